@@ -0,0 +1,361 @@
+package main
+
+import (
+	"crypto/tls"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/projectdiscovery/gologger"
+	"github.com/projectdiscovery/gologger/levels"
+	"github.com/projectdiscovery/interactsh/pkg/options"
+	"github.com/projectdiscovery/interactsh/pkg/server"
+	"github.com/projectdiscovery/interactsh/pkg/webhook"
+)
+
+// toggleableServices manages the optional services that can be
+// started and stopped at runtime when the configuration is reloaded.
+type toggleableServices struct {
+	sync.Mutex
+	options   *server.Options
+	tlsConfig *tls.Config
+
+	ftpAlive                chan bool
+	netbiosNameServiceAlive chan bool
+	netbiosDatagramAlive    chan bool
+	catchAllAlive           chan bool
+
+	ftpServer      *server.FTPServer
+	netbiosServer  *server.NetBIOSServer
+	catchAllServer *server.CatchAllServer
+	catchAllPorts  string
+}
+
+func newToggleableServices(serverOptions *server.Options, tlsConfig *tls.Config) *toggleableServices {
+	return &toggleableServices{
+		options:                 serverOptions,
+		tlsConfig:               tlsConfig,
+		ftpAlive:                make(chan bool),
+		netbiosNameServiceAlive: make(chan bool),
+		netbiosDatagramAlive:    make(chan bool),
+		catchAllAlive:           make(chan bool),
+	}
+}
+
+// Apply starts the enabled services that aren't running and stops the disabled ones.
+func (s *toggleableServices) Apply(cliOptions *options.CLIServerOptions) {
+	s.Lock()
+	defer s.Unlock()
+
+	if cliOptions.Ftp && s.ftpServer == nil {
+		ftpServer, err := server.NewFTPServer(s.options)
+		if err != nil {
+			gologger.Error().Msgf("Could not create FTP server: %s\n", err)
+		} else {
+			s.ftpServer = ftpServer
+			go ftpServer.ListenAndServe(s.tlsConfig, s.ftpAlive) //nolint
+		}
+	} else if !cliOptions.Ftp && s.ftpServer != nil {
+		gologger.Info().Msgf("Stopping FTP service\n")
+		s.ftpServer.Close()
+		s.ftpServer = nil
+		s.options.Health.Forget("FTP", "TCP")
+	}
+
+	if cliOptions.NetBIOS && s.netbiosServer == nil {
+		netbiosServer, err := server.NewNetBIOSServer(s.options)
+		if err != nil {
+			gologger.Error().Msgf("Could not create NetBIOS server: %s\n", err)
+		} else {
+			s.netbiosServer = netbiosServer
+			go netbiosServer.ListenAndServe(s.netbiosNameServiceAlive, s.netbiosDatagramAlive)
+		}
+	} else if !cliOptions.NetBIOS && s.netbiosServer != nil {
+		gologger.Info().Msgf("Stopping NetBIOS service\n")
+		s.netbiosServer.Close()
+		s.netbiosServer = nil
+		s.options.Health.Forget("NetBIOS-NS", "UDP")
+		s.options.Health.Forget("NetBIOS-DGM", "UDP")
+	}
+
+	catchAllPorts := strings.Join(cliOptions.CatchAllPorts, ",")
+	if catchAllPorts == s.catchAllPorts {
+		return
+	}
+	ports, err := options.ParsePorts(catchAllPorts)
+	if err != nil {
+		gologger.Error().Msgf("Could not parse catch-all ports: %s\n", err)
+		return
+	}
+	if s.catchAllServer != nil {
+		gologger.Info().Msgf("Stopping Catch-All service\n")
+		s.catchAllServer.Close()
+		s.catchAllServer = nil
+		s.options.Health.Forget("Catch-All", "TCP/UDP")
+	}
+	s.catchAllPorts = catchAllPorts
+	s.options.CatchAllPorts = ports
+	if len(ports) > 0 {
+		catchAllServer, err := server.NewCatchAllServer(s.options)
+		if err != nil {
+			gologger.Error().Msgf("Could not create catch-all server: %s\n", err)
+			return
+		}
+		s.catchAllServer = catchAllServer
+		go catchAllServer.ListenAndServe(s.catchAllAlive)
+	}
+}
+
+// CatchAllPorts returns the ports of the running catch-all service.
+func (s *toggleableServices) CatchAllPorts() string {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.catchAllPorts
+}
+
+// restartRequired returns the settings that are only applied at startup
+func restartRequired(o *options.CLIServerOptions) map[string]interface{} {
+	return map[string]interface{}{
+		"domain":                   &o.Domain,
+		"ip":                       &o.IPAddress,
+		"listen-ip":                &o.ListenIP,
+		"eviction":                 &o.Eviction,
+		"max-session-ttl":          &o.MaxSessionTTL,
+		"redis-url":                &o.RedisURL,
+		"db-url":                   &o.DatabaseURL,
+		"snapshot-file":            &o.SnapshotFile,
+		"snapshot-interval":        &o.SnapshotInterval,
+		"cluster-peers":            &o.ClusterPeers,
+		"cluster-secret":           &o.ClusterSecret,
+		"max-interactions":         &o.MaxInteractions,
+		"max-interactions-policy":  &o.InteractionPolicy,
+		"auth":                     &o.Auth,
+		"signed-requests":          &o.SignedRequests,
+		"oidc-issuer":              &o.OIDCIssuer,
+		"oidc-audience":            &o.OIDCAudience,
+		"oidc-claim":               &o.OIDCClaim,
+		"oidc-token-ttl":           &o.OIDCTokenTTL,
+		"wildcard":                 &o.RootTLD,
+		"wildcard-log":             &o.WildcardLog,
+		"skip-acme":                &o.SkipAcme,
+		"cert":                     &o.CertFile,
+		"key":                      &o.KeyFile,
+		"acme-ca":                  &o.ACMECA,
+		"acme-ca-root":             &o.ACMECARoot,
+		"client-ca":                &o.ClientCA,
+		"acme-eab-kid":             &o.ACMEEABKeyID,
+		"acme-eab-hmac":            &o.ACMEEABMACKey,
+		"acme-dir":                 &o.ACMEDirectory,
+		"self-signed":              &o.SelfSigned,
+		"tls-min-version":          &o.TLSMinVersion,
+		"tls-ciphers":              &o.TLSCipherSuites,
+		"tls-accept-all":           &o.TLSAcceptAll,
+		"dns-port":                 &o.DnsPort,
+		"http-port":                &o.HttpPort,
+		"https-port":               &o.HttpsPort,
+		"http-raw-bytes":           &o.HTTPRawBytes,
+		"http-max-body":            &o.HTTPMaxBody,
+		"no-tls-log":               &o.NoTLSLog,
+		"smtp-port":                &o.SmtpPort,
+		"smtps-port":               &o.SmtpsPort,
+		"smtp-autotls-port":        &o.SmtpAutoTLSPort,
+		"smtp-starttls":            &o.SmtpStartTLS,
+		"notify-mail-relay":        &o.NotifyMailRelay,
+		"notify-mail-user":         &o.NotifyMailUser,
+		"notify-mail-password":     &o.NotifyMailPassword,
+		"notify-mail-from":         &o.NotifyMailFrom,
+		"notify-mail-to":           &o.NotifyMailTo,
+		"notify-mail-rate":         &o.NotifyMailRate,
+		"ldap-port":                &o.LdapPort,
+		"ldap":                     &o.LdapWithFullLogger,
+		"smb":                      &o.Smb,
+		"responder":                &o.Responder,
+		"ftp-port":                 &o.FtpPort,
+		"ftp-dir":                  &o.FTPDirectory,
+		"catch-all-bytes":          &o.CatchAllBytes,
+		"proxy":                    &o.ProxyRoutes,
+		"no-http-responses":        &o.NoHTTPResponses,
+		"http-index":               &o.HTTPIndex,
+		"security-txt":             &o.SecurityTxt,
+		"robots-txt":               &o.RobotsTxt,
+		"hosted-file-size":         &o.HostedFileSize,
+		"hosted-file-ttl":          &o.HostedFileTTL,
+		"id-prefix":                &o.IDPrefixes,
+		"cid-length":               &o.IDLength,
+		"cid-nonce-length":         &o.IDNonceLength,
+		"cid-alphabet":             &o.IDAlphabet,
+		"max-sessions-per-ip":      &o.MaxSessionsPerIP,
+		"rate-limit":               &o.RateLimits,
+		"allow":                    &o.Allow,
+		"deny":                     &o.Deny,
+		"deny-interactions":        &o.DenyInteractions,
+		"acao-url":                 &o.OriginURL,
+		"resolver-stats":           &o.ResolverStats,
+		"dns-dedup":                &o.DNSDedup,
+		"dns-rebind":               &o.DNSRebind,
+		"admin-port":               &o.AdminPort,
+		"inject":                   &o.Inject,
+		"admin-token":              &o.AdminToken,
+		"revocation-file":          &o.RevocationFile,
+		"long-poll-wait":           &o.LongPollWait,
+		"otlp-endpoint":            &o.OTLPEndpoint,
+		"ingestion-lag-alert":      &o.IngestionLagAlert,
+		"delivery-lag-alert":       &o.DeliveryLagAlert,
+		"smtp-max-size":            &o.SmtpMaxSize,
+		"smtp-max-attachments":     &o.SmtpMaxAttachments,
+		"smtp-quarantine":          &o.SmtpQuarantine,
+		"smtp-store-attachments":   &o.SmtpStore,
+		"smtp-max-attachment-size": &o.SmtpMaxStoreSize,
+		"log-file":                 &o.LogFile,
+		"log-max-size":             &o.LogMaxSize,
+		"disk-log":                 &o.DiskLog,
+		"disk-log-blobs":           &o.DiskLogBlobs,
+		"audit-log":                &o.AuditLog,
+		"audit-log-retention":      &o.AuditLogRetention,
+		"pcap-dir":                 &o.PCAPDirectory,
+		"http-body-dir":            &o.HTTPBodyDirectory,
+		"geoip-db":                 &o.GeoIPDatabases,
+		"no-rdns":                  &o.NoReverseDNS,
+		"profile":                  &o.Profile,
+		"dns-ttl":                  &o.DNSTTL,
+		"ns-names":                 &o.NameServers,
+		"soa-serial":               &o.SOASerial,
+		"soa-mbox":                 &o.SOAMbox,
+		"soa-refresh":              &o.SOARefresh,
+		"soa-retry":                &o.SOARetry,
+		"soa-expire":               &o.SOAExpire,
+		"soa-min-ttl":              &o.SOAMinTTL,
+		"zone-file":                &o.ZoneFile,
+		"dnssec-keys":              &o.DNSSECKeys,
+		"dns-delegate":             &o.DNSDelegations,
+		"no-mail-records":          &o.NoMailRecords,
+		"dkim-key":                 &o.DKIMKey,
+		"dkim-selector":            &o.DKIMSelector,
+	}
+}
+
+// reloadConfig reloads the config file applying the settings that can be
+// changed at runtime, returning the options in effect after the reload.
+func reloadConfig(cliOptions *options.CLIServerOptions, serverOptions *server.Options, services *toggleableServices) *options.CLIServerOptions {
+	if cliOptions.Config == "" {
+		gologger.Info().Msgf("No config file specified, ignoring reload\n")
+		return cliOptions
+	}
+	reloaded, configFile, err := cliOptions.ReloadConfigFile()
+	if err != nil {
+		gologger.Error().Msgf("Could not reload config file: %s\n", err)
+		return cliOptions
+	}
+	if reloaded.Demo {
+		reloaded.ApplyDemoMode()
+	}
+	if reloaded.CatchAllBytes <= 0 {
+		gologger.Error().Msgf("Could not reload config file: invalid catch-all-bytes %d, must be greater than 0\n", reloaded.CatchAllBytes)
+		return cliOptions
+	}
+
+	webhooks, err := createWebhooks(reloaded.WebhookConfig, configFile)
+	if err != nil {
+		gologger.Error().Msgf("Could not reload webhooks: %s\n", err)
+		return cliOptions
+	}
+	tenants, err := createTenants(reloaded.TokenConfig, configFile)
+	if err != nil {
+		gologger.Error().Msgf("Could not reload token config: %s\n", err)
+		return cliOptions
+	}
+
+	current := restartRequired(cliOptions)
+	for name, field := range restartRequired(reloaded) {
+		value, currentValue := reflect.ValueOf(field).Elem(), reflect.ValueOf(current[name]).Elem()
+		if !reflect.DeepEqual(value.Interface(), currentValue.Interface()) {
+			gologger.Info().Msgf("Changing %s requires a restart, ignoring\n", name)
+			value.Set(currentValue)
+		}
+	}
+
+	if reloaded.Debug {
+		gologger.DefaultLogger.SetMaxLevel(levels.LevelDebug)
+	} else {
+		gologger.DefaultLogger.SetMaxLevel(levels.LevelInfo)
+	}
+
+	if reloaded.Token != "" && reloaded.Token != serverOptions.GetToken() {
+		if !serverOptions.Auth {
+			gologger.Info().Msgf("Changing token requires a restart when authentication is disabled, ignoring\n")
+			reloaded.Token = cliOptions.Token
+		} else if err := serverOptions.SetToken(reloaded.Token, 0); err != nil {
+			gologger.Error().Msgf("Could not update token: %s\n", err)
+			reloaded.Token = cliOptions.Token
+		} else {
+			gologger.Info().Msgf("Client Token: %s\n", reloaded.Token)
+		}
+	}
+	// the replaced webhooks deliver their queued interactions in the background
+	for _, webhook := range serverOptions.SetWebhooks(webhooks) {
+		go webhook.Close()
+	}
+	if serverOptions.Tokens == nil {
+		if len(tenants) > 0 {
+			gologger.Info().Msgf("Enabling the tokens of the tenants requires a restart when authentication is disabled, ignoring\n")
+			reloaded.TokenConfig = cliOptions.TokenConfig
+		}
+	} else if err := serverOptions.Tokens.SetTenants(tenants); err != nil {
+		gologger.Error().Msgf("Could not update the tokens of the tenants: %s\n", err)
+		reloaded.TokenConfig = cliOptions.TokenConfig
+	}
+
+	// the optional services store their interactions for the token
+	if !serverOptions.Auth && (reloaded.Ftp || reloaded.NetBIOS || len(reloaded.CatchAllPorts) > 0) {
+		gologger.Info().Msgf("Enabling ftp, netbios or catch-all-ports requires a restart when authentication is disabled, ignoring\n")
+		reloaded.Ftp, reloaded.NetBIOS, reloaded.CatchAllPorts = cliOptions.Ftp, cliOptions.NetBIOS, cliOptions.CatchAllPorts
+	}
+	// responder already binds the netbios ports
+	if reloaded.Responder && reloaded.NetBIOS {
+		gologger.Info().Msgf("responder and netbios can't be active at the same time, ignoring\n")
+		reloaded.NetBIOS = cliOptions.NetBIOS
+	}
+	services.Apply(reloaded)
+
+	gologger.Info().Msgf("Reloaded config file %s\n", cliOptions.Config)
+	return reloaded
+}
+
+// createTenants reads the tenants of the token config and the config file.
+func createTenants(tokenConfig string, configFile *options.ServerConfigFile) ([]*server.Tenant, error) {
+	var tenants []*server.Tenant
+	if tokenConfig != "" {
+		parsed, err := server.ParseTokenConfig(tokenConfig)
+		if err != nil {
+			return nil, err
+		}
+		tenants = parsed
+	}
+	if configFile != nil {
+		tenants = append(tenants, configFile.Tokens...)
+	}
+	return tenants, nil
+}
+
+// createWebhooks creates the webhooks of the webhook config and the config file.
+func createWebhooks(webhookConfig string, configFile *options.ServerConfigFile) ([]*webhook.Webhook, error) {
+	var webhooks []*webhook.Webhook
+	if webhookConfig != "" {
+		parsed, err := webhook.ParseConfig(webhookConfig)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = parsed
+	}
+	if configFile != nil {
+		for _, webhookOptions := range configFile.Webhooks {
+			webhook, err := webhook.New(webhookOptions)
+			if err != nil {
+				return nil, err
+			}
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks, nil
+}
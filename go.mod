@@ -5,15 +5,21 @@ go 1.15
 require (
 	git.mills.io/prologic/smtpd v0.0.0-20210710122116-a525b76c287a
 	github.com/Mzack9999/ldapserver v1.0.2-0.20211229000134-b44a0d6ad0dd
+	github.com/alicebob/miniredis/v2 v2.14.3
 	github.com/caddyserver/certmagic v0.15.2
+	github.com/go-redis/redis/v8 v8.11.4
 	github.com/goburrow/cache v0.1.4
 	github.com/google/uuid v1.3.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/json-iterator/go v1.1.12
 	github.com/karlseguin/ccache/v2 v2.0.8
 	github.com/klauspost/compress v1.14.1
 	github.com/kr/pretty v0.3.0 // indirect
+	github.com/lib/pq v1.10.4
 	github.com/libdns/libdns v0.2.1
+	github.com/mholt/acmez v1.0.1
 	github.com/miekg/dns v1.1.45
+	github.com/nats-io/nats.go v1.13.0
 	github.com/pkg/errors v0.9.1
 	github.com/projectdiscovery/fileutil v0.0.0-20210804142714-ebba15fa53ca
 	github.com/projectdiscovery/goflags v0.0.7
@@ -24,11 +30,18 @@ require (
 	github.com/remeh/sizedwaitgroup v1.0.0
 	github.com/rogpeppe/go-internal v1.8.0 // indirect
 	github.com/rs/xid v1.3.0
+	github.com/segmentio/kafka-go v0.4.28
 	github.com/stretchr/testify v1.7.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
 	go.uber.org/zap v1.20.0
 	goftp.io/server/v2 v2.0.0
-	golang.org/x/net v0.0.0-20210825183410-e898025ed96a // indirect
+	golang.org/x/net v0.0.0-20210825183410-e898025ed96a
 	golang.org/x/sys v0.0.0-20211210111614-af8b64212486 // indirect
 	golang.org/x/text v0.3.7 // indirect
 	gopkg.in/corvus-ch/zbase32.v1 v1.0.0
+	gopkg.in/yaml.v2 v2.4.0
+	modernc.org/sqlite v1.14.3
 )
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"time"
+)
+
+// defaultEvictionWarning is the time before the session expiry
+// EventEvictionApproaching is reported at by default
+const defaultEvictionWarning = time.Hour
+
+// EventType is the type of a session lifecycle event
+type EventType int
+
+const (
+	// EventRegistered is reported once the session has been registered to the server
+	EventRegistered EventType = iota
+	// EventEvictionApproaching is reported when the session is about to be evicted by the server
+	EventEvictionApproaching
+	// EventSessionExpired is reported when the session has been evicted by the server after its expiry
+	EventSessionExpired
+	// EventServerRestarted is reported when the session has been lost before its expiry,
+	// usually because the server has been restarted
+	EventServerRestarted
+	// EventFailover is reported when the session has been registered again to another
+	// server (or the same one) after the polls repeatedly failed, changing the payloads
+	EventFailover
+)
+
+// String returns the name of the event type
+func (t EventType) String() string {
+	switch t {
+	case EventRegistered:
+		return "registered"
+	case EventEvictionApproaching:
+		return "eviction-approaching"
+	case EventSessionExpired:
+		return "session-expired"
+	case EventServerRestarted:
+		return "server-restarted"
+	case EventFailover:
+		return "failover"
+	}
+	return "unknown"
+}
+
+// Event is a session lifecycle event
+type Event struct {
+	// Type is the type of the event
+	Type EventType
+	// Server is the url of the server of the session
+	Server string
+	// ExpiresAt is the time the session is evicted at, if reported by the server
+	ExpiresAt time.Time
+	// Timestamp is the timestamp of the event
+	Timestamp time.Time
+}
+
+// EventCallback is a callback function for a session lifecycle event
+type EventCallback func(*Event)
+
+// sendEvent reports a lifecycle event to the event callback, if any
+func (c *Client) sendEvent(eventType EventType) {
+	if c.eventCallback == nil {
+		return
+	}
+	event := &Event{Type: eventType, ExpiresAt: c.expiresAt, Timestamp: time.Now()}
+	if c.serverURL != nil {
+		event.Server = c.serverURL.String()
+	}
+	c.eventCallback(event)
+}
+
+// updateExpiry records the expiry of the session reported by the server,
+// reporting EventEvictionApproaching once when it's close.
+func (c *Client) updateExpiry(expiresAt *time.Time) {
+	if expiresAt == nil {
+		return
+	}
+	c.expiresAt = *expiresAt
+	if !c.evictionWarned && time.Until(c.expiresAt) <= c.evictionWarning {
+		c.evictionWarned = true
+		c.sendEvent(EventEvictionApproaching)
+	}
+}
+
+// sessionLost reports the loss of the session, once, as expired if it
+// was lost after its expiry or as a server restart otherwise.
+func (c *Client) sessionLost() {
+	if c.lost {
+		return
+	}
+	c.lost = true
+	if !c.expiresAt.IsZero() && !time.Now().Before(c.expiresAt) {
+		c.sendEvent(EventSessionExpired)
+	} else {
+		c.sendEvent(EventServerRestarted)
+	}
+}
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientEvents(t *testing.T) {
+	var events []EventType
+	c := &Client{evictionWarning: time.Hour, eventCallback: func(event *Event) {
+		events = append(events, event.Type)
+	}}
+
+	expiresAt := time.Now().Add(2 * time.Hour)
+	c.updateExpiry(&expiresAt)
+	require.Empty(t, events, "reported eviction before the warning")
+
+	expiresAt = time.Now().Add(30 * time.Minute)
+	c.updateExpiry(&expiresAt)
+	c.updateExpiry(&expiresAt)
+	require.Equal(t, []EventType{EventEvictionApproaching}, events, "could not report eviction once")
+
+	c.sessionLost()
+	c.sessionLost()
+	require.Equal(t, EventServerRestarted, events[1], "could not report session lost before expiry")
+	require.Len(t, events, 2, "reported session lost more than once")
+
+	c = &Client{expiresAt: time.Now().Add(-time.Minute), eventCallback: func(event *Event) {
+		events = append(events, event.Type)
+	}}
+	c.sessionLost()
+	require.Equal(t, EventSessionExpired, events[2], "could not report expired session")
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// Obfuscations are the transformations supported by Obfuscate
+var Obfuscations = []string{"url", "double-url", "case", "credentials", "decimal-ip", "ipv6"}
+
+// Obfuscate returns filter-evading variants of a payload (which can include
+// tags prepended to the unique id) for the obfuscation.
+//
+// The decimal-ip and ipv6 obfuscations use the ip literals of the
+// server with the payload in the url path.
+func (c *Client) Obfuscate(payload, obfuscation string) ([]string, error) {
+	var serverIPs []net.IP
+	if obfuscation == "decimal-ip" || obfuscation == "ipv6" {
+		var err error
+		if serverIPs, err = c.serverIPs(); err != nil {
+			return nil, errors.Wrap(err, "could not resolve server ip")
+		}
+	}
+	return obfuscate(payload, obfuscation, serverIPs)
+}
+
+func obfuscate(payload, obfuscation string, serverIPs []net.IP) ([]string, error) {
+	switch obfuscation {
+	case "url":
+		return []string{"http://" + percentEncode(payload, "%") + "/"}, nil
+	case "double-url":
+		return []string{"http://" + percentEncode(payload, "%25") + "/"}, nil
+	case "case":
+		return []string{mixCase(payload)}, nil
+	case "credentials":
+		return []string{"http://localhost@" + payload + "/"}, nil
+	case "decimal-ip", "ipv6":
+		if len(serverIPs) == 0 {
+			return nil, errors.New("no server ip available")
+		}
+		var payloads []string
+		for _, ip := range serverIPs {
+			if literal := ipLiteral(ip, obfuscation); literal != "" {
+				payloads = append(payloads, "http://"+literal+"/"+payload)
+			}
+		}
+		return payloads, nil
+	}
+	return nil, fmt.Errorf("unknown obfuscation %s", obfuscation)
+}
+
+// percentEncode percent encodes every byte of the value with the prefix
+func percentEncode(value, prefix string) string {
+	builder := &strings.Builder{}
+	for i := 0; i < len(value); i++ {
+		if value[i] == '.' {
+			builder.WriteByte('.')
+			continue
+		}
+		builder.WriteString(fmt.Sprintf("%s%02X", prefix, value[i]))
+	}
+	return builder.String()
+}
+
+// mixCase randomizes the case of the letters of the value,
+// the interactsh server correlates the payload case-insensitively.
+func mixCase(value string) string {
+	runes := []rune(value)
+	for i, r := range runes {
+		if rand.Intn(2) == 0 {
+			runes[i] = unicode.ToUpper(r)
+		} else {
+			runes[i] = unicode.ToLower(r)
+		}
+	}
+	return string(runes)
+}
+
+// ipLiteral returns the decimal or ipv6 literal of an ip
+func ipLiteral(ip net.IP, form string) string {
+	ipv4 := ip.To4()
+	switch form {
+	case "decimal-ip":
+		if ipv4 == nil {
+			return ""
+		}
+		return fmt.Sprintf("%d", binary.BigEndian.Uint32(ipv4))
+	case "ipv6":
+		if ipv4 != nil {
+			return "[::ffff:" + ipv4.String() + "]"
+		}
+		return "[" + ip.String() + "]"
+	}
+	return ""
+}
+
+// serverIPs returns the ip addresses of the interactsh server
+func (c *Client) serverIPs() ([]net.IP, error) {
+	c.serverIPsOnce.Do(func() {
+		host := c.serverURL.Hostname()
+		if ip := net.ParseIP(host); ip != nil {
+			c.serverIPAddresses = []net.IP{ip}
+			return
+		}
+		c.serverIPAddresses, c.serverIPsErr = net.LookupIP(host)
+	})
+	return c.serverIPAddresses, c.serverIPsErr
+}
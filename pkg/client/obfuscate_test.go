@@ -0,0 +1,32 @@
+package client
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestObfuscate(t *testing.T) {
+	payload := "tag.c23b2la0kl1krjcrdj10cndmnioyyyyyn.oast.fun"
+	ips := []net.IP{net.ParseIP("127.0.0.1")}
+
+	for obfuscation, expected := range map[string]string{
+		"url":         "http://%74%61%67.%63%32%33%62%32%6C%61%30%6B%6C%31%6B%72%6A%63%72%64%6A%31%30%63%6E%64%6D%6E%69%6F%79%79%79%79%79%6E.%6F%61%73%74.%66%75%6E/",
+		"credentials": "http://localhost@" + payload + "/",
+		"decimal-ip":  "http://2130706433/" + payload,
+		"ipv6":        "http://[::ffff:127.0.0.1]/" + payload,
+	} {
+		payloads, err := obfuscate(payload, obfuscation, ips)
+		require.Nil(t, err, "could not obfuscate payload")
+		require.Equal(t, []string{expected}, payloads, "could not get correct %s payload", obfuscation)
+	}
+
+	payloads, err := obfuscate(payload, "case", ips)
+	require.Nil(t, err, "could not obfuscate payload")
+	require.Equal(t, payload, strings.ToLower(payloads[0]), "could not get correct case payload")
+
+	_, err = obfuscate(payload, "unknown", ips)
+	require.NotNil(t, err, "could obfuscate with unknown obfuscation")
+}
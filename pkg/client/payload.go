@@ -0,0 +1,153 @@
+package client
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/interactsh/pkg/server"
+	"gopkg.in/corvus-ch/zbase32.v1"
+)
+
+const (
+	// correlationIDLength is the length of the correlation id prefix of the unique ids
+	correlationIDLength = 20
+	// uniqueIDLength is the length of the unique ids of the payloads of the xid scheme
+	uniqueIDLength = 33
+	// maxNonceLength is the maximum length of the encoded nonce of a unique id of any scheme
+	maxNonceLength = 16
+	// maxTagLength is the maximum number of bytes of a binary tag fitting a dns label
+	maxTagLength = 39
+	// maxCounter is the maximum value of the counter of a payload generator (48 bits)
+	maxCounter = 1<<48 - 1
+)
+
+// generatorCounter is used to assign a different id to every payload generator
+var generatorCounter = uint32(0)
+
+// PayloadGenerator generates payload urls embedding the id of the generator
+// and a monotonic counter, which can be retrieved from the interactions with
+// ParsePayload. A generator isn't safe for concurrent use, each goroutine
+// should use its own generator to avoid any synchronization.
+//
+// Payloads are unique across the 65536 first generators of the client.
+type PayloadGenerator struct {
+	id       uint16
+	counter  uint64
+	idScheme string
+	idFormat server.IDFormat
+	prefix   []byte
+	suffix   []byte
+	buffer   []byte
+}
+
+// NewPayloadGenerator returns a new payload generator for the session of the client.
+func (c *Client) NewPayloadGenerator() *PayloadGenerator {
+	generator := &PayloadGenerator{
+		id:       uint16(atomic.AddUint32(&generatorCounter, 1)),
+		idScheme: c.idScheme,
+		idFormat: c.idFormat,
+		prefix:   []byte(c.correlationID),
+		suffix:   []byte("." + c.serverURL.Host),
+	}
+	generator.buffer = make([]byte, 0, maxTagLength*2+len(generator.prefix)+maxNonceLength+len(generator.suffix))
+	return generator
+}
+
+// URL returns the next payload url of the generator.
+func (g *PayloadGenerator) URL() string {
+	return string(g.appendUniqueID(g.buffer[:0]))
+}
+
+// URLWithTag returns the next payload url of the generator with a binary tag
+// (eg. the index of a fuzzed parameter) encoded as a label before the unique
+// id, which can be decoded from the full id of the interactions with DecodeTag.
+func (g *PayloadGenerator) URLWithTag(tag []byte) (string, error) {
+	if len(tag) == 0 || len(tag) > maxTagLength {
+		return "", errors.Errorf("tag must be between 1 and %d bytes", maxTagLength)
+	}
+	buffer := g.buffer[:zbase32.StdEncoding.EncodedLen(len(tag))]
+	buffer = buffer[:zbase32.StdEncoding.Encode(buffer, tag)]
+	buffer = append(buffer, '.')
+	return string(g.appendUniqueID(buffer)), nil
+}
+
+// appendUniqueID appends the next unique id and the server host to the buffer
+func (g *PayloadGenerator) appendUniqueID(buffer []byte) []byte {
+	g.counter = (g.counter + 1) & maxCounter
+
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], g.counter)
+	binary.BigEndian.PutUint16(data[:2], g.id)
+
+	buffer = append(buffer, g.prefix...)
+	buffer = appendNonce(buffer, g.idScheme, g.idFormat, data[:])
+	return append(buffer, g.suffix...)
+}
+
+// appendNonce appends the nonce of a unique id encoded for the id scheme to
+// the buffer, which must have the capacity for maxNonceLength more bytes.
+// The 8 bytes nonces of the random scheme are encoded with the alphabet of
+// the id format, keeping the least significant digits fitting its length.
+func appendNonce(buffer []byte, idScheme string, idFormat server.IDFormat, nonce []byte) []byte {
+	start := len(buffer)
+	switch idScheme {
+	case server.IDSchemeRandom:
+		value, base := binary.BigEndian.Uint64(nonce), uint64(len(idFormat.Alphabet))
+		for i := 0; i < idFormat.NonceLength; i++ {
+			buffer = append(buffer, 0)
+		}
+		for i := len(buffer) - 1; i >= start; i-- {
+			buffer[i] = idFormat.Alphabet[value%base]
+			value /= base
+		}
+		return buffer
+	case server.IDSchemeUUID:
+		buffer = buffer[:start+hex.EncodedLen(len(nonce))]
+		hex.Encode(buffer[start:], nonce)
+		return buffer
+	case server.IDSchemeWords:
+		buffer = append(buffer, '-')
+		start++
+	}
+	buffer = buffer[:start+zbase32.StdEncoding.EncodedLen(len(nonce))]
+	return buffer[:start+zbase32.StdEncoding.Encode(buffer[start:], nonce)]
+}
+
+// ParsePayload returns the generator id and the counter of the unique id of
+// a payload generated by a PayloadGenerator. The nonces of the random id
+// scheme, truncated to the length of the id format, can't be parsed.
+func ParsePayload(uniqueID string) (uint16, uint64, error) {
+	uniqueID = strings.ToLower(uniqueID)
+
+	var data []byte
+	var err error
+	switch _, scheme := server.ParseUniqueID(uniqueID); scheme {
+	case "":
+		return 0, 0, errors.New("invalid unique id")
+	case server.IDSchemeUUID:
+		data, err = hex.DecodeString(uniqueID[len(uniqueID)-maxNonceLength:])
+	default:
+		data, err = zbase32.StdEncoding.DecodeString(uniqueID[len(uniqueID)-(uniqueIDLength-correlationIDLength):])
+	}
+	if err != nil || len(data) < 8 {
+		return 0, 0, errors.New("invalid unique id")
+	}
+	return binary.BigEndian.Uint16(data[:2]), binary.BigEndian.Uint64(data[:8]) & maxCounter, nil
+}
+
+// DecodeTag returns the binary tag of the full id (eg. tag.uniqueid) of an
+// interaction with a payload generated by PayloadGenerator.URLWithTag.
+func DecodeTag(fullID string) ([]byte, error) {
+	parts := strings.Split(fullID, ".")
+	if len(parts) < 2 {
+		return nil, errors.New("no tag found")
+	}
+	tag, err := zbase32.StdEncoding.DecodeString(strings.ToLower(parts[len(parts)-2]))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decode tag")
+	}
+	return tag, nil
+}
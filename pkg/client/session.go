@@ -0,0 +1,96 @@
+package client
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/interactsh/pkg/server"
+)
+
+// Session is the state of a registered session, saved to resume polling it
+// after a restart of the client or from another machine.
+type Session struct {
+	// ServerURL is the url of the server the session is registered to
+	ServerURL string `json:"server-url"`
+	// CorrelationID is the correlation id of the payloads of the session
+	CorrelationID string `json:"correlation-id"`
+	// SecretKey is the secret authenticating the polls of the session
+	SecretKey string `json:"secret-key"`
+	// PrivateKey is the pem encoded rsa private key decrypting the interactions
+	PrivateKey string `json:"private-key"`
+	// IDScheme is the correlation id scheme of the session
+	IDScheme string `json:"id-scheme"`
+	// IDFormat is the format of the correlation id of the random id scheme
+	IDFormat server.IDFormat `json:"id-format"`
+}
+
+// Session returns the state of the session of the client.
+func (c *Client) Session() *Session {
+	return &Session{
+		ServerURL:     c.serverURL.String(),
+		CorrelationID: c.correlationID,
+		SecretKey:     c.secretKey,
+		PrivateKey:    string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(c.privKey)})),
+		IDScheme:      c.idScheme,
+		IDFormat:      c.idFormat,
+	}
+}
+
+// SaveSession writes the state of the session of the client to a file,
+// readable by the current user only as it holds the keys of the session.
+func (c *Client) SaveSession(file string) error {
+	data, err := jsoniter.MarshalIndent(c.Session(), "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not marshal session")
+	}
+	// the session is written to a temporary file renamed over the previous
+	// one, so that an interrupted write doesn't lose the session
+	temporary, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return errors.Wrap(err, "could not create session file")
+	}
+	defer os.Remove(temporary.Name())
+	if _, err := temporary.Write(data); err != nil {
+		temporary.Close()
+		return errors.Wrap(err, "could not write session file")
+	}
+	if err := temporary.Close(); err != nil {
+		return errors.Wrap(err, "could not write session file")
+	}
+	return errors.Wrap(os.Rename(temporary.Name(), file), "could not write session file")
+}
+
+// LoadSession reads the state of a session saved with SaveSession.
+func LoadSession(file string) (*Session, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read session file")
+	}
+	session := &Session{}
+	if err := jsoniter.Unmarshal(data, session); err != nil {
+		return nil, errors.Wrap(err, "could not decode session file")
+	}
+	if session.ServerURL == "" || session.CorrelationID == "" || session.SecretKey == "" {
+		return nil, errors.New("incomplete session file")
+	}
+	return session, nil
+}
+
+// privateKey parses the private key of the session
+func (s *Session) privateKey() (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(s.PrivateKey))
+	if block == nil {
+		return nil, errors.New("could not decode session private key")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse session private key")
+	}
+	return key, nil
+}
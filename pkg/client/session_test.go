@@ -0,0 +1,48 @@
+package client
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/projectdiscovery/interactsh/pkg/server"
+	"github.com/projectdiscovery/retryablehttp-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientSession(t *testing.T) {
+	var registrations int
+	testServer := testServer(t, http.StatusNotFound, &registrations)
+
+	opts := retryablehttp.DefaultOptionsSingle
+	opts.RetryMax = 0
+	c := &Client{correlationID: "c23b2la0kl1krjcrdj10", secretKey: "secret", idScheme: server.IDSchemeXID, httpClient: retryablehttp.NewClient(opts)}
+	payload, err := c.initializeRSAKeys()
+	require.Nil(t, err, "could not initialize rsa keys")
+	require.Nil(t, c.parseServerURLs(testServer.URL, payload), "could not register")
+
+	file := filepath.Join(t.TempDir(), "session.json")
+	require.Nil(t, c.SaveSession(file), "could not save session")
+	info, err := os.Stat(file)
+	require.Nil(t, err, "could not stat session file")
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm(), "could not restrict session file")
+
+	session, err := LoadSession(file)
+	require.Nil(t, err, "could not load session")
+	require.Equal(t, c.Session(), session, "could not load saved session")
+
+	resumed := &Client{httpClient: retryablehttp.NewClient(opts)}
+	require.Nil(t, resumed.resume(session, "other.example.com,"+testServer.URL), "could not resume session")
+	require.Equal(t, c.privKey, resumed.privKey, "could not resume private key")
+	require.Equal(t, []string{testServer.URL, "other.example.com"}, resumed.serverURLs, "could not keep the servers for the failovers")
+	require.Equal(t, "c23b2la0kl1krjcrdj10", resumed.correlationID, "could not resume correlation id")
+
+	registrations = 0
+	require.Nil(t, resumed.getInteractions(func(*server.Interaction) {}), "could not register evicted session again")
+	require.Equal(t, 1, registrations, "could not register evicted session again")
+	require.Error(t, resumed.getInteractions(func(*server.Interaction) {}), "registered lost session again")
+
+	_, err = LoadSession(filepath.Join(t.TempDir(), "missing.json"))
+	require.Error(t, err, "loaded missing session")
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+	"github.com/projectdiscovery/interactsh/pkg/server"
+	"github.com/projectdiscovery/retryablehttp-go"
+)
+
+// streamReadTimeout is the time the stream waits for a message or a ping of
+// the server before reconnecting, the server pinging it every 30 seconds
+const streamReadTimeout = 70 * time.Second
+
+// errStreamUnsupported is returned for the servers answering the stream
+// requests without upgrading them
+var errStreamUnsupported = errors.New("server doesn't support streaming")
+
+// newStreamDialer returns the websocket dialer of the streams, with the
+// proxy and the tls configuration of the http client
+func newStreamDialer(httpClient *retryablehttp.Client, timeout time.Duration) *websocket.Dialer {
+	dialer := &websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: timeout}
+	if transport, ok := httpClient.HTTPClient.Transport.(*http.Transport); ok {
+		dialer.Proxy = transport.Proxy
+		if transport.TLSClientConfig != nil {
+			dialer.TLSClientConfig = transport.TLSClientConfig.Clone()
+		}
+	}
+	return dialer
+}
+
+// stream receives the interactions over the websocket stream of the server,
+// connecting again once it's closed (after duration if it failed) and falling
+// back to the polls if the server doesn't support it.
+func (c *Client) stream(duration time.Duration, callback InteractionCallback) {
+	for {
+		connected, err := c.streamInteractions(callback)
+		if err == errStreamUnsupported {
+			gologger.Info().Msgf("Server %s doesn't support streaming, polling the interactions instead\n", c.serverURL)
+			c.poll(duration, callback)
+			return
+		}
+		if err != nil && err.Error() == authError.Error() {
+			gologger.Fatal().Msgf("Could not authenticate to the server")
+		}
+		var wait time.Duration
+		if connected {
+			c.pollCompleted(nil)
+		} else {
+			c.pollCompleted(err)
+			wait = duration
+		}
+		select {
+		case <-time.After(wait):
+		case <-c.quitChan:
+			return
+		}
+	}
+}
+
+// streamInteractions connects to the stream of the session and delivers its
+// interactions until the connection is closed, returning true if it connected.
+func (c *Client) streamInteractions(callback InteractionCallback) (bool, error) {
+	streamURL := *c.serverURL
+	streamURL.Scheme = "ws"
+	if c.serverURL.Scheme == "https" {
+		streamURL.Scheme = "wss"
+	}
+	streamURL.Path += "/stream"
+	streamURL.RawQuery = url.Values{"id": {c.correlationID}, "secret": {c.secretKey}}.Encode()
+
+	req, err := retryablehttp.NewRequest("GET", streamURL.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	if err := c.authorize(req, nil); err != nil {
+		return false, err
+	}
+	conn, resp, err := c.streamDialer.Dial(streamURL.String(), req.Header)
+	if resp != nil && resp.Body != nil {
+		resp.Body.Close()
+	}
+	if err == websocket.ErrBadHandshake && resp != nil {
+		if resp.StatusCode == http.StatusUnauthorized {
+			return false, authError
+		}
+		// the poll handles the evicted sessions like for the polling clients,
+		// the servers polled successfully not supporting the streams
+		if err := c.getInteractions(callback); err != nil {
+			return false, err
+		}
+		return false, errStreamUnsupported
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "could not connect to stream")
+	}
+	defer conn.Close()
+	c.resumed = false
+
+	// the connection is closed on quit to interrupt the read
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-c.quitChan:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	_ = conn.SetReadDeadline(time.Now().Add(streamReadTimeout))
+	conn.SetPingHandler(func(data string) error {
+		_ = conn.SetReadDeadline(time.Now().Add(streamReadTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	gologger.Debug().Msgf("Streaming interactions from %s\n", c.serverURL)
+	for {
+		_, data, err := conn.ReadMessage()
+		if websocket.IsCloseError(err, server.CloseSessionNotFound) {
+			c.sessionLost()
+			return false, errors.Wrap(err, "could not stream interactions")
+		}
+		if err != nil {
+			return true, errors.Wrap(err, "could not stream interactions")
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(streamReadTimeout))
+
+		response := &server.PollResponse{}
+		if err := jsoniter.Unmarshal(data, response); err != nil {
+			gologger.Error().Msgf("Could not decode interactions: %v\n", err)
+			continue
+		}
+		c.deliver(response, callback)
+	}
+}
@@ -0,0 +1,262 @@
+// Package elastic streams the interactions received by the interactsh
+// client to an Elasticsearch or OpenSearch index with the bulk api.
+package elastic
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+	"github.com/projectdiscovery/interactsh/pkg/server"
+)
+
+const (
+	// defaultBatchSize is the number of documents indexed per bulk request
+	defaultBatchSize = 500
+	// defaultFlushInterval is the maximum time a document waits before being indexed
+	defaultFlushInterval = time.Second
+	// maxPending is the number of documents kept while the cluster is unreachable,
+	// the following ones being dropped
+	maxPending = 10000
+	// retries is the number of retries of the failed bulk requests
+	retries = 3
+	// retryWait is the time waited before the first retry, doubled for each following one
+	retryWait = time.Second
+)
+
+// Options contains configuration options for the exporter.
+type Options struct {
+	// URL is the url of the cluster (eg. https://elastic.internal:9200)
+	URL string
+	// Index is the index the interactions are written to
+	Index string
+	// Username and Password authenticate with basic auth, if set
+	Username string
+	Password string
+	// APIKey authenticates with an api key (the base64 encoded id:key), if set
+	APIKey string
+	// BatchSize is the number of documents indexed per bulk request (500 by default)
+	BatchSize int
+	// FlushInterval is the maximum time a document waits before being indexed (1 second by default)
+	FlushInterval time.Duration
+}
+
+// Exporter buffers the interactions and indexes them in batches in the
+// background, retrying the bulk requests failing with a network error,
+// a 429 or a 5xx status code.
+type Exporter struct {
+	options    *Options
+	httpClient *http.Client
+
+	sync.Mutex
+	pending [][]byte
+	dropped int
+
+	flush     chan struct{}
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns an exporter to the index of a cluster.
+func New(options *Options) (*Exporter, error) {
+	if options.URL == "" {
+		return nil, errors.New("no elasticsearch url specified")
+	}
+	if options.Index == "" {
+		return nil, errors.New("no elasticsearch index specified")
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultBatchSize
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = defaultFlushInterval
+	}
+	options.URL = strings.TrimSuffix(options.URL, "/")
+	exporter := &Exporter{
+		options:    options,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		flush:      make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	go exporter.loop()
+	return exporter, nil
+}
+
+// Export queues an interaction for indexing, with its timestamp as the
+// @timestamp of the document.
+func (e *Exporter) Export(interaction *server.Interaction) error {
+	data, err := jsoniter.Marshal(interaction)
+	if err != nil {
+		return errors.Wrap(err, "could not encode interaction")
+	}
+	timestamp, _ := jsoniter.Marshal(interaction.Timestamp)
+	document := make([]byte, 0, len(data)+len(timestamp)+16)
+	document = append(document, `{"@timestamp":`...)
+	document = append(document, timestamp...)
+	document = append(document, ',')
+	document = append(document, data[1:]...)
+
+	e.Lock()
+	if len(e.pending) >= maxPending {
+		e.dropped++
+		e.Unlock()
+		return errors.New("elasticsearch queue is full")
+	}
+	e.pending = append(e.pending, document)
+	full := len(e.pending) >= e.options.BatchSize
+	e.Unlock()
+
+	if full {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Close indexes the queued interactions and stops the exporter.
+func (e *Exporter) Close() {
+	e.closeOnce.Do(func() { close(e.done) })
+	<-e.stopped
+}
+
+// loop indexes the queued documents each flush interval or once a batch is full
+func (e *Exporter) loop() {
+	defer close(e.stopped)
+
+	ticker := time.NewTicker(e.options.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			e.index()
+			return
+		case <-ticker.C:
+			e.index()
+		case <-e.flush:
+			e.index()
+		}
+	}
+}
+
+// index indexes the queued documents in batches
+func (e *Exporter) index() {
+	for {
+		e.Lock()
+		if e.dropped > 0 {
+			gologger.Error().Msgf("Dropped %d interactions while elasticsearch was unreachable\n", e.dropped)
+			e.dropped = 0
+		}
+		count := len(e.pending)
+		if count > e.options.BatchSize {
+			count = e.options.BatchSize
+		}
+		batch := e.pending[:count]
+		e.pending = e.pending[count:]
+		e.Unlock()
+
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(e.body(batch)); err != nil {
+			gologger.Warning().Msgf("Could not index %d interactions to elasticsearch: %s\n", len(batch), err)
+		}
+	}
+}
+
+// body returns the bulk request body of a batch of documents
+func (e *Exporter) body(batch [][]byte) []byte {
+	action, _ := jsoniter.Marshal(map[string]map[string]string{"index": {"_index": e.options.Index}})
+	buffer := &bytes.Buffer{}
+	for _, document := range batch {
+		buffer.Write(action)
+		buffer.WriteByte('\n')
+		buffer.Write(document)
+		buffer.WriteByte('\n')
+	}
+	return buffer.Bytes()
+}
+
+// send posts a bulk request, retrying the retryable failures with an
+// exponential backoff
+func (e *Exporter) send(body []byte) error {
+	wait := retryWait
+	for attempt := 0; ; attempt++ {
+		retry, err := e.post(body)
+		if err == nil || !retry || attempt >= retries {
+			return err
+		}
+		gologger.Debug().Msgf("Could not index interactions to elasticsearch: %s, retrying in %s\n", err, wait)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// bulkResponse is the response of a bulk request
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// post makes a bulk request, returning true for the errors worth a retry
+func (e *Exporter) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, e.options.URL+"/_bulk", bytes.NewReader(body))
+	if err != nil {
+		return false, errors.Wrap(err, "could not create request")
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if e.options.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+e.options.APIKey)
+	} else if e.options.Username != "" {
+		req.SetBasicAuth(e.options.Username, e.options.Password)
+	}
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return true, errors.Wrap(err, "could not make request")
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	response := &bulkResponse{}
+	if err := jsoniter.NewDecoder(resp.Body).Decode(response); err != nil {
+		return false, errors.Wrap(err, "could not decode bulk response")
+	}
+	if !response.Errors {
+		return false, nil
+	}
+	failed := 0
+	var reason string
+	for _, item := range response.Items {
+		for _, result := range item {
+			if result.Status >= 300 {
+				failed++
+				if reason == "" {
+					reason = result.Error.Type + ": " + result.Error.Reason
+				}
+			}
+		}
+	}
+	return false, fmt.Errorf("%d documents rejected (%s)", failed, reason)
+}
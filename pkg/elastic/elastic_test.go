@@ -0,0 +1,71 @@
+package elastic
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/projectdiscovery/interactsh/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExporter(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []string
+	var lines []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.Header.Get("Authorization")+" "+r.Header.Get("Content-Type"))
+		if len(requests) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		_, _ = w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+	defer ts.Close()
+
+	exporter, err := New(&Options{URL: ts.URL + "/", Index: "interactsh", APIKey: "a2V5", BatchSize: 2, FlushInterval: time.Hour})
+	require.Nil(t, err, "could not create exporter")
+	timestamp := time.Date(2021, 9, 26, 13, 26, 10, 0, time.UTC)
+	for _, protocol := range []string{"dns", "http", "smtp"} {
+		require.Nil(t, exporter.Export(&server.Interaction{Protocol: protocol, UniqueID: "c23b2la0kl1krjcrdj10nkvoa2stvqcj", Timestamp: timestamp}), "could not export interaction")
+	}
+	exporter.Close()
+
+	require.Len(t, requests, 3, "could not retry and flush batches")
+	require.Equal(t, "POST /_bulk ApiKey a2V5 application/x-ndjson", requests[1], "could not authenticate bulk request")
+	require.Len(t, lines, 6, "could not index interactions")
+	require.Equal(t, `{"index":{"_index":"interactsh"}}`, lines[0], "could not set index action")
+	document := make(map[string]interface{})
+	require.Nil(t, jsoniter.Unmarshal([]byte(lines[5]), &document), "could not decode document")
+	require.Equal(t, "smtp", document["protocol"], "could not index interaction")
+	require.Equal(t, "2021-09-26T13:26:10Z", document["@timestamp"], "could not set document timestamp")
+}
+
+func TestExporterRejected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		require.Equal(t, "elastic:changeme", username+":"+password, "could not authenticate with basic auth")
+		_, _ = w.Write([]byte(`{"errors":true,"items":[{"index":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}]}`))
+	}))
+	defer ts.Close()
+
+	exporter, err := New(&Options{URL: ts.URL, Index: "interactsh", Username: "elastic", Password: "changeme"})
+	require.Nil(t, err, "could not create exporter")
+	defer exporter.Close()
+	retry, err := exporter.post(exporter.body([][]byte{[]byte(`{}`)}))
+	require.False(t, retry, "retried rejected documents")
+	require.EqualError(t, err, "1 documents rejected (mapper_parsing_exception: failed to parse)", "could not get rejection reason")
+}
@@ -0,0 +1,84 @@
+// Package eventlog writes the interactions and the events of the
+// interactsh server as JSON lines, to be ingested by a SIEM.
+package eventlog
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/projectdiscovery/gologger/formatter"
+)
+
+// Entry is a line of the event log
+type Entry struct {
+	// Timestamp is the time the entry has been logged
+	Timestamp time.Time `json:"timestamp"`
+	// Type is the type of the entry, either interaction or event
+	Type string `json:"type"`
+	// Level is the level of the event
+	Level string `json:"level,omitempty"`
+	// Message is the message of the event
+	Message string `json:"message,omitempty"`
+	// Stored is true if the interaction has been stored for a registered session
+	Stored *bool `json:"stored,omitempty"`
+	// Interaction is the json encoded interaction
+	Interaction jsoniter.RawMessage `json:"interaction,omitempty"`
+}
+
+// Logger writes the entries to an event log.
+type Logger struct {
+	sync.Mutex
+	writer io.Writer
+}
+
+// New returns a new logger writing the entries to the writer.
+func New(writer io.Writer) *Logger {
+	return &Logger{writer: writer}
+}
+
+// LogInteraction logs the json encoded interaction.
+func (l *Logger) LogInteraction(interaction []byte, stored bool) {
+	if l == nil {
+		return
+	}
+	l.write(&Entry{Timestamp: time.Now(), Type: "interaction", Stored: &stored, Interaction: interaction})
+}
+
+// LogEvent logs a server event.
+func (l *Logger) LogEvent(level, message string) {
+	if l == nil {
+		return
+	}
+	l.write(&Entry{Timestamp: time.Now(), Type: "event", Level: level, Message: message})
+}
+
+func (l *Logger) write(entry *Entry) {
+	data, err := jsoniter.Marshal(entry)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+
+	l.Lock()
+	_, _ = l.writer.Write(data)
+	l.Unlock()
+}
+
+// Formatter returns a gologger formatter logging every event
+// before formatting it with the next formatter.
+func (l *Logger) Formatter(next formatter.Formatter) formatter.Formatter {
+	return &eventFormatter{logger: l, next: next}
+}
+
+type eventFormatter struct {
+	logger *Logger
+	next   formatter.Formatter
+}
+
+// Format logs the event and formats it with the next formatter
+func (f *eventFormatter) Format(event *formatter.LogEvent) ([]byte, error) {
+	f.logger.LogEvent(event.Level.String(), event.Message)
+	return f.next.Format(event)
+}
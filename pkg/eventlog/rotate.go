@@ -0,0 +1,97 @@
+package eventlog
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// defaultMaxBackups is the number of rotated log files kept
+const defaultMaxBackups = 5
+
+// RotatingFile is a file writer rotating the file once it
+// reaches the maximum size, keeping the most recent backups
+// as <path>.1 (the newest) to <path>.5.
+type RotatingFile struct {
+	sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	size       int64
+	file       *os.File
+}
+
+// NewRotatingFile opens a log file for appending, rotating it once
+// it reaches maxSize bytes (0 to disable the rotation).
+func NewRotatingFile(path string, maxSize int64) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize, maxBackups: defaultMaxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrap(err, "could not open log file")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return errors.Wrap(err, "could not stat log file")
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// Write writes data to the file, rotating it first if it would exceed the maximum size.
+func (r *RotatingFile) Write(data []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.file == nil {
+		return 0, errors.New("log file is closed")
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(data)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(data)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups and reopens an empty log file
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return errors.Wrap(err, "could not close log file")
+	}
+	r.file = nil
+
+	_ = os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return errors.Wrap(err, "could not rotate log file")
+	}
+	return r.open()
+}
+
+// Close closes the log file.
+func (r *RotatingFile) Close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
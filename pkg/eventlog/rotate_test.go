@@ -0,0 +1,38 @@
+package eventlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile(t *testing.T) {
+	directory, err := ioutil.TempDir("", "eventlog")
+	require.Nil(t, err, "could not create directory")
+	defer os.RemoveAll(directory)
+
+	path := filepath.Join(directory, "events.log")
+	file, err := NewRotatingFile(path, 10)
+	require.Nil(t, err, "could not create log file")
+	defer file.Close()
+
+	logger := New(file)
+	for i := 0; i < defaultMaxBackups+3; i++ {
+		logger.LogEvent("info", "message")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	require.Nil(t, err, "could not read log file")
+	require.Contains(t, string(data), `"type":"event","level":"info","message":"message"`, "could not get event")
+
+	for i := 1; i <= defaultMaxBackups; i++ {
+		_, err := os.Stat(fmt.Sprintf("%s.%d", path, i))
+		require.Nil(t, err, "could not get backup %d", i)
+	}
+	_, err = os.Stat(fmt.Sprintf("%s.%d", path, defaultMaxBackups+1))
+	require.True(t, os.IsNotExist(err), "kept more backups than the maximum")
+}
@@ -0,0 +1,103 @@
+// Package geoip enriches the remote addresses of the interactions with
+// the location and the autonomous system found in MaxMind or DB-IP
+// databases (mmdb files).
+package geoip
+
+import (
+	"io/ioutil"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// Location is the location and the autonomous system of an ip
+type Location struct {
+	// Country is the ISO 3166-1 code of the country
+	Country string `json:"country,omitempty"`
+	// City is the english name of the city
+	City string `json:"city,omitempty"`
+	// ASN is the number of the autonomous system
+	ASN uint `json:"asn,omitempty"`
+	// ASOrganization is the organization of the autonomous system
+	ASOrganization string `json:"as-org,omitempty"`
+}
+
+// Reader looks up the ips in a set of databases, eg. a city and an asn one.
+type Reader struct {
+	databases []*database
+}
+
+// Open returns a reader for the mmdb files, which are loaded in memory.
+func Open(paths ...string) (*Reader, error) {
+	reader := &Reader{}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read %s", path)
+		}
+		db, err := newDatabase(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not load %s", path)
+		}
+		reader.databases = append(reader.databases, db)
+	}
+	return reader, nil
+}
+
+// Lookup returns the location of an address (ip or host:port), merged
+// from all the databases, or nil if none of them knows the address.
+func (r *Reader) Lookup(address string) *Location {
+	if r == nil {
+		return nil
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil
+	}
+	location := &Location{}
+	for _, db := range r.databases {
+		value, err := db.lookup(ip)
+		if err != nil {
+			continue
+		}
+		if record, ok := value.(map[string]interface{}); ok {
+			location.merge(record)
+		}
+	}
+	if *location == (Location{}) {
+		return nil
+	}
+	return location
+}
+
+// merge sets the empty fields of the location from a database record,
+// the GeoIP2 and DB-IP databases sharing the same record layout
+func (l *Location) merge(record map[string]interface{}) {
+	if l.Country == "" {
+		l.Country, _ = lookupPath(record, "country", "iso_code").(string)
+	}
+	if l.City == "" {
+		l.City, _ = lookupPath(record, "city", "names", "en").(string)
+	}
+	if l.ASN == 0 {
+		l.ASN, _ = toUint(record["autonomous_system_number"])
+	}
+	if l.ASOrganization == "" {
+		l.ASOrganization, _ = record["autonomous_system_organization"].(string)
+	}
+}
+
+// lookupPath returns the value of nested map keys, nil if missing
+func lookupPath(value interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = values[key]
+	}
+	return value
+}
@@ -0,0 +1,104 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// encode encodes the strings, uint32 and maps of the mmdb data section
+func encode(value interface{}) []byte {
+	switch value := value.(type) {
+	case string:
+		if len(value) >= 29 {
+			return append([]byte{2<<5 | 29, byte(len(value) - 29)}, value...)
+		}
+		return append([]byte{2<<5 | byte(len(value))}, value...)
+	case uint32:
+		b := []byte{6<<5 | 4, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], value)
+		return b
+	case []byte:
+		return value
+	case [][2]interface{}:
+		b := []byte{7<<5 | byte(len(value))}
+		for _, entry := range value {
+			b = append(b, encode(entry[0])...)
+			b = append(b, encode(entry[1])...)
+		}
+		return b
+	}
+	panic("unsupported value")
+}
+
+// writeDatabase writes a mmdb file mapping a network to the record at an offset of the data
+func writeDatabase(t *testing.T, ipVersion uint32, network string, data []byte, offset uint32) string {
+	_, ipNet, err := net.ParseCIDR(network)
+	require.Nil(t, err, "could not parse network")
+	ip := ipNet.IP
+	prefix, _ := ipNet.Mask.Size()
+	if ipVersion == 6 && ip.To4() != nil {
+		// ipv4 networks are stored in ::/96
+		ip, prefix = append(make(net.IP, 12), ip.To4()...), prefix+96
+	}
+
+	nodeCount := uint32(prefix)
+	var tree []byte
+	for i := 0; i < prefix; i++ {
+		bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+		next := uint32(i + 1)
+		if i == prefix-1 {
+			next = nodeCount + 16 + offset
+		}
+		records := [2]uint32{nodeCount, nodeCount}
+		records[bit] = next
+		for _, record := range records {
+			tree = append(tree, byte(record>>16), byte(record>>8), byte(record))
+		}
+	}
+	file := append(tree, make([]byte, 16)...)
+	file = append(file, data...)
+	file = append(file, metadataMarker...)
+	file = append(file, encode([][2]interface{}{
+		{"node_count", nodeCount},
+		{"record_size", uint32(24)},
+		{"ip_version", ipVersion},
+		{"database_type", "Test"},
+	})...)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	require.Nil(t, ioutil.WriteFile(path, file, 0600), "could not write database")
+	return path
+}
+
+func TestReaderLookup(t *testing.T) {
+	// the city name is a pointer to a string stored before the record
+	city := encode("Sydney")
+	city = append(city, encode([][2]interface{}{
+		{"country", [][2]interface{}{{"iso_code", "AU"}}},
+		{"city", [][2]interface{}{{"names", [][2]interface{}{{"en", []byte{1 << 5, 0}}}}}},
+	})...)
+	cityDB := writeDatabase(t, 6, "203.0.113.0/24", city, 7)
+	asn := encode([][2]interface{}{
+		{"autonomous_system_number", uint32(64496)},
+		{"autonomous_system_organization", "Example Networks"},
+	})
+	asnDB := writeDatabase(t, 4, "203.0.113.0/25", asn, 0)
+
+	reader, err := Open(cityDB, asnDB)
+	require.Nil(t, err, "could not open databases")
+	require.Equal(t, &Location{Country: "AU", City: "Sydney", ASN: 64496, ASOrganization: "Example Networks"}, reader.Lookup("203.0.113.5:4242"), "could not lookup merged location")
+	require.Equal(t, &Location{Country: "AU", City: "Sydney"}, reader.Lookup("203.0.113.200"), "could not lookup city only location")
+	require.Nil(t, reader.Lookup("198.51.100.1"), "found unknown ip")
+	require.Nil(t, reader.Lookup("2001:db8::1"), "found unknown ipv6")
+
+	var nilReader *Reader
+	require.Nil(t, nilReader.Lookup("203.0.113.5"), "nil reader found ip")
+
+	_, err = Open(filepath.Join(t.TempDir(), "missing.mmdb"))
+	require.NotNil(t, err, "opened missing database")
+}
@@ -0,0 +1,292 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/big"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// metadataMarker precedes the metadata at the end of a MaxMind DB file
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// maxMetadataSize is the size of the end of the file searched for the metadata
+const maxMetadataSize = 128 * 1024
+
+// database is a MaxMind DB (mmdb) file, the format used by MaxMind
+// GeoIP2/GeoLite2 and DB-IP databases.
+type database struct {
+	data         []byte
+	nodeCount    uint
+	recordSize   uint
+	ipVersion    uint
+	databaseType string
+	dataSection  []byte
+	ipv4Start    uint
+}
+
+func newDatabase(data []byte) (*database, error) {
+	start := len(data) - maxMetadataSize
+	if start < 0 {
+		start = 0
+	}
+	index := bytes.LastIndex(data[start:], metadataMarker)
+	if index < 0 {
+		return nil, errors.New("invalid mmdb file: metadata not found")
+	}
+	metadataStart := start + index + len(metadataMarker)
+	value, _, err := (&decoder{data: data[metadataStart:]}).decode(0)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decode metadata")
+	}
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid mmdb metadata")
+	}
+	db := &database{data: data}
+	db.nodeCount, _ = toUint(metadata["node_count"])
+	db.recordSize, _ = toUint(metadata["record_size"])
+	db.ipVersion, _ = toUint(metadata["ip_version"])
+	db.databaseType, _ = metadata["database_type"].(string)
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, errors.Errorf("unsupported mmdb record size %d", db.recordSize)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+16 > uint(start+index) {
+		return nil, errors.New("invalid mmdb search tree size")
+	}
+	db.dataSection = data[treeSize+16 : start+index]
+
+	// ipv4 addresses are looked up in ::/96 of the ipv6 databases
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// lookup returns the data of the network of an ip, nil if not found
+func (db *database) lookup(ip net.IP) (interface{}, error) {
+	node, bits := uint(0), 128
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip, bits = ipv4, 32
+		node = db.ipv4Start
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+	for i := 0; i < bits && node < db.nodeCount; i++ {
+		bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+		node = db.record(node, uint(bit))
+	}
+	if node == db.nodeCount {
+		return nil, nil
+	}
+	if node < db.nodeCount {
+		return nil, errors.New("invalid mmdb search tree")
+	}
+	offset := node - db.nodeCount - 16
+	if offset >= uint(len(db.dataSection)) {
+		return nil, errors.New("invalid mmdb data pointer")
+	}
+	value, _, err := (&decoder{data: db.dataSection}).decode(offset)
+	return value, err
+}
+
+// record returns the left (0) or right (1) record of a search tree node
+func (db *database) record(node, bit uint) uint {
+	size := db.recordSize / 4
+	b := db.data[node*size : node*size+size]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// decoder decodes the values of the mmdb data section
+type decoder struct {
+	data []byte
+}
+
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEnd       = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+// maxDecodeDepth is the maximum nesting of the decoded maps, arrays and pointers
+const maxDecodeDepth = 32
+
+// decode decodes the value at an offset, returning the offset following it
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	return d.decodeDepth(offset, 0)
+}
+
+func (d *decoder) decodeDepth(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, errors.New("mmdb data nested too deeply")
+	}
+	if offset >= uint(len(d.data)) {
+		return nil, 0, errors.New("unexpected end of mmdb data")
+	}
+	control := d.data[offset]
+	offset++
+	kind := uint(control >> 5)
+	if kind == typeExtended {
+		if offset >= uint(len(d.data)) {
+			return nil, 0, errors.New("unexpected end of mmdb data")
+		}
+		kind = 7 + uint(d.data[offset])
+		offset++
+	}
+	if kind == typePointer {
+		pointer, next, err := d.pointer(control, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decodeDepth(pointer, depth+1)
+		return value, next, err
+	}
+
+	size := uint(control & 0x1f)
+	if size >= 29 {
+		extra := size - 28
+		if offset+extra > uint(len(d.data)) {
+			return nil, 0, errors.New("unexpected end of mmdb data")
+		}
+		var value uint
+		for _, b := range d.data[offset : offset+extra] {
+			value = value<<8 | uint(b)
+		}
+		size = []uint{29, 285, 65821}[extra-1] + value
+		offset += extra
+	}
+
+	switch kind {
+	case typeMap:
+		values := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("invalid mmdb map key")
+			}
+			value, next, err := d.decodeDepth(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			values[name] = value
+			offset = next
+		}
+		return values, offset, nil
+	case typeArray:
+		values := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			values = append(values, value)
+			offset = next
+		}
+		return values, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEnd:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.data)) {
+		return nil, 0, errors.New("unexpected end of mmdb data")
+	}
+	b := d.data[offset : offset+size]
+	offset += size
+	switch kind {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return append([]byte(nil), b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid mmdb double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid mmdb float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		var value uint64
+		for _, c := range b {
+			value = value<<8 | uint64(c)
+		}
+		return value, offset, nil
+	case typeInt32:
+		var value uint32
+		for _, c := range b {
+			value = value<<8 | uint32(c)
+		}
+		return int64(int32(value)), offset, nil
+	case typeUint128:
+		return new(big.Int).SetBytes(b), offset, nil
+	}
+	return nil, 0, errors.Errorf("unknown mmdb data type %d", kind)
+}
+
+// pointer decodes a pointer into the data section
+func (d *decoder) pointer(control byte, offset uint) (uint, uint, error) {
+	size := uint(control>>3)&0x3 + 1
+	if offset+size > uint(len(d.data)) {
+		return 0, 0, errors.New("unexpected end of mmdb data")
+	}
+	var value uint
+	if size != 4 {
+		value = uint(control & 0x7)
+	}
+	for _, b := range d.data[offset : offset+size] {
+		value = value<<8 | uint(b)
+	}
+	value += []uint{0, 2048, 526336, 0}[size-1]
+	return value, offset + size, nil
+}
+
+// toUint returns an unsigned integer value of the decoded data
+func toUint(value interface{}) (uint, bool) {
+	switch value := value.(type) {
+	case uint64:
+		return uint(value), true
+	case int64:
+		if value >= 0 {
+			return uint(value), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,85 @@
+// Package notifier pushes a formatted summary of the interactions
+// to chat services (slack, discord and microsoft teams).
+package notifier
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/interactsh/pkg/server"
+	"github.com/projectdiscovery/interactsh/pkg/webhook"
+)
+
+// retries is the number of retries of the failed notifications
+const retries = 3
+
+// Service formats the summary of an interaction as the json message posted
+// to the incoming webhooks of a chat service.
+type Service func(summary string) interface{}
+
+// Services are the supported chat services by name
+var Services = map[string]Service{
+	"slack": func(summary string) interface{} {
+		return map[string]string{"text": summary}
+	},
+	"discord": func(summary string) interface{} {
+		return map[string]string{"content": summary, "username": "interactsh"}
+	},
+	"teams": func(summary string) interface{} {
+		return map[string]string{"@type": "MessageCard", "@context": "https://schema.org/extensions", "summary": "interactsh interaction", "text": summary}
+	},
+}
+
+// Notifier pushes the summary of the interactions to the incoming webhook
+// of a chat service, in the background and with retries.
+type Notifier struct {
+	service Service
+	webhook *webhook.Webhook
+}
+
+// New returns a notifier of a chat service posting to a webhook url.
+func New(service, url string) (*Notifier, error) {
+	format, ok := Services[service]
+	if !ok {
+		return nil, errors.Errorf("unsupported notifier service %s", service)
+	}
+	hook, err := webhook.New(&webhook.Options{Name: service, URL: url, Retries: retries})
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create %s notifier", service)
+	}
+	return &Notifier{service: format, webhook: hook}, nil
+}
+
+// Notify queues the summary of an interaction for delivery.
+func (n *Notifier) Notify(interaction *server.Interaction) error {
+	return n.webhook.Send(n.service(Summary(interaction)))
+}
+
+// Close stops the notifier once the queued notifications have been delivered.
+func (n *Notifier) Close() {
+	n.webhook.Close()
+}
+
+// Summary returns the one line markdown summary of an interaction, without
+// its raw request or captured credentials.
+func Summary(interaction *server.Interaction) string {
+	builder := &strings.Builder{}
+	builder.WriteString(fmt.Sprintf("Received %s interaction", strings.ToUpper(interaction.Protocol)))
+	switch interaction.Protocol {
+	case "dns", "axfr":
+		if interaction.QType != "" {
+			builder.WriteString(fmt.Sprintf(" (%s)", interaction.QType))
+		}
+	case "http":
+		if line := strings.SplitN(interaction.RawRequest, "\n", 2)[0]; line != "" {
+			builder.WriteString(fmt.Sprintf(" (`%s`)", strings.TrimSpace(line)))
+		}
+	case "smtp":
+		if message := interaction.SMTPMessage; message != nil && len(message.Headers["Subject"]) > 0 {
+			builder.WriteString(fmt.Sprintf(" (subject: %s)", message.Headers["Subject"][0]))
+		}
+	}
+	builder.WriteString(fmt.Sprintf(" on `%s` from %s at %s", interaction.FullId, interaction.RemoteAddress, interaction.Timestamp.Format("2006-01-02 15:04:05")))
+	return builder.String()
+}
@@ -0,0 +1,45 @@
+package notifier
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/interactsh/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummary(t *testing.T) {
+	timestamp := time.Date(2021, 9, 26, 13, 26, 10, 0, time.UTC)
+	require.Equal(t, "Received DNS interaction (A) on `c23b2la0kl1krjcrdj10nkvo` from 1.2.3.4 at 2021-09-26 13:26:10", Summary(&server.Interaction{Protocol: "dns", QType: "A", FullId: "c23b2la0kl1krjcrdj10nkvo", RemoteAddress: "1.2.3.4", Timestamp: timestamp}), "could not summarize dns interaction")
+	require.Equal(t, "Received HTTP interaction (`GET /admin HTTP/1.1`) on `c23b2la0kl1krjcrdj10nkvo` from 1.2.3.4 at 2021-09-26 13:26:10", Summary(&server.Interaction{Protocol: "http", RawRequest: "GET /admin HTTP/1.1\r\nHost: example.com\r\nAuthorization: Basic c2VjcmV0\r\n\r\n", FullId: "c23b2la0kl1krjcrdj10nkvo", RemoteAddress: "1.2.3.4", Timestamp: timestamp}), "could not summarize http interaction")
+}
+
+func TestNotifier(t *testing.T) {
+	received := make(chan string, 3)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		received <- string(data)
+	}))
+	defer ts.Close()
+
+	_, err := New("irc", ts.URL)
+	require.NotNil(t, err, "created notifier of unsupported service")
+
+	interaction := &server.Interaction{Protocol: "smtp", FullId: "c23b2la0kl1krjcrdj10nkvo", RemoteAddress: "1.2.3.4"}
+	summary := Summary(interaction)
+	for service, expected := range map[string]string{
+		"slack":   `{"text":"` + summary + `"}`,
+		"discord": `{"content":"` + summary + `","username":"interactsh"}`,
+		"teams":   `{"@type":"MessageCard","@context":"https://schema.org/extensions","summary":"interactsh interaction","text":"` + summary + `"}`,
+	} {
+		notifier, err := New(service, ts.URL)
+		require.Nil(t, err, "could not create %s notifier", service)
+		require.Nil(t, notifier.Notify(interaction), "could not notify %s", service)
+		notifier.Close()
+		require.Len(t, received, 1, "could not wait for the delivery of %s notification", service)
+		require.JSONEq(t, expected, <-received, "could not format %s message", service)
+	}
+}
@@ -1,16 +1,62 @@
 package options
 
+import "github.com/projectdiscovery/goflags"
+
 type CLIClientOptions struct {
 	ServerURL           string
 	NumberOfPayloads    int
+	PayloadTags         goflags.NormalizedStringSlice
 	Output              string
+	SQLite              string
+	ESURL               string
+	ESIndex             string
+	ESUsername          string
+	ESPassword          string
+	ESAPIKey            string
+	KafkaBrokers        goflags.StringSlice
+	KafkaTopic          string
+	NATSURL             string
+	NATSSubject         string
+	NATSJetStream       bool
 	JSON                bool
 	Verbose             bool
 	PollInterval        int
+	LongPoll            bool
+	Stream              bool
 	Persistent          bool
+	SessionFile         string
+	Filter              goflags.NormalizedStringSlice
+	MatchRegex          goflags.StringSlice
+	FilterRegex         goflags.StringSlice
 	DNSOnly             bool
 	HTTPOnly            bool
 	SmtpOnly            bool
 	Token               string
+	OIDCToken           string
+	SignRequests        bool
+	ClientCert          string
+	ClientKey           string
+	Proxy               string
 	DisableHTTPFallback bool
+	FailoverPolls       int
+	WebhookConfig       string
+	WebhookURL          string
+	WebhookTemplate     string
+	WebhookRetries      int
+	SlackWebhook        string
+	DiscordWebhook      string
+	TeamsWebhook        string
+	Obfuscations        goflags.NormalizedStringSlice
+	PayloadFormats      goflags.NormalizedStringSlice
+	QuarantineDirectory string
+	IDScheme            string
+	IDPrefix            string
+	IDLength            int
+	IDNonceLength       int
+	IDAlphabet          string
+	DNSAnswers          goflags.StringSlice
+	HTTPChallenge       string
+	HTTPResponses       string
+	HostFiles           goflags.StringSlice
+	SessionTTL          string
 }
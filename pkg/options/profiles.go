@@ -0,0 +1,81 @@
+package options
+
+import (
+	"flag"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Profiles are the deployment profiles of the server, bundling the values
+// of the listener, limit and feature flags. The profile values are only
+// used for the flags not set on the command line or in the config file.
+var Profiles = map[string]map[string]string{
+	// minimal only runs the dns and http(s) services with a short retention
+	"minimal": {
+		"smtp-port":         "0",
+		"smtps-port":        "0",
+		"smtp-autotls-port": "0",
+		"ldap-port":         "0",
+		"eviction":          "7",
+	},
+	// standard runs the default services
+	"standard": {},
+	// full runs every service of the server, requiring authentication
+	"full": {
+		"auth":     "true",
+		"ldap":     "true",
+		"wildcard": "true",
+		"ftp":      "true",
+		"netbios":  "true",
+	},
+	// research runs every service with per-resolver statistics and higher limits
+	"research": {
+		"auth":                 "true",
+		"ldap":                 "true",
+		"wildcard":             "true",
+		"ftp":                  "true",
+		"netbios":              "true",
+		"resolver-stats":       "true",
+		"eviction":             "90",
+		"catch-all-bytes":      "4096",
+		"smtp-max-attachments": "100",
+	},
+}
+
+// ProfileNames returns the sorted names of the deployment profiles.
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyProfile sets the flags of a deployment profile which are still at
+// their default value and haven't been set on the command line.
+func ApplyProfile(name string) error {
+	profile, ok := Profiles[strings.ToLower(name)]
+	if !ok {
+		return errors.Errorf("unknown profile %s (available: %s)", name, strings.Join(ProfileNames(), ", "))
+	}
+	set := make(map[string]struct{})
+	flag.CommandLine.Visit(func(f *flag.Flag) {
+		set[f.Name] = struct{}{}
+	})
+	for name, value := range profile {
+		currentFlag := flag.CommandLine.Lookup(name)
+		if currentFlag == nil {
+			return errors.Errorf("unknown profile flag %s", name)
+		}
+		if _, ok := set[name]; ok || currentFlag.Value.String() != currentFlag.DefValue {
+			continue
+		}
+		if err := currentFlag.Value.Set(value); err != nil {
+			return errors.Wrapf(err, "could not set profile flag %s", name)
+		}
+	}
+	return nil
+}
@@ -1,54 +1,324 @@
 package options
 
-import "github.com/projectdiscovery/interactsh/pkg/server"
+import (
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/goflags"
+	"github.com/projectdiscovery/gologger"
+	"github.com/projectdiscovery/interactsh/pkg/server"
+	"github.com/projectdiscovery/interactsh/pkg/webhook"
+	"gopkg.in/yaml.v2"
+)
 
 type CLIServerOptions struct {
-	Debug              bool
-	Domain             string
-	DnsPort            int
-	IPAddress          string
-	ListenIP           string
-	HttpPort           int
-	HttpsPort          int
-	Hostmaster         string
-	LdapWithFullLogger bool
-	Eviction           int
-	Responder          bool
-	Smb                bool
-	SmbPort            int
-	SmtpPort           int
-	SmtpsPort          int
-	SmtpAutoTLSPort    int
-	FtpPort            int
-	LdapPort           int
-	Ftp                bool
-	Auth               bool
-	Token              string
-	OriginURL          string
-	RootTLD            bool
-	FTPDirectory       string
-	SkipAcme           bool
+	Debug              bool                          `yaml:"debug"`
+	Domain             string                        `yaml:"domain"`
+	DnsPort            int                           `yaml:"dns-port"`
+	IPAddress          string                        `yaml:"ip"`
+	ListenIP           string                        `yaml:"listen-ip"`
+	HttpPort           int                           `yaml:"http-port"`
+	HttpsPort          int                           `yaml:"https-port"`
+	HTTPRawBytes       int                           `yaml:"http-raw-bytes"`
+	HTTPMaxBody        int                           `yaml:"http-max-body"`
+	NoTLSLog           bool                          `yaml:"no-tls-log"`
+	Hostmaster         string                        `yaml:"-"`
+	LdapWithFullLogger bool                          `yaml:"ldap"`
+	Eviction           string                        `yaml:"eviction"`
+	MaxSessionTTL      string                        `yaml:"max-session-ttl"`
+	RedisURL           string                        `yaml:"redis-url"`
+	DatabaseURL        string                        `yaml:"db-url"`
+	SnapshotFile       string                        `yaml:"snapshot-file"`
+	SnapshotInterval   int                           `yaml:"snapshot-interval"`
+	ClusterPeers       goflags.NormalizedStringSlice `yaml:"cluster-peers"`
+	ClusterSecret      string                        `yaml:"cluster-secret"`
+	Responder          bool                          `yaml:"responder"`
+	Smb                bool                          `yaml:"smb"`
+	SmbPort            int                           `yaml:"smb-port"`
+	SmtpPort           int                           `yaml:"smtp-port"`
+	SmtpsPort          int                           `yaml:"smtps-port"`
+	SmtpAutoTLSPort    int                           `yaml:"smtp-autotls-port"`
+	SmtpStartTLS       string                        `yaml:"smtp-starttls"`
+	NotifyMailRelay    string                        `yaml:"notify-mail-relay"`
+	NotifyMailUser     string                        `yaml:"notify-mail-user"`
+	NotifyMailPassword string                        `yaml:"notify-mail-password"`
+	NotifyMailFrom     string                        `yaml:"notify-mail-from"`
+	NotifyMailTo       goflags.StringSlice           `yaml:"notify-mail-to"`
+	NotifyMailRate     int                           `yaml:"notify-mail-rate"`
+	FtpPort            int                           `yaml:"ftp-port"`
+	LdapPort           int                           `yaml:"ldap-port"`
+	Ftp                bool                          `yaml:"ftp"`
+	NetBIOS            bool                          `yaml:"netbios"`
+	Auth               bool                          `yaml:"auth"`
+	Token              string                        `yaml:"token"`
+	TokenConfig        string                        `yaml:"token-config"`
+	SignedRequests     bool                          `yaml:"signed-requests"`
+	OIDCIssuer         string                        `yaml:"oidc-issuer"`
+	OIDCAudience       string                        `yaml:"oidc-audience"`
+	OIDCClaim          string                        `yaml:"oidc-claim"`
+	OIDCTokenTTL       int                           `yaml:"oidc-token-ttl"`
+	OIDCMaxSessions    int                           `yaml:"oidc-max-sessions"`
+	OIDCRegistrations  int                           `yaml:"oidc-registrations-per-hour"`
+	OIDCPolls          int                           `yaml:"oidc-polls-per-minute"`
+	OIDCScopes         goflags.NormalizedStringSlice `yaml:"oidc-scopes"`
+	OriginURL          string                        `yaml:"acao-url"`
+	RootTLD            bool                          `yaml:"wildcard"`
+	WildcardLog        bool                          `yaml:"wildcard-log"`
+	FTPDirectory       string                        `yaml:"ftp-dir"`
+	SkipAcme           bool                          `yaml:"skip-acme"`
+	CatchAllPorts      goflags.NormalizedStringSlice `yaml:"catch-all-ports"`
+	ProxyRoutes        goflags.StringSlice           `yaml:"proxy"`
+	NoHTTPResponses    bool                          `yaml:"no-http-responses"`
+	HTTPIndex          string                        `yaml:"http-index"`
+	SecurityTxt        string                        `yaml:"security-txt"`
+	RobotsTxt          string                        `yaml:"robots-txt"`
+	HostedFileSize     int                           `yaml:"hosted-file-size"`
+	HostedFileTTL      int                           `yaml:"hosted-file-ttl"`
+	IDPrefixes         goflags.StringSlice           `yaml:"id-prefix"`
+	IDLength           int                           `yaml:"cid-length"`
+	IDNonceLength      int                           `yaml:"cid-nonce-length"`
+	IDAlphabet         string                        `yaml:"cid-alphabet"`
+	CatchAllBytes      int                           `yaml:"catch-all-bytes"`
+	MaxSessionsPerIP   int                           `yaml:"max-sessions-per-ip"`
+	MaxInteractions    int                           `yaml:"max-interactions"`
+	InteractionPolicy  string                        `yaml:"max-interactions-policy"`
+	RateLimits         goflags.StringSlice           `yaml:"rate-limit"`
+	Allow              goflags.NormalizedStringSlice `yaml:"allow"`
+	Deny               goflags.NormalizedStringSlice `yaml:"deny"`
+	DenyInteractions   bool                          `yaml:"deny-interactions"`
+	Demo               bool                          `yaml:"demo"`
+	WebhookConfig      string                        `yaml:"webhook-config"`
+	Config             string                        `yaml:"-"`
+	ResolverStats      bool                          `yaml:"resolver-stats"`
+	DNSDedup           int                           `yaml:"dns-dedup"`
+	DNSRebind          bool                          `yaml:"dns-rebind"`
+	AdminPort          int                           `yaml:"admin-port"`
+	Inject             bool                          `yaml:"inject"`
+	AdminToken         string                        `yaml:"admin-token"`
+	RevocationFile     string                        `yaml:"revocation-file"`
+	LongPollWait       int                           `yaml:"long-poll-wait"`
+	CertFile           string                        `yaml:"cert"`
+	KeyFile            string                        `yaml:"key"`
+	ClientCA           string                        `yaml:"client-ca"`
+	ACMECA             string                        `yaml:"acme-ca"`
+	ACMECARoot         string                        `yaml:"acme-ca-root"`
+	ACMEEABKeyID       string                        `yaml:"acme-eab-kid"`
+	ACMEEABMACKey      string                        `yaml:"acme-eab-hmac"`
+	ACMEDirectory      string                        `yaml:"acme-dir"`
+	SelfSigned         bool                          `yaml:"self-signed"`
+	TLSMinVersion      string                        `yaml:"tls-min-version"`
+	TLSCipherSuites    goflags.NormalizedStringSlice `yaml:"tls-ciphers"`
+	TLSAcceptAll       bool                          `yaml:"tls-accept-all"`
+	OTLPEndpoint       string                        `yaml:"otlp-endpoint"`
+	IngestionLagAlert  int                           `yaml:"ingestion-lag-alert"`
+	DeliveryLagAlert   int                           `yaml:"delivery-lag-alert"`
+	SmtpMaxSize        int                           `yaml:"smtp-max-size"`
+	SmtpMaxAttachments int                           `yaml:"smtp-max-attachments"`
+	SmtpQuarantine     string                        `yaml:"smtp-quarantine"`
+	SmtpStore          bool                          `yaml:"smtp-store-attachments"`
+	SmtpMaxStoreSize   int                           `yaml:"smtp-max-attachment-size"`
+	LogFile            string                        `yaml:"log-file"`
+	LogMaxSize         int                           `yaml:"log-max-size"`
+	DiskLog            string                        `yaml:"disk-log"`
+	DiskLogBlobs       bool                          `yaml:"disk-log-blobs"`
+	AuditLog           string                        `yaml:"audit-log"`
+	AuditLogRetention  int                           `yaml:"audit-log-retention"`
+	PCAPDirectory      string                        `yaml:"pcap-dir"`
+	HTTPBodyDirectory  string                        `yaml:"http-body-dir"`
+	GeoIPDatabases     goflags.StringSlice           `yaml:"geoip-db"`
+	NoReverseDNS       bool                          `yaml:"no-rdns"`
+	Profile            string                        `yaml:"profile"`
+	DNSTTL             int                           `yaml:"dns-ttl"`
+	NameServers        goflags.NormalizedStringSlice `yaml:"ns-names"`
+	SOASerial          string                        `yaml:"soa-serial"`
+	SOAMbox            string                        `yaml:"soa-mbox"`
+	SOARefresh         int                           `yaml:"soa-refresh"`
+	SOARetry           int                           `yaml:"soa-retry"`
+	SOAExpire          int                           `yaml:"soa-expire"`
+	SOAMinTTL          int                           `yaml:"soa-min-ttl"`
+	ZoneFile           string                        `yaml:"zone-file"`
+	DNSSECKeys         string                        `yaml:"dnssec-keys"`
+	DNSDelegations     goflags.StringSlice           `yaml:"dns-delegate"`
+	NoMailRecords      bool                          `yaml:"no-mail-records"`
+	DKIMKey            string                        `yaml:"dkim-key"`
+	DKIMSelector       string                        `yaml:"dkim-selector"`
+}
+
+// PrimaryDomain returns the first domain of the server.
+func (cliServerOptions *CLIServerOptions) PrimaryDomain() string {
+	if domains := ParseDomains(cliServerOptions.Domain); len(domains) > 0 {
+		return domains[0]
+	}
+	return ""
 }
 
 func (cliServerOptions *CLIServerOptions) AsServerOptions() *server.Options {
+	timeToLive := uint32(cliServerOptions.DNSTTL)
 	return &server.Options{
-		Domain:          cliServerOptions.Domain,
-		DnsPort:         cliServerOptions.DnsPort,
-		IPAddress:       cliServerOptions.IPAddress,
-		ListenIP:        cliServerOptions.ListenIP,
-		HttpPort:        cliServerOptions.HttpPort,
-		HttpsPort:       cliServerOptions.HttpsPort,
-		Hostmaster:      cliServerOptions.Hostmaster,
-		SmbPort:         cliServerOptions.SmbPort,
-		SmtpPort:        cliServerOptions.SmtpPort,
-		SmtpsPort:       cliServerOptions.SmtpsPort,
-		SmtpAutoTLSPort: cliServerOptions.SmtpAutoTLSPort,
-		FtpPort:         cliServerOptions.FtpPort,
-		LdapPort:        cliServerOptions.LdapPort,
-		Auth:            cliServerOptions.Auth,
-		Token:           cliServerOptions.Token,
-		OriginURL:       cliServerOptions.OriginURL,
-		RootTLD:         cliServerOptions.RootTLD,
-		FTPDirectory:    cliServerOptions.FTPDirectory,
+		Domain:                cliServerOptions.PrimaryDomain(),
+		Domains:               ParseDomains(cliServerOptions.Domain),
+		DnsPort:               cliServerOptions.DnsPort,
+		IPAddress:             cliServerOptions.IPAddress,
+		ListenIP:              cliServerOptions.ListenIP,
+		HttpPort:              cliServerOptions.HttpPort,
+		HttpsPort:             cliServerOptions.HttpsPort,
+		HTTPRawBytes:          cliServerOptions.HTTPRawBytes,
+		HTTPMaxBody:           cliServerOptions.HTTPMaxBody,
+		TLSLog:                !cliServerOptions.NoTLSLog,
+		Hostmaster:            cliServerOptions.Hostmaster,
+		SmbPort:               cliServerOptions.SmbPort,
+		SmtpPort:              cliServerOptions.SmtpPort,
+		SmtpsPort:             cliServerOptions.SmtpsPort,
+		SmtpAutoTLSPort:       cliServerOptions.SmtpAutoTLSPort,
+		SmtpStartTLS:          cliServerOptions.SmtpStartTLS,
+		FtpPort:               cliServerOptions.FtpPort,
+		LdapPort:              cliServerOptions.LdapPort,
+		Auth:                  cliServerOptions.Auth,
+		Token:                 cliServerOptions.Token,
+		OriginURL:             cliServerOptions.OriginURL,
+		RootTLD:               cliServerOptions.RootTLD,
+		WildcardLog:           cliServerOptions.WildcardLog,
+		FTPDirectory:          cliServerOptions.FTPDirectory,
+		CatchAllBytes:         cliServerOptions.CatchAllBytes,
+		MaxSessionsPerIP:      cliServerOptions.MaxSessionsPerIP,
+		DenyInteractions:      cliServerOptions.DenyInteractions,
+		AdminPort:             cliServerOptions.AdminPort,
+		Inject:                cliServerOptions.Inject,
+		AdminToken:            cliServerOptions.AdminToken,
+		SmtpMaxSize:           cliServerOptions.SmtpMaxSize,
+		SmtpMaxAttachments:    cliServerOptions.SmtpMaxAttachments,
+		SmtpStoreAttachments:  cliServerOptions.SmtpStore,
+		SmtpMaxAttachmentSize: cliServerOptions.SmtpMaxStoreSize,
+		NameServers:           cliServerOptions.NameServers,
+		TimeToLive:            &timeToLive,
+		SOA: &server.SOA{
+			Mbox:    ParseMbox(cliServerOptions.SOAMbox),
+			Refresh: uint32(cliServerOptions.SOARefresh),
+			Retry:   uint32(cliServerOptions.SOARetry),
+			Expire:  uint32(cliServerOptions.SOAExpire),
+			MinTTL:  uint32(cliServerOptions.SOAMinTTL),
+		},
+	}
+}
+
+const (
+	// demoEviction is the time interactions are kept in demo mode
+	demoEviction = 24 * time.Hour
+	// demoMaxSessionsPerIP is the number of concurrent sessions per ip in demo mode
+	demoMaxSessionsPerIP = 5
+	// demoMaxInteractions is the number of pending interactions per correlation id in demo mode
+	demoMaxInteractions = 1000
+	// demoSmtpMaxSize is the maximum size in bytes of the smtp messages in demo mode
+	demoSmtpMaxSize = 1024 * 1024
+	// demoHostedFileSize is the maximum size in bytes of the hosted files in demo mode
+	demoHostedFileSize = 64 * 1024
+	// demoHostedFileTTL is the maximum number of hours files are hosted in demo mode
+	demoHostedFileTTL = 1
+	// demoHTTPMaxBody is the maximum number of bytes of the request bodies recorded in demo mode
+	demoHTTPMaxBody = 64 * 1024
+)
+
+// ApplyDemoMode configures the options for a public community server,
+// enforcing short retention, per-ip session quotas and turning off
+// the features exposing data of other users or the host itself.
+func (cliServerOptions *CLIServerOptions) ApplyDemoMode() {
+	if eviction, err := ParseEviction(cliServerOptions.Eviction); err != nil || eviction > demoEviction {
+		cliServerOptions.Eviction = demoEviction.String()
+	}
+	// the sessions can't outlive the eviction ttl
+	cliServerOptions.MaxSessionTTL = ""
+	if cliServerOptions.MaxSessionsPerIP <= 0 || cliServerOptions.MaxSessionsPerIP > demoMaxSessionsPerIP {
+		cliServerOptions.MaxSessionsPerIP = demoMaxSessionsPerIP
+	}
+	if cliServerOptions.MaxInteractions <= 0 || cliServerOptions.MaxInteractions > demoMaxInteractions {
+		cliServerOptions.MaxInteractions = demoMaxInteractions
+	}
+	if cliServerOptions.SmtpMaxSize <= 0 || cliServerOptions.SmtpMaxSize > demoSmtpMaxSize {
+		cliServerOptions.SmtpMaxSize = demoSmtpMaxSize
+	}
+	if cliServerOptions.HTTPMaxBody > demoHTTPMaxBody {
+		cliServerOptions.HTTPMaxBody = demoHTTPMaxBody
+	}
+	if cliServerOptions.HostedFileSize > demoHostedFileSize {
+		cliServerOptions.HostedFileSize = demoHostedFileSize
+	}
+	if cliServerOptions.HostedFileTTL <= 0 || cliServerOptions.HostedFileTTL > demoHostedFileTTL {
+		cliServerOptions.HostedFileTTL = demoHostedFileTTL
+	}
+
+	unsafeFeatures := map[string]*bool{
+		"wildcard":               &cliServerOptions.RootTLD,
+		"wildcard-log":           &cliServerOptions.WildcardLog,
+		"smb":                    &cliServerOptions.Smb,
+		"responder":              &cliServerOptions.Responder,
+		"ftp":                    &cliServerOptions.Ftp,
+		"ldap":                   &cliServerOptions.LdapWithFullLogger,
+		"netbios":                &cliServerOptions.NetBIOS,
+		"smtp-store-attachments": &cliServerOptions.SmtpStore,
+	}
+	for name, enabled := range unsafeFeatures {
+		if *enabled {
+			gologger.Info().Msgf("Disabling %s as it's not available in demo mode\n", name)
+			*enabled = false
+		}
+	}
+	if len(cliServerOptions.CatchAllPorts) > 0 {
+		gologger.Info().Msgf("Disabling catch-all-ports as it's not available in demo mode\n")
+		cliServerOptions.CatchAllPorts = nil
+	}
+	if len(cliServerOptions.ProxyRoutes) > 0 {
+		gologger.Info().Msgf("Disabling proxy as it's not available in demo mode\n")
+		cliServerOptions.ProxyRoutes = nil
+	}
+	if !cliServerOptions.NoHTTPResponses {
+		gologger.Info().Msgf("Disabling the http responses of the sessions as they're not available in demo mode\n")
+		cliServerOptions.NoHTTPResponses = true
+	}
+	if cliServerOptions.SmtpQuarantine != "" {
+		gologger.Info().Msgf("Disabling smtp-quarantine as it's not available in demo mode\n")
+		cliServerOptions.SmtpQuarantine = ""
+	}
+}
+
+// ServerConfigFile contains the settings of a server config file that
+// can't be expressed with command line flags.
+//
+// Any flag can be set in the config file as well using its long name as key.
+type ServerConfigFile struct {
+	// Webhooks are the webhooks notified of every interaction
+	Webhooks []*webhook.Options `yaml:"webhooks"`
+	// Tokens are the tokens of the tenants only seeing their own sessions
+	Tokens []*server.Tenant `yaml:"tokens"`
+}
+
+// ReadServerConfigFile reads the additional settings from a yaml or json config file.
+func ReadServerConfigFile(file string) (*ServerConfigFile, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read config file")
+	}
+	config := &ServerConfigFile{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, errors.Wrap(err, "could not decode config file")
+	}
+	return config, nil
+}
+
+// ReloadConfigFile returns a copy of the options with the values of the
+// config file applied on top, along with the additional config file settings.
+func (cliServerOptions *CLIServerOptions) ReloadConfigFile() (*CLIServerOptions, *ServerConfigFile, error) {
+	data, err := ioutil.ReadFile(cliServerOptions.Config)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not read config file")
+	}
+	reloaded := *cliServerOptions
+	if err := yaml.Unmarshal(data, &reloaded); err != nil {
+		return nil, nil, errors.Wrap(err, "could not decode config file")
+	}
+	config := &ServerConfigFile{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, nil, errors.Wrap(err, "could not decode config file")
 	}
+	return &reloaded, config, nil
 }
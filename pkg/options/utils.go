@@ -1,8 +1,26 @@
 package options
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
 	"github.com/projectdiscovery/goflags"
 	"github.com/projectdiscovery/gologger"
+	"github.com/projectdiscovery/interactsh/pkg/server"
+	"gopkg.in/yaml.v2"
 )
 
 const banner = `
@@ -27,3 +45,410 @@ func ShowBanner() {
 	gologger.Warning().Msgf("Use with caution. You are responsible for your actions\n")
 	gologger.Warning().Msgf("Developers assume no liability and are not responsible for any misuse or damage.\n")
 }
+
+// ParsePorts parses a comma separated list of ports and port ranges (eg. 1000-2000,8080)
+func ParsePorts(value string) ([]int, error) {
+	var ports []int
+	seen := make(map[int]struct{})
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		start, end := item, item
+		if strings.Contains(item, "-") {
+			parts := strings.SplitN(item, "-", 2)
+			start, end = parts[0], parts[1]
+		}
+		first, err := strconv.Atoi(strings.TrimSpace(start))
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %s", item)
+		}
+		last, err := strconv.Atoi(strings.TrimSpace(end))
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %s", item)
+		}
+		if first < 1 || last > 65535 || first > last {
+			return nil, fmt.Errorf("invalid port range %s", item)
+		}
+		for port := first; port <= last; port++ {
+			if _, ok := seen[port]; ok {
+				continue
+			}
+			seen[port] = struct{}{}
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// ParseSOASerial parses a soa serial strategy, either epoch for the unix time
+// of the startup (increasing on every restart) or a counter value.
+func ParseSOASerial(value string) (uint32, error) {
+	if strings.EqualFold(value, "epoch") {
+		return uint32(time.Now().Unix()), nil
+	}
+	serial, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid soa serial %s", value)
+	}
+	return uint32(serial), nil
+}
+
+// ParseEviction parses an eviction ttl, either a number of days (eg. 30 or 30d)
+// or a duration (eg. 12h or 90m).
+func ParseEviction(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	var ttl time.Duration
+	if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil {
+		ttl = time.Duration(days) * 24 * time.Hour
+	} else if ttl, err = time.ParseDuration(value); err != nil {
+		return 0, fmt.Errorf("invalid eviction %s", value)
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("invalid eviction %s", value)
+	}
+	return ttl, nil
+}
+
+// ParseMbox converts a hostmaster email (eg. admin@example.com) to a soa mailbox name
+func ParseMbox(value string) string {
+	if index := strings.LastIndex(value, "@"); index != -1 {
+		local := strings.ReplaceAll(value[:index], ".", "\\.")
+		value = local + "." + value[index+1:]
+	}
+	return value
+}
+
+// ParseDomains parses a comma separated list of domains, the first
+// one being the primary domain of the server.
+func ParseDomains(value string) []string {
+	var domains []string
+	for _, domain := range strings.Split(value, ",") {
+		if domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), ".")); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// ParseProxyRoutes parses the path=origin routes proxied by the http server
+// (eg. /app/=http://127.0.0.1:8000), returning the origins by path.
+func ParseProxyRoutes(values []string) (map[string]*url.URL, error) {
+	routes := make(map[string]*url.URL, len(values))
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "/") {
+			return nil, fmt.Errorf("invalid proxy route %s", value)
+		}
+		origin, err := url.Parse(parts[1])
+		if err != nil || (origin.Scheme != "http" && origin.Scheme != "https") || origin.Host == "" {
+			return nil, fmt.Errorf("invalid proxy origin %s", parts[1])
+		}
+		routes[parts[0]] = origin
+	}
+	return routes, nil
+}
+
+// ParseIDPrefixes parses the prefix=token prefixes of the prefix id scheme,
+// returning the tokens by prefix (empty for the server token).
+func ParseIDPrefixes(values []string) (map[string]string, error) {
+	prefixes := make(map[string]string, len(values))
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if err := server.ValidateIDPrefix(parts[0]); err != nil {
+			return nil, fmt.Errorf("invalid id prefix %s: %s", parts[0], err)
+		}
+		if len(parts) == 2 {
+			prefixes[parts[0]] = parts[1]
+		} else {
+			prefixes[parts[0]] = ""
+		}
+	}
+	return prefixes, nil
+}
+
+// protocolFilters are the protocols of the interactions displayed for each
+// class of the protocol filter
+var protocolFilters = map[string][]string{
+	"dns":       {"dns", "axfr"},
+	"axfr":      {"axfr"},
+	"http":      {"http", "http-raw", "tls"},
+	"http-raw":  {"http-raw"},
+	"tls":       {"tls"},
+	"smtp":      {"smtp"},
+	"ftp":       {"ftp"},
+	"smb":       {"responder", "smb"},
+	"responder": {"responder", "smb"},
+	"netbios":   {"netbios"},
+	"tcp":       {"tcp"},
+	"udp":       {"udp"},
+	"ldap":      {"ldap"},
+}
+
+// ProtocolFilterNames returns the sorted names of the protocol filter classes.
+func ProtocolFilterNames() []string {
+	names := make([]string, 0, len(protocolFilters))
+	for name := range protocolFilters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseProtocolFilter parses the protocol classes of the interactions to
+// display, returning the set of their protocols (nil to display all of them).
+func ParseProtocolFilter(values []string) (map[string]struct{}, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	protocols := make(map[string]struct{})
+	for _, value := range values {
+		classProtocols, ok := protocolFilters[strings.ToLower(strings.TrimSpace(value))]
+		if !ok {
+			return nil, fmt.Errorf("invalid protocol filter %s (%s)", value, strings.Join(ProtocolFilterNames(), ","))
+		}
+		for _, protocol := range classProtocols {
+			protocols[protocol] = struct{}{}
+		}
+	}
+	return protocols, nil
+}
+
+// ParseRegexes compiles the regular expressions of the content filters.
+func ParseRegexes(values []string) ([]*regexp.Regexp, error) {
+	regexes := make([]*regexp.Regexp, 0, len(values))
+	for _, value := range values {
+		regex, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %s: %s", value, err)
+		}
+		regexes = append(regexes, regex)
+	}
+	return regexes, nil
+}
+
+// payloadTagRegex matches the tags of the payloads, which are dns labels
+var payloadTagRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ParsePayloadTags returns the tags of the payloads listed by the client, one
+// payload being generated per tag. Without tags, the count payloads are
+// tagged with their number if there is more than one.
+func ParsePayloadTags(values []string, count int) ([]string, error) {
+	if len(values) == 0 {
+		if count <= 1 {
+			return []string{""}, nil
+		}
+		tags := make([]string, count)
+		for i := range tags {
+			tags[i] = strconv.Itoa(i + 1)
+		}
+		return tags, nil
+	}
+	tags := make([]string, 0, len(values))
+	seen := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		tag := strings.ToLower(strings.TrimSpace(value))
+		if !payloadTagRegex.MatchString(tag) {
+			return nil, fmt.Errorf("invalid payload tag %s (must be a dns label)", value)
+		}
+		if _, ok := seen[tag]; ok {
+			return nil, fmt.Errorf("duplicate payload tag %s", value)
+		}
+		seen[tag] = struct{}{}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// ParseDNSAnswers parses the type=value dns answers of a session, the
+// values of the same type being all answered.
+func ParseDNSAnswers(values []string) (*server.DNSAnswers, error) {
+	answers := &server.DNSAnswers{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid dns answer %s: expected type=value", value)
+		}
+		switch strings.ToLower(parts[0]) {
+		case "a":
+			answers.A = append(answers.A, parts[1])
+		case "aaaa":
+			answers.AAAA = append(answers.AAAA, parts[1])
+		case "cname":
+			answers.CNAME = parts[1]
+		case "txt":
+			answers.TXT = append(answers.TXT, parts[1])
+		case "ttl":
+			ttl, err := strconv.ParseUint(parts[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid dns answer %s: invalid ttl", value)
+			}
+			answers.TTL = uint32(ttl)
+		default:
+			return nil, fmt.Errorf("invalid dns answer %s: unsupported type %s", value, parts[0])
+		}
+	}
+	if err := answers.Validate(); err != nil {
+		return nil, err
+	}
+	return answers, nil
+}
+
+// httpResponseFile is an http response of a responses file, whose body can be read from a file
+type httpResponseFile struct {
+	server.HTTPResponse `yaml:",inline"`
+	// BodyFile is the file the body is read from, relative to the responses file
+	BodyFile string `yaml:"body-file"`
+}
+
+// ParseHTTPResponses parses a yaml file with the http responses of a session.
+func ParseHTTPResponses(file string) (server.HTTPResponses, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read http responses")
+	}
+	var entries []*httpResponseFile
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, errors.Wrap(err, "could not parse http responses")
+	}
+	responses := make(server.HTTPResponses, 0, len(entries))
+	for _, entry := range entries {
+		if entry.BodyFile != "" {
+			path := entry.BodyFile
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(filepath.Dir(file), path)
+			}
+			body, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not read body of %s", entry.Path)
+			}
+			entry.Body = string(body)
+		}
+		response := entry.HTTPResponse
+		responses = append(responses, &response)
+	}
+	if err := responses.Validate(); err != nil {
+		return nil, err
+	}
+	return responses, nil
+}
+
+// ParseDNSDelegations parses the zone=nameserver[:ip] delegations of the
+// sub-zones, the zones outside the domains being relative to the first one
+// and the ip being the glue record of the name server.
+func ParseDNSDelegations(values []string, domains []string) (map[string][]server.NameServer, error) {
+	delegations := make(map[string][]server.NameServer)
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid dns delegation %s: expected zone=nameserver[:ip]", value)
+		}
+		zone := strings.ToLower(strings.TrimSuffix(parts[0], "."))
+		inDomain := false
+		for _, domain := range domains {
+			domain = strings.ToLower(domain)
+			if zone == domain {
+				return nil, fmt.Errorf("invalid dns delegation %s: can't delegate the domain itself", value)
+			}
+			inDomain = inDomain || strings.HasSuffix(zone, "."+domain)
+		}
+		if !inDomain && len(domains) > 0 {
+			zone += "." + strings.ToLower(domains[0])
+		}
+		if _, ok := dns.IsDomainName(zone); !ok {
+			return nil, fmt.Errorf("invalid dns delegation %s: invalid zone %s", value, parts[0])
+		}
+		nameServer := server.NameServer{Name: parts[1]}
+		// the name servers can't contain a colon, the glue ip being an ipv4 or ipv6 address
+		if i := strings.Index(parts[1], ":"); i >= 0 {
+			nameServer.Name = parts[1][:i]
+			if nameServer.IP = net.ParseIP(parts[1][i+1:]); nameServer.IP == nil {
+				return nil, fmt.Errorf("invalid dns delegation %s: invalid glue ip %s", value, parts[1][i+1:])
+			}
+		}
+		if _, ok := dns.IsDomainName(nameServer.Name); !ok {
+			return nil, fmt.Errorf("invalid dns delegation %s: invalid name server %s", value, nameServer.Name)
+		}
+		nameServer.Name = dns.Fqdn(nameServer.Name)
+		delegations[dns.Fqdn(zone)] = append(delegations[dns.Fqdn(zone)], nameServer)
+	}
+	return delegations, nil
+}
+
+// ParseRateLimits parses the protocol=rate[:burst] interactions per second
+// recorded per source ip, the burst defaulting to the rate.
+func ParseRateLimits(values []string) (map[string]server.RateLimit, error) {
+	limits := make(map[string]server.RateLimit, len(values))
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid rate limit %s: expected protocol=rate[:burst]", value)
+		}
+		protocol := strings.ToLower(parts[0])
+		rate, burst := parts[1], ""
+		if i := strings.Index(rate, ":"); i >= 0 {
+			rate, burst = rate[:i], rate[i+1:]
+		}
+		limit := server.RateLimit{}
+		var err error
+		if limit.Rate, err = strconv.ParseFloat(rate, 64); err != nil || limit.Rate <= 0 {
+			return nil, fmt.Errorf("invalid rate limit %s: invalid rate %s", value, rate)
+		}
+		if burst == "" {
+			limit.Burst = int(math.Ceil(limit.Rate))
+		} else if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst < 1 {
+			return nil, fmt.Errorf("invalid rate limit %s: invalid burst %s", value, burst)
+		}
+		limits[protocol] = limit
+	}
+	return limits, nil
+}
+
+// tlsVersions are the tls versions accepted as minimum version
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses a tls version (eg. 1.2)
+func ParseTLSVersion(value string) (uint16, error) {
+	version, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(strings.TrimSpace(value)), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("invalid tls version %s", value)
+	}
+	return version, nil
+}
+
+// ParseCertPool reads the pem certificates of a file, eg. a ca bundle
+func ParseCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read certificates")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", file)
+	}
+	return pool, nil
+}
+
+// ParseCipherSuites parses a list of tls cipher suite names
+// (eg. TLS_RSA_WITH_AES_128_CBC_SHA), including the insecure ones.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[strings.ToLower(suite.Name)] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("invalid cipher suite %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"sort"
+	"sync"
+)
+
+// maxAccountedSessions is the number of sessions accounted before
+// the evicted and deregistered ones are pruned
+const maxAccountedSessions = 100000
+
+// SessionUsage is the traffic accounted for a session across all the
+// protocols, based on the raw requests and responses of its interactions.
+type SessionUsage struct {
+	// CorrelationID is the correlation id of the session
+	CorrelationID string `json:"correlation-id,omitempty"`
+	// Connections is the number of interactions of the session
+	Connections uint64 `json:"connections"`
+	// BytesIn is the number of bytes received by the server
+	BytesIn uint64 `json:"bytes-in"`
+	// BytesOut is the number of bytes sent by the server
+	BytesOut uint64 `json:"bytes-out"`
+}
+
+// Accounting tracks the connections and the bytes in/out per session.
+type Accounting struct {
+	alive func(correlationID string) bool
+
+	sync.Mutex
+	sessions map[string]*SessionUsage
+}
+
+// NewAccounting returns a new accounting, alive returning false for
+// the sessions which are no longer registered.
+func NewAccounting(alive func(correlationID string) bool) *Accounting {
+	return &Accounting{alive: alive, sessions: make(map[string]*SessionUsage)}
+}
+
+// Add accounts an interaction of a session.
+func (a *Accounting) Add(correlationID string, bytesIn, bytesOut int) {
+	if a == nil {
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+
+	usage, ok := a.sessions[correlationID]
+	if !ok {
+		if len(a.sessions) >= maxAccountedSessions {
+			a.prune()
+		}
+		usage = &SessionUsage{CorrelationID: correlationID}
+		a.sessions[correlationID] = usage
+	}
+	usage.Connections++
+	usage.BytesIn += uint64(bytesIn)
+	usage.BytesOut += uint64(bytesOut)
+}
+
+// Get returns the usage of a session.
+func (a *Accounting) Get(correlationID string) (SessionUsage, bool) {
+	if a == nil {
+		return SessionUsage{}, false
+	}
+	a.Lock()
+	defer a.Unlock()
+
+	usage, ok := a.sessions[correlationID]
+	if !ok {
+		return SessionUsage{}, false
+	}
+	return *usage, true
+}
+
+// List returns the usage of all the registered sessions sorted by correlation id.
+func (a *Accounting) List() []SessionUsage {
+	a.Lock()
+	defer a.Unlock()
+
+	a.prune()
+	sessions := make([]SessionUsage, 0, len(a.sessions))
+	for _, usage := range a.sessions {
+		sessions = append(sessions, *usage)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CorrelationID < sessions[j].CorrelationID
+	})
+	return sessions
+}
+
+// Remove removes the usage of a deregistered session.
+func (a *Accounting) Remove(correlationID string) {
+	if a == nil {
+		return
+	}
+	a.Lock()
+	delete(a.sessions, correlationID)
+	a.Unlock()
+}
+
+// prune removes the sessions which are no longer registered
+func (a *Accounting) prune() {
+	for correlationID := range a.sessions {
+		if !a.alive(correlationID) {
+			delete(a.sessions, correlationID)
+		}
+	}
+}
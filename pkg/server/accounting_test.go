@@ -0,0 +1,30 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccounting(t *testing.T) {
+	registered := map[string]bool{"c23b2la0kl1krjcrdj10": true, "c23b2la0kl1krjcrdj11": true}
+	accounting := NewAccounting(func(correlationID string) bool {
+		return registered[correlationID]
+	})
+
+	accounting.Add("c23b2la0kl1krjcrdj10", 100, 200)
+	accounting.Add("c23b2la0kl1krjcrdj10", 50, 0)
+	accounting.Add("c23b2la0kl1krjcrdj11", 10, 20)
+
+	usage, ok := accounting.Get("c23b2la0kl1krjcrdj10")
+	require.True(t, ok, "could not get usage")
+	require.Equal(t, SessionUsage{CorrelationID: "c23b2la0kl1krjcrdj10", Connections: 2, BytesIn: 150, BytesOut: 200}, usage, "could not account interactions")
+
+	registered["c23b2la0kl1krjcrdj11"] = false
+	sessions := accounting.List()
+	require.Len(t, sessions, 1, "could not prune evicted session")
+
+	accounting.Remove("c23b2la0kl1krjcrdj10")
+	_, ok = accounting.Get("c23b2la0kl1krjcrdj10")
+	require.False(t, ok, "could not remove deregistered session")
+}
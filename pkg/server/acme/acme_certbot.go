@@ -3,24 +3,106 @@ package acme
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/caddyserver/certmagic"
+	"github.com/mholt/acmez/acme"
+	"github.com/pkg/errors"
 	"github.com/projectdiscovery/gologger"
 	"go.uber.org/zap"
 )
 
+// CAs are the names of the well-known acme directories accepted as CA
+var CAs = map[string]string{
+	"letsencrypt":         certmagic.LetsEncryptProductionCA,
+	"letsencrypt-staging": certmagic.LetsEncryptStagingCA,
+	"zerossl":             certmagic.ZeroSSLProductionCA,
+}
+
+// Options contains the acme account and directory settings
+type Options struct {
+	// Email is the email of the acme account
+	Email string
+	// CA is the name of a well-known acme directory or a directory url,
+	// defaults to let's encrypt
+	CA string
+	// CARoot is a pem file with the root certificates of an internal acme directory
+	CARoot string
+	// EABKeyID is the key identifier of the external account binding
+	EABKeyID string
+	// EABMACKey is the base64url encoded mac key of the external account binding
+	EABMACKey string
+	// StorageDir is the directory the certificates and the account keys are
+	// stored in and reused from across restarts, defaults to the certmagic
+	// directory of the user (~/.local/share/certmagic)
+	StorageDir string
+	// Debug enables logging of the acme operations
+	Debug bool
+}
+
+// storagePath returns the directory the certificates are stored in
+func (options *Options) storagePath() string {
+	if options.StorageDir != "" {
+		return options.StorageDir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".local", "share", "certmagic")
+}
+
+// directory returns the directory url of the ca
+func (options *Options) directory() (string, error) {
+	if options.CA == "" {
+		return certmagic.LetsEncryptProductionCA, nil
+	}
+	if directory, ok := CAs[strings.ToLower(options.CA)]; ok {
+		return directory, nil
+	}
+	if directoryURL, err := url.Parse(options.CA); err != nil || directoryURL.Scheme != "https" || directoryURL.Host == "" {
+		return "", errors.Errorf("invalid acme directory %s", options.CA)
+	}
+	return options.CA, nil
+}
+
 // HandleWildcardCertificates handles ACME wildcard cert generation with DNS
-// challenge using certmagic library from caddyserver.
-func HandleWildcardCertificates(domain, email string, store *Provider, debug bool) (*tls.Config, error) {
+// challenge using certmagic library from caddyserver, for every domain
+// (eg. *.example.com) with the first one being the default server name.
+func HandleWildcardCertificates(domains []string, store *Provider, options *Options) (*tls.Config, error) {
 	logger, err := zap.NewProduction()
 	if err != nil {
 		return nil, err
 	}
+	directory, err := options.directory()
+	if err != nil {
+		return nil, err
+	}
+	if (options.EABKeyID == "") != (options.EABMACKey == "") {
+		return nil, errors.New("both eab key id and mac key are required")
+	}
+	if directory == certmagic.ZeroSSLProductionCA && options.EABKeyID == "" {
+		return nil, errors.New("zerossl requires eab credentials")
+	}
+	if options.EABKeyID != "" {
+		certmagic.DefaultACME.ExternalAccount = &acme.EAB{KeyID: options.EABKeyID, MACKey: options.EABMACKey}
+	}
+	if options.CARoot != "" {
+		data, err := ioutil.ReadFile(options.CARoot)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read acme ca root")
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, errors.New("no certificate found in acme ca root")
+		}
+		certmagic.DefaultACME.TrustedRoots = roots
+	}
+
 	certmagic.DefaultACME.Agreed = true
-	certmagic.DefaultACME.Email = email
+	certmagic.DefaultACME.Email = options.Email
 	certmagic.DefaultACME.DNS01Solver = &certmagic.DNS01Solver{
 		DNSProvider: store,
 		Resolvers: []string{
@@ -30,46 +112,50 @@ func HandleWildcardCertificates(domain, email string, store *Provider, debug boo
 			"1.0.0.1:53",
 		},
 	}
-	originalDomain := strings.TrimPrefix(domain, "*.")
-
-	certmagic.DefaultACME.CA = certmagic.LetsEncryptProductionCA
-	if debug {
+	certmagic.DefaultACME.CA = directory
+	if options.Debug {
 		certmagic.DefaultACME.Logger = logger
 	}
 	certmagic.DefaultACME.DisableHTTPChallenge = true
 	certmagic.DefaultACME.DisableTLSALPNChallenge = true
 
+	if err := os.MkdirAll(options.storagePath(), 0700); err != nil {
+		return nil, errors.Wrap(err, "could not create acme storage directory")
+	}
+	certmagic.Default.Storage = &certmagic.FileStorage{Path: options.storagePath()}
+
 	cfg := certmagic.NewDefault()
-	if debug {
+	if options.Debug {
 		cfg.Logger = logger
 	}
 
 	var creating bool
-	if !certAlreadyExists(cfg, &certmagic.DefaultACME, domain) {
-		creating = true
-		gologger.Info().Msgf("Requesting SSL Certificate for:  [%s, %s]", domain, strings.TrimPrefix(domain, "*."))
-	} else {
-		gologger.Info().Msgf("Loading existing SSL Certificate for:  [%s, %s]", domain, strings.TrimPrefix(domain, "*."))
+	managed := append([]string{}, domains...)
+	for _, domain := range domains {
+		managed = append(managed, strings.TrimPrefix(domain, "*."))
 	}
+	manager := newCertificateManager(cfg, managed)
+	for _, domain := range domains {
+		if !certAlreadyExists(cfg, cfg.Issuers[0], domain) {
+			creating = true
+			gologger.Info().Msgf("Requesting SSL Certificate for:  [%s, %s]", domain, strings.TrimPrefix(domain, "*."))
+		} else {
+			gologger.Info().Msgf("Loading existing SSL Certificate for:  [%s, %s]", domain, strings.TrimPrefix(domain, "*."))
+		}
 
-	// this obtains certificates or renews them if necessary
-	if syncerr := cfg.ObtainCertSync(context.Background(), domain); syncerr != nil {
-		return nil, syncerr
-	}
-	go func() {
-		syncerr := cfg.ManageAsync(context.Background(), []string{domain, originalDomain})
-		if syncerr != nil {
-			gologger.Error().Msgf("Could not manageasync certmagic certs: %s", err)
+		// this obtains certificates or renews them if necessary
+		if syncerr := manager.refresh(context.Background(), domain); syncerr != nil {
+			return nil, syncerr
 		}
-	}()
+	}
+	// the apex certificates are obtained in the background along
+	// with the renewal of all the certificates before they expire
+	go manager.renew(context.Background())
 
-	config := cfg.TLSConfig()
-	config.ServerName = originalDomain
-	config.NextProtos = []string{"h2", "http/1.1"}
+	config := manager.TLSConfig()
 
 	if creating {
-		home, _ := os.UserHomeDir()
-		gologger.Info().Msgf("Successfully Created SSL Certificate at: %s", filepath.Join(filepath.Join(home, ".local", "share"), "certmagic"))
+		gologger.Info().Msgf("Successfully Created SSL Certificate at: %s", options.storagePath())
 	}
 	return config, nil
 }
@@ -0,0 +1,162 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+)
+
+// renewCheckInterval is the interval the certificates are checked for renewal
+const renewCheckInterval = time.Hour
+
+// certificateManager serves the acme certificates of the names, renewing
+// them before they expire and atomically swapping the renewed certificates
+// into the running listeners without restarting them.
+type certificateManager struct {
+	cfg   *certmagic.Config
+	names []string
+
+	sync.Mutex
+	// certificates is the map[string]*tls.Certificate of the served
+	// certificates by name, replaced as a whole when one is swapped
+	certificates atomic.Value
+}
+
+// newCertificateManager returns a certificate manager for the names,
+// with the first one being the default certificate.
+func newCertificateManager(cfg *certmagic.Config, names []string) *certificateManager {
+	manager := &certificateManager{cfg: cfg, names: names}
+	manager.certificates.Store(map[string]*tls.Certificate{})
+	return manager
+}
+
+// GetCertificate returns the certificate matching the server name of the
+// client hello, falling back to the default certificate.
+func (m *certificateManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certificates := m.certificates.Load().(map[string]*tls.Certificate)
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if certificate, ok := certificates[name]; ok {
+		return certificate, nil
+	}
+	if index := strings.Index(name, "."); index != -1 {
+		if certificate, ok := certificates["*"+name[index:]]; ok {
+			return certificate, nil
+		}
+	}
+	if certificate, ok := certificates[m.names[0]]; ok {
+		return certificate, nil
+	}
+	return nil, errors.New("no certificate available")
+}
+
+// store swaps the served certificate of the name
+func (m *certificateManager) store(name string, certificate *tls.Certificate) {
+	m.Lock()
+	defer m.Unlock()
+
+	current := m.certificates.Load().(map[string]*tls.Certificate)
+	certificates := make(map[string]*tls.Certificate, len(current)+1)
+	for key, value := range current {
+		certificates[key] = value
+	}
+	certificates[name] = certificate
+	m.certificates.Store(certificates)
+}
+
+// refresh obtains the certificate of the name if it doesn't exist yet,
+// renews it when it is in the renewal window and swaps in the certificate
+// from the storage.
+func (m *certificateManager) refresh(ctx context.Context, name string) error {
+	current := m.certificates.Load().(map[string]*tls.Certificate)[name]
+	if current != nil && !m.needsRenewal(current.Leaf, time.Now()) {
+		return nil
+	}
+
+	if !certAlreadyExists(m.cfg, m.cfg.Issuers[0], name) {
+		if err := m.cfg.ObtainCertSync(ctx, name); err != nil {
+			return err
+		}
+	} else if err := m.cfg.RenewCertSync(ctx, name, false); err != nil {
+		return err
+	}
+
+	certificate, err := m.load(name)
+	if err != nil {
+		return err
+	}
+	m.store(name, certificate)
+	if current != nil && current.Leaf.SerialNumber.Cmp(certificate.Leaf.SerialNumber) != 0 {
+		gologger.Info().Msgf("Renewed SSL Certificate for %s, expires at %s", name, certificate.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// load returns the certificate of the name from the storage
+func (m *certificateManager) load(name string) (*tls.Certificate, error) {
+	issuerKey := m.cfg.Issuers[0].IssuerKey()
+	certPEM, err := m.cfg.Storage.Load(certmagic.StorageKeys.SiteCert(issuerKey, name))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load certificate")
+	}
+	keyPEM, err := m.cfg.Storage.Load(certmagic.StorageKeys.SitePrivateKey(issuerKey, name))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load private key")
+	}
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse certificate")
+	}
+	if certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+		return nil, errors.Wrap(err, "could not parse certificate")
+	}
+	return &certificate, nil
+}
+
+// needsRenewal returns true if the certificate is in the renewal window,
+// the last third of its lifetime by default
+func (m *certificateManager) needsRenewal(leaf *x509.Certificate, now time.Time) bool {
+	ratio := m.cfg.RenewalWindowRatio
+	if ratio <= 0 {
+		ratio = certmagic.DefaultRenewalWindowRatio
+	}
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	return leaf.NotAfter.Sub(now) < time.Duration(float64(lifetime)*ratio)
+}
+
+// renew refreshes the certificates of the names every renewCheckInterval
+// until the context is cancelled.
+func (m *certificateManager) renew(ctx context.Context) {
+	ticker := time.NewTicker(renewCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, name := range m.names {
+			if err := m.refresh(ctx, name); err != nil {
+				gologger.Error().Msgf("Could not renew SSL Certificate for %s: %s", name, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// TLSConfig returns a tls config serving the certificates of the manager
+func (m *certificateManager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		ServerName:     strings.TrimPrefix(m.names[0], "*."),
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+}
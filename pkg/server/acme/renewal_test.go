@@ -0,0 +1,62 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCertificate(t *testing.T, name string, serial int64, notBefore, notAfter time.Time) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err, "could not generate key")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err, "could not create certificate")
+	leaf, err := x509.ParseCertificate(der)
+	require.Nil(t, err, "could not parse certificate")
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestCertificateManager(t *testing.T) {
+	now := time.Now()
+	manager := newCertificateManager(&certmagic.Config{}, []string{"*.example.com", "example.com"})
+
+	_, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "test.example.com"})
+	require.NotNil(t, err, "could get certificate before loading one")
+
+	wildcard := newTestCertificate(t, "*.example.com", 1, now, now.Add(90*24*time.Hour))
+	apex := newTestCertificate(t, "example.com", 2, now, now.Add(90*24*time.Hour))
+	manager.store("*.example.com", wildcard)
+	manager.store("example.com", apex)
+
+	certificate, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "Test.Example.com."})
+	require.Nil(t, err, "could not get wildcard certificate")
+	require.Equal(t, wildcard, certificate, "could not match wildcard certificate")
+	certificate, _ = manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	require.Equal(t, apex, certificate, "could not match apex certificate")
+	certificate, _ = manager.GetCertificate(&tls.ClientHelloInfo{})
+	require.Equal(t, wildcard, certificate, "could not fall back to default certificate")
+
+	renewed := newTestCertificate(t, "*.example.com", 3, now, now.Add(90*24*time.Hour))
+	manager.store("*.example.com", renewed)
+	certificate, _ = manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "test.example.com"})
+	require.Equal(t, renewed, certificate, "could not swap renewed certificate")
+
+	require.False(t, manager.needsRenewal(renewed.Leaf, now.Add(59*24*time.Hour)), "certificate needs renewal early")
+	require.True(t, manager.needsRenewal(renewed.Leaf, now.Add(61*24*time.Hour)), "certificate doesn't need renewal in window")
+}
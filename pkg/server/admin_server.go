@@ -0,0 +1,477 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/projectdiscovery/gologger"
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+)
+
+// AdminServer is an admin http server exposing the prometheus
+// metrics, the health and readiness endpoints, the storage
+// statistics, the injection
+// of interactions by external integrations and the session
+// management api.
+type AdminServer struct {
+	options *Options
+	server  http.Server
+}
+
+// NewAdminServer returns a new admin server.
+func NewAdminServer(options *Options) (*AdminServer, error) {
+	server := &AdminServer{options: options}
+
+	router := &http.ServeMux{}
+	router.HandleFunc("/metrics", server.metricsHandler)
+	router.HandleFunc("/healthz", server.healthzHandler)
+	router.HandleFunc("/readyz", server.readyzHandler)
+	// the storage statistics and the usage of the sessions require the admin token, if any
+	router.Handle("/storage", server.adminTokenMiddleware(http.HandlerFunc(server.storageHandler)))
+	if options.Accounting != nil {
+		router.Handle("/sessions", server.adminTokenMiddleware(http.HandlerFunc(server.sessionsHandler)))
+	}
+	if options.Inject {
+		router.Handle("/inject", options.auditMiddleware("inject", http.HandlerFunc(server.injectHandler)))
+	}
+	if options.AdminToken != "" {
+		router.Handle("/api/sessions", options.auditMiddleware("admin-sessions", server.apiMiddleware(http.HandlerFunc(server.apiSessionsHandler))))
+		router.Handle("/api/sessions/", options.auditMiddleware("admin-session", server.apiMiddleware(http.HandlerFunc(server.apiSessionHandler))))
+		router.Handle("/api/token", options.auditMiddleware("admin-token", server.apiMiddleware(http.HandlerFunc(server.apiTokenHandler))))
+		router.Handle("/api/tokens", options.auditMiddleware("admin-tokens", server.apiMiddleware(http.HandlerFunc(server.apiTokensHandler))))
+		router.Handle("/api/tokens/", options.auditMiddleware("admin-tenant-token", server.apiMiddleware(http.HandlerFunc(server.apiTenantTokenHandler))))
+		router.Handle("/api/export", options.auditMiddleware("admin-export", server.apiMiddleware(http.HandlerFunc(server.apiExportHandler))))
+		router.Handle("/api/import", options.auditMiddleware("admin-import", server.apiMiddleware(http.HandlerFunc(server.apiImportHandler))))
+		if options.Revocations != nil {
+			router.Handle("/api/revocations", options.auditMiddleware("admin-revocations", server.apiMiddleware(http.HandlerFunc(server.apiRevocationsHandler))))
+			router.Handle("/api/revocations/", options.auditMiddleware("admin-revocation", server.apiMiddleware(http.HandlerFunc(server.apiRevocationHandler))))
+		}
+	}
+	server.server = http.Server{Addr: fmt.Sprintf("%s:%d", options.ListenIP, options.AdminPort), Handler: router}
+	return server, nil
+}
+
+// ListenAndServe listens on the admin port for the server.
+func (h *AdminServer) ListenAndServe(adminAlive chan bool) {
+	adminAlive <- true
+	if err := h.server.ListenAndServe(); err != nil {
+		gologger.Error().Msgf("Could not serve admin: %s\n", err)
+		adminAlive <- false
+	}
+}
+
+func (h *AdminServer) metricsHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	h.options.Metrics.WritePrometheus(w, h.options)
+}
+
+// healthzHandler reports the server process is alive
+func (h *AdminServer) healthzHandler(w http.ResponseWriter, req *http.Request) {
+	jsonMsg(w, "ok", http.StatusOK)
+}
+
+// ReadinessResponse is the response of the readiness endpoint
+type ReadinessResponse struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// readyzHandler reports whether all the enabled listeners are bound
+// and the acme certificate is valid
+func (h *AdminServer) readyzHandler(w http.ResponseWriter, req *http.Request) {
+	ready, checks := h.options.Health.Ready()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = jsoniter.NewEncoder(w).Encode(&ReadinessResponse{Ready: ready, Checks: checks})
+}
+
+// StorageResponse is the response of the storage endpoint
+type StorageResponse struct {
+	*storage.CacheMetrics
+	// HeapBytes is the heap memory allocated by the server
+	HeapBytes uint64 `json:"heap-bytes"`
+}
+
+// storageHandler returns the sessions, interactions, evictions and memory
+// or disk usage of the storage
+func (h *AdminServer) storageHandler(w http.ResponseWriter, req *http.Request) {
+	memStats := &runtime.MemStats{}
+	runtime.ReadMemStats(memStats)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = jsoniter.NewEncoder(w).Encode(&StorageResponse{CacheMetrics: h.options.Storage.GetCacheMetrics(), HeapBytes: memStats.HeapAlloc})
+}
+
+// injectHandler stores the interaction of the request body observed by an
+// external integration, the token is required when authentication is enabled.
+func (h *AdminServer) injectHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.options.Auth && !h.options.isServerToken(req.Header.Get("Authorization")) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	setAuditActor(req, h.options.actorOf(nil))
+
+	interaction := &Interaction{}
+	if err := jsoniter.NewDecoder(req.Body).Decode(interaction); err != nil {
+		jsonError(w, fmt.Sprintf("could not decode json body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := h.options.InjectInteraction(interaction); err == ErrSessionNotFound {
+		jsonError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		jsonError(w, fmt.Sprintf("could not inject interaction: %s", err), http.StatusBadRequest)
+		return
+	}
+	jsonMsg(w, "interaction injected", http.StatusOK)
+}
+
+// sessionsHandler returns the traffic accounted for all the sessions
+// or the one of the id query parameter.
+func (h *AdminServer) sessionsHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if id := req.URL.Query().Get("id"); id != "" {
+		usage, ok := h.options.Accounting.Get(id)
+		if !ok {
+			jsonError(w, "session not found", http.StatusNotFound)
+			return
+		}
+		_ = jsoniter.NewEncoder(w).Encode(usage)
+		return
+	}
+	_ = jsoniter.NewEncoder(w).Encode(h.options.Accounting.List())
+}
+
+// adminTokenMiddleware requires the admin token like the session management
+// api once it's set, the endpoints being open otherwise
+func (h *AdminServer) adminTokenMiddleware(next http.Handler) http.Handler {
+	if h.options.AdminToken == "" {
+		return next
+	}
+	return h.apiMiddleware(next)
+}
+
+// apiMiddleware requires the admin token, or the token of a tenant with
+// the admin scope, for the session management api
+func (h *AdminServer) apiMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := req.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.options.AdminToken)) == 1 {
+			setAuditActor(req, "admin")
+		} else if tenant, ok := h.options.Tokens.Lookup(token); ok && tenant.allows(ScopeAdmin) {
+			setAuditActor(req, tenant.Name)
+		} else {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		next.ServeHTTP(w, req)
+	})
+}
+
+// SessionResponse is a registered session returned by the session management api
+type SessionResponse struct {
+	storage.SessionInfo
+	// Tenant is the name of the tenant which registered the session, if any
+	Tenant string `json:"tenant,omitempty"`
+	// Usage is the traffic accounted for the session
+	Usage *SessionUsage `json:"usage,omitempty"`
+}
+
+func (h *AdminServer) sessionResponse(session storage.SessionInfo) *SessionResponse {
+	response := &SessionResponse{SessionInfo: session, Tenant: h.options.Tokens.Owner(session.CorrelationID)}
+	if usage, ok := h.options.Accounting.Get(session.CorrelationID); ok {
+		usage.CorrelationID = ""
+		response.Usage = &usage
+	}
+	return response
+}
+
+// apiSessionsHandler lists the registered sessions
+func (h *AdminServer) apiSessionsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sessions := h.options.Storage.ListSessions()
+	response := make([]*SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, h.sessionResponse(session))
+	}
+	_ = jsoniter.NewEncoder(w).Encode(response)
+}
+
+// apiSessionHandler returns (GET) or deregisters (DELETE) the session of the path
+func (h *AdminServer) apiSessionHandler(w http.ResponseWriter, req *http.Request) {
+	correlationID := strings.TrimPrefix(req.URL.Path, "/api/sessions/")
+	switch req.Method {
+	case http.MethodGet:
+		session, ok := h.options.Storage.GetSession(correlationID)
+		if !ok {
+			jsonError(w, "session not found", http.StatusNotFound)
+			return
+		}
+		_ = jsoniter.NewEncoder(w).Encode(h.sessionResponse(session))
+	case http.MethodDelete:
+		if err := h.options.Storage.RemoveSession(correlationID); err != nil {
+			jsonError(w, "session not found", http.StatusNotFound)
+			return
+		}
+		h.options.sessionRemoved(correlationID)
+		h.options.Cluster.Deregister(correlationID)
+		gologger.Info().Msgf("Deregistered correlationID %s from the admin api\n", correlationID)
+		jsonMsg(w, "deregistration successful", http.StatusOK)
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// sessionsExportVersion is the version of the format of the exported sessions
+const sessionsExportVersion = 1
+
+// SessionsExport is the export of the registered sessions, imported by
+// another server
+type SessionsExport struct {
+	Version    int                `json:"version"`
+	ExportedAt time.Time          `json:"exported-at"`
+	Sessions   []*ExportedSession `json:"sessions"`
+}
+
+// ExportedSession is the registration of a session with its settings
+type ExportedSession struct {
+	storage.SessionExport
+	DNSAnswers    *DNSAnswers   `json:"dns-answers,omitempty"`
+	HTTPChallenge string        `json:"http-challenge,omitempty"`
+	HTTPResponses HTTPResponses `json:"http-responses,omitempty"`
+	HostedFiles   []*HostedFile `json:"hosted-files,omitempty"`
+}
+
+// ImportResponse is the result of an import of sessions
+type ImportResponse struct {
+	// Imported is the number of imported sessions
+	Imported int `json:"imported"`
+	// Skipped are the errors of the sessions not imported per correlation-id,
+	// eg. already registered or expired
+	Skipped map[string]string `json:"skipped,omitempty"`
+}
+
+// apiExportHandler exports the registered sessions with their keys and settings
+func (h *AdminServer) apiExportHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	export := &SessionsExport{Version: sessionsExportVersion, ExportedAt: time.Now(), Sessions: []*ExportedSession{}}
+	for _, session := range h.options.Storage.ListSessions() {
+		if exported, ok := h.options.exportSession(session.CorrelationID); ok {
+			export.Sessions = append(export.Sessions, exported)
+		}
+	}
+	gologger.Info().Msgf("Exported %d sessions from the admin api\n", len(export.Sessions))
+	_ = jsoniter.NewEncoder(w).Encode(export)
+}
+
+// apiImportHandler imports the sessions exported by another server
+func (h *AdminServer) apiImportHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	export := &SessionsExport{}
+	if err := jsoniter.NewDecoder(req.Body).Decode(export); err != nil {
+		jsonError(w, fmt.Sprintf("could not decode json body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if export.Version != sessionsExportVersion {
+		jsonError(w, fmt.Sprintf("unsupported export version %d", export.Version), http.StatusBadRequest)
+		return
+	}
+	response := &ImportResponse{}
+	for _, session := range export.Sessions {
+		if err := h.options.importSession(session); err != nil {
+			if response.Skipped == nil {
+				response.Skipped = make(map[string]string)
+			}
+			response.Skipped[session.CorrelationID] = err.Error()
+			continue
+		}
+		h.options.Cluster.Import(session)
+		response.Imported++
+	}
+	gologger.Info().Msgf("Imported %d sessions from the admin api\n", response.Imported)
+	_ = jsoniter.NewEncoder(w).Encode(response)
+}
+
+// TokenRequest is the request rotating the server token, a random
+// token being generated when none is specified
+type TokenRequest struct {
+	Token string `json:"token,omitempty"`
+	// Grace is the number of seconds the previous token is still accepted for
+	Grace int64 `json:"grace,omitempty"`
+}
+
+// apiTokenHandler rotates the token required by the clients
+func (h *AdminServer) apiTokenHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.options.Auth {
+		jsonError(w, "authentication is disabled", http.StatusBadRequest)
+		return
+	}
+	r := &TokenRequest{}
+	if err := jsoniter.NewDecoder(req.Body).Decode(r); err != nil && err != io.EOF {
+		jsonError(w, fmt.Sprintf("could not decode json body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if r.Grace < 0 {
+		jsonError(w, fmt.Sprintf("invalid grace %d", r.Grace), http.StatusBadRequest)
+		return
+	}
+	if r.Token == "" {
+		token, err := newToken()
+		if err != nil {
+			jsonError(w, "could not generate token", http.StatusInternalServerError)
+			return
+		}
+		r.Token = token
+	}
+	if err := h.options.SetToken(r.Token, time.Duration(r.Grace)*time.Second); err != nil {
+		jsonError(w, fmt.Sprintf("could not set token: %s", err), http.StatusInternalServerError)
+		return
+	}
+	gologger.Info().Msgf("Client Token rotated from the admin api\n")
+	_ = jsoniter.NewEncoder(w).Encode(r)
+}
+
+// IssueTokenRequest is the request issuing the token of a new tenant
+type IssueTokenRequest struct {
+	Name                 string   `json:"name"`
+	MaxSessions          int      `json:"max-sessions,omitempty"`
+	RegistrationsPerHour int      `json:"registrations-per-hour,omitempty"`
+	PollsPerMinute       int      `json:"polls-per-minute,omitempty"`
+	Scopes               []string `json:"scopes,omitempty"`
+	// TTL is the number of seconds the token is accepted for (0 for no expiry)
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// TenantResponse is a tenant returned by the token api with its number of sessions
+type TenantResponse struct {
+	*Tenant
+	Sessions int `json:"sessions"`
+}
+
+// apiTokensHandler lists the tenants (GET) or issues the token of a new tenant (POST)
+func (h *AdminServer) apiTokensHandler(w http.ResponseWriter, req *http.Request) {
+	if h.options.Tokens == nil {
+		jsonError(w, "authentication is disabled", http.StatusBadRequest)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		tenants := h.options.Tokens.Tenants()
+		response := make([]*TenantResponse, 0, len(tenants))
+		for _, tenant := range tenants {
+			response = append(response, &TenantResponse{Tenant: tenant, Sessions: h.options.Tokens.Sessions(tenant.Name)})
+		}
+		_ = jsoniter.NewEncoder(w).Encode(response)
+	case http.MethodPost:
+		r := &IssueTokenRequest{}
+		if err := jsoniter.NewDecoder(req.Body).Decode(r); err != nil {
+			jsonError(w, fmt.Sprintf("could not decode json body: %s", err), http.StatusBadRequest)
+			return
+		}
+		if r.TTL < 0 {
+			jsonError(w, fmt.Sprintf("invalid ttl %d", r.TTL), http.StatusBadRequest)
+			return
+		}
+		template := Tenant{Name: r.Name, MaxSessions: r.MaxSessions, RegistrationsPerHour: r.RegistrationsPerHour, PollsPerMinute: r.PollsPerMinute, Scopes: r.Scopes}
+		tenant, err := h.options.Tokens.Issue(template, time.Duration(r.TTL)*time.Second)
+		if err != nil {
+			jsonError(w, fmt.Sprintf("could not issue token: %s", err), http.StatusBadRequest)
+			return
+		}
+		gologger.Info().Msgf("Issued token for tenant %s from the admin api\n", tenant.Name)
+		_ = jsoniter.NewEncoder(w).Encode(tenant)
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// apiTenantTokenHandler revokes (DELETE) the token issued for the tenant of the path
+func (h *AdminServer) apiTenantTokenHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, "/api/tokens/")
+	if h.options.Tokens == nil || !h.options.Tokens.Revoke(name) {
+		jsonError(w, "issued token not found", http.StatusNotFound)
+		return
+	}
+	gologger.Info().Msgf("Revoked token of tenant %s from the admin api\n", name)
+	jsonMsg(w, "token revoked", http.StatusOK)
+}
+
+// apiRevocationsHandler lists the revoked correlation ids (GET) or revokes
+// one (POST), deregistering its session if registered
+func (h *AdminServer) apiRevocationsHandler(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		_ = jsoniter.NewEncoder(w).Encode(h.options.Revocations.List())
+	case http.MethodPost:
+		revocation := &Revocation{}
+		if err := jsoniter.NewDecoder(req.Body).Decode(revocation); err != nil {
+			jsonError(w, fmt.Sprintf("could not decode json body: %s", err), http.StatusBadRequest)
+			return
+		}
+		revocation.RevokedAt = time.Time{}
+		if err := h.options.Revocations.Revoke(revocation); err != nil {
+			jsonError(w, fmt.Sprintf("could not revoke correlation-id: %s", err), http.StatusBadRequest)
+			return
+		}
+		if err := h.options.Storage.RemoveSession(revocation.CorrelationID); err == nil {
+			h.options.sessionRemoved(revocation.CorrelationID)
+			h.options.Cluster.Deregister(revocation.CorrelationID)
+		}
+		gologger.Info().Msgf("Revoked correlationID %s from the admin api\n", revocation.CorrelationID)
+		_ = jsoniter.NewEncoder(w).Encode(revocation)
+	default:
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// apiRevocationHandler lifts (DELETE) the revocation of the correlation id of the path
+func (h *AdminServer) apiRevocationHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	correlationID := strings.TrimPrefix(req.URL.Path, "/api/revocations/")
+	ok, err := h.options.Revocations.Unrevoke(correlationID)
+	if err != nil {
+		jsonError(w, fmt.Sprintf("could not lift revocation: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		jsonError(w, "revocation not found", http.StatusNotFound)
+		return
+	}
+	gologger.Info().Msgf("Lifted revocation of correlationID %s from the admin api\n", correlationID)
+	jsonMsg(w, "revocation lifted", http.StatusOK)
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminServerInject(t *testing.T) {
+	store, _ := newTestSession(t)
+	server, err := NewAdminServer(&Options{Storage: store, Inject: true, Auth: true, Token: "token"})
+	require.Nil(t, err, "could not create admin server")
+
+	inject := func(token, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/inject", strings.NewReader(body))
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	body := `{"protocol":"ssh","unique-id":"C23B2LA0KL1KRJCRDJ10CNDMNIOYYYYYN","remote-address":"203.0.113.5","source":"honeypot"}`
+	require.Equal(t, http.StatusUnauthorized, inject("", body), "injected without token")
+	require.Equal(t, http.StatusOK, inject("token", body), "could not inject interaction")
+	require.Equal(t, http.StatusNotFound, inject("token", `{"protocol":"ssh","unique-id":"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}`), "injected for unregistered session")
+	require.Equal(t, http.StatusBadRequest, inject("token", `{"protocol":"ssh","unique-id":"short"}`), "injected invalid unique id")
+
+	data, _, err := store.GetInteractions("c23b2la0kl1krjcrdj10", "secret")
+	require.Nil(t, err, "could not get interactions")
+	require.Len(t, data, 1, "could not get injected interaction")
+}
+
+func TestAdminServerSessions(t *testing.T) {
+	store, _ := newTestSession(t)
+	options := &Options{Storage: store, AdminToken: "admin", Auth: true, Token: "token"}
+	server, err := NewAdminServer(options)
+	require.Nil(t, err, "could not create admin server")
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/sessions", "token", "").Code, "listed sessions with client token")
+
+	rec := do(http.MethodGet, "/api/sessions", "admin", "")
+	require.Equal(t, http.StatusOK, rec.Code, "could not list sessions")
+	require.Contains(t, rec.Body.String(), `"correlation-id":"c23b2la0kl1krjcrdj10"`, "could not list session")
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/api/sessions/c23b2la0kl1krjcrdj10", "admin", "").Code, "could not get session")
+
+	rec = do(http.MethodPost, "/api/token", "admin", `{"token":"rotated"}`)
+	require.Equal(t, http.StatusOK, rec.Code, "could not rotate token")
+	require.Equal(t, "rotated", options.GetToken(), "could not set rotated token")
+	require.False(t, options.isServerToken("token"), "accepted previous token without grace")
+	rec = do(http.MethodPost, "/api/token", "admin", `{"grace":3600}`)
+	require.Equal(t, http.StatusOK, rec.Code, "could not generate token")
+	require.Len(t, options.GetToken(), 64, "could not set generated token")
+	require.True(t, options.isServerToken("rotated"), "could not accept previous token during grace")
+	require.True(t, options.isServerToken(options.GetToken()), "could not accept rotated token")
+	require.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/token", "admin", `{"grace":-1}`).Code, "rotated token with invalid grace")
+
+	require.Equal(t, http.StatusOK, do(http.MethodDelete, "/api/sessions/c23b2la0kl1krjcrdj10", "admin", "").Code, "could not deregister session")
+	require.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/sessions/c23b2la0kl1krjcrdj10", "admin", "").Code, "got deregistered session")
+	require.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/sessions/c23b2la0kl1krjcrdj10", "admin", "").Code, "deregistered session twice")
+}
+
+func TestAdminServerTokens(t *testing.T) {
+	store := storage.New(time.Hour)
+	tokens, err := NewTokenStore([]*Tenant{{Name: "red", Token: "red-token"}, {Name: "ops", Token: "ops-token", Scopes: []string{ScopeAdmin}}}, store.HasID)
+	require.Nil(t, err, "could not create token store")
+	options := &Options{Storage: store, AdminToken: "admin", Auth: true, Token: "token", Tokens: tokens}
+	server, err := NewAdminServer(options)
+	require.Nil(t, err, "could not create admin server")
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "admin")
+		rec := httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/tokens", `{"name":"blue","max-sessions":5,"ttl":3600}`)
+	require.Equal(t, http.StatusOK, rec.Code, "could not issue token")
+	issued := &Tenant{}
+	require.Nil(t, jsoniter.NewDecoder(rec.Body).Decode(issued), "could not decode issued token")
+	require.Len(t, issued.Token, 64, "could not generate issued token")
+	require.NotNil(t, issued.ExpiresAt, "could not set expiry of issued token")
+	tenant, ok := options.authenticate(issued.Token)
+	require.True(t, ok, "could not authenticate with issued token")
+	require.Equal(t, "blue", tenant.Name, "could not authenticate tenant of issued token")
+	require.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/tokens", `{"name":"red"}`).Code, "issued token of configured tenant")
+	require.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/tokens", `{"name":"green","scopes":["write"]}`).Code, "issued token with invalid scope")
+
+	// the tenants with the admin scope can use the api
+	for token, code := range map[string]int{"ops-token": http.StatusOK, "red-token": http.StatusUnauthorized, issued.Token: http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodGet, "/api/tokens", nil)
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(rec, req)
+		require.Equal(t, code, rec.Code, "could not restrict api to admin scope")
+	}
+
+	rec = do(http.MethodGet, "/api/tokens", "")
+	require.Equal(t, http.StatusOK, rec.Code, "could not list tokens")
+	require.Contains(t, rec.Body.String(), `"name":"blue"`, "could not list issued token")
+	require.Contains(t, rec.Body.String(), `"name":"red"`, "could not list configured token")
+	require.NotContains(t, rec.Body.String(), issued.Token, "listed token")
+
+	require.Equal(t, http.StatusOK, do(http.MethodDelete, "/api/tokens/blue", "").Code, "could not revoke token")
+	_, ok = options.authenticate(issued.Token)
+	require.False(t, ok, "authenticated with revoked token")
+	require.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/tokens/red", "").Code, "revoked configured token")
+
+	// the tokens expire at their ttl
+	expiresAt := time.Now().Add(-time.Second)
+	require.Nil(t, tokens.SetTenants([]*Tenant{{Name: "red", Token: "red-token", ExpiresAt: &expiresAt}}), "could not set tenants")
+	_, ok = options.authenticate("red-token")
+	require.False(t, ok, "authenticated with expired token")
+}
+
+func TestAdminServerStorage(t *testing.T) {
+	store := storage.New(time.Hour)
+	require.Nil(t, store.SetID("token"), "could not set id")
+	require.Nil(t, store.AddInteractionWithId("token", []byte("interaction")), "could not add interaction")
+	server, err := NewAdminServer(&Options{Storage: store})
+	require.Nil(t, err, "could not create admin server")
+
+	rec := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/storage", nil))
+	require.Equal(t, http.StatusOK, rec.Code, "could not get storage statistics")
+	require.Contains(t, rec.Body.String(), `"pending":1,`, "could not get pending interactions")
+	require.Contains(t, rec.Body.String(), `"heap-bytes":`, "could not get heap memory")
+}
+
+func TestAdminServerStorageToken(t *testing.T) {
+	store := storage.New(time.Hour)
+	options := &Options{Storage: store, Accounting: NewAccounting(store.HasID), AdminToken: "admin"}
+	server, err := NewAdminServer(options)
+	require.Nil(t, err, "could not create admin server")
+
+	for _, path := range []string{"/storage", "/sessions"} {
+		rec := httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusUnauthorized, rec.Code, "could not require admin token for %s", path)
+
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "admin")
+		rec = httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, "could not get %s with admin token", path)
+	}
+}
+
+func TestAdminServerExportImport(t *testing.T) {
+	encoded := encodedTestPublicKey(t)
+
+	source := &Options{Storage: storage.New(time.Hour), AdminToken: "admin"}
+	source.SessionChallenges = NewSessionChallenges(source.Storage.HasID)
+	require.Nil(t, source.Storage.SetIDPublicKey("c23b2la0kl1krjcrdj10", "secret", encoded), "could not register session")
+	source.SessionChallenges.Set("c23b2la0kl1krjcrdj10", "basic")
+	source.HostedFiles = NewHostedFiles(32, time.Hour, source.Storage.HasID)
+	require.Nil(t, source.HostedFiles.Put("c23b2la0kl1krjcrdj10", "x.svg", "image/svg+xml", []byte("<svg/>"), 0), "could not host file")
+	destination := &Options{Storage: storage.New(time.Hour), AdminToken: "admin"}
+	destination.SessionChallenges = NewSessionChallenges(destination.Storage.HasID)
+	destination.HostedFiles = NewHostedFiles(32, time.Hour, destination.Storage.HasID)
+
+	do := func(options *Options, method, path, body string) *httptest.ResponseRecorder {
+		server, err := NewAdminServer(options)
+		require.Nil(t, err, "could not create admin server")
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "admin")
+		rec := httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := do(source, http.MethodGet, "/api/export", "")
+	require.Equal(t, http.StatusOK, rec.Code, "could not export sessions")
+	export := rec.Body.String()
+	require.Contains(t, export, `"correlation-id":"c23b2la0kl1krjcrdj10"`, "could not export session")
+
+	rec = do(destination, http.MethodPost, "/api/import", export)
+	require.Equal(t, http.StatusOK, rec.Code, "could not import sessions")
+	require.Contains(t, rec.Body.String(), `"imported":1`, "could not import session")
+	require.True(t, destination.Storage.HasID("c23b2la0kl1krjcrdj10"), "could not register imported session")
+	require.Equal(t, "basic", destination.SessionChallenges.Get("c23b2la0kl1krjcrdj10"), "could not import http challenge")
+	file := destination.HostedFiles.Get("c23b2la0kl1krjcrdj10", "x.svg")
+	require.NotNil(t, file, "could not import hosted file")
+	require.Equal(t, "<svg/>", string(file.data), "could not import hosted file data")
+	require.Equal(t, "image/svg+xml", file.contentType, "could not import hosted file content type")
+	sourceKey, _ := source.Storage.GetAESKey("c23b2la0kl1krjcrdj10", "secret")
+	destinationKey, err := destination.Storage.GetAESKey("c23b2la0kl1krjcrdj10", "secret")
+	require.Nil(t, err, "could not get imported aes key")
+	require.Equal(t, sourceKey, destinationKey, "could not keep aes key")
+
+	rec = do(destination, http.MethodPost, "/api/import", export)
+	require.Contains(t, rec.Body.String(), `"imported":0,"skipped":{"c23b2la0kl1krjcrdj10":`, "could import session twice")
+	require.Equal(t, http.StatusBadRequest, do(destination, http.MethodPost, "/api/import", `{"version":2}`).Code, "could import unsupported version")
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+)
+
+// maxAuditPeek is the number of bytes of the request bodies the correlation
+// id of the audited operations is read from
+const maxAuditPeek = 64 * 1024
+
+// AuditEntry is a control-plane operation written to the audit log.
+type AuditEntry struct {
+	// Timestamp is the time the operation has been requested at
+	Timestamp time.Time `json:"timestamp"`
+	// Action is the operation (eg. register, poll, deregister or admin-export)
+	Action string `json:"action"`
+	// Actor is the tenant of the token, server for the server token or admin for the admin token
+	Actor string `json:"actor,omitempty"`
+	// RemoteAddress is the source ip of the request
+	RemoteAddress string `json:"remote-address"`
+	// CorrelationID is the correlation id of the session of the operation, if any
+	CorrelationID string `json:"correlation-id,omitempty"`
+	// Method and Path are the method and path of the request
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Status is the status code of the response
+	Status int `json:"status"`
+}
+
+// AuditLog appends the control-plane operations to daily files
+// (<directory>/<yyyy-mm-dd>.jsonl), removing the files older than
+// its retention, so that the operators of shared servers can
+// investigate misuse.
+type AuditLog struct {
+	sync.Mutex
+	directory string
+	retention time.Duration
+
+	date string
+	file *os.File
+}
+
+// NewAuditLog returns a new audit log writing to the directory, keeping the
+// files for the retention if positive.
+func NewAuditLog(directory string, retention time.Duration) (*AuditLog, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, errors.Wrap(err, "could not create audit log directory")
+	}
+	return &AuditLog{directory: directory, retention: retention}, nil
+}
+
+// Write appends an entry to the file of the current date.
+func (a *AuditLog) Write(entry *AuditEntry) error {
+	data, err := jsoniter.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "could not encode audit log entry")
+	}
+	data = append(data, '\n')
+
+	a.Lock()
+	defer a.Unlock()
+
+	now := time.Now().UTC()
+	if date := now.Format(diskLogDateLayout); date != a.date {
+		if err := a.open(date); err != nil {
+			return err
+		}
+		a.prune(now)
+	}
+	_, err = a.file.Write(data)
+	return err
+}
+
+// open opens the file of a date, closing the previous one
+func (a *AuditLog) open(date string) error {
+	if a.file != nil {
+		_ = a.file.Close()
+		a.file = nil
+	}
+	file, err := os.OpenFile(filepath.Join(a.directory, date+".jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrap(err, "could not open audit log file")
+	}
+	a.file = file
+	a.date = date
+	return nil
+}
+
+// prune removes the files of the dates older than the retention
+func (a *AuditLog) prune(now time.Time) {
+	if a.retention <= 0 {
+		return
+	}
+	files, err := ioutil.ReadDir(a.directory)
+	if err != nil {
+		gologger.Error().Msgf("Could not list audit log files: %s\n", err)
+		return
+	}
+	for _, file := range files {
+		date, err := time.Parse(diskLogDateLayout, strings.TrimSuffix(file.Name(), ".jsonl"))
+		if err != nil || file.IsDir() || !strings.HasSuffix(file.Name(), ".jsonl") {
+			continue
+		}
+		if date.Add(24 * time.Hour).Before(now.Add(-a.retention)) {
+			if err := os.Remove(filepath.Join(a.directory, file.Name())); err != nil {
+				gologger.Error().Msgf("Could not remove audit log file: %s\n", err)
+			}
+		}
+	}
+}
+
+// Close closes the current file of the audit log.
+func (a *AuditLog) Close() error {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	a.date = ""
+	return err
+}
+
+// auditContextKey is the request context key of the audit entry of a request
+type auditContextKey struct{}
+
+// auditResponseWriter records the status code of an audited response
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *auditResponseWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Hijack takes over the connection of the websocket upgrades
+func (w *auditResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection can't be hijacked")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// auditMiddleware writes the requests of a control-plane operation to the
+// audit log along with their actor and status, if enabled.
+func (options *Options) auditMiddleware(action string, next http.Handler) http.Handler {
+	if options.AuditLog == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+		entry := &AuditEntry{Timestamp: time.Now(), Action: action, RemoteAddress: host, CorrelationID: auditCorrelationID(req), Method: req.Method, Path: req.URL.Path}
+		recorder := &auditResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, req.WithContext(context.WithValue(req.Context(), auditContextKey{}, entry)))
+		entry.Status = recorder.status
+		if err := options.AuditLog.Write(entry); err != nil {
+			gologger.Error().Msgf("Could not write audit log: %s\n", err)
+		}
+	})
+}
+
+// setAuditActor records the actor of the audited operation of a request, if any
+func setAuditActor(req *http.Request, actor string) {
+	if entry, ok := req.Context().Value(auditContextKey{}).(*AuditEntry); ok {
+		entry.Actor = actor
+	}
+}
+
+// actorOf returns the actor of the operations authenticated for a tenant
+func (options *Options) actorOf(tenant *Tenant) string {
+	if tenant != nil {
+		return tenant.Name
+	}
+	if options.Auth {
+		return "server"
+	}
+	return ""
+}
+
+// auditCorrelationID returns the correlation id of the session of a request,
+// either the id query parameter, the session of the path of the hosted files
+// and the admin api or the correlation-id of the json body.
+func auditCorrelationID(req *http.Request) string {
+	if id := req.URL.Query().Get("id"); id != "" {
+		return id
+	}
+	for _, prefix := range []string{"/s/", "/api/sessions/", "/api/revocations/"} {
+		if path := strings.TrimPrefix(req.URL.Path, prefix); path != req.URL.Path {
+			return strings.SplitN(path, "/", 2)[0]
+		}
+	}
+	if req.Body == nil || req.Method != http.MethodPost {
+		return ""
+	}
+	peeked, err := ioutil.ReadAll(io.LimitReader(req.Body, maxAuditPeek))
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peeked), req.Body), req.Body}
+	if err != nil {
+		return ""
+	}
+	return jsoniter.Get(peeked, "correlation-id").ToString()
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	encoded := encodedTestPublicKey(t)
+
+	directory := t.TempDir()
+	expired := filepath.Join(directory, time.Now().UTC().AddDate(0, 0, -10).Format(diskLogDateLayout)+".jsonl")
+	require.Nil(t, ioutil.WriteFile(expired, []byte("{}\n"), 0600), "could not write expired audit log file")
+	auditLog, err := NewAuditLog(directory, 7*24*time.Hour)
+	require.Nil(t, err, "could not create audit log")
+
+	store := storage.New(time.Hour)
+	tokens, err := NewTokenStore([]*Tenant{{Name: "red", Token: "red-token"}}, store.HasID)
+	require.Nil(t, err, "could not create token store")
+	options := &Options{Domain: "example.com", Storage: store, Auth: true, Token: "server-token", Tokens: tokens, AuditLog: auditLog}
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+	do := func(method, path, token, body string) int {
+		req := httptest.NewRequest(method, "http://example.com"+path, strings.NewReader(body))
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		server.nontlsserver.Handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	register := `{"public-key":"` + encoded + `","secret-key":"secret","correlation-id":"c23b2la0kl1krjcrdj10"}`
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/register", "red-token", register), "could not register")
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/poll?id=c23b2la0kl1krjcrdj10&secret=secret", "invalid", ""), "could poll with invalid token")
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/deregister", "server-token", `{"correlation-id":"c23b2la0kl1krjcrdj10","secret-key":"secret"}`), "could not deregister")
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/", "", ""), "could not make interaction")
+	require.Nil(t, auditLog.Close(), "could not close audit log")
+
+	_, err = os.Stat(expired)
+	require.True(t, os.IsNotExist(err), "could not remove expired audit log file")
+	data, err := ioutil.ReadFile(filepath.Join(directory, time.Now().UTC().Format(diskLogDateLayout)+".jsonl"))
+	require.Nil(t, err, "could not read audit log file")
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3, "could not audit the control-plane operations only")
+
+	var entries []AuditEntry
+	for _, line := range lines {
+		var entry AuditEntry
+		require.Nil(t, jsoniter.Unmarshal([]byte(line), &entry), "could not decode entry")
+		entries = append(entries, entry)
+	}
+	require.Equal(t, AuditEntry{Timestamp: entries[0].Timestamp, Action: "register", Actor: "red", RemoteAddress: "192.0.2.1", CorrelationID: "c23b2la0kl1krjcrdj10", Method: http.MethodPost, Path: "/register", Status: http.StatusOK}, entries[0], "could not audit registration")
+	require.Equal(t, "", entries[1].Actor, "could audit actor of rejected poll")
+	require.Equal(t, http.StatusUnauthorized, entries[1].Status, "could not audit status of rejected poll")
+	require.Equal(t, "c23b2la0kl1krjcrdj10", entries[1].CorrelationID, "could not audit correlation id of poll")
+	require.Equal(t, "server", entries[2].Actor, "could not audit server token actor")
+	require.Equal(t, "c23b2la0kl1krjcrdj10", entries[2].CorrelationID, "could not audit correlation id of deregistration")
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+)
+
+// catchAllReadTimeout is the maximum time waited for the first bytes of a connection
+const catchAllReadTimeout = 5 * time.Second
+
+// CatchAllServer is a raw tcp & udp server instance that listens on
+// a range of ports recording the first bytes of any connection.
+type CatchAllServer struct {
+	options *Options
+
+	sync.Mutex
+	closed    bool
+	listeners []io.Closer
+}
+
+// NewCatchAllServer returns a new raw tcp & udp catch-all server.
+func NewCatchAllServer(options *Options) (*CatchAllServer, error) {
+	server := &CatchAllServer{options: options}
+	return server, nil
+}
+
+// ListenAndServe listens on all the configured catch-all ports.
+//
+// Ports that can't be bound (for example because another service
+// already uses them) are skipped with a warning, the server being reported
+// down if none of them could be.
+func (h *CatchAllServer) ListenAndServe(catchAllAlive chan bool) {
+	wg := &sync.WaitGroup{}
+	listening := false
+	for _, port := range h.options.CatchAllPorts {
+		address := fmt.Sprintf("%s:%d", h.options.ListenIP, port)
+
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			gologger.Warning().Msgf("Could not listen for catch-all on tcp port %d: %s\n", port, err)
+		} else if h.track(listener) {
+			listening = true
+			wg.Add(1)
+			go func(listener net.Listener) {
+				defer wg.Done()
+				h.serveTCP(listener)
+			}(listener)
+		}
+
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			gologger.Warning().Msgf("Could not listen for catch-all on udp port %d: %s\n", port, err)
+		} else if h.track(conn) {
+			listening = true
+			wg.Add(1)
+			go func(conn net.PacketConn) {
+				defer wg.Done()
+				h.serveUDP(conn)
+			}(conn)
+		}
+	}
+	// the server is alive as long as one of the ports could be bound
+	if !listening {
+		if !h.isClosed() {
+			gologger.Error().Msgf("Could not listen for catch-all on any port\n")
+			catchAllAlive <- false
+		}
+		return
+	}
+	catchAllAlive <- true
+	wg.Wait()
+	if !h.isClosed() {
+		catchAllAlive <- false
+	}
+}
+
+// track registers a listener to be closed with the server, closing
+// it and returning false if the server has already been closed.
+func (h *CatchAllServer) track(listener io.Closer) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.closed {
+		_ = listener.Close()
+		return false
+	}
+	h.listeners = append(h.listeners, listener)
+	return true
+}
+
+func (h *CatchAllServer) isClosed() bool {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.closed
+}
+
+// Close stops all the catch-all listeners.
+func (h *CatchAllServer) Close() {
+	h.Lock()
+	defer h.Unlock()
+
+	h.closed = true
+	for _, listener := range h.listeners {
+		_ = listener.Close()
+	}
+}
+
+func (h *CatchAllServer) serveTCP(listener net.Listener) {
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if h.isClosed() {
+				return
+			}
+			gologger.Error().Msgf("Could not accept catch-all connection on %s: %s\n", listener.Addr(), err)
+			return
+		}
+		go h.handleTCP(conn)
+	}
+}
+
+func (h *CatchAllServer) handleTCP(conn net.Conn) {
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(catchAllReadTimeout))
+	data := make([]byte, h.options.CatchAllBytes)
+	n, err := io.ReadFull(conn, data)
+	if n == 0 && err != nil {
+		return
+	}
+	h.recordInteraction("tcp", conn.LocalAddr(), conn.RemoteAddr(), data[:n])
+}
+
+func (h *CatchAllServer) serveUDP(conn net.PacketConn) {
+	defer conn.Close()
+
+	buffer := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if h.isClosed() {
+				return
+			}
+			gologger.Error().Msgf("Could not read catch-all packet on %s: %s\n", conn.LocalAddr(), err)
+			return
+		}
+		if n > h.options.CatchAllBytes {
+			n = h.options.CatchAllBytes
+		}
+		data := make([]byte, n)
+		copy(data, buffer[:n])
+		go h.recordInteraction("udp", conn.LocalAddr(), addr, data)
+	}
+}
+
+// recordInteraction stores the captured bytes of a connection. If the bytes
+// or the TLS server name contain an interaction id the data is stored for the
+// correlation-id, otherwise it's kept for the authenticated token holders.
+func (h *CatchAllServer) recordInteraction(protocol string, localAddr, remoteAddr net.Addr, data []byte) {
+	gologger.Debug().Msgf("New %s catch-all data on %s from %s: %x\n", protocol, localAddr, remoteAddr, data)
+
+	var clientHello *ClientHello
+	if protocol == "tcp" && isTLSClientHello(data) {
+		clientHello, _ = parseClientHello(data)
+	}
+
+	var message strings.Builder
+	message.WriteString(fmt.Sprintf("LocalAddress=%s\n", localAddr))
+	message.WriteString(fmt.Sprintf("Length=%d\n\n", len(data)))
+	message.WriteString(hex.Dump(data))
+
+	host, _, _ := net.SplitHostPort(remoteAddr.String())
+	interaction := &Interaction{
+		Protocol:       protocol,
+		RawRequest:     message.String(),
+		RemoteAddress:  host,
+		TLSClientHello: clientHello,
+		Timestamp:      time.Now(),
+	}
+
+	searchData := string(data)
+	if clientHello != nil {
+		searchData = clientHello.ServerName + " " + searchData
+	}
+	uniqueID, fullID, correlationID := h.options.findInteractionID(searchData)
+
+	if uniqueID != "" {
+		interaction.UniqueID = uniqueID
+		interaction.FullId = fullID
+		h.options.storeInteraction(correlationID, interaction)
+		return
+	}
+	// Correlation id doesn't apply here, we skip encryption
+	h.options.storeInteractionWithId(h.options.GetToken(), interaction)
+}
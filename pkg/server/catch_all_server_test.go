@@ -0,0 +1,24 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatchAllServerNoListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "could not listen on tcp")
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+	conn, err := net.ListenPacket("udp", listener.Addr().String())
+	require.Nil(t, err, "could not listen on udp")
+	defer conn.Close()
+
+	server, err := NewCatchAllServer(&Options{ListenIP: "127.0.0.1", CatchAllPorts: []int{port}, CatchAllBytes: 1024})
+	require.Nil(t, err, "could not create catch-all server")
+	alive := make(chan bool, 1)
+	server.ListenAndServe(alive)
+	require.False(t, <-alive, "reported alive without any listener")
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+)
+
+// CertificateLoader serves a certificate loaded from disk instead of ACME,
+// reloading it when the certificate or key files are modified (eg. renewed).
+type CertificateLoader struct {
+	certFile string
+	keyFile  string
+
+	sync.RWMutex
+	certificate *tls.Certificate
+	modTime     time.Time
+}
+
+// NewCertificateLoader returns a new certificate loader for a pem
+// encoded certificate (chain) and private key.
+func NewCertificateLoader(certFile, keyFile string) (*CertificateLoader, error) {
+	loader := &CertificateLoader{certFile: certFile, keyFile: keyFile}
+	if err := loader.load(); err != nil {
+		return nil, err
+	}
+	return loader, nil
+}
+
+// modified returns the latest modification time of the certificate and key files
+func (c *CertificateLoader) modified() (time.Time, error) {
+	var modTime time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+// load loads the certificate and the private key from disk
+func (c *CertificateLoader) load() error {
+	modTime, err := c.modified()
+	if err != nil {
+		return errors.Wrap(err, "could not stat certificate")
+	}
+	certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "could not load certificate")
+	}
+	if certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+		return errors.Wrap(err, "could not parse certificate")
+	}
+
+	c.Lock()
+	c.certificate = &certificate
+	c.modTime = modTime
+	c.Unlock()
+	return nil
+}
+
+// GetCertificate returns the certificate, reloading it if the file has been modified.
+func (c *CertificateLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.RLock()
+	certificate, modTime := c.certificate, c.modTime
+	c.RUnlock()
+
+	if current, err := c.modified(); err == nil && !current.Equal(modTime) {
+		if err := c.load(); err != nil {
+			gologger.Warning().Msgf("Could not reload certificate, using the previous one: %s\n", err)
+			c.Lock()
+			c.modTime = current
+			c.Unlock()
+		} else {
+			gologger.Info().Msgf("Reloaded certificate %s\n", c.certFile)
+			c.RLock()
+			certificate = c.certificate
+			c.RUnlock()
+		}
+	}
+	return certificate, nil
+}
+
+// Covers returns true if the certificate is valid for the subdomains of a domain.
+func (c *CertificateLoader) Covers(domain string) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.certificate.Leaf.VerifyHostname(domain) == nil && c.certificate.Leaf.VerifyHostname("x."+domain) == nil
+}
+
+// TLSConfig returns a tls config serving the certificate.
+func (c *CertificateLoader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: c.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+}
+
+// NewSelfSignedTLSConfig returns a tls config serving a self-signed wildcard
+// certificate for the domains, to capture the tls interactions of the clients
+// not validating certificates when no certificate could be obtained.
+func NewSelfSignedTLSConfig(domains []string) (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not generate key")
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not generate serial")
+	}
+	var names []string
+	for _, domain := range domains {
+		names = append(names, domain, "*."+domain)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "*." + domains[0]},
+		DNSNames:              names,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create certificate")
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse certificate")
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}},
+		ServerName:   domains[0],
+		NextProtos:   []string{"h2", "http/1.1"},
+	}, nil
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCertificate writes a self-signed certificate for the names
+func writeTestCertificate(t *testing.T, directory string, serial int64, names ...string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err, "could not generate key")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.Nil(t, err, "could not create certificate")
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err, "could not marshal key")
+
+	certFile, keyFile := filepath.Join(directory, "cert.pem"), filepath.Join(directory, "key.pem")
+	require.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600), "could not write certificate")
+	require.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600), "could not write key")
+	return certFile, keyFile
+}
+
+func TestCertificateLoader(t *testing.T) {
+	directory, err := ioutil.TempDir("", "certificate-")
+	require.Nil(t, err, "could not create directory")
+	defer os.RemoveAll(directory)
+
+	certFile, keyFile := writeTestCertificate(t, directory, 1, "example.com", "*.example.com")
+	loader, err := NewCertificateLoader(certFile, keyFile)
+	require.Nil(t, err, "could not load certificate")
+	require.True(t, loader.Covers("example.com"), "wildcard certificate doesn't cover domain")
+	require.False(t, loader.Covers("example.net"), "certificate covers other domain")
+	require.Nil(t, checkCertificate(loader.TLSConfig(), "example.com"), "could not check certificate")
+
+	writeTestCertificate(t, directory, 2, "example.com", "*.example.com")
+	modTime := time.Now().Add(time.Minute)
+	require.Nil(t, os.Chtimes(certFile, modTime, modTime), "could not modify certificate")
+	certificate, err := loader.GetCertificate(nil)
+	require.Nil(t, err, "could not get certificate")
+	require.Equal(t, int64(2), certificate.Leaf.SerialNumber.Int64(), "could not reload certificate")
+}
+
+func TestSelfSignedTLSConfig(t *testing.T) {
+	tlsConfig, err := NewSelfSignedTLSConfig([]string{"example.com", "example.org"})
+	require.Nil(t, err, "could not generate self-signed certificate")
+
+	leaf := tlsConfig.Certificates[0].Leaf
+	for _, name := range []string{"example.com", "abc.example.com", "abc.example.org"} {
+		require.Nil(t, leaf.VerifyHostname(name), "could not verify %s", name)
+	}
+}
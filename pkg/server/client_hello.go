@@ -0,0 +1,380 @@
+package server
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	tlsRecordTypeHandshake      = 0x16
+	tlsHandshakeTypeClientHello = 0x01
+
+	tlsExtensionServerName          = 0x0000
+	tlsExtensionSupportedGroups     = 0x000a
+	tlsExtensionPointFormats        = 0x000b
+	tlsExtensionSignatureAlgorithms = 0x000d
+	tlsExtensionALPN                = 0x0010
+	tlsExtensionSupportedVersions   = 0x002b
+)
+
+// ja4Versions are the version codes of the JA4 fingerprint
+var ja4Versions = map[uint16]string{
+	0x0002: "s2",
+	0x0300: "s3",
+	0x0301: "10",
+	0x0302: "11",
+	0x0303: "12",
+	0x0304: "13",
+}
+
+var tlsVersionNames = map[uint16]string{
+	0x0300: "SSL 3.0",
+	0x0301: "TLS 1.0",
+	0x0302: "TLS 1.1",
+	0x0303: "TLS 1.2",
+	0x0304: "TLS 1.3",
+}
+
+// ClientHello contains metadata parsed from a TLS ClientHello message.
+type ClientHello struct {
+	// Version is the legacy version advertised in the ClientHello.
+	Version string `json:"version"`
+	// ServerName is the SNI value sent by the client.
+	ServerName string `json:"server-name,omitempty"`
+	// ALPN is the list of application protocols offered by the client.
+	ALPN []string `json:"alpn,omitempty"`
+	// CipherSuites is the list of cipher suites offered by the client.
+	CipherSuites []uint16 `json:"cipher-suites,omitempty"`
+	// Extensions is the list of extension types in the order they were sent.
+	Extensions []uint16 `json:"extensions,omitempty"`
+	// SupportedVersions is the list of versions of the supported_versions extension.
+	SupportedVersions []uint16 `json:"supported-versions,omitempty"`
+	// SupportedGroups is the list of groups (elliptic curves) supported by the client.
+	SupportedGroups []uint16 `json:"supported-groups,omitempty"`
+	// PointFormats is the list of elliptic curve point formats supported by the client.
+	PointFormats []uint16 `json:"point-formats,omitempty"`
+	// SignatureAlgorithms is the list of signature algorithms in the order they were sent.
+	SignatureAlgorithms []uint16 `json:"signature-algorithms,omitempty"`
+	// JA3 is the JA3 fingerprint of the ClientHello.
+	JA3 string `json:"ja3,omitempty"`
+	// JA3Hash is the md5 hash of the JA3 fingerprint.
+	JA3Hash string `json:"ja3-hash,omitempty"`
+	// JA4 is the JA4 fingerprint of the ClientHello.
+	JA4 string `json:"ja4,omitempty"`
+
+	legacyVersion uint16
+}
+
+// isTLSClientHello returns true if the data looks like the beginning of
+// a TLS handshake record.
+func isTLSClientHello(data []byte) bool {
+	return len(data) > 5 && data[0] == tlsRecordTypeHandshake && data[1] == 0x03
+}
+
+// parseClientHello parses a TLS ClientHello from the start of a TLS record.
+//
+// Truncated records are parsed as far as possible as only the first bytes
+// of a connection are usually available.
+func parseClientHello(data []byte) (*ClientHello, error) {
+	if !isTLSClientHello(data) {
+		return nil, errors.New("not a tls handshake record")
+	}
+	reader := &byteReader{data: data[5:]}
+
+	handshakeType, ok := reader.uint8()
+	if !ok || handshakeType != tlsHandshakeTypeClientHello {
+		return nil, errors.New("not a tls client hello")
+	}
+	if !reader.skip(3) {
+		return nil, errors.New("truncated client hello")
+	}
+	version, ok := reader.uint16()
+	if !ok {
+		return nil, errors.New("truncated client hello")
+	}
+	hello := &ClientHello{Version: tlsVersionName(version), legacyVersion: version}
+
+	// random(32) + session id
+	if !reader.skip(32) {
+		return hello, nil
+	}
+	if _, ok := reader.vector8(); !ok {
+		return hello, nil
+	}
+	cipherSuites, ok := reader.vector16()
+	if !ok {
+		return hello, nil
+	}
+	for i := 0; i+1 < len(cipherSuites); i += 2 {
+		hello.CipherSuites = append(hello.CipherSuites, binary.BigEndian.Uint16(cipherSuites[i:]))
+	}
+	if _, ok := reader.vector8(); !ok {
+		return hello, nil
+	}
+	extensions, ok := reader.vector16()
+	if !ok {
+		return hello, nil
+	}
+
+	extensionsReader := &byteReader{data: extensions}
+	for {
+		extensionType, ok := extensionsReader.uint16()
+		if !ok {
+			break
+		}
+		extensionData, ok := extensionsReader.vector16()
+		if !ok {
+			break
+		}
+		hello.Extensions = append(hello.Extensions, extensionType)
+
+		switch extensionType {
+		case tlsExtensionServerName:
+			hello.ServerName = parseServerNameExtension(extensionData)
+		case tlsExtensionALPN:
+			hello.ALPN = parseALPNExtension(extensionData)
+		case tlsExtensionSupportedGroups, tlsExtensionSignatureAlgorithms:
+			list, _ := (&byteReader{data: extensionData}).vector16()
+			values := parseUint16List(list)
+			if extensionType == tlsExtensionSupportedGroups {
+				hello.SupportedGroups = values
+			} else {
+				hello.SignatureAlgorithms = values
+			}
+		case tlsExtensionSupportedVersions:
+			list, _ := (&byteReader{data: extensionData}).vector8()
+			hello.SupportedVersions = parseUint16List(list)
+		case tlsExtensionPointFormats:
+			list, _ := (&byteReader{data: extensionData}).vector8()
+			for _, format := range list {
+				hello.PointFormats = append(hello.PointFormats, uint16(format))
+			}
+		}
+	}
+	hello.JA3 = hello.ja3()
+	ja3Hash := md5.Sum([]byte(hello.JA3))
+	hello.JA3Hash = hex.EncodeToString(ja3Hash[:])
+	hello.JA4 = hello.ja4()
+	return hello, nil
+}
+
+// ja3 returns the JA3 fingerprint of the ClientHello
+// (version,ciphers,extensions,groups,point formats)
+func (hello *ClientHello) ja3() string {
+	join := func(values []uint16) string {
+		var items []string
+		for _, value := range values {
+			if !isGREASE(value) {
+				items = append(items, strconv.Itoa(int(value)))
+			}
+		}
+		return strings.Join(items, "-")
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(hello.legacyVersion)),
+		join(hello.CipherSuites),
+		join(hello.Extensions),
+		join(hello.SupportedGroups),
+		join(hello.PointFormats),
+	}, ",")
+}
+
+// ja4 returns the JA4 fingerprint of the ClientHello received over tcp
+func (hello *ClientHello) ja4() string {
+	version := hello.legacyVersion
+	for _, supported := range hello.SupportedVersions {
+		if !isGREASE(supported) && supported > version {
+			version = supported
+		}
+	}
+	versionCode, ok := ja4Versions[version]
+	if !ok {
+		versionCode = "00"
+	}
+	sni := "i"
+	if hello.ServerName != "" {
+		sni = "d"
+	}
+	alpn := "00"
+	if len(hello.ALPN) > 0 && hello.ALPN[0] != "" {
+		first, last := hello.ALPN[0][0], hello.ALPN[0][len(hello.ALPN[0])-1]
+		if isAlphanumeric(first) && isAlphanumeric(last) {
+			alpn = string([]byte{first, last})
+		} else {
+			encoded := hex.EncodeToString([]byte(hello.ALPN[0]))
+			alpn = string([]byte{encoded[0], encoded[len(encoded)-1]})
+		}
+	}
+
+	var ciphers, extensions []string
+	for _, cipher := range hello.CipherSuites {
+		if !isGREASE(cipher) {
+			ciphers = append(ciphers, fmt.Sprintf("%04x", cipher))
+		}
+	}
+	var extensionCount int
+	for _, extension := range hello.Extensions {
+		if isGREASE(extension) {
+			continue
+		}
+		extensionCount++
+		if extension != tlsExtensionServerName && extension != tlsExtensionALPN {
+			extensions = append(extensions, fmt.Sprintf("%04x", extension))
+		}
+	}
+	sort.Strings(ciphers)
+	sort.Strings(extensions)
+
+	extensionsHash := "000000000000"
+	if len(extensions) > 0 {
+		value := strings.Join(extensions, ",")
+		if len(hello.SignatureAlgorithms) > 0 {
+			var algorithms []string
+			for _, algorithm := range hello.SignatureAlgorithms {
+				algorithms = append(algorithms, fmt.Sprintf("%04x", algorithm))
+			}
+			value += "_" + strings.Join(algorithms, ",")
+		}
+		extensionsHash = truncatedSHA256(value)
+	}
+	ciphersHash := "000000000000"
+	if len(ciphers) > 0 {
+		ciphersHash = truncatedSHA256(strings.Join(ciphers, ","))
+	}
+	return fmt.Sprintf("t%s%s%02d%02d%s_%s_%s", versionCode, sni, min99(len(ciphers)), min99(extensionCount), alpn, ciphersHash, extensionsHash)
+}
+
+// isGREASE returns true for the reserved GREASE values (RFC 8701)
+func isGREASE(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func min99(value int) int {
+	if value > 99 {
+		return 99
+	}
+	return value
+}
+
+// truncatedSHA256 returns the first 12 hex characters of the sha256 hash of the value
+func truncatedSHA256(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+func parseUint16List(data []byte) []uint16 {
+	var values []uint16
+	for i := 0; i+1 < len(data); i += 2 {
+		values = append(values, binary.BigEndian.Uint16(data[i:]))
+	}
+	return values
+}
+
+func parseServerNameExtension(data []byte) string {
+	reader := &byteReader{data: data}
+	list, ok := reader.vector16()
+	if !ok {
+		return ""
+	}
+	listReader := &byteReader{data: list}
+	for {
+		nameType, ok := listReader.uint8()
+		if !ok {
+			return ""
+		}
+		name, ok := listReader.vector16()
+		if !ok {
+			return ""
+		}
+		if nameType == 0 {
+			return string(name)
+		}
+	}
+}
+
+func parseALPNExtension(data []byte) []string {
+	reader := &byteReader{data: data}
+	list, ok := reader.vector16()
+	if !ok {
+		return nil
+	}
+	var protocols []string
+	listReader := &byteReader{data: list}
+	for {
+		protocol, ok := listReader.vector8()
+		if !ok {
+			return protocols
+		}
+		protocols = append(protocols, string(protocol))
+	}
+}
+
+func tlsVersionName(version uint16) string {
+	if name, ok := tlsVersionNames[version]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// byteReader is a minimal reader for length prefixed tls structures
+type byteReader struct {
+	data []byte
+}
+
+func (b *byteReader) skip(n int) bool {
+	if len(b.data) < n {
+		return false
+	}
+	b.data = b.data[n:]
+	return true
+}
+
+func (b *byteReader) uint8() (uint8, bool) {
+	if len(b.data) < 1 {
+		return 0, false
+	}
+	value := b.data[0]
+	b.data = b.data[1:]
+	return value, true
+}
+
+func (b *byteReader) uint16() (uint16, bool) {
+	if len(b.data) < 2 {
+		return 0, false
+	}
+	value := binary.BigEndian.Uint16(b.data)
+	b.data = b.data[2:]
+	return value, true
+}
+
+func (b *byteReader) vector8() ([]byte, bool) {
+	length, ok := b.uint8()
+	if !ok || len(b.data) < int(length) {
+		return nil, false
+	}
+	value := b.data[:length]
+	b.data = b.data[length:]
+	return value, true
+}
+
+func (b *byteReader) vector16() ([]byte, bool) {
+	length, ok := b.uint16()
+	if !ok || len(b.data) < int(length) {
+		return nil, false
+	}
+	value := b.data[:length]
+	b.data = b.data[length:]
+	return value, true
+}
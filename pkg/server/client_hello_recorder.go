@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// maxClientHelloRecordSize is the maximum size of a tls record carrying a ClientHello
+const maxClientHelloRecordSize = 5 + 16*1024
+
+// clientHelloRecorder records the ClientHello of the tls connections
+// accepted by a server, by remote address, until they are closed.
+type clientHelloRecorder struct {
+	hellos sync.Map
+	// conns reports the connections closed without any request, if set
+	conns *tlsConnRecorder
+}
+
+// Listener wraps a listener recording the ClientHello of accepted connections.
+func (r *clientHelloRecorder) Listener(listener net.Listener) net.Listener {
+	return &clientHelloListener{Listener: listener, recorder: r}
+}
+
+// Conn wraps a connection recording its ClientHello, sent before or after an upgrade to tls.
+func (r *clientHelloRecorder) Conn(conn net.Conn) net.Conn {
+	return &clientHelloConn{Conn: conn, recorder: r}
+}
+
+// Get returns the ClientHello sent by a remote address, if any.
+func (r *clientHelloRecorder) Get(remoteAddr string) *ClientHello {
+	if r == nil {
+		return nil
+	}
+	if hello, ok := r.hellos.Load(remoteAddr); ok {
+		return hello.(*ClientHello)
+	}
+	return nil
+}
+
+// Remove removes the ClientHello of a remote address whose connection
+// is closed without being unwrapped, e.g. on a failed STARTTLS handshake.
+func (r *clientHelloRecorder) Remove(remoteAddr string) {
+	r.hellos.Delete(remoteAddr)
+}
+
+type clientHelloListener struct {
+	net.Listener
+	recorder *clientHelloRecorder
+}
+
+func (l *clientHelloListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.recorder.Conn(conn), nil
+}
+
+// clientHelloConn buffers the first tls record read from the connection
+type clientHelloConn struct {
+	net.Conn
+	recorder *clientHelloRecorder
+
+	record []byte
+	done   bool
+}
+
+func (c *clientHelloConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && !c.done {
+		c.recordData(b[:n])
+	}
+	return n, err
+}
+
+func (c *clientHelloConn) recordData(data []byte) {
+	if len(c.record) == 0 && data[0] != tlsRecordTypeHandshake {
+		// plaintext data before a STARTTLS upgrade
+		return
+	}
+	c.record = append(c.record, data...)
+	if len(c.record) < 5 {
+		return
+	}
+	size := 5 + int(binary.BigEndian.Uint16(c.record[3:]))
+	if size > maxClientHelloRecordSize {
+		size = maxClientHelloRecordSize
+	}
+	if len(c.record) < size {
+		return
+	}
+	c.done = true
+	if hello, err := parseClientHello(c.record[:size]); err == nil {
+		c.recorder.hellos.Store(c.Conn.RemoteAddr().String(), hello)
+	}
+	c.record = nil
+}
+
+func (c *clientHelloConn) Close() error {
+	c.recorder.conns.closed(c.Conn, c.recorder.Get(c.Conn.RemoteAddr().String()))
+	c.recorder.Remove(c.Conn.RemoteAddr().String())
+	return c.Conn.Close()
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClientHello(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	go func() {
+		client := tls.Client(clientConn, &tls.Config{ServerName: "c6rj61aciaeutn2ae680cg5ugboyyyyyn.interactsh.com", NextProtos: []string{"h2", "http/1.1"}, InsecureSkipVerify: true})
+		_ = client.Handshake()
+		_ = client.Close()
+	}()
+
+	_ = serverConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data := make([]byte, 4096)
+	n, err := serverConn.Read(data)
+	require.Nil(t, err, "could not read client hello")
+
+	hello, err := parseClientHello(data[:n])
+	require.Nil(t, err, "could not parse client hello")
+	require.Equal(t, "TLS 1.2", hello.Version, "could not get correct legacy version")
+	require.Equal(t, "c6rj61aciaeutn2ae680cg5ugboyyyyyn.interactsh.com", hello.ServerName, "could not get correct server name")
+	require.Equal(t, []string{"h2", "http/1.1"}, hello.ALPN, "could not get correct alpn")
+	require.NotEmpty(t, hello.CipherSuites, "could not get cipher suites")
+	require.Contains(t, hello.Extensions, uint16(tlsExtensionServerName), "could not get extensions")
+	require.NotEmpty(t, hello.SupportedGroups, "could not get supported groups")
+	require.Contains(t, hello.SupportedVersions, uint16(tls.VersionTLS13), "could not get supported versions")
+	require.True(t, strings.HasPrefix(hello.JA3, "771,"), "could not get correct ja3")
+	require.Len(t, hello.JA3Hash, 32, "could not get ja3 hash")
+	require.Regexp(t, `^t13d\d{4}h2_[0-9a-f]{12}_[0-9a-f]{12}$`, hello.JA4, "could not get correct ja4")
+
+	_, err = parseClientHello([]byte("GET / HTTP/1.1\r\n"))
+	require.NotNil(t, err, "could parse non tls data")
+}
+
+func TestClientHelloRecorder(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "could not listen")
+	recorder := &clientHelloRecorder{}
+	listener = recorder.Listener(listener)
+	defer listener.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			return
+		}
+		client := tls.Client(conn, &tls.Config{ServerName: "test.interactsh.com", InsecureSkipVerify: true})
+		_ = client.Handshake()
+		_ = client.Close()
+	}()
+
+	conn, err := listener.Accept()
+	require.Nil(t, err, "could not accept connection")
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// reads in small chunks as the record can be split across reads
+	buffer := make([]byte, 16)
+	for recorder.Get(conn.RemoteAddr().String()) == nil {
+		_, err := conn.Read(buffer)
+		require.Nil(t, err, "could not read client hello")
+	}
+	require.Equal(t, "test.interactsh.com", recorder.Get(conn.RemoteAddr().String()).ServerName, "could not record client hello")
+
+	_ = conn.Close()
+	require.Nil(t, recorder.Get(conn.RemoteAddr().String()), "could not remove client hello of closed connection")
+}
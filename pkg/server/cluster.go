@@ -0,0 +1,339 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+)
+
+// clusterQueueSize is the number of pending events kept per peer
+const clusterQueueSize = 10000
+
+// clusterBatchSize is the maximum number of events sent to a peer at once
+const clusterBatchSize = 100
+
+// clusterNodeHeader is the header holding the node id of the instance
+// replicating the events, so that an instance ignores its own events
+const clusterNodeHeader = "X-Interactsh-Node"
+
+// ClusterEvent types
+const (
+	ClusterRegister    = "register"
+	ClusterImport      = "import"
+	ClusterDeregister  = "deregister"
+	ClusterInteraction = "interaction"
+	ClusterAnswers     = "dns-answers"
+	ClusterResponses   = "http-responses"
+)
+
+// ClusterEvent is a registration, import, deregistration, interaction or
+// update of the dns answers or http responses of a session replicated to
+// the peers of a cluster.
+type ClusterEvent struct {
+	// Type is the type of the event (register, import, deregister, interaction, dns-answers or http-responses)
+	Type string `json:"type"`
+	// Register is the registration of the session, its ttl being the
+	// number of seconds remaining before its eviction
+	Register *RegisterRequest `json:"register,omitempty"`
+	// Tenant is the name of the tenant which registered the session, if any
+	Tenant string `json:"tenant,omitempty"`
+	// Import is a session imported from another server with its keys
+	Import *ExportedSession `json:"import,omitempty"`
+	// CorrelationID is the correlation id of a deregistered session or of an interaction
+	CorrelationID string `json:"correlation-id,omitempty"`
+	// ID is the id an unencrypted interaction is stored for (eg. the token)
+	ID string `json:"id,omitempty"`
+	// Data is the encoded interaction
+	Data []byte `json:"data,omitempty"`
+	// DNSAnswers are the dns answers set for the correlation id, removed if empty
+	DNSAnswers *DNSAnswers `json:"dns-answers,omitempty"`
+	// HTTPResponses are the http responses set for the correlation id, removed if empty
+	HTTPResponses HTTPResponses `json:"http-responses,omitempty"`
+}
+
+// Cluster replicates the registrations, deregistrations and interactions of
+// the sessions to the peer instances of a cluster (eg. anycast dns nodes), so
+// that a client polling any instance receives the interactions of all of them.
+// Each peer streams the events it receives itself, the events received from
+// the peers not being replicated again.
+type Cluster struct {
+	node       string
+	secret     string
+	peers      []*clusterPeer
+	httpClient *http.Client
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// clusterPeer is a peer instance with its queue of pending events
+type clusterPeer struct {
+	url   string
+	queue chan *ClusterEvent
+}
+
+// NewCluster returns a cluster replicating the events to the peer urls
+// (eg. https://node2.example.com), authenticated with a shared secret.
+func NewCluster(peers []string, secret string) (*Cluster, error) {
+	if secret == "" {
+		return nil, errors.New("no cluster secret specified")
+	}
+	node := make([]byte, 8)
+	if _, err := rand.Read(node); err != nil {
+		return nil, errors.Wrap(err, "could not generate node id")
+	}
+	cluster := &Cluster{
+		node:       hex.EncodeToString(node),
+		secret:     secret,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		done:       make(chan struct{}),
+	}
+	for _, peer := range peers {
+		if !strings.HasPrefix(peer, "http://") && !strings.HasPrefix(peer, "https://") {
+			return nil, errors.Errorf("invalid cluster peer %s (http:// or https:// url)", peer)
+		}
+		peer := &clusterPeer{url: strings.TrimSuffix(peer, "/") + "/cluster", queue: make(chan *ClusterEvent, clusterQueueSize)}
+		cluster.peers = append(cluster.peers, peer)
+		go cluster.deliver(peer)
+	}
+	return cluster, nil
+}
+
+// Register replicates the registration of a session evicted at a time,
+// along with the name of its tenant.
+func (c *Cluster) Register(r *RegisterRequest, expiresAt time.Time, tenant string) {
+	if c == nil {
+		return
+	}
+	register := *r
+	register.TTL = 0
+	if !expiresAt.IsZero() {
+		if register.TTL = int64(time.Until(expiresAt) / time.Second); register.TTL <= 0 {
+			return
+		}
+	}
+	c.publish(&ClusterEvent{Type: ClusterRegister, Register: &register, Tenant: tenant})
+}
+
+// Import replicates a session imported from another server.
+func (c *Cluster) Import(session *ExportedSession) {
+	if c == nil {
+		return
+	}
+	c.publish(&ClusterEvent{Type: ClusterImport, Import: session})
+}
+
+// Deregister replicates the deregistration of a session.
+func (c *Cluster) Deregister(correlationID string) {
+	if c == nil {
+		return
+	}
+	c.publish(&ClusterEvent{Type: ClusterDeregister, CorrelationID: correlationID})
+}
+
+// Interaction replicates an interaction stored for a correlation id, or
+// unencrypted for an id.
+func (c *Cluster) Interaction(correlationID, id string, data []byte) {
+	if c == nil {
+		return
+	}
+	c.publish(&ClusterEvent{Type: ClusterInteraction, CorrelationID: correlationID, ID: id, Data: data})
+}
+
+// SetDNSAnswers replicates the dns answers set for a session.
+func (c *Cluster) SetDNSAnswers(correlationID string, answers *DNSAnswers) {
+	if c == nil {
+		return
+	}
+	c.publish(&ClusterEvent{Type: ClusterAnswers, CorrelationID: correlationID, DNSAnswers: answers})
+}
+
+// SetHTTPResponses replicates the http responses set for a session.
+func (c *Cluster) SetHTTPResponses(correlationID string, responses HTTPResponses) {
+	if c == nil {
+		return
+	}
+	c.publish(&ClusterEvent{Type: ClusterResponses, CorrelationID: correlationID, HTTPResponses: responses})
+}
+
+// publish queues an event for every peer, dropping it for the peers whose queue is full
+func (c *Cluster) publish(event *ClusterEvent) {
+	for _, peer := range c.peers {
+		select {
+		case peer.queue <- event:
+		default:
+			gologger.Error().Msgf("Could not replicate %s to %s: queue is full\n", event.Type, peer.url)
+		}
+	}
+}
+
+// Close stops the replication of the queued events.
+func (c *Cluster) Close() {
+	if c == nil {
+		return
+	}
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// deliver sends the queued events of a peer in batches
+func (c *Cluster) deliver(peer *clusterPeer) {
+	for {
+		var batch []*ClusterEvent
+		select {
+		case <-c.done:
+			return
+		case event := <-peer.queue:
+			batch = append(batch, event)
+		}
+	drain:
+		for len(batch) < clusterBatchSize {
+			select {
+			case event := <-peer.queue:
+				batch = append(batch, event)
+			default:
+				break drain
+			}
+		}
+		if err := c.post(peer.url, batch); err != nil {
+			gologger.Error().Msgf("Could not replicate %d events to %s: %s\n", len(batch), peer.url, err)
+		}
+	}
+}
+
+func (c *Cluster) post(url string, batch []*ClusterEvent) error {
+	body, err := jsoniter.Marshal(batch)
+	if err != nil {
+		return errors.Wrap(err, "could not encode events")
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "could not create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", c.secret)
+	req.Header.Set(clusterNodeHeader, c.node)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not make request")
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// authorized returns true for the requests of the other peers of the cluster
+func (c *Cluster) authorized(req *http.Request) bool {
+	return subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(c.secret)) == 1
+}
+
+// applyClusterEvent applies an event replicated by a peer, without
+// replicating it again
+func (options *Options) applyClusterEvent(event *ClusterEvent) error {
+	switch event.Type {
+	case ClusterRegister:
+		r := event.Register
+		if r == nil {
+			return errors.New("missing registration")
+		}
+		if options.Storage.HasID(r.CorrelationID) {
+			return nil
+		}
+		if err := options.Storage.SetIDPublicKeyWithTTL(r.CorrelationID, r.SecretKey, r.PublicKey, time.Duration(r.TTL)*time.Second); err != nil {
+			return errors.Wrap(err, "could not set id and public key")
+		}
+		options.SessionAnswers.Set(r.CorrelationID, r.DNSAnswers)
+		options.SessionChallenges.Set(r.CorrelationID, r.HTTPChallenge)
+		options.SessionResponses.Set(r.CorrelationID, r.HTTPResponses)
+		options.Tokens.SetOwner(event.Tenant, r.CorrelationID)
+	case ClusterImport:
+		if event.Import == nil {
+			return errors.New("missing imported session")
+		}
+		if options.Storage.HasID(event.Import.CorrelationID) {
+			return nil
+		}
+		return options.importSession(event.Import)
+	case ClusterDeregister:
+		if err := options.Storage.RemoveSession(event.CorrelationID); err != nil {
+			return nil
+		}
+		options.sessionRemoved(event.CorrelationID)
+	case ClusterInteraction:
+		if event.ID != "" {
+			if err := options.Storage.AddInteractionWithId(event.ID, event.Data); err != nil {
+				return err
+			}
+			options.LongPolls.Notify(sharedBuckets)
+			return nil
+		}
+		if err := options.Storage.AddInteraction(event.CorrelationID, event.Data); err != nil {
+			return err
+		}
+		options.LongPolls.Notify(event.CorrelationID)
+	case ClusterAnswers:
+		if !options.Storage.HasID(event.CorrelationID) {
+			return nil
+		}
+		if event.DNSAnswers != nil {
+			if err := event.DNSAnswers.Validate(); err != nil {
+				return err
+			}
+		}
+		options.SessionAnswers.Set(event.CorrelationID, event.DNSAnswers)
+	case ClusterResponses:
+		if !options.Storage.HasID(event.CorrelationID) {
+			return nil
+		}
+		if err := event.HTTPResponses.Validate(); err != nil {
+			return err
+		}
+		options.SessionResponses.Set(event.CorrelationID, event.HTTPResponses)
+	default:
+		return errors.Errorf("unknown event type %s", event.Type)
+	}
+	return nil
+}
+
+// clusterHandler applies the events replicated by the peers of the cluster
+func (h *HTTPServer) clusterHandler(w http.ResponseWriter, req *http.Request) {
+	cluster := h.options.Cluster
+	if req.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !cluster.authorized(req) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if req.Header.Get(clusterNodeHeader) == cluster.node {
+		jsonMsg(w, "ignoring own events", http.StatusOK)
+		return
+	}
+	var events []*ClusterEvent
+	if err := jsoniter.NewDecoder(req.Body).Decode(&events); err != nil {
+		jsonError(w, fmt.Sprintf("could not decode json body: %s", err), http.StatusBadRequest)
+		return
+	}
+	for _, event := range events {
+		if err := h.options.applyClusterEvent(event); err != nil {
+			gologger.Debug().Msgf("Could not apply replicated %s: %s\n", event.Type, err)
+		}
+	}
+	jsonMsg(w, "events applied", http.StatusOK)
+}
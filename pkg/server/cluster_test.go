@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCluster(t *testing.T) {
+	encoded := encodedTestPublicKey(t)
+
+	peerCluster, err := NewCluster(nil, "secret")
+	require.Nil(t, err, "could not create peer cluster")
+	peerOptions := &Options{Domain: "example.com", Storage: storage.New(time.Hour), Cluster: peerCluster}
+	peerOptions.SessionChallenges = NewSessionChallenges(peerOptions.Storage.HasID)
+	peerOptions.SessionAnswers = NewSessionAnswers(peerOptions.Storage.HasID)
+	peerOptions.SessionResponses = NewSessionResponses(peerOptions.Storage.HasID)
+	peerServer, err := NewHTTPServer(peerOptions)
+	require.Nil(t, err, "could not create peer http server")
+	peer := httptest.NewServer(peerServer.nontlsserver.Handler)
+	defer peer.Close()
+
+	cluster, err := NewCluster([]string{peer.URL}, "secret")
+	require.Nil(t, err, "could not create cluster")
+	defer cluster.Close()
+	options := &Options{Domain: "example.com", Storage: storage.New(time.Hour), Cluster: cluster}
+
+	// the registrations and interactions are replicated to the peers
+	require.Nil(t, options.Storage.SetIDPublicKey("c23b2la0kl1krjcrdj10", "secret", encoded), "could not register session")
+	cluster.Register(&RegisterRequest{CorrelationID: "c23b2la0kl1krjcrdj10", SecretKey: "secret", PublicKey: encoded, HTTPChallenge: "basic"}, time.Now().Add(time.Hour), "")
+	require.Nil(t, options.addInteraction("c23b2la0kl1krjcrdj10", []byte(`{"protocol":"http"}`)), "could not add interaction")
+	require.Eventually(t, func() bool {
+		session, ok := peerOptions.Storage.GetSession("c23b2la0kl1krjcrdj10")
+		return ok && session.Pending == 1
+	}, 5*time.Second, 10*time.Millisecond, "could not replicate session")
+	expiresAt, _ := peerOptions.Storage.GetExpiry("c23b2la0kl1krjcrdj10")
+	require.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute, "could not replicate session ttl")
+	require.Equal(t, "basic", peerOptions.SessionChallenges.Get("c23b2la0kl1krjcrdj10"), "could not replicate http challenge")
+
+	// so are the dns answers and http responses set after the registration
+	cluster.SetDNSAnswers("c23b2la0kl1krjcrdj10", &DNSAnswers{A: []string{"10.0.0.1"}})
+	cluster.SetHTTPResponses("c23b2la0kl1krjcrdj10", HTTPResponses{{Body: "ok"}})
+	require.Eventually(t, func() bool {
+		return peerOptions.SessionAnswers.Get("c23b2la0kl1krjcrdj10") != nil && len(peerOptions.SessionResponses.Get("c23b2la0kl1krjcrdj10")) == 1
+	}, 5*time.Second, 10*time.Millisecond, "could not replicate dns answers and http responses")
+	require.Equal(t, []string{"10.0.0.1"}, peerOptions.SessionAnswers.Get("c23b2la0kl1krjcrdj10").A, "could not replicate dns answers")
+	cluster.SetDNSAnswers("c23b2la0kl1krjcrdj10", nil)
+	require.Eventually(t, func() bool {
+		return peerOptions.SessionAnswers.Get("c23b2la0kl1krjcrdj10") == nil
+	}, 5*time.Second, 10*time.Millisecond, "could not replicate removed dns answers")
+
+	cluster.Deregister("c23b2la0kl1krjcrdj10")
+	require.Eventually(t, func() bool {
+		return !peerOptions.Storage.HasID("c23b2la0kl1krjcrdj10")
+	}, 5*time.Second, 10*time.Millisecond, "could not replicate deregistration")
+
+	post := func(secret, node string) int {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/cluster", strings.NewReader(`[{"type":"interaction","id":"token","data":"e30="}]`))
+		req.Header.Set("Authorization", secret)
+		req.Header.Set(clusterNodeHeader, node)
+		rec := httptest.NewRecorder()
+		peerServer.nontlsserver.Handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	require.Nil(t, peerOptions.Storage.SetID("token"), "could not set token")
+	require.Equal(t, http.StatusUnauthorized, post("invalid", cluster.node), "could replicate with invalid secret")
+	require.Equal(t, http.StatusOK, post("secret", peerCluster.node), "could not ignore own events")
+	data, err := peerOptions.Storage.GetInteractionsWithId("token")
+	require.Nil(t, err, "could not get interactions")
+	require.Empty(t, data, "could apply own events")
+	require.Equal(t, http.StatusOK, post("secret", cluster.node), "could not replicate interaction")
+	data, err = peerOptions.Storage.GetInteractionsWithId("token")
+	require.Nil(t, err, "could not get interactions")
+	require.Equal(t, []string{"{}"}, data, "could not replicate interaction with id")
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+// diskLogDateLayout is the layout of the date of the disk log files
+const diskLogDateLayout = "2006-01-02"
+
+// DiskLogEntry is the summary of an interaction written to the disk log.
+type DiskLogEntry struct {
+	// Timestamp is the timestamp of the interaction
+	Timestamp time.Time `json:"timestamp"`
+	// Protocol is the protocol of the interaction
+	Protocol string `json:"protocol"`
+	// UniqueID is the unique id of the interaction
+	UniqueID string `json:"unique-id"`
+	// FullId is the full id of the interaction
+	FullId string `json:"full-id"`
+	// QType is the query type of dns interactions
+	QType string `json:"q-type,omitempty"`
+	// RemoteAddress is the remote address of the interaction
+	RemoteAddress string `json:"remote-address"`
+	// Size is the size in bytes of the raw request
+	Size int `json:"size"`
+	// Stored is true if the interaction has been stored for a registered session
+	Stored bool `json:"stored"`
+	// Blob is the interaction encrypted for the session, if enabled
+	Blob string `json:"blob,omitempty"`
+}
+
+// DiskLog appends the summary of every interaction to daily files
+// (<directory>/<yyyy-mm-dd>.jsonl) independently of the storage.
+type DiskLog struct {
+	sync.Mutex
+	directory string
+	// Blobs enables writing the interactions encrypted for their session
+	Blobs bool
+
+	date string
+	file *os.File
+}
+
+// NewDiskLog returns a new disk log writing to the directory.
+func NewDiskLog(directory string, blobs bool) (*DiskLog, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, errors.Wrap(err, "could not create disk log directory")
+	}
+	return &DiskLog{directory: directory, Blobs: blobs}, nil
+}
+
+// Write appends an entry to the file of the current date.
+func (d *DiskLog) Write(entry *DiskLogEntry) error {
+	data, err := jsoniter.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "could not encode disk log entry")
+	}
+	data = append(data, '\n')
+
+	d.Lock()
+	defer d.Unlock()
+
+	if date := time.Now().UTC().Format(diskLogDateLayout); date != d.date {
+		if err := d.open(date); err != nil {
+			return err
+		}
+	}
+	_, err = d.file.Write(data)
+	return err
+}
+
+// open opens the file of a date, closing the previous one
+func (d *DiskLog) open(date string) error {
+	if d.file != nil {
+		_ = d.file.Close()
+		d.file = nil
+	}
+	file, err := os.OpenFile(filepath.Join(d.directory, date+".jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrap(err, "could not open disk log file")
+	}
+	d.file = file
+	d.date = date
+	return nil
+}
+
+// Close closes the current file of the disk log.
+func (d *DiskLog) Close() error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.file == nil {
+		return nil
+	}
+	err := d.file.Close()
+	d.file = nil
+	d.date = ""
+	return err
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskLogWrite(t *testing.T) {
+	directory, err := ioutil.TempDir("", "disk-log-")
+	require.Nil(t, err, "could not create directory")
+	defer os.RemoveAll(directory)
+
+	diskLog, err := NewDiskLog(directory, false)
+	require.Nil(t, err, "could not create disk log")
+	for _, protocol := range []string{"dns", "http"} {
+		err = diskLog.Write(&DiskLogEntry{Timestamp: time.Now(), Protocol: protocol, UniqueID: "test", Stored: true})
+		require.Nil(t, err, "could not write entry")
+	}
+	require.Nil(t, diskLog.Close(), "could not close disk log")
+
+	data, err := ioutil.ReadFile(filepath.Join(directory, time.Now().UTC().Format(diskLogDateLayout)+".jsonl"))
+	require.Nil(t, err, "could not read disk log file")
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2, "could not get entries")
+
+	var entry DiskLogEntry
+	require.Nil(t, jsoniter.Unmarshal([]byte(lines[1]), &entry), "could not decode entry")
+	require.Equal(t, "http", entry.Protocol, "could not get protocol")
+	require.Empty(t, entry.Blob, "blob written while disabled")
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultDKIMSelector is the selector of the dkim key when none is configured
+	DefaultDKIMSelector = "interactsh"
+	// dkimKeyBits is the size of the generated dkim keys
+	dkimKeyBits = 2048
+)
+
+// dkimSignedHeaders are the headers signed if present in the message
+var dkimSignedHeaders = []string{"from", "to", "cc", "subject", "date", "message-id", "reply-to", "mime-version", "content-type", "content-transfer-encoding"}
+
+// dkimWhitespace matches the runs of whitespace collapsed by the relaxed canonicalization
+var dkimWhitespace = regexp.MustCompile(`[ \t]+`)
+
+// DKIMSigner signs the mails sent by the server from the domains with a
+// rsa key (rsa-sha256, relaxed/relaxed canonicalization), its public key
+// being published by the dns server under <selector>._domainkey.<domain>.
+type DKIMSigner struct {
+	selector string
+	key      *rsa.PrivateKey
+}
+
+// LoadDKIMSigner loads the pem encoded rsa key of a signer, generating and
+// writing it if missing.
+func LoadDKIMSigner(path, selector string) (*DKIMSigner, error) {
+	signer := &DKIMSigner{selector: selector}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.Errorf("could not decode dkim key %s", path)
+		}
+		if signer.key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, errors.Wrap(err, "could not parse dkim key")
+		}
+		return signer, nil
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "could not read dkim key")
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, dkimKeyBits)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not generate dkim key")
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return nil, errors.Wrap(err, "could not write dkim key")
+	}
+	signer.key = key
+	return signer, nil
+}
+
+// Selector returns the selector of the key.
+func (s *DKIMSigner) Selector() string {
+	return s.selector
+}
+
+// Record returns the value of the txt record of the public key.
+func (s *DKIMSigner) Record() string {
+	publicKey, _ := x509.MarshalPKIXPublicKey(&s.key.PublicKey)
+	return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(publicKey)
+}
+
+// Sign returns a message sent from a domain with its DKIM-Signature header.
+func (s *DKIMSigner) Sign(domain string, message []byte) ([]byte, error) {
+	message = bytes.ReplaceAll(bytes.ReplaceAll(message, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+	headers, body := message, []byte{}
+	if i := bytes.Index(message, []byte("\r\n\r\n")); i >= 0 {
+		headers, body = message[:i+2], message[i+4:]
+	}
+	bodyHash := sha256.Sum256(dkimRelaxedBody(body))
+
+	fields := dkimHeaderFields(headers)
+	var signed []string
+	hash := sha256.New()
+	for _, name := range dkimSignedHeaders {
+		// the last occurrence of a header is the one verified first
+		for i := len(fields) - 1; i >= 0; i-- {
+			if fieldName, _ := dkimSplitField(fields[i]); strings.EqualFold(fieldName, name) {
+				hash.Write([]byte(dkimRelaxedHeader(fields[i]) + "\r\n"))
+				signed = append(signed, name)
+				break
+			}
+		}
+	}
+	if len(signed) == 0 || signed[0] != "from" {
+		return nil, errors.New("message has no from header")
+	}
+	signature := fmt.Sprintf("v=1; a=rsa-sha256; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		strings.TrimSuffix(domain, "."), s.selector, time.Now().Unix(), strings.Join(signed, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+	hash.Write([]byte(dkimRelaxedHeader("DKIM-Signature: " + signature)))
+
+	b, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, hash.Sum(nil))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not sign message")
+	}
+	return append([]byte("DKIM-Signature: "+signature+base64.StdEncoding.EncodeToString(b)+"\r\n"), message...), nil
+}
+
+// dkimHeaderFields returns the header fields of a message with their folded lines
+func dkimHeaderFields(headers []byte) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(string(headers), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+// dkimSplitField returns the name and value of a header field
+func dkimSplitField(field string) (string, string) {
+	parts := strings.SplitN(field, ":", 2)
+	if len(parts) != 2 {
+		return strings.TrimSpace(field), ""
+	}
+	return strings.TrimSpace(parts[0]), parts[1]
+}
+
+// dkimRelaxedHeader returns the relaxed canonicalization of a header field (rfc 6376 3.4.2)
+func dkimRelaxedHeader(field string) string {
+	name, value := dkimSplitField(field)
+	value = strings.NewReplacer("\r\n", "", "\n", "").Replace(value)
+	value = strings.TrimSpace(dkimWhitespace.ReplaceAllString(value, " "))
+	return strings.ToLower(name) + ":" + value
+}
+
+// dkimRelaxedBody returns the relaxed canonicalization of a body (rfc 6376 3.4.4)
+func dkimRelaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(dkimWhitespace.ReplaceAllString(line, " "), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDKIMSigner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	signer, err := LoadDKIMSigner(path, "sel")
+	require.Nil(t, err, "could not generate dkim key")
+	loaded, err := LoadDKIMSigner(path, "sel")
+	require.Nil(t, err, "could not load dkim key")
+	require.Equal(t, signer.Record(), loaded.Record(), "could not load generated key")
+
+	message := "From: test@example.com\nTo: user@example.net\nSubject: a   folded\n\tsubject\n\nHello  world \n\n\n"
+	signed, err := signer.Sign("example.com", []byte(message))
+	require.Nil(t, err, "could not sign message")
+
+	fields := dkimHeaderFields([]byte(strings.SplitN(string(signed), "\r\n\r\n", 2)[0] + "\r\n"))
+	_, value := dkimSplitField(fields[0])
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(value, ";") {
+		parts := strings.SplitN(strings.TrimSpace(tag), "=", 2)
+		tags[parts[0]] = strings.TrimSpace(parts[1])
+	}
+	require.Equal(t, "example.com", tags["d"], "could not sign with domain")
+	require.Equal(t, "from:to:subject", tags["h"], "could not sign present headers")
+	bodyHash := sha256.Sum256([]byte("Hello world\r\n"))
+	require.Equal(t, base64.StdEncoding.EncodeToString(bodyHash[:]), tags["bh"], "could not hash canonicalized body")
+
+	hash := sha256.New()
+	for _, field := range fields[1:] {
+		hash.Write([]byte(dkimRelaxedHeader(field) + "\r\n"))
+	}
+	hash.Write([]byte(dkimRelaxedHeader(strings.TrimSuffix(fields[0], tags["b"]+"\r\n"))))
+	publicKey, err := x509.ParsePKIXPublicKey(mustDecodeBase64(t, strings.TrimPrefix(signer.Record(), "v=DKIM1; k=rsa; p=")))
+	require.Nil(t, err, "could not parse published key")
+	require.Nil(t, rsa.VerifyPKCS1v15(publicKey.(*rsa.PublicKey), crypto.SHA256, hash.Sum(nil), mustDecodeBase64(t, tags["b"])), "could not verify signature")
+
+	_, err = signer.Sign("example.com", []byte("Subject: test\n\nbody"))
+	require.NotNil(t, err, "signed message without from header")
+}
+
+func TestDNSServerMailRecords(t *testing.T) {
+	signer, err := LoadDKIMSigner(filepath.Join(t.TempDir(), "dkim.pem"), "sel")
+	require.Nil(t, err, "could not generate dkim key")
+	server := NewDNSServer("udp", &Options{Domain: "example.com", IPAddress: "1.2.3.4", MailRecords: true, DKIM: signer})
+
+	txt := func(name string) []string {
+		w := &testResponseWriter{}
+		server.ServeDNS(w, new(dns.Msg).SetQuestion(name, dns.TypeTXT))
+		var values []string
+		for _, answer := range w.msg.Answer {
+			values = append(values, strings.Join(answer.(*dns.TXT).Txt, ""))
+		}
+		return values
+	}
+	require.Contains(t, txt("example.com."), "v=spf1 ip4:1.2.3.4 -all", "could not get spf record")
+	require.Equal(t, []string{dmarcRecord}, txt("_dmarc.example.com."), "could not get dmarc record")
+	require.Equal(t, []string{signer.Record()}, txt("sel._domainkey.example.com."), "could not get dkim record")
+	require.NotContains(t, txt("test.example.com."), "v=spf1 ip4:1.2.3.4 -all", "got spf record of a subdomain")
+}
+
+func mustDecodeBase64(t *testing.T, value string) []byte {
+	data, err := base64.StdEncoding.DecodeString(value)
+	require.Nil(t, err, "could not decode base64")
+	return data
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"net"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxDNSAnswerValues is the maximum number of values of each record type of a session
+	maxDNSAnswerValues = 8
+	// maxDNSAnswerTXTLength is the maximum length of a txt value of a session
+	maxDNSAnswerTXTLength = 1024
+	// defaultDNSAnswerTTL is the ttl of the answers of a session without one
+	defaultDNSAnswerTTL = 60
+)
+
+// DNSAnswers are the records answered by the dns server for the names of a
+// session instead of the server ones, eg. to redirect through a cname or to
+// return data to the resolving host in txt records.
+type DNSAnswers struct {
+	// A are the ipv4 addresses of the A answers
+	A []string `json:"a,omitempty"`
+	// AAAA are the ipv6 addresses of the AAAA answers
+	AAAA []string `json:"aaaa,omitempty"`
+	// CNAME is the target of the CNAME answering every query type
+	CNAME string `json:"cname,omitempty"`
+	// TXT are the values of the TXT answers
+	TXT []string `json:"txt,omitempty"`
+	// TTL is the ttl of the answers in seconds, 60 if zero
+	TTL uint32 `json:"ttl,omitempty"`
+}
+
+// Validate returns an error if the answers can't be returned by the dns server.
+func (a *DNSAnswers) Validate() error {
+	if len(a.A) > maxDNSAnswerValues || len(a.AAAA) > maxDNSAnswerValues || len(a.TXT) > maxDNSAnswerValues {
+		return errors.Errorf("dns answers are limited to %d values per type", maxDNSAnswerValues)
+	}
+	for _, value := range a.A {
+		if ip := net.ParseIP(value); ip == nil || ip.To4() == nil {
+			return errors.Errorf("invalid ipv4 address %s", value)
+		}
+	}
+	for _, value := range a.AAAA {
+		if ip := net.ParseIP(value); ip == nil || ip.To4() != nil {
+			return errors.Errorf("invalid ipv6 address %s", value)
+		}
+	}
+	for _, value := range a.TXT {
+		if len(value) > maxDNSAnswerTXTLength {
+			return errors.Errorf("txt values are limited to %d characters", maxDNSAnswerTXTLength)
+		}
+	}
+	if a.CNAME != "" {
+		if _, ok := dns.IsDomainName(a.CNAME); !ok {
+			return errors.Errorf("invalid cname %s", a.CNAME)
+		}
+		if len(a.A) > 0 || len(a.AAAA) > 0 || len(a.TXT) > 0 {
+			return errors.New("cname can't be combined with other answers")
+		}
+	}
+	return nil
+}
+
+// records returns the answers of a query type for a name, nil if the
+// session has none so that the server ones are answered
+func (a *DNSAnswers) records(name string, qtype uint16) []dns.RR {
+	ttl := a.TTL
+	if ttl == 0 {
+		ttl = defaultDNSAnswerTTL
+	}
+	header := func(rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+	}
+	if a.CNAME != "" {
+		return []dns.RR{&dns.CNAME{Hdr: header(dns.TypeCNAME), Target: dns.Fqdn(a.CNAME)}}
+	}
+	var records []dns.RR
+	if qtype == dns.TypeA || qtype == dns.TypeANY {
+		for _, value := range a.A {
+			records = append(records, &dns.A{Hdr: header(dns.TypeA), A: net.ParseIP(value).To4()})
+		}
+	}
+	if qtype == dns.TypeAAAA || qtype == dns.TypeANY {
+		for _, value := range a.AAAA {
+			records = append(records, &dns.AAAA{Hdr: header(dns.TypeAAAA), AAAA: net.ParseIP(value)})
+		}
+	}
+	if qtype == dns.TypeTXT || qtype == dns.TypeANY {
+		for _, value := range a.TXT {
+			records = append(records, &dns.TXT{Hdr: header(dns.TypeTXT), Txt: splitTXT(value)})
+		}
+	}
+	return records
+}
+
+// splitTXT splits a txt value in the 255 bytes strings of a txt record
+func splitTXT(value string) []string {
+	parts := []string{}
+	for len(value) > 255 {
+		parts = append(parts, value[:255])
+		value = value[255:]
+	}
+	return append(parts, value)
+}
+
+// SessionAnswers are the dns answers set by the clients for their sessions.
+type SessionAnswers struct {
+	alive func(correlationID string) bool
+
+	sync.Mutex
+	sessions map[string]*DNSAnswers
+}
+
+// NewSessionAnswers returns new session answers, alive returning false for
+// the sessions which are no longer registered.
+func NewSessionAnswers(alive func(correlationID string) bool) *SessionAnswers {
+	return &SessionAnswers{alive: alive, sessions: make(map[string]*DNSAnswers)}
+}
+
+// Set sets the answers of a session, removing them if empty.
+func (s *SessionAnswers) Set(correlationID string, answers *DNSAnswers) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if answers == nil || len(answers.A) == 0 && len(answers.AAAA) == 0 && len(answers.TXT) == 0 && answers.CNAME == "" {
+		delete(s.sessions, correlationID)
+		return
+	}
+	if _, ok := s.sessions[correlationID]; !ok && len(s.sessions) >= maxAccountedSessions {
+		s.prune()
+	}
+	s.sessions[correlationID] = answers
+}
+
+// Get returns the answers of a registered session, nil if it didn't set any.
+func (s *SessionAnswers) Get(correlationID string) *DNSAnswers {
+	if s == nil {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	answers, ok := s.sessions[correlationID]
+	if !ok || !s.alive(correlationID) {
+		return nil
+	}
+	return answers
+}
+
+// Remove removes the answers of a deregistered session.
+func (s *SessionAnswers) Remove(correlationID string) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	delete(s.sessions, correlationID)
+	s.Unlock()
+}
+
+// prune removes the sessions which are no longer registered
+func (s *SessionAnswers) prune() {
+	for correlationID := range s.sessions {
+		if !s.alive(correlationID) {
+			delete(s.sessions, correlationID)
+		}
+	}
+}
+
+// sessionRecords returns the answers set by the session of a name for a query type
+func (options *Options) sessionRecords(name string, qtype uint16) []dns.RR {
+	if options.SessionAnswers == nil {
+		return nil
+	}
+	if _, ok := options.domainOf(name); !ok {
+		return nil
+	}
+	_, _, correlationID := options.findUniqueID(name)
+	if correlationID == "" {
+		return nil
+	}
+	if answers := options.SessionAnswers.Get(correlationID); answers != nil {
+		return answers.records(name, qtype)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionAnswers(t *testing.T) {
+	encoded := encodedTestPublicKey(t)
+
+	store := storage.New(time.Hour)
+	options := &Options{Domain: "example.com", Storage: store, SessionAnswers: NewSessionAnswers(store.HasID)}
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+	post := func(path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.nontlsserver.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://example.com"+path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := post("/register", `{"public-key":"`+encoded+`","secret-key":"secret","correlation-id":"c23b2la0kl1krjcrdj10","dns-answers":{"a":["10.0.0.1"],"txt":["data"],"ttl":5}}`)
+	require.Equal(t, http.StatusOK, rec.Code, "could not register with dns answers")
+	name := "x.c23b2la0kl1krjcrdj10cg5ugboyyyyyn.example.com."
+	records := options.sessionRecords(name, dns.TypeA)
+	require.Len(t, records, 1, "could not get a answer")
+	require.Equal(t, "10.0.0.1", records[0].(*dns.A).A.String(), "could not get a value")
+	require.Equal(t, uint32(5), records[0].Header().Ttl, "could not get ttl")
+	require.Equal(t, []string{"data"}, options.sessionRecords(name, dns.TypeTXT)[0].(*dns.TXT).Txt, "could not get txt value")
+	require.Empty(t, options.sessionRecords(name, dns.TypeAAAA), "got aaaa answer")
+	require.Empty(t, options.sessionRecords("c6rj61aciaeutn2ae680cg5ugboyyyyyn.example.com.", dns.TypeA), "got answer of another session")
+
+	rec = post("/dns-answers", `{"correlation-id":"c23b2la0kl1krjcrdj10","secret-key":"wrong","dns-answers":{"cname":"internal.example.net"}}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code, "set dns answers with wrong secret")
+	rec = post("/dns-answers", `{"correlation-id":"c23b2la0kl1krjcrdj10","secret-key":"secret","dns-answers":{"cname":"internal.example.net"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, "could not set dns answers")
+	records = options.sessionRecords(name, dns.TypeAAAA)
+	require.Equal(t, "internal.example.net.", records[0].(*dns.CNAME).Target, "could not get cname answering every type")
+
+	rec = post("/dns-answers", `{"correlation-id":"c23b2la0kl1krjcrdj10","secret-key":"secret","dns-answers":{"a":["::1"]}}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code, "set invalid dns answers")
+	rec = post("/dns-answers", `{"correlation-id":"c23b2la0kl1krjcrdj10","secret-key":"secret"}`)
+	require.Equal(t, http.StatusOK, rec.Code, "could not remove dns answers")
+	require.Empty(t, options.sessionRecords(name, dns.TypeA), "could not remove dns answers")
+
+	require.NotNil(t, (&DNSAnswers{CNAME: "a.example.net", A: []string{"10.0.0.1"}}).Validate(), "validated cname with other answers")
+	require.Len(t, splitTXT(strings.Repeat("a", 600)), 3, "could not split long txt value")
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSDeduplicator collapses the identical dns queries sent by a resolver
+// retrying a query (same name, type and source) within a window into a
+// single interaction with the number of retries.
+type DNSDeduplicator struct {
+	window time.Duration
+
+	sync.Mutex
+	pending map[dnsQueryKey]*Interaction
+}
+
+// dnsQueryKey identifies the retries of a dns query
+type dnsQueryKey struct {
+	name          string
+	qtype         string
+	remoteAddress string
+}
+
+// NewDNSDeduplicator returns a new deduplicator collapsing the queries within the window.
+func NewDNSDeduplicator(window time.Duration) *DNSDeduplicator {
+	return &DNSDeduplicator{window: window, pending: make(map[dnsQueryKey]*Interaction)}
+}
+
+// Add records a dns interaction, the first query of the window is stored
+// once the window has elapsed with the retries received in the meantime.
+func (d *DNSDeduplicator) Add(interaction *Interaction, store func(*Interaction)) {
+	// resolvers randomizing the case (0x20) use a different one for each retry
+	key := dnsQueryKey{name: strings.ToLower(interaction.FullId), qtype: interaction.QType, remoteAddress: interaction.RemoteAddress}
+
+	d.Lock()
+	defer d.Unlock()
+
+	if pending, ok := d.pending[key]; ok {
+		pending.Retries++
+		return
+	}
+	d.pending[key] = interaction
+	time.AfterFunc(d.window, func() {
+		d.Lock()
+		delete(d.pending, key)
+		d.Unlock()
+
+		store(interaction)
+	})
+}
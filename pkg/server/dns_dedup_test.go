@@ -0,0 +1,49 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSDeduplicator(t *testing.T) {
+	deduplicator := NewDNSDeduplicator(50 * time.Millisecond)
+
+	var mutex sync.Mutex
+	var stored []*Interaction
+	store := func(interaction *Interaction) {
+		mutex.Lock()
+		stored = append(stored, interaction)
+		mutex.Unlock()
+	}
+
+	newInteraction := func(fullID, qtype, remoteAddress string) *Interaction {
+		return &Interaction{Protocol: "dns", FullId: fullID, QType: qtype, RemoteAddress: remoteAddress}
+	}
+	deduplicator.Add(newInteraction("abc.example.com", "A", "8.8.8.8"), store)
+	deduplicator.Add(newInteraction("ABC.example.com", "A", "8.8.8.8"), store)
+	deduplicator.Add(newInteraction("abc.example.com", "A", "8.8.8.8"), store)
+	deduplicator.Add(newInteraction("abc.example.com", "AAAA", "8.8.8.8"), store)
+	deduplicator.Add(newInteraction("abc.example.com", "A", "1.1.1.1"), store)
+
+	require.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(stored) == 3
+	}, time.Second, 10*time.Millisecond, "could not store deduplicated interactions")
+
+	retries := make(map[string]int)
+	for _, interaction := range stored {
+		retries[interaction.QType+" "+interaction.RemoteAddress] = interaction.Retries
+	}
+	require.Equal(t, map[string]int{"A 8.8.8.8": 2, "AAAA 8.8.8.8": 0, "A 1.1.1.1": 0}, retries, "could not count retries")
+
+	deduplicator.Add(newInteraction("abc.example.com", "A", "8.8.8.8"), store)
+	require.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(stored) == 4
+	}, time.Second, 10*time.Millisecond, "could not store query after the window")
+}
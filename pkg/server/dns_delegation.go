@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// NameServer is a name server of a delegated zone, with the ip of its glue
+// record if it's inside the zone of the server.
+type NameServer struct {
+	Name string
+	IP   net.IP
+}
+
+// DNSDelegations are the sub-zones of the domains delegated to other name
+// servers, answered with a referral to their name servers instead of the
+// dynamic answers so that other tooling can serve a part of the domain.
+type DNSDelegations struct {
+	zones map[string][]NameServer
+}
+
+// NewDNSDelegations returns the delegations of the sub-zones to their name servers.
+func NewDNSDelegations(zones map[string][]NameServer) *DNSDelegations {
+	delegations := &DNSDelegations{zones: make(map[string][]NameServer, len(zones))}
+	for zone, nameServers := range zones {
+		delegations.zones[strings.ToLower(dns.Fqdn(zone))] = nameServers
+	}
+	return delegations
+}
+
+// match returns the delegated sub-zone a name belongs to, the longest one
+// if nested, empty if the name isn't delegated. The DS records of the
+// delegation points are answered by the parent zone.
+func (d *DNSDelegations) match(name string, qtype uint16) string {
+	if d == nil {
+		return ""
+	}
+	name = strings.ToLower(dns.Fqdn(name))
+	var matched string
+	for zone := range d.zones {
+		if name == zone && qtype == dns.TypeDS {
+			continue
+		}
+		if (name == zone || strings.HasSuffix(name, "."+zone)) && len(zone) > len(matched) {
+			matched = zone
+		}
+	}
+	return matched
+}
+
+// handleDelegation answers a query of a delegated sub-zone with a referral
+// to its name servers and their glue records.
+func (h *DNSServer) handleDelegation(zone string, m *dns.Msg) {
+	m.Authoritative = false
+	for _, nameServer := range h.options.DNSDelegations.zones[zone] {
+		m.Ns = append(m.Ns, &dns.NS{Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: h.timeToLive}, Ns: nameServer.Name})
+	}
+	for _, nameServer := range h.options.DNSDelegations.zones[zone] {
+		switch {
+		case nameServer.IP == nil:
+		case nameServer.IP.To4() != nil:
+			m.Extra = append(m.Extra, &dns.A{Hdr: dns.RR_Header{Name: nameServer.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: h.timeToLive}, A: nameServer.IP.To4()})
+		default:
+			m.Extra = append(m.Extra, &dns.AAAA{Hdr: dns.RR_Header{Name: nameServer.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: h.timeToLive}, AAAA: nameServer.IP})
+		}
+	}
+}
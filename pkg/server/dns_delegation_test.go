@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSDelegations(t *testing.T) {
+	delegations := NewDNSDelegations(map[string][]NameServer{
+		"sub.example.com": {{Name: "ns1.sub.example.com.", IP: net.ParseIP("5.6.7.8")}, {Name: "ns.provider.net."}},
+	})
+	require.Equal(t, "sub.example.com.", delegations.match("X.Sub.example.com.", dns.TypeA), "could not match name of sub-zone")
+	require.Equal(t, "sub.example.com.", delegations.match("sub.example.com.", dns.TypeNS), "could not match delegation point")
+	require.Empty(t, delegations.match("sub.example.com.", dns.TypeDS), "delegated ds record of delegation point")
+	require.Empty(t, delegations.match("xsub.example.com.", dns.TypeA), "matched name outside of sub-zone")
+
+	server := NewDNSServer("udp", &Options{Domain: "example.com", IPAddress: "1.2.3.4", DNSDelegations: delegations})
+	w := &testResponseWriter{}
+	server.ServeDNS(w, new(dns.Msg).SetQuestion("test.sub.example.com.", dns.TypeA))
+	require.False(t, w.msg.Authoritative, "answered referral as authoritative")
+	require.Empty(t, w.msg.Answer, "answered name of delegated sub-zone")
+	require.Len(t, w.msg.Ns, 2, "could not get name servers of sub-zone")
+	require.Equal(t, "sub.example.com.", w.msg.Ns[0].Header().Name, "could not get delegation point")
+	require.Len(t, w.msg.Extra, 1, "could not get glue record of name server inside sub-zone")
+	require.Equal(t, "5.6.7.8", w.msg.Extra[0].(*dns.A).A.String(), "could not get glue ip")
+
+	server.ServeDNS(w, new(dns.Msg).SetQuestion("test.example.com.", dns.TypeA))
+	require.True(t, w.msg.Authoritative, "could not answer name outside of sub-zone")
+	require.Equal(t, "1.2.3.4", w.msg.Answer[0].(*dns.A).A.String(), "could not answer name outside of sub-zone")
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// rebindLabelPrefix is the prefix of the labels of the dns rebinding payloads
+	rebindLabelPrefix = "rebind-"
+	// rebindStateTTL is the time the resolutions of a rebinding name are counted for since the last one
+	rebindStateTTL = 10 * time.Minute
+	// maxRebindStates is the number of rebinding names tracked before the expired ones are pruned
+	maxRebindStates = 10000
+)
+
+// DNSRebinder answers the dns rebinding payloads, whose rebind-<ip1>-<ip2>
+// label alternates the answers between two ips on every resolution, or
+// switches from the first one to the second one a delay in seconds after
+// the first resolution with rebind-<ip1>-<ip2>-<delay>. The ips are either
+// hex encoded (7f000001) or separated by dashes (127-0-0-1).
+type DNSRebinder struct {
+	mutex  sync.Mutex
+	states map[rebindKey]*rebindState
+}
+
+// rebindKey identifies the resolutions of a name, counted per query type
+// for the clients resolving both the A and AAAA records
+type rebindKey struct {
+	name  string
+	qtype uint16
+}
+
+// rebindState are the resolutions of a rebinding name
+type rebindState struct {
+	resolutions int
+	first       time.Time
+	expires     time.Time
+}
+
+// rebindTarget are the answers of a rebinding name
+type rebindTarget struct {
+	first  net.IP
+	second net.IP
+	delay  time.Duration
+}
+
+// NewDNSRebinder returns a new dns rebinding answerer.
+func NewDNSRebinder() *DNSRebinder {
+	return &DNSRebinder{states: make(map[rebindKey]*rebindState)}
+}
+
+// parseRebindTarget returns the answers of the rebinding label of a name
+func parseRebindTarget(name string) (*rebindTarget, bool) {
+	for _, label := range strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".") {
+		if !strings.HasPrefix(label, rebindLabelPrefix) {
+			continue
+		}
+		parts := strings.Split(label[len(rebindLabelPrefix):], "-")
+		target := &rebindTarget{}
+		var rest []string
+		switch len(parts) {
+		case 2, 3:
+			target.first, target.second, rest = parseHexIP(parts[0]), parseHexIP(parts[1]), parts[2:]
+		case 8, 9:
+			target.first = net.ParseIP(strings.Join(parts[:4], ".")).To4()
+			target.second = net.ParseIP(strings.Join(parts[4:8], ".")).To4()
+			rest = parts[8:]
+		default:
+			continue
+		}
+		if target.first == nil || target.second == nil {
+			continue
+		}
+		if len(rest) == 1 {
+			seconds, err := strconv.Atoi(rest[0])
+			if err != nil || seconds <= 0 {
+				continue
+			}
+			target.delay = time.Duration(seconds) * time.Second
+		}
+		return target, true
+	}
+	return nil, false
+}
+
+// parseHexIP returns the ipv4 or ipv6 address of its hex encoding
+func parseHexIP(value string) net.IP {
+	if len(value) != 2*net.IPv4len && len(value) != 2*net.IPv6len {
+		return nil
+	}
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+	return net.IP(data)
+}
+
+// answer returns the ip answering an A or AAAA query of a rebinding name,
+// nil if the ip of the resolution doesn't match the query type.
+func (r *DNSRebinder) answer(name string, qtype uint16) (net.IP, bool) {
+	if r == nil || qtype != dns.TypeA && qtype != dns.TypeAAAA {
+		return nil, false
+	}
+	target, ok := parseRebindTarget(name)
+	if !ok {
+		return nil, false
+	}
+	// resolvers randomizing the case (0x20) use a different one for each query
+	key := rebindKey{name: strings.ToLower(name), qtype: qtype}
+	now := time.Now()
+
+	r.mutex.Lock()
+	state, ok := r.states[key]
+	if !ok || !now.Before(state.expires) {
+		if len(r.states) >= maxRebindStates {
+			r.prune(now)
+		}
+		state = &rebindState{first: now}
+		if len(r.states) < maxRebindStates {
+			r.states[key] = state
+		}
+	}
+	state.expires = now.Add(rebindStateTTL)
+	state.resolutions++
+	ip := target.second
+	if target.delay > 0 && now.Sub(state.first) < target.delay || target.delay == 0 && state.resolutions%2 == 1 {
+		ip = target.first
+	}
+	r.mutex.Unlock()
+
+	if (ip.To4() != nil) != (qtype == dns.TypeA) {
+		return nil, true
+	}
+	return ip, true
+}
+
+// prune removes the expired rebinding names
+func (r *DNSRebinder) prune(now time.Time) {
+	for key, state := range r.states {
+		if !now.Before(state.expires) {
+			delete(r.states, key)
+		}
+	}
+}
+
+// handleRebind answers a rebinding query with a zero ttl so that every
+// resolution reaches the server, an empty answer being returned for an
+// ip of the other family.
+func (h *DNSServer) handleRebind(zone string, ipAddress net.IP, m *dns.Msg) {
+	switch {
+	case ipAddress == nil:
+	case ipAddress.To4() != nil:
+		m.Answer = append(m.Answer, &dns.A{Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 0}, A: ipAddress.To4()})
+	default:
+		m.Answer = append(m.Answer, &dns.AAAA{Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 0}, AAAA: ipAddress})
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSRebinder(t *testing.T) {
+	rebinder := NewDNSRebinder()
+	name := "a1.rebind-cb007101-7f000001.c6rj61aciaeutn2ae680cg5ugboyyyyyn.example.com."
+	for i, expected := range []string{"203.0.113.1", "127.0.0.1", "203.0.113.1"} {
+		ip, ok := rebinder.answer(name, dns.TypeA)
+		require.True(t, ok, "could not answer rebinding name")
+		require.Equal(t, expected, ip.String(), "could not get answer %d", i)
+	}
+	ip, ok := rebinder.answer("A1.REBIND-cb007101-7f000001.c6rj61aciaeutn2ae680cg5ugboyyyyyn.example.com.", dns.TypeA)
+	require.True(t, ok, "could not answer randomized case name")
+	require.Equal(t, "127.0.0.1", ip.String(), "could not count randomized case resolutions")
+	ip, ok = rebinder.answer(name, dns.TypeAAAA)
+	require.True(t, ok, "could not answer aaaa query")
+	require.Nil(t, ip, "answered ipv4 to aaaa query")
+
+	target, ok := parseRebindTarget("rebind-203-0-113-1-127-0-0-1-30.example.com")
+	require.True(t, ok, "could not parse dashed ips")
+	require.Equal(t, &rebindTarget{first: net.ParseIP("203.0.113.1").To4(), second: net.ParseIP("127.0.0.1").To4(), delay: 30 * time.Second}, target, "could not parse rebinding target")
+	ip, _ = rebinder.answer("rebind-203-0-113-1-127-0-0-1-30.example.com.", dns.TypeA)
+	require.Equal(t, "203.0.113.1", ip.String(), "could not get first answer")
+	ip, _ = rebinder.answer("rebind-203-0-113-1-127-0-0-1-30.example.com.", dns.TypeA)
+	require.Equal(t, "203.0.113.1", ip.String(), "switched answer before the delay")
+
+	_, ok = rebinder.answer(name, dns.TypeTXT)
+	require.False(t, ok, "answered txt query")
+	_, ok = rebinder.answer("rebind-zz.example.com.", dns.TypeA)
+	require.False(t, ok, "answered invalid rebinding label")
+	var nilRebinder *DNSRebinder
+	_, ok = nilRebinder.answer(name, dns.TypeA)
+	require.False(t, ok, "disabled rebinder answered")
+}
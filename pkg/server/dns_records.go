@@ -0,0 +1,73 @@
+package server
+
+import (
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// defaultDNSRecordTTL is the ttl of the records of the zone file until one sets it
+const defaultDNSRecordTTL = 3600
+
+// dnsRecordTypes are the record types supported in the zone file
+var dnsRecordTypes = map[uint16]struct{}{
+	dns.TypeA:     {},
+	dns.TypeAAAA:  {},
+	dns.TypeCNAME: {},
+	dns.TypeTXT:   {},
+	dns.TypeMX:    {},
+	dns.TypeSRV:   {},
+}
+
+// DNSRecords are the static records of a zone file, served by the dns
+// server instead of the dynamic answers for their names and types (eg.
+// the mta-sts and verification txt records or a www cname).
+type DNSRecords struct {
+	records map[string][]dns.RR
+}
+
+// LoadDNSRecords loads the records of a zone file, the relative names
+// being relative to the origin unless the file sets $ORIGIN.
+func LoadDNSRecords(path, origin string) (*DNSRecords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open zone file")
+	}
+	defer file.Close()
+
+	records := &DNSRecords{records: make(map[string][]dns.RR)}
+	parser := dns.NewZoneParser(file, dns.Fqdn(origin), path)
+	parser.SetDefaultTTL(defaultDNSRecordTTL)
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		header := rr.Header()
+		if _, ok := dnsRecordTypes[header.Rrtype]; !ok {
+			return nil, errors.Errorf("unsupported %s record for %s", dns.TypeToString[header.Rrtype], header.Name)
+		}
+		name := strings.ToLower(header.Name)
+		records.records[name] = append(records.records[name], rr)
+	}
+	if err := parser.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not parse zone file")
+	}
+	return records, nil
+}
+
+// answer returns the records of a name matching a query type, the cname
+// records answering every type. The names of the records are the queried
+// name to keep its case for the resolvers randomizing it (0x20).
+func (r *DNSRecords) answer(name string, qtype uint16) []dns.RR {
+	if r == nil {
+		return nil
+	}
+	var answers []dns.RR
+	for _, rr := range r.records[strings.ToLower(name)] {
+		if rrtype := rr.Header().Rrtype; rrtype == qtype || rrtype == dns.TypeCNAME || qtype == dns.TypeANY {
+			answer := dns.Copy(rr)
+			answer.Header().Name = name
+			answers = append(answers, answer)
+		}
+	}
+	return answers
+}
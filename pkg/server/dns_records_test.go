@@ -0,0 +1,62 @@
+package server
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadDNSRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.zone")
+	zone := "www CNAME example.github.io.\n_mta-sts 300 TXT \"v=STSv1; id=1\"\n@ MX 10 mx.provider.net.\n_xmpp._tcp SRV 10 5 5222 xmpp.example.com.\n"
+	require.Nil(t, ioutil.WriteFile(path, []byte(zone), 0600), "could not write zone file")
+	records, err := LoadDNSRecords(path, "example.com")
+	require.Nil(t, err, "could not load zone file")
+
+	answers := records.answer("WWW.example.com.", dns.TypeA)
+	require.Len(t, answers, 1, "could not get cname of a query")
+	require.Equal(t, "WWW.example.com.", answers[0].Header().Name, "could not keep query name case")
+	require.Equal(t, "example.github.io.", answers[0].(*dns.CNAME).Target, "could not get cname target")
+	require.Equal(t, uint32(defaultDNSRecordTTL), answers[0].Header().Ttl, "could not get default ttl")
+
+	answers = records.answer("_mta-sts.example.com.", dns.TypeTXT)
+	require.Len(t, answers, 1, "could not get txt record")
+	require.Equal(t, uint32(300), answers[0].Header().Ttl, "could not get record ttl")
+	require.Equal(t, []string{"v=STSv1; id=1"}, answers[0].(*dns.TXT).Txt, "could not get txt value")
+	require.Empty(t, records.answer("_mta-sts.example.com.", dns.TypeA), "got txt record for a query")
+	require.Len(t, records.answer("example.com.", dns.TypeMX), 1, "could not get mx record")
+	require.Len(t, records.answer("_xmpp._tcp.example.com.", dns.TypeSRV), 1, "could not get srv record")
+
+	server := NewDNSServer("udp", &Options{Domain: "example.com", IPAddress: "1.2.3.4", DNSRecords: records})
+	m := new(dns.Msg)
+	server.handleMX("test.example.com.", m)
+	require.Equal(t, "mail.example.com.", m.Answer[0].(*dns.MX).Mx, "static record replaced answer of another name")
+
+	require.Nil(t, ioutil.WriteFile(path, []byte("@ NS ns.provider.net.\n"), 0600), "could not write zone file")
+	_, err = LoadDNSRecords(path, "example.com")
+	require.NotNil(t, err, "loaded unsupported record")
+}
+
+func TestDNSRecordsANY(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.zone")
+	require.Nil(t, ioutil.WriteFile(path, []byte("www TXT \"hello\"\n"), 0600), "could not write zone file")
+	records, err := LoadDNSRecords(path, "example.com")
+	require.Nil(t, err, "could not load zone file")
+
+	store := storage.New(time.Hour)
+	options := &Options{Domain: "example.com", IPAddress: "1.2.3.4", Storage: store, DNSRecords: records, SessionAnswers: NewSessionAnswers(store.HasID)}
+	w := &testResponseWriter{}
+	NewDNSServer("udp", options).ServeDNS(w, new(dns.Msg).SetQuestion("www.example.com.", dns.TypeANY))
+	var txt []string
+	for _, answer := range w.msg.Answer {
+		if record, ok := answer.(*dns.TXT); ok {
+			txt = append(txt, record.Txt...)
+		}
+	}
+	require.Equal(t, []string{"hello"}, txt, "could not answer static records to any query")
+}
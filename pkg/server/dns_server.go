@@ -1,53 +1,102 @@
 package server
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"net"
 	"strings"
 	"time"
 
-	jsoniter "github.com/json-iterator/go"
 	"github.com/miekg/dns"
 	"github.com/projectdiscovery/gologger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // DNSServer is a DNS server instance that listens on port 53.
 type DNSServer struct {
 	options    *Options
-	mxDomain   string
-	ns1Domain  string
-	ns2Domain  string
-	dotDomain  string
+	zones      []*dnsZone
+	soa        SOA
 	ipAddress  net.IP
 	timeToLive uint32
 	server     *dns.Server
 	TxtRecord  string // used for ACME verification
 }
 
+// dnsZone contains the records of a domain of the server
+type dnsZone struct {
+	dotDomain string
+	mxDomain  string
+	nsDomains []string
+}
+
 // NewDNSServer returns a new DNS server.
 func NewDNSServer(network string, options *Options) *DNSServer {
-	dotdomain := dns.Fqdn(options.Domain)
 	server := &DNSServer{
 		options:    options,
 		ipAddress:  net.ParseIP(options.IPAddress),
-		mxDomain:   "mail." + dotdomain,
-		ns1Domain:  "ns1." + dotdomain,
-		ns2Domain:  "ns2." + dotdomain,
-		dotDomain:  "." + dotdomain,
-		timeToLive: 3600,
+		soa:        DefaultSOA,
+		timeToLive: DefaultTimeToLive,
+	}
+	if options.TimeToLive != nil {
+		server.timeToLive = *options.TimeToLive
 	}
+	for _, domain := range options.GetDomains() {
+		dotdomain := dns.Fqdn(domain)
+		server.zones = append(server.zones, &dnsZone{
+			dotDomain: "." + dotdomain,
+			mxDomain:  "mail." + dotdomain,
+			nsDomains: nameServers(options.NameServers, dotdomain),
+		})
+	}
+	if options.SOA != nil {
+		server.soa = *options.SOA
+	}
+	server.soa.Mbox = dns.Fqdn(server.soa.Mbox)
 	server.server = &dns.Server{
 		Addr:    options.ListenIP + fmt.Sprintf(":%d", options.DnsPort),
 		Net:     network,
 		Handler: server,
+		// queries with edns0 options may be larger than the default 512 bytes
+		UDPSize: maxUDPSize,
 	}
 	return server
 }
 
+// nameServers returns the fully qualified name servers of the zone,
+// names without a dot are hosts of the zone (defaults to ns1 and ns2).
+func nameServers(names []string, dotdomain string) []string {
+	if len(names) == 0 {
+		names = []string{"ns1", "ns2"}
+	}
+	nsDomains := make([]string, 0, len(names))
+	for _, name := range names {
+		if !strings.Contains(strings.TrimSuffix(name, "."), ".") {
+			name = strings.TrimSuffix(name, ".") + "." + dotdomain
+		}
+		nsDomains = append(nsDomains, dns.Fqdn(name))
+	}
+	return nsDomains
+}
+
+// zone returns the zone a name belongs to, defaulting to the first domain
+func (h *DNSServer) zone(name string) *dnsZone {
+	if domain, ok := h.options.domainOf(name); ok {
+		for _, zone := range h.zones {
+			if strings.EqualFold(zone.dotDomain, "."+dns.Fqdn(domain)) {
+				return zone
+			}
+		}
+	}
+	return h.zones[0]
+}
+
 // ListenAndServe listens on dns ports for the server.
 func (h *DNSServer) ListenAndServe(dnsAlive chan bool) {
+	if h.options.DnsPort == 0 {
+		return
+	}
 	dnsAlive <- true
 	if err := h.server.ListenAndServe(); err != nil {
 		gologger.Error().Msgf("Could not listen for %s DNS on %s (%s)\n", strings.ToUpper(h.server.Net), h.server.Addr, err)
@@ -56,10 +105,34 @@ func (h *DNSServer) ListenAndServe(dnsAlive chan bool) {
 }
 
 const (
-	dnsChallengeString   = "_acme-challenge."
-	certificateAuthority = "letsencrypt.org."
+	dnsChallengeString = "_acme-challenge."
+	// maxUDPSize is the edns0 udp payload size advertised by the server, avoiding
+	// the fragmentation of the larger responses (dns flag day 2020)
+	maxUDPSize = 1232
 )
 
+// SOA contains the fields of the SOA record of the zone
+type SOA struct {
+	// Mbox is the mailbox of the zone administrator
+	Mbox string
+	// Serial is the serial number of the zone
+	Serial uint32
+	// Refresh is the interval in seconds before secondaries refresh the zone
+	Refresh uint32
+	// Retry is the interval in seconds before secondaries retry a failed refresh
+	Retry uint32
+	// Expire is the time in seconds after which secondaries stop answering for the zone
+	Expire uint32
+	// MinTTL is the ttl of the negative responses
+	MinTTL uint32
+}
+
+// DefaultTimeToLive is the ttl of the answers when none is configured
+const DefaultTimeToLive = 3600
+
+// DefaultSOA is the SOA record of the zone when none is configured
+var DefaultSOA = SOA{Mbox: "letsencrypt.org.", Serial: 1, Refresh: 3600, Retry: 600, Expire: 86400, MinTTL: 60}
+
 // ServeDNS is the default handler for DNS queries.
 func (h *DNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	m := new(dns.Msg)
@@ -70,8 +143,39 @@ func (h *DNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	if len(r.Question) == 0 {
 		return
 	}
+	// only the version 0 of edns is supported (rfc 6891)
+	if opt := r.IsEdns0(); opt != nil && opt.Version() != 0 {
+		m.Rcode = dns.RcodeBadVers
+		m.SetEdns0(maxUDPSize, false)
+		if err := w.WriteMsg(m); err != nil {
+			gologger.Warning().Msgf("Could not write DNS response: \n%s\n %s\n", m.String(), err)
+		}
+		return
+	}
+	_, span := tracer.Start(context.Background(), "dns.query", trace.WithAttributes(
+		attribute.String("dns.network", h.server.Net),
+		attribute.String("dns.name", r.Question[0].Name),
+		attribute.String("dns.type", toQType(r.Question[0].Qtype)),
+	))
+	defer span.End()
+
+	// zone transfers are refused, the attempts being recorded as axfr interactions
+	if qtype := r.Question[0].Qtype; qtype == dns.TypeAXFR || qtype == dns.TypeIXFR {
+		m.Rcode = dns.RcodeRefused
+		if !h.options.allowSource("dns", w.RemoteAddr().String()) {
+			return
+		}
+		h.handleInteraction(r.Question[0].Name, w, r, m)
+		h.fitResponse(r, m)
+		if err := w.WriteMsg(m); err != nil {
+			setSpanError(span, err)
+			gologger.Warning().Msgf("Could not write DNS response: \n%s\n %s\n", m.String(), err)
+		}
+		return
+	}
 
 	isDNSChallenge := false
+	delegation := ""
 	for _, question := range r.Question {
 		domain := question.Name
 
@@ -97,10 +201,25 @@ func (h *DNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 			}
 
 			gologger.Debug().Msgf("Got acme dns response: \n%s\n", m.String())
+		} else if h.options.Revocations.NXDomain(domain) {
+			m.Rcode = dns.RcodeNameError
+		} else if delegation = h.options.DNSDelegations.match(domain, question.Qtype); delegation != "" {
+			h.handleDelegation(delegation, m)
+		} else if records := h.options.DNSRecords.answer(domain, question.Qtype); len(records) > 0 && question.Qtype != dns.TypeANY {
+			m.Answer = append(m.Answer, records...)
+		} else if answers := h.options.sessionRecords(domain, question.Qtype); len(answers) > 0 {
+			m.Answer = append(m.Answer, answers...)
+		} else if ipAddress, ok := h.options.DNSRebinder.answer(domain, question.Qtype); ok {
+			h.handleRebind(domain, ipAddress, m)
 		} else {
 			switch question.Qtype {
-			case dns.TypeA, dns.TypeAAAA, dns.TypeCNAME, dns.TypeANY:
+			case dns.TypeA, dns.TypeAAAA, dns.TypeCNAME:
 				h.handleACNAMEANY(domain, m)
+			case dns.TypeANY:
+				h.handleANY(domain, m)
+				m.Answer = append(m.Answer, records...)
+			case dns.TypeHTTPS, dns.TypeSVCB:
+				h.handleSVCB(domain, question.Qtype, m)
 			case dns.TypeMX:
 				h.handleMX(domain, m)
 			case dns.TypeNS:
@@ -108,20 +227,67 @@ func (h *DNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 			case dns.TypeSOA:
 				h.handleSOA(domain, m)
 			case dns.TypeTXT:
-				h.handleTXT(domain, m)
+				if !h.handleMailTXT(domain, m) {
+					h.handleTXT(domain, m)
+				}
+			case dns.TypeDNSKEY:
+				h.options.DNSSEC.handleDNSKEY(domain, m)
 			}
 		}
 	}
-	if !isDNSChallenge {
+	// the empty answers carry the soa record for the resolvers to cache them for its minimum ttl
+	if len(m.Answer) == 0 && len(m.Ns) == 0 {
+		m.Ns = append(m.Ns, h.soaRecord(r.Question[0].Name))
+	}
+	// the queries of the delegated sub-zones belong to their name servers
+	if !isDNSChallenge && delegation == "" {
+		if !h.options.allowSource("dns", w.RemoteAddr().String()) {
+			return
+		}
+		if h.options.ResolverStats != nil {
+			host, _, _ := net.SplitHostPort(w.RemoteAddr().String())
+			h.options.ResolverStats.Record(host, h.server.Net, r)
+		}
 		// Write interaction for first question and dns request
 		h.handleInteraction(r.Question[0].Name, w, r, m)
 	}
 
+	if opt := r.IsEdns0(); opt != nil && opt.Do() && h.options.DNSSEC != nil {
+		if delegation != "" {
+			h.options.DNSSEC.signDelegation(m, delegation)
+		} else {
+			h.options.DNSSEC.sign(m, r.Question[0].Name, r.Question[0].Qtype)
+		}
+	}
+	h.fitResponse(r, m)
+
 	if err := w.WriteMsg(m); err != nil {
+		setSpanError(span, err)
 		gologger.Warning().Msgf("Could not write DNS response: \n%s\n %s\n", m.String(), err)
 	}
 }
 
+// fitResponse answers the edns0 queries with the server payload size and
+// truncates the udp responses larger than the client payload size, setting
+// the TC bit for the client to retry over tcp which isn't size limited.
+func (h *DNSServer) fitResponse(r, m *dns.Msg) {
+	size := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		m.SetEdns0(maxUDPSize, opt.Do() && h.options.DNSSEC != nil)
+		if int(opt.UDPSize()) > size {
+			size = int(opt.UDPSize())
+		}
+		if size > maxUDPSize {
+			size = maxUDPSize
+		}
+	}
+	if h.server.Net == "udp" {
+		m.Truncate(size)
+	} else {
+		m.Compress = true
+	}
+}
+
 // handleACMETXTChallenge handles solving of ACME TXT challenge with the given provider
 func (h *DNSServer) handleACMETXTChallenge(zone string, m *dns.Msg) error {
 	records, err := h.options.ACMEStore.GetRecords(context.Background(), strings.ToLower(zone))
@@ -141,83 +307,139 @@ func (h *DNSServer) handleACMETXTChallenge(zone string, m *dns.Msg) error {
 // handleACNAMEANY handles A, CNAME or ANY queries for DNS server
 func (h *DNSServer) handleACNAMEANY(zone string, m *dns.Msg) {
 	nsHeader := dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: h.timeToLive}
+	dnsZone := h.zone(zone)
 
 	resultFunction := func(ipAddress net.IP) {
 		m.Answer = append(m.Answer, &dns.A{Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: h.timeToLive}, A: ipAddress})
 
-		m.Ns = append(m.Ns, &dns.NS{Hdr: nsHeader, Ns: h.ns1Domain})
-		m.Ns = append(m.Ns, &dns.NS{Hdr: nsHeader, Ns: h.ns2Domain})
-		m.Extra = append(m.Extra, &dns.A{Hdr: dns.RR_Header{Name: h.ns1Domain, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: h.timeToLive}, A: h.ipAddress})
-		m.Extra = append(m.Extra, &dns.A{Hdr: dns.RR_Header{Name: h.ns2Domain, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: h.timeToLive}, A: h.ipAddress})
+		for _, nsDomain := range dnsZone.nsDomains {
+			m.Ns = append(m.Ns, &dns.NS{Hdr: nsHeader, Ns: nsDomain})
+		}
+		// glue records are only returned for the name servers in the zone
+		for _, nsDomain := range dnsZone.nsDomains {
+			if strings.HasSuffix(strings.ToLower(nsDomain), strings.ToLower(dnsZone.dotDomain)) {
+				m.Extra = append(m.Extra, &dns.A{Hdr: dns.RR_Header{Name: nsDomain, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: h.timeToLive}, A: h.ipAddress})
+			}
+		}
 	}
 
+	resultFunction(h.zoneIPAddress(zone))
+}
+
+// zoneIPAddress returns the ip address a zone resolves to
+func (h *DNSServer) zoneIPAddress(zone string) net.IP {
+	dotDomain := h.zone(zone).dotDomain
 	switch {
-	case strings.EqualFold(zone, "aws"+h.dotDomain):
-		resultFunction(net.ParseIP("169.254.169.254"))
-	case strings.EqualFold(zone, "alibaba"+h.dotDomain):
-		resultFunction(net.ParseIP("100.100.100.200"))
+	case strings.EqualFold(zone, "aws"+dotDomain):
+		return net.ParseIP("169.254.169.254")
+	case strings.EqualFold(zone, "alibaba"+dotDomain):
+		return net.ParseIP("100.100.100.200")
 	default:
-		resultFunction(h.ipAddress)
+		return h.ipAddress
+	}
+}
+
+// handleANY handles ANY queries for DNS server answering with
+// the address, mail and service binding records of the zone
+func (h *DNSServer) handleANY(zone string, m *dns.Msg) {
+	h.handleACNAMEANY(zone, m)
+	h.handleMX(zone, m)
+	h.handleSVCB(zone, dns.TypeHTTPS, m)
+}
+
+// handleSVCB handles HTTPS and SVCB queries for DNS server pointing
+// clients to the http server of the zone address
+func (h *DNSServer) handleSVCB(zone string, qtype uint16, m *dns.Msg) {
+	svcb := dns.SVCB{
+		Hdr:      dns.RR_Header{Name: zone, Rrtype: qtype, Class: dns.ClassINET, Ttl: h.timeToLive},
+		Priority: 1,
+		Target:   ".",
+		Value:    []dns.SVCBKeyValue{&dns.SVCBAlpn{Alpn: []string{"h2", "http/1.1"}}},
+	}
+	if h.options.HttpsPort != 443 && h.options.HttpsPort != 0 {
+		svcb.Value = append(svcb.Value, &dns.SVCBPort{Port: uint16(h.options.HttpsPort)})
+	}
+	if ipAddress := h.zoneIPAddress(zone); ipAddress.To4() != nil {
+		svcb.Value = append(svcb.Value, &dns.SVCBIPv4Hint{Hint: []net.IP{ipAddress.To4()}})
+	} else if ipAddress != nil {
+		svcb.Value = append(svcb.Value, &dns.SVCBIPv6Hint{Hint: []net.IP{ipAddress}})
+	}
+
+	if qtype == dns.TypeHTTPS {
+		m.Answer = append(m.Answer, &dns.HTTPS{SVCB: svcb})
+	} else {
+		m.Answer = append(m.Answer, &svcb)
 	}
 }
 
 func (h *DNSServer) handleMX(zone string, m *dns.Msg) {
 	nsHdr := dns.RR_Header{Name: zone, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: h.timeToLive}
-	m.Answer = append(m.Answer, &dns.MX{Hdr: nsHdr, Mx: h.mxDomain, Preference: 1})
+	m.Answer = append(m.Answer, &dns.MX{Hdr: nsHdr, Mx: h.zone(zone).mxDomain, Preference: 1})
 }
 
 func (h *DNSServer) handleNS(zone string, m *dns.Msg) {
 	nsHeader := dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: h.timeToLive}
-	m.Answer = append(m.Answer, &dns.NS{Hdr: nsHeader, Ns: h.ns1Domain})
-	m.Answer = append(m.Answer, &dns.NS{Hdr: nsHeader, Ns: h.ns2Domain})
+	for _, nsDomain := range h.zone(zone).nsDomains {
+		m.Answer = append(m.Answer, &dns.NS{Hdr: nsHeader, Ns: nsDomain})
+	}
 }
 
 func (h *DNSServer) handleSOA(zone string, m *dns.Msg) {
-	nsHdr := dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET}
-	m.Answer = append(m.Answer, &dns.SOA{Hdr: nsHdr, Ns: h.ns1Domain, Mbox: certificateAuthority, Serial: 1, Expire: 60, Minttl: 60})
+	m.Answer = append(m.Answer, h.soaRecord(zone))
+}
+
+// soaRecord returns the SOA record of the zone of a name
+func (h *DNSServer) soaRecord(zone string) *dns.SOA {
+	nsHdr := dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: h.soa.MinTTL}
+	return &dns.SOA{
+		Hdr:     nsHdr,
+		Ns:      h.zone(zone).nsDomains[0],
+		Mbox:    h.soa.Mbox,
+		Serial:  h.soa.Serial,
+		Refresh: h.soa.Refresh,
+		Retry:   h.soa.Retry,
+		Expire:  h.soa.Expire,
+		Minttl:  h.soa.MinTTL,
+	}
 }
 
 func (h *DNSServer) handleTXT(zone string, m *dns.Msg) {
 	m.Answer = append(m.Answer, &dns.TXT{Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0}, Txt: []string{h.TxtRecord}})
 }
 
-func toQType(ttype uint16) (rtype string) {
-	switch ttype {
-	case dns.TypeA:
-		rtype = "A"
-	case dns.TypeNS:
-		rtype = "NS"
-	case dns.TypeCNAME:
-		rtype = "CNAME"
-	case dns.TypeSOA:
-		rtype = "SOA"
-	case dns.TypePTR:
-		rtype = "PTR"
-	case dns.TypeMX:
-		rtype = "MX"
-	case dns.TypeTXT:
-		rtype = "TXT"
-	case dns.TypeAAAA:
-		rtype = "AAAA"
-	}
-	return
+// toQType returns the name of a query type (eg. HTTPS), or TYPE<N> for unknown types
+func toQType(ttype uint16) string {
+	if rtype, ok := dns.TypeToString[ttype]; ok {
+		return rtype
+	}
+	return fmt.Sprintf("TYPE%d", ttype)
 }
 
 // handleInteraction handles an interaction for the DNS server
 func (h *DNSServer) handleInteraction(domain string, w dns.ResponseWriter, r *dns.Msg, m *dns.Msg) {
-	var uniqueID, fullID string
+	var uniqueID, fullID, correlationID string
+	protocol := "dns"
+	if qtype := r.Question[0].Qtype; qtype == dns.TypeAXFR || qtype == dns.TypeIXFR {
+		protocol = "axfr"
+	}
 
 	requestMsg := r.String()
 	responseMsg := m.String()
 
 	gologger.Debug().Msgf("New DNS request: %s\n", requestMsg)
 
+	_, isDomain := h.options.domainOf(domain)
+	var capture *packetCapture
+	if h.options.PCAP != nil {
+		capture = captureDNS(w, r, m)
+	}
+
 	// if root-tld is enabled stores any interaction towards the main domain
-	if h.options.RootTLD && strings.HasSuffix(domain, h.dotDomain) {
+	if h.options.RootTLD && isDomain {
 		correlationID := h.options.Domain
 		host, _, _ := net.SplitHostPort(w.RemoteAddr().String())
 		interaction := &Interaction{
-			Protocol:      "dns",
+			Protocol:      protocol,
 			UniqueID:      domain,
 			FullId:        domain,
 			QType:         toQType(r.Question[0].Qtype),
@@ -225,37 +447,21 @@ func (h *DNSServer) handleInteraction(domain string, w dns.ResponseWriter, r *dn
 			RawResponse:   responseMsg,
 			RemoteAddress: host,
 			Timestamp:     time.Now(),
+			capture:       capture,
 		}
-		buffer := &bytes.Buffer{}
-		if err := jsoniter.NewEncoder(buffer).Encode(interaction); err != nil {
-			gologger.Warning().Msgf("Could not encode root tld dns interaction: %s\n", err)
-		} else {
-			gologger.Debug().Msgf("Root TLD DNS Interaction: \n%s\n", buffer.String())
-			if err := h.options.Storage.AddInteractionWithId(correlationID, buffer.Bytes()); err != nil {
-				gologger.Warning().Msgf("Could not store dns interaction: %s\n", err)
-			}
-		}
+		h.storeInteraction(interaction, func(interaction *Interaction) {
+			h.options.storeInteractionWithId(correlationID, interaction)
+		})
 	}
 
-	if strings.HasSuffix(domain, h.dotDomain) {
-		parts := strings.Split(domain, ".")
-		for i, part := range parts {
-			if len(part) == 33 {
-				uniqueID = part
-				fullID = part
-				if i+1 <= len(parts) {
-					fullID = strings.Join(parts[:i+1], ".")
-				}
-			}
-		}
+	if isDomain {
+		uniqueID, fullID, correlationID = h.options.findUniqueID(domain)
 	}
-	uniqueID = strings.ToLower(uniqueID)
 
 	if uniqueID != "" {
-		correlationID := uniqueID[:20]
 		host, _, _ := net.SplitHostPort(w.RemoteAddr().String())
 		interaction := &Interaction{
-			Protocol:      "dns",
+			Protocol:      protocol,
 			UniqueID:      uniqueID,
 			FullId:        fullID,
 			QType:         toQType(r.Question[0].Qtype),
@@ -263,15 +469,34 @@ func (h *DNSServer) handleInteraction(domain string, w dns.ResponseWriter, r *dn
 			RawResponse:   responseMsg,
 			RemoteAddress: host,
 			Timestamp:     time.Now(),
+			capture:       capture,
 		}
-		buffer := &bytes.Buffer{}
-		if err := jsoniter.NewEncoder(buffer).Encode(interaction); err != nil {
-			gologger.Warning().Msgf("Could not encode dns interaction: %s\n", err)
-		} else {
-			gologger.Debug().Msgf("DNS Interaction: \n%s\n", buffer.String())
-			if err := h.options.Storage.AddInteraction(correlationID, buffer.Bytes()); err != nil {
-				gologger.Warning().Msgf("Could not store dns interaction: %s\n", err)
-			}
+		h.storeInteraction(interaction, func(interaction *Interaction) {
+			h.options.storeInteraction(correlationID, interaction)
+		})
+	} else if h.options.WildcardLog && !(h.options.RootTLD && isDomain) {
+		host, _, _ := net.SplitHostPort(w.RemoteAddr().String())
+		interaction := &Interaction{
+			Protocol:      protocol,
+			UniqueID:      domain,
+			FullId:        domain,
+			QType:         toQType(r.Question[0].Qtype),
+			RawRequest:    requestMsg,
+			RawResponse:   responseMsg,
+			RemoteAddress: host,
+			Timestamp:     time.Now(),
+			capture:       capture,
 		}
+		h.storeInteraction(interaction, h.options.storeWildcardInteraction)
+	}
+}
+
+// storeInteraction stores a dns interaction, collapsing the retried queries if
+// enabled except for the rebinding ones whose every resolution is recorded.
+func (h *DNSServer) storeInteraction(interaction *Interaction, store func(*Interaction)) {
+	if _, rebinding := parseRebindTarget(interaction.FullId); h.options.DNSDeduplicator != nil && !(h.options.DNSRebinder != nil && rebinding) {
+		h.options.DNSDeduplicator.Add(interaction, store)
+		return
 	}
+	store(interaction)
 }
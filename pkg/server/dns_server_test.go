@@ -0,0 +1,173 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSServerHandleSVCB(t *testing.T) {
+	server := NewDNSServer("udp", &Options{Domain: "example.com", IPAddress: "1.2.3.4", HttpsPort: 8443})
+
+	m := new(dns.Msg)
+	server.handleSVCB("test.example.com.", dns.TypeHTTPS, m)
+	_, err := m.Pack()
+	require.Nil(t, err, "could not pack https record")
+	require.Len(t, m.Answer, 1, "could not get https record")
+
+	https, ok := m.Answer[0].(*dns.HTTPS)
+	require.True(t, ok, "could not get https record")
+	require.Equal(t, uint16(1), https.Priority, "could not get service mode record")
+	require.Contains(t, https.String(), "alpn=\"h2,http/1.1\"", "could not get alpn")
+	require.Contains(t, https.String(), "port=\"8443\"", "could not get port")
+	require.Contains(t, https.String(), "ipv4hint=\"1.2.3.4\"", "could not get ipv4 hint")
+
+	m = new(dns.Msg)
+	server.handleSVCB("aws.example.com.", dns.TypeSVCB, m)
+	svcb, ok := m.Answer[0].(*dns.SVCB)
+	require.True(t, ok, "could not get svcb record")
+	require.Equal(t, []net.IP{net.ParseIP("169.254.169.254").To4()}, svcb.Value[2].(*dns.SVCBIPv4Hint).Hint, "could not get metadata ipv4 hint")
+
+	m = new(dns.Msg)
+	server.handleANY("test.example.com.", m)
+	types := make(map[uint16]struct{})
+	for _, answer := range m.Answer {
+		types[answer.Header().Rrtype] = struct{}{}
+	}
+	require.Contains(t, types, dns.TypeA, "could not get a record")
+	require.Contains(t, types, dns.TypeMX, "could not get mx record")
+	require.Contains(t, types, dns.TypeHTTPS, "could not get https record")
+
+	require.Equal(t, "HTTPS", toQType(dns.TypeHTTPS), "could not get query type")
+	require.Equal(t, "TYPE65000", toQType(65000), "could not get unknown query type")
+}
+
+func TestDNSServerHandleSOA(t *testing.T) {
+	soa := &SOA{Mbox: "hostmaster.example.com", Serial: 1634169600, Refresh: 7200, Retry: 900, Expire: 1209600, MinTTL: 300}
+	server := NewDNSServer("udp", &Options{Domain: "example.com", IPAddress: "1.2.3.4", NameServers: []string{"dns1", "ns.provider.net"}, SOA: soa})
+
+	m := new(dns.Msg)
+	server.handleSOA("example.com.", m)
+	record, ok := m.Answer[0].(*dns.SOA)
+	require.True(t, ok, "could not get soa record")
+	require.Equal(t, "dns1.example.com.", record.Ns, "could not get primary name server")
+	require.Equal(t, "hostmaster.example.com.", record.Mbox, "could not get mailbox")
+	require.Equal(t, uint32(1634169600), record.Serial, "could not get serial")
+	require.Equal(t, uint32(1209600), record.Expire, "could not get expire")
+
+	m = new(dns.Msg)
+	server.handleACNAMEANY("test.example.com.", m)
+	require.Len(t, m.Ns, 2, "could not get name servers")
+	require.Len(t, m.Extra, 1, "could not get glue records of the zone name servers")
+	require.Equal(t, "dns1.example.com.", m.Extra[0].Header().Name, "could not get glue record")
+}
+
+func TestDNSServerMultipleDomains(t *testing.T) {
+	server := NewDNSServer("udp", &Options{Domain: "example.com", Domains: []string{"example.com", "oast.example.net"}, IPAddress: "1.2.3.4"})
+
+	m := new(dns.Msg)
+	server.handleMX("test.oast.example.net.", m)
+	require.Equal(t, "mail.oast.example.net.", m.Answer[0].(*dns.MX).Mx, "could not get mx of the second domain")
+
+	m = new(dns.Msg)
+	server.handleNS("unknown.org.", m)
+	require.Equal(t, "ns1.example.com.", m.Answer[0].(*dns.NS).Ns, "could not get name server of the primary domain")
+
+	domain, ok := server.options.domainOf("C23B2LA0KL1KRJCRDJ10CNDMNIOYYYYYN.oast.example.net.")
+	require.True(t, ok, "could not match second domain")
+	require.Equal(t, "oast.example.net", domain, "could not get second domain")
+	_, ok = server.options.domainOf("notexample.com.")
+	require.False(t, ok, "matched domain suffix without a label boundary")
+}
+
+// testResponseWriter records the dns response written by the server
+type testResponseWriter struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (w *testResponseWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 53}
+}
+
+func (w *testResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func TestDNSServerTimeToLive(t *testing.T) {
+	timeToLive := uint32(0)
+	server := NewDNSServer("udp", &Options{Domain: "example.com", IPAddress: "1.2.3.4", TimeToLive: &timeToLive, SOA: &SOA{Mbox: "hostmaster.example.com", MinTTL: 5}})
+
+	w := &testResponseWriter{}
+	server.ServeDNS(w, new(dns.Msg).SetQuestion("test.example.com.", dns.TypeA))
+	require.Equal(t, uint32(0), w.msg.Answer[0].Header().Ttl, "could not set answer ttl")
+
+	server.ServeDNS(w, new(dns.Msg).SetQuestion("test.example.com.", dns.TypePTR))
+	require.Empty(t, w.msg.Answer, "could not get empty answer")
+	require.Len(t, w.msg.Ns, 1, "could not get soa of empty answer")
+	require.Equal(t, uint32(5), w.msg.Ns[0].(*dns.SOA).Minttl, "could not get negative ttl")
+
+	require.Equal(t, uint32(DefaultTimeToLive), NewDNSServer("udp", &Options{Domain: "example.com"}).timeToLive, "could not get default ttl")
+}
+
+func TestDNSServerEdns0(t *testing.T) {
+	records := &DNSRecords{records: make(map[string][]dns.RR)}
+	for i := 0; i < 10; i++ {
+		rr, err := dns.NewRR(`big.example.com. 60 IN TXT "` + strings.Repeat("a", 200) + `"`)
+		require.Nil(t, err, "could not create txt record")
+		records.records["big.example.com."] = append(records.records["big.example.com."], rr)
+	}
+	options := &Options{Domain: "example.com", IPAddress: "1.2.3.4", DNSRecords: records}
+
+	w := &testResponseWriter{}
+	udp := NewDNSServer("udp", options)
+	udp.ServeDNS(w, new(dns.Msg).SetQuestion("big.example.com.", dns.TypeTXT))
+	require.True(t, w.msg.Truncated, "could not truncate response larger than 512 bytes")
+	require.Nil(t, w.msg.IsEdns0(), "answered edns0 to a query without it")
+
+	in := new(dns.Msg).SetQuestion("big.example.com.", dns.TypeTXT)
+	in.SetEdns0(4096, false)
+	udp.ServeDNS(w, in)
+	require.True(t, w.msg.Truncated, "could not truncate response larger than the server payload size")
+	require.Equal(t, uint16(maxUDPSize), w.msg.IsEdns0().UDPSize(), "could not advertise payload size")
+	data, err := w.msg.Pack()
+	require.Nil(t, err, "could not pack truncated response")
+	require.LessOrEqual(t, len(data), maxUDPSize, "could not fit response in payload size")
+
+	NewDNSServer("tcp", options).ServeDNS(w, new(dns.Msg).SetQuestion("big.example.com.", dns.TypeTXT))
+	require.False(t, w.msg.Truncated, "truncated tcp response")
+	require.Len(t, w.msg.Answer, 10, "could not get every record over tcp")
+
+	in = new(dns.Msg).SetQuestion("test.example.com.", dns.TypeA)
+	in.SetEdns0(1232, false)
+	in.IsEdns0().SetVersion(1)
+	udp.ServeDNS(w, in)
+	require.Equal(t, dns.RcodeBadVers, w.msg.Rcode, "could not reject unsupported edns version")
+	_, err = w.msg.Pack()
+	require.Nil(t, err, "could not pack badvers response")
+}
+
+func TestDNSServerZoneTransfer(t *testing.T) {
+	store := storage.New(time.Hour)
+	require.Nil(t, store.SetID("example.com"), "could not set root tld id")
+	server := NewDNSServer("tcp", &Options{Domain: "example.com", IPAddress: "1.2.3.4", Storage: store, RootTLD: true})
+
+	w := &testResponseWriter{}
+	server.ServeDNS(w, new(dns.Msg).SetQuestion("example.com.", dns.TypeAXFR))
+	require.Equal(t, dns.RcodeRefused, w.msg.Rcode, "could not refuse zone transfer")
+	require.Empty(t, w.msg.Answer, "answered zone transfer")
+	server.ServeDNS(w, new(dns.Msg).SetIxfr("example.com.", 1, "ns1.example.com.", "letsencrypt.org."))
+	require.Equal(t, dns.RcodeRefused, w.msg.Rcode, "could not refuse incremental zone transfer")
+
+	data, err := store.GetInteractionsWithId("example.com")
+	require.Nil(t, err, "could not get interactions")
+	require.Len(t, data, 2, "could not record zone transfer attempts")
+	require.Contains(t, data[0], `"protocol":"axfr"`, "could not record zone transfer as axfr interaction")
+	require.Contains(t, data[1], `"q-type":"IXFR"`, "could not record incremental zone transfer type")
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+const (
+	// dnssecKeyTTL is the ttl of the DNSKEY records
+	dnssecKeyTTL = 3600
+	// dnssecSignatureValidity is the time the signatures are valid for after their inception
+	dnssecSignatureValidity = 7 * 24 * time.Hour
+	// dnssecClockSkew is the time the inception of the signatures is backdated by
+	dnssecClockSkew = time.Hour
+	// dnssecKSKFlags and dnssecZSKFlags are the flags of the key signing and zone signing keys
+	dnssecKSKFlags = 257
+	dnssecZSKFlags = 256
+)
+
+// nsecTypes are the types of the NSEC records denying the existence of the
+// queried type, the server answering the others for every name.
+var nsecTypes = []uint16{dns.TypeA, dns.TypeNS, dns.TypeSOA, dns.TypeMX, dns.TypeTXT, dns.TypeAAAA, dns.TypeSRV, dns.TypeSVCB, dns.TypeHTTPS}
+
+// DNSSEC signs the dns answers of the zones on the fly for the resolvers
+// requesting dnssec records (DO bit), with a key signing key and a zone
+// signing key per domain. Since the zones are dynamic, the empty answers
+// are denied with minimal NSEC records covering only the queried name.
+type DNSSEC struct {
+	zones map[string]*dnssecZone
+}
+
+// dnssecZone are the keys of a domain
+type dnssecZone struct {
+	name   string
+	ksk    *dns.DNSKEY
+	kskKey crypto.Signer
+	zsk    *dns.DNSKEY
+	zskKey crypto.Signer
+}
+
+// LoadDNSSEC loads the keys of the domains from a directory, generating
+// and writing the missing ones (ECDSA P-256).
+func LoadDNSSEC(directory string, domains []string) (*DNSSEC, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, errors.Wrap(err, "could not create dnssec keys directory")
+	}
+	d := &DNSSEC{zones: make(map[string]*dnssecZone)}
+	for _, domain := range domains {
+		zone := &dnssecZone{name: strings.ToLower(dns.Fqdn(domain))}
+		var err error
+		if zone.ksk, zone.kskKey, err = loadDNSSECKey(directory, zone.name, "ksk", dnssecKSKFlags); err != nil {
+			return nil, err
+		}
+		if zone.zsk, zone.zskKey, err = loadDNSSECKey(directory, zone.name, "zsk", dnssecZSKFlags); err != nil {
+			return nil, err
+		}
+		d.zones[zone.name] = zone
+	}
+	return d, nil
+}
+
+// loadDNSSECKey loads the <domain>.<kind>.key and .private files of a key,
+// generating them if missing
+func loadDNSSECKey(directory, name, kind string, flags uint16) (*dns.DNSKEY, crypto.Signer, error) {
+	path := filepath.Join(directory, strings.TrimSuffix(name, ".")+"."+kind)
+	if data, err := ioutil.ReadFile(path + ".key"); err == nil {
+		rr, err := dns.NewRR(string(data))
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "could not parse %s.key", path)
+		}
+		key, ok := rr.(*dns.DNSKEY)
+		if !ok {
+			return nil, nil, errors.Errorf("%s.key is not a dnskey record", path)
+		}
+		file, err := os.Open(path + ".private")
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "could not open private key")
+		}
+		defer file.Close()
+		privateKey, err := key.ReadPrivateKey(file, path+".private")
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "could not parse %s.private", path)
+		}
+		signer, ok := privateKey.(crypto.Signer)
+		if !ok {
+			return nil, nil, errors.Errorf("unsupported private key %s.private", path)
+		}
+		return key, signer, nil
+	} else if !os.IsNotExist(err) {
+		return nil, nil, errors.Wrap(err, "could not read dnssec key")
+	}
+
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: dnssecKeyTTL},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	privateKey, err := key.Generate(256)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not generate dnssec key")
+	}
+	if err := ioutil.WriteFile(path+".private", []byte(key.PrivateKeyString(privateKey)), 0600); err != nil {
+		return nil, nil, errors.Wrap(err, "could not write private key")
+	}
+	if err := ioutil.WriteFile(path+".key", []byte(key.String()+"\n"), 0644); err != nil {
+		return nil, nil, errors.Wrap(err, "could not write public key")
+	}
+	return key, privateKey.(crypto.Signer), nil
+}
+
+// DSRecords returns the DS records of the key signing keys to publish at the registrar.
+func (d *DNSSEC) DSRecords() []string {
+	records := make([]string, 0, len(d.zones))
+	for _, zone := range d.zones {
+		records = append(records, zone.ksk.ToDS(dns.SHA256).String())
+	}
+	return records
+}
+
+// zoneOf returns the keys of the zone of a name, nil if not in a signed zone
+func (d *DNSSEC) zoneOf(name string) *dnssecZone {
+	name = strings.ToLower(dns.Fqdn(name))
+	var matched *dnssecZone
+	for zoneName, zone := range d.zones {
+		if (name == zoneName || strings.HasSuffix(name, "."+zoneName)) && (matched == nil || len(zoneName) > len(matched.name)) {
+			matched = zone
+		}
+	}
+	return matched
+}
+
+// handleDNSKEY answers the DNSKEY records of the zone apex
+func (d *DNSSEC) handleDNSKEY(name string, m *dns.Msg) {
+	if d == nil {
+		return
+	}
+	zone := d.zoneOf(name)
+	if zone == nil || !strings.EqualFold(dns.Fqdn(name), zone.name) {
+		return
+	}
+	for _, key := range []*dns.DNSKEY{zone.ksk, zone.zsk} {
+		answer := dns.Copy(key)
+		answer.Header().Name = name
+		m.Answer = append(m.Answer, answer)
+	}
+}
+
+// sign adds the signatures of the records of the signed zones to a response,
+// denying the existence of the queried type with a NSEC record if empty.
+func (d *DNSSEC) sign(m *dns.Msg, qname string, qtype uint16) {
+	if len(m.Answer) == 0 && m.Rcode == dns.RcodeSuccess {
+		if zone := d.zoneOf(qname); zone != nil {
+			types := []uint16{dns.TypeRRSIG, dns.TypeNSEC}
+			for _, rrtype := range nsecTypes {
+				if rrtype != qtype {
+					types = append(types, rrtype)
+				}
+			}
+			// the types of the bitmap must be in ascending order
+			sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+			m.Ns = append(m.Ns, &dns.NSEC{
+				Hdr:        dns.RR_Header{Name: qname, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: minTTL(m.Ns)},
+				NextDomain: "\\000." + qname,
+				TypeBitMap: types,
+			})
+		}
+	}
+	now := time.Now()
+	m.Answer = d.signSection(m.Answer, now)
+	m.Ns = d.signSection(m.Ns, now)
+	m.Extra = d.signSection(m.Extra, now)
+}
+
+// signDelegation proves the absence of a DS record of a delegated sub-zone
+// in a referral with a signed NSEC record, the delegation being insecure
+// and its NS and glue records not signed by the parent zone.
+func (d *DNSSEC) signDelegation(m *dns.Msg, zone string) {
+	if d.zoneOf(zone) == nil {
+		return
+	}
+	nsec := &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: zone, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: dnssecKeyTTL},
+		NextDomain: "\\000." + zone,
+		TypeBitMap: []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC},
+	}
+	m.Ns = append(m.Ns, d.signSection([]dns.RR{nsec}, time.Now())...)
+}
+
+// minTTL returns the negative ttl of the soa record of the authority section
+func minTTL(records []dns.RR) uint32 {
+	for _, record := range records {
+		if soa, ok := record.(*dns.SOA); ok {
+			return soa.Minttl
+		}
+	}
+	return 0
+}
+
+// signSection adds the signatures after the rrsets of a section
+func (d *DNSSEC) signSection(records []dns.RR, now time.Time) []dns.RR {
+	if len(records) == 0 {
+		return records
+	}
+	type rrsetKey struct {
+		name   string
+		rrtype uint16
+	}
+	var keys []rrsetKey
+	rrsets := make(map[rrsetKey][]dns.RR)
+	signed := make([]dns.RR, 0, 2*len(records))
+	for _, record := range records {
+		header := record.Header()
+		if header.Rrtype == dns.TypeOPT || header.Rrtype == dns.TypeRRSIG {
+			signed = append(signed, record)
+			continue
+		}
+		key := rrsetKey{name: strings.ToLower(header.Name), rrtype: header.Rrtype}
+		if _, ok := rrsets[key]; !ok {
+			keys = append(keys, key)
+		}
+		rrsets[key] = append(rrsets[key], record)
+	}
+	for _, key := range keys {
+		rrset := rrsets[key]
+		signed = append(signed, rrset...)
+		zone := d.zoneOf(key.name)
+		if zone == nil {
+			continue
+		}
+		dnskey, signer := zone.zsk, zone.zskKey
+		if key.rrtype == dns.TypeDNSKEY {
+			dnskey, signer = zone.ksk, zone.kskKey
+		}
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: rrset[0].Header().Ttl},
+			KeyTag:     dnskey.KeyTag(),
+			SignerName: zone.name,
+			Algorithm:  dnskey.Algorithm,
+			Inception:  uint32(now.Add(-dnssecClockSkew).Unix()),
+			Expiration: uint32(now.Add(dnssecSignatureValidity).Unix()),
+		}
+		if err := sig.Sign(signer, rrset); err != nil {
+			continue
+		}
+		signed = append(signed, sig)
+	}
+	return signed
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSSEC(t *testing.T) {
+	directory := t.TempDir()
+	dnssec, err := LoadDNSSEC(directory, []string{"example.com"})
+	require.Nil(t, err, "could not generate dnssec keys")
+	require.Len(t, dnssec.DSRecords(), 1, "could not get ds record")
+	zone := dnssec.zoneOf("Test.Example.com.")
+	require.NotNil(t, zone, "could not get zone of name")
+	require.Nil(t, dnssec.zoneOf("example.net."), "got zone of another domain")
+
+	reloaded, err := LoadDNSSEC(directory, []string{"example.com"})
+	require.Nil(t, err, "could not load dnssec keys")
+	require.Equal(t, zone.ksk.KeyTag(), reloaded.zoneOf("example.com.").ksk.KeyTag(), "could not reload key signing key")
+	require.Equal(t, dnssec.DSRecords(), reloaded.DSRecords(), "could not reload ds record")
+
+	server := NewDNSServer("udp", &Options{Domain: "example.com", IPAddress: "1.2.3.4", DNSSEC: dnssec})
+	in := new(dns.Msg).SetQuestion("Test.example.com.", dns.TypeA)
+	in.SetEdns0(1232, true)
+	w := &testResponseWriter{}
+	server.ServeDNS(w, in)
+	require.Len(t, w.msg.Answer, 2, "could not sign answer")
+	sig, ok := w.msg.Answer[1].(*dns.RRSIG)
+	require.True(t, ok, "could not get signature")
+	require.Nil(t, sig.Verify(zone.zsk, w.msg.Answer[:1]), "could not verify signature")
+	require.True(t, w.msg.IsEdns0().Do(), "could not set do bit")
+
+	in = new(dns.Msg).SetQuestion("example.com.", dns.TypeDNSKEY)
+	in.SetEdns0(1232, true)
+	server.ServeDNS(w, in)
+	require.Len(t, w.msg.Answer, 3, "could not get signed dnskey records")
+	require.Nil(t, w.msg.Answer[2].(*dns.RRSIG).Verify(zone.ksk, w.msg.Answer[:2]), "could not verify dnskey signature")
+
+	in = new(dns.Msg).SetQuestion("test.example.com.", dns.TypePTR)
+	in.SetEdns0(1232, true)
+	server.ServeDNS(w, in)
+	var nsec *dns.NSEC
+	var signatures int
+	for _, record := range w.msg.Ns {
+		switch record := record.(type) {
+		case *dns.NSEC:
+			nsec = record
+		case *dns.RRSIG:
+			signatures++
+		}
+	}
+	require.NotNil(t, nsec, "could not deny empty answer")
+	require.Equal(t, 2, signatures, "could not sign soa and nsec records")
+	require.NotContains(t, nsec.TypeBitMap, dns.TypePTR, "could not deny queried type")
+	_, err = w.msg.Pack()
+	require.Nil(t, err, "could not pack denial")
+
+	server.ServeDNS(w, new(dns.Msg).SetQuestion("test.example.com.", dns.TypeA))
+	require.Len(t, w.msg.Answer, 1, "signed answer without do bit")
+}
@@ -1,7 +1,6 @@
 package server
 
 import (
-	"bytes"
 	"crypto/tls"
 	"fmt"
 	"io"
@@ -9,7 +8,6 @@ import (
 	"strings"
 	"time"
 
-	jsoniter "github.com/json-iterator/go"
 	"github.com/projectdiscovery/gologger"
 	ftpserver "goftp.io/server/v2"
 	"goftp.io/server/v2/driver/file"
@@ -64,8 +62,8 @@ func NewFTPServer(options *Options) (*FTPServer, error) {
 // ListenAndServe listens on smtp and/or smtps ports for the server.
 func (h *FTPServer) ListenAndServe(tlsConfig *tls.Config, ftpAlive chan bool) {
 	ftpAlive <- true
-	if err := h.ftpServer.ListenAndServe(); err != nil {
-		gologger.Error().Msgf("Could not serve ftp on port 21: %s\n", err)
+	if err := h.ftpServer.ListenAndServe(); err != nil && err != ftpserver.ErrServerClosed {
+		gologger.Error().Msgf("Could not serve ftp on port %d: %s\n", h.options.FtpPort, err)
 		ftpAlive <- false
 	}
 }
@@ -84,15 +82,7 @@ func (h *FTPServer) recordInteraction(remoteAddress, data string) {
 		RawRequest:    data,
 		Timestamp:     time.Now(),
 	}
-	buffer := &bytes.Buffer{}
-	if err := jsoniter.NewEncoder(buffer).Encode(interaction); err != nil {
-		gologger.Warning().Msgf("Could not encode ftp interaction: %s\n", err)
-	} else {
-		gologger.Debug().Msgf("FTP Interaction: \n%s\n", buffer.String())
-		if err := h.options.Storage.AddInteractionWithId(h.options.Token, buffer.Bytes()); err != nil {
-			gologger.Warning().Msgf("Could not store ftp interaction: %s\n", err)
-		}
-	}
+	h.options.storeInteractionWithId(h.options.GetToken(), interaction)
 }
 
 func (h *FTPServer) Print(sessionID string, message interface{})              {}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// healthDialTimeout is the timeout for checking a tcp listener is bound
+const healthDialTimeout = time.Second
+
+// Health tracks the status of the enabled listeners for the readiness checks.
+type Health struct {
+	sync.Mutex
+	services  map[string]*serviceHealth
+	tlsConfig *tls.Config
+	domain    string
+}
+
+type serviceHealth struct {
+	network string
+	address string
+	alive   bool
+}
+
+// NewHealth returns a new health tracker.
+func NewHealth() *Health {
+	return &Health{services: make(map[string]*serviceHealth)}
+}
+
+// Expect registers an enabled service listening on the address, the
+// service isn't ready until it reports that it's alive.
+func (h *Health) Expect(service, network, address string) {
+	if h == nil {
+		return
+	}
+	h.Lock()
+	h.services[healthKey(service, network)] = &serviceHealth{network: strings.ToLower(network), address: address}
+	h.Unlock()
+}
+
+// Forget removes a service that has been disabled.
+func (h *Health) Forget(service, network string) {
+	if h == nil {
+		return
+	}
+	h.Lock()
+	delete(h.services, healthKey(service, network))
+	h.Unlock()
+}
+
+// SetStatus updates the status reported by a service listening on the address.
+func (h *Health) SetStatus(service, network, address string, alive bool) {
+	if h == nil {
+		return
+	}
+	h.Lock()
+	h.services[healthKey(service, network)] = &serviceHealth{network: strings.ToLower(network), address: address, alive: alive}
+	h.Unlock()
+}
+
+func healthKey(service, network string) string {
+	return strings.ToLower(service + "/" + network)
+}
+
+// SetTLSConfig sets the acme tls configuration whose certificate
+// for the domain is checked for validity.
+func (h *Health) SetTLSConfig(tlsConfig *tls.Config, domain string) {
+	if h == nil {
+		return
+	}
+	h.Lock()
+	h.tlsConfig = tlsConfig
+	h.domain = domain
+	h.Unlock()
+}
+
+// Ready returns true if all the services are alive and bound and the acme
+// certificate is valid, along with the result of each check.
+func (h *Health) Ready() (bool, map[string]string) {
+	h.Lock()
+	services := make(map[string]serviceHealth, len(h.services))
+	for service, status := range h.services {
+		services[service] = *status
+	}
+	tlsConfig, domain := h.tlsConfig, h.domain
+	h.Unlock()
+
+	ready := true
+	checks := make(map[string]string, len(services)+1)
+	for service, status := range services {
+		err := checkService(status)
+		if err != nil {
+			ready = false
+			checks[service] = err.Error()
+		} else {
+			checks[service] = "ok"
+		}
+	}
+	if tlsConfig != nil {
+		if err := checkCertificate(tlsConfig, domain); err != nil {
+			ready = false
+			checks["acme"] = err.Error()
+		} else {
+			checks["acme"] = "ok"
+		}
+	}
+	return ready, checks
+}
+
+// checkService checks a service is alive, tcp services are also
+// checked to be accepting connections.
+func checkService(status serviceHealth) error {
+	if !status.alive {
+		return fmt.Errorf("not listening")
+	}
+	if status.network != "tcp" {
+		return nil
+	}
+	// listeners bound to all the interfaces are checked on the loopback
+	address := status.address
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+			address = net.JoinHostPort("127.0.0.1", port)
+		}
+	}
+	conn, err := net.DialTimeout("tcp", address, healthDialTimeout)
+	if err != nil {
+		return fmt.Errorf("not accepting connections: %s", err)
+	}
+	_ = conn.Close()
+	return nil
+}
+
+// checkCertificate checks the certificate served for the domain is valid
+func checkCertificate(tlsConfig *tls.Config, domain string) error {
+	if tlsConfig.GetCertificate == nil {
+		return fmt.Errorf("no certificate configured")
+	}
+	certificate, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: domain})
+	if err != nil {
+		return fmt.Errorf("could not get certificate: %s", err)
+	}
+	if certificate == nil || len(certificate.Certificate) == 0 {
+		return fmt.Errorf("no certificate for %s", domain)
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("could not parse certificate: %s", err)
+	}
+	now := time.Now()
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return fmt.Errorf("certificate for %s expired on %s", domain, leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
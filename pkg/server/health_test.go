@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthReady(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "could not listen")
+	defer listener.Close()
+
+	health := NewHealth()
+	health.Expect("HTTP", "TCP", listener.Addr().String())
+	health.Expect("DNS", "UDP", "127.0.0.1:53")
+
+	ready, checks := health.Ready()
+	require.False(t, ready, "services not reported alive are ready")
+	require.Equal(t, "not listening", checks["dns/udp"], "could not get dns check")
+
+	health.SetStatus("HTTP", "TCP", listener.Addr().String(), true)
+	health.SetStatus("DNS", "UDP", "127.0.0.1:53", true)
+	ready, checks = health.Ready()
+	require.True(t, ready, "bound services are not ready")
+	require.Equal(t, "ok", checks["http/tcp"], "could not get http check")
+
+	address := listener.Addr().String()
+	listener.Close()
+	health.SetStatus("HTTP", "TCP", address, true)
+	ready, _ = health.Ready()
+	require.False(t, ready, "unbound tcp service is ready")
+
+	health.Forget("HTTP", "TCP")
+	ready, _ = health.Ready()
+	require.True(t, ready, "forgotten service is checked")
+}
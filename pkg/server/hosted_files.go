@@ -0,0 +1,274 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+)
+
+// maxHostedFiles is the maximum number of files hosted for a session
+const maxHostedFiles = 16
+
+// hostedFileName matches the names of the hosted files
+var hostedFileName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// HostedFiles are the small payload files (dtds, svgs, js callbacks) uploaded
+// by the clients for their sessions, served on the interactsh domain under
+// /s/<correlation-id>/<name> until they expire.
+type HostedFiles struct {
+	maxSize int
+	maxTTL  time.Duration
+	alive   func(correlationID string) bool
+
+	sync.Mutex
+	sessions map[string]map[string]*hostedFile
+}
+
+// hostedFile is a file hosted for a session
+type hostedFile struct {
+	contentType string
+	data        []byte
+	expires     time.Time
+}
+
+// NewHostedFiles returns new hosted files of up to maxSize bytes kept for up
+// to maxTTL, alive returning false for the sessions which are no longer registered.
+func NewHostedFiles(maxSize int, maxTTL time.Duration, alive func(correlationID string) bool) *HostedFiles {
+	return &HostedFiles{maxSize: maxSize, maxTTL: maxTTL, alive: alive, sessions: make(map[string]map[string]*hostedFile)}
+}
+
+// Put hosts a file for a session, replacing the previous file of the same
+// name, for ttl or the maximum ttl if zero or longer.
+func (f *HostedFiles) Put(correlationID, name, contentType string, data []byte, ttl time.Duration) error {
+	if !hostedFileName.MatchString(name) {
+		return fmt.Errorf("invalid file name %s", name)
+	}
+	if len(data) > f.maxSize {
+		return fmt.Errorf("hosted files are limited to %d bytes", f.maxSize)
+	}
+	if ttl <= 0 || ttl > f.maxTTL {
+		ttl = f.maxTTL
+	}
+	f.Lock()
+	defer f.Unlock()
+
+	files, ok := f.sessions[correlationID]
+	if !ok {
+		if len(f.sessions) >= maxAccountedSessions {
+			f.prune()
+		}
+		files = make(map[string]*hostedFile)
+		f.sessions[correlationID] = files
+	}
+	now := time.Now()
+	for fileName, file := range files {
+		if now.After(file.expires) {
+			delete(files, fileName)
+		}
+	}
+	if _, ok := files[name]; !ok && len(files) >= maxHostedFiles {
+		return fmt.Errorf("hosted files are limited to %d per session", maxHostedFiles)
+	}
+	files[name] = &hostedFile{contentType: contentType, data: data, expires: now.Add(ttl)}
+	return nil
+}
+
+// HostedFile is a file hosted for a session, as exported with the session
+type HostedFile struct {
+	Name        string    `json:"name"`
+	ContentType string    `json:"content-type,omitempty"`
+	Data        []byte    `json:"data"`
+	ExpiresAt   time.Time `json:"expires-at"`
+}
+
+// Export returns the files of a session which haven't expired.
+func (f *HostedFiles) Export(correlationID string) []*HostedFile {
+	if f == nil {
+		return nil
+	}
+	f.Lock()
+	defer f.Unlock()
+
+	var exported []*HostedFile
+	now := time.Now()
+	for name, file := range f.sessions[correlationID] {
+		if now.Before(file.expires) {
+			exported = append(exported, &HostedFile{Name: name, ContentType: file.contentType, Data: file.data, ExpiresAt: file.expires})
+		}
+	}
+	return exported
+}
+
+// Validate returns an error if the files exported by another server can't be
+// hosted, the files being ignored if the hosting is disabled.
+func (f *HostedFiles) Validate(files []*HostedFile) error {
+	if f == nil {
+		return nil
+	}
+	if len(files) > maxHostedFiles {
+		return fmt.Errorf("hosted files are limited to %d per session", maxHostedFiles)
+	}
+	for _, file := range files {
+		if file == nil || !hostedFileName.MatchString(file.Name) {
+			return errors.New("invalid file name")
+		}
+		if len(file.Data) > f.maxSize {
+			return fmt.Errorf("hosted files are limited to %d bytes", f.maxSize)
+		}
+	}
+	return nil
+}
+
+// Import hosts the files exported by another server for a session until
+// they expire, the expired ones being skipped.
+func (f *HostedFiles) Import(correlationID string, files []*HostedFile) error {
+	if f == nil {
+		return nil
+	}
+	for _, file := range files {
+		ttl := time.Until(file.ExpiresAt)
+		if ttl <= 0 {
+			continue
+		}
+		if err := f.Put(correlationID, file.Name, file.ContentType, file.Data, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns a file of a registered session, nil if it doesn't exist or expired.
+func (f *HostedFiles) Get(correlationID, name string) *hostedFile {
+	f.Lock()
+	defer f.Unlock()
+
+	file, ok := f.sessions[correlationID][name]
+	if !ok || time.Now().After(file.expires) || !f.alive(correlationID) {
+		return nil
+	}
+	return file
+}
+
+// Delete removes a file of a session.
+func (f *HostedFiles) Delete(correlationID, name string) {
+	f.Lock()
+	defer f.Unlock()
+
+	delete(f.sessions[correlationID], name)
+}
+
+// Remove removes the files of a deregistered session.
+func (f *HostedFiles) Remove(correlationID string) {
+	if f == nil {
+		return
+	}
+	f.Lock()
+	delete(f.sessions, correlationID)
+	f.Unlock()
+}
+
+// prune removes the sessions which are no longer registered
+func (f *HostedFiles) prune() {
+	for correlationID := range f.sessions {
+		if !f.alive(correlationID) {
+			delete(f.sessions, correlationID)
+		}
+	}
+}
+
+// hostedFilesHandler serves the hosted files of the /s/<correlation-id>/<name>
+// requests, recording the fetches for the session, and uploads (PUT) or
+// removes (DELETE) them for the clients authenticated with their secret key.
+func (h *HTTPServer) hostedFilesHandler(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/s/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.NotFound(w, req)
+		return
+	}
+	correlationID, name := parts[0], parts[1]
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		file := h.options.HostedFiles.Get(correlationID, name)
+		if file == nil {
+			http.NotFound(w, req)
+			return
+		}
+		rawRequest, _ := httputil.DumpRequest(req, false)
+		if file.contentType != "" {
+			w.Header().Set("Content-Type", file.contentType)
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Length", strconv.Itoa(len(file.data)))
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(file.data)
+		}
+
+		host, _, _ := net.SplitHostPort(req.RemoteAddr)
+		var clientHello *ClientHello
+		if req.TLS != nil {
+			clientHello = h.clientHellos.Get(req.RemoteAddr)
+		}
+		interaction := &Interaction{
+			Protocol:       "http",
+			UniqueID:       correlationID,
+			FullId:         correlationID,
+			HostedFile:     name,
+			RawRequest:     string(rawRequest),
+			RemoteAddress:  host,
+			Timestamp:      time.Now(),
+			TLSClientHello: clientHello,
+		}
+		h.options.storeInteraction(correlationID, interaction)
+	case http.MethodPut, http.MethodDelete:
+		if !h.options.IPFilter.Allowed(req.RemoteAddr) {
+			jsonError(w, "client ip not allowed", http.StatusForbidden)
+			return
+		}
+		if !h.verifiedClient(req) {
+			jsonError(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+		req, ok := h.authorize(w, req, ScopeRegister)
+		if !ok {
+			return
+		}
+		if _, ok := h.checkSession(w, req, correlationID); !ok {
+			return
+		}
+		if _, err := h.options.Storage.GetAESKey(correlationID, req.Header.Get("X-Secret-Key")); err != nil {
+			gologger.Warning().Msgf("Could not host file for %s: %s\n", correlationID, err)
+			jsonError(w, fmt.Sprintf("could not host file: %s", err), http.StatusBadRequest)
+			return
+		}
+		if req.Method == http.MethodDelete {
+			h.options.HostedFiles.Delete(correlationID, name)
+			jsonMsg(w, "file removed", http.StatusOK)
+			return
+		}
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, int64(h.options.HostedFiles.maxSize)+1))
+		if err != nil {
+			jsonError(w, fmt.Sprintf("could not read file: %s", err), http.StatusBadRequest)
+			return
+		}
+		ttl, _ := strconv.Atoi(req.Header.Get("X-TTL"))
+		if err := h.options.HostedFiles.Put(correlationID, name, req.Header.Get("Content-Type"), data, time.Duration(ttl)*time.Second); err != nil {
+			jsonError(w, fmt.Sprintf("could not host file: %s", err), http.StatusBadRequest)
+			return
+		}
+		jsonMsg(w, "file hosted", http.StatusOK)
+		gologger.Debug().Msgf("Hosted file %s for correlationID %s\n", name, correlationID)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
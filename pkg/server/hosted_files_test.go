@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostedFiles(t *testing.T) {
+	store, _ := newTestSession(t)
+	options := &Options{Domain: "example.com", Storage: store, HostedFiles: NewHostedFiles(32, time.Hour, store.HasID)}
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+	do := func(method, path, secret, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "http://example.com"+path, strings.NewReader(body))
+		req.Header.Set("X-Secret-Key", secret)
+		req.Header.Set("Content-Type", "image/svg+xml")
+		server.nontlsserver.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/s/c23b2la0kl1krjcrdj10/x.svg", "wrong", "<svg/>").Code, "hosted file with wrong secret")
+	require.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/s/c23b2la0kl1krjcrdj10/x.svg", "secret", strings.Repeat("a", 33)).Code, "hosted file larger than the limit")
+	require.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/s/c23b2la0kl1krjcrdj10/x%20y", "secret", "<svg/>").Code, "hosted file with invalid name")
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/s/c23b2la0kl1krjcrdj10/x.svg", "secret", "<svg/>").Code, "could not host file")
+
+	rec := do(http.MethodGet, "/s/c23b2la0kl1krjcrdj10/x.svg", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, "could not get hosted file")
+	require.Equal(t, "<svg/>", rec.Body.String(), "invalid hosted file")
+	require.Equal(t, "image/svg+xml", rec.Header().Get("Content-Type"), "invalid hosted file content type")
+	require.Equal(t, http.StatusNotFound, do(http.MethodGet, "/s/c23b2la0kl1krjcrdj10/other.svg", "", "").Code, "got missing hosted file")
+	data, _, err := store.GetInteractions("c23b2la0kl1krjcrdj10", "secret")
+	require.Nil(t, err, "could not get interactions")
+	require.Len(t, data, 1, "could not record hosted file fetch")
+
+	for i := 1; i < maxHostedFiles; i++ {
+		require.Equal(t, http.StatusOK, do(http.MethodPut, fmt.Sprintf("/s/c23b2la0kl1krjcrdj10/%d.js", i), "secret", "x").Code, "could not host file")
+	}
+	require.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/s/c23b2la0kl1krjcrdj10/last.js", "secret", "x").Code, "hosted more files than the limit")
+
+	require.Equal(t, http.StatusOK, do(http.MethodDelete, "/s/c23b2la0kl1krjcrdj10/x.svg", "secret", "").Code, "could not remove hosted file")
+	require.Equal(t, http.StatusNotFound, do(http.MethodGet, "/s/c23b2la0kl1krjcrdj10/x.svg", "", "").Code, "could not remove hosted file")
+
+	options.HostedFiles.sessions["c23b2la0kl1krjcrdj10"]["1.js"].expires = time.Now().Add(-time.Second)
+	require.Equal(t, http.StatusNotFound, do(http.MethodGet, "/s/c23b2la0kl1krjcrdj10/1.js", "", "").Code, "got expired hosted file")
+}
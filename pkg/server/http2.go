@@ -0,0 +1,448 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+const (
+	// maxHTTP2Streams is the number of streams recorded per connection until they reach the handler
+	maxHTTP2Streams = 100
+	// maxHTTP2StreamFrames is the number of frames recorded per stream
+	maxHTTP2StreamFrames = 32
+	// maxHTTP2StreamHeaders is the number of header fields recorded per stream
+	maxHTTP2StreamHeaders = 128
+	// maxHTTP2StreamData is the number of data bytes recorded per stream
+	maxHTTP2StreamData = 4096
+	// maxHTTP2FrameSize is the maximum size of the frames read by the server
+	maxHTTP2FrameSize = 1 << 20
+	// http2FrameHeaderLength is the length of the header of every frame
+	http2FrameHeaderLength = 9
+)
+
+// HTTP2Stream is the stream level metadata of an HTTP/2 request.
+type HTTP2Stream struct {
+	// ID is the identifier of the stream.
+	ID uint32 `json:"id"`
+	// Headers are the header fields as sent by the client, including the pseudo-headers.
+	Headers []string `json:"headers,omitempty"`
+	// Frames is the summary of the frames sent by the client on the stream.
+	Frames []string `json:"frames,omitempty"`
+	// Reset is the error code of the server resetting the stream, if any.
+	Reset string `json:"reset,omitempty"`
+}
+
+// http2StreamContextKey is the request context key of the recorded HTTP/2 stream
+type http2StreamContextKey struct{}
+
+// configureHTTP2 enables HTTP/2 on the tls server, the connections being
+// recorded to add the stream level metadata to the interactions.
+func (h *HTTPServer) configureHTTP2() error {
+	server := &http2.Server{}
+	if err := http2.ConfigureServer(&h.tlsserver, server); err != nil {
+		return err
+	}
+	h.tlsserver.TLSNextProto[http2.NextProtoTLS] = func(srv *http.Server, conn *tls.Conn, handler http.Handler) {
+		recorder := newHTTP2Conn(conn, h.storeRejectedStream)
+		server.ServeConn(recorder, &http2.ServeConnOpts{
+			BaseConfig: srv,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// the streams not claimed once answered were rejected by the server
+				stream := recorder.claim(r.Method, r.RequestURI)
+				handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), http2StreamContextKey{}, stream)))
+			}),
+		})
+	}
+	return nil
+}
+
+// http2Stream returns the stream of an HTTP/2 request, if any
+func http2Stream(r *http.Request) *HTTP2Stream {
+	stream, _ := r.Context().Value(http2StreamContextKey{}).(*HTTP2Stream)
+	return stream
+}
+
+// storeRejectedStream stores the interaction of a stream rejected by the
+// server before reaching the handler for the session of its payload, if any.
+func (h *HTTPServer) storeRejectedStream(remoteAddr string, stream *http2StreamRecord) {
+	uniqueID, fullID, correlationID := h.options.findUniqueID(stream.authority)
+	if uniqueID == "" {
+		uniqueID, fullID, correlationID = h.options.findInteractionID(stream.path)
+	}
+	if uniqueID == "" {
+		return
+	}
+	host, _, _ := net.SplitHostPort(remoteAddr)
+	interaction := &Interaction{
+		Protocol:       "http",
+		UniqueID:       uniqueID,
+		FullId:         fullID,
+		RawRequest:     stream.rawRequest(),
+		RawResponse:    stream.rawResponse(),
+		RemoteAddress:  host,
+		Timestamp:      time.Now(),
+		TLSClientHello: h.clientHellos.Get(remoteAddr),
+		HTTP2:          &stream.HTTP2Stream,
+	}
+	h.options.storeInteraction(correlationID, interaction)
+}
+
+// http2StreamRecord is a stream recorded until it reaches the handler or is rejected
+type http2StreamRecord struct {
+	HTTP2Stream
+
+	method    string
+	path      string
+	authority string
+	data      []byte
+	complete  bool
+
+	responseHeaders []string
+	responseData    []byte
+}
+
+// rawRequest returns the header fields and the data sent on the stream
+func (s *http2StreamRecord) rawRequest() string {
+	return http2Message(s.Headers, s.data)
+}
+
+// rawResponse returns the response of the server rejecting the stream
+func (s *http2StreamRecord) rawResponse() string {
+	if s.Reset != "" {
+		return "RST_STREAM " + s.Reset
+	}
+	return http2Message(s.responseHeaders, s.responseData)
+}
+
+func http2Message(headers []string, data []byte) string {
+	builder := &strings.Builder{}
+	for _, header := range headers {
+		builder.WriteString(header)
+		builder.WriteString("\r\n")
+	}
+	builder.WriteString("\r\n")
+	builder.Write(data)
+	return builder.String()
+}
+
+// http2Conn records the frames exchanged on an HTTP/2 connection, as
+// decrypted by the tls connection, so that the streams rejected by the
+// server before reaching the handler (eg. smuggling probes with invalid
+// header fields) are visible, answered with an error response or reset.
+type http2Conn struct {
+	*tls.Conn
+	// reject is called with the streams rejected by the server
+	reject func(remoteAddr string, stream *http2StreamRecord)
+
+	mutex       sync.Mutex
+	in          http2FrameReader
+	out         http2FrameReader
+	decoder     *hpack.Decoder
+	decoding    *http2StreamRecord
+	outDecoder  *hpack.Decoder
+	outDecoding *http2StreamRecord
+	streams     map[uint32]*http2StreamRecord
+}
+
+func newHTTP2Conn(conn *tls.Conn, reject func(remoteAddr string, stream *http2StreamRecord)) *http2Conn {
+	c := &http2Conn{Conn: conn, reject: reject, streams: make(map[uint32]*http2StreamRecord)}
+	c.in.preface = len(http2.ClientPreface)
+	c.decoder = hpack.NewDecoder(4096, c.emitHeader)
+	c.outDecoder = hpack.NewDecoder(4096, c.emitResponseHeader)
+	// the table size of the server is the one announced by the client
+	c.outDecoder.SetAllowedMaxDynamicTableSize(maxHTTP2FrameSize)
+	return c
+}
+
+func (c *http2Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mutex.Lock()
+		c.in.feed(b[:n], c.handleClientFrame)
+		c.mutex.Unlock()
+	}
+	return n, err
+}
+
+func (c *http2Conn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	var rejected []*http2StreamRecord
+	c.out.feed(b, func(header http2.FrameHeader, payload []byte) {
+		rejected = append(rejected, c.handleServerFrame(header, payload)...)
+	})
+	c.mutex.Unlock()
+
+	for _, stream := range rejected {
+		c.reject(c.RemoteAddr().String(), stream)
+	}
+	return c.Conn.Write(b)
+}
+
+// claim returns the stream of a request dispatched to the handler, matched by
+// its method and path as the stream id isn't exposed to the handlers.
+func (c *http2Conn) claim(method, path string) *HTTP2Stream {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var claimed *http2StreamRecord
+	for _, stream := range c.streams {
+		if stream.complete && stream.method == method && stream.path == path && (claimed == nil || stream.ID < claimed.ID) {
+			claimed = stream
+		}
+	}
+	if claimed == nil {
+		return nil
+	}
+	delete(c.streams, claimed.ID)
+	return &claimed.HTTP2Stream
+}
+
+func (c *http2Conn) emitHeader(field hpack.HeaderField) {
+	stream := c.decoding
+	if stream == nil {
+		return
+	}
+	switch field.Name {
+	case ":method":
+		stream.method = field.Value
+	case ":path":
+		stream.path = field.Value
+	case ":authority":
+		stream.authority = field.Value
+	}
+	if len(stream.Headers) < maxHTTP2StreamHeaders {
+		stream.Headers = append(stream.Headers, field.Name+": "+field.Value)
+	}
+}
+
+func (c *http2Conn) emitResponseHeader(field hpack.HeaderField) {
+	stream := c.outDecoding
+	if stream != nil && len(stream.responseHeaders) < maxHTTP2StreamHeaders {
+		stream.responseHeaders = append(stream.responseHeaders, field.Name+": "+field.Value)
+	}
+}
+
+func (c *http2Conn) handleClientFrame(header http2.FrameHeader, payload []byte) {
+	if header.StreamID == 0 {
+		return
+	}
+	stream, ok := c.streams[header.StreamID]
+	if !ok && header.Type == http2.FrameHeaders && len(c.streams) < maxHTTP2Streams {
+		stream = &http2StreamRecord{HTTP2Stream: HTTP2Stream{ID: header.StreamID}}
+		c.streams[header.StreamID] = stream
+	}
+	if stream != nil && len(stream.Frames) < maxHTTP2StreamFrames {
+		stream.Frames = append(stream.Frames, http2FrameSummary(header, payload))
+	}
+
+	switch header.Type {
+	case http2.FrameHeaders, http2.FrameContinuation:
+		if header.Type == http2.FrameHeaders {
+			payload = http2FramePayload(header, payload)
+			if header.Flags.Has(http2.FlagHeadersPriority) && len(payload) >= 5 {
+				payload = payload[5:]
+			}
+		}
+		c.decoding = stream
+		endHeaders := header.Flags.Has(http2.FlagHeadersEndHeaders)
+		c.decoder = decodeHTTP2Headers(c.decoder, payload, endHeaders)
+		if endHeaders {
+			if stream != nil {
+				stream.complete = true
+			}
+			c.decoding = nil
+		}
+	case http2.FrameData:
+		if stream != nil && len(stream.data) < maxHTTP2StreamData {
+			data := http2FramePayload(header, payload)
+			if len(data) > maxHTTP2StreamData-len(stream.data) {
+				data = data[:maxHTTP2StreamData-len(stream.data)]
+			}
+			stream.data = append(stream.data, data...)
+		}
+	}
+}
+
+// decodeHTTP2Headers decodes a header block fragment, returning nil once the
+// decoder fails. The header blocks of every stream are decoded to keep the
+// dynamic table in sync.
+func decodeHTTP2Headers(decoder *hpack.Decoder, fragment []byte, endHeaders bool) *hpack.Decoder {
+	if decoder == nil {
+		return nil
+	}
+	if _, err := decoder.Write(fragment); err != nil {
+		return nil
+	}
+	if endHeaders {
+		if err := decoder.Close(); err != nil {
+			return nil
+		}
+	}
+	return decoder
+}
+
+// handleServerFrame returns the streams rejected by a frame sent by the server,
+// the streams dispatched to the handler being claimed before any response.
+func (c *http2Conn) handleServerFrame(header http2.FrameHeader, payload []byte) []*http2StreamRecord {
+	stream := c.streams[header.StreamID]
+	switch header.Type {
+	case http2.FrameHeaders, http2.FrameContinuation:
+		if header.Type == http2.FrameHeaders {
+			payload = http2FramePayload(header, payload)
+			if header.Flags.Has(http2.FlagHeadersPriority) && len(payload) >= 5 {
+				payload = payload[5:]
+			}
+		}
+		c.outDecoding = stream
+		endHeaders := header.Flags.Has(http2.FlagHeadersEndHeaders)
+		c.outDecoder = decodeHTTP2Headers(c.outDecoder, payload, endHeaders)
+		if endHeaders {
+			c.outDecoding = nil
+		}
+	case http2.FrameData:
+		if stream != nil && len(stream.responseData) < maxHTTP2StreamData {
+			data := http2FramePayload(header, payload)
+			if len(data) > maxHTTP2StreamData-len(stream.responseData) {
+				data = data[:maxHTTP2StreamData-len(stream.responseData)]
+			}
+			stream.responseData = append(stream.responseData, data...)
+		}
+	case http2.FrameRSTStream:
+		if stream == nil || len(payload) < 4 {
+			return nil
+		}
+		delete(c.streams, header.StreamID)
+		if code := http2.ErrCode(binary.BigEndian.Uint32(payload)); code != http2.ErrCodeNo {
+			stream.Reset = code.String()
+		}
+		return []*http2StreamRecord{stream}
+	case http2.FrameGoAway:
+		if len(payload) < 8 {
+			return nil
+		}
+		code := http2.ErrCode(binary.BigEndian.Uint32(payload[4:]))
+		if code == http2.ErrCodeNo {
+			return nil
+		}
+		var rejected []*http2StreamRecord
+		for id, stream := range c.streams {
+			stream.Reset = code.String()
+			rejected = append(rejected, stream)
+			delete(c.streams, id)
+		}
+		return rejected
+	}
+	if stream != nil && (header.Type == http2.FrameHeaders || header.Type == http2.FrameData) && header.Flags.Has(http2.FlagDataEndStream) {
+		delete(c.streams, header.StreamID)
+		return []*http2StreamRecord{stream}
+	}
+	return nil
+}
+
+// http2FramePayload returns the payload of a frame without its padding
+func http2FramePayload(header http2.FrameHeader, payload []byte) []byte {
+	padded := header.Type == http2.FrameData && header.Flags.Has(http2.FlagDataPadded) ||
+		header.Type == http2.FrameHeaders && header.Flags.Has(http2.FlagHeadersPadded)
+	if !padded {
+		return payload
+	}
+	if len(payload) == 0 || int(payload[0]) >= len(payload) {
+		return nil
+	}
+	return payload[1 : len(payload)-int(payload[0])]
+}
+
+// http2FlagNames are the names of the flags of the frames carrying stream data
+var http2FlagNames = map[http2.FrameType][]struct {
+	flag http2.Flags
+	name string
+}{
+	http2.FrameData: {
+		{http2.FlagDataEndStream, "END_STREAM"},
+		{http2.FlagDataPadded, "PADDED"},
+	},
+	http2.FrameHeaders: {
+		{http2.FlagHeadersEndStream, "END_STREAM"},
+		{http2.FlagHeadersEndHeaders, "END_HEADERS"},
+		{http2.FlagHeadersPadded, "PADDED"},
+		{http2.FlagHeadersPriority, "PRIORITY"},
+	},
+	http2.FrameContinuation: {
+		{http2.FlagContinuationEndHeaders, "END_HEADERS"},
+	},
+}
+
+// http2FrameSummary returns the summary of a frame (eg. HEADERS len=30 flags=END_HEADERS)
+func http2FrameSummary(header http2.FrameHeader, payload []byte) string {
+	summary := fmt.Sprintf("%s len=%d", header.Type, header.Length)
+	var flags []string
+	for _, name := range http2FlagNames[header.Type] {
+		if header.Flags.Has(name.flag) {
+			flags = append(flags, name.name)
+		}
+	}
+	if len(flags) > 0 {
+		summary += " flags=" + strings.Join(flags, "|")
+	}
+	if header.Type == http2.FrameRSTStream && len(payload) >= 4 {
+		summary += " code=" + http2.ErrCode(binary.BigEndian.Uint32(payload)).String()
+	}
+	return summary
+}
+
+// http2FrameReader splits the data of one direction of a connection into frames
+type http2FrameReader struct {
+	// preface is the number of bytes of the client preface left to skip
+	preface int
+	buffer  []byte
+	broken  bool
+}
+
+func (r *http2FrameReader) feed(data []byte, handle func(header http2.FrameHeader, payload []byte)) {
+	if r.broken {
+		return
+	}
+	if r.preface > 0 {
+		skip := r.preface
+		if skip > len(data) {
+			skip = len(data)
+		}
+		r.preface -= skip
+		data = data[skip:]
+	}
+	r.buffer = append(r.buffer, data...)
+
+	for len(r.buffer) >= http2FrameHeaderLength {
+		length := uint32(r.buffer[0])<<16 | uint32(r.buffer[1])<<8 | uint32(r.buffer[2])
+		if length > maxHTTP2FrameSize {
+			// the server fails the connection as well
+			r.broken = true
+			r.buffer = nil
+			return
+		}
+		if len(r.buffer) < http2FrameHeaderLength+int(length) {
+			break
+		}
+		header := http2.FrameHeader{
+			Type:     http2.FrameType(r.buffer[3]),
+			Flags:    http2.Flags(r.buffer[4]),
+			Length:   length,
+			StreamID: binary.BigEndian.Uint32(r.buffer[5:]) & (1<<31 - 1),
+		}
+		handle(header, r.buffer[http2FrameHeaderLength:http2FrameHeaderLength+int(length)])
+		r.buffer = r.buffer[http2FrameHeaderLength+int(length):]
+	}
+	if len(r.buffer) == 0 {
+		r.buffer = nil
+	}
+}
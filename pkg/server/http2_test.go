@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+func TestHTTP2Conn(t *testing.T) {
+	var headersBuffer bytes.Buffer
+	encoder := hpack.NewEncoder(&headersBuffer)
+	encode := func(fields ...string) []byte {
+		headersBuffer.Reset()
+		for i := 0; i < len(fields); i += 2 {
+			_ = encoder.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+		}
+		return append([]byte(nil), headersBuffer.Bytes()...)
+	}
+
+	var in bytes.Buffer
+	in.WriteString(http2.ClientPreface)
+	framer := http2.NewFramer(&in, nil)
+	require.Nil(t, framer.WriteSettings(), "could not write settings")
+	require.Nil(t, framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: encode(":method", "POST", ":path", "/login", ":authority", "example.com", "content-length", "4"), EndHeaders: true}), "could not write headers")
+	require.Nil(t, framer.WriteData(1, true, []byte("test")), "could not write data")
+	require.Nil(t, framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 3, BlockFragment: encode(":method", "GET", ":path", "/", ":authority", "c6rj61aciaeutn2ae680cg5ugboyyyyyn.example.com", "transfer-encoding", "chunked"), EndHeaders: true, EndStream: true}), "could not write headers")
+	require.Nil(t, framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 5, BlockFragment: encode(":method", "GET", ":path", "/", ":authority", "example.com", "te", "gzip"), EndHeaders: true, EndStream: true}), "could not write headers")
+
+	conn := newHTTP2Conn(nil, nil)
+	// frames split across reads like tls records
+	data := in.Bytes()
+	for len(data) > 0 {
+		n := 7
+		if n > len(data) {
+			n = len(data)
+		}
+		conn.in.feed(data[:n], conn.handleClientFrame)
+		data = data[n:]
+	}
+
+	stream := conn.claim("POST", "/login")
+	require.NotNil(t, stream, "could not claim stream")
+	require.Equal(t, uint32(1), stream.ID, "could not get stream id")
+	require.Contains(t, stream.Headers, "content-length: 4", "could not get headers")
+	require.Equal(t, []string{"HEADERS len=21 flags=END_HEADERS", "DATA len=4 flags=END_STREAM"}, stream.Frames, "could not get frames")
+	require.Nil(t, conn.claim("POST", "/login"), "could claim stream twice")
+
+	var out bytes.Buffer
+	require.Nil(t, http2.NewFramer(&out, nil).WriteRSTStream(3, http2.ErrCodeProtocol), "could not write reset")
+	var rejected []*http2StreamRecord
+	conn.out.feed(out.Bytes(), func(header http2.FrameHeader, payload []byte) {
+		rejected = append(rejected, conn.handleServerFrame(header, payload)...)
+	})
+	require.Len(t, rejected, 1, "could not get rejected stream")
+	require.Equal(t, "PROTOCOL_ERROR", rejected[0].Reset, "could not get reset code")
+	require.Equal(t, "c6rj61aciaeutn2ae680cg5ugboyyyyyn.example.com", rejected[0].authority, "could not get authority")
+	require.Contains(t, rejected[0].rawRequest(), "transfer-encoding: chunked\r\n", "could not get raw request")
+	require.Equal(t, "RST_STREAM PROTOCOL_ERROR", rejected[0].rawResponse(), "could not get raw response")
+
+	out.Reset()
+	outFramer := http2.NewFramer(&out, nil)
+	require.Nil(t, outFramer.WriteHeaders(http2.HeadersFrameParam{StreamID: 5, BlockFragment: encode(":status", "400"), EndHeaders: true}), "could not write headers")
+	require.Nil(t, outFramer.WriteData(5, true, []byte("bad request")), "could not write data")
+	rejected = nil
+	conn.out.feed(out.Bytes(), func(header http2.FrameHeader, payload []byte) {
+		rejected = append(rejected, conn.handleServerFrame(header, payload)...)
+	})
+	require.Len(t, rejected, 1, "could not get rejected stream")
+	require.Equal(t, uint32(5), rejected[0].ID, "could not get rejected stream id")
+	require.Empty(t, rejected[0].Reset, "could get reset code")
+	require.Equal(t, ":status: 400\r\n\r\nbad request", rejected[0].rawResponse(), "could not get raw response")
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+)
+
+// maxHTTPBodySize is the maximum size in bytes of the request bodies read by the http server
+const maxHTTPBodySize = 256 * 1024 * 1024
+
+// HTTPBody is the summary of the body of an http request.
+type HTTPBody struct {
+	// Size is the size in bytes of the body read
+	Size int64 `json:"size"`
+	// SHA256 is the hex encoded sha256 of the body read
+	SHA256 string `json:"sha256"`
+	// Truncated is true if the raw request only holds the start of the body
+	Truncated bool `json:"truncated,omitempty"`
+	// Partial is true if the body was larger than the 256 MB read by the server
+	Partial bool `json:"partial,omitempty"`
+	// File is the path of the body written to the body directory, if enabled
+	File string `json:"file,omitempty"`
+}
+
+// HTTPBodyWriter writes the request bodies to a directory, naming the files
+// after their sha256 so that a body sent several times is written once.
+type HTTPBodyWriter struct {
+	directory string
+}
+
+// NewHTTPBodyWriter returns a new body writer creating the files in the directory.
+func NewHTTPBodyWriter(directory string) (*HTTPBodyWriter, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, errors.Wrap(err, "could not create body directory")
+	}
+	return &HTTPBodyWriter{directory: directory}, nil
+}
+
+// create creates the temporary file a body is streamed to
+func (b *HTTPBodyWriter) create() (*os.File, error) {
+	if b == nil {
+		return nil, nil
+	}
+	return ioutil.TempFile(b.directory, ".body-")
+}
+
+// commit closes the temporary file of a body and renames it after its hash,
+// returning its final path.
+func (b *HTTPBodyWriter) commit(file *os.File, hash string) (string, error) {
+	name := file.Name()
+	if err := file.Close(); err != nil {
+		_ = os.Remove(name)
+		return "", err
+	}
+	path := filepath.Join(b.directory, hash)
+	if err := os.Rename(name, path); err != nil {
+		_ = os.Remove(name)
+		return "", err
+	}
+	return path, nil
+}
+
+// limitedBuffer keeps the first max bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.max - b.Len(); remaining < len(p) {
+		b.Buffer.Write(p[:remaining])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// dumpRequest returns the raw request holding the first HTTPMaxBody bytes of
+// its body, which is read while hashing it and streaming it to the body
+// directory so that large bodies are never buffered in memory. The body of
+// the request is replaced by the bytes kept.
+func (h *HTTPServer) dumpRequest(r *http.Request) (string, *HTTPBody) {
+	raw, _ := httputil.DumpRequest(r, false)
+	if r.Body == nil || r.Body == http.NoBody {
+		return string(raw), nil
+	}
+	hash := sha256.New()
+	kept := &limitedBuffer{max: h.options.HTTPMaxBody}
+	writers := []io.Writer{hash, kept}
+
+	file, err := h.options.HTTPBodies.create()
+	if err != nil {
+		gologger.Warning().Msgf("Could not create body file: %s\n", err)
+	} else if file != nil {
+		writers = append(writers, file)
+	}
+	size, _ := io.Copy(io.MultiWriter(writers...), io.LimitReader(r.Body, maxHTTPBodySize))
+	partial := false
+	if size == maxHTTPBodySize {
+		// the remaining bytes are left unread
+		n, _ := r.Body.Read(make([]byte, 1))
+		partial = n > 0
+	}
+	_ = r.Body.Close()
+	if size == 0 {
+		if file != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+		r.Body = http.NoBody
+		return string(raw), nil
+	}
+
+	body := &HTTPBody{Size: size, SHA256: hex.EncodeToString(hash.Sum(nil)), Truncated: kept.truncated, Partial: partial}
+	if file != nil {
+		if body.File, err = h.options.HTTPBodies.commit(file, body.SHA256); err != nil {
+			gologger.Warning().Msgf("Could not write body file: %s\n", err)
+		}
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(kept.Bytes()))
+	return string(raw) + kept.String(), body
+}
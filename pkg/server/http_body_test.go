@@ -0,0 +1,41 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPServerDumpRequest(t *testing.T) {
+	bodies, err := NewHTTPBodyWriter(t.TempDir())
+	require.Nil(t, err, "could not create body writer")
+	server := &HTTPServer{options: &Options{HTTPMaxBody: 16, HTTPBodies: bodies}}
+
+	data := bytes.Repeat([]byte("exfiltrated "), 1000)
+	hash := sha256.Sum256(data)
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/upload", bytes.NewReader(data))
+	raw, body := server.dumpRequest(req)
+	require.True(t, strings.HasSuffix(raw, "\r\n\r\nexfiltrated exfi"), "invalid raw request body")
+	require.Equal(t, &HTTPBody{Size: int64(len(data)), SHA256: hex.EncodeToString(hash[:]), Truncated: true, File: filepath.Join(bodies.directory, hex.EncodeToString(hash[:]))}, body, "invalid body")
+
+	written, err := ioutil.ReadFile(body.File)
+	require.Nil(t, err, "could not read body file")
+	require.Equal(t, data, written, "invalid body file")
+	kept, _ := ioutil.ReadAll(req.Body)
+	require.Equal(t, "exfiltrated exfi", string(kept), "invalid kept body")
+
+	raw, body = server.dumpRequest(httptest.NewRequest(http.MethodPost, "http://example.com/upload", strings.NewReader("small")))
+	require.False(t, body.Truncated, "truncated small body")
+	require.True(t, strings.HasSuffix(raw, "\r\n\r\nsmall"), "invalid small raw request body")
+
+	_, body = server.dumpRequest(httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	require.Nil(t, body, "returned body of get request")
+}
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+const (
+	// HTTPChallengeBasic answers the requests of a session with a Basic authentication challenge
+	HTTPChallengeBasic = "basic"
+	// HTTPChallengeNTLM answers the requests of a session with an NTLM authentication challenge
+	HTTPChallengeNTLM = "ntlm"
+)
+
+// HTTPChallenges are the http authentication challenges a session can request.
+var HTTPChallenges = []string{HTTPChallengeBasic, HTTPChallengeNTLM}
+
+// ntlmServerChallenge is the server challenge of the ntlm challenge messages,
+// the well-known value the cracking tools have precomputed tables for
+var ntlmServerChallenge = []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}
+
+// ntlmSignature is the signature of the ntlm messages
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+const (
+	ntlmNegotiateUnicode    = 0x00000001
+	ntlmRequestTarget       = 0x00000004
+	ntlmNegotiateNTLM       = 0x00000200
+	ntlmNegotiateAlwaysSign = 0x00008000
+	ntlmTargetTypeDomain    = 0x00010000
+	ntlmNegotiateESS        = 0x00080000
+	ntlmNegotiateTargetInfo = 0x00800000
+	ntlmNegotiateVersion    = 0x02000000
+	ntlmNegotiate128        = 0x20000000
+	ntlmNegotiate56         = 0x80000000
+)
+
+// HTTPCredential is a credential sent by a client answering the http
+// authentication challenge of a session.
+type HTTPCredential struct {
+	// Scheme is the authentication scheme (basic or ntlm)
+	Scheme string `json:"scheme"`
+	// Username is the username presented
+	Username string `json:"username"`
+	// Password is the password presented with basic authentication
+	Password string `json:"password,omitempty"`
+	// Domain is the domain of the ntlm user
+	Domain string `json:"domain,omitempty"`
+	// Workstation is the workstation of the ntlm client
+	Workstation string `json:"workstation,omitempty"`
+	// Version is the version of the ntlm response (NTLMv1 or NTLMv2)
+	Version string `json:"version,omitempty"`
+	// Hash is the ntlm response in the hashcat format (5500 for NTLMv1, 5600 for NTLMv2)
+	Hash string `json:"hash,omitempty"`
+}
+
+// ValidHTTPChallenge returns true if a session can request the http challenge.
+func ValidHTTPChallenge(challenge string) bool {
+	return challenge == HTTPChallengeBasic || challenge == HTTPChallengeNTLM
+}
+
+// SessionChallenges are the http authentication challenges requested by the
+// clients for their sessions.
+type SessionChallenges struct {
+	alive func(correlationID string) bool
+
+	sync.Mutex
+	sessions map[string]string
+}
+
+// NewSessionChallenges returns new session challenges, alive returning false
+// for the sessions which are no longer registered.
+func NewSessionChallenges(alive func(correlationID string) bool) *SessionChallenges {
+	return &SessionChallenges{alive: alive, sessions: make(map[string]string)}
+}
+
+// Set sets the challenge of a session, removing it if empty.
+func (s *SessionChallenges) Set(correlationID, challenge string) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if challenge == "" {
+		delete(s.sessions, correlationID)
+		return
+	}
+	if _, ok := s.sessions[correlationID]; !ok && len(s.sessions) >= maxAccountedSessions {
+		s.prune()
+	}
+	s.sessions[correlationID] = challenge
+}
+
+// Get returns the challenge of a registered session, empty if it didn't request any.
+func (s *SessionChallenges) Get(correlationID string) string {
+	if s == nil {
+		return ""
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	challenge, ok := s.sessions[correlationID]
+	if !ok || !s.alive(correlationID) {
+		return ""
+	}
+	return challenge
+}
+
+// Remove removes the challenge of a deregistered session.
+func (s *SessionChallenges) Remove(correlationID string) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	delete(s.sessions, correlationID)
+	s.Unlock()
+}
+
+// prune removes the sessions which are no longer registered
+func (s *SessionChallenges) prune() {
+	for correlationID := range s.sessions {
+		if !s.alive(correlationID) {
+			delete(s.sessions, correlationID)
+		}
+	}
+}
+
+// sessionChallenge returns the http challenge requested by the session of a request, if any
+func (options *Options) sessionChallenge(req *http.Request) string {
+	if options.SessionChallenges == nil {
+		return ""
+	}
+	_, _, correlationID := options.findUniqueID(req.Host)
+	if correlationID == "" {
+		_, _, correlationID = options.findInteractionID(req.URL.Path)
+	}
+	if correlationID == "" {
+		return ""
+	}
+	return options.SessionChallenges.Get(correlationID)
+}
+
+// challenge answers the request with the authentication challenge of its
+// session until the client presents its credentials, returning true once
+// they have been presented.
+func (h *HTTPServer) challenge(w http.ResponseWriter, req *http.Request, challenge string, domain string) bool {
+	authorization := req.Header.Get("Authorization")
+	if credential := parseHTTPCredential(authorization); credential != nil && credential.Scheme == challenge {
+		return true
+	}
+	switch challenge {
+	case HTTPChallengeBasic:
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", domain))
+	case HTTPChallengeNTLM:
+		if message := ntlmMessage(authorization); len(message) > 12 && binary.LittleEndian.Uint32(message[8:]) == 1 {
+			w.Header().Set("WWW-Authenticate", "NTLM "+base64.StdEncoding.EncodeToString(ntlmChallengeMessage(domain)))
+		} else {
+			w.Header().Set("WWW-Authenticate", "NTLM")
+		}
+	}
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	return false
+}
+
+// httpCredential returns the credential answering the challenge of a session, if any
+func (options *Options) httpCredential(correlationID string, req *http.Request) *HTTPCredential {
+	if options.SessionChallenges.Get(correlationID) == "" {
+		return nil
+	}
+	return parseHTTPCredential(req.Header.Get("Authorization"))
+}
+
+// parseHTTPCredential parses the basic credentials or the ntlm authenticate
+// message of an authorization header, nil for the other messages
+func parseHTTPCredential(authorization string) *HTTPCredential {
+	scheme, value := splitAuthorization(authorization)
+	if strings.EqualFold(scheme, "Basic") {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		credential := &HTTPCredential{Scheme: HTTPChallengeBasic, Username: parts[0]}
+		if len(parts) == 2 {
+			credential.Password = parts[1]
+		}
+		return credential
+	}
+	if message := ntlmMessage(authorization); message != nil {
+		return parseNTLMAuthenticate(message)
+	}
+	return nil
+}
+
+// splitAuthorization returns the scheme and the value of an authorization header
+func splitAuthorization(authorization string) (scheme, value string) {
+	parts := strings.SplitN(strings.TrimSpace(authorization), " ", 2)
+	if len(parts) != 2 {
+		return parts[0], ""
+	}
+	return parts[0], strings.TrimSpace(parts[1])
+}
+
+// ntlmMessage returns the ntlm message of an NTLM or Negotiate authorization header, if any
+func ntlmMessage(authorization string) []byte {
+	scheme, value := splitAuthorization(authorization)
+	if !strings.EqualFold(scheme, "NTLM") && !strings.EqualFold(scheme, "Negotiate") {
+		return nil
+	}
+	message, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(message) < 12 || !bytes.HasPrefix(message, ntlmSignature) {
+		return nil
+	}
+	return message
+}
+
+// ntlmChallengeMessage returns the ntlm challenge message of the server,
+// announcing the domain and its netbios name as the target
+func ntlmChallengeMessage(domain string) []byte {
+	netbios := strings.ToUpper(strings.SplitN(domain, ".", 2)[0])
+	if len(netbios) > 15 {
+		netbios = netbios[:15]
+	}
+	targetName := ntlmString(netbios)
+
+	targetInfo := &bytes.Buffer{}
+	for _, pair := range []struct {
+		id    uint16
+		value []byte
+	}{{2, ntlmString(netbios)}, {1, ntlmString(netbios)}, {4, ntlmString(domain)}, {3, ntlmString(domain)}, {0, nil}} {
+		_ = binary.Write(targetInfo, binary.LittleEndian, pair.id)
+		_ = binary.Write(targetInfo, binary.LittleEndian, uint16(len(pair.value)))
+		targetInfo.Write(pair.value)
+	}
+
+	const headerSize = 56
+	message := make([]byte, headerSize, headerSize+len(targetName)+targetInfo.Len())
+	copy(message, ntlmSignature)
+	binary.LittleEndian.PutUint32(message[8:], 2)
+	putNTLMField(message[12:], len(targetName), headerSize)
+	flags := uint32(ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign | ntlmTargetTypeDomain | ntlmNegotiateESS | ntlmNegotiateTargetInfo | ntlmNegotiateVersion | ntlmNegotiate128 | ntlmNegotiate56)
+	binary.LittleEndian.PutUint32(message[20:], flags)
+	copy(message[24:], ntlmServerChallenge)
+	putNTLMField(message[40:], targetInfo.Len(), headerSize+len(targetName))
+	// version 10.0 build 19041, ntlm revision 15
+	copy(message[48:], []byte{10, 0, 0x61, 0x4a, 0, 0, 0, 15})
+	message = append(message, targetName...)
+	return append(message, targetInfo.Bytes()...)
+}
+
+// parseNTLMAuthenticate returns the credential of an ntlm authenticate
+// message, nil if it isn't one
+func parseNTLMAuthenticate(message []byte) *HTTPCredential {
+	if len(message) < 64 || binary.LittleEndian.Uint32(message[8:]) != 3 {
+		return nil
+	}
+	flags := binary.LittleEndian.Uint32(message[60:])
+	str := func(offset int) string {
+		value := ntlmField(message, offset)
+		if flags&ntlmNegotiateUnicode == 0 {
+			return string(value)
+		}
+		runes := make([]uint16, len(value)/2)
+		for i := range runes {
+			runes[i] = binary.LittleEndian.Uint16(value[i*2:])
+		}
+		return string(utf16.Decode(runes))
+	}
+	credential := &HTTPCredential{Scheme: HTTPChallengeNTLM, Domain: str(28), Username: str(36), Workstation: str(44)}
+	lm, nt := ntlmField(message, 12), ntlmField(message, 20)
+	challenge := hex.EncodeToString(ntlmServerChallenge)
+	switch {
+	case len(nt) == 24:
+		credential.Version = "NTLMv1"
+		credential.Hash = fmt.Sprintf("%s::%s:%s:%s:%s", credential.Username, credential.Domain, hex.EncodeToString(lm), hex.EncodeToString(nt), challenge)
+	case len(nt) > 24:
+		credential.Version = "NTLMv2"
+		credential.Hash = fmt.Sprintf("%s::%s:%s:%s:%s", credential.Username, credential.Domain, challenge, hex.EncodeToString(nt[:16]), hex.EncodeToString(nt[16:]))
+	}
+	return credential
+}
+
+// ntlmField returns the payload of the security buffer of a message at an offset
+func ntlmField(message []byte, offset int) []byte {
+	length := int(binary.LittleEndian.Uint16(message[offset:]))
+	start := int(binary.LittleEndian.Uint32(message[offset+4:]))
+	if start < 0 || start+length > len(message) {
+		return nil
+	}
+	return message[start : start+length]
+}
+
+// putNTLMField writes a security buffer of a payload
+func putNTLMField(field []byte, length, offset int) {
+	binary.LittleEndian.PutUint16(field, uint16(length))
+	binary.LittleEndian.PutUint16(field[2:], uint16(length))
+	binary.LittleEndian.PutUint32(field[4:], uint32(offset))
+}
+
+// ntlmString returns the unicode encoding of a string in the ntlm messages
+func ntlmString(value string) []byte {
+	encoded := utf16.Encode([]rune(value))
+	data := make([]byte, len(encoded)*2)
+	for i, r := range encoded {
+		binary.LittleEndian.PutUint16(data[i*2:], r)
+	}
+	return data
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPChallenge(t *testing.T) {
+	store := storage.New(time.Hour)
+	require.Nil(t, store.SetID("c23b2la0kl1krjcrdj10"), "could not set id")
+	options := &Options{Domain: "example.com", Storage: store, SessionChallenges: NewSessionChallenges(store.HasID)}
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+	get := func(authorization string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://c23b2la0kl1krjcrdj10cndmnioyyyyyn.example.com/", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		server.nontlsserver.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, http.StatusOK, get("").Code, "challenged a session without challenge")
+
+	options.SessionChallenges.Set("c23b2la0kl1krjcrdj10", HTTPChallengeBasic)
+	rec := get("")
+	require.Equal(t, http.StatusUnauthorized, rec.Code, "could not challenge basic")
+	require.Equal(t, `Basic realm="example.com"`, rec.Header().Get("WWW-Authenticate"), "could not set basic challenge")
+	require.Equal(t, http.StatusOK, get("Basic "+base64.StdEncoding.EncodeToString([]byte("admin:s3cret"))).Code, "could not accept basic credentials")
+
+	options.SessionChallenges.Set("c23b2la0kl1krjcrdj10", HTTPChallengeNTLM)
+	require.Equal(t, "NTLM", get("").Header().Get("WWW-Authenticate"), "could not challenge ntlm")
+	negotiate := append(append([]byte{}, ntlmSignature...), 1, 0, 0, 0, 0x07, 0x82, 0x08, 0xa2)
+	rec = get("NTLM " + base64.StdEncoding.EncodeToString(negotiate))
+	require.Equal(t, http.StatusUnauthorized, rec.Code, "could not send ntlm challenge")
+	challenge, err := base64.StdEncoding.DecodeString(rec.Header().Get("WWW-Authenticate")[len("NTLM "):])
+	require.Nil(t, err, "could not decode ntlm challenge")
+	require.Equal(t, uint32(2), binary.LittleEndian.Uint32(challenge[8:]), "invalid ntlm challenge type")
+	require.Equal(t, ntlmServerChallenge, challenge[24:32], "invalid ntlm server challenge")
+	require.Equal(t, ntlmString("EXAMPLE"), ntlmField(challenge, 12), "invalid ntlm target name")
+
+	response := make([]byte, 40)
+	for i := range response {
+		response[i] = byte(i)
+	}
+	rec = get("NTLM " + base64.StdEncoding.EncodeToString(ntlmAuthenticate("CORP", "alice", "WS01", nil, response)))
+	require.Equal(t, http.StatusOK, rec.Code, "could not accept ntlm authenticate")
+
+	req := httptest.NewRequest(http.MethodGet, "http://c23b2la0kl1krjcrdj10cndmnioyyyyyn.example.com/", nil)
+	req.Header.Set("Authorization", "NTLM "+base64.StdEncoding.EncodeToString(ntlmAuthenticate("CORP", "alice", "WS01", nil, response)))
+	credential := options.httpCredential("c23b2la0kl1krjcrdj10", req)
+	require.Equal(t, &HTTPCredential{Scheme: HTTPChallengeNTLM, Username: "alice", Domain: "CORP", Workstation: "WS01", Version: "NTLMv2", Hash: "alice::CORP:1122334455667788:000102030405060708090a0b0c0d0e0f:1011121314151617" + "18191a1b1c1d1e1f2021222324252627"}, credential, "could not record ntlmv2 hash")
+	options.SessionChallenges.Remove("c23b2la0kl1krjcrdj10")
+	require.Nil(t, options.httpCredential("c23b2la0kl1krjcrdj10", req), "recorded credential of a session without challenge")
+
+	credential = parseNTLMAuthenticate(ntlmAuthenticate("CORP", "bob", "WS02", make([]byte, 24), make([]byte, 24)))
+	require.Equal(t, "NTLMv1", credential.Version, "could not detect ntlmv1")
+	require.Equal(t, "bob::CORP:"+zeroHex(24)+":"+zeroHex(24)+":1122334455667788", credential.Hash, "invalid ntlmv1 hash")
+}
+
+// ntlmAuthenticate returns a unicode ntlm authenticate message
+func ntlmAuthenticate(domain, username, workstation string, lm, nt []byte) []byte {
+	message := make([]byte, 64)
+	copy(message, ntlmSignature)
+	binary.LittleEndian.PutUint32(message[8:], 3)
+	binary.LittleEndian.PutUint32(message[60:], ntlmNegotiateUnicode)
+	for _, field := range []struct {
+		offset int
+		value  []byte
+	}{{12, lm}, {20, nt}, {28, ntlmString(domain)}, {36, ntlmString(username)}, {44, ntlmString(workstation)}} {
+		putNTLMField(message[field.offset:], len(field.value), len(message))
+		message = append(message, field.value...)
+	}
+	return message
+}
+
+func zeroHex(n int) string {
+	data := make([]byte, n*2)
+	for i := range data {
+		data[i] = '0'
+	}
+	return string(data)
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxHelperDelay is the maximum delay of the /delay/<seconds> responses
+	maxHelperDelay = 30 * time.Second
+	// maxHelperBytes is the maximum size of the /bytes/<n> responses
+	maxHelperBytes = 10 * 1024 * 1024
+	// maxRawResponseBody is the maximum size of the http response bodies recorded in the interactions
+	maxRawResponseBody = 64 * 1024
+)
+
+// redirectCodes are the status codes accepted by the /redirect responses
+var redirectCodes = map[int]bool{http.StatusMovedPermanently: true, http.StatusFound: true, http.StatusSeeOther: true, http.StatusTemporaryRedirect: true, http.StatusPermanentRedirect: true}
+
+// helperHandler serves the helper endpoints of the correlated hosts exercising
+// the ssrf filters and the timeouts of the clients, returning true if the
+// request has been answered:
+//
+//	/redirect?to=<url>[&code=<301|302|303|307|308>] redirects to the url
+//	/delay/<seconds> answers the default response after up to 30 seconds
+//	/bytes/<n> answers n random bytes, up to 10 MB
+//	/status/<code> answers the status code
+func (h *HTTPServer) helperHandler(w http.ResponseWriter, req *http.Request) bool {
+	if _, _, correlationID := h.options.findUniqueID(req.Host); correlationID == "" {
+		return false
+	}
+	path := strings.TrimSuffix(req.URL.Path, "/")
+	switch {
+	case path == "/redirect":
+		target, err := url.Parse(req.URL.Query().Get("to"))
+		if err != nil || target.String() == "" {
+			http.Error(w, "invalid redirect target", http.StatusBadRequest)
+			return true
+		}
+		code := http.StatusFound
+		if value := req.URL.Query().Get("code"); value != "" {
+			if code, err = strconv.Atoi(value); err != nil || !redirectCodes[code] {
+				http.Error(w, "invalid redirect code", http.StatusBadRequest)
+				return true
+			}
+		}
+		w.Header().Set("Location", target.String())
+		w.WriteHeader(code)
+		return true
+	case strings.HasPrefix(path, "/delay/"):
+		seconds, err := strconv.ParseFloat(strings.TrimPrefix(path, "/delay/"), 64)
+		if err != nil || seconds < 0 {
+			http.Error(w, "invalid delay", http.StatusBadRequest)
+			return true
+		}
+		delay := time.Duration(seconds * float64(time.Second))
+		if delay > maxHelperDelay {
+			delay = maxHelperDelay
+		}
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+		}
+		return false
+	case strings.HasPrefix(path, "/bytes/"):
+		size, err := strconv.Atoi(strings.TrimPrefix(path, "/bytes/"))
+		if err != nil || size < 0 || size > maxHelperBytes {
+			http.Error(w, "invalid size", http.StatusBadRequest)
+			return true
+		}
+		data := make([]byte, size)
+		_, _ = rand.Read(data)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(data)
+		return true
+	case strings.HasPrefix(path, "/status/"):
+		code, err := strconv.Atoi(strings.TrimPrefix(path, "/status/"))
+		if err != nil || code < 200 || code > 599 {
+			http.Error(w, "invalid status code", http.StatusBadRequest)
+			return true
+		}
+		w.WriteHeader(code)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPServerHelpers(t *testing.T) {
+	options := &Options{Domain: "example.com", Storage: storage.New(time.Hour)}
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+	get := func(host, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.nontlsserver.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://"+host+path, nil))
+		return rec
+	}
+	host := "c23b2la0kl1krjcrdj10cndmnioyyyyyn.example.com"
+
+	rec := get(host, "/redirect?to=http://169.254.169.254/latest/&code=307")
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code, "could not redirect")
+	require.Equal(t, "http://169.254.169.254/latest/", rec.Header().Get("Location"), "invalid redirect location")
+	require.Equal(t, http.StatusFound, get(host, "/redirect?to=/next").Code, "invalid default redirect code")
+	require.Equal(t, http.StatusBadRequest, get(host, "/redirect?to=/next&code=200").Code, "redirected with invalid code")
+
+	require.Len(t, get(host, "/bytes/1000").Body.Bytes(), 1000, "invalid bytes size")
+	require.Equal(t, http.StatusBadRequest, get(host, "/bytes/100000000").Code, "answered bytes larger than the limit")
+	require.Equal(t, http.StatusTeapot, get(host, "/status/418").Code, "invalid status code")
+
+	start := time.Now()
+	rec = get(host, "/delay/0.2")
+	require.True(t, time.Since(start) >= 200*time.Millisecond, "could not delay response")
+	require.Equal(t, http.StatusOK, rec.Code, "could not answer delayed response")
+
+	require.Equal(t, http.StatusOK, get("example.com", "/status/500").Code, "answered helper for uncorrelated host")
+}
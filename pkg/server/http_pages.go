@@ -0,0 +1,74 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+)
+
+// HTTPPages are the pages configured by the operator which the http server
+// serves instead of the default ones, eg. with the contact and disclosure
+// information required on the internet-facing hosts of an organization.
+type HTTPPages struct {
+	// Index is the body of the / page of the non-correlated hosts, {DOMAIN}
+	// being replaced by the domain of the request
+	Index string
+	// SecurityTxt is the body of /.well-known/security.txt and /security.txt
+	SecurityTxt string
+	// RobotsTxt is the body of /robots.txt of the non-correlated hosts
+	RobotsTxt string
+}
+
+// NewHTTPPages returns the pages read from the index, security.txt and
+// robots.txt files, the empty ones keeping the default page.
+func NewHTTPPages(index, securityTxt, robotsTxt string) (*HTTPPages, error) {
+	pages := &HTTPPages{}
+	for _, page := range []struct {
+		file  string
+		value *string
+	}{{index, &pages.Index}, {securityTxt, &pages.SecurityTxt}, {robotsTxt, &pages.RobotsTxt}} {
+		if page.file == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(page.file)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read page")
+		}
+		*page.value = string(data)
+	}
+	// fields required by rfc 9116
+	for _, field := range []string{"Contact:", "Expires:"} {
+		if pages.SecurityTxt != "" && !strings.Contains(pages.SecurityTxt, field) {
+			gologger.Warning().Msgf("The security.txt file has no %s field\n", strings.TrimSuffix(field, ":"))
+		}
+	}
+	return pages, nil
+}
+
+// serve serves the configured page of a request, returning true if the
+// request has been answered
+func (p *HTTPPages) serve(w http.ResponseWriter, req *http.Request, domain string, correlated bool) bool {
+	if p == nil {
+		return false
+	}
+	path := strings.ToLower(req.URL.Path)
+	switch {
+	case p.SecurityTxt != "" && (path == "/.well-known/security.txt" || path == "/security.txt"):
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(p.SecurityTxt))
+	case correlated:
+		return false
+	case p.RobotsTxt != "" && path == "/robots.txt":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(p.RobotsTxt))
+	case p.Index != "" && path == "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(strings.ReplaceAll(p.Index, "{DOMAIN}", domain)))
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPServerPages(t *testing.T) {
+	directory := t.TempDir()
+	index, securityTxt := filepath.Join(directory, "index.html"), filepath.Join(directory, "security.txt")
+	require.Nil(t, ioutil.WriteFile(index, []byte("<h1>{DOMAIN} research server</h1>"), 0600), "could not write index")
+	require.Nil(t, ioutil.WriteFile(securityTxt, []byte("Contact: mailto:security@example.com\nExpires: 2030-01-01T00:00:00.000Z\n"), 0600), "could not write security.txt")
+	pages, err := NewHTTPPages(index, securityTxt, "")
+	require.Nil(t, err, "could not load pages")
+	_, err = NewHTTPPages(filepath.Join(directory, "missing.html"), "", "")
+	require.NotNil(t, err, "loaded missing page")
+
+	server, err := NewHTTPServer(&Options{Domain: "example.com", Storage: storage.New(time.Hour), HTTPPages: pages})
+	require.Nil(t, err, "could not create http server")
+	get := func(host, path string) string {
+		rec := httptest.NewRecorder()
+		server.nontlsserver.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://"+host+path, nil))
+		return rec.Body.String()
+	}
+	host := "c23b2la0kl1krjcrdj10cndmnioyyyyyn.example.com"
+
+	require.Equal(t, "<h1>example.com research server</h1>", get("example.com", "/"), "invalid index")
+	require.Contains(t, get("example.com", "/.well-known/security.txt"), "Contact: mailto:security@example.com", "invalid security.txt")
+	require.Contains(t, get(host, "/security.txt"), "Contact: mailto:security@example.com", "invalid security.txt of correlated host")
+	require.Contains(t, get("example.com", "/robots.txt"), "Disallow: /", "invalid default robots.txt")
+	require.Contains(t, get(host, "/"), "nyyyyy", "invalid index of correlated host")
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// rawHTTPRecorder records the first bytes of the connections accepted by
+// the http servers, reporting the ones closed without any request being
+// parsed (smuggling probes, other protocols on the http ports).
+type rawHTTPRecorder struct {
+	maxSize int
+	tls     bool
+	record  func(localAddr, remoteAddr net.Addr, data []byte, truncated bool)
+
+	served sync.Map
+}
+
+// Listener wraps a listener recording the first bytes of accepted connections.
+func (r *rawHTTPRecorder) Listener(listener net.Listener) net.Listener {
+	if r == nil {
+		return listener
+	}
+	return &rawHTTPListener{Listener: listener, recorder: r}
+}
+
+// Handler wraps a handler marking the connections of the parsed requests.
+func (r *rawHTTPRecorder) Handler(handler http.Handler) http.Handler {
+	if r == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.served.Store(req.RemoteAddr, struct{}{})
+		handler.ServeHTTP(w, req)
+	})
+}
+
+type rawHTTPListener struct {
+	net.Listener
+	recorder *rawHTTPRecorder
+}
+
+func (l *rawHTTPListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &rawHTTPConn{Conn: conn, recorder: l.recorder}, nil
+}
+
+// rawHTTPConn buffers the first bytes read from the connection
+type rawHTTPConn struct {
+	net.Conn
+	recorder *rawHTTPRecorder
+
+	mutex     sync.Mutex
+	data      []byte
+	truncated bool
+	closeOnce sync.Once
+}
+
+func (c *rawHTTPConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if remaining := c.recorder.maxSize - len(c.data); remaining >= n {
+			c.data = append(c.data, b[:n]...)
+		} else {
+			c.data, c.truncated = append(c.data, b[:remaining]...), true
+		}
+	}
+	return n, err
+}
+
+func (c *rawHTTPConn) Close() error {
+	c.closeOnce.Do(func() {
+		remoteAddr := c.Conn.RemoteAddr().String()
+		if _, served := c.recorder.served.Load(remoteAddr); served {
+			c.recorder.served.Delete(remoteAddr)
+			return
+		}
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		// the requests sent over tls can't be recovered from the encrypted records
+		if len(c.data) == 0 || c.recorder.tls && c.data[0] == tlsRecordTypeHandshake {
+			return
+		}
+		go c.recorder.record(c.Conn.LocalAddr(), c.Conn.RemoteAddr(), c.data, c.truncated)
+	})
+	return c.Conn.Close()
+}
+
+// recordRawData stores the bytes of a connection which couldn't be parsed as
+// http for the session of the interaction id they contain, or for the wildcard
+// log or the token holders otherwise.
+func (h *HTTPServer) recordRawData(localAddr, remoteAddr net.Addr, data []byte, truncated bool) {
+	var message strings.Builder
+	message.WriteString(fmt.Sprintf("LocalAddress=%s\n", localAddr))
+	message.WriteString(fmt.Sprintf("Length=%d\n", len(data)))
+	if truncated {
+		message.WriteString("Truncated=true\n")
+	}
+	message.WriteString("\n")
+	message.WriteString(hex.Dump(data))
+
+	host, _, _ := net.SplitHostPort(remoteAddr.String())
+	interaction := &Interaction{
+		Protocol:      "http-raw",
+		ProtocolGuess: guessProtocol(data),
+		RawRequest:    message.String(),
+		RemoteAddress: host,
+		Timestamp:     time.Now(),
+	}
+	if uniqueID, fullID, correlationID := h.options.findInteractionID(string(data)); uniqueID != "" {
+		interaction.UniqueID = uniqueID
+		interaction.FullId = fullID
+		h.options.storeInteraction(correlationID, interaction)
+	} else if h.options.WildcardLog {
+		h.options.storeWildcardInteraction(interaction)
+	} else if token := h.options.GetToken(); token != "" {
+		h.options.storeInteractionWithId(token, interaction)
+	}
+}
+
+// httpMethods are the methods starting the http requests
+var httpMethods = []string{"GET ", "POST ", "PUT ", "HEAD ", "DELETE ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE ", "PROPFIND "}
+
+// guessProtocol returns the protocol the first bytes of a connection most
+// likely belong to
+func guessProtocol(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("PRI * HTTP/2.0")):
+		return "http2"
+	case isTLSClientHello(data):
+		return "tls"
+	case bytes.HasPrefix(data, []byte("SSH-")):
+		return "ssh"
+	case len(data) > 8 && (bytes.Equal(data[4:8], []byte("\xffSMB")) || bytes.Equal(data[4:8], []byte("\xfeSMB"))):
+		return "smb"
+	case len(data) > 4 && data[0] == 3 && data[1] == 0 && data[4] > 0:
+		return "rdp"
+	case len(data) > 2 && (data[0] == 4 || data[0] == 5) && data[1] > 0 && data[1] < 16:
+		return "socks"
+	case bytes.HasPrefix(data, []byte("*")) && len(data) > 1 && data[1] >= '0' && data[1] <= '9':
+		return "redis"
+	}
+	upper := strings.ToUpper(string(data[:minInt(len(data), 16)]))
+	for _, method := range httpMethods {
+		if strings.HasPrefix(upper, method) {
+			return "http"
+		}
+	}
+	if strings.HasPrefix(upper, "HELO") || strings.HasPrefix(upper, "EHLO") {
+		return "smtp"
+	}
+	for _, r := range string(data) {
+		if r == unicode.ReplacementChar || !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return "binary"
+		}
+	}
+	return "text"
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
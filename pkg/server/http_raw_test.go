@@ -0,0 +1,63 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPServerRawData(t *testing.T) {
+	store := storage.New(time.Hour)
+	require.Nil(t, store.SetID("token"), "could not set token")
+	options := &Options{Domain: "example.com", Storage: store, Auth: true, Token: "token", HTTPRawBytes: 16}
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "could not listen")
+	defer listener.Close()
+	go func() { _ = server.nontlsserver.Serve(server.raw.Listener(listener)) }()
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/")
+	require.Nil(t, err, "could not send http request")
+	_, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.Nil(t, err, "could not connect")
+	_, err = conn.Write([]byte("SSH-2.0-OpenSSH_8.9p1 Ubuntu-3\r\n"))
+	require.Nil(t, err, "could not write data")
+	_, _ = ioutil.ReadAll(conn)
+	conn.Close()
+
+	var data []string
+	require.Eventually(t, func() bool {
+		data, _ = store.GetInteractionsWithId("token")
+		return len(data) > 0
+	}, time.Second, 10*time.Millisecond, "could not record raw data")
+	require.Len(t, data, 1, "recorded parsed request")
+	require.Contains(t, data[0], `"protocol":"http-raw"`, "invalid protocol")
+	require.Contains(t, data[0], `"protocol-guess":"ssh"`, "could not guess protocol")
+	require.Contains(t, data[0], `Truncated=true`, "could not truncate raw data")
+}
+
+func TestGuessProtocol(t *testing.T) {
+	for data, protocol := range map[string]string{
+		"PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n":         "http2",
+		"\x16\x03\x01\x00\xa5\x01\x00\x00\xa1\x03": "tls",
+		"\x00\x00\x00\x85\xffSMBr\x00\x00":         "smb",
+		"\x03\x00\x00\x13\x0e\xe0\x00\x00":         "rdp",
+		"\x05\x01\x00":                             "socks",
+		"*1\r\n$4\r\nPING\r\n":                     "redis",
+		"GET /\x00 HTTP/1.1\r\n":                   "http",
+		"EHLO example.com\r\n":                     "smtp",
+		"hello\n":                                  "text",
+		"\x00\x01\x02":                             "binary",
+	} {
+		require.Equal(t, protocol, guessProtocol([]byte(data)), "invalid guess for %q", data)
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxHTTPResponses is the maximum number of http responses of a session
+	maxHTTPResponses = 16
+	// maxHTTPResponseBodySize is the maximum size in bytes of the body of an http response of a session
+	maxHTTPResponseBodySize = 64 * 1024
+)
+
+// HTTPResponse is a response served by the http server for the requests to
+// the names of a session matching its path instead of the default one, eg.
+// to host an xxe dtd, an open redirect or a second stage payload.
+type HTTPResponse struct {
+	// Path is the path of the requests served, every path if empty and
+	// the paths starting with its prefix if it ends with *
+	Path string `json:"path,omitempty" yaml:"path"`
+	// Status is the status code of the response, 200 if zero
+	Status int `json:"status,omitempty" yaml:"status"`
+	// Headers are the headers of the response
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers"`
+	// Body is the body of the response
+	Body string `json:"body,omitempty" yaml:"body"`
+}
+
+// HTTPResponses are the http responses of a session, the first one matching
+// the path of a request being served.
+type HTTPResponses []*HTTPResponse
+
+// Validate returns an error if the responses can't be served by the http server.
+func (r HTTPResponses) Validate() error {
+	if len(r) > maxHTTPResponses {
+		return errors.Errorf("http responses are limited to %d per session", maxHTTPResponses)
+	}
+	for _, response := range r {
+		if response == nil {
+			return errors.New("empty http response")
+		}
+		if response.Path != "" && !strings.HasPrefix(response.Path, "/") {
+			return errors.Errorf("invalid path %s: must start with /", response.Path)
+		}
+		if response.Status != 0 && (response.Status < 100 || response.Status > 599) {
+			return errors.Errorf("invalid status code %d", response.Status)
+		}
+		if len(response.Body) > maxHTTPResponseBodySize {
+			return errors.Errorf("http response bodies are limited to %d bytes", maxHTTPResponseBodySize)
+		}
+		for name, value := range response.Headers {
+			if name == "" || strings.ContainsAny(name, " :\r\n") || strings.ContainsAny(value, "\r\n") {
+				return errors.Errorf("invalid header %s", name)
+			}
+			if strings.EqualFold(name, "Content-Length") || strings.EqualFold(name, "Transfer-Encoding") {
+				return errors.Errorf("header %s is set by the server", name)
+			}
+		}
+	}
+	return nil
+}
+
+// match returns the first response matching a path, nil if none does
+func (r HTTPResponses) match(path string) *HTTPResponse {
+	for _, response := range r {
+		if response.Path == "" || response.Path == path || strings.HasSuffix(response.Path, "*") && strings.HasPrefix(path, strings.TrimSuffix(response.Path, "*")) {
+			return response
+		}
+	}
+	return nil
+}
+
+// write writes the response
+func (response *HTTPResponse) write(w http.ResponseWriter) {
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+	status := response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(response.Body))
+}
+
+// SessionResponses are the http responses set by the clients for their sessions.
+type SessionResponses struct {
+	alive func(correlationID string) bool
+
+	sync.Mutex
+	sessions map[string]HTTPResponses
+}
+
+// NewSessionResponses returns new session responses, alive returning false for
+// the sessions which are no longer registered.
+func NewSessionResponses(alive func(correlationID string) bool) *SessionResponses {
+	return &SessionResponses{alive: alive, sessions: make(map[string]HTTPResponses)}
+}
+
+// Set sets the responses of a session, removing them if empty.
+func (s *SessionResponses) Set(correlationID string, responses HTTPResponses) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if len(responses) == 0 {
+		delete(s.sessions, correlationID)
+		return
+	}
+	if _, ok := s.sessions[correlationID]; !ok && len(s.sessions) >= maxAccountedSessions {
+		s.prune()
+	}
+	s.sessions[correlationID] = responses
+}
+
+// Get returns the responses of a registered session, nil if it didn't set any.
+func (s *SessionResponses) Get(correlationID string) HTTPResponses {
+	if s == nil {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	responses, ok := s.sessions[correlationID]
+	if !ok || !s.alive(correlationID) {
+		return nil
+	}
+	return responses
+}
+
+// Remove removes the responses of a deregistered session.
+func (s *SessionResponses) Remove(correlationID string) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	delete(s.sessions, correlationID)
+	s.Unlock()
+}
+
+// prune removes the sessions which are no longer registered
+func (s *SessionResponses) prune() {
+	for correlationID := range s.sessions {
+		if !s.alive(correlationID) {
+			delete(s.sessions, correlationID)
+		}
+	}
+}
+
+// sessionResponse returns the response set by the session of a request for its path, if any
+func (options *Options) sessionResponse(req *http.Request) *HTTPResponse {
+	if options.SessionResponses == nil {
+		return nil
+	}
+	_, _, correlationID := options.findUniqueID(req.Host)
+	if correlationID == "" {
+		return nil
+	}
+	return options.SessionResponses.Get(correlationID).match(req.URL.Path)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionResponses(t *testing.T) {
+	encoded := encodedTestPublicKey(t)
+
+	store := storage.New(time.Hour)
+	options := &Options{Domain: "example.com", Storage: store, SessionResponses: NewSessionResponses(store.HasID)}
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+	post := func(path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.nontlsserver.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://example.com"+path, strings.NewReader(body)))
+		return rec
+	}
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.nontlsserver.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+
+	rec := post("/register", `{"public-key":"`+encoded+`","secret-key":"secret","correlation-id":"c23b2la0kl1krjcrdj10","http-responses":[`+
+		`{"path":"/evil.dtd","headers":{"Content-Type":"application/xml-dtd"},"body":"<!ENTITY % data SYSTEM \"file:///etc/passwd\">"},`+
+		`{"path":"/r/*","status":302,"headers":{"Location":"http://169.254.169.254/"}}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, "could not register with http responses")
+
+	rec = get("http://c23b2la0kl1krjcrdj10cndmnioyyyyyn.example.com/evil.dtd")
+	require.Equal(t, http.StatusOK, rec.Code, "could not serve response")
+	require.Equal(t, "application/xml-dtd", rec.Header().Get("Content-Type"), "could not set header")
+	require.Equal(t, `<!ENTITY % data SYSTEM "file:///etc/passwd">`, rec.Body.String(), "could not serve body")
+	rec = get("http://c23b2la0kl1krjcrdj10cndmnioyyyyyn.example.com/r/next")
+	require.Equal(t, http.StatusFound, rec.Code, "could not match path prefix")
+	require.Equal(t, "http://169.254.169.254/", rec.Header().Get("Location"), "could not set location")
+	require.Contains(t, get("http://c23b2la0kl1krjcrdj10cndmnioyyyyyn.example.com/other").Body.String(), "<html>", "could not serve default response")
+	require.Contains(t, get("http://c6rj61aciaeutn2ae680cg5ugboyyyyyn.example.com/evil.dtd").Body.String(), "<html>", "served response of another session")
+
+	rec = post("/http-responses", `{"correlation-id":"c23b2la0kl1krjcrdj10","secret-key":"secret","http-responses":[{"path":"relative"}]}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code, "set invalid http responses")
+	rec = post("/http-responses", `{"correlation-id":"c23b2la0kl1krjcrdj10","secret-key":"wrong","http-responses":[{"body":"x"}]}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code, "set http responses with wrong secret")
+	rec = post("/http-responses", `{"correlation-id":"c23b2la0kl1krjcrdj10","secret-key":"secret"}`)
+	require.Equal(t, http.StatusOK, rec.Code, "could not remove http responses")
+	require.Contains(t, get("http://c23b2la0kl1krjcrdj10cndmnioyyyyyn.example.com/evil.dtd").Body.String(), "<html>", "could not remove http responses")
+
+	require.NotNil(t, HTTPResponses{{Headers: map[string]string{"Content-Length": "1"}}}.Validate(), "validated content-length header")
+	require.NotNil(t, HTTPResponses{{Status: 1000}}.Validate(), "validated invalid status")
+}
@@ -1,19 +1,24 @@
 package server
 
 import (
-	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/projectdiscovery/gologger"
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // HTTPServer is a http server instance that listens both
@@ -21,6 +26,12 @@ import (
 type HTTPServer struct {
 	options      *Options
 	domain       string
+	proxy        *http.ServeMux
+	clientHellos *clientHelloRecorder
+	packets      *packetRecorder
+	raw          *rawHTTPRecorder
+	tlsRaw       *rawHTTPRecorder
+	tlsConns     *tlsConnRecorder
 	tlsserver    http.Server
 	nontlsserver http.Server
 }
@@ -35,50 +46,145 @@ func (l *noopLogger) Write(p []byte) (n int, err error) {
 // NewHTTPServer returns a new TLS & Non-TLS HTTP server.
 func NewHTTPServer(options *Options) (*HTTPServer, error) {
 	server := &HTTPServer{options: options, domain: strings.TrimSuffix(options.Domain, ".")}
+	if options.PCAP != nil {
+		server.packets = &packetRecorder{}
+	}
+	if options.HTTPRawBytes > 0 {
+		server.raw = &rawHTTPRecorder{maxSize: options.HTTPRawBytes, record: server.recordRawData}
+		server.tlsRaw = &rawHTTPRecorder{maxSize: options.HTTPRawBytes, tls: true, record: server.recordRawData}
+	}
+	if options.TLSLog {
+		server.tlsConns = &tlsConnRecorder{record: server.recordTLSConnection}
+	}
+	if options.MaxSessionsPerIP > 0 && options.sessionLimiter == nil {
+		options.sessionLimiter = newSessionLimiter(options.MaxSessionsPerIP, options.Storage.HasID)
+	}
 
 	router := &http.ServeMux{}
-	router.Handle("/", server.logger(http.HandlerFunc(server.defaultHandler)))
-	router.Handle("/register", server.corsMiddleware(server.authMiddleware(http.HandlerFunc(server.registerHandler))))
-	router.Handle("/deregister", server.corsMiddleware(server.authMiddleware(http.HandlerFunc(server.deregisterHandler))))
-	router.Handle("/poll", server.corsMiddleware(server.authMiddleware(http.HandlerFunc(server.pollHandler))))
-	router.Handle("/metrics", server.corsMiddleware(server.authMiddleware(http.HandlerFunc(server.metricsHandler))))
-	server.tlsserver = http.Server{Addr: options.ListenIP + fmt.Sprintf(":%d", options.HttpsPort), Handler: router, ErrorLog: log.New(&noopLogger{}, "", 0)}
-	server.nontlsserver = http.Server{Addr: options.ListenIP + fmt.Sprintf(":%d", options.HttpPort), Handler: router, ErrorLog: log.New(&noopLogger{}, "", 0)}
+	router.Handle("/", server.logger(http.HandlerFunc(server.rootHandler)))
+	router.Handle("/register", server.corsMiddleware(options.auditMiddleware("register", server.filterMiddleware(server.authMiddleware(ScopeRegister, http.HandlerFunc(server.registerHandler))))))
+	router.Handle("/deregister", server.corsMiddleware(options.auditMiddleware("deregister", server.filterMiddleware(server.authMiddleware(ScopeDeregister, http.HandlerFunc(server.deregisterHandler))))))
+	router.Handle("/poll", server.corsMiddleware(options.auditMiddleware("poll", server.filterMiddleware(server.authMiddleware(ScopePoll, http.HandlerFunc(server.pollHandler))))))
+	router.Handle("/dns-answers", server.corsMiddleware(options.auditMiddleware("dns-answers", server.filterMiddleware(server.authMiddleware(ScopeRegister, http.HandlerFunc(server.dnsAnswersHandler))))))
+	router.Handle("/metrics", server.corsMiddleware(server.filterMiddleware(server.authMiddleware("", http.HandlerFunc(server.metricsHandler)))))
+	if options.OIDC != nil && options.Tokens != nil {
+		router.Handle("/oidc/token", server.corsMiddleware(options.auditMiddleware("oidc-token", server.filterMiddleware(http.HandlerFunc(server.oidcTokenHandler)))))
+	}
+	if options.LongPolls != nil {
+		router.Handle("/stream", server.corsMiddleware(options.auditMiddleware("stream", server.filterMiddleware(streamTokenMiddleware(server.authMiddleware(ScopePoll, http.HandlerFunc(server.streamHandler)))))))
+	}
+	if options.Quarantine != nil {
+		router.Handle("/quarantine", server.corsMiddleware(options.auditMiddleware("quarantine", server.filterMiddleware(server.authMiddleware(ScopePoll, http.HandlerFunc(server.quarantineHandler))))))
+	}
+	if options.HostedFiles != nil {
+		// only the uploads and removals of the hosted files are audited, not their fetches
+		hostedFiles := options.auditMiddleware("hosted-file", http.HandlerFunc(server.hostedFilesHandler))
+		router.Handle("/s/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodPut || req.Method == http.MethodDelete {
+				hostedFiles.ServeHTTP(w, req)
+				return
+			}
+			server.hostedFilesHandler(w, req)
+		}))
+	}
+	if options.SessionResponses != nil {
+		router.Handle("/http-responses", server.corsMiddleware(options.auditMiddleware("http-responses", server.filterMiddleware(server.authMiddleware(ScopeRegister, http.HandlerFunc(server.httpResponsesHandler))))))
+	}
+	if options.Cluster != nil {
+		router.Handle("/cluster", http.HandlerFunc(server.clusterHandler))
+	}
+	if options.ResolverStats != nil {
+		router.Handle("/resolvers", server.corsMiddleware(server.filterMiddleware(server.authMiddleware("", http.HandlerFunc(server.resolversHandler)))))
+	}
+	if len(options.ProxyRoutes) > 0 {
+		server.proxy = &http.ServeMux{}
+		for path, origin := range options.ProxyRoutes {
+			if _, pattern := router.Handler(&http.Request{Method: http.MethodGet, URL: &url.URL{Path: path}}); pattern != "/" {
+				return nil, fmt.Errorf("proxy route %s conflicts with %s", path, pattern)
+			}
+			proxy := httputil.NewSingleHostReverseProxy(origin)
+			proxy.ErrorLog = log.New(&noopLogger{}, "", 0)
+			server.proxy.Handle(path, proxy)
+		}
+	}
+	server.tlsserver = http.Server{Addr: options.ListenIP + fmt.Sprintf(":%d", options.HttpsPort), Handler: server.tlsRaw.Handler(server.tlsConns.Handler(router)), ErrorLog: log.New(&noopLogger{}, "", 0)}
+	server.nontlsserver = http.Server{Addr: options.ListenIP + fmt.Sprintf(":%d", options.HttpPort), Handler: server.raw.Handler(router), ErrorLog: log.New(&noopLogger{}, "", 0)}
 	return server, nil
 }
 
 // ListenAndServe listens on http and/or https ports for the server.
 func (h *HTTPServer) ListenAndServe(tlsConfig *tls.Config, httpAlive, httpsAlive chan bool) {
 	go func() {
-		if tlsConfig == nil {
+		if tlsConfig == nil || h.options.HttpsPort == 0 {
 			return
 		}
-		h.tlsserver.TLSConfig = tlsConfig
+		h.tlsserver.TLSConfig = h.serverTLSConfig(tlsConfig)
+		if err := h.configureHTTP2(); err != nil {
+			gologger.Warning().Msgf("Could not enable http2 on tls, only http/1.1 will be served: %s\n", err)
+		}
 
+		listener, err := net.Listen("tcp", h.tlsserver.Addr)
+		if err != nil {
+			gologger.Error().Msgf("Could not serve http on tls: %s\n", err)
+			httpsAlive <- false
+			return
+		}
+		h.clientHellos = &clientHelloRecorder{conns: h.tlsConns}
 		httpsAlive <- true
-		if err := h.tlsserver.ListenAndServeTLS("", ""); err != nil {
+		if err := h.tlsserver.ServeTLS(h.clientHellos.Listener(h.tlsRaw.Listener(h.packets.Listener(listener))), "", ""); err != nil {
 			gologger.Error().Msgf("Could not serve http on tls: %s\n", err)
 			httpsAlive <- false
 		}
 	}()
 
+	if h.options.HttpPort == 0 {
+		return
+	}
+	listener, err := net.Listen("tcp", h.nontlsserver.Addr)
+	if err != nil {
+		httpAlive <- false
+		gologger.Error().Msgf("Could not serve http: %s\n", err)
+		return
+	}
 	httpAlive <- true
-	if err := h.nontlsserver.ListenAndServe(); err != nil {
+	if err := h.nontlsserver.Serve(h.raw.Listener(h.packets.Listener(listener))); err != nil {
 		httpAlive <- false
 		gologger.Error().Msgf("Could not serve http: %s\n", err)
 	}
 }
 
+// serverTLSConfig returns the tls config of the https listener, requesting
+// the client certificates if mutual tls is enabled. The certificates are
+// only required by the client endpoints, not the interactions.
+func (h *HTTPServer) serverTLSConfig(tlsConfig *tls.Config) *tls.Config {
+	config := tlsConfig.Clone()
+	if h.options.ClientCAs != nil {
+		config.ClientCAs = h.options.ClientCAs
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config
+}
+
 func (h *HTTPServer) logger(handler http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		req, _ := httputil.DumpRequest(r, true)
-		reqString := string(req)
+		var reqString string
+		var body *HTTPBody
+		if _, ok := h.proxyHandler(r); ok {
+			// the bodies of the proxied requests are forwarded whole to their origin
+			req, _ := httputil.DumpRequest(r, true)
+			reqString = string(req)
+		} else {
+			reqString, body = h.dumpRequest(r)
+		}
+		// claimed once the body has been read to record all the frames of the stream
+		stream := http2Stream(r)
 
 		gologger.Debug().Msgf("New HTTP request: %s\n", reqString)
 		rec := httptest.NewRecorder()
 		handler.ServeHTTP(rec, r)
 
-		resp, _ := httputil.DumpResponse(rec.Result(), true)
+		// the large bodies (eg. of the /bytes helper) aren't recorded
+		resp, _ := httputil.DumpResponse(rec.Result(), rec.Body.Len() <= maxRawResponseBody)
 		resoString := string(resp)
 
 		for k, v := range rec.Header() {
@@ -89,68 +195,108 @@ func (h *HTTPServer) logger(handler http.Handler) http.HandlerFunc {
 		w.WriteHeader(rec.Result().StatusCode)
 		_, _ = w.Write(data)
 
+		var clientHello *ClientHello
+		if r.TLS != nil {
+			clientHello = h.clientHellos.Get(r.RemoteAddr)
+		}
+		capture := h.packets.Get(r.RemoteAddr)
+		hostMismatch := tlsHostMismatch(r, clientHello)
+
 		// if root-tld is enabled stores any interaction towards the main domain
-		if h.options.RootTLD && strings.HasSuffix(r.Host, h.domain) {
+		_, isDomain := h.options.domainOf(stripPort(r.Host))
+		if h.options.RootTLD && isDomain {
 			ID := h.domain
 			host, _, _ := net.SplitHostPort(r.RemoteAddr)
 			interaction := &Interaction{
-				Protocol:      "http",
-				UniqueID:      r.Host,
-				FullId:        r.Host,
-				RawRequest:    reqString,
-				RawResponse:   resoString,
-				RemoteAddress: host,
-				Timestamp:     time.Now(),
-			}
-			buffer := &bytes.Buffer{}
-			if err := jsoniter.NewEncoder(buffer).Encode(interaction); err != nil {
-				gologger.Warning().Msgf("Could not encode root tld http interaction: %s\n", err)
-			} else {
-				gologger.Debug().Msgf("Root TLD HTTP Interaction: \n%s\n", buffer.String())
-				if err := h.options.Storage.AddInteractionWithId(ID, buffer.Bytes()); err != nil {
-					gologger.Warning().Msgf("Could not store root tld http interaction: %s\n", err)
-				}
+				Protocol:        "http",
+				UniqueID:        r.Host,
+				FullId:          r.Host,
+				RawRequest:      reqString,
+				RawResponse:     resoString,
+				RemoteAddress:   host,
+				Timestamp:       time.Now(),
+				TLSClientHello:  clientHello,
+				HTTP2:           stream,
+				TLSHostMismatch: hostMismatch,
+				HTTPBody:        body,
+				capture:         capture,
 			}
+			h.options.storeInteractionWithId(ID, interaction)
 		}
 
-		var uniqueID, fullID string
-		parts := strings.Split(r.Host, ".")
-		for i, part := range parts {
-			if len(part) == 33 {
-				uniqueID = part
-				fullID = part
-				if i+1 <= len(parts) {
-					fullID = strings.Join(parts[:i+1], ".")
-				}
-			}
+		uniqueID, fullID, correlationID := h.options.findUniqueID(r.Host)
+		// payloads using the ip literals of the server carry the id in the path
+		if uniqueID == "" {
+			uniqueID, fullID, correlationID = h.options.findInteractionID(r.URL.Path)
+		}
+		// and the fronted requests in the sni of their connection
+		if uniqueID == "" && clientHello != nil {
+			uniqueID, fullID, correlationID = h.options.findUniqueID(clientHello.ServerName)
 		}
 		if uniqueID != "" {
-			correlationID := uniqueID[:20]
-
 			host, _, _ := net.SplitHostPort(r.RemoteAddr)
 			interaction := &Interaction{
-				Protocol:      "http",
-				UniqueID:      uniqueID,
-				FullId:        fullID,
-				RawRequest:    reqString,
-				RawResponse:   resoString,
-				RemoteAddress: host,
-				Timestamp:     time.Now(),
+				Protocol:        "http",
+				UniqueID:        uniqueID,
+				FullId:          fullID,
+				RawRequest:      reqString,
+				RawResponse:     resoString,
+				RemoteAddress:   host,
+				Timestamp:       time.Now(),
+				TLSClientHello:  clientHello,
+				HTTP2:           stream,
+				TLSHostMismatch: hostMismatch,
+				HTTPAuth:        h.options.httpCredential(correlationID, r),
+				HTTPBody:        body,
+				capture:         capture,
 			}
-			buffer := &bytes.Buffer{}
-			if err := jsoniter.NewEncoder(buffer).Encode(interaction); err != nil {
-				gologger.Warning().Msgf("Could not encode http interaction: %s\n", err)
-			} else {
-				gologger.Debug().Msgf("HTTP Interaction: \n%s\n", buffer.String())
-
-				if err := h.options.Storage.AddInteraction(correlationID, buffer.Bytes()); err != nil {
-					gologger.Warning().Msgf("Could not store http interaction: %s\n", err)
-				}
+			h.options.storeInteraction(correlationID, interaction)
+		} else if _, ok := h.proxyHandler(r); ok {
+			// every proxied request is recorded for the token
+			host, _, _ := net.SplitHostPort(r.RemoteAddr)
+			interaction := &Interaction{
+				Protocol:        "http",
+				UniqueID:        r.Host,
+				FullId:          r.Host + r.URL.Path,
+				RawRequest:      reqString,
+				RawResponse:     resoString,
+				RemoteAddress:   host,
+				Timestamp:       time.Now(),
+				TLSClientHello:  clientHello,
+				HTTP2:           stream,
+				TLSHostMismatch: hostMismatch,
+				capture:         capture,
 			}
+			h.options.storeInteractionWithId(h.options.GetToken(), interaction)
+		} else if h.options.WildcardLog && !(h.options.RootTLD && isDomain) {
+			host, _, _ := net.SplitHostPort(r.RemoteAddr)
+			interaction := &Interaction{
+				Protocol:        "http",
+				UniqueID:        r.Host,
+				FullId:          r.Host + r.URL.Path,
+				RawRequest:      reqString,
+				RawResponse:     resoString,
+				RemoteAddress:   host,
+				Timestamp:       time.Now(),
+				TLSClientHello:  clientHello,
+				HTTP2:           stream,
+				TLSHostMismatch: hostMismatch,
+				HTTPBody:        body,
+				capture:         capture,
+			}
+			h.options.storeWildcardInteraction(interaction)
 		}
 	}
 }
 
+// stripPort returns the host of a host:port request host
+func stripPort(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		return hostname
+	}
+	return host
+}
+
 const banner = `<h1> Interactsh Server </h1>
 
 <a href='https://github.com/projectdiscovery/interactsh'><b>Interactsh</b></a> is an open-source tool for detecting out-of-band interactions. It is a tool designed to detect vulnerabilities that cause external interactions.<br><br>
@@ -160,13 +306,49 @@ If you notice any interactions from <b>*.%s</b> in your logs, it's possible that
 You should investigate the sites where these interactions were generated from, and if a vulnerability exists, examine the root cause and take the necessary steps to mitigate the issue.
 `
 
+// rootHandler proxies the requests of the proxy routes to their origin,
+// handling the other requests as collaborator requests.
+func (h *HTTPServer) rootHandler(w http.ResponseWriter, req *http.Request) {
+	if handler, ok := h.proxyHandler(req); ok {
+		handler.ServeHTTP(w, req)
+		return
+	}
+	h.defaultHandler(w, req)
+}
+
+// proxyHandler returns the reverse proxy of the route matching the request, if any
+func (h *HTTPServer) proxyHandler(req *http.Request) (http.Handler, bool) {
+	if h.proxy == nil {
+		return nil, false
+	}
+	handler, pattern := h.proxy.Handler(req)
+	return handler, pattern != ""
+}
+
 // defaultHandler is a handler for default collaborator requests
 func (h *HTTPServer) defaultHandler(w http.ResponseWriter, req *http.Request) {
 	reflection := URLReflection(req.Host)
-	w.Header().Set("Server", h.domain)
+	domain, ok := h.options.domainOf(stripPort(req.Host))
+	if !ok {
+		domain = h.domain
+	}
+	w.Header().Set("Server", domain)
+	if challenge := h.options.sessionChallenge(req); challenge != "" && !h.challenge(w, req, challenge, domain) {
+		return
+	}
+	if response := h.options.sessionResponse(req); response != nil {
+		response.write(w)
+		return
+	}
+	if h.helperHandler(w, req) {
+		return
+	}
+	if h.options.HTTPPages.serve(w, req, domain, reflection != "") {
+		return
+	}
 
 	if req.URL.Path == "/" && reflection == "" {
-		fmt.Fprintf(w, banner, h.domain)
+		fmt.Fprintf(w, banner, domain)
 	} else if strings.EqualFold(req.URL.Path, "/robots.txt") {
 		fmt.Fprintf(w, "User-agent: *\nDisallow: / # %s", reflection)
 	} else if strings.HasSuffix(req.URL.Path, ".json") {
@@ -188,23 +370,150 @@ type RegisterRequest struct {
 	SecretKey string `json:"secret-key"`
 	// CorrelationID is an ID for correlation with requests.
 	CorrelationID string `json:"correlation-id"`
+	// IDScheme is the scheme of the correlation ID (xid by default).
+	IDScheme string `json:"id-scheme,omitempty"`
+	// IDFormat is the format of the correlation ID of the random scheme, checked against the server one.
+	IDFormat *IDFormat `json:"id-format,omitempty"`
+	// DNSAnswers are the records answered for the names of the correlation ID.
+	DNSAnswers *DNSAnswers `json:"dns-answers,omitempty"`
+	// HTTPChallenge is the authentication challenge answered to the http requests of the correlation ID, if any.
+	HTTPChallenge string `json:"http-challenge,omitempty"`
+	// HTTPResponses are the responses served for the http requests to the names of the correlation ID.
+	HTTPResponses HTTPResponses `json:"http-responses,omitempty"`
+	// TTL is the number of seconds the correlation ID is kept for, bounded by the
+	// maximum of the server (the eviction ttl of the server if 0).
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// RegisterResponse is the response of a successful client registration.
+type RegisterResponse struct {
+	// Message is the registration status message.
+	Message string `json:"message"`
+	// IDScheme is the scheme of the correlation ID accepted by the server.
+	IDScheme string `json:"id-scheme"`
+	// ExpiresAt is the time the session is evicted at by the server
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 // registerHandler is a handler for client register requests
 func (h *HTTPServer) registerHandler(w http.ResponseWriter, req *http.Request) {
+	ctx, span := tracer.Start(req.Context(), "http.register")
+	defer span.End()
+
 	r := &RegisterRequest{}
 	if err := jsoniter.NewDecoder(req.Body).Decode(r); err != nil {
+		setSpanError(span, err)
 		gologger.Warning().Msgf("Could not decode json body: %s\n", err)
 		jsonError(w, fmt.Sprintf("could not decode json body: %s", err), http.StatusBadRequest)
 		return
 	}
+	if r.IDScheme == IDSchemeRandom && r.IDFormat != nil && *r.IDFormat != h.options.idFormat() {
+		format := h.options.idFormat()
+		jsonError(w, fmt.Sprintf("could not register: id format doesn't match the server one (length %d, nonce length %d, alphabet %s)", format.Length, format.NonceLength, format.Alphabet), http.StatusBadRequest)
+		return
+	}
+	if r.DNSAnswers != nil {
+		if err := r.DNSAnswers.Validate(); err != nil {
+			jsonError(w, fmt.Sprintf("could not register: invalid dns answers: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if len(r.HTTPResponses) > 0 {
+		if h.options.SessionResponses == nil {
+			jsonError(w, "could not register: http responses are disabled on this server", http.StatusBadRequest)
+			return
+		}
+		if err := r.HTTPResponses.Validate(); err != nil {
+			jsonError(w, fmt.Sprintf("could not register: invalid http responses: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if r.HTTPChallenge != "" && !ValidHTTPChallenge(r.HTTPChallenge) {
+		jsonError(w, fmt.Sprintf("could not register: invalid http challenge %s", r.HTTPChallenge), http.StatusBadRequest)
+		return
+	}
+	if r.TTL < 0 {
+		jsonError(w, fmt.Sprintf("could not register: invalid ttl %d", r.TTL), http.StatusBadRequest)
+		return
+	}
+	if err := h.options.validateCorrelationID(r.IDScheme, r.CorrelationID, h.tokenOf(req)); err != nil {
+		setSpanError(span, err)
+		gologger.Warning().Msgf("Could not register %s: %s\n", r.CorrelationID, err)
+		jsonError(w, fmt.Sprintf("could not register: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := h.options.Revocations.Revoked(r.CorrelationID); ok {
+		gologger.Warning().Msgf("Could not register %s: correlation-id revoked\n", r.CorrelationID)
+		jsonError(w, "could not register: correlation-id revoked", http.StatusForbidden)
+		return
+	}
+	tenant := h.tenantOf(req)
+	if tenant != nil {
+		if h.options.Storage.HasID(r.CorrelationID) && !h.options.visibleTo(tenant, r.CorrelationID) {
+			gologger.Warning().Msgf("Could not register %s: registered by another token\n", r.CorrelationID)
+			jsonError(w, "could not register: correlation-id registered by another token", http.StatusForbidden)
+			return
+		}
+		if ok, wait := h.options.Tokens.AllowRegistration(tenant); !ok {
+			gologger.Warning().Msgf("Could not register %s: too many registrations for %s\n", r.CorrelationID, tenant.Name)
+			retryAfter(w, wait)
+			jsonError(w, "too many registrations for this token", http.StatusTooManyRequests)
+			return
+		}
+		if !h.options.Tokens.Acquire(tenant, r.CorrelationID) {
+			gologger.Warning().Msgf("Could not register %s: too many sessions for %s\n", r.CorrelationID, tenant.Name)
+			jsonError(w, "too many sessions registered for this token", http.StatusTooManyRequests)
+			return
+		}
+	}
+	if h.options.MaxSessionsPerIP > 0 {
+		host, _, _ := net.SplitHostPort(req.RemoteAddr)
+		if !h.options.sessionLimiter.Acquire(host, r.CorrelationID) {
+			h.options.Tokens.Release(r.CorrelationID)
+			gologger.Warning().Msgf("Could not register %s: too many sessions for %s\n", r.CorrelationID, host)
+			jsonError(w, "too many sessions registered from this address", http.StatusTooManyRequests)
+			return
+		}
+	}
+	span.SetAttributes(attribute.String("interaction.correlation-id", r.CorrelationID))
 
-	if err := h.options.Storage.SetIDPublicKey(r.CorrelationID, r.SecretKey, r.PublicKey); err != nil {
+	ttl := time.Duration(r.TTL) * time.Second
+	if h.options.MaxSessionTTL > 0 && ttl > h.options.MaxSessionTTL {
+		ttl = h.options.MaxSessionTTL
+	}
+	_, storageSpan := tracer.Start(ctx, "storage.set-id-public-key")
+	err := h.options.Storage.SetIDPublicKeyWithTTL(r.CorrelationID, r.SecretKey, r.PublicKey, ttl)
+	setSpanError(storageSpan, err)
+	storageSpan.End()
+	if err != nil {
+		setSpanError(span, err)
+		h.options.sessionLimiter.Release(r.CorrelationID)
+		h.options.Tokens.Release(r.CorrelationID)
 		gologger.Warning().Msgf("Could not set id and public key for %s: %s\n", r.CorrelationID, err)
 		jsonError(w, fmt.Sprintf("could not set id and public key: %s", err), http.StatusBadRequest)
 		return
 	}
-	jsonMsg(w, "registration successful", http.StatusOK)
+	h.options.Metrics.IncRegistrations()
+	h.options.SessionAnswers.Set(r.CorrelationID, r.DNSAnswers)
+	h.options.SessionChallenges.Set(r.CorrelationID, r.HTTPChallenge)
+	h.options.SessionResponses.Set(r.CorrelationID, r.HTTPResponses)
+	if r.IDScheme == "" {
+		r.IDScheme = IDSchemeXID
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	response := &RegisterResponse{Message: "registration successful", IDScheme: r.IDScheme}
+	expiresAt, ok := h.options.Storage.GetExpiry(r.CorrelationID)
+	if ok {
+		response.ExpiresAt = &expiresAt
+	}
+	var owner string
+	if tenant != nil {
+		owner = tenant.Name
+	}
+	h.options.Cluster.Register(r, expiresAt, owner)
+	_ = jsoniter.NewEncoder(w).Encode(response)
 	gologger.Debug().Msgf("Registered correlationID %s for key\n", r.CorrelationID)
 }
 
@@ -218,32 +527,148 @@ type DeregisterRequest struct {
 
 // deregisterHandler is a handler for client deregister requests
 func (h *HTTPServer) deregisterHandler(w http.ResponseWriter, req *http.Request) {
+	ctx, span := tracer.Start(req.Context(), "http.deregister")
+	defer span.End()
+
 	r := &DeregisterRequest{}
 	if err := jsoniter.NewDecoder(req.Body).Decode(r); err != nil {
+		setSpanError(span, err)
 		gologger.Warning().Msgf("Could not decode json body: %s\n", err)
 		jsonError(w, fmt.Sprintf("could not decode json body: %s", err), http.StatusBadRequest)
 		return
 	}
+	span.SetAttributes(attribute.String("interaction.correlation-id", r.CorrelationID))
+	if _, ok := h.checkSession(w, req, r.CorrelationID); !ok {
+		return
+	}
 
-	if err := h.options.Storage.RemoveID(r.CorrelationID, r.SecretKey); err != nil {
+	_, storageSpan := tracer.Start(ctx, "storage.remove-id")
+	err := h.options.Storage.RemoveID(r.CorrelationID, r.SecretKey)
+	setSpanError(storageSpan, err)
+	storageSpan.End()
+	if err != nil {
+		setSpanError(span, err)
 		gologger.Warning().Msgf("Could not remove id for %s: %s\n", r.CorrelationID, err)
 		jsonError(w, fmt.Sprintf("could not remove id: %s", err), http.StatusBadRequest)
 		return
 	}
+	h.options.sessionRemoved(r.CorrelationID)
+	h.options.Cluster.Deregister(r.CorrelationID)
 	jsonMsg(w, "deregistration successful", http.StatusOK)
 	gologger.Debug().Msgf("Deregistered correlationID %s for key\n", r.CorrelationID)
 }
 
+// DNSAnswersRequest is a request setting the dns answers of a session.
+type DNSAnswersRequest struct {
+	// CorrelationID is an ID for correlation with requests.
+	CorrelationID string `json:"correlation-id"`
+	// SecretKey is the secretKey for the interactsh client.
+	SecretKey string `json:"secret-key"`
+	// DNSAnswers are the records answered for the names of the correlation ID, removed if empty.
+	DNSAnswers *DNSAnswers `json:"dns-answers"`
+}
+
+// dnsAnswersHandler is a handler for client requests setting the dns answers of their session
+func (h *HTTPServer) dnsAnswersHandler(w http.ResponseWriter, req *http.Request) {
+	r := &DNSAnswersRequest{}
+	if err := jsoniter.NewDecoder(req.Body).Decode(r); err != nil {
+		gologger.Warning().Msgf("Could not decode json body: %s\n", err)
+		jsonError(w, fmt.Sprintf("could not decode json body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if _, ok := h.checkSession(w, req, r.CorrelationID); !ok {
+		return
+	}
+	if _, err := h.options.Storage.GetAESKey(r.CorrelationID, r.SecretKey); err != nil {
+		gologger.Warning().Msgf("Could not set dns answers for %s: %s\n", r.CorrelationID, err)
+		jsonError(w, fmt.Sprintf("could not set dns answers: %s", err), http.StatusBadRequest)
+		return
+	}
+	if r.DNSAnswers != nil {
+		if err := r.DNSAnswers.Validate(); err != nil {
+			jsonError(w, fmt.Sprintf("could not set dns answers: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	h.options.SessionAnswers.Set(r.CorrelationID, r.DNSAnswers)
+	h.options.Cluster.SetDNSAnswers(r.CorrelationID, r.DNSAnswers)
+	jsonMsg(w, "dns answers set", http.StatusOK)
+	gologger.Debug().Msgf("Set dns answers for correlationID %s\n", r.CorrelationID)
+}
+
+// HTTPResponsesRequest is a request setting the http responses of a session.
+type HTTPResponsesRequest struct {
+	// CorrelationID is an ID for correlation with requests.
+	CorrelationID string `json:"correlation-id"`
+	// SecretKey is the secretKey for the interactsh client.
+	SecretKey string `json:"secret-key"`
+	// HTTPResponses are the responses served for the names of the correlation ID, removed if empty.
+	HTTPResponses HTTPResponses `json:"http-responses"`
+}
+
+// httpResponsesHandler is a handler for client requests setting the http responses of their session
+func (h *HTTPServer) httpResponsesHandler(w http.ResponseWriter, req *http.Request) {
+	r := &HTTPResponsesRequest{}
+	if err := jsoniter.NewDecoder(req.Body).Decode(r); err != nil {
+		gologger.Warning().Msgf("Could not decode json body: %s\n", err)
+		jsonError(w, fmt.Sprintf("could not decode json body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if _, ok := h.checkSession(w, req, r.CorrelationID); !ok {
+		return
+	}
+	if _, err := h.options.Storage.GetAESKey(r.CorrelationID, r.SecretKey); err != nil {
+		gologger.Warning().Msgf("Could not set http responses for %s: %s\n", r.CorrelationID, err)
+		jsonError(w, fmt.Sprintf("could not set http responses: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := r.HTTPResponses.Validate(); err != nil {
+		jsonError(w, fmt.Sprintf("could not set http responses: %s", err), http.StatusBadRequest)
+		return
+	}
+	h.options.SessionResponses.Set(r.CorrelationID, r.HTTPResponses)
+	h.options.Cluster.SetHTTPResponses(r.CorrelationID, r.HTTPResponses)
+	jsonMsg(w, "http responses set", http.StatusOK)
+	gologger.Debug().Msgf("Set http responses for correlationID %s\n", r.CorrelationID)
+}
+
 // PollResponse is the response for a polling request
 type PollResponse struct {
 	Data    []string `json:"data"`
 	Extra   []string `json:"extra"`
 	AESKey  string   `json:"aes_key"`
 	TLDData []string `json:"tlddata,omitempty"`
+	// WildcardData are the interactions not matching any registered correlation id
+	WildcardData []string `json:"wildcard_data,omitempty"`
+	// ExpiresAt is the time the session is evicted at by the server
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Usage is the traffic accounted for the session
+	Usage *SessionUsage `json:"usage,omitempty"`
+	// DeliveredAt is the time the interactions were delivered at
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	// MaxDeliveryLag is the longest time an interaction waited in the storage, in milliseconds
+	MaxDeliveryLag float64 `json:"max_delivery_lag_ms,omitempty"`
+	// LongPoll is true if the poll waited for the interactions as asked with the wait parameter
+	LongPoll bool `json:"long_poll,omitempty"`
+}
+
+// empty returns true if a response holds no interaction
+func (p *PollResponse) empty() bool {
+	return len(p.Data)+len(p.Extra)+len(p.TLDData)+len(p.WildcardData) == 0
 }
 
 // pollHandler is a handler for client poll requests
 func (h *HTTPServer) pollHandler(w http.ResponseWriter, req *http.Request) {
+	// the long polls aren't observed, their duration being the time they waited
+	wait := h.options.LongPolls.waitOf(req)
+	defer func(start time.Time) {
+		if wait == 0 {
+			h.options.Metrics.ObservePoll(time.Since(start))
+		}
+	}(time.Now())
+	ctx, span := tracer.Start(req.Context(), "http.poll")
+	defer span.End()
+
 	ID := req.URL.Query().Get("id")
 	if ID == "" {
 		jsonError(w, "no id specified for poll", http.StatusBadRequest)
@@ -255,27 +680,163 @@ func (h *HTTPServer) pollHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	data, aesKey, err := h.options.Storage.GetInteractions(ID, secret)
-	if err != nil {
-		gologger.Warning().Msgf("Could not get interactions for %s: %s\n", ID, err)
-		jsonError(w, fmt.Sprintf("could not get interactions: %s", err), http.StatusBadRequest)
+	span.SetAttributes(attribute.String("interaction.correlation-id", ID))
+	tenant, ok := h.checkSession(w, req, ID)
+	if !ok {
+		return
+	}
+	if ok, wait := h.options.Tokens.AllowPoll(tenant); !ok {
+		retryAfter(w, wait)
+		jsonError(w, "too many polls for this token", http.StatusTooManyRequests)
 		return
 	}
 
-	// At this point the client is authenticated, so we return also the data related to the auth token
-	var tlddata, extradata []string
-	if h.options.RootTLD {
-		tlddata, _ = h.options.Storage.GetInteractionsWithId(h.options.Domain)
-		extradata, _ = h.options.Storage.GetInteractionsWithId(h.options.Token)
+	// the long polls subscribe before getting the interactions, so that the
+	// ones stored in between wake them up
+	var notified <-chan struct{}
+	if wait > 0 {
+		keys := []string{ID}
+		if tenant.allows(ScopeAdmin) {
+			keys = append(keys, sharedBuckets)
+		}
+		var unsubscribe func()
+		notified, unsubscribe = h.options.LongPolls.subscribe(keys...)
+		defer unsubscribe()
+	}
+	deadline := time.Now().Add(wait)
+
+	var response *PollResponse
+	var storedAt []time.Time
+	for {
+		var err error
+		response, storedAt, err = h.getInteractions(ctx, ID, secret, tenant)
+		if err != nil {
+			setSpanError(span, err)
+			gologger.Warning().Msgf("Could not get interactions for %s: %s\n", ID, err)
+			code := http.StatusBadRequest
+			if err == storage.ErrCorrelationIDNotFound {
+				code = http.StatusNotFound
+			}
+			jsonError(w, fmt.Sprintf("could not get interactions: %s", err), code)
+			return
+		}
+
+		remaining := time.Until(deadline)
+		if !response.empty() || remaining <= 0 {
+			break
+		}
+		if remaining > longPollRecheck {
+			remaining = longPollRecheck
+		}
+		select {
+		case <-notified:
+		case <-time.After(remaining):
+		case <-req.Context().Done():
+			return
+		}
 	}
-	response := &PollResponse{Data: data, AESKey: aesKey, TLDData: tlddata, Extra: extradata}
+	response.LongPoll = wait > 0
+	h.delivered(response, ID, storedAt, "poll")
+	span.SetAttributes(attribute.Int("interaction.count", len(response.Data)))
 
 	if err := jsoniter.NewEncoder(w).Encode(response); err != nil {
+		setSpanError(span, err)
 		gologger.Warning().Msgf("Could not encode interactions for %s: %s\n", ID, err)
 		jsonError(w, fmt.Sprintf("could not encode interactions: %s", err), http.StatusBadRequest)
 		return
 	}
-	gologger.Debug().Msgf("Polled %d interactions for %s correlationID\n", len(data), ID)
+	gologger.Debug().Msgf("Polled %d interactions for %s correlationID\n", len(response.Data), ID)
+}
+
+// getInteractions gets and removes the pending interactions of a session,
+// along with the ones of the shared buckets for the admin tokens.
+func (h *HTTPServer) getInteractions(ctx context.Context, ID, secret string, tenant *Tenant) (*PollResponse, []time.Time, error) {
+	_, storageSpan := tracer.Start(ctx, "storage.get-interactions")
+	data, storedAt, aesKey, err := h.options.Storage.GetInteractionsWithStoredAt(ID, secret)
+	setSpanError(storageSpan, err)
+	storageSpan.End()
+	if err != nil {
+		return nil, nil, err
+	}
+	response := &PollResponse{Data: data, AESKey: aesKey}
+
+	// At this point the client is authenticated, so we return also the data related to
+	// the auth token, the tenants only receiving the interactions of their sessions
+	if h.options.RootTLD && tenant.allows(ScopeAdmin) {
+		_, storageSpan := tracer.Start(ctx, "storage.get-interactions-with-id")
+		response.TLDData, _ = h.options.Storage.GetInteractionsWithId(h.options.Domain)
+		response.Extra, _ = h.options.Storage.GetInteractionsWithId(h.options.GetToken())
+		storageSpan.End()
+	}
+	if h.options.WildcardLog && tenant.allows(ScopeAdmin) {
+		response.WildcardData, _ = h.options.Storage.GetInteractionsWithId(WildcardLogID)
+	}
+	return response, storedAt, nil
+}
+
+// delivered sets the delivery time and lag of the interactions of a response,
+// along with the expiry and the usage of their session.
+func (h *HTTPServer) delivered(response *PollResponse, ID string, storedAt []time.Time, method string) {
+	deliveredAt := time.Now()
+	response.DeliveredAt = &deliveredAt
+	for _, stored := range storedAt {
+		lag := deliveredAt.Sub(stored)
+		h.options.observeDelivery(method, lag)
+		if lag := milliseconds(lag); lag > response.MaxDeliveryLag {
+			response.MaxDeliveryLag = lag
+		}
+	}
+	if expiresAt, ok := h.options.Storage.GetExpiry(ID); ok {
+		response.ExpiresAt = &expiresAt
+	}
+	if usage, ok := h.options.Accounting.Get(ID); ok {
+		usage.CorrelationID = ""
+		response.Usage = &usage
+	}
+}
+
+// QuarantineResponse is the response for a quarantined attachment request
+type QuarantineResponse struct {
+	Data   string `json:"data"`
+	AESKey string `json:"aes_key"`
+}
+
+// quarantineHandler is a handler for client requests of quarantined smtp attachments
+func (h *HTTPServer) quarantineHandler(w http.ResponseWriter, req *http.Request) {
+	ID := req.URL.Query().Get("id")
+	if ID == "" {
+		jsonError(w, "no id specified for quarantine", http.StatusBadRequest)
+		return
+	}
+	secret := req.URL.Query().Get("secret")
+	if secret == "" {
+		jsonError(w, "no secret specified for quarantine", http.StatusBadRequest)
+		return
+	}
+	sha256 := strings.ToLower(req.URL.Query().Get("sha256"))
+	if sha256 == "" {
+		jsonError(w, "no sha256 specified for quarantine", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := h.checkSession(w, req, ID); !ok {
+		return
+	}
+
+	aesKey, err := h.options.Storage.GetAESKey(ID, secret)
+	if err != nil {
+		gologger.Warning().Msgf("Could not get quarantined attachment for %s: %s\n", ID, err)
+		jsonError(w, fmt.Sprintf("could not get quarantined attachment: %s", err), http.StatusBadRequest)
+		return
+	}
+	data, err := h.options.Quarantine.Load(ID, sha256)
+	if err != nil {
+		jsonError(w, fmt.Sprintf("could not get quarantined attachment: %s", err), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_ = jsoniter.NewEncoder(w).Encode(&QuarantineResponse{Data: data, AESKey: aesKey})
 }
 
 func (h *HTTPServer) corsMiddleware(next http.Handler) http.Handler {
@@ -310,18 +871,92 @@ func jsonMsg(w http.ResponseWriter, err string, code int) {
 	jsonBody(w, "message", err, code)
 }
 
-func (h *HTTPServer) authMiddleware(next http.Handler) http.Handler {
+// retryAfter sets the seconds to wait before retrying a rate limited request
+func retryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// filterMiddleware rejects the requests of the clients outside of the allowed ranges
+func (h *HTTPServer) filterMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !h.options.IPFilter.Allowed(req.RemoteAddr) {
+			jsonError(w, "client ip not allowed", http.StatusForbidden)
+			return
+		}
+		if !h.verifiedClient(req) {
+			jsonError(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// verifiedClient returns true if the request was made over tls with a client
+// certificate signed by the client cas, or if mutual tls is disabled
+func (h *HTTPServer) verifiedClient(req *http.Request) bool {
+	return h.options.ClientCAs == nil || req.TLS != nil && len(req.TLS.VerifiedChains) > 0
+}
+
+// authMiddleware requires a token allowed the scope of the endpoint, any
+// accepted token being allowed the endpoints without scope.
+func (h *HTTPServer) authMiddleware(scope string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		if !h.checkToken(req) {
-			w.WriteHeader(http.StatusUnauthorized)
+		req, ok := h.authorize(w, req, scope)
+		if !ok {
 			return
 		}
 		next.ServeHTTP(w, req)
 	})
 }
 
-func (h *HTTPServer) checkToken(req *http.Request) bool {
-	return !h.options.Auth || h.options.Auth && h.options.Token == req.Header.Get("Authorization")
+// tenantContextKey is the request context key of the authenticated tenant
+type tenantContextKey struct{}
+
+// tokenContextKey is the request context key of the authenticated token
+type tokenContextKey struct{}
+
+// authorize returns the request with its authenticated tenant and true if its
+// token is allowed a scope, writing an error otherwise. The signed requests
+// are verified once, their tenant and token being kept in the request context.
+func (h *HTTPServer) authorize(w http.ResponseWriter, req *http.Request, scope string) (*http.Request, bool) {
+	tenant, token, ok := h.options.authenticateRequest(req)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return req, false
+	}
+	setAuditActor(req, h.options.actorOf(tenant))
+	if scope != "" && !tenant.allows(scope) {
+		jsonError(w, fmt.Sprintf("token is not allowed to %s", scope), http.StatusForbidden)
+		return req, false
+	}
+	ctx := context.WithValue(req.Context(), tenantContextKey{}, tenant)
+	return req.WithContext(context.WithValue(ctx, tokenContextKey{}, token)), true
+}
+
+// tenantOf returns the tenant authenticated by a request, nil for the server token
+func (h *HTTPServer) tenantOf(req *http.Request) *Tenant {
+	tenant, _ := req.Context().Value(tenantContextKey{}).(*Tenant)
+	return tenant
+}
+
+// tokenOf returns the token authenticated by a request, the token sent by the
+// request if the authentication is disabled
+func (h *HTTPServer) tokenOf(req *http.Request) string {
+	if token, ok := req.Context().Value(tokenContextKey{}).(string); ok {
+		return token
+	}
+	return req.Header.Get("Authorization")
+}
+
+// checkSession returns the tenant of a request and true if it can access the
+// session of a correlation id, writing a forbidden error otherwise.
+func (h *HTTPServer) checkSession(w http.ResponseWriter, req *http.Request, correlationID string) (*Tenant, bool) {
+	tenant := h.tenantOf(req)
+	if h.options.visibleTo(tenant, correlationID) {
+		return tenant, true
+	}
+	jsonError(w, "correlation-id registered by another token", http.StatusForbidden)
+	return tenant, false
 }
 
 // metricsHandler is a handler for /metrics endpoint
@@ -332,3 +967,21 @@ func (h *HTTPServer) metricsHandler(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	_ = jsoniter.NewEncoder(w).Encode(metrics)
 }
+
+// resolversHandler is a handler for /resolvers endpoint returning the
+// behavior of all the resolvers or the one of the ip query parameter.
+func (h *HTTPServer) resolversHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if ip := req.URL.Query().Get("ip"); ip != "" {
+		resolver, ok := h.options.ResolverStats.Get(ip)
+		if !ok {
+			jsonError(w, "resolver not found", http.StatusNotFound)
+			return
+		}
+		_ = jsoniter.NewEncoder(w).Encode(resolver)
+		return
+	}
+	_ = jsoniter.NewEncoder(w).Encode(h.options.ResolverStats.List())
+}
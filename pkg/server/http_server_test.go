@@ -0,0 +1,99 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPServerProxy(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "origin %s", req.URL.Path)
+	}))
+	defer origin.Close()
+	originURL, _ := url.Parse(origin.URL)
+
+	store := storage.New(time.Hour)
+	require.Nil(t, store.SetID("token"), "could not set token")
+	options := &Options{Domain: "example.com", Storage: store, Auth: true, Token: "token", ProxyRoutes: map[string]*url.URL{"/app/": originURL}}
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+
+	get := func(path string) string {
+		rec := httptest.NewRecorder()
+		server.nontlsserver.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil))
+		return rec.Body.String()
+	}
+	require.Equal(t, "origin /app/login", get("/app/login"), "could not proxy request")
+	require.NotContains(t, get("/other"), "origin", "proxied request outside of the route")
+
+	data, err := store.GetInteractionsWithId("token")
+	require.Nil(t, err, "could not get interactions")
+	require.Len(t, data, 1, "could not record proxied request")
+
+	options.ProxyRoutes = map[string]*url.URL{"/poll": originURL}
+	_, err = NewHTTPServer(options)
+	require.NotNil(t, err, "could proxy a route of the server")
+}
+
+func TestHTTPServerWildcardLog(t *testing.T) {
+	store := storage.New(time.Hour)
+	require.Nil(t, store.SetID(WildcardLogID), "could not set wildcard log id")
+	options := &Options{Domain: "example.com", Storage: store, WildcardLog: true}
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+
+	for _, host := range []string{"example.com", "c6rj61aciaeutn2ae680cg5ugboyyyyyn.example.com"} {
+		rec := httptest.NewRecorder()
+		server.nontlsserver.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://"+host+"/scan", nil))
+	}
+	data, err := store.GetInteractionsWithId(WildcardLogID)
+	require.Nil(t, err, "could not get interactions")
+	require.Len(t, data, 2, "could not record unmatched requests")
+	require.Contains(t, data[1], `"unique-id":"c6rj61aciaeutn2ae680cg5ugboyyyyyn"`, "could not record unregistered correlation id")
+}
+
+func TestHTTPServerClientCertificate(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir(), 1, "client")
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.Nil(t, err, "could not load client certificate")
+	clientCAs, err := ioutil.ReadFile(certFile)
+	require.Nil(t, err, "could not read client ca")
+
+	store := storage.New(time.Hour)
+	options := &Options{Domain: "example.com", Storage: store, ClientCAs: x509.NewCertPool()}
+	require.True(t, options.ClientCAs.AppendCertsFromPEM(clientCAs), "could not add client ca")
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+	tlsConfig, err := NewSelfSignedTLSConfig([]string{"example.com"})
+	require.Nil(t, err, "could not create tls config")
+	ts := httptest.NewUnstartedServer(server.nontlsserver.Handler)
+	ts.TLS = server.serverTLSConfig(tlsConfig)
+	ts.StartTLS()
+	defer ts.Close()
+
+	get := func(certificates []tls.Certificate, path string) int {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: certificates}}}
+		resp, err := client.Get(ts.URL + path)
+		require.Nil(t, err, "could not make request")
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// the client endpoints require a certificate, unlike the interactions
+	require.Equal(t, http.StatusForbidden, get(nil, "/poll?id=c23b2la0kl1krjcrdj10&secret=secret"), "could poll without client certificate")
+	require.Equal(t, http.StatusNotFound, get([]tls.Certificate{certificate}, "/poll?id=c23b2la0kl1krjcrdj10&secret=secret"), "could not poll with client certificate")
+	require.Equal(t, http.StatusOK, get(nil, "/"), "could not record interaction without client certificate")
+
+	rec := httptest.NewRecorder()
+	server.nontlsserver.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/poll?id=c23b2la0kl1krjcrdj10&secret=secret", nil))
+	require.Equal(t, http.StatusForbidden, rec.Code, "could poll over http with mutual tls")
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+)
+
+// The correlation id schemes negotiated at registration. The unique id of
+// a payload is the correlation id of the session followed by a nonce.
+const (
+	// IDSchemeXID is the default scheme, a 20 characters xid followed by a 13 characters nonce
+	IDSchemeXID = "xid"
+	// IDSchemeUUID is a 16 hex characters id followed by a 16 hex characters nonce,
+	// looking like an uuid without dashes
+	IDSchemeUUID = "uuid"
+	// IDSchemeWords is a human readable word-word-word id followed by a dash and a nonce
+	IDSchemeWords = "words"
+	// IDSchemePrefix is a prefix reserved for a token and a xid separated by
+	// a dash followed by a nonce (eg. acme-<xid><nonce>)
+	IDSchemePrefix = "prefix"
+	// IDSchemeRandom is a random id followed by a random nonce, whose lengths
+	// and alphabet are the IDFormat configured on the server
+	IDSchemeRandom = "random"
+)
+
+// IDSchemes are the supported correlation id schemes
+var IDSchemes = []string{IDSchemeXID, IDSchemeUUID, IDSchemeWords, IDSchemePrefix, IDSchemeRandom}
+
+const (
+	xidLength         = 20
+	xidUniqueIDLength = 33
+	uuidLength        = 16
+	uuidNonceLength   = 16
+	wordsCount        = 3
+	// wordsNonceLength is the length of the zbase32 nonce of the words and prefix schemes
+	wordsNonceLength = 13
+	// MaxIDPrefixLength is the maximum length of a prefix fitting a dns label with its unique id
+	MaxIDPrefixLength = 63 - 1 - xidUniqueIDLength
+	// minIDFormatLength is the minimum length of the correlation ids of the random scheme
+	minIDFormatLength = 4
+	// xidAlphabet is the alphabet of the xid correlation ids
+	xidAlphabet = "0123456789abcdefghijklmnopqrstuv"
+)
+
+// IDFormat is the format of the correlation ids of the random scheme, which
+// can be shortened for the injection points truncating the payloads.
+type IDFormat struct {
+	// Length is the length of the correlation id
+	Length int `json:"length"`
+	// NonceLength is the length of the nonce following the correlation id
+	NonceLength int `json:"nonce-length"`
+	// Alphabet are the characters of the correlation id and the nonce
+	Alphabet string `json:"alphabet"`
+}
+
+// xidFormat is the format of the unique ids of the xid scheme
+var xidFormat = IDFormat{Length: xidLength, NonceLength: xidUniqueIDLength - xidLength, Alphabet: xidAlphabet}
+
+// DefaultIDFormat is the default format of the random scheme, as long as the xid unique ids
+var DefaultIDFormat = IDFormat{Length: xidLength, NonceLength: xidUniqueIDLength - xidLength, Alphabet: "abcdefghijklmnopqrstuvwxyz0123456789"}
+
+// Validate returns an error if the ids of the format don't fit a dns label.
+func (f IDFormat) Validate() error {
+	if f.Length < minIDFormatLength || f.NonceLength < 1 || f.Length+f.NonceLength > 63 {
+		return errors.Errorf("correlation id must be at least %d characters and fit a dns label with its nonce (63 characters)", minIDFormatLength)
+	}
+	if len(f.Alphabet) < 2 {
+		return errors.New("id alphabet must contain at least 2 characters")
+	}
+	for i, c := range f.Alphabet {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			return errors.New("id alphabet must contain only lowercase letters and digits")
+		}
+		if strings.IndexRune(f.Alphabet[:i], c) >= 0 {
+			return errors.Errorf("id alphabet contains %c twice", c)
+		}
+	}
+	return nil
+}
+
+// NewCorrelationID returns a new random correlation id of the format.
+func (f IDFormat) NewCorrelationID() (string, error) {
+	if err := f.Validate(); err != nil {
+		return "", err
+	}
+	id := make([]byte, 0, f.Length)
+	// bytes above the largest multiple of the alphabet size are discarded to keep the ids uniform
+	limit := 256 - 256%len(f.Alphabet)
+	data := make([]byte, f.Length)
+	for len(id) < f.Length {
+		if _, err := rand.Read(data); err != nil {
+			return "", errors.Wrap(err, "could not generate id")
+		}
+		for _, value := range data {
+			if int(value) < limit && len(id) < f.Length {
+				id = append(id, f.Alphabet[int(value)%len(f.Alphabet)])
+			}
+		}
+	}
+	return string(id), nil
+}
+
+// parseUniqueID returns the correlation id of a unique id of the format
+func (f IDFormat) parseUniqueID(uniqueID string) (string, bool) {
+	if len(uniqueID) != f.Length+f.NonceLength || !f.valid(uniqueID) {
+		return "", false
+	}
+	return uniqueID[:f.Length], true
+}
+
+// valid returns true if a value contains only characters of the alphabet
+func (f IDFormat) valid(value string) bool {
+	return value != "" && strings.Trim(value, f.Alphabet) == ""
+}
+
+// idFormat returns the format of the random scheme, DefaultIDFormat if not configured
+func (options *Options) idFormat() IDFormat {
+	if options.IDFormat.Length == 0 {
+		return DefaultIDFormat
+	}
+	return options.IDFormat
+}
+
+// idWords are the words of the correlation ids of the words scheme
+var idWords = []string{
+	"able", "acid", "area", "army", "baby", "back", "ball", "band", "bank", "base", "bath", "bear",
+	"beat", "bell", "belt", "bird", "blue", "boat", "body", "bone", "book", "boot", "bowl", "burn",
+	"bush", "cake", "calm", "camp", "card", "care", "cart", "case", "cash", "cell", "chef", "chip",
+	"city", "clay", "club", "coal", "coat", "code", "cold", "cone", "cook", "cool", "copy", "cord",
+	"corn", "cost", "crew", "crop", "cube", "dark", "data", "dawn", "deal", "deep", "deer", "desk",
+	"dial", "dice", "dish", "dock", "door", "dove", "draw", "drum", "duck", "dune", "dust", "duty",
+	"east", "easy", "echo", "edge", "exit", "face", "fact", "fair", "farm", "fast", "fern", "film",
+	"fine", "fire", "firm", "fish", "flag", "flat", "flow", "foam", "fold", "folk", "font", "food",
+	"foot", "fork", "form", "fort", "fuel", "full", "fund", "gain", "game", "gate", "gear", "gift",
+	"glad", "glow", "goal", "gold", "golf", "good", "gray", "grid", "grow", "gulf", "hall", "hand",
+	"harp", "hawk", "heat", "herb", "hero", "hill", "hint", "hive", "hold", "home", "hook", "hope",
+	"horn", "host", "hunt", "idea", "iron", "jade", "jazz", "joke", "jump", "king", "kite", "knot",
+	"lake", "lamp", "land", "lane", "lava", "lawn", "leaf", "lens", "lift", "lily", "lime", "line",
+	"link", "lion", "list", "loaf", "lock", "loft", "loop", "luck", "lump", "mail", "malt", "mark",
+	"mask", "mast", "meal", "melt", "milk", "mill", "mind", "mint", "mist", "mode", "moon", "moss",
+	"moth", "nail", "nest", "news", "node", "nose", "note", "oak", "oval", "palm", "park", "path",
+	"peak", "pear", "pine", "pink", "plan", "plum", "poem", "pond", "pony", "pool", "port", "raft",
+	"rail", "rain", "ramp", "rice", "ring", "road", "rock", "roof", "root", "rope", "rose", "ruby",
+	"rush", "sage", "sail", "salt", "sand", "seal", "seed", "ship", "silk", "sink", "snow", "soap",
+	"sock", "soft", "soil", "song", "star", "stem", "sun", "surf", "swan", "tape", "team", "tent",
+	"tide", "tile", "toad", "tree", "tune", "twig", "vase", "vine", "wave", "wind", "wolf", "wood",
+	"yard", "yarn", "zinc", "zone",
+}
+
+var idWordsSet = func() map[string]struct{} {
+	set := make(map[string]struct{}, len(idWords))
+	for _, word := range idWords {
+		set[word] = struct{}{}
+	}
+	return set
+}()
+
+// NewCorrelationID returns a new correlation id of a scheme, the prefix
+// being required by the prefix scheme.
+func NewCorrelationID(scheme, prefix string) (string, error) {
+	switch scheme {
+	case "", IDSchemeXID:
+		return xid.New().String(), nil
+	case IDSchemeUUID:
+		data := make([]byte, uuidLength/2)
+		if _, err := rand.Read(data); err != nil {
+			return "", errors.Wrap(err, "could not generate id")
+		}
+		return hex.EncodeToString(data), nil
+	case IDSchemeWords:
+		data := make([]byte, wordsCount)
+		if _, err := rand.Read(data); err != nil {
+			return "", errors.Wrap(err, "could not generate id")
+		}
+		words := make([]string, wordsCount)
+		for i, value := range data {
+			words[i] = idWords[value]
+		}
+		return strings.Join(words, "-"), nil
+	case IDSchemePrefix:
+		if err := ValidateIDPrefix(prefix); err != nil {
+			return "", err
+		}
+		return prefix + "-" + xid.New().String(), nil
+	case IDSchemeRandom:
+		return DefaultIDFormat.NewCorrelationID()
+	}
+	return "", errors.Errorf("unsupported id scheme %s", scheme)
+}
+
+// ValidateIDPrefix returns an error if a prefix can't be used by the prefix scheme.
+func ValidateIDPrefix(prefix string) error {
+	if prefix == "" || len(prefix) > MaxIDPrefixLength {
+		return errors.Errorf("id prefix must be between 1 and %d characters", MaxIDPrefixLength)
+	}
+	for _, c := range prefix {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			return errors.New("id prefix must contain only lowercase letters and digits")
+		}
+	}
+	return nil
+}
+
+// ParseUniqueID returns the correlation id and the scheme of a unique id,
+// or empty strings if the label isn't shaped like a unique id.
+func ParseUniqueID(uniqueID string) (correlationID, scheme string) {
+	switch {
+	case len(uniqueID) == xidUniqueIDLength:
+		return uniqueID[:xidLength], IDSchemeXID
+	case len(uniqueID) == uuidLength+uuidNonceLength && isHex(uniqueID):
+		return uniqueID[:uuidLength], IDSchemeUUID
+	}
+	if index := strings.IndexByte(uniqueID, '-'); index > 0 && index <= MaxIDPrefixLength && len(uniqueID)-index-1 == xidUniqueIDLength {
+		return uniqueID[:index+1+xidLength], IDSchemePrefix
+	}
+	parts := strings.Split(uniqueID, "-")
+	if len(parts) != wordsCount+1 || len(parts[wordsCount]) != wordsNonceLength {
+		return "", ""
+	}
+	for _, word := range parts[:wordsCount] {
+		if _, ok := idWordsSet[word]; !ok {
+			return "", ""
+		}
+	}
+	return uniqueID[:len(uniqueID)-wordsNonceLength-1], IDSchemeWords
+}
+
+// validateCorrelationID returns an error if the correlation id registered
+// with the authorization token doesn't match the scheme.
+func (options *Options) validateCorrelationID(scheme, correlationID, token string) error {
+	var valid bool
+	switch scheme {
+	case "", IDSchemeXID:
+		// kept unchecked for the clients predating the schemes
+		return nil
+	case IDSchemeUUID:
+		valid = len(correlationID) == uuidLength && isHex(correlationID)
+	case IDSchemeWords:
+		parts := strings.Split(correlationID, "-")
+		valid = len(parts) == wordsCount
+		for _, word := range parts {
+			if _, ok := idWordsSet[word]; !ok {
+				valid = false
+			}
+		}
+	case IDSchemePrefix:
+		index := strings.IndexByte(correlationID, '-')
+		if index <= 0 {
+			return errors.New("invalid correlation-id for prefix scheme")
+		}
+		reservedToken, ok := options.IDPrefixes[correlationID[:index]]
+		// the prefixes reserved without token are reserved for the server token
+		if reservedToken == "" {
+			ok = ok && options.isServerToken(token)
+		} else {
+			ok = ok && subtle.ConstantTimeCompare([]byte(reservedToken), []byte(token)) == 1
+		}
+		if !ok {
+			return errors.Errorf("id prefix %s is not reserved for the token", correlationID[:index])
+		}
+		id := correlationID[index+1:]
+		valid = len(id) == xidLength && strings.Trim(id, xidAlphabet) == ""
+	case IDSchemeRandom:
+		format := options.idFormat()
+		valid = len(correlationID) == format.Length && format.valid(correlationID)
+	default:
+		return errors.Errorf("unsupported id scheme %s", scheme)
+	}
+	if !valid {
+		return errors.Errorf("invalid correlation-id for %s scheme", scheme)
+	}
+	return nil
+}
+
+// validCorrelationID returns true if a correlation id could have been issued
+// by one of the schemes, the random one being of the format.
+func validCorrelationID(correlationID string, format IDFormat) bool {
+	switch {
+	case len(correlationID) == xidLength && xidFormat.valid(correlationID):
+		return true
+	case len(correlationID) == uuidLength && isHex(correlationID):
+		return true
+	case len(correlationID) == format.Length && format.valid(correlationID):
+		return true
+	}
+	if index := strings.IndexByte(correlationID, '-'); index > 0 && ValidateIDPrefix(correlationID[:index]) == nil {
+		id := correlationID[index+1:]
+		if len(id) == xidLength && xidFormat.valid(id) {
+			return true
+		}
+	}
+	parts := strings.Split(correlationID, "-")
+	if len(parts) != wordsCount {
+		return false
+	}
+	for _, word := range parts {
+		if _, ok := idWordsSet[word]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// correlationIDOf returns the correlation id of a label holding the unique id
+// of a registered session, the xid labels being matched by their length only.
+func (options *Options) correlationIDOf(label string) string {
+	correlationID, scheme := ParseUniqueID(label)
+	if scheme == IDSchemeXID || scheme != "" && options.Storage.HasID(correlationID) {
+		return correlationID
+	}
+	if correlationID, ok := options.idFormat().parseUniqueID(label); ok && options.Storage.HasID(correlationID) {
+		return correlationID
+	}
+	return ""
+}
+
+// embeddedUniqueIDOf returns the unique id of a registered session of the xid
+// or random scheme embedded in a longer label, eg. by an injection point
+// adding characters before or after the payload.
+func (options *Options) embeddedUniqueIDOf(label string) (uniqueID, correlationID string) {
+	format := options.idFormat()
+	for _, id := range []IDFormat{xidFormat, format} {
+		size := id.Length + id.NonceLength
+		if len(label) <= size {
+			continue
+		}
+		for i := 0; i+size <= len(label); i++ {
+			candidate := label[i : i+size]
+			if id.valid(candidate) && options.Storage.HasID(candidate[:id.Length]) {
+				return candidate, candidate[:id.Length]
+			}
+		}
+	}
+	return "", ""
+}
+
+func isHex(value string) bool {
+	for _, c := range value {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIDSchemes(t *testing.T) {
+	nonces := map[string]string{
+		IDSchemeXID:    "cg5ugboyyyyyn",
+		IDSchemeUUID:   "0123456789abcdef",
+		IDSchemeWords:  "-cg5ugboyyyyyn",
+		IDSchemePrefix: "cg5ugboyyyyyn",
+	}
+	store := storage.New(time.Hour)
+	options := &Options{Storage: store, Token: "token", IDPrefixes: map[string]string{"acme": "", "corp": "other"}}
+	for _, scheme := range IDSchemes {
+		if scheme == IDSchemeRandom {
+			// the unique ids of the default random format are parsed as xid ones
+			continue
+		}
+		correlationID, err := NewCorrelationID(scheme, "acme")
+		require.Nil(t, err, "could not generate %s correlation id", scheme)
+		require.Nil(t, options.validateCorrelationID(scheme, correlationID, "token"), "could not validate %s correlation id", scheme)
+
+		uniqueID := correlationID + nonces[scheme]
+		parsed, parsedScheme := ParseUniqueID(uniqueID)
+		require.Equal(t, correlationID, parsed, "could not parse %s unique id", scheme)
+		require.Equal(t, scheme, parsedScheme, "could not get scheme of %s unique id", scheme)
+
+		if scheme != IDSchemeXID {
+			require.Empty(t, options.correlationIDOf(uniqueID), "matched unregistered %s unique id", scheme)
+			require.Nil(t, store.SetID(correlationID), "could not register %s correlation id", scheme)
+		}
+		require.Equal(t, correlationID, options.correlationIDOf(uniqueID), "could not match %s unique id", scheme)
+	}
+
+	require.NotNil(t, options.validateCorrelationID(IDSchemeUUID, "c6rj61aciaeutn2ae680", "token"), "validated xid as uuid")
+	require.NotNil(t, options.validateCorrelationID(IDSchemeWords, "able-acid-nope", "token"), "validated unknown words")
+	require.NotNil(t, options.validateCorrelationID(IDSchemePrefix, "corp-c6rj61aciaeutn2ae680", "token"), "validated prefix reserved for another token")
+	require.NotNil(t, options.validateCorrelationID(IDSchemePrefix, "free-c6rj61aciaeutn2ae680", "token"), "validated unreserved prefix")
+	require.NotNil(t, options.validateCorrelationID("ulid", "c6rj61aciaeutn2ae680", "token"), "validated unknown scheme")
+
+	// the prefixes of the server token are still accepted with the rotated token during its grace period
+	require.Nil(t, options.SetToken("rotated", time.Minute), "could not rotate token")
+	require.Nil(t, options.validateCorrelationID(IDSchemePrefix, "acme-c6rj61aciaeutn2ae680", "token"), "could not validate prefix with previous token")
+	require.Nil(t, options.validateCorrelationID(IDSchemePrefix, "acme-c6rj61aciaeutn2ae680", "rotated"), "could not validate prefix with rotated token")
+	require.NotNil(t, options.validateCorrelationID(IDSchemePrefix, "acme-c6rj61aciaeutn2ae680", "other"), "validated prefix of server token with other token")
+	_, scheme := ParseUniqueID("www")
+	require.Empty(t, scheme, "parsed label as unique id")
+}
+
+func TestIDFormat(t *testing.T) {
+	format := IDFormat{Length: 6, NonceLength: 4, Alphabet: "abcdef0123"}
+	require.Nil(t, format.Validate(), "could not validate id format")
+	require.NotNil(t, IDFormat{Length: 2, NonceLength: 4, Alphabet: "abc"}.Validate(), "validated short id")
+	require.NotNil(t, IDFormat{Length: 40, NonceLength: 40, Alphabet: "abc"}.Validate(), "validated id longer than a dns label")
+	require.NotNil(t, IDFormat{Length: 6, NonceLength: 4, Alphabet: "aA"}.Validate(), "validated uppercase alphabet")
+	require.NotNil(t, IDFormat{Length: 6, NonceLength: 4, Alphabet: "aba"}.Validate(), "validated duplicated characters")
+
+	store := storage.New(time.Hour)
+	options := &Options{Storage: store, IDFormat: format}
+	correlationID, err := format.NewCorrelationID()
+	require.Nil(t, err, "could not generate correlation id")
+	require.Len(t, correlationID, 6, "could not generate correlation id of the format length")
+	require.Nil(t, options.validateCorrelationID(IDSchemeRandom, correlationID, ""), "could not validate random correlation id")
+	require.NotNil(t, options.validateCorrelationID(IDSchemeRandom, "c6rj61aciaeutn2ae680", ""), "validated correlation id of another format")
+
+	uniqueID := correlationID + "0123"
+	require.Empty(t, options.correlationIDOf(uniqueID), "matched unregistered random unique id")
+	require.Nil(t, store.SetID(correlationID), "could not register random correlation id")
+	require.Equal(t, correlationID, options.correlationIDOf(uniqueID), "could not match random unique id")
+
+	id, fullID, parsed := options.findUniqueID("x.y." + uniqueID + ".example.com")
+	require.Equal(t, uniqueID, id, "could not find unique id of deep label")
+	require.Equal(t, "x.y."+uniqueID, fullID, "could not get full id of deep label")
+	require.Equal(t, correlationID, parsed, "could not get correlation id of deep label")
+
+	// injection points adding characters around the payload
+	id, _, parsed = options.findUniqueID("zz" + uniqueID + "-9.example.com")
+	require.Equal(t, uniqueID, id, "could not find embedded unique id")
+	require.Equal(t, correlationID, parsed, "could not get correlation id of embedded unique id")
+	id, _, _ = options.findUniqueID("zz" + uniqueID[:8] + ".example.com")
+	require.Empty(t, id, "found truncated unique id")
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IPFilter is an allowlist and a denylist of ip ranges.
+type IPFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewIPFilter returns a filter for the allowed and denied ranges, either
+// cidrs or single ips. All the ips are allowed when no range is allowed.
+func NewIPFilter(allow, deny []string) (*IPFilter, error) {
+	filter := &IPFilter{}
+	var err error
+	if filter.allow, err = parseIPRanges(allow); err != nil {
+		return nil, errors.Wrap(err, "could not parse allowed ranges")
+	}
+	if filter.deny, err = parseIPRanges(deny); err != nil {
+		return nil, errors.Wrap(err, "could not parse denied ranges")
+	}
+	return filter, nil
+}
+
+func parseIPRanges(values []string) ([]*net.IPNet, error) {
+	ranges := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, errors.Errorf("invalid ip %s", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			ranges = append(ranges, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, errors.Errorf("invalid cidr %s", value)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
+
+// Allowed returns true if an address (ip or host:port) is allowed
+// and not denied.
+func (f *IPFilter) Allowed(address string) bool {
+	if f == nil {
+		return true
+	}
+	ip := parseAddressIP(address)
+	if ip == nil {
+		return len(f.allow) == 0 && len(f.deny) == 0
+	}
+	if containsIP(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, ip)
+}
+
+// Denied returns true if an address (ip or host:port) is denied.
+func (f *IPFilter) Denied(address string) bool {
+	if f == nil {
+		return false
+	}
+	ip := parseAddressIP(address)
+	return ip != nil && containsIP(f.deny, ip)
+}
+
+func parseAddressIP(address string) net.IP {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return net.ParseIP(address)
+}
+
+func containsIP(ranges []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ranges {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPFilter(t *testing.T) {
+	filter, err := NewIPFilter([]string{"10.0.0.0/8", "192.168.1.5", "fd00::/8"}, []string{"10.0.13.0/24"})
+	require.Nil(t, err, "could not create ip filter")
+
+	require.True(t, filter.Allowed("10.1.2.3"), "could not allow ip of an allowed range")
+	require.True(t, filter.Allowed("192.168.1.5:4321"), "could not allow allowed ip with port")
+	require.True(t, filter.Allowed("[fd00::1]:80"), "could not allow ipv6 of an allowed range")
+	require.False(t, filter.Allowed("192.168.1.6"), "could allow ip outside of the allowed ranges")
+	require.False(t, filter.Allowed("10.0.13.7"), "could allow denied ip of an allowed range")
+	require.True(t, filter.Denied("10.0.13.7:53"), "could not deny ip of a denied range")
+	require.False(t, filter.Denied("8.8.8.8"), "could deny ip outside of the denied ranges")
+
+	denyOnly, err := NewIPFilter(nil, []string{"203.0.113.0/24"})
+	require.Nil(t, err, "could not create ip filter")
+	require.True(t, denyOnly.Allowed("8.8.8.8"), "could not allow ip without allowed ranges")
+	require.False(t, denyOnly.Allowed("203.0.113.9"), "could allow denied ip")
+
+	var disabled *IPFilter
+	require.True(t, disabled.Allowed("8.8.8.8"), "could not allow ip without filter")
+
+	_, err = NewIPFilter([]string{"10.0.0.0/33"}, nil)
+	require.NotNil(t, err, "could create filter with invalid cidr")
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+	"github.com/projectdiscovery/interactsh/pkg/webhook"
+)
+
+// latencyAlertInterval is the minimum interval between two alerts of the same stage
+const latencyAlertInterval = time.Minute
+
+// InteractionLatency is the latency of an interaction from its receipt
+// by a listener to its storage and its delivery to the client.
+type InteractionLatency struct {
+	// StoredAt is the time the interaction was stored at
+	StoredAt time.Time `json:"stored-at"`
+	// Ingestion is the time from the receipt to the storage in milliseconds
+	Ingestion float64 `json:"ingestion-ms"`
+	// DeliveredAt is the time the interaction was polled at, set by the client
+	DeliveredAt *time.Time `json:"delivered-at,omitempty"`
+	// Delivery is the time from the storage to the poll in milliseconds, set by the client
+	Delivery float64 `json:"delivery-ms,omitempty"`
+}
+
+// SetDeliveredAt sets the time the interaction was delivered at by a poll.
+func (l *InteractionLatency) SetDeliveredAt(deliveredAt time.Time) {
+	l.DeliveredAt = &deliveredAt
+	l.Delivery = milliseconds(deliveredAt.Sub(l.StoredAt))
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// LatencyAlerts warns when the interactions are stored or delivered later
+// than the thresholds, at most once per minute for each stage so that a
+// lagging server doesn't flood the logs.
+type LatencyAlerts struct {
+	ingestion time.Duration
+	delivery  time.Duration
+
+	mutex  sync.Mutex
+	alerts map[string]*latencyAlert
+}
+
+// latencyAlert is the state of the alerts of a stage
+type latencyAlert struct {
+	last       time.Time
+	suppressed int
+	max        time.Duration
+}
+
+// NewLatencyAlerts returns the alerts for the ingestion and delivery
+// thresholds, a zero threshold disabling the alerts of the stage.
+func NewLatencyAlerts(ingestion, delivery time.Duration) *LatencyAlerts {
+	return &LatencyAlerts{ingestion: ingestion, delivery: delivery, alerts: make(map[string]*latencyAlert)}
+}
+
+// ObserveIngestion checks the lag between the receipt of an interaction and
+// its storage, returning true if an alert has been logged.
+func (a *LatencyAlerts) ObserveIngestion(lag time.Duration) bool {
+	if a == nil {
+		return false
+	}
+	return a.observe("ingestion", a.ingestion, lag)
+}
+
+// ObserveDelivery checks the lag between the storage of an interaction and
+// its delivery with a method, returning true if an alert has been logged.
+func (a *LatencyAlerts) ObserveDelivery(method string, lag time.Duration) bool {
+	if a == nil {
+		return false
+	}
+	return a.observe(method+" delivery", a.delivery, lag)
+}
+
+func (a *LatencyAlerts) observe(stage string, threshold, lag time.Duration) bool {
+	if threshold <= 0 || lag <= threshold {
+		return false
+	}
+	a.mutex.Lock()
+	alert, ok := a.alerts[stage]
+	if !ok {
+		alert = &latencyAlert{}
+		a.alerts[stage] = alert
+	}
+	if lag > alert.max {
+		alert.max = lag
+	}
+	now := time.Now()
+	if now.Sub(alert.last) < latencyAlertInterval {
+		alert.suppressed++
+		a.mutex.Unlock()
+		return false
+	}
+	suppressed, max := alert.suppressed, alert.max
+	alert.last, alert.suppressed, alert.max = now, 0, 0
+	a.mutex.Unlock()
+
+	if suppressed > 0 {
+		gologger.Error().Msgf("Interactions %s lag exceeded %s: %s (%d more since the last alert, up to %s)\n", stage, threshold, lag.Round(time.Millisecond), suppressed, max.Round(time.Millisecond))
+	} else {
+		gologger.Error().Msgf("Interactions %s lag exceeded %s: %s\n", stage, threshold, lag.Round(time.Millisecond))
+	}
+	return true
+}
+
+// observeIngestion records the lag between the receipt and the storage of an interaction
+func (options *Options) observeIngestion(lag time.Duration) {
+	options.Metrics.ObserveIngestion(lag)
+	options.LatencyAlerts.ObserveIngestion(lag)
+}
+
+// observeDelivery records the lag between the storage and the delivery of an interaction
+func (options *Options) observeDelivery(method string, lag time.Duration) {
+	options.Metrics.ObserveDelivery(method, lag)
+	options.LatencyAlerts.ObserveDelivery(method, lag)
+}
+
+// observeWebhooks records the delivery lag of the interactions sent to the webhooks
+func (options *Options) observeWebhooks(webhooks []*webhook.Webhook) {
+	for _, webhook := range webhooks {
+		webhook.SetDeliveryObserver(func(lag time.Duration) {
+			options.observeDelivery("webhook", lag)
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyAlerts(t *testing.T) {
+	alerts := NewLatencyAlerts(100*time.Millisecond, 0)
+
+	require.False(t, alerts.ObserveIngestion(10*time.Millisecond), "could alert below the threshold")
+	require.True(t, alerts.ObserveIngestion(time.Second), "could not alert above the threshold")
+	require.False(t, alerts.ObserveIngestion(2*time.Second), "could alert twice within the interval")
+	require.Equal(t, 1, alerts.alerts["ingestion"].suppressed, "could not count suppressed alerts")
+	require.Equal(t, 2*time.Second, alerts.alerts["ingestion"].max, "could not get maximum lag")
+	require.False(t, alerts.ObserveDelivery("poll", time.Hour), "could alert for a disabled threshold")
+
+	var disabled *LatencyAlerts
+	require.False(t, disabled.ObserveDelivery("poll", time.Hour), "could alert without thresholds")
+}
+
+func TestInteractionLatency(t *testing.T) {
+	storedAt := time.Now()
+	latency := &InteractionLatency{StoredAt: storedAt}
+	latency.SetDeliveredAt(storedAt.Add(1500 * time.Millisecond))
+	require.Equal(t, 1500.0, latency.Delivery, "could not get delivery latency")
+}
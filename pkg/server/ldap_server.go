@@ -1,14 +1,12 @@
 package server
 
 import (
-	"bytes"
 	"crypto/tls"
 	"fmt"
 	"strings"
 	"time"
 
 	ldap "github.com/Mzack9999/ldapserver"
-	jsoniter "github.com/json-iterator/go"
 	"github.com/projectdiscovery/gologger"
 )
 
@@ -24,11 +22,13 @@ type LDAPServer struct {
 	options    *Options
 	server     *ldap.Server
 	tlsConfig  *tls.Config
+
+	clientHellos *clientHelloRecorder
 }
 
 // NewLDAPServer returns a new LDAP server.
 func NewLDAPServer(options *Options, withLogger bool) (*LDAPServer, error) {
-	ldapserver := &LDAPServer{options: options, WithLogger: withLogger}
+	ldapserver := &LDAPServer{options: options, WithLogger: withLogger, clientHellos: &clientHelloRecorder{}}
 
 	if withLogger {
 		ldap.Logger = ldapserver
@@ -59,10 +59,13 @@ func NewLDAPServer(options *Options, withLogger bool) (*LDAPServer, error) {
 
 // ListenAndServe listens on ldap ports for the server.
 func (ldapServer *LDAPServer) ListenAndServe(tlsConfig *tls.Config, ldapAlive chan bool) {
+	if ldapServer.options.LdapPort == 0 {
+		return
+	}
 	ldapAlive <- true
 	ldapServer.tlsConfig = tlsConfig
 	if err := ldapServer.server.ListenAndServe(fmt.Sprintf("%s:%d", ldapServer.options.ListenIP, ldapServer.options.LdapPort)); err != nil {
-		gologger.Error().Msgf("Could not serve ldap on port 10389: %s\n", err)
+		gologger.Error().Msgf("Could not serve ldap on port %d: %s\n", ldapServer.options.LdapPort, err)
 		ldapAlive <- false
 	}
 }
@@ -88,7 +91,7 @@ func (ldapServer *LDAPServer) handleBind(w ldap.ResponseWriter, m *ldap.Message)
 
 // handleSearch is a handler for search requests
 func (ldapServer *LDAPServer) handleSearch(w ldap.ResponseWriter, m *ldap.Message) {
-	var uniqueID, fullID string
+	var uniqueID, fullID, correlationID string
 
 	host := m.Client.Addr().String()
 
@@ -117,39 +120,22 @@ func (ldapServer *LDAPServer) handleSearch(w ldap.ResponseWriter, m *ldap.Messag
 
 	parts := ldapServer.splitSearchMsg(string(baseObject))
 	for _, part := range parts {
-		partChunks := strings.Split(part, ".")
-		if len(partChunks) > 0 {
-			for i, partChunk := range partChunks {
-				if len(partChunk) == 33 {
-					uniqueID = partChunk
-					fullID = partChunk
-					if i+1 <= len(partChunks) {
-						fullID = strings.Join(partChunks[:i+1], ".")
-					}
-				}
-			}
+		if id, full, correlation := ldapServer.options.findUniqueID(part); id != "" {
+			uniqueID, fullID, correlationID = id, full, correlation
 		}
 	}
 
 	if uniqueID != "" {
-		correlationID := uniqueID[:20]
 		interaction := &Interaction{
-			Protocol:      "ldap",
-			UniqueID:      uniqueID,
-			FullId:        fullID,
-			RawRequest:    message.String(),
-			RemoteAddress: host,
-			Timestamp:     time.Now(),
-		}
-		buffer := &bytes.Buffer{}
-		if err := jsoniter.NewEncoder(buffer).Encode(interaction); err != nil {
-			gologger.Warning().Msgf("Could not encode ldap interaction: %s\n", err)
-		} else {
-			gologger.Debug().Msgf("LDAP Interaction: \n%s\n", buffer.String())
-			if err := ldapServer.options.Storage.AddInteraction(correlationID, buffer.Bytes()); err != nil {
-				gologger.Warning().Msgf("Could not store ldap interaction: %s\n", err)
-			}
+			Protocol:       "ldap",
+			UniqueID:       uniqueID,
+			FullId:         fullID,
+			RawRequest:     message.String(),
+			RemoteAddress:  host,
+			Timestamp:      time.Now(),
+			TLSClientHello: ldapServer.clientHellos.Get(host),
 		}
+		ldapServer.options.storeInteraction(correlationID, interaction)
 
 	}
 
@@ -315,12 +301,13 @@ func (ldapServer *LDAPServer) handleStartTLS(w ldap.ResponseWriter, m *ldap.Mess
 	message.WriteString("Type=StartTLS\n")
 
 	tlsconfig, _ := ldapServer.getTLSconfig()
-	tlsConn := tls.Server(m.Client.GetConn(), tlsconfig)
+	tlsConn := tls.Server(ldapServer.clientHellos.Conn(m.Client.GetConn()), tlsconfig)
 	res := ldap.NewExtendedResponse(ldap.LDAPResultSuccess)
 	res.SetResponseName(ldap.NoticeOfStartTLS)
 	w.Write(res)
 
 	if err := tlsConn.Handshake(); err != nil {
+		ldapServer.clientHellos.Remove(m.Client.Addr().String())
 		message.WriteString(fmt.Sprintf("Result=StartTLS Handshake error %s\n", err.Error()))
 		res.SetDiagnosticMessage(fmt.Sprintf("StartTLS Handshake error : \"%s\"", err.Error()))
 		res.SetResultCode(ldap.LDAPResultOperationsError)
@@ -332,8 +319,9 @@ func (ldapServer *LDAPServer) handleStartTLS(w ldap.ResponseWriter, m *ldap.Mess
 
 	if ldapServer.WithLogger {
 		ldapServer.logInteraction(Interaction{
-			RemoteAddress: m.Client.Addr().String(),
-			RawRequest:    message.String(),
+			RemoteAddress:  m.Client.Addr().String(),
+			RawRequest:     message.String(),
+			TLSClientHello: ldapServer.clientHellos.Get(m.Client.Addr().String()),
 		})
 	}
 }
@@ -426,15 +414,7 @@ func (ldapServer *LDAPServer) logInteraction(interaction Interaction) {
 	// Correlation id doesn't apply here, we skip encryption
 	interaction.Protocol = "ldap"
 	interaction.Timestamp = time.Now()
-	buffer := &bytes.Buffer{}
-	if err := jsoniter.NewEncoder(buffer).Encode(interaction); err != nil {
-		gologger.Warning().Msgf("Could not encode ldap interaction: %s\n", err)
-	} else {
-		gologger.Debug().Msgf("LDAP Interaction: \n%s\n", buffer.String())
-		if err := ldapServer.options.Storage.AddInteractionWithId(ldapServer.options.Token, buffer.Bytes()); err != nil {
-			gologger.Warning().Msgf("Could not store ldap interaction: %s\n", err)
-		}
-	}
+	ldapServer.options.storeInteractionWithId(ldapServer.options.GetToken(), &interaction)
 }
 
 func (ldapServer *LDAPServer) Close() error {
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// longPollRecheck is the interval the storage is checked at while a poll
+// waits, for the interactions stored by the other instances sharing it
+const longPollRecheck = time.Second
+
+// sharedBuckets is the key of the waiters of the buckets shared by the
+// admin clients (the auth token, the root tld and the wildcard log)
+const sharedBuckets = ""
+
+// LongPolls holds the polls asking to wait for the interactions of their
+// session, waking them up as soon as an interaction is stored instead of
+// the clients polling at a fixed interval.
+type LongPolls struct {
+	maxWait time.Duration
+
+	sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+// NewLongPolls returns the long polls of a server, waiting for up to maxWait.
+func NewLongPolls(maxWait time.Duration) *LongPolls {
+	return &LongPolls{maxWait: maxWait, waiters: make(map[string]map[chan struct{}]struct{})}
+}
+
+// waitOf returns the time a poll asked to wait for (the wait query parameter,
+// in seconds) bounded by the maximum, 0 if long polling is disabled.
+func (l *LongPolls) waitOf(req *http.Request) time.Duration {
+	if l == nil {
+		return 0
+	}
+	seconds, err := strconv.Atoi(req.URL.Query().Get("wait"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	wait := time.Duration(seconds) * time.Second
+	if wait > l.maxWait {
+		wait = l.maxWait
+	}
+	return wait
+}
+
+// subscribe returns a channel notified when an interaction is stored for one
+// of the keys, and the function unsubscribing it.
+func (l *LongPolls) subscribe(keys ...string) (<-chan struct{}, func()) {
+	notified := make(chan struct{}, 1)
+	l.Lock()
+	for _, key := range keys {
+		waiters, ok := l.waiters[key]
+		if !ok {
+			waiters = make(map[chan struct{}]struct{})
+			l.waiters[key] = waiters
+		}
+		waiters[notified] = struct{}{}
+	}
+	l.Unlock()
+
+	return notified, func() {
+		l.Lock()
+		defer l.Unlock()
+		for _, key := range keys {
+			delete(l.waiters[key], notified)
+			if len(l.waiters[key]) == 0 {
+				delete(l.waiters, key)
+			}
+		}
+	}
+}
+
+// Notify wakes up the polls waiting for the interactions of a correlation id
+// (or of the shared buckets if empty).
+func (l *LongPolls) Notify(correlationID string) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	for notified := range l.waiters[correlationID] {
+		select {
+		case notified <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLongPoll(t *testing.T) {
+	store, _ := newTestSession(t)
+	options := &Options{Domain: "example.com", Storage: store, LongPolls: NewLongPolls(2 * time.Second)}
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+	poll := func(wait string) *PollResponse {
+		rec := httptest.NewRecorder()
+		server.nontlsserver.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/poll?id=c23b2la0kl1krjcrdj10&secret=secret&wait="+wait, nil))
+		require.Equal(t, http.StatusOK, rec.Code, "could not poll")
+		response := &PollResponse{}
+		require.Nil(t, jsoniter.Unmarshal(rec.Body.Bytes(), response), "could not decode poll response")
+		return response
+	}
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		options.storeInteraction("c23b2la0kl1krjcrdj10", &Interaction{Protocol: "dns", UniqueID: "c23b2la0kl1krjcrdj10cndmnioyyyyyn", Timestamp: time.Now()})
+	}()
+	start := time.Now()
+	response := poll("10")
+	require.Len(t, response.Data, 1, "could not wait for interaction")
+	require.True(t, response.LongPoll, "could not report long poll")
+	require.Less(t, int64(time.Since(start)), int64(time.Second), "could not deliver interaction as soon as stored")
+
+	start = time.Now()
+	response = poll("10")
+	require.Empty(t, response.Data, "returned interactions without any stored")
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(2*time.Second), "could not wait up to the maximum")
+
+	start = time.Now()
+	response = poll("")
+	require.False(t, response.LongPoll, "long polled without wait")
+	require.Less(t, int64(time.Since(start)), int64(time.Second), "waited without wait")
+}
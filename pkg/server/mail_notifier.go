@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projectdiscovery/gologger"
+	"github.com/rs/xid"
+)
+
+const (
+	// mailNotifierRateWindow is the window the interactions of a session are counted in for the spikes
+	mailNotifierRateWindow = time.Minute
+	// mailNotifierSpikeInterval is the minimum interval between two spike notifications of a session
+	mailNotifierSpikeInterval = time.Hour
+)
+
+// MailNotifierOptions are the options of the email notifications.
+type MailNotifierOptions struct {
+	// Relay is the host:port of the smtp relay sending the notifications
+	Relay string
+	// Username and Password authenticate to the relay, if set
+	Username string
+	Password string
+	// From is the sender of the notifications
+	From string
+	// To are the operators receiving the notifications
+	To []string
+	// Rate is the number of interactions of a session per minute notified
+	// as a spike, 0 to only notify the first interaction
+	Rate int
+	// DKIM signs the notifications, if enabled
+	DKIM *DKIMSigner
+}
+
+// MailNotifier emails the operators through a relay when a session receives
+// its first interaction or its interactions spike, for the long-lived canary
+// sessions whose client isn't polling.
+type MailNotifier struct {
+	options *MailNotifierOptions
+	alive   func(correlationID string) bool
+	send    func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+
+	sync.Mutex
+	sessions map[string]*mailNotifierSession
+}
+
+// mailNotifierSession is the notification state of a session
+type mailNotifierSession struct {
+	windowStart time.Time
+	window      int
+	lastSpike   time.Time
+}
+
+// NewMailNotifier returns a new email notifier, alive returning false for
+// the sessions which are no longer registered.
+func NewMailNotifier(options *MailNotifierOptions, alive func(correlationID string) bool) *MailNotifier {
+	return &MailNotifier{options: options, alive: alive, send: smtp.SendMail, sessions: make(map[string]*mailNotifierSession)}
+}
+
+// Observe notifies the first interaction of a session and the spikes of its
+// interactions, the emails being sent in the background.
+func (n *MailNotifier) Observe(correlationID string, interaction *Interaction) {
+	if n == nil {
+		return
+	}
+	now := time.Now()
+	n.Lock()
+	session, ok := n.sessions[correlationID]
+	if !ok {
+		if len(n.sessions) >= maxAccountedSessions {
+			n.prune()
+		}
+		session = &mailNotifierSession{windowStart: now}
+		n.sessions[correlationID] = session
+	}
+	if now.Sub(session.windowStart) >= mailNotifierRateWindow {
+		session.windowStart, session.window = now, 0
+	}
+	session.window++
+	spike := n.options.Rate > 0 && session.window == n.options.Rate && now.Sub(session.lastSpike) >= mailNotifierSpikeInterval
+	if spike {
+		session.lastSpike = now
+	}
+	n.Unlock()
+
+	switch {
+	case !ok:
+		go n.notify(fmt.Sprintf("First interaction for %s", correlationID), interaction)
+	case spike:
+		go n.notify(fmt.Sprintf("%d interactions in a minute for %s", n.options.Rate, correlationID), interaction)
+	}
+}
+
+// Remove removes the state of a deregistered session.
+func (n *MailNotifier) Remove(correlationID string) {
+	if n == nil {
+		return
+	}
+	n.Lock()
+	delete(n.sessions, correlationID)
+	n.Unlock()
+}
+
+// prune removes the sessions which are no longer registered
+func (n *MailNotifier) prune() {
+	for correlationID := range n.sessions {
+		if !n.alive(correlationID) {
+			delete(n.sessions, correlationID)
+		}
+	}
+}
+
+// notify sends a notification about an interaction to the operators
+func (n *MailNotifier) notify(subject string, interaction *Interaction) {
+	message := n.message(subject, interaction)
+	if n.options.DKIM != nil {
+		signed, err := n.options.DKIM.Sign(n.options.From[strings.LastIndex(n.options.From, "@")+1:], message)
+		if err != nil {
+			gologger.Warning().Msgf("Could not sign notification email: %s\n", err)
+		} else {
+			message = signed
+		}
+	}
+	var auth smtp.Auth
+	if n.options.Username != "" {
+		host, _, _ := net.SplitHostPort(n.options.Relay)
+		auth = smtp.PlainAuth("", n.options.Username, n.options.Password, host)
+	}
+	if err := n.send(n.options.Relay, auth, n.options.From, n.options.To, message); err != nil {
+		gologger.Warning().Msgf("Could not send notification email: %s\n", err)
+	}
+}
+
+// message returns the email of a notification about an interaction
+func (n *MailNotifier) message(subject string, interaction *Interaction) []byte {
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, "From: %s\r\n", n.options.From)
+	fmt.Fprintf(buffer, "To: %s\r\n", strings.Join(n.options.To, ", "))
+	fmt.Fprintf(buffer, "Subject: [interactsh] %s\r\n", subject)
+	fmt.Fprintf(buffer, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buffer, "Message-ID: <%s@%s>\r\n", xid.New().String(), n.options.From[strings.LastIndex(n.options.From, "@")+1:])
+	buffer.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(buffer, "%s\r\n\r\n", subject)
+	fmt.Fprintf(buffer, "Protocol: %s\r\n", interaction.Protocol)
+	fmt.Fprintf(buffer, "Full ID: %s\r\n", interaction.FullId)
+	if interaction.QType != "" {
+		fmt.Fprintf(buffer, "Query type: %s\r\n", interaction.QType)
+	}
+	fmt.Fprintf(buffer, "Remote address: %s\r\n", interaction.RemoteAddress)
+	fmt.Fprintf(buffer, "Timestamp: %s\r\n", interaction.Timestamp.Format(time.RFC3339))
+	return buffer.Bytes()
+}
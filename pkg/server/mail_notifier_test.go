@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMailNotifier(t *testing.T) {
+	notifier := NewMailNotifier(&MailNotifierOptions{Relay: "127.0.0.1:25", From: "interactsh@example.com", To: []string{"ops@example.net"}, Rate: 3}, func(string) bool { return true })
+	sent := make(chan string, 10)
+	notifier.send = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sent <- string(msg)
+		return nil
+	}
+	receive := func() string {
+		select {
+		case message := <-sent:
+			return message
+		case <-time.After(time.Second):
+			return ""
+		}
+	}
+
+	interaction := &Interaction{Protocol: "dns", FullId: "c23b2la0kl1krjcrdj10cndmnioyyyyyn", RemoteAddress: "203.0.113.7", Timestamp: time.Now()}
+	notifier.Observe("c23b2la0kl1krjcrdj10", interaction)
+	message := receive()
+	require.Contains(t, message, "Subject: [interactsh] First interaction for c23b2la0kl1krjcrdj10", "could not notify first interaction")
+	require.Contains(t, message, "Remote address: 203.0.113.7", "could not describe interaction")
+
+	notifier.Observe("c23b2la0kl1krjcrdj10", interaction)
+	notifier.Observe("c23b2la0kl1krjcrdj10", interaction)
+	require.True(t, strings.Contains(receive(), "3 interactions in a minute"), "could not notify spike")
+	for i := 0; i < 5; i++ {
+		notifier.Observe("c23b2la0kl1krjcrdj10", interaction)
+	}
+	require.Empty(t, receive(), "notified spike twice within the interval")
+
+	notifier.Remove("c23b2la0kl1krjcrdj10")
+	notifier.Observe("c23b2la0kl1krjcrdj10", interaction)
+	require.Contains(t, receive(), "First interaction", "could not reset removed session")
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// dmarcRecord is the dmarc policy of the domains, rejecting the mails
+// spoofing them which aren't aligned with the spf or dkim records
+const dmarcRecord = "v=DMARC1; p=reject; adkim=s; aspf=s"
+
+// spfRecord returns the spf record of the domains, only allowing the
+// server ip to send their mails
+func (h *DNSServer) spfRecord() string {
+	mechanism := "ip4:"
+	if h.ipAddress.To4() == nil {
+		mechanism = "ip6:"
+	}
+	return "v=spf1 " + mechanism + h.ipAddress.String() + " -all"
+}
+
+// handleMailTXT answers the spf, dmarc and dkim txt records of the domains,
+// returning false for the other names. The spf record is answered with the
+// txt record of the domain apex.
+func (h *DNSServer) handleMailTXT(zone string, m *dns.Msg) bool {
+	domain, ok := h.options.domainOf(zone)
+	if !ok {
+		return false
+	}
+	name := strings.ToLower(dns.Fqdn(zone))
+	dotdomain := strings.ToLower(dns.Fqdn(domain))
+	header := dns.RR_Header{Name: zone, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: h.timeToLive}
+	switch {
+	case h.options.MailRecords && name == dotdomain:
+		h.handleTXT(zone, m)
+		m.Answer = append(m.Answer, &dns.TXT{Hdr: header, Txt: []string{h.spfRecord()}})
+	case h.options.MailRecords && name == "_dmarc."+dotdomain:
+		m.Answer = append(m.Answer, &dns.TXT{Hdr: header, Txt: []string{dmarcRecord}})
+	case h.options.DKIM != nil && name == strings.ToLower(h.options.DKIM.Selector())+"._domainkey."+dotdomain:
+		m.Answer = append(m.Answer, &dns.TXT{Hdr: header, Txt: splitTXT(h.options.DKIM.Record())})
+	default:
+		return false
+	}
+	return true
+}
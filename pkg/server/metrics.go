@@ -0,0 +1,250 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pollLatencyBuckets are the upper bounds in seconds of the poll latency histogram
+var pollLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// ingestionLagBuckets are the upper bounds in seconds of the ingestion lag histogram
+var ingestionLagBuckets = []float64{0.0001, 0.001, 0.01, 0.1, 1, 5}
+
+// deliveryLagBuckets are the upper bounds in seconds of the delivery lag histograms
+var deliveryLagBuckets = []float64{0.1, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// histogram is a prometheus histogram of observations in seconds
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(seconds float64) {
+	h.count++
+	h.sum += seconds
+	for i, bucket := range h.buckets {
+		if seconds <= bucket {
+			h.counts[i]++
+		}
+	}
+}
+
+// write writes the samples of the histogram, labels being
+// prepended to the bucket labels (eg. method="poll",)
+func (h *histogram) write(w io.Writer, name, labels string) {
+	for i, bucket := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d\n", name, labels, bucket, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	if labels != "" {
+		labels = "{" + strings.TrimSuffix(labels, ",") + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// Metrics contains the counters exposed by the metrics server.
+type Metrics struct {
+	registrations   uint64
+	deregistrations uint64
+
+	sync.Mutex
+	interactions map[string]uint64
+	rateLimited  map[string]uint64
+	pollLatency  *histogram
+	ingestionLag *histogram
+	deliveryLag  map[string]*histogram
+}
+
+// NewMetrics returns a new metrics instance.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		interactions: make(map[string]uint64),
+		rateLimited:  make(map[string]uint64),
+		pollLatency:  newHistogram(pollLatencyBuckets),
+		ingestionLag: newHistogram(ingestionLagBuckets),
+		deliveryLag:  make(map[string]*histogram),
+	}
+}
+
+// IncInteractions increments the stored interactions of a protocol.
+func (m *Metrics) IncInteractions(protocol string) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	m.interactions[protocol]++
+	m.Unlock()
+}
+
+// IncRateLimited increments the interactions of a protocol dropped by the rate limiter.
+func (m *Metrics) IncRateLimited(protocol string) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	m.rateLimited[protocol]++
+	m.Unlock()
+}
+
+// IncRegistrations increments the registered sessions.
+func (m *Metrics) IncRegistrations() {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.registrations, 1)
+}
+
+// IncDeregistrations increments the deregistered sessions.
+func (m *Metrics) IncDeregistrations() {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.deregistrations, 1)
+}
+
+// ObservePoll records the latency of a poll request.
+func (m *Metrics) ObservePoll(latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	m.pollLatency.observe(latency.Seconds())
+	m.Unlock()
+}
+
+// ObserveIngestion records the lag between the receipt of an interaction by a listener and its storage.
+func (m *Metrics) ObserveIngestion(lag time.Duration) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	m.ingestionLag.observe(lag.Seconds())
+	m.Unlock()
+}
+
+// ObserveDelivery records the lag between the storage of an interaction
+// and its delivery with a method (eg. poll or webhook).
+func (m *Metrics) ObserveDelivery(method string, lag time.Duration) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+
+	deliveryLag, ok := m.deliveryLag[method]
+	if !ok {
+		deliveryLag = newHistogram(deliveryLagBuckets)
+		m.deliveryLag[method] = deliveryLag
+	}
+	deliveryLag.observe(lag.Seconds())
+}
+
+// WritePrometheus writes the metrics and the storage metrics
+// in the prometheus text exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer, options *Options) {
+	m.Lock()
+	protocols := make([]string, 0, len(m.interactions))
+	for protocol := range m.interactions {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+
+	fmt.Fprintf(w, "# HELP interactsh_interactions_total Number of interactions stored per protocol.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_interactions_total counter\n")
+	for _, protocol := range protocols {
+		fmt.Fprintf(w, "interactsh_interactions_total{protocol=%q} %d\n", protocol, m.interactions[protocol])
+	}
+
+	limited := make([]string, 0, len(m.rateLimited))
+	for protocol := range m.rateLimited {
+		limited = append(limited, protocol)
+	}
+	sort.Strings(limited)
+	fmt.Fprintf(w, "# HELP interactsh_rate_limited_total Number of interactions dropped by the per source ip rate limits per protocol.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_rate_limited_total counter\n")
+	for _, protocol := range limited {
+		fmt.Fprintf(w, "interactsh_rate_limited_total{protocol=%q} %d\n", protocol, m.rateLimited[protocol])
+	}
+
+	fmt.Fprintf(w, "# HELP interactsh_poll_duration_seconds Latency of the poll requests.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_poll_duration_seconds histogram\n")
+	m.pollLatency.write(w, "interactsh_poll_duration_seconds", "")
+
+	fmt.Fprintf(w, "# HELP interactsh_ingestion_lag_seconds Time from the receipt of the interactions by the listeners to their storage.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_ingestion_lag_seconds histogram\n")
+	m.ingestionLag.write(w, "interactsh_ingestion_lag_seconds", "")
+
+	methods := make([]string, 0, len(m.deliveryLag))
+	for method := range m.deliveryLag {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	fmt.Fprintf(w, "# HELP interactsh_delivery_lag_seconds Time from the storage of the interactions to their delivery per method.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_delivery_lag_seconds histogram\n")
+	for _, method := range methods {
+		m.deliveryLag[method].write(w, "interactsh_delivery_lag_seconds", fmt.Sprintf("method=%q,", method))
+	}
+	m.Unlock()
+
+	fmt.Fprintf(w, "# HELP interactsh_registrations_total Number of sessions registered.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_registrations_total counter\n")
+	fmt.Fprintf(w, "interactsh_registrations_total %d\n", atomic.LoadUint64(&m.registrations))
+	fmt.Fprintf(w, "# HELP interactsh_deregistrations_total Number of sessions deregistered.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_deregistrations_total counter\n")
+	fmt.Fprintf(w, "interactsh_deregistrations_total %d\n", atomic.LoadUint64(&m.deregistrations))
+
+	storage := options.Storage.GetCacheMetrics()
+	fmt.Fprintf(w, "# HELP interactsh_storage_entries Number of sessions and ids kept in the storage.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_storage_entries gauge\n")
+	fmt.Fprintf(w, "interactsh_storage_entries %d\n", storage.Entries)
+	fmt.Fprintf(w, "# HELP interactsh_storage_evictions_total Number of storage entries evicted.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_storage_evictions_total counter\n")
+	fmt.Fprintf(w, "interactsh_storage_evictions_total %d\n", storage.EvictionCount)
+	fmt.Fprintf(w, "# HELP interactsh_storage_dropped_total Number of interactions dropped by the limit of pending interactions.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_storage_dropped_total counter\n")
+	fmt.Fprintf(w, "interactsh_storage_dropped_total %d\n", storage.DroppedCount)
+	fmt.Fprintf(w, "# HELP interactsh_storage_hits_total Number of storage lookups for existing ids.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_storage_hits_total counter\n")
+	fmt.Fprintf(w, "interactsh_storage_hits_total %d\n", storage.HitCount)
+	fmt.Fprintf(w, "# HELP interactsh_storage_misses_total Number of storage lookups for unknown ids.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_storage_misses_total counter\n")
+	fmt.Fprintf(w, "interactsh_storage_misses_total %d\n", storage.MissCount)
+	fmt.Fprintf(w, "# HELP interactsh_storage_sessions Number of sessions registered with a public key.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_storage_sessions gauge\n")
+	fmt.Fprintf(w, "interactsh_storage_sessions %d\n", storage.Sessions)
+	fmt.Fprintf(w, "# HELP interactsh_storage_pending_interactions Number of interactions stored and not polled yet.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_storage_pending_interactions gauge\n")
+	fmt.Fprintf(w, "interactsh_storage_pending_interactions %d\n", storage.Pending)
+	fmt.Fprintf(w, "# HELP interactsh_storage_pending_bytes Size of the interactions stored and not polled yet.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_storage_pending_bytes gauge\n")
+	fmt.Fprintf(w, "interactsh_storage_pending_bytes %d\n", storage.PendingBytes)
+	fmt.Fprintf(w, "# HELP interactsh_storage_added_total Number of interactions added to the storage.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_storage_added_total counter\n")
+	fmt.Fprintf(w, "interactsh_storage_added_total %d\n", storage.AddedCount)
+	fmt.Fprintf(w, "# HELP interactsh_storage_polled_total Number of interactions returned by the polls.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_storage_polled_total counter\n")
+	fmt.Fprintf(w, "interactsh_storage_polled_total %d\n", storage.PolledCount)
+	fmt.Fprintf(w, "# HELP interactsh_storage_used_bytes Memory or disk used by the redis or sql backend of the storage.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_storage_used_bytes gauge\n")
+	fmt.Fprintf(w, "interactsh_storage_used_bytes %d\n", storage.UsedBytes)
+
+	memStats := &runtime.MemStats{}
+	runtime.ReadMemStats(memStats)
+	fmt.Fprintf(w, "# HELP interactsh_memory_heap_bytes Heap memory allocated by the server.\n")
+	fmt.Fprintf(w, "# TYPE interactsh_memory_heap_bytes gauge\n")
+	fmt.Fprintf(w, "interactsh_memory_heap_bytes %d\n", memStats.HeapAlloc)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsWritePrometheus(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.IncInteractions("dns")
+	metrics.IncInteractions("dns")
+	metrics.IncInteractions("http")
+	metrics.IncRegistrations()
+	metrics.IncRateLimited("smtp")
+	metrics.ObservePoll(20 * time.Millisecond)
+	metrics.ObserveIngestion(time.Millisecond)
+	metrics.ObserveDelivery("poll", 3*time.Second)
+	metrics.ObserveDelivery("webhook", 200*time.Millisecond)
+
+	options := &Options{Storage: storage.New(time.Hour), Metrics: metrics}
+	_ = options.Storage.SetID("token")
+
+	buffer := &bytes.Buffer{}
+	metrics.WritePrometheus(buffer, options)
+	output := buffer.String()
+
+	require.Contains(t, output, "interactsh_interactions_total{protocol=\"dns\"} 2\n", "could not get dns interactions")
+	require.Contains(t, output, "interactsh_interactions_total{protocol=\"http\"} 1\n", "could not get http interactions")
+	require.Contains(t, output, "interactsh_registrations_total 1\n", "could not get registrations")
+	require.Contains(t, output, "interactsh_rate_limited_total{protocol=\"smtp\"} 1\n", "could not get rate limited interactions")
+	require.Contains(t, output, "interactsh_poll_duration_seconds_bucket{le=\"0.01\"} 0\n", "could not get poll latency bucket")
+	require.Contains(t, output, "interactsh_poll_duration_seconds_bucket{le=\"0.05\"} 1\n", "could not get poll latency bucket")
+	require.Contains(t, output, "interactsh_poll_duration_seconds_count 1\n", "could not get poll latency count")
+	require.Contains(t, output, "interactsh_ingestion_lag_seconds_bucket{le=\"0.001\"} 1\n", "could not get ingestion lag bucket")
+	require.Contains(t, output, "interactsh_delivery_lag_seconds_bucket{method=\"poll\",le=\"1\"} 0\n", "could not get poll delivery lag bucket")
+	require.Contains(t, output, "interactsh_delivery_lag_seconds_bucket{method=\"poll\",le=\"5\"} 1\n", "could not get poll delivery lag bucket")
+	require.Contains(t, output, "interactsh_delivery_lag_seconds_count{method=\"webhook\"} 1\n", "could not get webhook delivery lag count")
+	require.Contains(t, output, "interactsh_storage_entries 1\n", "could not get storage entries")
+	require.Contains(t, output, "interactsh_storage_sessions 0\n", "could not get storage sessions")
+	require.Contains(t, output, "interactsh_storage_pending_interactions 0\n", "could not get pending interactions")
+}
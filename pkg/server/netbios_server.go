@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+)
+
+const (
+	netbiosNameServicePort = 137
+	netbiosDatagramPort    = 138
+
+	// netbiosEncodedNameLength is the length of a first-level encoded netbios name
+	netbiosEncodedNameLength = 32
+)
+
+// browserMailslot is the mailslot used by the browser protocol announcements
+var browserMailslot = []byte("\\MAILSLOT\\BROWSE\x00")
+
+// NetBIOSServer is a passive netbios name service and datagram
+// server instance that listens on port 137 and 138.
+type NetBIOSServer struct {
+	options *Options
+
+	sync.Mutex
+	closed bool
+	conns  []net.PacketConn
+}
+
+// NewNetBIOSServer returns a new netbios server.
+func NewNetBIOSServer(options *Options) (*NetBIOSServer, error) {
+	server := &NetBIOSServer{options: options}
+	return server, nil
+}
+
+// ListenAndServe listens on netbios name service and datagram ports.
+func (h *NetBIOSServer) ListenAndServe(nameServiceAlive, datagramAlive chan bool) {
+	go h.listen(netbiosNameServicePort, h.handleNameService, nameServiceAlive)
+	h.listen(netbiosDatagramPort, h.handleDatagram, datagramAlive)
+}
+
+func (h *NetBIOSServer) listen(port int, handler func(net.Addr, []byte), alive chan bool) {
+	conn, err := net.ListenPacket("udp", fmt.Sprintf("%s:%d", h.options.ListenIP, port))
+	if err != nil {
+		gologger.Error().Msgf("Could not listen for netbios on udp port %d: %s\n", port, err)
+		alive <- false
+		return
+	}
+	defer conn.Close()
+	if !h.track(conn) {
+		return
+	}
+	alive <- true
+
+	buffer := make([]byte, 2048)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if h.isClosed() {
+				return
+			}
+			gologger.Error().Msgf("Could not read netbios packet on udp port %d: %s\n", port, err)
+			alive <- false
+			return
+		}
+		packet := make([]byte, n)
+		copy(packet, buffer[:n])
+		go handler(addr, packet)
+	}
+}
+
+// track registers a connection to be closed with the server,
+// returning false if the server has already been closed.
+func (h *NetBIOSServer) track(conn net.PacketConn) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.closed {
+		return false
+	}
+	h.conns = append(h.conns, conn)
+	return true
+}
+
+func (h *NetBIOSServer) isClosed() bool {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.closed
+}
+
+// Close stops the netbios listeners.
+func (h *NetBIOSServer) Close() {
+	h.Lock()
+	defer h.Unlock()
+
+	h.closed = true
+	for _, conn := range h.conns {
+		_ = conn.Close()
+	}
+}
+
+var netbiosOpcodes = map[byte]string{
+	0: "Query",
+	5: "Registration",
+	6: "Release",
+	7: "WACK",
+	8: "Refresh",
+}
+
+// handleNameService handles a packet received on the netbios name service port
+func (h *NetBIOSServer) handleNameService(remoteAddr net.Addr, data []byte) {
+	gologger.Debug().Msgf("New NetBIOS name service packet from %s: %x\n", remoteAddr, data)
+
+	if len(data) < 12 {
+		return
+	}
+	isResponse := data[2]&0x80 != 0
+	if isResponse {
+		return
+	}
+	opcode, ok := netbiosOpcodes[(data[2]>>3)&0x0f]
+	if !ok {
+		opcode = "Unknown"
+	}
+	if binary.BigEndian.Uint16(data[4:6]) == 0 {
+		return
+	}
+	name, suffix, next, err := decodeNetBIOSName(data, 12)
+	if err != nil {
+		gologger.Debug().Msgf("Could not decode netbios name: %s\n", err)
+		return
+	}
+	qtype := "NB"
+	if len(data) >= next+2 && binary.BigEndian.Uint16(data[next:next+2]) == 0x21 {
+		qtype = "NBSTAT"
+	}
+
+	var message strings.Builder
+	message.WriteString(fmt.Sprintf("Type=%s\n", opcode))
+	message.WriteString(fmt.Sprintf("Name=%s\n", name))
+	message.WriteString(fmt.Sprintf("Suffix=<%02X>\n", suffix))
+	message.WriteString(fmt.Sprintf("QType=%s\n", qtype))
+
+	h.recordInteraction(remoteAddr, name, qtype, message.String())
+}
+
+// handleDatagram handles a packet received on the netbios datagram port
+func (h *NetBIOSServer) handleDatagram(remoteAddr net.Addr, data []byte) {
+	gologger.Debug().Msgf("New NetBIOS datagram packet from %s: %x\n", remoteAddr, data)
+
+	// direct unique, direct group and broadcast datagrams are the ones carrying names
+	if len(data) < 14 || data[0] < 0x10 || data[0] > 0x12 {
+		return
+	}
+	sourceName, _, next, err := decodeNetBIOSName(data, 14)
+	if err != nil {
+		gologger.Debug().Msgf("Could not decode netbios source name: %s\n", err)
+		return
+	}
+	destinationName, _, next, err := decodeNetBIOSName(data, next)
+	if err != nil {
+		gologger.Debug().Msgf("Could not decode netbios destination name: %s\n", err)
+		return
+	}
+
+	var message strings.Builder
+	message.WriteString("Type=Datagram\n")
+	message.WriteString(fmt.Sprintf("SourceName=%s\n", sourceName))
+	message.WriteString(fmt.Sprintf("DestinationName=%s\n", destinationName))
+
+	qtype := "DATAGRAM"
+	if announcement, ok := parseBrowserAnnouncement(data[next:]); ok {
+		qtype = announcement.Command
+		message.WriteString(fmt.Sprintf("Command=%s\n", announcement.Command))
+		message.WriteString(fmt.Sprintf("ServerName=%s\n", announcement.ServerName))
+		message.WriteString(fmt.Sprintf("OSVersion=%d.%d\n", announcement.OSMajor, announcement.OSMinor))
+		message.WriteString(fmt.Sprintf("ServerType=0x%08x\n", announcement.ServerType))
+		message.WriteString(fmt.Sprintf("Comment=%s\n", announcement.Comment))
+	}
+
+	h.recordInteraction(remoteAddr, sourceName, qtype, message.String())
+}
+
+func (h *NetBIOSServer) recordInteraction(remoteAddr net.Addr, name, qtype, data string) {
+	host, _, _ := net.SplitHostPort(remoteAddr.String())
+
+	// Correlation id doesn't apply here, we skip encryption
+	interaction := &Interaction{
+		Protocol:      "netbios",
+		UniqueID:      name,
+		FullId:        name,
+		QType:         qtype,
+		RawRequest:    data,
+		RemoteAddress: host,
+		Timestamp:     time.Now(),
+	}
+	h.options.storeInteractionWithId(h.options.GetToken(), interaction)
+}
+
+// decodeNetBIOSName decodes a first-level encoded netbios name (RFC 1001 14.1)
+// starting at offset, returning the name, its suffix and the offset following
+// the name including any scope labels.
+func decodeNetBIOSName(data []byte, offset int) (string, byte, int, error) {
+	if len(data) < offset+1+netbiosEncodedNameLength || int(data[offset]) != netbiosEncodedNameLength {
+		return "", 0, 0, errors.New("invalid netbios name length")
+	}
+	encoded := data[offset+1 : offset+1+netbiosEncodedNameLength]
+	decoded := make([]byte, netbiosEncodedNameLength/2)
+	for i := range decoded {
+		high, low := encoded[2*i]-'A', encoded[2*i+1]-'A'
+		if high > 0x0f || low > 0x0f {
+			return "", 0, 0, errors.New("invalid netbios name encoding")
+		}
+		decoded[i] = high<<4 | low
+	}
+	name := strings.TrimRight(string(decoded[:15]), " \x00")
+	suffix := decoded[15]
+
+	// skip the scope labels up to the root label
+	next := offset + 1 + netbiosEncodedNameLength
+	for next < len(data) && data[next] != 0 {
+		next += int(data[next]) + 1
+	}
+	if next >= len(data) {
+		return "", 0, 0, errors.New("unterminated netbios name")
+	}
+	return name, suffix, next + 1, nil
+}
+
+// browserAnnouncement is a browser protocol announcement sent to \MAILSLOT\BROWSE
+type browserAnnouncement struct {
+	Command    string
+	ServerName string
+	OSMajor    byte
+	OSMinor    byte
+	ServerType uint32
+	Comment    string
+}
+
+var browserCommands = map[byte]string{
+	0x01: "HostAnnouncement",
+	0x0c: "DomainAnnouncement",
+	0x0f: "LocalMasterAnnouncement",
+}
+
+// parseBrowserAnnouncement extracts a host, domain or local master announcement
+// from the smb transaction user data of a netbios datagram.
+func parseBrowserAnnouncement(data []byte) (*browserAnnouncement, bool) {
+	index := bytes.Index(data, browserMailslot)
+	if index == -1 {
+		return nil, false
+	}
+	frame := data[index+len(browserMailslot):]
+	// opcode(1) + update count(1) + periodicity(4) + server name(16) + os version(2) + server type(4)
+	if len(frame) < 28 {
+		return nil, false
+	}
+	command, ok := browserCommands[frame[0]]
+	if !ok {
+		return nil, false
+	}
+	announcement := &browserAnnouncement{
+		Command:    command,
+		ServerName: strings.TrimRight(string(frame[6:22]), "\x00"),
+		OSMajor:    frame[22],
+		OSMinor:    frame[23],
+		ServerType: binary.LittleEndian.Uint32(frame[24:28]),
+	}
+	// browser version(2) + signature(2) precede the null terminated comment
+	if len(frame) > 32 {
+		comment := frame[32:]
+		if end := bytes.IndexByte(comment, 0); end != -1 {
+			comment = comment[:end]
+		}
+		announcement.Comment = string(comment)
+	}
+	return announcement, true
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func encodeNetBIOSName(name string, suffix byte) []byte {
+	raw := make([]byte, 16)
+	copy(raw, fmt.Sprintf("%-15s", name))
+	raw[15] = suffix
+
+	encoded := []byte{netbiosEncodedNameLength}
+	for _, b := range raw {
+		encoded = append(encoded, 'A'+(b>>4), 'A'+(b&0x0f))
+	}
+	return append(encoded, 0)
+}
+
+func TestDecodeNetBIOSName(t *testing.T) {
+	packet := append(make([]byte, 12), encodeNetBIOSName("WPAD", 0x00)...)
+	packet = append(packet, 0x00, 0x20, 0x00, 0x01)
+
+	name, suffix, next, err := decodeNetBIOSName(packet, 12)
+	require.Nil(t, err, "could not decode netbios name")
+	require.Equal(t, "WPAD", name, "could not get correct name")
+	require.Equal(t, byte(0x00), suffix, "could not get correct suffix")
+	require.Equal(t, uint16(0x20), binary.BigEndian.Uint16(packet[next:next+2]), "could not get correct offset")
+
+	_, _, _, err = decodeNetBIOSName([]byte{0x20, 'A'}, 0)
+	require.NotNil(t, err, "could decode truncated netbios name")
+}
+
+func TestParseBrowserAnnouncement(t *testing.T) {
+	frame := []byte{0x01, 0x01, 0x60, 0xea, 0x00, 0x00}
+	serverName := make([]byte, 16)
+	copy(serverName, "WORKSTATION1")
+	frame = append(frame, serverName...)
+	frame = append(frame, 10, 0, 0x03, 0x10, 0x00, 0x00, 15, 1, 0x55, 0xaa)
+	frame = append(frame, []byte("lab host\x00")...)
+
+	data := append([]byte("smb-header"), browserMailslot...)
+	data = append(data, frame...)
+
+	announcement, ok := parseBrowserAnnouncement(data)
+	require.True(t, ok, "could not parse browser announcement")
+	require.Equal(t, "HostAnnouncement", announcement.Command, "could not get correct command")
+	require.Equal(t, "WORKSTATION1", announcement.ServerName, "could not get correct server name")
+	require.Equal(t, byte(10), announcement.OSMajor, "could not get correct os version")
+	require.Equal(t, uint32(0x1003), announcement.ServerType, "could not get correct server type")
+	require.Equal(t, "lab host", announcement.Comment, "could not get correct comment")
+}
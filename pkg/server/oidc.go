@@ -0,0 +1,405 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // hashes of the id token signatures
+	_ "crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+)
+
+const (
+	// oidcLeeway is the clock skew tolerated for the expiration of the id tokens
+	oidcLeeway = time.Minute
+	// oidcRefreshInterval is the minimum interval between two fetches of the
+	// keys of the identity provider, refreshed for the unknown key ids
+	oidcRefreshInterval = time.Minute
+	// maxOIDCDocumentSize is the maximum size of the documents of the identity provider
+	maxOIDCDocumentSize = 1024 * 1024
+)
+
+// OIDCVerifier verifies the id tokens of an OpenID Connect identity provider
+// exchanged by the clients for the token of a tenant named after their
+// identity, so that the access to an internal server can be gated by sso.
+type OIDCVerifier struct {
+	sync.Mutex
+	issuer   string
+	audience string
+	claim    string
+	ttl      time.Duration
+	template Tenant
+	client   *http.Client
+
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// NewOIDCVerifier returns a verifier of the id tokens of an issuer for an
+// audience (the client id), naming the tenants after a claim (eg. email)
+// and issuing them tokens with the limits and scopes of a template
+// expiring after the ttl.
+func NewOIDCVerifier(issuer, audience, claim string, ttl time.Duration, template Tenant) (*OIDCVerifier, error) {
+	if issuer == "" || audience == "" {
+		return nil, errors.New("oidc requires an issuer and an audience")
+	}
+	if err := template.validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid oidc tenant limits")
+	}
+	template.Name, template.Token, template.ExpiresAt = "", "", nil
+	if claim == "" {
+		claim = "sub"
+	}
+	return &OIDCVerifier{
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		audience: audience,
+		claim:    claim,
+		ttl:      ttl,
+		template: template,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		keys:     make(map[string]crypto.PublicKey),
+	}, nil
+}
+
+// oidcHeader is the header of an id token
+type oidcHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// oidcKey is a json web key of the identity provider
+type oidcKey struct {
+	KeyID   string `json:"kid"`
+	KeyType string `json:"kty"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// Verify verifies the signature, issuer, audience and expiration of an id
+// token, returning the value of the claim naming its tenant.
+func (v *OIDCVerifier) Verify(ctx context.Context, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed id token")
+	}
+	var header oidcHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", errors.Wrap(err, "could not decode id token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.Wrap(err, "could not decode id token signature")
+	}
+	keys, err := v.keysOf(ctx, header.KeyID)
+	if err != nil {
+		return "", err
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if err = verifySignature(header.Algorithm, key, signed, signature); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		if err == nil {
+			err = errors.New("unknown key id")
+		}
+		return "", errors.Wrap(err, "could not verify id token signature")
+	}
+
+	claims := make(map[string]interface{})
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", errors.Wrap(err, "could not decode id token claims")
+	}
+	if issuer, _ := claims["iss"].(string); strings.TrimSuffix(issuer, "/") != v.issuer {
+		return "", errors.Errorf("unexpected issuer %s", issuer)
+	}
+	if !v.hasAudience(claims["aud"]) {
+		return "", errors.New("unexpected audience")
+	}
+	now := time.Now()
+	expiry, ok := claims["exp"].(float64)
+	if !ok {
+		return "", errors.New("no expiration")
+	}
+	if now.Add(-oidcLeeway).After(time.Unix(int64(expiry), 0)) {
+		return "", errors.New("id token expired")
+	}
+	if notBefore, ok := claims["nbf"].(float64); ok && now.Add(oidcLeeway).Before(time.Unix(int64(notBefore), 0)) {
+		return "", errors.New("id token not valid yet")
+	}
+	name, _ := claims[v.claim].(string)
+	if name == "" {
+		return "", errors.Errorf("no %s claim", v.claim)
+	}
+	if verified, ok := claims["email_verified"].(bool); ok && !verified && v.claim == "email" {
+		return "", errors.New("email not verified")
+	}
+	return name, nil
+}
+
+// hasAudience returns true if the aud claim (a string or an array) holds the audience
+func (v *OIDCVerifier) hasAudience(audience interface{}) bool {
+	switch value := audience.(type) {
+	case string:
+		return value == v.audience
+	case []interface{}:
+		for _, item := range value {
+			if item == v.audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keysOf returns the keys of the identity provider with a key id (all of them
+// if empty), fetching them again for an unknown key id.
+func (v *OIDCVerifier) keysOf(ctx context.Context, keyID string) ([]crypto.PublicKey, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	if _, ok := v.keys[keyID]; (!ok || keyID == "") && time.Since(v.fetchedAt) > oidcRefreshInterval {
+		if err := v.fetchKeys(ctx); err != nil {
+			if len(v.keys) == 0 {
+				return nil, err
+			}
+			gologger.Warning().Msgf("Could not refresh oidc keys: %s\n", err)
+		}
+	}
+	if keyID != "" {
+		if key, ok := v.keys[keyID]; ok {
+			return []crypto.PublicKey{key}, nil
+		}
+		return nil, nil
+	}
+	keys := make([]crypto.PublicKey, 0, len(v.keys))
+	for _, key := range v.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// fetchKeys fetches the keys of the jwks_uri of the discovery document of the issuer
+func (v *OIDCVerifier) fetchKeys(ctx context.Context) error {
+	v.fetchedAt = time.Now()
+
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := v.fetch(ctx, v.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return errors.Wrap(err, "could not fetch oidc discovery document")
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != v.issuer || discovery.JWKSURI == "" {
+		return errors.New("invalid oidc discovery document")
+	}
+	var jwks struct {
+		Keys []oidcKey `json:"keys"`
+	}
+	if err := v.fetch(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return errors.Wrap(err, "could not fetch oidc keys")
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			gologger.Warning().Msgf("Could not parse oidc key %s: %s\n", jwk.KeyID, err)
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	if len(keys) == 0 {
+		return errors.New("no oidc signing keys")
+	}
+	v.keys = keys
+	return nil
+}
+
+// fetch decodes the json document of an url of the identity provider
+func (v *OIDCVerifier) fetch(ctx context.Context, url string, document interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return jsoniter.NewDecoder(io.LimitReader(resp.Body, maxOIDCDocumentSize)).Decode(document)
+}
+
+// publicKey returns the rsa or ecdsa public key of a json web key
+func (k *oidcKey) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("unsupported curve %s", k.Curve)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid ec point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, errors.Errorf("unsupported key type %s", k.KeyType)
+	}
+}
+
+// verifySignature verifies the signature of an id token with an asymmetric
+// algorithm, the none and hmac algorithms being rejected.
+func verifySignature(algorithm string, key crypto.PublicKey, signed, signature []byte) error {
+	if len(algorithm) != 5 {
+		return errors.Errorf("unsupported algorithm %s", algorithm)
+	}
+	var hash crypto.Hash
+	switch algorithm[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	if hash == 0 {
+		return errors.Errorf("unsupported algorithm %s", algorithm)
+	}
+	hasher := hash.New()
+	_, _ = hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch algorithm[:2] {
+	case "RS", "PS":
+		public, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key type mismatch")
+		}
+		if algorithm[0] == 'P' {
+			return rsa.VerifyPSS(public, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(public, hash, digest, signature)
+	case "ES":
+		public, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("key type mismatch")
+		}
+		size := (public.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid signature length")
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(public, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return errors.Errorf("unsupported algorithm %s", algorithm)
+	}
+}
+
+// decodeSegment decodes a base64url json segment of an id token
+func decodeSegment(segment string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return jsoniter.Unmarshal(data, value)
+}
+
+// decodeInt decodes a base64url big-endian integer of a json web key
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// oidcTokenHandler exchanges the id token of the identity provider sent as a
+// bearer token for the token of a tenant named after its identity, replacing
+// the token previously issued for the identity.
+func (h *HTTPServer) oidcTokenHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		jsonError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	authorization := req.Header.Get("Authorization")
+	idToken := strings.TrimPrefix(authorization, "Bearer ")
+	if idToken == "" || idToken == authorization {
+		jsonError(w, "no bearer id token specified", http.StatusUnauthorized)
+		return
+	}
+	name, err := h.options.OIDC.Verify(req.Context(), idToken)
+	if err != nil {
+		gologger.Debug().Msgf("Could not verify oidc id token: %s\n", err)
+		jsonError(w, fmt.Sprintf("could not verify id token: %s", err), http.StatusUnauthorized)
+		return
+	}
+	setAuditActor(req, name)
+	template := h.options.OIDC.template
+	template.Name = name
+	tenant, err := h.options.Tokens.Reissue(template, h.options.OIDC.ttl)
+	if err != nil {
+		jsonError(w, fmt.Sprintf("could not issue token: %s", err), http.StatusConflict)
+		return
+	}
+	gologger.Info().Msgf("Issued token of tenant %s for its oidc identity\n", name)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = jsoniter.NewEncoder(w).Encode(tenant)
+}
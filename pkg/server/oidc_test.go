@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// testIdentityProvider serves the discovery document and the keys of an oidc issuer
+func testIdentityProvider(t *testing.T, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) *httptest.Server {
+	encode := func(value *big.Int) string { return base64.RawURLEncoding.EncodeToString(value.Bytes()) }
+	var provider *httptest.Server
+	provider = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = jsoniter.NewEncoder(w).Encode(map[string]string{"issuer": provider.URL, "jwks_uri": provider.URL + "/keys"})
+		case "/keys":
+			_ = jsoniter.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+				{"kid": "rsa", "kty": "RSA", "use": "sig", "n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E)))},
+				{"kid": "ec", "kty": "EC", "crv": "P-256", "x": encode(ecKey.X), "y": encode(ecKey.Y)},
+				{"kid": "enc", "kty": "RSA", "use": "enc", "n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E)))},
+			}})
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	t.Cleanup(provider.Close)
+	return provider
+}
+
+// signIDToken returns an id token with the claims signed by a key
+func signIDToken(t *testing.T, algorithm, keyID string, key crypto.Signer, claims map[string]interface{}) string {
+	header, err := jsoniter.Marshal(map[string]string{"alg": algorithm, "kid": keyID, "typ": "JWT"})
+	require.Nil(t, err, "could not encode header")
+	payload, err := jsoniter.Marshal(claims)
+	require.Nil(t, err, "could not encode claims")
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		require.Nil(t, err, "could not sign id token")
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		require.Nil(t, err, "could not sign id token")
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCVerifier(t *testing.T) {
+	rsaKey := testPrivateKey(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err, "could not generate ec key")
+	provider := testIdentityProvider(t, rsaKey, ecKey)
+
+	_, err = NewOIDCVerifier(provider.URL, "interactsh", "email", time.Hour, Tenant{Scopes: []string{"other"}})
+	require.NotNil(t, err, "created verifier with invalid scope")
+	verifier, err := NewOIDCVerifier(provider.URL, "interactsh", "email", time.Hour, Tenant{})
+	require.Nil(t, err, "could not create verifier")
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		claims := map[string]interface{}{"iss": provider.URL, "aud": []string{"other", "interactsh"}, "exp": time.Now().Add(time.Hour).Unix(), "email": "alice@example.com", "email_verified": true}
+		for name, value := range changes {
+			claims[name] = value
+		}
+		return claims
+	}
+	ctx := context.Background()
+
+	name, err := verifier.Verify(ctx, signIDToken(t, "RS256", "rsa", rsaKey, claims(nil)))
+	require.Nil(t, err, "could not verify rsa id token")
+	require.Equal(t, "alice@example.com", name, "could not get claim")
+	name, err = verifier.Verify(ctx, signIDToken(t, "ES256", "ec", ecKey, claims(map[string]interface{}{"aud": "interactsh"})))
+	require.Nil(t, err, "could not verify ec id token")
+	require.Equal(t, "alice@example.com", name, "could not get claim")
+	_, err = verifier.Verify(ctx, signIDToken(t, "RS256", "", rsaKey, claims(nil)))
+	require.Nil(t, err, "could not verify id token without key id")
+
+	for reason, token := range map[string]string{
+		"expired":            signIDToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"other audience":     signIDToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"aud": "other"})),
+		"other issuer":       signIDToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"iss": "https://evil.example.com"})),
+		"unverified email":   signIDToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"email_verified": false})),
+		"encryption key":     signIDToken(t, "RS256", "enc", rsaKey, claims(nil)),
+		"key type mismatch":  signIDToken(t, "RS256", "ec", rsaKey, claims(nil)),
+		"none algorithm":     strings.Join(strings.Split(signIDToken(t, "none", "rsa", rsaKey, claims(nil)), ".")[:2], ".") + ".",
+		"tampered token":     signIDToken(t, "RS256", "rsa", rsaKey, claims(nil))[:40] + "x" + signIDToken(t, "RS256", "rsa", rsaKey, claims(nil))[41:],
+		"malformed id token": "token",
+	} {
+		_, err := verifier.Verify(ctx, token)
+		require.NotNil(t, err, "verified id token with %s", reason)
+	}
+}
+
+func TestOIDCTokenExchange(t *testing.T) {
+	rsaKey := testPrivateKey(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err, "could not generate ec key")
+	provider := testIdentityProvider(t, rsaKey, ecKey)
+
+	verifier, err := NewOIDCVerifier(provider.URL, "interactsh", "email", time.Hour, Tenant{MaxSessions: 2, PollsPerMinute: 1, Scopes: []string{ScopeRegister, ScopePoll}})
+	require.Nil(t, err, "could not create verifier")
+	store := storage.New(time.Hour)
+	tokens, err := NewTokenStore(nil, store.HasID)
+	require.Nil(t, err, "could not create token store")
+	options := &Options{Domain: "example.com", Storage: store, Auth: true, Token: "server-token", Tokens: tokens, OIDC: verifier}
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+	exchange := func(authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/oidc/token", nil)
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		server.nontlsserver.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	idToken := signIDToken(t, "RS256", "rsa", rsaKey, map[string]interface{}{"iss": provider.URL, "aud": "interactsh", "exp": time.Now().Add(time.Hour).Unix(), "email": "alice@example.com"})
+
+	require.Equal(t, http.StatusUnauthorized, exchange(idToken).Code, "exchanged id token without bearer")
+	rec := exchange("Bearer " + idToken)
+	require.Equal(t, http.StatusOK, rec.Code, "could not exchange id token")
+	first := &Tenant{}
+	require.Nil(t, jsoniter.Unmarshal(rec.Body.Bytes(), first), "could not decode tenant")
+	require.Equal(t, "alice@example.com", first.Name, "could not name tenant after claim")
+	require.NotNil(t, first.ExpiresAt, "could not expire exchanged token")
+	tenant, ok := tokens.Lookup(first.Token)
+	require.True(t, ok, "could not authenticate with exchanged token")
+	require.Equal(t, "alice@example.com", tenant.Name, "could not authenticate tenant")
+	require.Equal(t, 2, tenant.MaxSessions, "could not limit oidc tenant")
+	require.False(t, tenant.allows(ScopeDeregister), "could not scope oidc tenant")
+	allowed, _ := tokens.AllowPoll(tenant)
+	require.True(t, allowed, "could not poll")
+
+	rec = exchange("Bearer " + idToken)
+	require.Equal(t, http.StatusOK, rec.Code, "could not exchange id token again")
+	_, ok = tokens.Lookup(first.Token)
+	require.False(t, ok, "could not replace previously exchanged token")
+	second := &Tenant{}
+	require.Nil(t, jsoniter.Unmarshal(rec.Body.Bytes(), second), "could not decode tenant")
+	tenant, ok = tokens.Lookup(second.Token)
+	require.True(t, ok, "could not authenticate with exchanged token")
+	allowed, _ = tokens.AllowPoll(tenant)
+	require.False(t, allowed, "could not keep rate limits of replaced token")
+}
@@ -0,0 +1,393 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxPCAPFiles is the number of pcap snippets kept before the oldest ones are removed
+	maxPCAPFiles = 10000
+	// maxCapturedBytes is the number of bytes captured per connection
+	maxCapturedBytes = 1024 * 1024
+	// pcapSegmentSize is the size of the tcp segments the captured data is split into
+	pcapSegmentSize = 1460
+	// pcapLinkTypeRaw is the link type of the raw ip packets
+	pcapLinkTypeRaw = 101
+)
+
+// PCAPWriter writes a pcap snippet of the packets of every interaction to a
+// directory, so that the raw traffic can be inspected when the parsers
+// couldn't decode it. The oldest snippets are removed once maxPCAPFiles
+// snippets have been written.
+type PCAPWriter struct {
+	directory string
+
+	mutex sync.Mutex
+	files []string
+}
+
+// NewPCAPWriter returns a new pcap writer creating the snippets in the directory.
+func NewPCAPWriter(directory string) (*PCAPWriter, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, errors.Wrap(err, "could not create pcap directory")
+	}
+	return &PCAPWriter{directory: directory}, nil
+}
+
+// Write writes the packets captured for an interaction, returning the path of the snippet.
+func (p *PCAPWriter) Write(interaction *Interaction, capture *packetCapture) (string, error) {
+	name := fmt.Sprintf("%s-%s-%s.pcap", interaction.Timestamp.UTC().Format("20060102T150405.000000000"), interaction.Protocol, sanitizeFileName(interaction.UniqueID))
+	path := filepath.Join(p.directory, name)
+	if err := ioutil.WriteFile(path, capture.pcap(), 0600); err != nil {
+		return "", errors.Wrap(err, "could not write pcap")
+	}
+
+	p.mutex.Lock()
+	p.files = append(p.files, path)
+	var removed []string
+	if len(p.files) > maxPCAPFiles {
+		removed = append(removed, p.files[:len(p.files)-maxPCAPFiles]...)
+		p.files = append([]string(nil), p.files[len(p.files)-maxPCAPFiles:]...)
+	}
+	p.mutex.Unlock()
+
+	for _, file := range removed {
+		_ = os.Remove(file)
+	}
+	return path, nil
+}
+
+// sanitizeFileName replaces the characters of a unique id invalid in file names
+func sanitizeFileName(value string) string {
+	if len(value) > 64 {
+		value = value[:64]
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, value)
+}
+
+// packetCapture is the traffic of a connection or a datagram exchange,
+// replayed as ip packets in the pcap snippets.
+type packetCapture struct {
+	network  string
+	client   net.Addr
+	server   net.Addr
+	started  time.Time
+	segments []packetSegment
+}
+
+// packetSegment is data received or sent on a connection
+type packetSegment struct {
+	time       time.Time
+	fromServer bool
+	data       []byte
+}
+
+// captureDNS returns the capture of a dns query and its response
+func captureDNS(w dns.ResponseWriter, r, m *dns.Msg) *packetCapture {
+	request, err := r.Pack()
+	if err != nil {
+		return nil
+	}
+	response, err := m.Pack()
+	if err != nil {
+		return nil
+	}
+	network := "udp"
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		network = "tcp"
+		request = append([]byte{byte(len(request) >> 8), byte(len(request))}, request...)
+		response = append([]byte{byte(len(response) >> 8), byte(len(response))}, response...)
+	}
+	now := time.Now()
+	return &packetCapture{
+		network: network,
+		client:  w.RemoteAddr(),
+		server:  w.LocalAddr(),
+		started: now,
+		segments: []packetSegment{
+			{time: now, data: request},
+			{time: now, fromServer: true, data: response},
+		},
+	}
+}
+
+// pcap encodes the capture as a pcap file of raw ip packets
+func (c *packetCapture) pcap() []byte {
+	buffer := &bytes.Buffer{}
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], 65535)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkTypeRaw)
+	buffer.Write(header)
+
+	clientIP, clientPort := addrIPPort(c.client)
+	serverIP, serverPort := addrIPPort(c.server)
+	if clientIP.To4() == nil || serverIP.To4() == nil {
+		clientIP, serverIP = clientIP.To16(), serverIP.To16()
+	} else {
+		clientIP, serverIP = clientIP.To4(), serverIP.To4()
+	}
+	packets := &pcapPackets{buffer: buffer, clientIP: clientIP, serverIP: serverIP, clientPort: clientPort, serverPort: serverPort}
+
+	if c.network == "udp" {
+		for _, segment := range c.segments {
+			packets.udp(segment.time, segment.fromServer, segment.data)
+		}
+		return buffer.Bytes()
+	}
+	// the handshake isn't captured, a synthetic one lets the dissectors follow the stream
+	var clientSeq, serverSeq uint32 = 1, 1
+	packets.tcp(c.started, false, 0, 0, tcpFlagSYN, nil)
+	packets.tcp(c.started, true, 0, 1, tcpFlagSYN|tcpFlagACK, nil)
+	packets.tcp(c.started, false, 1, 1, tcpFlagACK, nil)
+	for _, segment := range c.segments {
+		for data := segment.data; len(data) > 0; {
+			size := len(data)
+			if size > pcapSegmentSize {
+				size = pcapSegmentSize
+			}
+			if segment.fromServer {
+				packets.tcp(segment.time, true, serverSeq, clientSeq, tcpFlagPSH|tcpFlagACK, data[:size])
+				serverSeq += uint32(size)
+			} else {
+				packets.tcp(segment.time, false, clientSeq, serverSeq, tcpFlagPSH|tcpFlagACK, data[:size])
+				clientSeq += uint32(size)
+			}
+			data = data[size:]
+		}
+	}
+	return buffer.Bytes()
+}
+
+func addrIPPort(addr net.Addr) (net.IP, uint16) {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP, uint16(addr.Port)
+	case *net.UDPAddr:
+		return addr.IP, uint16(addr.Port)
+	}
+	return net.IPv4zero, 0
+}
+
+const (
+	tcpFlagSYN = 0x02
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+)
+
+// pcapPackets writes the ip packets of a capture to a pcap file
+type pcapPackets struct {
+	buffer     *bytes.Buffer
+	clientIP   net.IP
+	serverIP   net.IP
+	clientPort uint16
+	serverPort uint16
+}
+
+func (p *pcapPackets) endpoints(fromServer bool) (net.IP, net.IP, uint16, uint16) {
+	if fromServer {
+		return p.serverIP, p.clientIP, p.serverPort, p.clientPort
+	}
+	return p.clientIP, p.serverIP, p.clientPort, p.serverPort
+}
+
+func (p *pcapPackets) tcp(t time.Time, fromServer bool, seq, ack uint32, flags byte, data []byte) {
+	src, dst, srcPort, dstPort := p.endpoints(fromServer)
+	segment := make([]byte, 20+len(data))
+	binary.BigEndian.PutUint16(segment[0:], srcPort)
+	binary.BigEndian.PutUint16(segment[2:], dstPort)
+	binary.BigEndian.PutUint32(segment[4:], seq)
+	binary.BigEndian.PutUint32(segment[8:], ack)
+	segment[12] = 5 << 4
+	segment[13] = flags
+	binary.BigEndian.PutUint16(segment[14:], 65535)
+	copy(segment[20:], data)
+	binary.BigEndian.PutUint16(segment[16:], transportChecksum(src, dst, 6, segment))
+	p.write(t, src, dst, 6, segment)
+}
+
+func (p *pcapPackets) udp(t time.Time, fromServer bool, data []byte) {
+	src, dst, srcPort, dstPort := p.endpoints(fromServer)
+	datagram := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint16(datagram[0:], srcPort)
+	binary.BigEndian.PutUint16(datagram[2:], dstPort)
+	binary.BigEndian.PutUint16(datagram[4:], uint16(len(datagram)))
+	copy(datagram[8:], data)
+	binary.BigEndian.PutUint16(datagram[6:], transportChecksum(src, dst, 17, datagram))
+	p.write(t, src, dst, 17, datagram)
+}
+
+// write writes an ip packet carrying a transport payload as a pcap record
+func (p *pcapPackets) write(t time.Time, src, dst net.IP, protocol byte, payload []byte) {
+	var packet []byte
+	if len(src) == net.IPv4len {
+		packet = make([]byte, 20, 20+len(payload))
+		packet[0] = 0x45
+		binary.BigEndian.PutUint16(packet[2:], uint16(20+len(payload)))
+		binary.BigEndian.PutUint16(packet[6:], 0x4000)
+		packet[8] = 64
+		packet[9] = protocol
+		copy(packet[12:], src)
+		copy(packet[16:], dst)
+		binary.BigEndian.PutUint16(packet[10:], checksum(packet, 0))
+	} else {
+		packet = make([]byte, 40, 40+len(payload))
+		packet[0] = 0x60
+		binary.BigEndian.PutUint16(packet[4:], uint16(len(payload)))
+		packet[6] = protocol
+		packet[7] = 64
+		copy(packet[8:], src)
+		copy(packet[24:], dst)
+	}
+	packet = append(packet, payload...)
+
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(t.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+	p.buffer.Write(record)
+	p.buffer.Write(packet)
+}
+
+// transportChecksum returns the tcp/udp checksum of a segment with the ip pseudo header
+func transportChecksum(src, dst net.IP, protocol byte, segment []byte) uint16 {
+	var sum uint32
+	for _, ip := range []net.IP{src, dst} {
+		for i := 0; i+1 < len(ip); i += 2 {
+			sum += uint32(ip[i])<<8 | uint32(ip[i+1])
+		}
+	}
+	sum += uint32(protocol) + uint32(len(segment))
+	value := checksum(segment, sum)
+	if value == 0 && protocol == 17 {
+		return 0xffff
+	}
+	return value
+}
+
+// checksum returns the internet checksum of data added to an initial sum
+func checksum(data []byte, sum uint32) uint16 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// packetRecorder records the traffic of the connections accepted by a
+// server, by remote address, until they are closed. It is disabled when nil.
+type packetRecorder struct {
+	conns sync.Map
+}
+
+// Listener wraps a listener recording the traffic of accepted connections.
+func (r *packetRecorder) Listener(listener net.Listener) net.Listener {
+	if r == nil {
+		return listener
+	}
+	return &packetListener{Listener: listener, recorder: r}
+}
+
+// Get returns a copy of the traffic recorded for a remote address, if any.
+func (r *packetRecorder) Get(remoteAddr string) *packetCapture {
+	if r == nil {
+		return nil
+	}
+	value, ok := r.conns.Load(remoteAddr)
+	if !ok {
+		return nil
+	}
+	conn := value.(*packetConn)
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	capture := &packetCapture{network: "tcp", client: conn.RemoteAddr(), server: conn.LocalAddr(), started: conn.started}
+	capture.segments = append(capture.segments, conn.segments...)
+	return capture
+}
+
+type packetListener struct {
+	net.Listener
+	recorder *packetRecorder
+}
+
+func (l *packetListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	packetConn := &packetConn{Conn: conn, recorder: l.recorder, started: time.Now()}
+	l.recorder.conns.Store(conn.RemoteAddr().String(), packetConn)
+	return packetConn, nil
+}
+
+// packetConn records the data read from and written to the connection
+type packetConn struct {
+	net.Conn
+	recorder *packetRecorder
+	started  time.Time
+
+	mutex    sync.Mutex
+	size     int
+	segments []packetSegment
+}
+
+func (c *packetConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.record(false, b[:n])
+	}
+	return n, err
+}
+
+func (c *packetConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.record(true, b[:n])
+	}
+	return n, err
+}
+
+func (c *packetConn) record(fromServer bool, data []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.size+len(data) > maxCapturedBytes {
+		data = data[:maxCapturedBytes-c.size]
+	}
+	if len(data) == 0 {
+		return
+	}
+	c.size += len(data)
+	c.segments = append(c.segments, packetSegment{time: time.Now(), fromServer: fromServer, data: append([]byte(nil), data...)})
+}
+
+func (c *packetConn) Close() error {
+	c.recorder.conns.Delete(c.Conn.RemoteAddr().String())
+	return c.Conn.Close()
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPCAPWriter(t *testing.T) {
+	writer, err := NewPCAPWriter(t.TempDir())
+	require.Nil(t, err, "could not create pcap writer")
+
+	now := time.Now()
+	capture := &packetCapture{
+		network: "tcp",
+		client:  &net.TCPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40000},
+		server:  &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 80},
+		started: now,
+		segments: []packetSegment{
+			{time: now, data: make([]byte, 2000)},
+			{time: now, fromServer: true, data: []byte("HTTP/1.1 200 OK\r\n\r\n")},
+		},
+	}
+	interaction := &Interaction{Protocol: "http", UniqueID: "c23b2la0kl1krjcrdj10cndmnioyyyyyn/..", Timestamp: now}
+	path, err := writer.Write(interaction, capture)
+	require.Nil(t, err, "could not write pcap")
+	require.Contains(t, path, "-http-c23b2la0kl1krjcrdj10cndmnioyyyyyn_..", "could not sanitize file name")
+
+	data, err := ioutil.ReadFile(path)
+	require.Nil(t, err, "could not read pcap")
+	require.Equal(t, uint32(0xa1b2c3d4), binary.LittleEndian.Uint32(data), "invalid pcap magic")
+
+	// handshake, request split in two segments and response
+	var packets []int
+	for offset := 24; offset < len(data); {
+		size := int(binary.LittleEndian.Uint32(data[offset+8:]))
+		packet := data[offset+16 : offset+16+size]
+		require.Equal(t, uint16(0), checksum(packet[:20], 0), "invalid ip checksum")
+		packets = append(packets, size-40)
+		offset += 16 + size
+	}
+	require.Equal(t, []int{0, 0, 0, 1460, 540, 19}, packets, "invalid tcp segments")
+}
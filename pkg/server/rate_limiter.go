@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultRateLimit is the protocol name of the limit applying to
+// the protocols without a limit of their own
+const DefaultRateLimit = "default"
+
+// maxRateLimitedSources is the number of buckets kept before
+// the ones refilled since their last interaction are pruned
+const maxRateLimitedSources = 100000
+
+// RateLimit is the rate of interactions a source ip can record for a protocol.
+type RateLimit struct {
+	// Rate is the number of interactions per second
+	Rate float64
+	// Burst is the number of interactions recorded at once before being limited
+	Burst int
+}
+
+// RateLimiter limits the interactions recorded per source ip with token
+// buckets, so that a noisy source can't fill the storage and evict the
+// interactions of the other ones.
+type RateLimiter struct {
+	limits map[string]RateLimit
+
+	sync.Mutex
+	buckets map[rateLimitKey]*tokenBucket
+	pruned  time.Time
+}
+
+type rateLimitKey struct {
+	protocol string
+	ip       string
+}
+
+// tokenBucket is the bucket of a source ip for a protocol
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a new rate limiter for the limits keyed by protocol,
+// DefaultRateLimit applying to the protocols without a limit.
+func NewRateLimiter(limits map[string]RateLimit) *RateLimiter {
+	return &RateLimiter{limits: limits, buckets: make(map[rateLimitKey]*tokenBucket)}
+}
+
+// Allow takes a token from the bucket of the source address for a
+// protocol, returning false if the source has exceeded its rate.
+func (r *RateLimiter) Allow(protocol, address string) bool {
+	if r == nil {
+		return true
+	}
+	name := protocol
+	limit, ok := r.limits[name]
+	if !ok {
+		name = DefaultRateLimit
+		if limit, ok = r.limits[name]; !ok {
+			return true
+		}
+	}
+	ip := address
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		ip = host
+	}
+	key := rateLimitKey{protocol: name, ip: ip}
+	now := time.Now()
+
+	r.Lock()
+	defer r.Unlock()
+
+	bucket, ok := r.buckets[key]
+	if !ok {
+		if len(r.buckets) >= maxRateLimitedSources && now.Sub(r.pruned) >= time.Second {
+			r.prune(now)
+			r.pruned = now
+		}
+		if len(r.buckets) >= maxRateLimitedSources {
+			// too many distinct sources to limit each of them (eg. spoofed udp sources)
+			return true
+		}
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		r.buckets[key] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * limit.Rate
+	if bucket.tokens > float64(limit.Burst) {
+		bucket.tokens = float64(limit.Burst)
+	}
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// prune removes the buckets refilled since their last interaction,
+// which are identical to the buckets of new sources
+func (r *RateLimiter) prune(now time.Time) {
+	for key, bucket := range r.buckets {
+		limit := r.limits[key.protocol]
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(r.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(map[string]RateLimit{
+		"dns":            {Rate: 0.001, Burst: 2},
+		DefaultRateLimit: {Rate: 0.001, Burst: 1},
+	})
+
+	require.True(t, limiter.Allow("dns", "8.8.8.8"), "could not allow first query")
+	require.True(t, limiter.Allow("dns", "8.8.8.8:53"), "could not allow burst query")
+	require.False(t, limiter.Allow("dns", "8.8.8.8"), "could allow query above the burst")
+	require.True(t, limiter.Allow("dns", "1.1.1.1"), "could not allow query of another source")
+
+	require.True(t, limiter.Allow("http", "8.8.8.8"), "could not allow request of another protocol")
+	require.False(t, limiter.Allow("smtp", "8.8.8.8"), "could allow request above the default limit")
+
+	var disabled *RateLimiter
+	require.True(t, disabled.Allow("dns", "8.8.8.8"), "could limit without limits")
+	require.True(t, NewRateLimiter(map[string]RateLimit{"dns": {Rate: 1, Burst: 1}}).Allow("http", "8.8.8.8"), "could limit protocol without limit")
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultMaxResolvers is the maximum number of resolvers tracked at once
+	defaultMaxResolvers = 10000
+	// maxTrackedQueries is the number of recent queries kept per resolver
+	// to detect retries and tcp fallbacks
+	maxTrackedQueries = 64
+	// retryWindow is the maximum delay between two identical queries
+	// for the second one to be considered a retry
+	retryWindow = 10 * time.Second
+)
+
+// ResolverBehavior contains the characteristics observed for a resolver
+// across all the dns queries it has sent to the server.
+type ResolverBehavior struct {
+	// IP is the ip address of the resolver
+	IP string `json:"ip"`
+	// Queries is the total number of queries received from the resolver
+	Queries uint64 `json:"queries"`
+	// UDPQueries is the number of queries received over udp
+	UDPQueries uint64 `json:"udp-queries"`
+	// TCPQueries is the number of queries received over tcp
+	TCPQueries uint64 `json:"tcp-queries"`
+	// TCPFallbacks is the number of queries retried over tcp after udp
+	TCPFallbacks uint64 `json:"tcp-fallbacks"`
+	// EDNSQueries is the number of queries with an EDNS0 OPT record
+	EDNSQueries uint64 `json:"edns-queries"`
+	// EDNSBufferSize is the largest EDNS0 udp buffer size advertised
+	EDNSBufferSize uint16 `json:"edns-buffer-size,omitempty"`
+	// DNSSECOKQueries is the number of queries with the DNSSEC OK bit set
+	DNSSECOKQueries uint64 `json:"dnssec-ok-queries"`
+	// ECSQueries is the number of queries with an EDNS0 client subnet option
+	ECSQueries uint64 `json:"ecs-queries"`
+	// Mixed0x20Queries is the number of queries using 0x20 case randomization
+	Mixed0x20Queries uint64 `json:"0x20-queries"`
+	// Retries is the number of identical queries repeated within the retry window
+	Retries uint64 `json:"retries"`
+	// AverageRetryDelay is the average delay before the resolver retries a query,
+	// which is the typical latency the resolver tolerates before timing out
+	AverageRetryDelay time.Duration `json:"average-retry-delay"`
+	// FirstSeen is the timestamp of the first query of the resolver
+	FirstSeen time.Time `json:"first-seen"`
+	// LastSeen is the timestamp of the last query of the resolver
+	LastSeen time.Time `json:"last-seen"`
+
+	totalRetryDelay time.Duration
+	recentQueries   map[string]recentQuery
+}
+
+type recentQuery struct {
+	network   string
+	timestamp time.Time
+}
+
+// ResolverStats is a cache of the behavior of the resolvers
+// querying the server, keyed by resolver ip.
+type ResolverStats struct {
+	sync.Mutex
+	maxResolvers int
+	resolvers    map[string]*ResolverBehavior
+}
+
+// NewResolverStats returns a new resolver behavior cache.
+func NewResolverStats() *ResolverStats {
+	return &ResolverStats{
+		maxResolvers: defaultMaxResolvers,
+		resolvers:    make(map[string]*ResolverBehavior),
+	}
+}
+
+// Record records the characteristics of a query received from the resolver.
+func (s *ResolverStats) Record(ip, network string, r *dns.Msg) {
+	if len(r.Question) == 0 {
+		return
+	}
+	question := r.Question[0]
+	now := time.Now()
+
+	s.Lock()
+	defer s.Unlock()
+
+	resolver, ok := s.resolvers[ip]
+	if !ok {
+		if len(s.resolvers) >= s.maxResolvers {
+			s.evictOldest()
+		}
+		resolver = &ResolverBehavior{IP: ip, FirstSeen: now, recentQueries: make(map[string]recentQuery)}
+		s.resolvers[ip] = resolver
+	}
+	resolver.Queries++
+	resolver.LastSeen = now
+
+	if network == "tcp" {
+		resolver.TCPQueries++
+	} else {
+		resolver.UDPQueries++
+	}
+	if opt := r.IsEdns0(); opt != nil {
+		resolver.EDNSQueries++
+		if opt.UDPSize() > resolver.EDNSBufferSize {
+			resolver.EDNSBufferSize = opt.UDPSize()
+		}
+		if opt.Do() {
+			resolver.DNSSECOKQueries++
+		}
+		for _, option := range opt.Option {
+			if option.Option() == dns.EDNS0SUBNET {
+				resolver.ECSQueries++
+				break
+			}
+		}
+	}
+	if question.Name != strings.ToLower(question.Name) && question.Name != strings.ToUpper(question.Name) {
+		resolver.Mixed0x20Queries++
+	}
+
+	key := strings.ToLower(question.Name) + "/" + dns.TypeToString[question.Qtype]
+	if previous, ok := resolver.recentQueries[key]; ok && now.Sub(previous.timestamp) <= retryWindow {
+		if previous.network == "udp" && network == "tcp" {
+			resolver.TCPFallbacks++
+		} else if previous.network == network {
+			resolver.Retries++
+			resolver.totalRetryDelay += now.Sub(previous.timestamp)
+			resolver.AverageRetryDelay = resolver.totalRetryDelay / time.Duration(resolver.Retries)
+		}
+	}
+	if len(resolver.recentQueries) >= maxTrackedQueries {
+		resolver.recentQueries = make(map[string]recentQuery)
+	}
+	resolver.recentQueries[key] = recentQuery{network: network, timestamp: now}
+}
+
+// evictOldest removes the resolver seen the least recently
+func (s *ResolverStats) evictOldest() {
+	var oldest *ResolverBehavior
+	for _, resolver := range s.resolvers {
+		if oldest == nil || resolver.LastSeen.Before(oldest.LastSeen) {
+			oldest = resolver
+		}
+	}
+	if oldest != nil {
+		delete(s.resolvers, oldest.IP)
+	}
+}
+
+// Get returns the behavior observed for a resolver ip.
+func (s *ResolverStats) Get(ip string) (ResolverBehavior, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	resolver, ok := s.resolvers[ip]
+	if !ok {
+		return ResolverBehavior{}, false
+	}
+	return *resolver, true
+}
+
+// List returns the behavior of all the resolvers, most active first.
+func (s *ResolverStats) List() []ResolverBehavior {
+	s.Lock()
+	resolvers := make([]ResolverBehavior, 0, len(s.resolvers))
+	for _, resolver := range s.resolvers {
+		resolvers = append(resolvers, *resolver)
+	}
+	s.Unlock()
+
+	sort.Slice(resolvers, func(i, j int) bool {
+		return resolvers[i].Queries > resolvers[j].Queries
+	})
+	return resolvers
+}
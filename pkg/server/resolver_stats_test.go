@@ -0,0 +1,36 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolverStatsRecord(t *testing.T) {
+	stats := NewResolverStats()
+
+	query := new(dns.Msg)
+	query.SetQuestion("c23B2la0kL1krjcrdj10cndmnioyyyyyn.Interactsh.com.", dns.TypeA)
+	query.SetEdns0(4096, true)
+
+	stats.Record("8.8.8.8", "udp", query)
+	stats.Record("8.8.8.8", "udp", query)
+	stats.Record("8.8.8.8", "tcp", query)
+
+	resolver, ok := stats.Get("8.8.8.8")
+	require.True(t, ok, "could not get resolver")
+	require.Equal(t, uint64(3), resolver.Queries, "could not get correct queries")
+	require.Equal(t, uint64(2), resolver.UDPQueries, "could not get correct udp queries")
+	require.Equal(t, uint64(1), resolver.TCPQueries, "could not get correct tcp queries")
+	require.Equal(t, uint64(1), resolver.TCPFallbacks, "could not get correct tcp fallbacks")
+	require.Equal(t, uint64(1), resolver.Retries, "could not get correct retries")
+	require.Equal(t, uint64(3), resolver.EDNSQueries, "could not get correct edns queries")
+	require.Equal(t, uint16(4096), resolver.EDNSBufferSize, "could not get correct edns buffer size")
+	require.Equal(t, uint64(3), resolver.DNSSECOKQueries, "could not get correct dnssec ok queries")
+	require.Equal(t, uint64(3), resolver.Mixed0x20Queries, "could not get correct 0x20 queries")
+
+	_, ok = stats.Get("1.1.1.1")
+	require.False(t, ok, "got unknown resolver")
+	require.Len(t, stats.List(), 1, "could not list resolvers")
+}
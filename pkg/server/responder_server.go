@@ -1,7 +1,6 @@
 package server
 
 import (
-	"bytes"
 	"io/ioutil"
 	"net"
 	"os"
@@ -10,9 +9,7 @@ import (
 	"strings"
 	"time"
 
-	jsoniter "github.com/json-iterator/go"
 	"github.com/projectdiscovery/fileutil"
-	"github.com/projectdiscovery/gologger"
 	"github.com/projectdiscovery/interactsh/pkg/filewatcher"
 	"github.com/projectdiscovery/stringsutil"
 )
@@ -92,15 +89,7 @@ func (h *ResponderServer) ListenAndServe(responderAlive chan bool) error {
 						RawRequest: responderData,
 						Timestamp:  time.Now(),
 					}
-					buffer := &bytes.Buffer{}
-					if err := jsoniter.NewEncoder(buffer).Encode(interaction); err != nil {
-						gologger.Warning().Msgf("Could not encode responder interaction: %s\n", err)
-					} else {
-						gologger.Debug().Msgf("Responder Interaction: \n%s\n", buffer.String())
-						if err := h.options.Storage.AddInteractionWithId(h.options.Token, buffer.Bytes()); err != nil {
-							gologger.Warning().Msgf("Could not store dns interaction: %s\n", err)
-						}
-					}
+					h.options.storeInteractionWithId(h.options.GetToken(), interaction)
 				}
 			}
 		}
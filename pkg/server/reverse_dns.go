@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// reverseDNSTimeout is the maximum time waited for a ptr lookup
+	reverseDNSTimeout = 2 * time.Second
+	// reverseDNSCacheTTL is the time the hostname of an ip is cached for
+	reverseDNSCacheTTL = time.Hour
+	// maxReverseDNSCacheSize is the number of ips cached before the expired ones are pruned
+	maxReverseDNSCacheSize = 10000
+	// maxReverseDNSLookups is the number of concurrent ptr lookups
+	maxReverseDNSLookups = 32
+)
+
+// ReverseDNS looks up the ptr hostname of the remote addresses of the
+// interactions in the background, caching the results so that the
+// resolvers and crawlers hitting the server repeatedly are looked up once.
+type ReverseDNS struct {
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+	lookups    chan struct{}
+
+	mutex sync.Mutex
+	cache map[string]*reverseDNSEntry
+}
+
+// reverseDNSEntry is the lookup of an ip, done being closed once hostname is set
+type reverseDNSEntry struct {
+	hostname string
+	expires  time.Time
+	done     chan struct{}
+}
+
+// NewReverseDNS returns a new reverse dns pipeline using the system resolver.
+func NewReverseDNS() *ReverseDNS {
+	return &ReverseDNS{
+		lookupAddr: net.DefaultResolver.LookupAddr,
+		lookups:    make(chan struct{}, maxReverseDNSLookups),
+		cache:      make(map[string]*reverseDNSEntry),
+	}
+}
+
+// lookup returns the lookup of the ip of an address (ip or host:port),
+// starting it in the background if it isn't cached.
+func (r *ReverseDNS) lookup(address string) *reverseDNSEntry {
+	ip := parseAddressIP(address)
+	if ip == nil {
+		return doneReverseDNSEntry
+	}
+	key := ip.String()
+	now := time.Now()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if entry, ok := r.cache[key]; ok && now.Before(entry.expires) {
+		return entry
+	}
+	if len(r.cache) >= maxReverseDNSCacheSize {
+		r.prune(now)
+		if len(r.cache) >= maxReverseDNSCacheSize {
+			return doneReverseDNSEntry
+		}
+	}
+	select {
+	case r.lookups <- struct{}{}:
+	default:
+		// too many pending lookups, the interaction isn't annotated
+		return doneReverseDNSEntry
+	}
+	entry := &reverseDNSEntry{expires: now.Add(reverseDNSCacheTTL), done: make(chan struct{})}
+	r.cache[key] = entry
+	go func() {
+		defer func() { <-r.lookups }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), reverseDNSTimeout)
+		defer cancel()
+		if names, err := r.lookupAddr(ctx, key); err == nil && len(names) > 0 {
+			entry.hostname = strings.TrimSuffix(names[0], ".")
+		}
+		close(entry.done)
+	}()
+	return entry
+}
+
+// doneReverseDNSEntry is the lookup of the addresses not looked up
+var doneReverseDNSEntry = func() *reverseDNSEntry {
+	entry := &reverseDNSEntry{done: make(chan struct{})}
+	close(entry.done)
+	return entry
+}()
+
+// prune removes the expired lookups
+func (r *ReverseDNS) prune(now time.Time) {
+	for key, entry := range r.cache {
+		if !now.Before(entry.expires) {
+			delete(r.cache, key)
+		}
+	}
+}
+
+// withReverseDNS annotates an interaction with the hostname of its remote
+// address before storing it. The interaction is stored in the background
+// when the lookup is pending, so that the listeners aren't delayed.
+func (options *Options) withReverseDNS(interaction *Interaction, store func()) {
+	if options.ReverseDNS == nil || interaction.ReverseDNS != "" {
+		store()
+		return
+	}
+	entry := options.ReverseDNS.lookup(interaction.RemoteAddress)
+	select {
+	case <-entry.done:
+		interaction.ReverseDNS = entry.hostname
+		store()
+	default:
+		go func() {
+			<-entry.done
+			interaction.ReverseDNS = entry.hostname
+			store()
+		}()
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseDNS(t *testing.T) {
+	release := make(chan struct{})
+	var lookups int
+	reverseDNS := NewReverseDNS()
+	reverseDNS.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		lookups++
+		<-release
+		return []string{"crawl-66-249-66-1.googlebot.com."}, nil
+	}
+	options := &Options{ReverseDNS: reverseDNS}
+
+	stored := make(chan *Interaction, 2)
+	interaction := &Interaction{RemoteAddress: "66.249.66.1:4242"}
+	options.withReverseDNS(interaction, func() { stored <- interaction })
+	select {
+	case <-stored:
+		require.Fail(t, "stored interaction before the lookup completed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	require.Equal(t, "crawl-66-249-66-1.googlebot.com", (<-stored).ReverseDNS, "could not annotate interaction")
+
+	cached := &Interaction{RemoteAddress: "66.249.66.1"}
+	options.withReverseDNS(cached, func() { stored <- cached })
+	require.Equal(t, "crawl-66-249-66-1.googlebot.com", (<-stored).ReverseDNS, "could not annotate interaction from cache")
+	require.Equal(t, 1, lookups, "looked up cached ip")
+
+	invalid := &Interaction{RemoteAddress: "invalid"}
+	options.withReverseDNS(invalid, func() { stored <- invalid })
+	require.Empty(t, (<-stored).ReverseDNS, "annotated invalid address")
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+// ErrRevoked is returned for the interactions of a revoked correlation id
+var ErrRevoked = errors.New("correlation-id revoked")
+
+// Revocation is a correlation id blocklisted by the operators, eg. for a
+// leaked or abused payload domain.
+type Revocation struct {
+	CorrelationID string `json:"correlation-id"`
+	// NXDomain answers NXDOMAIN to the dns queries of the payloads of the correlation id
+	NXDomain bool `json:"nxdomain,omitempty"`
+	// Reason is the reason of the revocation, if any
+	Reason string `json:"reason,omitempty"`
+	// RevokedAt is the time the correlation id has been revoked at
+	RevokedAt time.Time `json:"revoked-at"`
+}
+
+// Revocations are the revoked correlation ids, whose interactions aren't
+// recorded anymore and which can't be registered again. The revocations
+// are written to a json file, if any, to be kept across restarts.
+type Revocations struct {
+	sync.RWMutex
+	file    string
+	format  IDFormat
+	revoked map[string]*Revocation
+}
+
+// NewRevocations returns the revocations of a json file, created once a
+// correlation id is revoked, or kept in memory if the file is empty. The
+// format is the one of the random scheme, DefaultIDFormat if zero.
+func NewRevocations(file string, format IDFormat) (*Revocations, error) {
+	if format.Length == 0 {
+		format = DefaultIDFormat
+	}
+	r := &Revocations{file: file, format: format, revoked: make(map[string]*Revocation)}
+	if file == "" {
+		return r, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read revocation file")
+	}
+	var revocations []*Revocation
+	if err := jsoniter.Unmarshal(data, &revocations); err != nil {
+		return nil, errors.Wrap(err, "could not decode revocation file")
+	}
+	for _, revocation := range revocations {
+		r.revoked[strings.ToLower(revocation.CorrelationID)] = revocation
+	}
+	return r, nil
+}
+
+// Revoke revokes a correlation id, replacing its previous revocation. The
+// correlation id must be one the server could have issued.
+func (r *Revocations) Revoke(revocation *Revocation) error {
+	revocation.CorrelationID = strings.ToLower(revocation.CorrelationID)
+	if revocation.CorrelationID == "" {
+		return errors.New("no correlation-id specified")
+	}
+	if !validCorrelationID(revocation.CorrelationID, r.format) {
+		return errors.Errorf("invalid correlation-id %s", revocation.CorrelationID)
+	}
+	if revocation.RevokedAt.IsZero() {
+		revocation.RevokedAt = time.Now()
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	previous, ok := r.revoked[revocation.CorrelationID]
+	r.revoked[revocation.CorrelationID] = revocation
+	if err := r.save(); err != nil {
+		if ok {
+			r.revoked[revocation.CorrelationID] = previous
+		} else {
+			delete(r.revoked, revocation.CorrelationID)
+		}
+		return err
+	}
+	return nil
+}
+
+// Unrevoke lifts the revocation of a correlation id, returning false if it
+// wasn't revoked.
+func (r *Revocations) Unrevoke(correlationID string) (bool, error) {
+	correlationID = strings.ToLower(correlationID)
+	r.Lock()
+	defer r.Unlock()
+
+	revocation, ok := r.revoked[correlationID]
+	if !ok {
+		return false, nil
+	}
+	delete(r.revoked, correlationID)
+	if err := r.save(); err != nil {
+		r.revoked[correlationID] = revocation
+		return false, err
+	}
+	return true, nil
+}
+
+// Revoked returns the revocation of a correlation id, if any.
+func (r *Revocations) Revoked(correlationID string) (*Revocation, bool) {
+	if r == nil {
+		return nil, false
+	}
+	r.RLock()
+	defer r.RUnlock()
+
+	revocation, ok := r.revoked[strings.ToLower(correlationID)]
+	return revocation, ok
+}
+
+// List returns the revocations sorted by correlation id.
+func (r *Revocations) List() []*Revocation {
+	if r == nil {
+		return nil
+	}
+	r.RLock()
+	defer r.RUnlock()
+
+	revocations := make([]*Revocation, 0, len(r.revoked))
+	for _, revocation := range r.revoked {
+		revocations = append(revocations, revocation)
+	}
+	sort.Slice(revocations, func(i, j int) bool { return revocations[i].CorrelationID < revocations[j].CorrelationID })
+	return revocations
+}
+
+// match returns the revocation of the correlation id of a unique id in the
+// dns labels of a name (eg. the full id of an interaction or a dns query), if any.
+func (r *Revocations) match(name string) (*Revocation, bool) {
+	if r == nil || name == "" {
+		return nil, false
+	}
+	r.RLock()
+	defer r.RUnlock()
+
+	if len(r.revoked) == 0 {
+		return nil, false
+	}
+	for _, label := range strings.Split(strings.ToLower(name), ".") {
+		if revocation, ok := r.matchLabel(label); ok {
+			return revocation, true
+		}
+	}
+	return nil, false
+}
+
+// matchLabel looks up the correlation id of a label holding a unique id, or
+// of the xid and random unique ids embedded in a longer label.
+func (r *Revocations) matchLabel(label string) (*Revocation, bool) {
+	if correlationID, scheme := ParseUniqueID(label); scheme != "" {
+		if revocation, ok := r.revoked[correlationID]; ok {
+			return revocation, true
+		}
+	}
+	for _, format := range []IDFormat{xidFormat, r.format} {
+		size := format.Length + format.NonceLength
+		for i := 0; i+size <= len(label); i++ {
+			if candidate := label[i : i+size]; format.valid(candidate) {
+				if revocation, ok := r.revoked[candidate[:format.Length]]; ok {
+					return revocation, true
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// NXDomain returns true if a dns name holds a unique id of a correlation id revoked with
+// NXDOMAIN answers.
+func (r *Revocations) NXDomain(name string) bool {
+	revocation, ok := r.match(name)
+	return ok && revocation.NXDomain
+}
+
+// save writes the revocations to the file atomically, if any
+func (r *Revocations) save() error {
+	if r.file == "" {
+		return nil
+	}
+	revocations := make([]*Revocation, 0, len(r.revoked))
+	for _, revocation := range r.revoked {
+		revocations = append(revocations, revocation)
+	}
+	data, err := jsoniter.Marshal(revocations)
+	if err != nil {
+		return errors.Wrap(err, "could not encode revocations")
+	}
+	file, err := ioutil.TempFile(filepath.Dir(r.file), ".revocations-*")
+	if err != nil {
+		return errors.Wrap(err, "could not write revocation file")
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return errors.Wrap(err, "could not write revocation file")
+	}
+	if err := file.Close(); err != nil {
+		_ = os.Remove(file.Name())
+		return errors.Wrap(err, "could not write revocation file")
+	}
+	if err := os.Rename(file.Name(), r.file); err != nil {
+		_ = os.Remove(file.Name())
+		return errors.Wrap(err, "could not write revocation file")
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevocations(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "revocations.json")
+	revocations, err := NewRevocations(file, IDFormat{})
+	require.Nil(t, err, "could not create revocations")
+	require.NotNil(t, revocations.Revoke(&Revocation{}), "revoked empty correlation id")
+	require.NotNil(t, revocations.Revoke(&Revocation{CorrelationID: "a"}), "revoked invalid correlation id")
+	require.Nil(t, revocations.Revoke(&Revocation{CorrelationID: "C23B2LA0KL1KRJCRDJ10", NXDomain: true, Reason: "leaked"}), "could not revoke correlation id")
+	require.Nil(t, revocations.Revoke(&Revocation{CorrelationID: "c59e3crp82ke7bi4e4tg"}), "could not revoke correlation id")
+
+	revocations, err = NewRevocations(file, IDFormat{})
+	require.Nil(t, err, "could not load revocations")
+	require.Len(t, revocations.List(), 2, "could not keep revocations")
+	revocation, ok := revocations.Revoked("c23b2la0kl1krjcrdj10")
+	require.True(t, ok, "could not get revocation")
+	require.Equal(t, "leaked", revocation.Reason, "could not keep reason")
+	require.True(t, revocations.NXDomain("abc.c23b2la0kl1krjcrdj10nkvoa2stvqcjy.example.com."), "could not match payload domain")
+	require.True(t, revocations.NXDomain("xssc23b2la0kl1krjcrdj10nkvoa2stvqcjy.example.com."), "could not match embedded payload")
+	require.False(t, revocations.NXDomain("c23b2la0kl1krjcrdj10nkvo.example.com."), "matched name containing correlation id")
+	require.False(t, revocations.NXDomain("c59e3crp82ke7bi4e4tgnkvoa2stvqcjy.example.com."), "answered nxdomain without nxdomain")
+
+	ok, err = revocations.Unrevoke("c59e3crp82ke7bi4e4tg")
+	require.Nil(t, err, "could not lift revocation")
+	require.True(t, ok, "could not find revocation")
+	ok, err = revocations.Unrevoke("c59e3crp82ke7bi4e4tg")
+	require.Nil(t, err, "could not lift revocation")
+	require.False(t, ok, "lifted revocation twice")
+
+	var none *Revocations
+	_, ok = none.Revoked("c23b2la0kl1krjcrdj10")
+	require.False(t, ok, "got revocation of disabled revocations")
+}
+
+func TestAdminServerRevocations(t *testing.T) {
+	store, encoded := newTestSession(t)
+	revocations, err := NewRevocations("", IDFormat{})
+	require.Nil(t, err, "could not create revocations")
+	options := &Options{Domain: "example.com", IPAddress: "1.2.3.4", Storage: store, AdminToken: "admin", Revocations: revocations}
+	admin, err := NewAdminServer(options)
+	require.Nil(t, err, "could not create admin server")
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+
+	do := func(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "admin")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := do(admin.server.Handler, http.MethodPost, "/api/revocations", `{"correlation-id":"c23b2la0kl1krjcrdj10","nxdomain":true,"reason":"leaked"}`)
+	require.Equal(t, http.StatusOK, rec.Code, "could not revoke correlation id")
+	require.False(t, store.HasID("c23b2la0kl1krjcrdj10"), "could not deregister revoked session")
+	require.Contains(t, do(admin.server.Handler, http.MethodGet, "/api/revocations", "").Body.String(), `"reason":"leaked"`, "could not list revocation")
+
+	register := `{"public-key":"` + encoded + `","secret-key":"secret","correlation-id":"c23b2la0kl1krjcrdj10"}`
+	require.Equal(t, http.StatusForbidden, do(server.nontlsserver.Handler, http.MethodPost, "http://example.com/register", register).Code, "registered revoked correlation id")
+
+	w := &testResponseWriter{}
+	NewDNSServer("udp", options).ServeDNS(w, new(dns.Msg).SetQuestion("c23b2la0kl1krjcrdj10nkvoa2stvqcjy.example.com.", dns.TypeA))
+	require.Equal(t, dns.RcodeNameError, w.msg.Rcode, "could not answer nxdomain to revoked payload")
+
+	stored := false
+	err = options.writeInteraction("c23b2la0kl1krjcrdj10", &Interaction{Protocol: "http", UniqueID: "c23b2la0kl1krjcrdj10nkvoa2stvqcjy"}, func([]byte) error {
+		stored = true
+		return nil
+	})
+	require.Equal(t, ErrRevoked, err, "could not drop interaction of revoked correlation id")
+	require.False(t, stored, "stored interaction of revoked correlation id")
+
+	require.Equal(t, http.StatusOK, do(admin.server.Handler, http.MethodDelete, "/api/revocations/c23b2la0kl1krjcrdj10", "").Code, "could not lift revocation")
+	require.Equal(t, http.StatusNotFound, do(admin.server.Handler, http.MethodDelete, "/api/revocations/c23b2la0kl1krjcrdj10", "").Code, "lifted revocation twice")
+	require.Equal(t, http.StatusOK, do(server.nontlsserver.Handler, http.MethodPost, "http://example.com/register", register).Code, "could not register lifted correlation id")
+}
+
+func TestRevocationsIDFormat(t *testing.T) {
+	revocations, err := NewRevocations("", IDFormat{Length: 6, NonceLength: 4, Alphabet: "abcdef0123"})
+	require.Nil(t, err, "could not create revocations")
+	require.NotNil(t, revocations.Revoke(&Revocation{CorrelationID: "abc"}), "revoked too short correlation id")
+	require.NotNil(t, revocations.Revoke(&Revocation{CorrelationID: "abcxyz"}), "revoked correlation id outside alphabet")
+	for _, correlationID := range []string{"abc012", "c23b2la0kl1krjcrdj10", "0123456789abcdef", "acme-c23b2la0kl1krjcrdj10", "able-acid-area"} {
+		require.Nil(t, revocations.Revoke(&Revocation{CorrelationID: correlationID, NXDomain: true}), "could not revoke %s", correlationID)
+	}
+	require.True(t, revocations.NXDomain("abc012ffff.example.com."), "could not match payload of format")
+	require.True(t, revocations.NXDomain("able-acid-area-nkvoa2stvqcjf.example.com."), "could not match payload of words scheme")
+	require.False(t, revocations.NXDomain("abc0123.example.com."), "matched label of other length")
+	require.False(t, revocations.NXDomain("xabc012.example.com."), "matched label containing correlation id")
+}
@@ -1,13 +1,33 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+	"github.com/projectdiscovery/interactsh/pkg/eventlog"
+	"github.com/projectdiscovery/interactsh/pkg/geoip"
 	"github.com/projectdiscovery/interactsh/pkg/server/acme"
 	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/projectdiscovery/interactsh/pkg/webhook"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer is the tracer for the spans of the servers, spans are only
+// exported when an opentelemetry tracer provider has been configured.
+var tracer = otel.Tracer("github.com/projectdiscovery/interactsh/pkg/server")
+
 // Interaction is an interaction received to the server.
 type Interaction struct {
 	// Protocol for interaction, can contains HTTP/DNS/SMTP,etc.
@@ -24,16 +44,61 @@ type Interaction struct {
 	RawResponse string `json:"raw-response,omitempty"`
 	// SMTPFrom is the mail form field
 	SMTPFrom string `json:"smtp-from,omitempty"`
+	// SMTPAttachments are the attachments of the mail, if any
+	SMTPAttachments []*SMTPAttachment `json:"smtp-attachments,omitempty"`
+	// SMTPMessage is the envelope, headers and decoded parts of the mail, if parsed
+	SMTPMessage *SMTPMessage `json:"smtp-message,omitempty"`
+	// SMTPAuth are the credentials presented with SMTP AUTH before the mail, if any
+	SMTPAuth []*SMTPCredential `json:"smtp-auth,omitempty"`
 	// RemoteAddress is the remote address for interaction
 	RemoteAddress string `json:"remote-address"`
+	// Geo is the location and the autonomous system of the remote address, if enriched
+	Geo *geoip.Location `json:"geo,omitempty"`
+	// ReverseDNS is the ptr hostname of the remote address, if found
+	ReverseDNS string `json:"reverse-dns,omitempty"`
+	// TLSClientHello is the TLS ClientHello metadata sent by the client, if any
+	TLSClientHello *ClientHello `json:"tls-client-hello,omitempty"`
+	// TLSHostMismatch is true if the host of an HTTP request doesn't match the SNI of its connection
+	TLSHostMismatch bool `json:"tls-host-mismatch,omitempty"`
+	// HTTP2 is the stream level metadata of an HTTP/2 request, if any
+	HTTP2 *HTTP2Stream `json:"http2,omitempty"`
+	// HTTPAuth is the credential answering the http authentication challenge of the session, if any
+	HTTPAuth *HTTPCredential `json:"http-auth,omitempty"`
+	// HTTPBody is the size and hash of the body of an http request, if any
+	HTTPBody *HTTPBody `json:"http-body,omitempty"`
+	// ProtocolGuess is the protocol guessed from the bytes which couldn't be parsed as http, if any
+	ProtocolGuess string `json:"protocol-guess,omitempty"`
+	// HostedFile is the name of the hosted file fetched, if any
+	HostedFile string `json:"hosted-file,omitempty"`
+	// Retries is the number of identical dns queries retried by the resolver
+	// which have been collapsed into the interaction, if deduplicated
+	Retries int `json:"retries,omitempty"`
+	// Source is the external integration which observed the interaction, if injected
+	Source string `json:"source,omitempty"`
 	// Timestamp is the timestamp for the interaction
 	Timestamp time.Time `json:"timestamp"`
+	// Latency is the latency of the interaction from its receipt to its delivery
+	Latency *InteractionLatency `json:"latency,omitempty"`
+	// PCAP is the path of the pcap snippet of the packets of the interaction, if captured
+	PCAP string `json:"pcap,omitempty"`
+
+	capture *packetCapture
+}
+
+// WebhookInteraction is an interaction sent to the webhooks along with the
+// correlation id of its session, resolved by the server or the client.
+type WebhookInteraction struct {
+	*Interaction
+	// CorrelationID is the correlation id of the session of the interaction, if any
+	CorrelationID string `json:"correlation-id,omitempty"`
 }
 
 // Options contains configuration options for the servers
 type Options struct {
 	// Domain is the domain for the instance.
 	Domain string
+	// Domains are all the domains of the instance, Domain being the first one.
+	Domains []string
 	// IPAddress is the IP address of the current server.
 	IPAddress string
 	// ListenIP is the IP address to listen servers on
@@ -44,6 +109,15 @@ type Options struct {
 	HttpPort int
 	// HttpsPort is the port to listen HTTPS server on
 	HttpsPort int
+	// HTTPRawBytes is the maximum number of bytes recorded for the connections which
+	// couldn't be parsed as http, 0 to disable the recording
+	HTTPRawBytes int
+	// HTTPMaxBody is the maximum number of bytes of the request bodies recorded
+	// in the interactions, the bodies being hashed and written to HTTPBodies whole
+	HTTPMaxBody int
+	// TLSLog records the tls connections of the https server closed without
+	// any http request, eg. failed handshakes of scanners probing random SNI values
+	TLSLog bool
 	// SmbPort is the port to listen Smb server on
 	SmbPort int
 	// SmtpPort is the port to listen Smtp server on
@@ -52,26 +126,582 @@ type Options struct {
 	SmtpsPort int
 	// SmtpAutoTLSPort is the port to listen Smtp autoTLS server on
 	SmtpAutoTLSPort int
+	// SmtpStartTLS is the STARTTLS policy of the smtp and smtps ports, advertised if empty
+	SmtpStartTLS string
 	// FtpPort is the port to listen Ftp server on
 	FtpPort int
 	// FtpPort is the port to listen Ftp server on
 	LdapPort int
+	// SmtpMaxSize is the maximum size in bytes of the smtp messages (0 for unlimited)
+	SmtpMaxSize int
+	// SmtpMaxAttachments is the maximum number of attachments processed per smtp message
+	SmtpMaxAttachments int
+	// Quarantine stores the suspicious smtp attachments, if enabled
+	Quarantine *Quarantine
+	// SmtpStoreAttachments stores every smtp attachment in the quarantine for retrieval
+	SmtpStoreAttachments bool
+	// SmtpMaxAttachmentSize is the maximum size in bytes of the stored smtp attachments (0 for unlimited)
+	SmtpMaxAttachmentSize int
+	// PCAP writes the packets of the interactions to pcap snippets, if enabled
+	PCAP *PCAPWriter
+	// HTTPBodies writes the request bodies to the body directory, if enabled
+	HTTPBodies *HTTPBodyWriter
+	// GeoIP enriches the interactions with the location of the remote address, if enabled
+	GeoIP *geoip.Reader
+	// ReverseDNS annotates the interactions with the ptr hostname of the remote address, if enabled
+	ReverseDNS *ReverseDNS
+	// EventLog is the json event log every interaction is written to, if enabled
+	EventLog *eventlog.Logger
+	// DiskLog is the disk log the interaction summaries are written to, if enabled
+	DiskLog *DiskLog
+	// AuditLog is the log the control-plane operations are written to, if enabled
+	AuditLog *AuditLog
 	// Hostmaster is the hostmaster email for the server.
 	Hostmaster string
+	// NameServers are the name servers of the zone, defaults to ns1 and ns2 of the domain
+	NameServers []string
+	// SOA is the SOA record of the zone, defaults to DefaultSOA
+	SOA *SOA
+	// TimeToLive is the ttl of the dns answers, defaults to DefaultTimeToLive
+	TimeToLive *uint32
+	// DNSSEC signs the dns answers for the resolvers requesting it, if enabled
+	DNSSEC *DNSSEC
+	// DNSRecords are the static records of the zone file served by the dns server
+	DNSRecords *DNSRecords
+	// DNSDelegations are the sub-zones delegated to other name servers
+	DNSDelegations *DNSDelegations
+	// MailRecords publishes the spf and dmarc records of the domains
+	MailRecords bool
+	// DKIM signs the mails sent by the server and publishes its public key, if enabled
+	DKIM *DKIMSigner
 	// Storage is a storage for interaction data storage
-	Storage *storage.Storage
+	Storage storage.Storage
 	// Auth requires client to authenticate
 	Auth bool
 	// Token required to retrieve interactions
 	Token string
+	// Tokens are the tokens of the tenants only seeing their own sessions, if enabled
+	Tokens *TokenStore
+	// Signatures verifies the requests signed with the tokens instead of sending them, if enabled
+	Signatures *SignatureVerifier
 	// Enable root tld interactions
 	RootTLD bool
+	// WildcardLog stores the interactions not matching any registered correlation id in the WildcardLogID session
+	WildcardLog bool
 	// OriginURL for the HTTP Server
 	OriginURL string
+	// ProxyRoutes are the origins the http server proxies the paths to (eg. /app/)
+	ProxyRoutes map[string]*url.URL
+	// IDFormat is the format of the correlation ids of the random scheme, DefaultIDFormat if zero
+	IDFormat IDFormat
+	// IDPrefixes are the tokens the prefixes of the prefix id scheme are reserved for
+	IDPrefixes map[string]string
 	// FTPDirectory or temporary one
 	FTPDirectory string
+	// CatchAllPorts is the list of ports to listen raw tcp/udp catch-all servers on
+	CatchAllPorts []int
+	// CatchAllBytes is the maximum number of bytes recorded for catch-all connections
+	CatchAllBytes int
+	// MaxSessionsPerIP is the maximum number of sessions a single ip can register (0 for unlimited)
+	MaxSessionsPerIP int
+	// MaxSessionTTL is the maximum ttl the clients can request for their session at registration
+	MaxSessionTTL time.Duration
+	// Webhooks are the webhooks notified of every stored interaction
+	Webhooks []*webhook.Webhook
+	// ResolverStats is the cache of resolver behavior, if enabled
+	ResolverStats *ResolverStats
+	// DNSDeduplicator collapses the retried dns queries, if enabled
+	DNSDeduplicator *DNSDeduplicator
+	// DNSRebinder answers the dns rebinding payloads, if enabled
+	DNSRebinder *DNSRebinder
+	// RateLimiter limits the interactions recorded per source ip, if enabled
+	RateLimiter *RateLimiter
+	// IPFilter restricts the ips of the clients registering and polling, if enabled
+	IPFilter *IPFilter
+	// ClientCAs are the cas the certificates of the clients registering and
+	// polling over tls must be signed by, if enabled (mutual tls)
+	ClientCAs *x509.CertPool
+	// DenyInteractions drops the interactions of the ips denied by the ip filter
+	DenyInteractions bool
+	// AdminPort is the port to listen the admin server on
+	AdminPort int
+	// Inject enables injecting interactions with the admin server
+	Inject bool
+	// AdminToken is the token required by the session management api of the admin server, if enabled
+	AdminToken string
+	// Accounting tracks the connections and bytes in/out of the sessions
+	Accounting *Accounting
+	// SessionAnswers are the dns answers set by the clients for their sessions
+	SessionAnswers *SessionAnswers
+	// SessionChallenges are the http authentication challenges requested by the clients for their sessions
+	SessionChallenges *SessionChallenges
+	// SessionResponses are the http responses set by the clients for their sessions, if enabled
+	SessionResponses *SessionResponses
+	// HTTPPages are the default pages configured by the operator, if any
+	HTTPPages *HTTPPages
+	// HostedFiles are the payload files uploaded by the clients for their sessions, if enabled
+	HostedFiles *HostedFiles
+	// Metrics are the counters exposed by the admin server, if enabled
+	Metrics *Metrics
+	// LatencyAlerts warns about the interactions stored or delivered late, if enabled
+	LatencyAlerts *LatencyAlerts
+	// MailNotifier emails the first interactions and the spikes of the sessions, if enabled
+	MailNotifier *MailNotifier
+	// Health tracks the listeners for the admin readiness checks, if enabled
+	Health *Health
+	// Cluster replicates the sessions and interactions to the peer instances, if enabled
+	Cluster *Cluster
+	// Revocations are the correlation ids revoked with the admin api, if enabled
+	Revocations *Revocations
+	// LongPolls holds the polls waiting for the interactions of their session, if enabled
+	LongPolls *LongPolls
+	// OIDC verifies the id tokens exchanged for the tokens of the tenants, if enabled
+	OIDC *OIDCVerifier
 
 	ACMEStore *acme.Provider
+
+	// runtimeMutex protects the settings that can be reloaded at runtime
+	runtimeMutex sync.RWMutex
+	// previousToken is the token replaced by a rotation, accepted until previousExpiry
+	previousToken  string
+	previousExpiry time.Time
+	// sessionLimiter caps the sessions registered per ip, if enabled
+	sessionLimiter *sessionLimiter
+}
+
+// GetDomains returns all the domains of the instance.
+func (options *Options) GetDomains() []string {
+	if len(options.Domains) == 0 {
+		return []string{options.Domain}
+	}
+	return options.Domains
+}
+
+// domainOf returns the domain of the instance a host (eg. abc.example.com.)
+// belongs to, preferring the longest matching domain.
+func (options *Options) domainOf(host string) (string, bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	var matched string
+	for _, domain := range options.GetDomains() {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		if domain == "" || len(domain) <= len(matched) {
+			continue
+		}
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			matched = domain
+		}
+	}
+	return matched, matched != ""
+}
+
+// isServerToken returns true for the server token, and for the token it
+// replaced during the grace period of a rotation.
+func (options *Options) isServerToken(token string) bool {
+	options.runtimeMutex.RLock()
+	defer options.runtimeMutex.RUnlock()
+
+	if token == options.Token {
+		return true
+	}
+	return options.previousToken != "" && token == options.previousToken && time.Now().Before(options.previousExpiry)
+}
+
+// GetToken returns the token required to retrieve interactions.
+func (options *Options) GetToken() string {
+	options.runtimeMutex.RLock()
+	defer options.runtimeMutex.RUnlock()
+
+	return options.Token
+}
+
+// SetToken replaces the token required to retrieve interactions, the previous
+// token being still accepted for a grace period so that the clients can be
+// updated. Interactions stored for the previous token are kept until they
+// are evicted.
+func (options *Options) SetToken(token string, grace time.Duration) error {
+	if err := options.Storage.SetID(token); err != nil {
+		return err
+	}
+	options.runtimeMutex.Lock()
+	options.previousToken, options.previousExpiry = "", time.Time{}
+	if grace > 0 && options.Token != token {
+		options.previousToken, options.previousExpiry = options.Token, time.Now().Add(grace)
+	}
+	options.Token = token
+	options.runtimeMutex.Unlock()
+	return nil
+}
+
+// authenticate returns true if a token is accepted by the server, along
+// with its tenant (nil for the server token or without authentication).
+func (options *Options) authenticate(token string) (*Tenant, bool) {
+	if !options.Auth || options.isServerToken(token) {
+		return nil, true
+	}
+	return options.Tokens.Lookup(token)
+}
+
+// authenticateRequest returns true if a request sends a token accepted by the
+// server or is signed with one, along with its tenant and the token. The
+// requests sending their token are rejected if the signatures are required.
+func (options *Options) authenticateRequest(req *http.Request) (*Tenant, string, bool) {
+	header := req.Header.Get("Authorization")
+	if !options.Auth {
+		return nil, header, true
+	}
+	if params := strings.TrimPrefix(header, SignatureScheme+" "); params != header {
+		return options.Signatures.verify(req, params, options.signingKeys)
+	}
+	if options.Signatures.Required() {
+		return nil, "", false
+	}
+	tenant, ok := options.authenticate(header)
+	return tenant, header, ok
+}
+
+// signingKeys returns the tokens with the fingerprint of the key id of a
+// signed request along with their tenant, nil for the server token.
+func (options *Options) signingKeys(keyID string) ([]string, *Tenant) {
+	options.runtimeMutex.RLock()
+	var tokens []string
+	if options.Token != "" && tokenFingerprint(options.Token) == keyID {
+		tokens = append(tokens, options.Token)
+	}
+	if options.previousToken != "" && tokenFingerprint(options.previousToken) == keyID && time.Now().Before(options.previousExpiry) {
+		tokens = append(tokens, options.previousToken)
+	}
+	options.runtimeMutex.RUnlock()
+	if len(tokens) > 0 {
+		return tokens, nil
+	}
+	if tenant, ok := options.Tokens.lookupKey(keyID); ok {
+		return []string{tenant.Token}, tenant
+	}
+	return nil, nil
+}
+
+// visibleTo returns true if a session can be accessed by a tenant, the
+// server token and the admin tokens accessing all of them.
+func (options *Options) visibleTo(tenant *Tenant, correlationID string) bool {
+	return tenant.allows(ScopeAdmin) || options.Tokens.Owner(correlationID) == tenant.Name
+}
+
+// SetWebhooks replaces the webhooks notified of every stored interaction,
+// returning the previous ones. The webhooks of an unchanged configuration
+// keep the first-only keys seen by the previous ones.
+func (options *Options) SetWebhooks(webhooks []*webhook.Webhook) []*webhook.Webhook {
+	options.runtimeMutex.Lock()
+	defer options.runtimeMutex.Unlock()
+
+	options.observeWebhooks(webhooks)
+	previous := options.Webhooks
+	for _, webhook := range webhooks {
+		for _, previousWebhook := range previous {
+			if webhook.Inherit(previousWebhook) {
+				break
+			}
+		}
+	}
+	options.Webhooks = webhooks
+	return previous
+}
+
+func (options *Options) getWebhooks() []*webhook.Webhook {
+	options.runtimeMutex.RLock()
+	defer options.runtimeMutex.RUnlock()
+
+	return options.Webhooks
+}
+
+// WildcardLogID is the id of the server-owned session storing the
+// interactions not matching any registered correlation id, if enabled
+const WildcardLogID = "wildcard-log"
+
+// storeInteraction encodes an interaction and stores it in the
+// encrypted bucket of the correlation-id.
+func (options *Options) storeInteraction(correlationID string, interaction *Interaction) {
+	if options.WildcardLog && !options.Storage.HasID(correlationID) {
+		options.storeInteractionWithId(WildcardLogID, interaction)
+		return
+	}
+	if !options.allowInteraction(interaction) {
+		return
+	}
+	options.withReverseDNS(interaction, func() {
+		_ = options.writeInteraction(correlationID, interaction, func(data []byte) error {
+			return options.addInteraction(correlationID, data)
+		})
+	})
+}
+
+// storeInteractionWithId encodes an interaction and stores it unencrypted
+// in the id bucket (eg. the auth token or the root tld domain).
+func (options *Options) storeInteractionWithId(id string, interaction *Interaction) {
+	if !options.allowInteraction(interaction) {
+		return
+	}
+	options.withReverseDNS(interaction, func() {
+		_ = options.writeInteraction("", interaction, func(data []byte) error {
+			return options.addInteractionWithId(id, data)
+		})
+	})
+}
+
+// addInteraction stores an encoded interaction for a correlation-id,
+// replicating it to the peers of the cluster
+func (options *Options) addInteraction(correlationID string, data []byte) error {
+	if err := options.Storage.AddInteraction(correlationID, data); err != nil {
+		return err
+	}
+	options.LongPolls.Notify(correlationID)
+	options.Cluster.Interaction(correlationID, "", data)
+	return nil
+}
+
+// addInteractionWithId stores an encoded interaction unencrypted for an id,
+// replicating it to the peers of the cluster
+func (options *Options) addInteractionWithId(id string, data []byte) error {
+	if err := options.Storage.AddInteractionWithId(id, data); err != nil {
+		return err
+	}
+	options.LongPolls.Notify(sharedBuckets)
+	options.Cluster.Interaction("", id, data)
+	return nil
+}
+
+// storeWildcardInteraction stores an interaction not matching any
+// registered correlation id in the wildcard log session, if enabled.
+func (options *Options) storeWildcardInteraction(interaction *Interaction) {
+	if options.WildcardLog {
+		options.storeInteractionWithId(WildcardLogID, interaction)
+	}
+}
+
+// allowInteraction returns false for the interactions of the sources exceeding
+// their rate limit. Dns queries are limited by the dns server before they are
+// answered, so that limited sources can't use the server for amplification.
+func (options *Options) allowInteraction(interaction *Interaction) bool {
+	if interaction.Protocol == "dns" || interaction.Protocol == "axfr" {
+		return true
+	}
+	return options.allowSource(interaction.Protocol, interaction.RemoteAddress)
+}
+
+// allowSource returns false for the denied source ips, if enabled, and
+// takes a token from the rate limit bucket of the other ones
+func (options *Options) allowSource(protocol, remoteAddress string) bool {
+	if options.DenyInteractions && options.IPFilter.Denied(remoteAddress) {
+		gologger.Debug().Msgf("Dropped %s interaction from denied %s\n", protocol, remoteAddress)
+		return false
+	}
+	if options.RateLimiter.Allow(protocol, remoteAddress) {
+		return true
+	}
+	options.Metrics.IncRateLimited(protocol)
+	gologger.Debug().Msgf("Rate limited %s interaction from %s\n", protocol, remoteAddress)
+	return false
+}
+
+// sessionRemoved releases the resources of a deregistered session
+func (options *Options) sessionRemoved(correlationID string) {
+	if options.Quarantine != nil {
+		if err := options.Quarantine.Remove(correlationID); err != nil {
+			gologger.Warning().Msgf("Could not remove quarantined attachments for %s: %s\n", correlationID, err)
+		}
+	}
+	options.Accounting.Remove(correlationID)
+	options.SessionAnswers.Remove(correlationID)
+	options.SessionChallenges.Remove(correlationID)
+	options.SessionResponses.Remove(correlationID)
+	options.HostedFiles.Remove(correlationID)
+	options.MailNotifier.Remove(correlationID)
+	options.Tokens.Release(correlationID)
+	options.sessionLimiter.Release(correlationID)
+	options.Metrics.IncDeregistrations()
+}
+
+// exportSession returns the registration of a session with its settings
+func (options *Options) exportSession(correlationID string) (*ExportedSession, bool) {
+	session, ok := options.Storage.ExportSession(correlationID)
+	if !ok {
+		return nil, false
+	}
+	return &ExportedSession{
+		SessionExport: session,
+		DNSAnswers:    options.SessionAnswers.Get(correlationID),
+		HTTPChallenge: options.SessionChallenges.Get(correlationID),
+		HTTPResponses: options.SessionResponses.Get(correlationID),
+		HostedFiles:   options.HostedFiles.Export(correlationID),
+	}, true
+}
+
+// importSession registers a session exported by another server with its settings
+func (options *Options) importSession(session *ExportedSession) error {
+	if session.DNSAnswers != nil {
+		if err := session.DNSAnswers.Validate(); err != nil {
+			return errors.Wrap(err, "invalid dns answers")
+		}
+	}
+	if len(session.HTTPResponses) > 0 {
+		if err := session.HTTPResponses.Validate(); err != nil {
+			return errors.Wrap(err, "invalid http responses")
+		}
+	}
+	if session.HTTPChallenge != "" && !ValidHTTPChallenge(session.HTTPChallenge) {
+		return errors.Errorf("invalid http challenge %s", session.HTTPChallenge)
+	}
+	if err := options.HostedFiles.Validate(session.HostedFiles); err != nil {
+		return errors.Wrap(err, "invalid hosted files")
+	}
+	if err := options.Storage.ImportSession(session.SessionExport); err != nil {
+		return err
+	}
+	options.SessionAnswers.Set(session.CorrelationID, session.DNSAnswers)
+	options.SessionChallenges.Set(session.CorrelationID, session.HTTPChallenge)
+	options.SessionResponses.Set(session.CorrelationID, session.HTTPResponses)
+	return options.HostedFiles.Import(session.CorrelationID, session.HostedFiles)
+}
+
+// ErrSessionNotFound is returned when injecting an interaction for an unregistered session
+var ErrSessionNotFound = errors.New("session not found")
+
+// InjectInteraction stores an interaction observed by an external integration
+// (eg. a co-located honeypot or IDS) for the session of its unique id, as if
+// it had been received by the server.
+func (options *Options) InjectInteraction(interaction *Interaction) error {
+	interaction.UniqueID = strings.ToLower(interaction.UniqueID)
+	correlationID := options.correlationIDOf(interaction.UniqueID)
+	if correlationID == "" {
+		return errors.New("invalid unique-id")
+	}
+	if interaction.Protocol == "" {
+		return errors.New("missing protocol")
+	}
+	if interaction.FullId == "" {
+		interaction.FullId = interaction.UniqueID
+	}
+	if interaction.Timestamp.IsZero() {
+		interaction.Timestamp = time.Now()
+	}
+	if !options.Storage.HasID(correlationID) {
+		return ErrSessionNotFound
+	}
+	return options.writeInteraction(correlationID, interaction, func(data []byte) error {
+		return options.addInteraction(correlationID, data)
+	})
+}
+
+// writeInteraction encodes and stores an interaction, notifying the
+// configured webhooks once it has been stored. The correlation id is
+// empty for the interactions stored unencrypted.
+func (options *Options) writeInteraction(correlationID string, interaction *Interaction, store func(data []byte) error) error {
+	if revocation, ok := options.revocationOf(correlationID, interaction); ok {
+		gologger.Debug().Msgf("Dropped %s interaction of revoked %s\n", interaction.Protocol, revocation.CorrelationID)
+		return ErrRevoked
+	}
+	ctx, span := tracer.Start(context.Background(), "interaction.store", trace.WithAttributes(
+		attribute.String("interaction.protocol", interaction.Protocol),
+		attribute.String("interaction.unique-id", interaction.UniqueID),
+	))
+	defer span.End()
+
+	if interaction.Geo == nil {
+		interaction.Geo = options.GeoIP.Lookup(interaction.RemoteAddress)
+	}
+	if options.PCAP != nil && interaction.capture != nil {
+		path, err := options.PCAP.Write(interaction, interaction.capture)
+		if err != nil {
+			gologger.Warning().Msgf("Could not write %s interaction pcap: %s\n", interaction.Protocol, err)
+		}
+		interaction.PCAP = path
+	}
+
+	storedAt := time.Now()
+	interaction.Latency = &InteractionLatency{StoredAt: storedAt, Ingestion: milliseconds(storedAt.Sub(interaction.Timestamp))}
+
+	buffer := &bytes.Buffer{}
+	if err := jsoniter.NewEncoder(buffer).Encode(interaction); err != nil {
+		setSpanError(span, err)
+		gologger.Warning().Msgf("Could not encode %s interaction: %s\n", interaction.Protocol, err)
+		return err
+	}
+	gologger.Debug().Msgf("%s Interaction: \n%s\n", strings.ToUpper(interaction.Protocol), buffer.String())
+
+	_, storageSpan := tracer.Start(ctx, "storage.add-interaction")
+	err := store(buffer.Bytes())
+	setSpanError(storageSpan, err)
+	storageSpan.End()
+	options.EventLog.LogInteraction(bytes.TrimSpace(buffer.Bytes()), err == nil)
+	if options.DiskLog != nil {
+		options.writeDiskLog(correlationID, interaction, buffer.Bytes(), err == nil)
+	}
+	if err != nil {
+		setSpanError(span, err)
+		gologger.Warning().Msgf("Could not store %s interaction: %s\n", interaction.Protocol, err)
+		return err
+	}
+	options.Metrics.IncInteractions(interaction.Protocol)
+	options.observeIngestion(storedAt.Sub(interaction.Timestamp))
+	if correlationID != "" {
+		options.Accounting.Add(correlationID, len(interaction.RawRequest), len(interaction.RawResponse))
+		options.MailNotifier.Observe(correlationID, interaction)
+	}
+	for _, webhook := range options.getWebhooks() {
+		if err := webhook.Send(&WebhookInteraction{Interaction: interaction, CorrelationID: correlationID}); err != nil {
+			gologger.Warning().Msgf("Could not send %s interaction to webhook: %s\n", interaction.Protocol, err)
+		}
+	}
+	return nil
+}
+
+// revocationOf returns the revocation of the correlation id of an interaction
+// or of the unique ids in its ids (eg. the wildcard log and root tld interactions), if any.
+func (options *Options) revocationOf(correlationID string, interaction *Interaction) (*Revocation, bool) {
+	if correlationID != "" {
+		if revocation, ok := options.Revocations.Revoked(correlationID); ok {
+			return revocation, true
+		}
+	}
+	for _, id := range []string{interaction.UniqueID, interaction.FullId} {
+		if revocation, ok := options.Revocations.match(id); ok {
+			return revocation, true
+		}
+	}
+	return nil, false
+}
+
+// writeDiskLog writes the summary of an interaction to the disk log, along
+// with the interaction encrypted for the session if enabled.
+func (options *Options) writeDiskLog(correlationID string, interaction *Interaction, data []byte, stored bool) {
+	entry := &DiskLogEntry{
+		Timestamp:     interaction.Timestamp,
+		Protocol:      interaction.Protocol,
+		UniqueID:      interaction.UniqueID,
+		FullId:        interaction.FullId,
+		QType:         interaction.QType,
+		RemoteAddress: interaction.RemoteAddress,
+		Size:          len(interaction.RawRequest),
+		Stored:        stored,
+	}
+	if options.DiskLog.Blobs && stored && correlationID != "" {
+		blob, err := options.Storage.Encrypt(correlationID, data)
+		if err != nil {
+			gologger.Warning().Msgf("Could not encrypt %s interaction for disk log: %s\n", interaction.Protocol, err)
+		}
+		entry.Blob = blob
+	}
+	if err := options.DiskLog.Write(entry); err != nil {
+		gologger.Warning().Msgf("Could not write %s interaction to disk log: %s\n", interaction.Protocol, err)
+	}
+}
+
+// setSpanError records the error of a span, if any
+func setSpanError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
 }
 
 // URLReflection returns a reversed part of the URL payload
@@ -86,15 +716,57 @@ func URLReflection(URL string) string {
 	return string(rns)
 }
 
-// getURLIDComponent returns the 33 character interactsh ID
+// getURLIDComponent returns the interactsh unique ID of any scheme
 func getURLIDComponent(URL string) string {
 	parts := strings.Split(URL, ".")
 
 	var randomID string
 	for _, part := range parts {
-		if len(part) == 33 {
+		if _, scheme := ParseUniqueID(part); scheme != "" {
 			randomID = part
 		}
 	}
 	return randomID
 }
+
+// findUniqueID looks for the unique id of a registered session in the dns labels
+// of a host, returning the unique id, the full subdomain path leading to it
+// and the correlation id.
+func (options *Options) findUniqueID(host string) (uniqueID, fullID, correlationID string) {
+	parts := strings.Split(strings.ToLower(host), ".")
+	for i, part := range parts {
+		if id, correlation := options.uniqueIDOf(part); id != "" {
+			uniqueID = id
+			fullID = strings.Join(parts[:i+1], ".")
+			correlationID = correlation
+		}
+	}
+	return uniqueID, fullID, correlationID
+}
+
+// uniqueIDOf returns the unique id and the correlation id of a label, either
+// holding the unique id or embedding the unique id of a registered session.
+func (options *Options) uniqueIDOf(label string) (uniqueID, correlationID string) {
+	if correlationID := options.correlationIDOf(label); correlationID != "" {
+		return label, correlationID
+	}
+	return options.embeddedUniqueIDOf(label)
+}
+
+// findInteractionID looks for an interaction id inside free-form data,
+// returning the unique id, the full subdomain path leading to it and the
+// correlation id.
+func (options *Options) findInteractionID(data string) (uniqueID, fullID, correlationID string) {
+	tokens := strings.FieldsFunc(data, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-')
+	})
+	for _, token := range tokens {
+		parts := strings.Split(strings.ToLower(token), ".")
+		for i, part := range parts {
+			if uniqueID, correlationID := options.uniqueIDOf(part); uniqueID != "" {
+				return uniqueID, strings.Join(parts[:i+1], "."), correlationID
+			}
+		}
+	}
+	return "", "", ""
+}
@@ -1,12 +1,98 @@
 package server
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/projectdiscovery/interactsh/pkg/webhook"
 	"github.com/stretchr/testify/require"
 )
 
+var (
+	testKeyOnce sync.Once
+	testKey     *rsa.PrivateKey
+	testKeyErr  error
+)
+
+// testPrivateKey returns the rsa key of the test sessions, generated once
+func testPrivateKey(t *testing.T) *rsa.PrivateKey {
+	testKeyOnce.Do(func() {
+		testKey, testKeyErr = rsa.GenerateKey(rand.Reader, 2048)
+	})
+	require.Nil(t, testKeyErr, "could not generate rsa key")
+	return testKey
+}
+
+// encodedTestPublicKey returns the public key of the test sessions encoded
+// like the registrations of the clients
+func encodedTestPublicKey(t *testing.T) string {
+	publicKey, err := x509.MarshalPKIXPublicKey(testPrivateKey(t).Public())
+	require.Nil(t, err, "could not marshal public key")
+	return base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: publicKey}))
+}
+
+// newTestSession returns a storage with the c23b2la0kl1krjcrdj10 session
+// registered with the secret key, along with its encoded public key
+func newTestSession(t *testing.T) (*storage.MemoryStorage, string) {
+	encoded := encodedTestPublicKey(t)
+	store := storage.New(time.Hour)
+	require.Nil(t, store.SetIDPublicKey("c23b2la0kl1krjcrdj10", "secret", encoded), "could not register session")
+	return store, encoded
+}
+
 func TestGetURLIDComponent(t *testing.T) {
 	random := getURLIDComponent("c6rj61aciaeutn2ae680cg5ugboyyyyyn.interactsh.com")
 	require.Equal(t, "c6rj61aciaeutn2ae680cg5ugboyyyyyn", random, "could not get correct component")
 }
+
+func TestFindInteractionID(t *testing.T) {
+	options := &Options{}
+	uniqueID, fullID, correlationID := options.findInteractionID("*1\r\n$4\r\nPING\r\nx.c6rj61aciaeutn2ae680cg5ugboyyyyyn.interactsh.com\r\n")
+	require.Equal(t, "c6rj61aciaeutn2ae680cg5ugboyyyyyn", uniqueID, "could not get correct unique id")
+	require.Equal(t, "x.c6rj61aciaeutn2ae680cg5ugboyyyyyn", fullID, "could not get correct full id")
+	require.Equal(t, "c6rj61aciaeutn2ae680", correlationID, "could not get correct correlation id")
+}
+
+func TestWebhookCorrelationID(t *testing.T) {
+	received := make(chan string, 3)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer ts.Close()
+
+	hook, err := webhook.New(&webhook.Options{URL: ts.URL, Template: "{{.CorrelationID}}", FirstOnly: "protocol"})
+	require.Nil(t, err, "could not create webhook")
+	defer hook.Close()
+	options := &Options{IDFormat: IDFormat{Length: 8, NonceLength: 4, Alphabet: "abcdefghijklmnopqrstuvwxyz0123456789"}}
+	options.SetWebhooks([]*webhook.Webhook{hook})
+
+	// the sessions of the short correlation ids sharing the first characters of the longer ones
+	for _, interaction := range []struct{ correlationID, uniqueID string }{{"abcd1234", "abcd1234wxyz"}, {"abcd1234", "abcd1234zyxw"}, {"abcd5678", "abcd5678wxyz"}} {
+		err := options.writeInteraction(interaction.correlationID, &Interaction{Protocol: "dns", UniqueID: interaction.uniqueID}, func([]byte) error { return nil })
+		require.Nil(t, err, "could not write interaction")
+	}
+	for _, correlationID := range []string{"abcd1234", "abcd5678"} {
+		select {
+		case body := <-received:
+			require.Equal(t, correlationID, body, "could not deliver first interaction of session")
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "could not deliver webhook")
+		}
+	}
+	select {
+	case body := <-received:
+		require.Fail(t, "delivered repeated interaction", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"sync"
+)
+
+// sessionLimiter limits the number of sessions that can be
+// registered concurrently from the same source ip.
+type sessionLimiter struct {
+	sync.Mutex
+	max      int
+	sessions map[string]map[string]struct{}
+	owners   map[string]string
+	exists   func(correlationID string) bool
+}
+
+// newSessionLimiter returns a new session limiter allowing max sessions per ip.
+//
+// exists reports whether a session is still alive in the storage so that
+// evicted sessions stop counting towards the limit.
+func newSessionLimiter(max int, exists func(correlationID string) bool) *sessionLimiter {
+	return &sessionLimiter{
+		max:      max,
+		sessions: make(map[string]map[string]struct{}),
+		owners:   make(map[string]string),
+		exists:   exists,
+	}
+}
+
+// Acquire reserves a session for the ip, returning false if the
+// ip has already reached the maximum number of sessions.
+func (s *sessionLimiter) Acquire(ip, correlationID string) bool {
+	if s == nil {
+		return true
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.owners[correlationID]; ok {
+		return true
+	}
+	if len(s.owners) >= maxAccountedSessions {
+		s.prune()
+	}
+	sessions, ok := s.sessions[ip]
+	if !ok {
+		sessions = make(map[string]struct{})
+		s.sessions[ip] = sessions
+	}
+	if len(sessions) >= s.max {
+		for id := range sessions {
+			if !s.exists(id) {
+				delete(sessions, id)
+				delete(s.owners, id)
+			}
+		}
+	}
+	if len(sessions) >= s.max {
+		return false
+	}
+	sessions[correlationID] = struct{}{}
+	s.owners[correlationID] = ip
+	return true
+}
+
+// Release releases a session reserved by Acquire once it's
+// no longer present in the storage.
+func (s *sessionLimiter) Release(correlationID string) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	ip, ok := s.owners[correlationID]
+	if !ok || s.exists(correlationID) {
+		return
+	}
+	delete(s.owners, correlationID)
+	delete(s.sessions[ip], correlationID)
+	if len(s.sessions[ip]) == 0 {
+		delete(s.sessions, ip)
+	}
+}
+
+// prune forgets the sessions of all the ips which aren't alive anymore,
+// eg. evicted at their ttl
+func (s *sessionLimiter) prune() {
+	for correlationID, ip := range s.owners {
+		if !s.exists(correlationID) {
+			delete(s.owners, correlationID)
+			delete(s.sessions[ip], correlationID)
+			if len(s.sessions[ip]) == 0 {
+				delete(s.sessions, ip)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionLimiter(t *testing.T) {
+	alive := map[string]bool{}
+	limiter := newSessionLimiter(2, func(correlationID string) bool { return alive[correlationID] })
+	acquire := func(ip, correlationID string) bool {
+		ok := limiter.Acquire(ip, correlationID)
+		if ok {
+			alive[correlationID] = true
+		}
+		return ok
+	}
+
+	require.True(t, acquire("1.1.1.1", "a"), "could not acquire first session")
+	require.True(t, acquire("1.1.1.1", "b"), "could not acquire second session")
+	require.True(t, acquire("1.1.1.1", "a"), "could not acquire session again")
+	require.False(t, acquire("1.1.1.1", "c"), "acquired session past the limit")
+	require.True(t, acquire("2.2.2.2", "c"), "could not acquire session of other ip")
+
+	limiter.Release("a")
+	require.False(t, acquire("1.1.1.1", "d"), "released session still alive")
+	delete(alive, "a")
+	limiter.Release("a")
+	require.True(t, acquire("1.1.1.1", "d"), "could not acquire released session")
+
+	delete(alive, "b")
+	require.True(t, acquire("1.1.1.1", "e"), "evicted session still counted")
+	require.False(t, acquire("1.1.1.1", "f"), "acquired session past the limit")
+}
+
+func TestSessionLimiterPrune(t *testing.T) {
+	alive := map[string]bool{}
+	limiter := newSessionLimiter(1, func(correlationID string) bool { return alive[correlationID] })
+	for i := 0; i < maxAccountedSessions; i++ {
+		require.True(t, limiter.Acquire(fmt.Sprintf("ip-%d", i), fmt.Sprintf("id-%d", i)), "could not acquire session")
+	}
+	alive["id-0"] = true
+	require.True(t, limiter.Acquire("other", "other"), "could not acquire session")
+	require.Len(t, limiter.owners, 2, "could not prune evicted sessions")
+	require.Len(t, limiter.sessions, 2, "could not prune ips without sessions")
+}
+
+func TestSessionLimiterRemoved(t *testing.T) {
+	store, _ := newTestSession(t)
+	options := &Options{Storage: store, sessionLimiter: newSessionLimiter(1, store.HasID)}
+	require.True(t, options.sessionLimiter.Acquire("1.1.1.1", "c23b2la0kl1krjcrdj10"), "could not acquire session")
+	require.False(t, options.sessionLimiter.Acquire("1.1.1.1", "c23b2la0kl1krjcrdj11"), "acquired session past the limit")
+
+	require.Nil(t, store.RemoveSession("c23b2la0kl1krjcrdj10"), "could not remove session")
+	options.sessionRemoved("c23b2la0kl1krjcrdj10")
+	require.Empty(t, options.sessionLimiter.owners, "could not release removed session")
+}
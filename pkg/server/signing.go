@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+)
+
+// SignatureScheme is the authorization scheme of the signed requests:
+//
+//	Authorization: HMAC-SHA256 keyid=<id>,timestamp=<unix>,nonce=<hex>,signature=<hex>
+//
+// the key id being the fingerprint of the token the request is signed with,
+// and the signature the hex hmac-sha256 keyed with the token of the method,
+// the request uri, the timestamp, the nonce and the hex sha256 of the body
+// separated by newlines. The token itself is never sent.
+const SignatureScheme = "HMAC-SHA256"
+
+// SignatureWindow is the maximum difference between the timestamp of a signed
+// request and the clock of the server, the nonces being remembered as long.
+const SignatureWindow = 5 * time.Minute
+
+// maxSignedBodySize is the maximum size of the body of a signed request
+const maxSignedBodySize = 16 << 20
+
+// maxSeenNonces is the maximum number of nonces remembered within the window
+// for each key id, so that a tenant signing too many requests doesn't lock
+// out the others
+const maxSeenNonces = 100000
+
+// SignRequest returns the authorization header of a request signed with a
+// token at a time, with a random nonce.
+func SignRequest(method, requestURI, token string, body []byte, now time.Time) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Wrap(err, "could not generate nonce")
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	encodedNonce := hex.EncodeToString(nonce)
+	signature := requestSignature(token, method, requestURI, timestamp, encodedNonce, body)
+	return fmt.Sprintf("%s keyid=%s,timestamp=%s,nonce=%s,signature=%s", SignatureScheme, tokenFingerprint(token), timestamp, encodedNonce, signature), nil
+}
+
+// requestSignature returns the hex hmac-sha256 of a request keyed with a token
+func requestSignature(token, method, requestURI, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(token))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", method, requestURI, timestamp, nonce, hex.EncodeToString(bodyHash[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// tokenFingerprint returns the key id identifying a token in the signed requests
+func tokenFingerprint(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:8])
+}
+
+// SignatureVerifier verifies the requests signed with the tokens instead of
+// sending them, rejecting the requests signed outside the SignatureWindow and
+// the replayed ones.
+type SignatureVerifier struct {
+	sync.Mutex
+	required bool
+	// seen are the expiry times of the nonces seen by key id
+	seen map[string]map[string]time.Time
+}
+
+// NewSignatureVerifier returns a signature verifier, the requests sending
+// their token being rejected if the signatures are required.
+func NewSignatureVerifier(required bool) *SignatureVerifier {
+	return &SignatureVerifier{required: required, seen: make(map[string]map[string]time.Time)}
+}
+
+// Required returns true if the requests must be signed.
+func (v *SignatureVerifier) Required() bool {
+	return v != nil && v.required
+}
+
+// verify returns the tenant and the token of a signed request with the
+// parameters of its authorization header, keys returning the tokens of a key
+// id along with their tenant. The body of the request is restored for the handlers.
+func (v *SignatureVerifier) verify(req *http.Request, params string, keys func(keyID string) ([]string, *Tenant)) (*Tenant, string, bool) {
+	if v == nil {
+		return nil, "", false
+	}
+	values := make(map[string]string, 4)
+	for _, param := range strings.Split(params, ",") {
+		if parts := strings.SplitN(strings.TrimSpace(param), "=", 2); len(parts) == 2 {
+			values[parts[0]] = parts[1]
+		}
+	}
+	timestamp, nonce, signature := values["timestamp"], values["nonce"], values["signature"]
+	if nonce == "" || signature == "" {
+		return nil, "", false
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, "", false
+	}
+	now := time.Now()
+	signedAt := time.Unix(unix, 0)
+	if signedAt.Before(now.Add(-SignatureWindow)) || signedAt.After(now.Add(SignatureWindow)) {
+		gologger.Debug().Msgf("Rejected request signed at %s outside the window\n", signedAt)
+		return nil, "", false
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(io.LimitReader(req.Body, maxSignedBodySize+1))
+		if err != nil || len(body) > maxSignedBodySize {
+			return nil, "", false
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	tokens, tenant := keys(values["keyid"])
+	for _, token := range tokens {
+		expected := requestSignature(token, req.Method, req.URL.RequestURI(), timestamp, nonce, body)
+		if hmac.Equal([]byte(expected), []byte(signature)) {
+			return tenant, token, v.remember(values["keyid"], nonce, signedAt.Add(SignatureWindow), now)
+		}
+	}
+	return nil, "", false
+}
+
+// remember returns false for a nonce already seen with a key id, remembering
+// it until a time otherwise
+func (v *SignatureVerifier) remember(keyID, nonce string, until, now time.Time) bool {
+	v.Lock()
+	defer v.Unlock()
+
+	seen, ok := v.seen[keyID]
+	if !ok {
+		seen = make(map[string]time.Time)
+		v.seen[keyID] = seen
+	}
+	if _, ok := seen[nonce]; ok {
+		gologger.Debug().Msgf("Rejected replayed request with nonce %s\n", nonce)
+		return false
+	}
+	if len(seen) >= maxSeenNonces {
+		for seenNonce, expiry := range seen {
+			if !now.Before(expiry) {
+				delete(seen, seenNonce)
+			}
+		}
+		if len(seen) >= maxSeenNonces {
+			gologger.Error().Msgf("Rejected signed request of key %s: too many requests within the signature window\n", keyID)
+			return false
+		}
+	}
+	seen[nonce] = until
+	return true
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedRequests(t *testing.T) {
+	encoded := encodedTestPublicKey(t)
+
+	store := storage.New(time.Hour)
+	tokens, err := NewTokenStore([]*Tenant{{Name: "red", Token: "red-token"}}, store.HasID)
+	require.Nil(t, err, "could not create token store")
+	options := &Options{Domain: "example.com", Storage: store, Auth: true, Token: "server-token", Tokens: tokens, Signatures: NewSignatureVerifier(false)}
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+	do := func(method, path, authorization, body string) int {
+		req := httptest.NewRequest(method, "http://example.com"+path, strings.NewReader(body))
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		server.nontlsserver.Handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	sign := func(method, path, token, body string, at time.Time) string {
+		authorization, err := SignRequest(method, path, token, []byte(body), at)
+		require.Nil(t, err, "could not sign request")
+		return authorization
+	}
+
+	register := `{"public-key":"` + encoded + `","secret-key":"secret","correlation-id":"c23b2la0kl1krjcrdj10"}`
+	signed := sign(http.MethodPost, "/register", "red-token", register, time.Now())
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/register", signed, strings.Replace(register, "secret", "other", 1)), "could register with tampered body")
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/register", signed, register), "could not register with signed request")
+	require.Equal(t, "red", tokens.Owner("c23b2la0kl1krjcrdj10"), "could not authenticate tenant of signed request")
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/register", signed, register), "could replay signed request")
+
+	poll := "/poll?id=c23b2la0kl1krjcrdj10&secret=secret"
+	require.Equal(t, http.StatusOK, do(http.MethodGet, poll, sign(http.MethodGet, poll, "server-token", "", time.Now()), ""), "could not poll with signed request")
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, poll, sign(http.MethodGet, poll, "invalid", "", time.Now()), ""), "could poll with invalid token")
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, poll, sign(http.MethodGet, "/poll?id=other", "red-token", "", time.Now()), ""), "could poll with signature of another uri")
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, poll, sign(http.MethodGet, poll, "red-token", "", time.Now().Add(-2*SignatureWindow)), ""), "could poll with expired signature")
+	require.Equal(t, http.StatusOK, do(http.MethodGet, poll, "red-token", ""), "could not poll with token")
+
+	// the tokens aren't accepted anymore once the signatures are required
+	options.Signatures = NewSignatureVerifier(true)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, poll, "red-token", ""), "could poll with token when signatures are required")
+	require.Equal(t, http.StatusOK, do(http.MethodGet, poll, sign(http.MethodGet, poll, "red-token", "", time.Now()), ""), "could not poll with required signature")
+}
+
+func TestSignedRegisterPrefix(t *testing.T) {
+	encoded := encodedTestPublicKey(t)
+
+	store := storage.New(time.Hour)
+	tokens, err := NewTokenStore([]*Tenant{{Name: "red", Token: "red-token"}}, store.HasID)
+	require.Nil(t, err, "could not create token store")
+	options := &Options{Domain: "example.com", Storage: store, Auth: true, Token: "server-token", Tokens: tokens, Signatures: NewSignatureVerifier(false),
+		IDPrefixes: map[string]string{"acme": "red-token", "corp": ""}}
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+	register := func(token, correlationID string) int {
+		body := `{"public-key":"` + encoded + `","secret-key":"secret","correlation-id":"` + correlationID + `","id-scheme":"prefix"}`
+		authorization, err := SignRequest(http.MethodPost, "/register", token, []byte(body), time.Now())
+		require.Nil(t, err, "could not sign request")
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/register", strings.NewReader(body))
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		server.nontlsserver.Handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	require.Equal(t, http.StatusOK, register("red-token", "acme-c23b2la0kl1krjcrdj10"), "could not register signed prefix of tenant")
+	require.Equal(t, http.StatusOK, register("server-token", "corp-c23b2la0kl1krjcrdj10"), "could not register signed prefix of server token")
+	require.Equal(t, http.StatusBadRequest, register("server-token", "acme-c59e3crp82ke7bi4e4tg"), "registered signed prefix reserved for another token")
+}
+
+func TestSignatureVerifierNoncesPerKey(t *testing.T) {
+	verifier := NewSignatureVerifier(false)
+	now := time.Now()
+	until := now.Add(SignatureWindow)
+	for i := 0; i < maxSeenNonces; i++ {
+		require.True(t, verifier.remember("red", strconv.Itoa(i), until, now), "could not remember nonce")
+	}
+	require.False(t, verifier.remember("red", "0", until, now), "accepted replayed nonce")
+	require.False(t, verifier.remember("red", "flood", until, now), "accepted nonce above the limit of the key")
+	require.True(t, verifier.remember("blue", "0", until, now), "could not remember nonce of other key")
+	require.True(t, verifier.remember("red", "expired", until, until), "could not remember nonce once the others expired")
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxMIMEDepth is the maximum nesting of multipart bodies parsed for attachments
+const maxMIMEDepth = 10
+
+// suspiciousExtensions are the extensions of executables, scripts and
+// archives which are quarantined instead of being returned to the client
+var suspiciousExtensions = map[string]struct{}{
+	".exe": {}, ".dll": {}, ".scr": {}, ".com": {}, ".pif": {}, ".cpl": {}, ".msi": {}, ".msp": {},
+	".bat": {}, ".cmd": {}, ".ps1": {}, ".vbs": {}, ".vbe": {}, ".js": {}, ".jse": {}, ".wsf": {},
+	".hta": {}, ".jar": {}, ".lnk": {}, ".reg": {}, ".sh": {}, ".elf": {}, ".apk": {}, ".dmg": {},
+	".zip": {}, ".rar": {}, ".7z": {}, ".gz": {}, ".tgz": {}, ".tar": {}, ".bz2": {}, ".xz": {},
+	".cab": {}, ".iso": {}, ".img": {}, ".vhd": {}, ".arj": {}, ".ace": {},
+	".docm": {}, ".xlsm": {}, ".pptm": {}, ".xlam": {},
+}
+
+// suspiciousMagics are the file signatures of executables and archives
+var suspiciousMagics = [][]byte{
+	[]byte("MZ"),                        // windows executable
+	[]byte("\x7fELF"),                   // elf executable
+	[]byte("\xcf\xfa\xed\xfe"),          // mach-o executable
+	[]byte("PK\x03\x04"),                // zip, jar, office documents
+	[]byte("Rar!\x1a\x07"),              // rar
+	[]byte("7z\xbc\xaf\x27\x1c"),        // 7z
+	[]byte("\x1f\x8b"),                  // gzip
+	[]byte("MSCF"),                      // cab
+	[]byte("\xd0\xcf\x11\xe0"),          // ole documents and msi
+	[]byte("L\x00\x00\x00\x01\x14\x02"), // windows shortcut
+}
+
+// quarantineNameRegex validates the correlation ids and hashes used as
+// quarantine paths.
+var quarantineNameRegex = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// SMTPAttachment is an attachment received over smtp.
+type SMTPAttachment struct {
+	// Filename is the file name of the attachment
+	Filename string `json:"filename,omitempty"`
+	// ContentType is the content type of the attachment
+	ContentType string `json:"content-type,omitempty"`
+	// Size is the decoded size of the attachment in bytes
+	Size int `json:"size"`
+	// MD5 is the hex encoded md5 hash of the attachment
+	MD5 string `json:"md5"`
+	// SHA1 is the hex encoded sha1 hash of the attachment
+	SHA1 string `json:"sha1"`
+	// SHA256 is the hex encoded sha256 hash of the attachment
+	SHA256 string `json:"sha256"`
+	// Suspicious is true for executables, scripts and archives
+	Suspicious bool `json:"suspicious,omitempty"`
+	// Quarantined is true if the attachment has been stored in the quarantine
+	Quarantined bool `json:"quarantined,omitempty"`
+	// Stored is true if the attachment has been stored for retrieval while
+	// being kept in the raw request
+	Stored bool `json:"stored,omitempty"`
+}
+
+// smtpAttachment is a parsed attachment along with its raw encoded body
+type smtpAttachment struct {
+	*SMTPAttachment
+	content []byte
+	raw     string
+}
+
+// Quarantine stores the suspicious attachments received over smtp on disk,
+// encrypted with the key of the session which received them.
+type Quarantine struct {
+	directory string
+}
+
+// NewQuarantine returns a new quarantine storing the attachments in the directory.
+func NewQuarantine(directory string) (*Quarantine, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, errors.Wrap(err, "could not create quarantine directory")
+	}
+	return &Quarantine{directory: directory}, nil
+}
+
+// path returns the path of the attachments of the correlation id
+func (q *Quarantine) path(correlationID string, sha256 ...string) (string, error) {
+	for _, name := range append(sha256, correlationID) {
+		if !quarantineNameRegex.MatchString(name) {
+			return "", errors.New("invalid quarantine id")
+		}
+	}
+	return filepath.Join(append([]string{q.directory, correlationID}, sha256...)...), nil
+}
+
+// Store stores an encrypted attachment of the correlation id.
+func (q *Quarantine) Store(correlationID, sha256, encrypted string) error {
+	path, err := q.path(correlationID, sha256)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, "could not create quarantine directory")
+	}
+	return ioutil.WriteFile(path, []byte(encrypted), 0600)
+}
+
+// Load returns an encrypted attachment of the correlation id.
+func (q *Quarantine) Load(correlationID, sha256 string) (string, error) {
+	path, err := q.path(correlationID, sha256)
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.New("attachment not found")
+	}
+	return string(data), nil
+}
+
+// Remove removes all the attachments of the correlation id.
+func (q *Quarantine) Remove(correlationID string) error {
+	path, err := q.path(correlationID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+// parsePart parses a mime part collecting the text parts and the attachments
+func (p *mimeParts) parsePart(contentType, disposition, encoding string, body io.Reader, depth int) error {
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxMIMEDepth || params["boundary"] == "" {
+			return nil
+		}
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return errors.Wrap(err, "could not parse multipart body")
+			}
+			if err := p.parsePart(part.Header.Get("Content-Type"), part.Header.Get("Content-Disposition"), part.Header.Get("Content-Transfer-Encoding"), part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, dispositionParams, _ := mime.ParseMediaType(disposition)
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	isAttachment := filename != "" || strings.HasPrefix(strings.ToLower(disposition), "attachment")
+	if isAttachment && len(p.attachments) >= p.maxAttachments {
+		return nil
+	}
+
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return errors.Wrap(err, "could not read attachment")
+	}
+	content := raw
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		decoded, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(raw)))
+		if err == nil {
+			content = decoded
+		}
+	case "quoted-printable":
+		decoded, err := ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(raw)))
+		if err == nil {
+			content = decoded
+		}
+	}
+
+	if !isAttachment {
+		p.addText(mediaType, params["charset"], content)
+		return nil
+	}
+	md5Sum, sha1Sum, sha256Sum := md5.Sum(content), sha1.Sum(content), sha256.Sum256(content)
+	p.attachments = append(p.attachments, &smtpAttachment{
+		SMTPAttachment: &SMTPAttachment{
+			Filename:    filename,
+			ContentType: mediaType,
+			Size:        len(content),
+			MD5:         hex.EncodeToString(md5Sum[:]),
+			SHA1:        hex.EncodeToString(sha1Sum[:]),
+			SHA256:      hex.EncodeToString(sha256Sum[:]),
+			Suspicious:  isSuspiciousAttachment(filename, content),
+		},
+		content: content,
+		raw:     string(raw),
+	})
+	return nil
+}
+
+// isSuspiciousAttachment returns true if the attachment is an executable,
+// a script or an archive based on its extension or its content
+func isSuspiciousAttachment(filename string, content []byte) bool {
+	if _, ok := suspiciousExtensions[strings.ToLower(filepath.Ext(filename))]; ok {
+		return true
+	}
+	for _, magic := range suspiciousMagics {
+		if bytes.HasPrefix(content, magic) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+const testMessage = "From: sender@example.com\r\n" +
+	"To: user@c23b2la0kl1krjcrdj10cndmnioyyyyyn.example.com\r\n" +
+	"Subject: =?utf-8?q?invoice_=E2=82=AC?=\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"boundary\"\r\n" +
+	"\r\n" +
+	"--boundary\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"please find the invoice attached\r\n" +
+	"--boundary\r\n" +
+	"Content-Type: text/plain; name=\"notes.txt\"\r\n" +
+	"Content-Disposition: attachment; filename=\"notes.txt\"\r\n" +
+	"\r\n" +
+	"notes\r\n" +
+	"--boundary\r\n" +
+	"Content-Type: application/octet-stream; name=\"invoice.exe\"\r\n" +
+	"Content-Disposition: attachment; filename=\"invoice.exe\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"TVqQAAMAAAAEAAAA\r\n" +
+	"--boundary--\r\n"
+
+func TestSMTPAttachmentsQuarantine(t *testing.T) {
+	message, attachments, err := parseMessage("sender@example.com", []string{"user@c23b2la0kl1krjcrdj10cndmnioyyyyyn.example.com"}, []byte(testMessage), 10)
+	require.Nil(t, err, "could not parse attachments")
+	require.Equal(t, "sender@example.com", message.MailFrom, "could not get envelope sender")
+	require.Equal(t, []string{"invoice €"}, message.Headers["Subject"], "could not decode subject header")
+	require.Len(t, message.Parts, 1, "could not get text parts")
+	require.Equal(t, "please find the invoice attached", message.Parts[0].Content, "could not get text part content")
+	require.Len(t, attachments, 2, "could not get attachments")
+	require.Equal(t, "notes.txt", attachments[0].Filename, "could not get attachment filename")
+	require.False(t, attachments[0].Suspicious, "text attachment is suspicious")
+	require.Equal(t, "invoice.exe", attachments[1].Filename, "could not get attachment filename")
+	require.True(t, attachments[1].Suspicious, "executable attachment is not suspicious")
+	require.Equal(t, 12, attachments[1].Size, "could not decode attachment")
+
+	directory, err := ioutil.TempDir("", "quarantine")
+	require.Nil(t, err, "could not create quarantine directory")
+	defer os.RemoveAll(directory)
+	quarantine, err := NewQuarantine(directory)
+	require.Nil(t, err, "could not create quarantine")
+
+	encoded := encodedTestPublicKey(t)
+
+	correlationID := "c23b2la0kl1krjcrdj10"
+	store := storage.New(time.Hour)
+	require.Nil(t, store.SetIDPublicKey(correlationID, "secret", encoded), "could not register session")
+
+	server := &SMTPServer{options: &Options{Storage: store, Quarantine: quarantine}}
+	smtpAttachments, rawRequest := server.processAttachments(correlationID, testMessage, attachments)
+	require.False(t, smtpAttachments[0].Quarantined, "text attachment is quarantined")
+	require.True(t, smtpAttachments[1].Quarantined, "executable attachment is not quarantined")
+	require.False(t, strings.Contains(rawRequest, "TVqQAAMAAAAEAAAA"), "executable attachment is in raw request")
+	require.True(t, strings.Contains(rawRequest, "notes\r\n"), "text attachment is not in raw request")
+
+	data, err := quarantine.Load(correlationID, smtpAttachments[1].SHA256)
+	require.Nil(t, err, "could not load quarantined attachment")
+	require.NotContains(t, data, "TVqQAAMAAAAEAAAA", "quarantined attachment is not encrypted")
+
+	server.options.SmtpStoreAttachments = true
+	server.options.SmtpMaxAttachmentSize = 5
+	smtpAttachments, rawRequest = server.processAttachments(correlationID, testMessage, attachments)
+	require.True(t, smtpAttachments[0].Stored, "could not store text attachment")
+	require.True(t, strings.Contains(rawRequest, "notes\r\n"), "stored attachment is not in raw request")
+	_, err = quarantine.Load(correlationID, smtpAttachments[0].SHA256)
+	require.Nil(t, err, "could not load stored attachment")
+	server.options.SmtpMaxAttachmentSize = 4
+	smtpAttachments, _ = server.processAttachments(correlationID, testMessage, attachments)
+	require.False(t, smtpAttachments[0].Stored, "stored attachment larger than maximum size")
+
+	require.Nil(t, quarantine.Remove(correlationID), "could not remove quarantined attachments")
+	_, err = quarantine.Load(correlationID, smtpAttachments[1].SHA256)
+	require.NotNil(t, err, "could load removed attachment")
+}
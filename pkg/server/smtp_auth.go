@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net"
+	"sync"
+)
+
+// smtpAuthMechanisms are the authentication mechanisms advertised by the
+// smtp servers, the plaintext ones being allowed before STARTTLS as well
+var smtpAuthMechanisms = map[string]bool{"LOGIN": true, "PLAIN": true, "CRAM-MD5": true}
+
+// SMTPCredential is a credential presented by a client with SMTP AUTH.
+type SMTPCredential struct {
+	// Mechanism is the authentication mechanism (PLAIN, LOGIN or CRAM-MD5)
+	Mechanism string `json:"mechanism"`
+	// Username is the username presented
+	Username string `json:"username"`
+	// Password is the password presented, the hex encoded digest for CRAM-MD5
+	Password string `json:"password"`
+	// Challenge is the challenge of the CRAM-MD5 digest
+	Challenge string `json:"challenge,omitempty"`
+}
+
+// smtpAuthRecorder records the credentials presented on the connections
+// accepted by the smtp servers, by remote address, until they are closed.
+type smtpAuthRecorder struct {
+	mutex       sync.Mutex
+	credentials map[string][]*SMTPCredential
+}
+
+// newSMTPAuthRecorder returns a new smtp credentials recorder.
+func newSMTPAuthRecorder() *smtpAuthRecorder {
+	return &smtpAuthRecorder{credentials: make(map[string][]*SMTPCredential)}
+}
+
+// Listener wraps a listener removing the credentials of the closed connections.
+func (r *smtpAuthRecorder) Listener(listener net.Listener) net.Listener {
+	return &smtpAuthListener{Listener: listener, recorder: r}
+}
+
+// handler records the credentials of every authentication attempt, accepting them
+func (r *smtpAuthRecorder) handler(remoteAddr net.Addr, mechanism string, username []byte, password []byte, shared []byte) (bool, error) {
+	credential := &SMTPCredential{Mechanism: mechanism, Username: string(username), Password: string(password), Challenge: string(shared)}
+	r.mutex.Lock()
+	r.credentials[remoteAddr.String()] = append(r.credentials[remoteAddr.String()], credential)
+	r.mutex.Unlock()
+	return true, nil
+}
+
+// Get returns the credentials presented by a remote address, if any.
+func (r *smtpAuthRecorder) Get(remoteAddr string) []*SMTPCredential {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	credentials := r.credentials[remoteAddr]
+	return credentials[:len(credentials):len(credentials)]
+}
+
+type smtpAuthListener struct {
+	net.Listener
+	recorder *smtpAuthRecorder
+}
+
+func (l *smtpAuthListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &smtpAuthConn{Conn: conn, recorder: l.recorder}, nil
+}
+
+// smtpAuthConn removes the credentials of the connection once closed
+type smtpAuthConn struct {
+	net.Conn
+	recorder *smtpAuthRecorder
+}
+
+func (c *smtpAuthConn) Close() error {
+	c.recorder.mutex.Lock()
+	delete(c.recorder.credentials, c.Conn.RemoteAddr().String())
+	c.recorder.mutex.Unlock()
+	return c.Conn.Close()
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net"
+	"net/smtp"
+	"testing"
+	"time"
+
+	"git.mills.io/prologic/smtpd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSMTPAuthRecorder(t *testing.T) {
+	recorder := newSMTPAuthRecorder()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "could not listen")
+	credentials := make(chan []*SMTPCredential, 1)
+	srv := &smtpd.Server{
+		Hostname:    "example.com",
+		AuthHandler: recorder.handler,
+		AuthMechs:   smtpAuthMechanisms,
+		Handler: func(remoteAddr net.Addr, from string, to []string, data []byte) error {
+			credentials <- recorder.Get(remoteAddr.String())
+			return nil
+		},
+	}
+	go func() { _ = srv.Serve(recorder.Listener(listener)) }()
+	defer listener.Close()
+
+	auth := smtp.PlainAuth("", "svc-account", "s3cret", "127.0.0.1")
+	require.Nil(t, smtp.SendMail(listener.Addr().String(), auth, "a@example.net", []string{"b@example.com"}, []byte("Subject: test\r\n\r\nbody\r\n")), "could not send mail")
+	recorded := <-credentials
+	require.Len(t, recorded, 1, "could not record credentials")
+	require.Equal(t, &SMTPCredential{Mechanism: "PLAIN", Username: "svc-account", Password: "s3cret"}, recorded[0], "could not record plain credentials")
+
+	require.Eventually(t, func() bool {
+		recorder.mutex.Lock()
+		defer recorder.mutex.Unlock()
+		return len(recorder.credentials) == 0
+	}, time.Second, 10*time.Millisecond, "could not remove credentials of closed connection")
+}
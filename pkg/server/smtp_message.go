@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"mime"
+	"net/mail"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxSMTPPartSize is the maximum size in bytes of the content of a text part recorded
+const maxSMTPPartSize = 64 * 1024
+
+// SMTPMessage is the parsed structure of a mail received over smtp.
+type SMTPMessage struct {
+	// MailFrom is the envelope sender of the mail
+	MailFrom string `json:"mail-from"`
+	// RcptTo are the envelope recipients of the mail
+	RcptTo []string `json:"rcpt-to"`
+	// Headers are the headers of the mail, with their encoded words decoded
+	Headers map[string][]string `json:"headers,omitempty"`
+	// Parts are the decoded mime parts of the mail other than the attachments
+	Parts []*SMTPPart `json:"parts,omitempty"`
+}
+
+// SMTPPart is a decoded mime part of a mail.
+type SMTPPart struct {
+	// ContentType is the media type of the part
+	ContentType string `json:"content-type"`
+	// Charset is the charset of a text part, if set
+	Charset string `json:"charset,omitempty"`
+	// Size is the decoded size of the part in bytes
+	Size int `json:"size"`
+	// Content is the decoded content of a text part
+	Content string `json:"content,omitempty"`
+	// Truncated is true if the content is larger than the recorded one
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// mimeParts collects the text parts and the attachments of a message
+type mimeParts struct {
+	maxAttachments int
+	parts          []*SMTPPart
+	attachments    []*smtpAttachment
+}
+
+// parseMessage returns the structure of a mail message received from an
+// envelope and its attachments, up to maxAttachments.
+func parseMessage(from string, to []string, data []byte, maxAttachments int) (*SMTPMessage, []*smtpAttachment, error) {
+	parsed := &SMTPMessage{MailFrom: from, RcptTo: to}
+	message, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return parsed, nil, errors.Wrap(err, "could not parse message")
+	}
+	decoder := &mime.WordDecoder{}
+	parsed.Headers = make(map[string][]string, len(message.Header))
+	for name, values := range message.Header {
+		for _, value := range values {
+			if decoded, err := decoder.DecodeHeader(value); err == nil {
+				value = decoded
+			}
+			parsed.Headers[name] = append(parsed.Headers[name], value)
+		}
+	}
+	parts := &mimeParts{maxAttachments: maxAttachments}
+	err = parts.parsePart(message.Header.Get("Content-Type"), message.Header.Get("Content-Disposition"), message.Header.Get("Content-Transfer-Encoding"), message.Body, 0)
+	parsed.Parts = parts.parts
+	return parsed, parts.attachments, err
+}
+
+// addText records a part which isn't an attachment, with its content if
+// it's a text part (the default content type of the mails)
+func (p *mimeParts) addText(mediaType, charset string, content []byte) {
+	if mediaType == "" {
+		mediaType = "text/plain"
+	}
+	part := &SMTPPart{ContentType: mediaType, Charset: charset, Size: len(content)}
+	if strings.HasPrefix(mediaType, "text/") {
+		if len(content) > maxSMTPPartSize {
+			content, part.Truncated = content[:maxSMTPPartSize], true
+		}
+		part.Content = string(content)
+	}
+	p.parts = append(p.parts, part)
+}
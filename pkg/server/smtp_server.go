@@ -1,7 +1,7 @@
 package server
 
 import (
-	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
@@ -9,8 +9,19 @@ import (
 	"time"
 
 	"git.mills.io/prologic/smtpd"
-	jsoniter "github.com/json-iterator/go"
 	"github.com/projectdiscovery/gologger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// STARTTLS policies of the smtp and submission ports
+const (
+	// SMTPStartTLSAdvertise advertises STARTTLS, accepting the plaintext mails
+	SMTPStartTLSAdvertise = "advertise"
+	// SMTPStartTLSRequire rejects the mails sent before STARTTLS
+	SMTPStartTLSRequire = "require"
+	// SMTPStartTLSDisable doesn't advertise STARTTLS
+	SMTPStartTLSDisable = "disable"
 )
 
 // SMTPServer is a smtp server instance that listens both
@@ -19,33 +30,42 @@ type SMTPServer struct {
 	options     *Options
 	smtpServer  smtpd.Server
 	smtpsServer smtpd.Server
+
+	clientHellos *clientHelloRecorder
+	packets      *packetRecorder
+	auth         *smtpAuthRecorder
 }
 
 // NewSMTPServer returns a new TLS & Non-TLS SMTP server.
 func NewSMTPServer(options *Options) (*SMTPServer, error) {
-	server := &SMTPServer{options: options}
-
-	authHandler := func(remoteAddr net.Addr, mechanism string, username []byte, password []byte, shared []byte) (bool, error) {
-		return true, nil
+	server := &SMTPServer{options: options, auth: newSMTPAuthRecorder(), clientHellos: &clientHelloRecorder{}}
+	if options.PCAP != nil {
+		server.packets = &packetRecorder{}
 	}
+
+	authHandler := server.auth.handler
 	rcptHandler := func(remoteAddr net.Addr, from string, to string) bool {
 		return true
 	}
 	server.smtpServer = smtpd.Server{
 		Addr:        fmt.Sprintf("%s:%d", options.ListenIP, options.SmtpPort),
 		AuthHandler: authHandler,
+		AuthMechs:   smtpAuthMechanisms,
 		HandlerRcpt: rcptHandler,
 		Hostname:    options.Domain,
 		Appname:     "interactsh",
 		Handler:     smtpd.Handler(server.defaultHandler),
+		MaxSize:     options.SmtpMaxSize,
 	}
 	server.smtpsServer = smtpd.Server{
 		Addr:        fmt.Sprintf("%s:%d", options.ListenIP, options.SmtpsPort),
 		AuthHandler: authHandler,
+		AuthMechs:   smtpAuthMechanisms,
 		HandlerRcpt: rcptHandler,
 		Hostname:    options.Domain,
 		Appname:     "interactsh",
 		Handler:     smtpd.Handler(server.defaultHandler),
+		MaxSize:     options.SmtpMaxSize,
 	}
 	return server, nil
 }
@@ -53,103 +73,199 @@ func NewSMTPServer(options *Options) (*SMTPServer, error) {
 // ListenAndServe listens on smtp and/or smtps ports for the server.
 func (h *SMTPServer) ListenAndServe(tlsConfig *tls.Config, smtpAlive, smtpsAlive chan bool) {
 	go func() {
-		if tlsConfig == nil {
+		if tlsConfig == nil || h.options.SmtpAutoTLSPort == 0 {
 			return
 		}
-		srv := &smtpd.Server{Addr: fmt.Sprintf("%s:%d", h.options.ListenIP, h.options.SmtpAutoTLSPort), Handler: h.defaultHandler, Appname: "interactsh", Hostname: h.options.Domain}
+		srv := &smtpd.Server{Addr: fmt.Sprintf("%s:%d", h.options.ListenIP, h.options.SmtpAutoTLSPort), Handler: h.defaultHandler, AuthHandler: h.auth.handler, AuthMechs: smtpAuthMechanisms, Appname: "interactsh", Hostname: h.options.Domain, MaxSize: h.options.SmtpMaxSize, Timeout: 5 * time.Minute}
 		srv.TLSConfig = tlsConfig
+		srv.TLSListener = true
 
+		listener, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			gologger.Error().Msgf("Could not serve smtp with tls on port %d: %s\n", h.options.SmtpAutoTLSPort, err)
+			smtpsAlive <- false
+			return
+		}
 		smtpsAlive <- true
-		err := srv.ListenAndServe()
+		// the ClientHello of the implicit tls connections is recorded before the handshake
+		err = srv.Serve(tls.NewListener(h.clientHellos.Listener(h.auth.Listener(h.packets.Listener(listener))), tlsConfig))
 		if err != nil {
 			gologger.Error().Msgf("Could not serve smtp with tls on port %d: %s\n", h.options.SmtpAutoTLSPort, err)
 			smtpsAlive <- false
 		}
 	}()
 
-	smtpAlive <- true
-	go func() {
-		if err := h.smtpServer.ListenAndServe(); err != nil {
-			smtpAlive <- false
-			gologger.Error().Msgf("Could not serve smtp on port %d: %s\n", h.options.SmtpPort, err)
+	if tlsConfig != nil && h.options.SmtpStartTLS != SMTPStartTLSDisable {
+		for _, srv := range []*smtpd.Server{&h.smtpServer, &h.smtpsServer} {
+			srv.TLSConfig = tlsConfig
+			srv.TLSRequired = h.options.SmtpStartTLS == SMTPStartTLSRequire
 		}
-	}()
-	if err := h.smtpsServer.ListenAndServe(); err != nil {
+	}
+	if h.options.SmtpPort != 0 {
+		smtpAlive <- true
+		go func() {
+			if err := h.serve(&h.smtpServer); err != nil {
+				smtpAlive <- false
+				gologger.Error().Msgf("Could not serve smtp on port %d: %s\n", h.options.SmtpPort, err)
+			}
+		}()
+	}
+	if h.options.SmtpsPort == 0 {
+		return
+	}
+	if err := h.serve(&h.smtpsServer); err != nil {
 		gologger.Error().Msgf("Could not serve smtp on port %d: %s\n", h.options.SmtpsPort, err)
 		smtpAlive <- false
 	}
 }
 
+// serve listens and serves a smtp server, recording the credentials and the traffic (if enabled) of the connections
+func (h *SMTPServer) serve(srv *smtpd.Server) error {
+	if srv.Timeout == 0 {
+		srv.Timeout = 5 * time.Minute
+	}
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(h.auth.Listener(h.packets.Listener(listener)))
+}
+
 // defaultHandler is a handler for default collaborator requests
 func (h *SMTPServer) defaultHandler(remoteAddr net.Addr, from string, to []string, data []byte) error {
-	var uniqueID, fullID string
+	var uniqueID, fullID, correlationID string
+
+	_, span := tracer.Start(context.Background(), "smtp.message", trace.WithAttributes(
+		attribute.String("smtp.from", from),
+		attribute.StringSlice("smtp.to", to),
+	))
+	defer span.End()
 
 	dataString := string(data)
 	gologger.Debug().Msgf("New SMTP request: %s %s %s %s\n", remoteAddr, from, to, dataString)
 
+	message, attachments, err := parseMessage(from, to, data, h.options.SmtpMaxAttachments)
+	if err != nil {
+		gologger.Debug().Msgf("Could not parse SMTP message: %s\n", err)
+	}
+	span.SetAttributes(attribute.Int("smtp.attachments", len(attachments)))
+	clientHello := h.clientHellos.Get(remoteAddr.String())
+	credentials := h.auth.Get(remoteAddr.String())
+	capture := h.packets.Get(remoteAddr.String())
+
 	// if root-tld is enabled stores any interaction towards the main domain
+	var rootTLD bool
 	for _, addr := range to {
-		if h.options.RootTLD && strings.HasSuffix(addr, h.options.Domain) {
+		if _, ok := h.options.domainOf(addr[strings.Index(addr, "@")+1:]); h.options.RootTLD && ok {
+			rootTLD = true
 			ID := h.options.Domain
 			host, _, _ := net.SplitHostPort(remoteAddr.String())
 			address := addr[strings.Index(addr, "@"):]
+			smtpAttachments, rawRequest := h.processAttachments("", dataString, attachments)
 			interaction := &Interaction{
-				Protocol:      "smtp",
-				UniqueID:      address,
-				FullId:        address,
-				RawRequest:    dataString,
-				SMTPFrom:      from,
-				RemoteAddress: host,
-				Timestamp:     time.Now(),
-			}
-			buffer := &bytes.Buffer{}
-			if err := jsoniter.NewEncoder(buffer).Encode(interaction); err != nil {
-				gologger.Warning().Msgf("Could not encode root tld SMTP interaction: %s\n", err)
-			} else {
-				gologger.Debug().Msgf("Root TLD SMTP Interaction: \n%s\n", buffer.String())
-				if err := h.options.Storage.AddInteractionWithId(ID, buffer.Bytes()); err != nil {
-					gologger.Warning().Msgf("Could not store root tld smtp interaction: %s\n", err)
-				}
+				Protocol:        "smtp",
+				UniqueID:        address,
+				FullId:          address,
+				RawRequest:      rawRequest,
+				SMTPFrom:        from,
+				SMTPAttachments: smtpAttachments,
+				SMTPMessage:     message,
+				SMTPAuth:        credentials,
+				RemoteAddress:   host,
+				Timestamp:       time.Now(),
+				TLSClientHello:  clientHello,
+				capture:         capture,
 			}
+			h.options.storeInteractionWithId(ID, interaction)
 		}
 	}
 
 	for _, addr := range to {
-		if len(addr) > 33 && strings.Contains(addr, "@") {
-			parts := strings.Split(addr[strings.Index(addr, "@")+1:], ".")
-			for i, part := range parts {
-				if len(part) == 33 {
-					uniqueID = part
-					fullID = part
-					if i+1 <= len(parts) {
-						fullID = strings.Join(parts[:i+1], ".")
-					}
-				}
+		if strings.Contains(addr, "@") {
+			if id, full, correlation := h.options.findUniqueID(addr[strings.Index(addr, "@")+1:]); id != "" {
+				uniqueID, fullID, correlationID = id, full, correlation
 			}
 		}
 	}
 	if uniqueID != "" {
 		host, _, _ := net.SplitHostPort(remoteAddr.String())
 
-		correlationID := uniqueID[:20]
+		smtpAttachments, rawRequest := h.processAttachments(correlationID, dataString, attachments)
 		interaction := &Interaction{
-			Protocol:      "smtp",
-			UniqueID:      uniqueID,
-			FullId:        fullID,
-			RawRequest:    dataString,
-			SMTPFrom:      from,
-			RemoteAddress: host,
-			Timestamp:     time.Now(),
+			Protocol:        "smtp",
+			UniqueID:        uniqueID,
+			FullId:          fullID,
+			RawRequest:      rawRequest,
+			SMTPFrom:        from,
+			SMTPAttachments: smtpAttachments,
+			SMTPMessage:     message,
+			SMTPAuth:        credentials,
+			RemoteAddress:   host,
+			Timestamp:       time.Now(),
+			TLSClientHello:  clientHello,
+			capture:         capture,
 		}
-		buffer := &bytes.Buffer{}
-		if err := jsoniter.NewEncoder(buffer).Encode(interaction); err != nil {
-			gologger.Warning().Msgf("Could not encode smtp interaction: %s\n", err)
-		} else {
-			gologger.Debug().Msgf("%s\n", buffer.String())
-			if err := h.options.Storage.AddInteraction(correlationID, buffer.Bytes()); err != nil {
-				gologger.Warning().Msgf("Could not store smtp interaction: %s\n", err)
-			}
+		h.options.storeInteraction(correlationID, interaction)
+	} else if h.options.WildcardLog && !rootTLD {
+		host, _, _ := net.SplitHostPort(remoteAddr.String())
+		smtpAttachments, rawRequest := h.processAttachments("", dataString, attachments)
+		interaction := &Interaction{
+			Protocol:        "smtp",
+			UniqueID:        strings.Join(to, ","),
+			FullId:          strings.Join(to, ","),
+			RawRequest:      rawRequest,
+			SMTPFrom:        from,
+			SMTPAttachments: smtpAttachments,
+			SMTPMessage:     message,
+			SMTPAuth:        credentials,
+			RemoteAddress:   host,
+			Timestamp:       time.Now(),
+			TLSClientHello:  clientHello,
+			capture:         capture,
 		}
+		h.options.storeWildcardInteraction(interaction)
 	}
 	return nil
 }
+
+// processAttachments returns the hashes of the attachments of the message. When the
+// quarantine is enabled the suspicious attachments are removed from the raw request and
+// stored encrypted for the correlation id, if any, to be downloaded by the client, along
+// with the other attachments up to the maximum size if they are stored as well.
+func (h *SMTPServer) processAttachments(correlationID, rawRequest string, attachments []*smtpAttachment) ([]*SMTPAttachment, string) {
+	if len(attachments) == 0 {
+		return nil, rawRequest
+	}
+	smtpAttachments := make([]*SMTPAttachment, 0, len(attachments))
+	for _, attachment := range attachments {
+		// the attachments are shared with the other recipients
+		smtpAttachment := *attachment.SMTPAttachment
+		smtpAttachments = append(smtpAttachments, &smtpAttachment)
+
+		stored := h.options.SmtpStoreAttachments && (h.options.SmtpMaxAttachmentSize <= 0 || attachment.Size <= h.options.SmtpMaxAttachmentSize)
+		if !attachment.Suspicious && !stored || h.options.Quarantine == nil {
+			continue
+		}
+		if attachment.Suspicious && attachment.raw != "" {
+			rawRequest = strings.Replace(rawRequest, attachment.raw, fmt.Sprintf("[attachment removed, sha256: %s]\r\n", attachment.SHA256), 1)
+		}
+		if correlationID == "" {
+			continue
+		}
+		encrypted, err := h.options.Storage.Encrypt(correlationID, attachment.content)
+		if err != nil {
+			gologger.Warning().Msgf("Could not encrypt SMTP attachment %s: %s\n", attachment.SHA256, err)
+			continue
+		}
+		if err := h.options.Quarantine.Store(correlationID, attachment.SHA256, encrypted); err != nil {
+			gologger.Warning().Msgf("Could not quarantine SMTP attachment %s: %s\n", attachment.SHA256, err)
+			continue
+		}
+		if attachment.Suspicious {
+			smtpAttachment.Quarantined = true
+		} else {
+			smtpAttachment.Stored = true
+		}
+	}
+	return smtpAttachments, rawRequest
+}
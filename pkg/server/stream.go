@@ -0,0 +1,157 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/projectdiscovery/gologger"
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+)
+
+const (
+	// streamPingInterval is the interval the streams are pinged at, the ones
+	// not answering two pings in a row being closed
+	streamPingInterval = 30 * time.Second
+	// streamWriteTimeout is the time a stream has to accept a message
+	streamWriteTimeout = 10 * time.Second
+	// streamReadLimit is the maximum size of the messages of the clients,
+	// which only answer the pings
+	streamReadLimit = 512
+)
+
+// CloseSessionNotFound is the close code of the streams of the sessions
+// evicted or unknown to the server, like the 404 of the polls.
+const CloseSessionNotFound = 4404
+
+// streamTokenMiddleware authenticates the streams of the browsers, which
+// can't set the authorization header of a websocket, with the token query
+// parameter.
+func streamTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if token := req.URL.Query().Get("token"); token != "" && req.Header.Get("Authorization") == "" {
+			req.Header.Set("Authorization", token)
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// allowedOrigin returns true for the streams of the clients other than the
+// browsers and of the origin of the acao header.
+func (h *HTTPServer) allowedOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	return origin == "" || h.options.OriginURL == "*" || strings.EqualFold(origin, h.options.OriginURL)
+}
+
+// streamHandler is a handler for the websocket streams pushing the
+// interactions of a session as soon as they are stored, each message being
+// a poll response.
+func (h *HTTPServer) streamHandler(w http.ResponseWriter, req *http.Request) {
+	ID := req.URL.Query().Get("id")
+	if ID == "" {
+		jsonError(w, "no id specified for stream", http.StatusBadRequest)
+		return
+	}
+	secret := req.URL.Query().Get("secret")
+	if secret == "" {
+		jsonError(w, "no secret specified for stream", http.StatusBadRequest)
+		return
+	}
+	tenant, ok := h.checkSession(w, req, ID)
+	if !ok {
+		return
+	}
+	if ok, wait := h.options.Tokens.AllowPoll(tenant); !ok {
+		retryAfter(w, wait)
+		jsonError(w, "too many polls for this token", http.StatusTooManyRequests)
+		return
+	}
+	// the session is checked before the upgrade so that the unknown ones are
+	// answered like the polls, without taking their interactions
+	if _, err := h.options.Storage.GetAESKey(ID, secret); err != nil {
+		code := http.StatusBadRequest
+		if err == storage.ErrCorrelationIDNotFound {
+			code = http.StatusNotFound
+		}
+		jsonError(w, fmt.Sprintf("could not get interactions: %s", err), code)
+		return
+	}
+
+	upgrader := &websocket.Upgrader{CheckOrigin: h.allowedOrigin}
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		gologger.Debug().Msgf("Could not upgrade stream for %s: %s\n", ID, err)
+		return
+	}
+	defer conn.Close()
+
+	keys := []string{ID}
+	if tenant.allows(ScopeAdmin) {
+		keys = append(keys, sharedBuckets)
+	}
+	notified, unsubscribe := h.options.LongPolls.subscribe(keys...)
+	defer unsubscribe()
+
+	// the messages of the client are discarded, reading them handles its
+	// pongs and notices the closed connections
+	closed := make(chan struct{})
+	conn.SetReadLimit(streamReadLimit)
+	go func() {
+		defer close(closed)
+		_ = conn.SetReadDeadline(time.Now().Add(2 * streamPingInterval))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * streamPingInterval))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+	recheck := time.NewTicker(longPollRecheck)
+	defer recheck.Stop()
+	gologger.Debug().Msgf("Streaming interactions for %s correlationID\n", ID)
+	for {
+		response, storedAt, err := h.getInteractions(req.Context(), ID, secret, tenant)
+		if err != nil {
+			gologger.Warning().Msgf("Could not get interactions for %s: %s\n", ID, err)
+			code := websocket.CloseInternalServerErr
+			if err == storage.ErrCorrelationIDNotFound {
+				code = CloseSessionNotFound
+			}
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, err.Error()), time.Now().Add(streamWriteTimeout))
+			return
+		}
+		if !response.empty() {
+			h.delivered(response, ID, storedAt, "stream")
+			data, err := jsoniter.Marshal(response)
+			if err != nil {
+				gologger.Warning().Msgf("Could not encode interactions for %s: %s\n", ID, err)
+				return
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				gologger.Warning().Msgf("Could not stream %d interactions for %s: %s\n", len(response.Data), ID, err)
+				return
+			}
+			gologger.Debug().Msgf("Streamed %d interactions for %s correlationID\n", len(response.Data), ID)
+		}
+
+		select {
+		case <-notified:
+		case <-recheck.C:
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
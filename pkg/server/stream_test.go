@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream(t *testing.T) {
+	store, _ := newTestSession(t)
+	options := &Options{Domain: "example.com", Storage: store, LongPolls: NewLongPolls(0), OriginURL: "https://app.interactsh.com"}
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+	ts := httptest.NewServer(server.nontlsserver.Handler)
+	defer ts.Close()
+	streamURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/stream?id=c23b2la0kl1krjcrdj10&secret="
+
+	_, resp, err := websocket.DefaultDialer.Dial(streamURL+"invalid", nil)
+	require.Equal(t, websocket.ErrBadHandshake, err, "could not reject invalid secret")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, "could not reject invalid secret")
+	_, resp, err = websocket.DefaultDialer.Dial(streamURL+"secret", http.Header{"Origin": {"https://evil.example"}})
+	require.Equal(t, websocket.ErrBadHandshake, err, "could not reject other origin")
+	require.Equal(t, http.StatusForbidden, resp.StatusCode, "could not reject other origin")
+
+	conn, _, err := websocket.DefaultDialer.Dial(streamURL+"secret", http.Header{"Origin": {"https://app.interactsh.com"}})
+	require.Nil(t, err, "could not connect to stream")
+	defer conn.Close()
+
+	start := time.Now()
+	options.storeInteraction("c23b2la0kl1krjcrdj10", &Interaction{Protocol: "dns", UniqueID: "c23b2la0kl1krjcrdj10cndmnioyyyyyn", Timestamp: time.Now()})
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	require.Nil(t, err, "could not read stream")
+	require.Less(t, int64(time.Since(start)), int64(time.Second), "could not stream interaction as soon as stored")
+	response := &PollResponse{}
+	require.Nil(t, jsoniter.Unmarshal(data, response), "could not decode stream message")
+	require.Len(t, response.Data, 1, "could not stream interaction")
+	require.NotEmpty(t, response.AESKey, "could not stream aes key")
+	require.NotNil(t, response.DeliveredAt, "could not set delivery time")
+
+	// the messages above the read limit close the stream
+	large, _, err := websocket.DefaultDialer.Dial(streamURL+"secret", nil)
+	require.Nil(t, err, "could not connect to stream")
+	defer large.Close()
+	require.Nil(t, large.WriteMessage(websocket.TextMessage, make([]byte, streamReadLimit+1)), "could not write message")
+	_ = large.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = large.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), "could not close stream above read limit")
+
+	require.Nil(t, store.RemoveID("c23b2la0kl1krjcrdj10", "secret"), "could not remove session")
+	_, _, err = conn.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, CloseSessionNotFound), "could not close stream of removed session")
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tlsConnRecorder reports the tls connections of the https server closed
+// without any http request being served (failed handshakes of scanners
+// probing random SNI values, clients rejecting the certificate).
+type tlsConnRecorder struct {
+	record func(localAddr, remoteAddr net.Addr, hello *ClientHello)
+
+	served sync.Map
+}
+
+// Handler wraps a handler marking the connections of the served requests.
+func (r *tlsConnRecorder) Handler(handler http.Handler) http.Handler {
+	if r == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.served.Store(req.RemoteAddr, struct{}{})
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// closed reports a connection closed after sending its ClientHello, if no request was served
+func (r *tlsConnRecorder) closed(conn net.Conn, hello *ClientHello) {
+	if r == nil {
+		return
+	}
+	if _, served := r.served.LoadAndDelete(conn.RemoteAddr().String()); served || hello == nil {
+		return
+	}
+	go r.record(conn.LocalAddr(), conn.RemoteAddr(), hello)
+}
+
+// recordTLSConnection stores a tls connection closed without any http
+// request for the session of the interaction id of its SNI, or for the
+// wildcard log or the token holders otherwise.
+func (h *HTTPServer) recordTLSConnection(localAddr, remoteAddr net.Addr, hello *ClientHello) {
+	var message strings.Builder
+	message.WriteString(fmt.Sprintf("ServerName=%s\n", hello.ServerName))
+	message.WriteString(fmt.Sprintf("LocalAddress=%s\n", localAddr))
+	message.WriteString(fmt.Sprintf("Version=%s\n", hello.Version))
+	if len(hello.ALPN) > 0 {
+		message.WriteString(fmt.Sprintf("ALPN=%s\n", strings.Join(hello.ALPN, ",")))
+	}
+	message.WriteString(fmt.Sprintf("JA3=%s\n", hello.JA3Hash))
+	message.WriteString(fmt.Sprintf("JA4=%s\n", hello.JA4))
+
+	host, _, _ := net.SplitHostPort(remoteAddr.String())
+	interaction := &Interaction{
+		Protocol:       "tls",
+		UniqueID:       hello.ServerName,
+		FullId:         hello.ServerName,
+		RawRequest:     message.String(),
+		RemoteAddress:  host,
+		Timestamp:      time.Now(),
+		TLSClientHello: hello,
+	}
+	if uniqueID, fullID, correlationID := h.options.findUniqueID(hello.ServerName); uniqueID != "" {
+		interaction.UniqueID = uniqueID
+		interaction.FullId = fullID
+		h.options.storeInteraction(correlationID, interaction)
+	} else if h.options.WildcardLog {
+		h.options.storeWildcardInteraction(interaction)
+	} else if token := h.options.GetToken(); token != "" {
+		h.options.storeInteractionWithId(token, interaction)
+	}
+}
+
+// tlsHostMismatch returns true if the host of a request sent over tls
+// doesn't match the SNI of its connection (domain fronting, misrouted clients)
+func tlsHostMismatch(req *http.Request, hello *ClientHello) bool {
+	return hello != nil && hello.ServerName != "" && !strings.EqualFold(strings.TrimSuffix(hello.ServerName, "."), strings.TrimSuffix(stripPort(req.Host), "."))
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPServerTLSConnection(t *testing.T) {
+	store := storage.New(time.Hour)
+	require.Nil(t, store.SetID("token"), "could not set token")
+	server, err := NewHTTPServer(&Options{Domain: "example.com", Storage: store, Auth: true, Token: "token", TLSLog: true})
+	require.Nil(t, err, "could not create http server")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "could not listen")
+	defer listener.Close()
+	hellos := &clientHelloRecorder{conns: server.tlsConns}
+	go func() {
+		conn, err := hellos.Listener(listener).Accept()
+		if err != nil {
+			return
+		}
+		config := &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return nil, errors.New("no certificate")
+		}}
+		_ = tls.Server(conn, config).Handshake()
+		conn.Close()
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.Nil(t, err, "could not connect")
+	require.NotNil(t, tls.Client(conn, &tls.Config{ServerName: "random.scanner.test", InsecureSkipVerify: true}).Handshake(), "completed handshake without certificate")
+	conn.Close()
+
+	var data []string
+	require.Eventually(t, func() bool {
+		data, _ = store.GetInteractionsWithId("token")
+		return len(data) > 0
+	}, time.Second, 10*time.Millisecond, "could not record tls connection")
+	require.Contains(t, data[0], `"protocol":"tls"`, "invalid protocol")
+	require.Contains(t, data[0], `"server-name":"random.scanner.test"`, "could not record sni")
+}
+
+func TestTLSHostMismatch(t *testing.T) {
+	req := httptest.NewRequest("GET", "https://example.com:443/", nil)
+	require.False(t, tlsHostMismatch(req, &ClientHello{ServerName: "EXAMPLE.com"}), "invalid matching sni")
+	require.True(t, tlsHostMismatch(req, &ClientHello{ServerName: "fronted.example.com"}), "invalid mismatching sni")
+	require.False(t, tlsHostMismatch(req, &ClientHello{}), "invalid empty sni")
+	require.False(t, tlsHostMismatch(req, nil), "invalid plaintext request")
+}
@@ -0,0 +1,480 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Token scopes
+const (
+	// ScopeRegister allows registering sessions and configuring their answers and responses
+	ScopeRegister = "register"
+	// ScopePoll allows polling the interactions of the sessions
+	ScopePoll = "poll"
+	// ScopeDeregister allows deregistering the sessions
+	ScopeDeregister = "deregister"
+	// ScopeAdmin allows all the operations on all the sessions and the session management api
+	ScopeAdmin = "admin"
+)
+
+// defaultScopes are the scopes of the tenants without explicit scopes
+var defaultScopes = []string{ScopeRegister, ScopePoll, ScopeDeregister}
+
+// Tenant is a client identity of a server shared by several teams,
+// authenticated with a token of its own.
+type Tenant struct {
+	// Name identifies the tenant in the logs
+	Name string `yaml:"name" json:"name"`
+	// Token is the authentication token of the clients of the tenant
+	Token string `yaml:"token" json:"token,omitempty"`
+	// MaxSessions is the maximum number of concurrent sessions of the tenant (0 for unlimited)
+	MaxSessions int `yaml:"max-sessions" json:"max-sessions,omitempty"`
+	// RegistrationsPerHour is the maximum number of registrations of the tenant per hour (0 for unlimited)
+	RegistrationsPerHour int `yaml:"registrations-per-hour" json:"registrations-per-hour,omitempty"`
+	// PollsPerMinute is the maximum number of polls of the tenant per minute (0 for unlimited)
+	PollsPerMinute int `yaml:"polls-per-minute" json:"polls-per-minute,omitempty"`
+	// ExpiresAt is the time the token stops being accepted at, if any
+	ExpiresAt *time.Time `yaml:"expires-at" json:"expires-at,omitempty"`
+	// Scopes are the operations allowed with the token, register, poll and deregister if empty
+	Scopes []string `yaml:"scopes" json:"scopes,omitempty"`
+}
+
+// allows returns true if the token of the tenant is allowed an operation,
+// the server token (nil tenant) and the admin tokens being allowed all of them.
+func (tenant *Tenant) allows(scope string) bool {
+	if tenant == nil {
+		return true
+	}
+	scopes := tenant.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultScopes
+	}
+	for _, allowed := range scopes {
+		if allowed == scope || allowed == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// validate returns an error for invalid limits or scopes of a tenant
+func (tenant *Tenant) validate() error {
+	if tenant.MaxSessions < 0 {
+		return errors.Errorf("invalid max-sessions %d", tenant.MaxSessions)
+	}
+	if tenant.RegistrationsPerHour < 0 {
+		return errors.Errorf("invalid registrations-per-hour %d", tenant.RegistrationsPerHour)
+	}
+	if tenant.PollsPerMinute < 0 {
+		return errors.Errorf("invalid polls-per-minute %d", tenant.PollsPerMinute)
+	}
+	return validateScopes(tenant.Scopes)
+}
+
+// validateScopes returns an error for an unknown scope
+func validateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeRegister, ScopePoll, ScopeDeregister, ScopeAdmin:
+		default:
+			return errors.Errorf("invalid scope %s (register, poll, deregister, admin)", scope)
+		}
+	}
+	return nil
+}
+
+// expired returns true if the token of the tenant isn't accepted anymore
+func (tenant *Tenant) expired(now time.Time) bool {
+	return tenant.ExpiresAt != nil && !now.Before(*tenant.ExpiresAt)
+}
+
+// TokenStore authenticates the clients of the tenants and records the
+// sessions they registered, a tenant only seeing its own sessions. The
+// tenants are either configured or issued with the admin api.
+type TokenStore struct {
+	sync.RWMutex
+	tenants  map[string]*Tenant
+	issued   map[string]*Tenant
+	sessions map[string]map[string]struct{}
+	owners   map[string]string
+	buckets  map[tenantLimitKey]*tokenBucket
+	exists   func(correlationID string) bool
+}
+
+// Tenant rate limited operations
+const (
+	tenantRegistrations = "registrations"
+	tenantPolls         = "polls"
+)
+
+// tenantLimitKey identifies the bucket of a rate limited operation of a tenant
+type tenantLimitKey struct {
+	tenant    string
+	operation string
+}
+
+// ParseTokenConfig reads the tenants of a yaml token config file.
+func ParseTokenConfig(file string) ([]*Tenant, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open token config")
+	}
+	defer f.Close()
+
+	var tenants []*Tenant
+	if err := yaml.NewDecoder(f).Decode(&tenants); err != nil {
+		return nil, errors.Wrap(err, "could not decode token config")
+	}
+	return tenants, nil
+}
+
+// NewTokenStore returns a token store authenticating the tenants.
+//
+// exists reports whether a session is still alive in the storage so that
+// evicted sessions stop counting towards the quota of their tenant.
+func NewTokenStore(tenants []*Tenant, exists func(correlationID string) bool) (*TokenStore, error) {
+	store := &TokenStore{
+		issued:   make(map[string]*Tenant),
+		sessions: make(map[string]map[string]struct{}),
+		owners:   make(map[string]string),
+		buckets:  make(map[tenantLimitKey]*tokenBucket),
+		exists:   exists,
+	}
+	if err := store.SetTenants(tenants); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// SetTenants replaces the configured tenants, the sessions of the removed
+// tenants being kept for the server token only.
+func (t *TokenStore) SetTenants(tenants []*Tenant) error {
+	byToken := make(map[string]*Tenant, len(tenants))
+	names := make(map[string]struct{}, len(tenants))
+	for _, tenant := range tenants {
+		if tenant.Name == "" || tenant.Token == "" {
+			return errors.New("tenant without name or token")
+		}
+		if err := tenant.validate(); err != nil {
+			return errors.Wrapf(err, "invalid tenant %s", tenant.Name)
+		}
+		if _, ok := names[tenant.Name]; ok {
+			return errors.Errorf("duplicate tenant %s", tenant.Name)
+		}
+		if _, ok := byToken[tenant.Token]; ok {
+			return errors.Errorf("duplicate token for tenant %s", tenant.Name)
+		}
+		names[tenant.Name] = struct{}{}
+		byToken[tenant.Token] = tenant
+	}
+	t.Lock()
+	t.tenants = byToken
+	t.Unlock()
+	return nil
+}
+
+// Lookup returns the tenant authenticated by a token.
+func (t *TokenStore) Lookup(token string) (*Tenant, bool) {
+	if t == nil || token == "" {
+		return nil, false
+	}
+	t.RLock()
+	defer t.RUnlock()
+
+	now := time.Now()
+	for _, tenants := range []map[string]*Tenant{t.tenants, t.issued} {
+		for candidate, tenant := range tenants {
+			if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 && !tenant.expired(now) {
+				return tenant, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// lookupKey returns the tenant whose token has the fingerprint of the key id
+// of a signed request.
+func (t *TokenStore) lookupKey(keyID string) (*Tenant, bool) {
+	if t == nil || keyID == "" {
+		return nil, false
+	}
+	t.RLock()
+	defer t.RUnlock()
+
+	now := time.Now()
+	for _, tenants := range []map[string]*Tenant{t.tenants, t.issued} {
+		for token, tenant := range tenants {
+			if tokenFingerprint(token) == keyID && !tenant.expired(now) {
+				return tenant, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Issue issues a random token for a new tenant with the name, limits and
+// scopes (the default ones if empty) of a template, expiring after a ttl if
+// positive.
+func (t *TokenStore) Issue(template Tenant, ttl time.Duration) (*Tenant, error) {
+	return t.issue(template, ttl, false)
+}
+
+// Reissue issues a token as Issue does, replacing the token previously issued
+// for the tenant, which keeps the state of its rate limits.
+func (t *TokenStore) Reissue(template Tenant, ttl time.Duration) (*Tenant, error) {
+	return t.issue(template, ttl, true)
+}
+
+// issue issues a token for a tenant, replacing its issued token if replace is true
+func (t *TokenStore) issue(template Tenant, ttl time.Duration, replace bool) (*Tenant, error) {
+	if template.Name == "" {
+		return nil, errors.New("no tenant name specified")
+	}
+	if err := template.validate(); err != nil {
+		return nil, err
+	}
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	tenant := &template
+	tenant.Token, tenant.ExpiresAt = token, nil
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		tenant.ExpiresAt = &expiresAt
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	now := time.Now()
+	for token, issued := range t.issued {
+		if issued.expired(now) || (replace && issued.Name == tenant.Name) {
+			delete(t.issued, token)
+		}
+	}
+	for _, tenants := range []map[string]*Tenant{t.tenants, t.issued} {
+		for _, existing := range tenants {
+			if existing.Name == tenant.Name {
+				return nil, errors.Errorf("duplicate tenant %s", tenant.Name)
+			}
+		}
+	}
+	t.issued[token] = tenant
+	issued := *tenant
+	return &issued, nil
+}
+
+// Revoke revokes the token issued for a tenant, returning false if none was issued.
+func (t *TokenStore) Revoke(name string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	for token, tenant := range t.issued {
+		if tenant.Name == name {
+			delete(t.issued, token)
+			delete(t.buckets, tenantLimitKey{tenant: name, operation: tenantRegistrations})
+			delete(t.buckets, tenantLimitKey{tenant: name, operation: tenantPolls})
+			return true
+		}
+	}
+	return false
+}
+
+// AllowRegistration returns true if a tenant hasn't exceeded its registrations
+// per hour, along with the time to wait before registering again otherwise.
+func (t *TokenStore) AllowRegistration(tenant *Tenant) (bool, time.Duration) {
+	if t == nil || tenant == nil {
+		return true, 0
+	}
+	return t.allow(tenant.Name, tenantRegistrations, tenant.RegistrationsPerHour, time.Hour)
+}
+
+// AllowPoll returns true if a tenant hasn't exceeded its polls per minute,
+// along with the time to wait before polling again otherwise.
+func (t *TokenStore) AllowPoll(tenant *Tenant) (bool, time.Duration) {
+	if t == nil || tenant == nil {
+		return true, 0
+	}
+	return t.allow(tenant.Name, tenantPolls, tenant.PollsPerMinute, time.Minute)
+}
+
+// allow takes a token from the bucket of an operation of a tenant holding up
+// to limit tokens refilled over a period, unlimited if zero.
+func (t *TokenStore) allow(name, operation string, limit int, period time.Duration) (bool, time.Duration) {
+	if limit <= 0 {
+		return true, 0
+	}
+	rate := float64(limit) / period.Seconds()
+	key := tenantLimitKey{tenant: name, operation: operation}
+	now := time.Now()
+
+	t.Lock()
+	defer t.Unlock()
+
+	bucket, ok := t.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit), last: now}
+		t.buckets[key] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * rate
+	if bucket.tokens > float64(limit) {
+		bucket.tokens = float64(limit)
+	}
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// Tenants returns the configured and issued tenants accepted at the moment
+// sorted by name, without their token.
+func (t *TokenStore) Tenants() []*Tenant {
+	if t == nil {
+		return nil
+	}
+	t.RLock()
+	defer t.RUnlock()
+
+	now := time.Now()
+	tenants := make([]*Tenant, 0, len(t.tenants)+len(t.issued))
+	for _, configured := range []map[string]*Tenant{t.tenants, t.issued} {
+		for _, tenant := range configured {
+			if tenant.expired(now) {
+				continue
+			}
+			listed := *tenant
+			listed.Token = ""
+			tenants = append(tenants, &listed)
+		}
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].Name < tenants[j].Name })
+	return tenants
+}
+
+// Acquire records a session registered by a tenant, returning false if
+// the tenant has already reached its maximum number of sessions.
+func (t *TokenStore) Acquire(tenant *Tenant, correlationID string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if owner, ok := t.owners[correlationID]; ok {
+		if owner == tenant.Name {
+			return true
+		}
+		t.remove(correlationID)
+	}
+	if len(t.owners) >= maxAccountedSessions {
+		t.prune()
+	}
+	if tenant.MaxSessions > 0 && len(t.sessions[tenant.Name]) >= tenant.MaxSessions {
+		t.prune()
+		if len(t.sessions[tenant.Name]) >= tenant.MaxSessions {
+			return false
+		}
+	}
+	t.add(tenant.Name, correlationID)
+	return true
+}
+
+// SetOwner records the tenant of a session registered on another
+// instance, without checking its quota.
+func (t *TokenStore) SetOwner(name, correlationID string) {
+	if t == nil || name == "" {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	t.remove(correlationID)
+	if len(t.owners) >= maxAccountedSessions {
+		t.prune()
+	}
+	t.add(name, correlationID)
+}
+
+// Owner returns the name of the tenant which registered a session.
+func (t *TokenStore) Owner(correlationID string) string {
+	if t == nil {
+		return ""
+	}
+	t.RLock()
+	defer t.RUnlock()
+
+	return t.owners[correlationID]
+}
+
+// Sessions returns the number of sessions recorded for a tenant.
+func (t *TokenStore) Sessions(name string) int {
+	if t == nil {
+		return 0
+	}
+	t.RLock()
+	defer t.RUnlock()
+
+	return len(t.sessions[name])
+}
+
+// Release releases a session recorded by Acquire once it's no longer
+// present in the storage.
+func (t *TokenStore) Release(correlationID string) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	if !t.exists(correlationID) {
+		t.remove(correlationID)
+	}
+}
+
+func (t *TokenStore) add(name, correlationID string) {
+	sessions, ok := t.sessions[name]
+	if !ok {
+		sessions = make(map[string]struct{})
+		t.sessions[name] = sessions
+	}
+	sessions[correlationID] = struct{}{}
+	t.owners[correlationID] = name
+}
+
+func (t *TokenStore) remove(correlationID string) {
+	name, ok := t.owners[correlationID]
+	if !ok {
+		return
+	}
+	delete(t.owners, correlationID)
+	delete(t.sessions[name], correlationID)
+	if len(t.sessions[name]) == 0 {
+		delete(t.sessions, name)
+	}
+}
+
+// prune removes the evicted sessions
+func (t *TokenStore) prune() {
+	for correlationID := range t.owners {
+		if !t.exists(correlationID) {
+			t.remove(correlationID)
+		}
+	}
+}
+
+// newToken returns a random token
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "could not generate token")
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/interactsh/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenStore(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "tokens.yaml")
+	require.Nil(t, ioutil.WriteFile(config, []byte("- name: red\n  token: red-token\n  max-sessions: 1\n- name: blue\n  token: blue-token\n  expires-at: 2100-01-01T00:00:00Z\n"), 0600), "could not write token config")
+	tenants, err := ParseTokenConfig(config)
+	require.Nil(t, err, "could not parse token config")
+	require.Len(t, tenants, 2, "could not parse tenants")
+	require.NotNil(t, tenants[1].ExpiresAt, "could not parse expiry")
+	require.Equal(t, 2100, tenants[1].ExpiresAt.Year(), "could not parse expiry")
+
+	alive := map[string]bool{}
+	store, err := NewTokenStore(tenants, func(correlationID string) bool { return alive[correlationID] })
+	require.Nil(t, err, "could not create token store")
+	red, ok := store.Lookup("red-token")
+	require.True(t, ok, "could not lookup tenant")
+	require.Equal(t, "red", red.Name, "could not lookup tenant name")
+	_, ok = store.Lookup("invalid")
+	require.False(t, ok, "could lookup invalid token")
+
+	require.True(t, store.Acquire(red, "first"), "could not acquire session")
+	alive["first"] = true
+	require.True(t, store.Acquire(red, "first"), "could not acquire registered session again")
+	require.False(t, store.Acquire(red, "second"), "could acquire session over quota")
+	require.Equal(t, "red", store.Owner("first"), "could not record owner")
+
+	// evicted sessions stop counting towards the quota
+	delete(alive, "first")
+	require.True(t, store.Acquire(red, "second"), "could not acquire session after eviction")
+	require.Equal(t, 1, store.Sessions("red"), "could not prune evicted session")
+	store.Release("second")
+	require.Equal(t, "", store.Owner("second"), "could not release session")
+
+	_, err = NewTokenStore([]*Tenant{{Name: "red", Token: "a"}, {Name: "red", Token: "b"}}, nil)
+	require.NotNil(t, err, "created store with duplicate tenant")
+	_, err = NewTokenStore([]*Tenant{{Name: "red", Token: "a"}, {Name: "blue", Token: "a"}}, nil)
+	require.NotNil(t, err, "created store with duplicate token")
+	_, err = NewTokenStore([]*Tenant{{Name: "red", Token: "a", Scopes: []string{"write"}}}, nil)
+	require.NotNil(t, err, "created store with invalid scope")
+
+	require.True(t, red.allows(ScopeDeregister), "could not allow default scope")
+	require.False(t, red.allows(ScopeAdmin), "allowed admin scope by default")
+	ci := &Tenant{Name: "ci", Scopes: []string{ScopeRegister, ScopePoll}}
+	require.True(t, ci.allows(ScopePoll), "could not allow scope")
+	require.False(t, ci.allows(ScopeDeregister), "allowed scope not granted")
+	require.True(t, (*Tenant)(nil).allows(ScopeAdmin), "could not allow all the scopes to the server token")
+}
+
+func TestTenantSessions(t *testing.T) {
+	encoded := encodedTestPublicKey(t)
+
+	store := storage.New(time.Hour)
+	tokens, err := NewTokenStore([]*Tenant{{Name: "red", Token: "red-token", MaxSessions: 1}, {Name: "blue", Token: "blue-token"},
+		{Name: "ci", Token: "ci-token", Scopes: []string{ScopeRegister, ScopePoll}}, {Name: "ops", Token: "ops-token", Scopes: []string{ScopeAdmin}},
+		{Name: "reader", Token: "reader-token", Scopes: []string{ScopePoll}}}, store.HasID)
+	require.Nil(t, err, "could not create token store")
+	options := &Options{Domain: "example.com", Storage: store, Auth: true, Token: "server-token", Tokens: tokens, WildcardLog: true}
+	require.Nil(t, store.SetID(WildcardLogID), "could not set wildcard log id")
+	server, err := NewHTTPServer(options)
+	require.Nil(t, err, "could not create http server")
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.com"+path, strings.NewReader(body))
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		server.nontlsserver.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	register := func(token, correlationID string) int {
+		return do(http.MethodPost, "/register", token, `{"public-key":"`+encoded+`","secret-key":"secret","correlation-id":"`+correlationID+`"}`).Code
+	}
+
+	require.Equal(t, http.StatusUnauthorized, register("invalid", "c23b2la0kl1krjcrdj10"), "could register with invalid token")
+	require.Equal(t, http.StatusOK, register("red-token", "c23b2la0kl1krjcrdj10"), "could not register with tenant token")
+	require.Equal(t, http.StatusTooManyRequests, register("red-token", "c6rj61aciaeutn2ae680"), "could register over tenant quota")
+	require.Equal(t, http.StatusForbidden, register("blue-token", "c23b2la0kl1krjcrdj10"), "could register session of another tenant")
+
+	// a tenant only sees its own sessions, the server token all of them
+	poll := "/poll?id=c23b2la0kl1krjcrdj10&secret=secret"
+	require.Equal(t, http.StatusForbidden, do(http.MethodGet, poll, "blue-token", "").Code, "could poll session of another tenant")
+	require.Equal(t, http.StatusOK, do(http.MethodGet, poll, "server-token", "").Code, "could not poll with server token")
+	require.Nil(t, options.addInteractionWithId(WildcardLogID, []byte(`{"protocol":"dns"}`)), "could not add wildcard interaction")
+	rec := do(http.MethodGet, poll, "red-token", "")
+	require.Equal(t, http.StatusOK, rec.Code, "could not poll with tenant token")
+	require.NotContains(t, rec.Body.String(), "wildcard_data", "could poll the wildcard log with tenant token")
+
+	// the scopes limit the operations of the tokens, the admin scope seeing all the sessions
+	require.Equal(t, http.StatusForbidden, register("reader-token", "c9c8l3is1dv2u9rpsnd0"), "could register without register scope")
+	require.Equal(t, http.StatusOK, register("ci-token", "c9c8l3is1dv2u9rpsnd0"), "could not register with register scope")
+	ciDeregister := `{"correlation-id":"c9c8l3is1dv2u9rpsnd0","secret-key":"secret"}`
+	require.Equal(t, http.StatusForbidden, do(http.MethodPost, "/deregister", "ci-token", ciDeregister).Code, "could deregister without deregister scope")
+	require.Equal(t, http.StatusOK, do(http.MethodGet, poll, "ops-token", "").Code, "could not poll other session with admin scope")
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/deregister", "ops-token", ciDeregister).Code, "could not deregister with admin scope")
+
+	deregister := `{"correlation-id":"c23b2la0kl1krjcrdj10","secret-key":"secret"}`
+	require.Equal(t, http.StatusForbidden, do(http.MethodPost, "/deregister", "blue-token", deregister).Code, "could deregister session of another tenant")
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/deregister", "red-token", deregister).Code, "could not deregister with tenant token")
+	require.Equal(t, http.StatusOK, register("red-token", "c6rj61aciaeutn2ae680"), "could not register after deregistration")
+}
+
+func TestTenantRateLimits(t *testing.T) {
+	store, err := NewTokenStore([]*Tenant{{Name: "ci", Token: "ci-token", RegistrationsPerHour: 1, PollsPerMinute: 2}, {Name: "red", Token: "red-token"}}, nil)
+	require.Nil(t, err, "could not create token store")
+	ci, _ := store.Lookup("ci-token")
+	red, _ := store.Lookup("red-token")
+
+	ok, _ := store.AllowRegistration(ci)
+	require.True(t, ok, "could not register within the limit")
+	ok, wait := store.AllowRegistration(ci)
+	require.False(t, ok, "could register over the limit")
+	require.InDelta(t, time.Hour.Seconds(), wait.Seconds(), 1, "could not get wait before next registration")
+	for i := 0; i < 2; i++ {
+		ok, _ = store.AllowPoll(ci)
+		require.True(t, ok, "could not poll within the limit")
+	}
+	ok, wait = store.AllowPoll(ci)
+	require.False(t, ok, "could poll over the limit")
+	require.InDelta(t, 30, wait.Seconds(), 1, "could not get wait before next poll")
+
+	// the tenants without limits and the server token aren't limited
+	for i := 0; i < 10; i++ {
+		ok, _ = store.AllowPoll(red)
+		require.True(t, ok, "could limit tenant without limit")
+	}
+	ok, _ = store.AllowRegistration(nil)
+	require.True(t, ok, "could limit server token")
+
+	issued, err := store.Issue(Tenant{Name: "bot", PollsPerMinute: 1}, 0)
+	require.Nil(t, err, "could not issue token")
+	require.Equal(t, 1, issued.PollsPerMinute, "could not issue token with limits")
+	_, err = store.Issue(Tenant{Name: "other", RegistrationsPerHour: -1}, 0)
+	require.NotNil(t, err, "could issue token with invalid limit")
+}
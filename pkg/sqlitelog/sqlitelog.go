@@ -0,0 +1,101 @@
+// Package sqlitelog persists the interactions received by the interactsh
+// client to a local SQLite database, to be queried after an engagement.
+package sqlitelog
+
+import (
+	"database/sql"
+	"strings"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/interactsh/pkg/server"
+	_ "modernc.org/sqlite"
+)
+
+// timestampLayout is the fixed width utc layout of the timestamps, sorting
+// lexically and comparable with the results of the sqlite datetime function
+const timestampLayout = "2006-01-02 15:04:05.000000000"
+
+// schema is the schema of the database, the full interaction being kept as
+// json for the fields without a column (eg. json_extract(interaction, '$.smtp-message'))
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS interactions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		correlation_id TEXT NOT NULL,
+		unique_id TEXT NOT NULL,
+		full_id TEXT NOT NULL,
+		protocol TEXT NOT NULL,
+		q_type TEXT NOT NULL DEFAULT '',
+		remote_address TEXT NOT NULL,
+		timestamp TEXT NOT NULL,
+		raw_request TEXT NOT NULL DEFAULT '',
+		raw_response TEXT NOT NULL DEFAULT '',
+		interaction TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS interactions_correlation_id ON interactions (correlation_id)`,
+	`CREATE INDEX IF NOT EXISTS interactions_protocol ON interactions (protocol)`,
+	`CREATE INDEX IF NOT EXISTS interactions_timestamp ON interactions (timestamp)`,
+}
+
+// Log writes the interactions to the interactions table of a sqlite database.
+type Log struct {
+	sync.Mutex
+	db                  *sql.DB
+	insert              *sql.Stmt
+	correlationIDLength int
+}
+
+// New opens (or creates) the sqlite database of a file, the correlation id of
+// the interactions being the first correlationIDLength characters of their
+// unique id.
+func New(file string, correlationIDLength int) (*Log, error) {
+	db, err := sql.Open("sqlite", file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open sqlite database")
+	}
+	// sqlite serializes the writes, the wal journal letting other tools read
+	// the database while the client is running
+	db.SetMaxOpenConns(1)
+	statements := append([]string{"PRAGMA journal_mode=WAL", "PRAGMA busy_timeout=5000"}, schema...)
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			_ = db.Close()
+			return nil, errors.Wrap(err, "could not create sqlite schema")
+		}
+	}
+	insert, err := db.Prepare(`INSERT INTO interactions (correlation_id, unique_id, full_id, protocol, q_type, remote_address, timestamp, raw_request, raw_response, interaction) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "could not prepare sqlite insert")
+	}
+	return &Log{db: db, insert: insert, correlationIDLength: correlationIDLength}, nil
+}
+
+// Write inserts an interaction into the database.
+func (l *Log) Write(interaction *server.Interaction) error {
+	data, err := jsoniter.Marshal(interaction)
+	if err != nil {
+		return errors.Wrap(err, "could not encode interaction")
+	}
+	uniqueID := strings.ToLower(interaction.UniqueID)
+	correlationID := uniqueID
+	if len(correlationID) > l.correlationIDLength && l.correlationIDLength > 0 {
+		correlationID = correlationID[:l.correlationIDLength]
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	_, err = l.insert.Exec(correlationID, uniqueID, interaction.FullId, interaction.Protocol, interaction.QType, interaction.RemoteAddress, interaction.Timestamp.UTC().Format(timestampLayout), interaction.RawRequest, interaction.RawResponse, string(data))
+	if err != nil {
+		return errors.Wrap(err, "could not insert interaction")
+	}
+	return nil
+}
+
+// Close closes the database.
+func (l *Log) Close() error {
+	_ = l.insert.Close()
+	return l.db.Close()
+}
@@ -0,0 +1,41 @@
+package sqlitelog
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/interactsh/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "interactions.db")
+	log, err := New(file, 20)
+	require.Nil(t, err, "could not create sqlite log")
+	timestamp := time.Date(2021, 9, 26, 13, 26, 10, 0, time.FixedZone("CEST", 2*60*60))
+	require.Nil(t, log.Write(&server.Interaction{Protocol: "dns", QType: "A", UniqueID: "C23B2LA0KL1KRJCRDJ10NKVOA2STVQCJ", FullId: "tag.c23b2la0kl1krjcrdj10nkvoa2stvqcj", RemoteAddress: "1.2.3.4", Timestamp: timestamp, RawRequest: "request"}), "could not write interaction")
+	require.Nil(t, log.Write(&server.Interaction{Protocol: "http", UniqueID: "c59e3crp82ke7bi4e4tgnkvoa2stvqcj", RemoteAddress: "1.2.3.5", Timestamp: timestamp.Add(time.Minute)}), "could not write interaction")
+	require.Nil(t, log.Close(), "could not close sqlite log")
+
+	// the database is reopened without recreating the schema
+	log, err = New(file, 20)
+	require.Nil(t, err, "could not reopen sqlite log")
+	require.Nil(t, log.Close(), "could not close sqlite log")
+
+	db, err := sql.Open("sqlite", file)
+	require.Nil(t, err, "could not open database")
+	defer db.Close()
+	var correlationID, protocol, stored, remoteAddress string
+	err = db.QueryRow("SELECT correlation_id, protocol, timestamp, json_extract(interaction, '$.remote-address') FROM interactions WHERE timestamp < datetime('2021-09-26 11:27:00') ORDER BY timestamp").Scan(&correlationID, &protocol, &stored, &remoteAddress)
+	require.Nil(t, err, "could not query interactions")
+	require.Equal(t, "c23b2la0kl1krjcrdj10", correlationID, "could not get correlation id")
+	require.Equal(t, "dns", protocol, "could not get protocol")
+	require.Equal(t, "2021-09-26 11:26:10.000000000", stored, "could not get utc timestamp")
+	require.Equal(t, "1.2.3.4", remoteAddress, "could not get json interaction")
+
+	var count int
+	require.Nil(t, db.QueryRow("SELECT COUNT(*) FROM interactions WHERE correlation_id = ?", "c59e3crp82ke7bi4e4tg").Scan(&count), "could not count interactions")
+	require.Equal(t, 1, count, "could not index correlation id")
+}
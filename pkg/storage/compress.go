@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// sealedPrefix marks the data items compressed with zstd before their
+// encryption, the items stored by the previous versions being compressed
+// with zlib after it.
+const sealedPrefix = "\x01"
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// seal compresses an interaction and encrypts it with the AES key of its
+// correlation-id, the items of the ids without key being only compressed.
+// The item holds the raw ciphertext, the base64 encoding being done on poll.
+func seal(key []byte, data []byte) (string, error) {
+	compressed := zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/4))
+	if len(key) == 0 {
+		return sealedPrefix + string(compressed), nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	sealed := make([]byte, len(sealedPrefix)+aes.BlockSize+len(compressed))
+	copy(sealed, sealedPrefix)
+	iv := sealed[len(sealedPrefix) : len(sealedPrefix)+aes.BlockSize]
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return "", err
+	}
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(sealed[len(sealedPrefix)+aes.BlockSize:], compressed)
+	return string(sealed), nil
+}
+
+// unseal returns the interactions of data items as polled by the clients,
+// encrypted with the AES key of their correlation-id and base64 encoded,
+// or in plaintext for the ids without key.
+func unseal(key []byte, data []string) []string {
+	results := make([]string, len(data))
+	var legacy []int
+	for i, item := range data {
+		if !strings.HasPrefix(item, sealedPrefix) {
+			legacy = append(legacy, i)
+			continue
+		}
+		result, err := unsealItem(key, item[len(sealedPrefix):])
+		if err != nil {
+			continue
+		}
+		results[i] = result
+	}
+	if len(legacy) > 0 {
+		items := make([]string, len(legacy))
+		for j, i := range legacy {
+			items[j] = data[i]
+		}
+		for j, item := range decompress(items) {
+			results[legacy[j]] = item
+		}
+	}
+	return results
+}
+
+// unsealItem decrypts and decompresses a sealed data item, encrypting it
+// again for the client with a new iv
+func unsealItem(key []byte, item string) (string, error) {
+	compressed := []byte(item)
+	if len(key) > 0 {
+		if len(compressed) < aes.BlockSize {
+			return "", errors.New("invalid sealed item")
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return "", err
+		}
+		iv, ciphertext := compressed[:aes.BlockSize], compressed[aes.BlockSize:]
+		cipher.NewCFBDecrypter(block, iv).XORKeyStream(ciphertext, ciphertext)
+		compressed = ciphertext
+	}
+	plaintext, err := zstdDecoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "could not decompress item")
+	}
+	if len(key) == 0 {
+		return string(plaintext), nil
+	}
+	return aesEncryptRaw(key, plaintext)
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zlib"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeal(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	data := []byte(`{"protocol":"http","raw-request":"` + strings.Repeat("GET / HTTP/1.1\r\nHost: example.com\r\n", 100) + `"}`)
+
+	sealed, err := seal(key, data)
+	require.Nil(t, err, "could not seal item")
+	require.Less(t, len(sealed)*4, len(data), "could not compress item")
+	require.NotContains(t, sealed, "example.com", "could not encrypt item")
+
+	// the clients receive the base64 encoded ciphertext of the plaintext
+	unsealed := unseal(key, []string{sealed})
+	require.Len(t, unsealed, 1, "could not unseal item")
+	ciphertext, err := base64.StdEncoding.DecodeString(unsealed[0])
+	require.Nil(t, err, "could not decode item")
+	block, err := aes.NewCipher(key)
+	require.Nil(t, err, "could not create cipher")
+	plaintext := make([]byte, len(ciphertext)-aes.BlockSize)
+	cipher.NewCFBDecrypter(block, ciphertext[:aes.BlockSize]).XORKeyStream(plaintext, ciphertext[aes.BlockSize:])
+	require.Equal(t, data, plaintext, "could not decrypt item")
+
+	sealed, err = seal(nil, data)
+	require.Nil(t, err, "could not seal item without key")
+	require.Equal(t, []string{string(data)}, unseal(nil, []string{sealed}), "could not unseal item without key")
+}
+
+func TestUnsealLegacy(t *testing.T) {
+	compressed, err := seal(nil, []byte("sealed"))
+	require.Nil(t, err, "could not seal item")
+	// the items stored by the previous versions are compressed with zlib
+	var legacy strings.Builder
+	writer := zlib.NewWriter(&legacy)
+	_, _ = writer.Write([]byte("legacy"))
+	writer.Close()
+	require.Equal(t, []string{"legacy", "sealed"}, unseal(nil, []string{legacy.String(), compressed}), "could not unseal legacy items")
+}
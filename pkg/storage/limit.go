@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"math/rand"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LimitPolicy is the policy applied to the new interactions of an id
+// which has reached the maximum number of pending interactions.
+type LimitPolicy string
+
+const (
+	// DropOldest removes the oldest pending interaction to store the new one
+	DropOldest LimitPolicy = "drop-oldest"
+	// DropNewest drops the new interaction
+	DropNewest LimitPolicy = "drop-newest"
+	// Sample keeps a uniform random sample of the interactions received since
+	// the last poll, the new interaction replacing a random pending one or
+	// being dropped
+	Sample LimitPolicy = "sample"
+)
+
+// ParseLimitPolicy returns the policy of its name.
+func ParseLimitPolicy(value string) (LimitPolicy, error) {
+	switch policy := LimitPolicy(strings.ToLower(value)); policy {
+	case DropOldest, DropNewest, Sample:
+		return policy, nil
+	}
+	return "", errors.Errorf("invalid limit policy %s (drop-oldest, drop-newest or sample)", value)
+}
+
+// InteractionLimit is the maximum number of pending interactions per id
+// and the policy applied once it's reached.
+type InteractionLimit struct {
+	// Max is the maximum number of pending interactions, 0 for unlimited
+	Max int
+	// Policy is the policy applied to the new interactions once Max is reached
+	Policy LimitPolicy
+}
+
+// limitAction is the action taken for a new interaction
+type limitAction int
+
+const (
+	// appendItem stores the new interaction
+	appendItem limitAction = iota
+	// shiftItem removes the oldest pending interaction and stores the new one
+	shiftItem
+	// replaceItem replaces a pending interaction with the new one
+	replaceItem
+	// dropItem drops the new interaction
+	dropItem
+)
+
+// action returns the action taken for a new interaction of an id with a number
+// of pending interactions, seen being the number of interactions received
+// since the last poll including the new one. The index is the one of the
+// pending interaction replaced by replaceItem, or the number of oldest
+// pending interactions removed by shiftItem.
+func (l InteractionLimit) action(pending, seen int) (limitAction, int) {
+	if l.Max <= 0 || pending < l.Max {
+		return appendItem, 0
+	}
+	switch l.Policy {
+	case DropNewest:
+		return dropItem, 0
+	case Sample:
+		// reservoir sampling, every interaction since the last poll having
+		// the same probability to be kept
+		if seen <= pending {
+			return dropItem, 0
+		}
+		if index := rand.Intn(seen); index < pending {
+			return replaceItem, index
+		}
+		return dropItem, 0
+	default:
+		return shiftItem, pending - l.Max + 1
+	}
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimitPolicy(t *testing.T) {
+	policy, err := ParseLimitPolicy("Drop-Newest")
+	require.Nil(t, err, "could not parse policy")
+	require.Equal(t, DropNewest, policy, "could not get policy")
+
+	_, err = ParseLimitPolicy("drop-random")
+	require.NotNil(t, err, "could parse invalid policy")
+}
+
+func TestInteractionLimit(t *testing.T) {
+	server, err := miniredis.Run()
+	require.Nil(t, err, "could not start redis server")
+	defer server.Close()
+	redisStorage, err := NewRedis("redis://"+server.Addr(), time.Hour)
+	require.Nil(t, err, "could not create redis storage")
+	defer redisStorage.Close()
+	sqlStorage, err := NewSQL("sqlite://"+filepath.Join(t.TempDir(), "interactsh.db"), time.Hour)
+	require.Nil(t, err, "could not create sql storage")
+	defer sqlStorage.Close()
+
+	storages := map[string]Storage{"memory": New(time.Hour), "redis": redisStorage, "sql": sqlStorage}
+	for name, storage := range storages {
+		for _, policy := range []LimitPolicy{DropOldest, DropNewest, Sample} {
+			ID := "token-" + string(policy)
+			storage.SetInteractionLimit(InteractionLimit{Max: 3, Policy: policy})
+			require.Nil(t, storage.SetID(ID), "could not set id")
+			for i := 0; i < 10; i++ {
+				require.Nil(t, storage.AddInteractionWithId(ID, []byte(strconv.Itoa(i))), "could not add interaction")
+			}
+			data, err := storage.GetInteractionsWithId(ID)
+			require.Nil(t, err, "could not get interactions")
+			require.Len(t, data, 3, "could not limit %s interactions with %s", name, policy)
+			switch policy {
+			case DropOldest:
+				require.Equal(t, []string{"7", "8", "9"}, data, "could not drop oldest %s interactions", name)
+			case DropNewest:
+				require.Equal(t, []string{"0", "1", "2"}, data, "could not drop newest %s interactions", name)
+			}
+
+			// the limit applies to the interactions received since the last poll
+			require.Nil(t, storage.AddInteractionWithId(ID, []byte("new")), "could not add interaction")
+			data, err = storage.GetInteractionsWithId(ID)
+			require.Nil(t, err, "could not get interactions")
+			require.Equal(t, []string{"new"}, data, "could not reset %s limit", name)
+		}
+		require.Equal(t, uint64(3*7), storage.GetCacheMetrics().DroppedCount, "could not count %s dropped interactions", name)
+	}
+}
+
+func TestInteractionLimitSample(t *testing.T) {
+	limit := InteractionLimit{Max: 10, Policy: Sample}
+	kept := 0
+	for seen := 11; seen <= 1000; seen++ {
+		if action, index := limit.action(10, seen); action == replaceItem {
+			require.Less(t, index, 10, "could not replace pending interaction")
+			kept++
+		}
+	}
+	// the expected number of kept interactions is sum(10/n) ~ 46
+	require.InDelta(t, 46, kept, 30, "could not sample interactions")
+}
@@ -0,0 +1,653 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goburrow/cache"
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zlib"
+	"github.com/pkg/errors"
+)
+
+// MemoryStorage is the in-memory storage of the interaction data as well
+// as correlation-id -> rsa-public-key data, evicting the ids after a ttl.
+// The ids are sharded across several caches, each maintained by its own
+// goroutine, so that the storage scales with the number of cores.
+type MemoryStorage struct {
+	shards      []cache.Cache
+	evictionTTL time.Duration
+	maxTTL      time.Duration
+	// entries is the number of ids currently stored
+	entries int64
+	// ids are the ids currently stored, the cache not being iterable
+	ids sync.Map
+	// limit is the maximum number of pending interactions per id
+	limit InteractionLimit
+	// dropped is the number of interactions dropped by the limit
+	dropped uint64
+	// sessions is the number of ids stored with a public key
+	sessions int64
+	// pending and pendingBytes are the number and size of the stored interactions
+	pending      int64
+	pendingBytes int64
+	// added and polled are the number of interactions added and polled
+	added  uint64
+	polled uint64
+}
+
+// CorrelationData is the data for a correlation-id.
+type CorrelationData struct {
+	// data contains data for a correlation-id in AES encrypted json format.
+	Data []string `json:"data"`
+	// storedAt contains the time each data item was stored at.
+	storedAt []time.Time
+	// dataMutex is a mutex for the data slice.
+	dataMutex *sync.Mutex
+	// secretkey is a secret key for original user verification
+	secretKey string
+	// AESKey is the AES encryption key in encrypted format.
+	AESKey string `json:"aes-key"`
+	aesKey []byte // decrypted AES key for signing
+	// expiresAt is the time the correlation-id is evicted at
+	expiresAt time.Time
+	// seen is the number of interactions received since the last poll
+	seen int
+}
+
+// GetCacheMetrics returns the metrics of the cache.
+func (s *MemoryStorage) GetCacheMetrics() *CacheMetrics {
+	info := &cache.Stats{}
+	for _, shard := range s.shards {
+		stats := &cache.Stats{}
+		shard.Stats(stats)
+		info.HitCount += stats.HitCount
+		info.MissCount += stats.MissCount
+		info.LoadSuccessCount += stats.LoadSuccessCount
+		info.LoadErrorCount += stats.LoadErrorCount
+		info.TotalLoadTime += stats.TotalLoadTime
+		info.EvictionCount += stats.EvictionCount
+	}
+
+	return &CacheMetrics{
+		HitCount:         info.HitCount,
+		MissCount:        info.MissCount,
+		LoadSuccessCount: info.LoadSuccessCount,
+		LoadErrorCount:   info.LoadErrorCount,
+		TotalLoadTime:    info.TotalLoadTime,
+		EvictionCount:    info.EvictionCount,
+		DroppedCount:     atomic.LoadUint64(&s.dropped),
+		Entries:          atomic.LoadInt64(&s.entries),
+		Sessions:         atomic.LoadInt64(&s.sessions),
+		Pending:          atomic.LoadInt64(&s.pending),
+		PendingBytes:     atomic.LoadInt64(&s.pendingBytes),
+		AddedCount:       atomic.LoadUint64(&s.added),
+		PolledCount:      atomic.LoadUint64(&s.polled),
+	}
+}
+
+// GetInteractions returns the uncompressed interactions for a correlation-id
+func (c *CorrelationData) GetInteractions() []string {
+	data, _ := c.GetInteractionsWithStoredAt()
+	return data
+}
+
+// GetInteractionsWithStoredAt returns the uncompressed interactions for a
+// correlation-id along with the time each one was stored at.
+func (c *CorrelationData) GetInteractionsWithStoredAt() ([]string, []time.Time) {
+	data, storedAt := c.take()
+	return unseal(c.aesKey, data), storedAt
+}
+
+// take returns and removes the stored data items of a correlation-id
+func (c *CorrelationData) take() ([]string, []time.Time) {
+	c.dataMutex.Lock()
+	data := c.Data
+	storedAt := c.storedAt
+	c.Data = make([]string, 0)
+	c.storedAt = nil
+	c.seen = 0
+	c.dataMutex.Unlock()
+	return data, storedAt
+}
+
+// size returns the total size of data items
+func size(data []string) int {
+	total := 0
+	for _, item := range data {
+		total += len(item)
+	}
+	return total
+}
+
+// decompress returns the uncompressed data items stored by the previous
+// versions, compressed with zlib
+func decompress(data []string) []string {
+	if len(data) == 0 {
+		return []string{}
+	}
+
+	buf := new(strings.Builder)
+	results := make([]string, len(data))
+
+	var reader io.ReadCloser
+	for i, item := range data {
+		var err error
+
+		if reader == nil {
+			reader, err = zlib.NewReader(strings.NewReader(item))
+		} else {
+			err = reader.(zlib.Resetter).Reset(strings.NewReader(item), nil)
+		}
+		if err != nil {
+			continue
+		}
+		if _, err := io.Copy(buf, reader); err != nil {
+			buf.Reset()
+			continue
+		}
+		results[i] = buf.String()
+		buf.Reset()
+	}
+	if reader != nil {
+		_ = reader.Close()
+	}
+	return results
+}
+
+// add appends a data item stored at the current time applying the limit of
+// pending interactions, returning the number of dropped items and the change
+// of the size of the data items.
+func (c *CorrelationData) add(item string, limit InteractionLimit) (int, int) {
+	c.dataMutex.Lock()
+	defer c.dataMutex.Unlock()
+
+	c.seen++
+	action, index := limit.action(len(c.Data), c.seen)
+	switch action {
+	case dropItem:
+		return 1, 0
+	case replaceItem:
+		delta := len(item) - len(c.Data[index])
+		c.Data[index] = item
+		c.storedAt[index] = time.Now()
+		return 1, delta
+	case shiftItem:
+		delta := len(item) - size(c.Data[:index])
+		c.Data = append(c.Data[:0], c.Data[index:]...)
+		c.storedAt = append(c.storedAt[:0], c.storedAt[index:]...)
+		c.Data = append(c.Data, item)
+		c.storedAt = append(c.storedAt, time.Now())
+		return index, delta
+	}
+	c.Data = append(c.Data, item)
+	c.storedAt = append(c.storedAt, time.Now())
+	return 0, len(item)
+}
+
+const defaultCacheMaxSize = 2500000
+
+// defaultShards is the number of shards of the memory storage
+var defaultShards = 4 * runtime.GOMAXPROCS(0)
+
+var _ Storage = &MemoryStorage{}
+
+// New creates a new in-memory storage instance for interactsh data.
+func New(evictionTTL time.Duration) *MemoryStorage {
+	return NewWithMaxTTL(evictionTTL, evictionTTL)
+}
+
+// NewWithMaxTTL creates a new in-memory storage whose sessions can be
+// registered with a ttl up to maxTTL rather than the eviction ttl.
+func NewWithMaxTTL(evictionTTL, maxTTL time.Duration) *MemoryStorage {
+	return newSharded(evictionTTL, maxTTL, defaultShards)
+}
+
+// newSharded creates a new in-memory storage with a number of shards
+func newSharded(evictionTTL, maxTTL time.Duration, shards int) *MemoryStorage {
+	if maxTTL < evictionTTL {
+		maxTTL = evictionTTL
+	}
+	storage := &MemoryStorage{evictionTTL: evictionTTL, maxTTL: maxTTL, shards: make([]cache.Cache, shards)}
+	// the ids expiring before the ttl of the cache are ignored once expired
+	for i := range storage.shards {
+		storage.shards[i] = cache.New(cache.WithMaximumSize(defaultCacheMaxSize/shards), cache.WithExpireAfterWrite(maxTTL), cache.WithRemovalListener(func(key cache.Key, value cache.Value) {
+			atomic.AddInt64(&storage.entries, -1)
+			storage.ids.Delete(key)
+			if data, ok := value.(*CorrelationData); ok {
+				if data.aesKey != nil {
+					atomic.AddInt64(&storage.sessions, -1)
+				}
+				storage.release(data)
+			}
+		}))
+	}
+	return storage
+}
+
+// shard returns the cache of an id, hashed with fnv-1a
+func (s *MemoryStorage) shard(ID string) cache.Cache {
+	hash := uint32(2166136261)
+	for i := 0; i < len(ID); i++ {
+		hash ^= uint32(ID[i])
+		hash *= 16777619
+	}
+	return s.shards[hash%uint32(len(s.shards))]
+}
+
+// ttl returns the ttl of a session bounded by the max ttl, the eviction ttl if 0
+func (s *MemoryStorage) ttl(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return s.evictionTTL
+	}
+	if ttl > s.maxTTL {
+		return s.maxTTL
+	}
+	return ttl
+}
+
+// get returns the data of an id, releasing the interactions of the expired ones
+func (s *MemoryStorage) get(ID string) (*CorrelationData, bool) {
+	item, ok := s.shard(ID).GetIfPresent(ID)
+	if !ok {
+		return nil, false
+	}
+	value, ok := item.(*CorrelationData)
+	if !ok {
+		return nil, false
+	}
+	if !value.expiresAt.IsZero() && !time.Now().Before(value.expiresAt) {
+		// the entry is left to the cache ttl, invalidating it would race
+		// with a new registration of the id
+		s.release(value)
+		return nil, false
+	}
+	return value, true
+}
+
+// release removes the data items of an id, updating the pending interactions
+func (s *MemoryStorage) release(value *CorrelationData) {
+	value.dataMutex.Lock()
+	atomic.AddInt64(&s.pending, -int64(len(value.Data)))
+	atomic.AddInt64(&s.pendingBytes, -int64(size(value.Data)))
+	value.Data = nil
+	value.storedAt = nil
+	value.dataMutex.Unlock()
+}
+
+// addItem adds a data item to an id updating the metrics of the storage
+func (s *MemoryStorage) addItem(value *CorrelationData, item string) {
+	dropped, delta := value.add(item, s.limit)
+	atomic.AddUint64(&s.added, 1)
+	atomic.AddUint64(&s.dropped, uint64(dropped))
+	atomic.AddInt64(&s.pending, int64(1-dropped))
+	atomic.AddInt64(&s.pendingBytes, int64(delta))
+}
+
+// takeItems returns and removes the data items of an id updating the metrics of the storage
+func (s *MemoryStorage) takeItems(value *CorrelationData) ([]string, []time.Time) {
+	data, storedAt := value.take()
+	atomic.AddUint64(&s.polled, uint64(len(data)))
+	atomic.AddInt64(&s.pending, -int64(len(data)))
+	atomic.AddInt64(&s.pendingBytes, -int64(size(data)))
+	return unseal(value.aesKey, data), storedAt
+}
+
+// SetInteractionLimit sets the maximum number of pending interactions per id.
+func (s *MemoryStorage) SetInteractionLimit(limit InteractionLimit) {
+	s.limit = limit
+}
+
+// SetIDPublicKey sets the correlation ID and publicKey into the cache for further operations.
+func (s *MemoryStorage) SetIDPublicKey(correlationID, secretKey string, publicKey string) error {
+	return s.SetIDPublicKeyWithTTL(correlationID, secretKey, publicKey, 0)
+}
+
+// SetIDPublicKeyWithTTL sets the correlation ID and publicKey like SetIDPublicKey,
+// evicting the correlation ID after a ttl (the eviction ttl if 0) bounded by
+// the max ttl of the storage.
+func (s *MemoryStorage) SetIDPublicKeyWithTTL(correlationID, secretKey string, publicKey string, ttl time.Duration) error {
+	// If we already have this correlation ID, return.
+	if s.HasID(correlationID) {
+		return errors.New("correlation-id provided already exists")
+	}
+	publicKeyData, err := parseB64RSAPublicKeyFromPEM(publicKey)
+	if err != nil {
+		return errors.Wrap(err, "could not read public Key")
+	}
+	aesKey := uuid.New().String()[:32]
+
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKeyData, []byte(aesKey), []byte(""))
+	if err != nil {
+		return errors.New("could not encrypt event data")
+	}
+
+	data := &CorrelationData{
+		Data:      make([]string, 0),
+		secretKey: secretKey,
+		dataMutex: &sync.Mutex{},
+		aesKey:    []byte(aesKey),
+		AESKey:    base64.StdEncoding.EncodeToString(ciphertext),
+		expiresAt: time.Now().Add(s.ttl(ttl)),
+	}
+	s.put(correlationID, data)
+	return nil
+}
+
+// ExportSession returns the registration of a session with its secret and AES keys.
+func (s *MemoryStorage) ExportSession(correlationID string) (SessionExport, bool) {
+	value, err := s.GetCacheItem(correlationID)
+	if err != nil || value.aesKey == nil {
+		return SessionExport{}, false
+	}
+	return SessionExport{
+		CorrelationID: correlationID,
+		SecretKey:     value.secretKey,
+		AESKey:        value.AESKey,
+		RawAESKey:     value.aesKey,
+		ExpiresAt:     value.expiresAt,
+	}, true
+}
+
+// ImportSession registers a session exported by another server, its expiry
+// being bounded by the max ttl of the storage.
+func (s *MemoryStorage) ImportSession(session SessionExport) error {
+	if err := session.validate(); err != nil {
+		return err
+	}
+	if s.HasID(session.CorrelationID) {
+		return errors.New("correlation-id provided already exists")
+	}
+	expiresAt := session.ExpiresAt
+	if maxExpiry := time.Now().Add(s.maxTTL); expiresAt.After(maxExpiry) {
+		expiresAt = maxExpiry
+	}
+	s.put(session.CorrelationID, &CorrelationData{
+		Data:      make([]string, 0),
+		secretKey: session.SecretKey,
+		dataMutex: &sync.Mutex{},
+		aesKey:    session.RawAESKey,
+		AESKey:    session.AESKey,
+		expiresAt: expiresAt,
+	})
+	return nil
+}
+
+// SetID sets an id without public key, eg. the token, storing its interactions
+// unencrypted. The interactions of an id already set are kept.
+func (s *MemoryStorage) SetID(ID string) error {
+	if value, err := s.GetCacheItem(ID); err == nil && value.aesKey == nil {
+		value.dataMutex.Lock()
+		s.put(ID, &CorrelationData{
+			Data:      value.Data,
+			storedAt:  value.storedAt,
+			seen:      value.seen,
+			dataMutex: value.dataMutex,
+			expiresAt: time.Now().Add(s.evictionTTL),
+		})
+		value.dataMutex.Unlock()
+		return nil
+	}
+	data := &CorrelationData{
+		Data:      make([]string, 0),
+		dataMutex: &sync.Mutex{},
+		expiresAt: time.Now().Add(s.evictionTTL),
+	}
+	s.put(ID, data)
+	return nil
+}
+
+// HasID returns true if the correlation id or id is registered in the storage.
+func (s *MemoryStorage) HasID(ID string) bool {
+	_, found := s.get(ID)
+	return found
+}
+
+// put stores the data for an id keeping track of the number of entries,
+// sessions and pending interactions. The data items of a replaced entry are
+// kept if shared with the new one (ie. with the same mutex), released otherwise.
+func (s *MemoryStorage) put(ID string, data *CorrelationData) {
+	shared := false
+	if previous, found := s.shard(ID).GetIfPresent(ID); !found {
+		atomic.AddInt64(&s.entries, 1)
+	} else if value, ok := previous.(*CorrelationData); ok {
+		if value.aesKey != nil {
+			atomic.AddInt64(&s.sessions, -1)
+		}
+		if shared = value.dataMutex == data.dataMutex; !shared {
+			s.release(value)
+		}
+	}
+	if data.aesKey != nil {
+		atomic.AddInt64(&s.sessions, 1)
+	}
+	if !shared {
+		atomic.AddInt64(&s.pending, int64(len(data.Data)))
+		atomic.AddInt64(&s.pendingBytes, int64(size(data.Data)))
+	}
+	s.shard(ID).Put(ID, data)
+	s.ids.Store(ID, struct{}{})
+}
+
+// AddInteraction adds an interaction data to the correlation ID after encrypting
+// it with Public Key for the provided correlation ID.
+func (s *MemoryStorage) AddInteraction(correlationID string, data []byte) error {
+	value, ok := s.get(correlationID)
+	if !ok {
+		return errors.New("could not get correlation-id from cache")
+	}
+
+	if value.aesKey == nil {
+		return errors.New("no encryption key for correlation-id")
+	}
+	ct, err := seal(value.aesKey, data)
+	if err != nil {
+		return errors.Wrap(err, "could not encrypt event data")
+	}
+	s.addItem(value, ct)
+	return nil
+}
+
+// AddInteractionWithId adds an interaction data to the id bucket
+func (s *MemoryStorage) AddInteractionWithId(id string, data []byte) error {
+	value, ok := s.get(id)
+	if !ok {
+		return errors.New("could not get correlation-id from cache")
+	}
+
+	compressed, err := seal(nil, data)
+	if err != nil {
+		return err
+	}
+	s.addItem(value, compressed)
+	return nil
+}
+
+// Encrypt encrypts data with the AES key of the correlation ID, returning the
+// base64 encoded ciphertext which can be decrypted like the interactions.
+func (s *MemoryStorage) Encrypt(correlationID string, data []byte) (string, error) {
+	value, err := s.GetCacheItem(correlationID)
+	if err != nil {
+		return "", errors.New("could not get correlation-id from cache")
+	}
+	if value.aesKey == nil {
+		return "", errors.New("no encryption key for correlation-id")
+	}
+	return aesEncryptRaw(value.aesKey, data)
+}
+
+// GetAESKey returns the AES Encrypted Key for a correlationID.
+func (s *MemoryStorage) GetAESKey(correlationID, secret string) (string, error) {
+	value, err := s.GetCacheItem(correlationID)
+	if err != nil {
+		return "", errors.New("could not get correlation-id from cache")
+	}
+	if !strings.EqualFold(value.secretKey, secret) {
+		return "", errors.New("invalid secret key passed for user")
+	}
+	return value.AESKey, nil
+}
+
+// GetInteractions returns the interactions for a correlationID and removes
+// it from the storage. It also returns AES Encrypted Key for the IDs.
+func (s *MemoryStorage) GetInteractions(correlationID, secret string) ([]string, string, error) {
+	data, _, aesKey, err := s.GetInteractionsWithStoredAt(correlationID, secret)
+	return data, aesKey, err
+}
+
+// GetInteractionsWithStoredAt returns the interactions for a correlationID
+// like GetInteractions, along with the time each one was stored at.
+func (s *MemoryStorage) GetInteractionsWithStoredAt(correlationID, secret string) ([]string, []time.Time, string, error) {
+	value, ok := s.get(correlationID)
+	if !ok {
+		return nil, nil, "", ErrCorrelationIDNotFound
+	}
+	if !strings.EqualFold(value.secretKey, secret) {
+		return nil, nil, "", errors.New("invalid secret key passed for user")
+	}
+	data, storedAt := s.takeItems(value)
+	return data, storedAt, value.AESKey, nil
+}
+
+// GetExpiry returns the time a registered correlation-id is evicted at.
+func (s *MemoryStorage) GetExpiry(correlationID string) (time.Time, bool) {
+	value, ok := s.get(correlationID)
+	if !ok || value.aesKey == nil {
+		return time.Time{}, false
+	}
+	return value.expiresAt, true
+}
+
+// GetInteractions returns the interactions for a id and empty the cache
+func (s *MemoryStorage) GetInteractionsWithId(id string) ([]string, error) {
+	value, ok := s.get(id)
+	if !ok {
+		return nil, errors.New("could not get id from cache")
+	}
+	data, _ := s.takeItems(value)
+	return data, nil
+}
+
+// RemoveID removes data for a correlation ID and data related to it.
+func (s *MemoryStorage) RemoveID(correlationID, secret string) error {
+	value, ok := s.get(correlationID)
+	if !ok {
+		return errors.New("could not get correlation-id from cache")
+	}
+	if !strings.EqualFold(value.secretKey, secret) {
+		return errors.New("invalid secret key passed for deregister")
+	}
+	s.release(value)
+	s.shard(correlationID).Invalidate(correlationID)
+	return nil
+}
+
+// RemoveSession removes a session registered with a public key without
+// requiring its secret, eg. to deregister it from the admin api.
+func (s *MemoryStorage) RemoveSession(correlationID string) error {
+	value, err := s.GetCacheItem(correlationID)
+	if err != nil || value.aesKey == nil {
+		return ErrCorrelationIDNotFound
+	}
+	s.release(value)
+	s.shard(correlationID).Invalidate(correlationID)
+	s.ids.Delete(correlationID)
+	return nil
+}
+
+// GetSession returns the state of a session registered with a public key.
+func (s *MemoryStorage) GetSession(correlationID string) (SessionInfo, bool) {
+	value, err := s.GetCacheItem(correlationID)
+	if err != nil || value.aesKey == nil {
+		return SessionInfo{}, false
+	}
+	value.dataMutex.Lock()
+	pending := len(value.Data)
+	value.dataMutex.Unlock()
+	return SessionInfo{CorrelationID: correlationID, ExpiresAt: value.expiresAt, Pending: pending}, true
+}
+
+// ListSessions returns the state of all the sessions registered with
+// a public key sorted by correlation-id.
+func (s *MemoryStorage) ListSessions() []SessionInfo {
+	var sessions []SessionInfo
+	s.ids.Range(func(key, _ interface{}) bool {
+		if session, ok := s.GetSession(key.(string)); ok {
+			sessions = append(sessions, session)
+		}
+		return true
+	})
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CorrelationID < sessions[j].CorrelationID
+	})
+	return sessions
+}
+
+// parseB64RSAPublicKeyFromPEM parses a base64 encoded rsa pem to a public key structure
+func parseB64RSAPublicKeyFromPEM(pubPEM string) (*rsa.PublicKey, error) {
+	decoded, err := base64.StdEncoding.DecodeString(pubPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(decoded)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the key")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return pub, nil
+	default:
+		break // fall through
+	}
+	return nil, errors.New("Key type is not RSA")
+}
+
+// aesEncryptRaw encrypts a message using AES and puts IV at the beginning of ciphertext,
+// returning the base64 encoded ciphertext.
+func aesEncryptRaw(key []byte, message []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	// It's common to put IV at the beginning of the ciphertext.
+	cipherText := make([]byte, aes.BlockSize+len(message))
+	iv := cipherText[:aes.BlockSize]
+	if _, err = io.ReadFull(rand.Reader, iv); err != nil {
+		return "", err
+	}
+
+	stream := cipher.NewCFBEncrypter(block, iv)
+	stream.XORKeyStream(cipherText[aes.BlockSize:], message)
+
+	return base64.StdEncoding.EncodeToString(cipherText), nil
+}
+
+// GetCacheItem returns an item as is
+func (s *MemoryStorage) GetCacheItem(token string) (*CorrelationData, error) {
+	value, ok := s.get(token)
+	if !ok {
+		return nil, errors.New("cache item not found")
+	}
+	return value, nil
+}
@@ -0,0 +1,358 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goburrow/cache"
+	"github.com/google/uuid"
+	"github.com/karlseguin/ccache/v2"
+	"github.com/klauspost/compress/zlib"
+	"github.com/rs/xid"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testKeyOnce sync.Once
+	testKey     *rsa.PrivateKey
+	testKeyErr  error
+)
+
+// newTestKey returns the rsa key of the test sessions, generated once, along
+// with its public key encoded like the registrations of the clients
+func newTestKey(t *testing.T) (*rsa.PrivateKey, string) {
+	testKeyOnce.Do(func() {
+		testKey, testKeyErr = rsa.GenerateKey(rand.Reader, 2048)
+	})
+	require.Nil(t, testKeyErr, "could not generate rsa key")
+	pubkeyBytes, err := x509.MarshalPKIXPublicKey(testKey.Public())
+	require.Nil(t, err, "could not marshal public key")
+	return testKey, base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: pubkeyBytes}))
+}
+
+func TestStorageSetIDPublicKey(t *testing.T) {
+	storage := New(1 * time.Hour)
+
+	secret := uuid.New().String()
+	correlationID := xid.New().String()
+
+	_, encoded := newTestKey(t)
+
+	err := storage.SetIDPublicKey(correlationID, secret, encoded)
+	require.Nil(t, err, "could not set correlation-id and rsa public key in storage")
+
+	item, ok := storage.shard(correlationID).GetIfPresent(correlationID)
+	require.True(t, ok, "could not assert item value presence")
+	require.NotNil(t, item, "could not get correlation-id item from storage")
+
+	value, ok := item.(*CorrelationData)
+	require.True(t, ok, "could not assert item value type as correlation data")
+
+	require.Equal(t, secret, value.secretKey, "could not get correct secret key")
+}
+
+func TestStorageAddGetInteractions(t *testing.T) {
+	storage := New(1 * time.Hour)
+
+	secret := uuid.New().String()
+	correlationID := xid.New().String()
+
+	priv, encoded := newTestKey(t)
+
+	err := storage.SetIDPublicKey(correlationID, secret, encoded)
+	require.Nil(t, err, "could not set correlation-id and rsa public key in storage")
+
+	dataOriginal := []byte("hello world, this is unencrypted interaction")
+	err = storage.AddInteraction(correlationID, dataOriginal)
+	require.Nil(t, err, "could not add interaction to storage")
+
+	data, storedAt, key, err := storage.GetInteractionsWithStoredAt(correlationID, secret)
+	require.Nil(t, err, "could not get interaction from storage")
+	require.Len(t, storedAt, 1, "could not get interaction storage time")
+	require.WithinDuration(t, time.Now(), storedAt[0], time.Minute, "could not get correct interaction storage time")
+
+	decodedKey, err := base64.StdEncoding.DecodeString(key)
+	require.Nil(t, err, "could not decode key")
+
+	// Decrypt the key plaintext first
+	keyPlaintext, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, decodedKey, nil)
+	require.Nil(t, err, "could not decrypt key to plaintext")
+
+	cipherText, err := base64.StdEncoding.DecodeString(data[0])
+	require.Nil(t, err, "could not decode ciphertext")
+
+	block, err := aes.NewCipher(keyPlaintext)
+	require.Nil(t, err, "could not create aes cipher")
+
+	if len(cipherText) < aes.BlockSize {
+		require.Fail(t, "Cipher text is less than block size")
+	}
+
+	// IV is at the start of the Ciphertext
+	iv := cipherText[:aes.BlockSize]
+	cipherText = cipherText[aes.BlockSize:]
+
+	// XORKeyStream can work in-place if the two arguments are the same.
+	stream := cipher.NewCFBDecrypter(block, iv)
+	decoded := make([]byte, len(cipherText))
+	stream.XORKeyStream(decoded, cipherText)
+
+	require.Equal(t, dataOriginal, decoded, "could not get correct decrypted interaction")
+}
+
+func TestStorageSessions(t *testing.T) {
+	storage := New(1 * time.Hour)
+
+	_, encoded := newTestKey(t)
+
+	require.Nil(t, storage.SetID("token"), "could not set token")
+	require.Nil(t, storage.SetIDPublicKey("b", "secret", encoded), "could not register session")
+	require.Nil(t, storage.SetIDPublicKey("a", "secret", encoded), "could not register session")
+	require.Nil(t, storage.AddInteraction("a", []byte("interaction")), "could not add interaction")
+
+	sessions := storage.ListSessions()
+	require.Len(t, sessions, 2, "could not list sessions")
+	require.Equal(t, "a", sessions[0].CorrelationID, "could not sort sessions")
+	require.Equal(t, 1, sessions[0].Pending, "could not get pending interactions")
+
+	require.Nil(t, storage.RemoveSession("a"), "could not remove session")
+	require.NotNil(t, storage.RemoveSession("token"), "could remove token")
+	_, ok := storage.GetSession("a")
+	require.False(t, ok, "could get removed session")
+	require.Len(t, storage.ListSessions(), 1, "could list removed session")
+}
+
+func TestGetInteractions(t *testing.T) {
+	compressZlib := func(data string) string {
+		var builder strings.Builder
+		writer := zlib.NewWriter(&builder)
+		_, _ = writer.Write([]byte(data))
+		writer.Close()
+		return builder.String()
+	}
+	data := &CorrelationData{
+		dataMutex: &sync.Mutex{},
+		Data:      []string{compressZlib("test"), compressZlib("another")},
+	}
+	decompressed := data.GetInteractions()
+	require.ElementsMatch(t, []string{"test", "another"}, decompressed, "could not get correct decompressed list")
+}
+
+func TestStorageSessionTTL(t *testing.T) {
+	storage := NewWithMaxTTL(time.Hour, 2*time.Hour)
+
+	_, encoded := newTestKey(t)
+
+	require.Nil(t, storage.SetIDPublicKeyWithTTL("short", "secret", encoded, 50*time.Millisecond), "could not set correlation-id")
+	require.Nil(t, storage.SetIDPublicKeyWithTTL("long", "secret", encoded, 10*time.Hour), "could not set correlation-id")
+	require.Nil(t, storage.SetIDPublicKey("default", "secret", encoded), "could not set correlation-id")
+
+	expiry, ok := storage.GetExpiry("long")
+	require.True(t, ok, "could not get expiry")
+	require.WithinDuration(t, time.Now().Add(2*time.Hour), expiry, time.Minute, "could not bound ttl")
+	expiry, ok = storage.GetExpiry("default")
+	require.True(t, ok, "could not get expiry")
+	require.WithinDuration(t, time.Now().Add(time.Hour), expiry, time.Minute, "could not use eviction ttl")
+
+	require.True(t, storage.HasID("short"), "could not get correlation-id")
+	time.Sleep(100 * time.Millisecond)
+	require.False(t, storage.HasID("short"), "could get expired correlation-id")
+	require.Nil(t, storage.SetIDPublicKey("short", "secret", encoded), "could not register expired correlation-id again")
+}
+
+func TestStorageMetrics(t *testing.T) {
+	storage := New(time.Hour)
+	storage.SetInteractionLimit(InteractionLimit{Max: 2, Policy: DropOldest})
+
+	_, encoded := newTestKey(t)
+
+	require.Nil(t, storage.SetIDPublicKey("session", "secret", encoded), "could not set correlation-id")
+	require.Nil(t, storage.SetIDPublicKey("removed", "secret", encoded), "could not set correlation-id")
+	require.Nil(t, storage.SetID("token"), "could not set id")
+	for i := 0; i < 3; i++ {
+		require.Nil(t, storage.AddInteraction("session", []byte("interaction")), "could not add interaction")
+	}
+	require.Nil(t, storage.AddInteraction("removed", []byte("interaction")), "could not add interaction")
+	require.Nil(t, storage.AddInteractionWithId("token", []byte("interaction")), "could not add interaction")
+	// the interactions of an id set again are kept
+	require.Nil(t, storage.SetID("token"), "could not set id")
+
+	metrics := storage.GetCacheMetrics()
+	require.Equal(t, int64(3), metrics.Entries, "could not get entries")
+	require.Equal(t, int64(2), metrics.Sessions, "could not get sessions")
+	require.Equal(t, int64(4), metrics.Pending, "could not get pending interactions")
+	require.Greater(t, metrics.PendingBytes, int64(0), "could not get pending bytes")
+	require.Equal(t, uint64(5), metrics.AddedCount, "could not get added interactions")
+
+	require.Nil(t, storage.RemoveID("removed", "secret"), "could not remove correlation-id")
+	data, _, err := storage.GetInteractions("session", "secret")
+	require.Nil(t, err, "could not get interactions")
+	require.Len(t, data, 2, "could not limit interactions")
+	_, err = storage.GetInteractionsWithId("token")
+	require.Nil(t, err, "could not get interactions")
+
+	metrics = storage.GetCacheMetrics()
+	require.Equal(t, int64(0), metrics.Pending, "could not release pending interactions")
+	require.Equal(t, int64(0), metrics.PendingBytes, "could not release pending bytes")
+	require.Equal(t, uint64(3), metrics.PolledCount, "could not get polled interactions")
+	require.Eventually(t, func() bool {
+		return storage.GetCacheMetrics().Sessions == 1
+	}, time.Second, 10*time.Millisecond, "could not remove session")
+}
+
+func TestStorageExportImport(t *testing.T) {
+	source := New(time.Hour)
+	priv, encoded := newTestKey(t)
+	require.Nil(t, source.SetIDPublicKey("session", "secret", encoded), "could not set correlation-id")
+	require.Nil(t, source.SetID("token"), "could not set id")
+
+	_, ok := source.ExportSession("token")
+	require.False(t, ok, "could export id without public key")
+	session, ok := source.ExportSession("session")
+	require.True(t, ok, "could not export session")
+
+	sqlStorage, err := NewSQL("sqlite://"+filepath.Join(t.TempDir(), "interactsh.db"), time.Hour)
+	require.Nil(t, err, "could not create sql storage")
+	defer sqlStorage.Close()
+	for name, destination := range map[string]Storage{"memory": New(time.Hour), "sql": sqlStorage} {
+		require.Nil(t, destination.ImportSession(session), "could not import %s session", name)
+		require.NotNil(t, destination.ImportSession(session), "could import %s session twice", name)
+		require.Nil(t, destination.AddInteraction("session", []byte("interaction")), "could not add %s interaction", name)
+
+		// the client decrypts the interactions of the new server with its aes key
+		data, aesKey, err := destination.GetInteractions("session", "secret")
+		require.Nil(t, err, "could not get %s interactions", name)
+		require.Equal(t, session.AESKey, aesKey, "could not keep %s aes key", name)
+		require.Len(t, data, 1, "could not get %s interaction", name)
+		key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, mustDecodeBase64(t, aesKey), []byte(""))
+		require.Nil(t, err, "could not decrypt %s aes key", name)
+		ciphertext := mustDecodeBase64(t, data[0])
+		block, err := aes.NewCipher(key)
+		require.Nil(t, err, "could not create cipher")
+		plaintext := make([]byte, len(ciphertext)-aes.BlockSize)
+		cipher.NewCFBDecrypter(block, ciphertext[:aes.BlockSize]).XORKeyStream(plaintext, ciphertext[aes.BlockSize:])
+		require.Equal(t, "interaction", string(plaintext), "could not decrypt %s interaction", name)
+
+		expiry, ok := destination.GetExpiry("session")
+		require.True(t, ok, "could not get %s expiry", name)
+		require.WithinDuration(t, session.ExpiresAt, expiry, time.Millisecond, "could not keep %s expiry", name)
+	}
+
+	session.CorrelationID = "expired"
+	session.ExpiresAt = time.Now().Add(-time.Minute)
+	require.NotNil(t, New(time.Hour).ImportSession(session), "could import expired session")
+}
+
+func mustDecodeBase64(t *testing.T, value string) []byte {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	require.Nil(t, err, "could not decode base64")
+	return decoded
+}
+
+func TestMemoryStorageShards(t *testing.T) {
+	storage := newSharded(time.Hour, time.Hour, 16)
+	ids := make([]string, 100)
+	for i := range ids {
+		ids[i] = xid.New().String()
+		require.Nil(t, storage.SetID(ids[i]), "could not set id")
+	}
+	used := map[interface{}]struct{}{}
+	for _, ID := range ids {
+		used[storage.shard(ID)] = struct{}{}
+	}
+	require.Greater(t, len(used), 8, "could not spread ids across shards")
+
+	var wg sync.WaitGroup
+	for _, ID := range ids {
+		wg.Add(1)
+		go func(ID string) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				_ = storage.AddInteractionWithId(ID, []byte(strconv.Itoa(i)))
+			}
+		}(ID)
+	}
+	wg.Wait()
+	for _, ID := range ids {
+		data, err := storage.GetInteractionsWithId(ID)
+		require.Nil(t, err, "could not get interactions")
+		require.Len(t, data, 10, "could not get interactions of %s", ID)
+	}
+	require.Equal(t, int64(len(ids)), storage.GetCacheMetrics().Entries, "could not count entries")
+}
+
+// BenchmarkMemoryStorage stores and polls the interactions of 1000 ids from
+// parallel goroutines with a single cache and with the sharded storage, run
+// with -cpu 1,2,4,8 to compare their scaling across cores.
+func BenchmarkMemoryStorage(b *testing.B) {
+	data := []byte(`{"protocol":"dns","unique-id":"c6rj61aciaeutn2ae680cg5ugboyyyyyn","q-type":"A"}`)
+	for _, shards := range []int{1, defaultShards} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			storage := newSharded(time.Hour, time.Hour, shards)
+			ids := make([]string, 1000)
+			for i := range ids {
+				ids[i] = xid.New().String()
+				_ = storage.SetID(ids[i])
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					ID := ids[i%len(ids)]
+					_ = storage.AddInteractionWithId(ID, data)
+					if i%10 == 0 {
+						_, _ = storage.GetInteractionsWithId(ID)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkCacheParallel(b *testing.B) {
+	config := ccache.Configure().MaxSize(defaultCacheMaxSize).Buckets(64).GetsPerPromote(10).PromoteBuffer(4096)
+	cache := ccache.New(config)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			doStuffWithCache(cache)
+		}
+	})
+}
+
+func BenchmarkCacheParallelOther(b *testing.B) {
+	cache := cache.New(cache.WithMaximumSize(defaultCacheMaxSize), cache.WithExpireAfterWrite(24*7*time.Hour))
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			doStuffWithOtherCache(cache)
+		}
+	})
+}
+
+func doStuffWithCache(cache *ccache.Cache) {
+	for i := 0; i < 1e2; i++ {
+		cache.Set(strconv.Itoa(i), "test", 1*time.Minute)
+		_ = cache.Get(strconv.Itoa(i))
+	}
+}
+
+func doStuffWithOtherCache(cache cache.Cache) {
+	for i := 0; i < 1e2; i++ {
+		cache.Put(strconv.Itoa(i), "test")
+		_, _ = cache.GetIfPresent(strconv.Itoa(i))
+	}
+}
@@ -0,0 +1,459 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// redisKeyPrefix is the prefix of the keys of the redis storage
+const redisKeyPrefix = "interactsh:"
+
+var _ Storage = &RedisStorage{}
+
+// RedisStorage is a storage keeping the ids and their interactions in redis,
+// so that several interactsh-server instances behind a load balancer share
+// the sessions. Each id is a hash holding its secret and keys, and its
+// interactions a list, both expiring with the eviction ttl.
+type RedisStorage struct {
+	client      *redis.Client
+	evictionTTL time.Duration
+	limit       InteractionLimit
+
+	hitCount     uint64
+	missCount    uint64
+	droppedCount uint64
+	addedCount   uint64
+	polledCount  uint64
+}
+
+// NewRedis creates a new storage instance on the redis server of the url
+// (redis://[user:password@]host:port[/db]).
+func NewRedis(url string, evictionTTL time.Duration) (*RedisStorage, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse redis url")
+	}
+	client := redis.NewClient(options)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		_ = client.Close()
+		return nil, errors.Wrap(err, "could not connect to redis")
+	}
+	return &RedisStorage{client: client, evictionTTL: evictionTTL}, nil
+}
+
+// Close closes the connections to the redis server.
+func (s *RedisStorage) Close() error {
+	return s.client.Close()
+}
+
+// SetInteractionLimit sets the maximum number of pending interactions per id.
+// The ids shared by the instances count the interactions received by all of them.
+func (s *RedisStorage) SetInteractionLimit(limit InteractionLimit) {
+	s.limit = limit
+}
+
+// idKey is the key of the hash of an id
+func idKey(ID string) string {
+	return redisKeyPrefix + "id:" + ID
+}
+
+// dataKey is the key of the list of the interactions of an id
+func dataKey(ID string) string {
+	return redisKeyPrefix + "data:" + ID
+}
+
+// sessionsKey is the key of the set of the correlation-ids registered with a public key
+const sessionsKey = redisKeyPrefix + "sessions"
+
+// get returns the fields of the hash of an id, ErrCorrelationIDNotFound if it isn't registered
+func (s *RedisStorage) get(ID string) (map[string]string, error) {
+	fields, err := s.client.HGetAll(context.Background(), idKey(ID)).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get id from redis")
+	}
+	if len(fields) == 0 {
+		atomic.AddUint64(&s.missCount, 1)
+		return nil, ErrCorrelationIDNotFound
+	}
+	atomic.AddUint64(&s.hitCount, 1)
+	return fields, nil
+}
+
+// SetIDPublicKey sets the correlation ID and publicKey into the storage for further operations.
+func (s *RedisStorage) SetIDPublicKey(correlationID, secretKey string, publicKey string) error {
+	return s.SetIDPublicKeyWithTTL(correlationID, secretKey, publicKey, 0)
+}
+
+// SetIDPublicKeyWithTTL sets the correlation ID and publicKey like SetIDPublicKey,
+// expiring the correlation ID after a ttl (the eviction ttl if 0).
+func (s *RedisStorage) SetIDPublicKeyWithTTL(correlationID, secretKey string, publicKey string, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = s.evictionTTL
+	}
+	publicKeyData, err := parseB64RSAPublicKeyFromPEM(publicKey)
+	if err != nil {
+		return errors.Wrap(err, "could not read public Key")
+	}
+	aesKey := uuid.New().String()[:32]
+
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKeyData, []byte(aesKey), []byte(""))
+	if err != nil {
+		return errors.New("could not encrypt event data")
+	}
+
+	return s.setSession(correlationID, secretKey, aesKey, base64.StdEncoding.EncodeToString(ciphertext), time.Now().Add(ttl))
+}
+
+// setSession registers a correlation ID with its secret, its AES key and the
+// AES key encrypted for its client, failing if it already exists
+func (s *RedisStorage) setSession(correlationID, secretKey, aesKey, encryptedKey string, expiresAt time.Time) error {
+	ctx := context.Background()
+	key := idKey(correlationID)
+	created, err := s.client.HSetNX(ctx, key, "secret", secretKey).Result()
+	if err != nil {
+		return errors.Wrap(err, "could not set correlation-id in redis")
+	}
+	if !created {
+		return errors.New("correlation-id provided already exists")
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "key", aesKey, "aes-key", encryptedKey, "expires", expiresAt.UnixNano())
+		pipe.ExpireAt(ctx, key, expiresAt)
+		pipe.Del(ctx, dataKey(correlationID))
+		pipe.SAdd(ctx, sessionsKey, correlationID)
+		return nil
+	})
+	if err != nil {
+		_ = s.client.Del(ctx, key).Err()
+		return errors.Wrap(err, "could not set correlation-id in redis")
+	}
+	return nil
+}
+
+// SetID sets an id without public key, eg. the token, storing its interactions
+// unencrypted. The interactions stored by the other instances for the id are kept.
+func (s *RedisStorage) SetID(ID string) error {
+	ctx := context.Background()
+	key := idKey(ID)
+	expiresAt := time.Now().Add(s.evictionTTL)
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "expires", expiresAt.UnixNano())
+		pipe.ExpireAt(ctx, key, expiresAt)
+		return nil
+	})
+	return errors.Wrap(err, "could not set id in redis")
+}
+
+// HasID returns true if the correlation id or id is registered in the storage.
+func (s *RedisStorage) HasID(ID string) bool {
+	found, err := s.client.Exists(context.Background(), idKey(ID)).Result()
+	if err != nil || found == 0 {
+		atomic.AddUint64(&s.missCount, 1)
+		return false
+	}
+	atomic.AddUint64(&s.hitCount, 1)
+	return true
+}
+
+// push appends a data item stored at the current time to the interactions
+// of an id applying the limit of pending interactions, expiring them with the id
+func (s *RedisStorage) push(ID string, fields map[string]string, item string) error {
+	ctx := context.Background()
+	expires, _ := strconv.ParseInt(fields["expires"], 10, 64)
+	value := strconv.FormatInt(time.Now().UnixNano(), 10) + ":" + item
+	atomic.AddUint64(&s.addedCount, 1)
+
+	action := appendItem
+	var index int
+	if s.limit.Max > 0 {
+		var seen *redis.IntCmd
+		var pending *redis.IntCmd
+		_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			seen = pipe.HIncrBy(ctx, idKey(ID), "seen", 1)
+			pipe.ExpireAt(ctx, idKey(ID), time.Unix(0, expires))
+			pending = pipe.LLen(ctx, dataKey(ID))
+			return nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "could not add interaction to redis")
+		}
+		action, index = s.limit.action(int(pending.Val()), int(seen.Val()))
+	}
+	switch action {
+	case dropItem:
+		atomic.AddUint64(&s.droppedCount, 1)
+		return nil
+	case replaceItem:
+		atomic.AddUint64(&s.droppedCount, 1)
+		return errors.Wrap(s.client.LSet(ctx, dataKey(ID), int64(index), value).Err(), "could not add interaction to redis")
+	case shiftItem:
+		atomic.AddUint64(&s.droppedCount, uint64(index))
+	}
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, dataKey(ID), value)
+		if action == shiftItem {
+			// trimming to the newest interactions keeps the limit with concurrent instances
+			pipe.LTrim(ctx, dataKey(ID), -int64(s.limit.Max), -1)
+		}
+		pipe.ExpireAt(ctx, dataKey(ID), time.Unix(0, expires))
+		return nil
+	})
+	return errors.Wrap(err, "could not add interaction to redis")
+}
+
+// AddInteraction adds an interaction data to the correlation ID after encrypting
+// it with Public Key for the provided correlation ID.
+func (s *RedisStorage) AddInteraction(correlationID string, data []byte) error {
+	fields, err := s.get(correlationID)
+	if err != nil {
+		return errors.Wrap(err, "could not get correlation-id")
+	}
+	if fields["key"] == "" {
+		return errors.New("no encryption key for correlation-id")
+	}
+	ct, err := seal([]byte(fields["key"]), data)
+	if err != nil {
+		return errors.Wrap(err, "could not encrypt event data")
+	}
+	return s.push(correlationID, fields, ct)
+}
+
+// AddInteractionWithId adds an interaction data to the id bucket
+func (s *RedisStorage) AddInteractionWithId(id string, data []byte) error {
+	fields, err := s.get(id)
+	if err != nil {
+		return errors.Wrap(err, "could not get id")
+	}
+	compressed, err := seal(nil, data)
+	if err != nil {
+		return err
+	}
+	return s.push(id, fields, compressed)
+}
+
+// Encrypt encrypts data with the AES key of the correlation ID, returning the
+// base64 encoded ciphertext which can be decrypted like the interactions.
+func (s *RedisStorage) Encrypt(correlationID string, data []byte) (string, error) {
+	fields, err := s.get(correlationID)
+	if err != nil {
+		return "", errors.New("could not get correlation-id from cache")
+	}
+	if fields["key"] == "" {
+		return "", errors.New("no encryption key for correlation-id")
+	}
+	return aesEncryptRaw([]byte(fields["key"]), data)
+}
+
+// GetAESKey returns the AES Encrypted Key for a correlationID.
+func (s *RedisStorage) GetAESKey(correlationID, secret string) (string, error) {
+	fields, err := s.get(correlationID)
+	if err != nil {
+		return "", errors.New("could not get correlation-id from cache")
+	}
+	if !strings.EqualFold(fields["secret"], secret) {
+		return "", errors.New("invalid secret key passed for user")
+	}
+	return fields["aes-key"], nil
+}
+
+// pop returns and removes the interactions of an id, encrypted with its key if any
+func (s *RedisStorage) pop(ID string, key []byte) ([]string, []time.Time, error) {
+	ctx := context.Background()
+	var items *redis.StringSliceCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		items = pipe.LRange(ctx, dataKey(ID), 0, -1)
+		pipe.Del(ctx, dataKey(ID))
+		pipe.HDel(ctx, idKey(ID), "seen")
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not get interactions from redis")
+	}
+	atomic.AddUint64(&s.polledCount, uint64(len(items.Val())))
+	var data []string
+	var storedAt []time.Time
+	for _, item := range items.Val() {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		nanoseconds, _ := strconv.ParseInt(parts[0], 10, 64)
+		data = append(data, parts[1])
+		storedAt = append(storedAt, time.Unix(0, nanoseconds))
+	}
+	return unseal(key, data), storedAt, nil
+}
+
+// GetInteractions returns the interactions for a correlationID and removes
+// it from the storage. It also returns AES Encrypted Key for the IDs.
+func (s *RedisStorage) GetInteractions(correlationID, secret string) ([]string, string, error) {
+	data, _, aesKey, err := s.GetInteractionsWithStoredAt(correlationID, secret)
+	return data, aesKey, err
+}
+
+// GetInteractionsWithStoredAt returns the interactions for a correlationID
+// like GetInteractions, along with the time each one was stored at.
+func (s *RedisStorage) GetInteractionsWithStoredAt(correlationID, secret string) ([]string, []time.Time, string, error) {
+	fields, err := s.get(correlationID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if !strings.EqualFold(fields["secret"], secret) {
+		return nil, nil, "", errors.New("invalid secret key passed for user")
+	}
+	data, storedAt, err := s.pop(correlationID, []byte(fields["key"]))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return data, storedAt, fields["aes-key"], nil
+}
+
+// GetExpiry returns the time a registered correlation-id is evicted at.
+func (s *RedisStorage) GetExpiry(correlationID string) (time.Time, bool) {
+	fields, err := s.get(correlationID)
+	if err != nil || fields["key"] == "" {
+		return time.Time{}, false
+	}
+	expires, err := strconv.ParseInt(fields["expires"], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, expires), true
+}
+
+// GetInteractionsWithId returns the interactions for a id and empty the storage
+func (s *RedisStorage) GetInteractionsWithId(id string) ([]string, error) {
+	if _, err := s.get(id); err != nil {
+		return nil, errors.New("could not get id from cache")
+	}
+	data, _, err := s.pop(id, nil)
+	return data, err
+}
+
+// remove removes an id and its interactions
+func (s *RedisStorage) remove(ID string) error {
+	ctx := context.Background()
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, idKey(ID), dataKey(ID))
+		pipe.SRem(ctx, sessionsKey, ID)
+		return nil
+	})
+	return errors.Wrap(err, "could not remove id from redis")
+}
+
+// RemoveID removes data for a correlation ID and data related to it.
+func (s *RedisStorage) RemoveID(correlationID, secret string) error {
+	fields, err := s.get(correlationID)
+	if err != nil {
+		return errors.New("could not get correlation-id from cache")
+	}
+	if !strings.EqualFold(fields["secret"], secret) {
+		return errors.New("invalid secret key passed for deregister")
+	}
+	return s.remove(correlationID)
+}
+
+// RemoveSession removes a session registered with a public key without
+// requiring its secret, eg. to deregister it from the admin api.
+func (s *RedisStorage) RemoveSession(correlationID string) error {
+	fields, err := s.get(correlationID)
+	if err != nil || fields["key"] == "" {
+		return ErrCorrelationIDNotFound
+	}
+	return s.remove(correlationID)
+}
+
+// GetSession returns the state of a session registered with a public key.
+func (s *RedisStorage) GetSession(correlationID string) (SessionInfo, bool) {
+	expiresAt, ok := s.GetExpiry(correlationID)
+	if !ok {
+		return SessionInfo{}, false
+	}
+	pending, _ := s.client.LLen(context.Background(), dataKey(correlationID)).Result()
+	return SessionInfo{CorrelationID: correlationID, ExpiresAt: expiresAt, Pending: int(pending)}, true
+}
+
+// ListSessions returns the state of all the sessions registered with
+// a public key sorted by correlation-id, forgetting the evicted ones.
+func (s *RedisStorage) ListSessions() []SessionInfo {
+	ctx := context.Background()
+	correlationIDs, err := s.client.SMembers(ctx, sessionsKey).Result()
+	if err != nil {
+		return nil
+	}
+	var sessions []SessionInfo
+	for _, correlationID := range correlationIDs {
+		if session, ok := s.GetSession(correlationID); ok {
+			sessions = append(sessions, session)
+		} else {
+			_ = s.client.SRem(ctx, sessionsKey, correlationID).Err()
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CorrelationID < sessions[j].CorrelationID
+	})
+	return sessions
+}
+
+// ExportSession returns the registration of a session with its secret and AES keys.
+func (s *RedisStorage) ExportSession(correlationID string) (SessionExport, bool) {
+	fields, err := s.get(correlationID)
+	if err != nil || fields["key"] == "" {
+		return SessionExport{}, false
+	}
+	expires, _ := strconv.ParseInt(fields["expires"], 10, 64)
+	return SessionExport{
+		CorrelationID: correlationID,
+		SecretKey:     fields["secret"],
+		AESKey:        fields["aes-key"],
+		RawAESKey:     []byte(fields["key"]),
+		ExpiresAt:     time.Unix(0, expires),
+	}, true
+}
+
+// ImportSession registers a session exported by another server.
+func (s *RedisStorage) ImportSession(session SessionExport) error {
+	if err := session.validate(); err != nil {
+		return err
+	}
+	return s.setSession(session.CorrelationID, session.SecretKey, string(session.RawAESKey), session.AESKey, session.ExpiresAt)
+}
+
+// GetCacheMetrics returns the hits and misses of the instance, the entries
+// being the number of sessions registered with a public key on every instance
+// and the used bytes the memory used by the redis server. The pending
+// interactions aren't reported, counting them requiring to scan the sessions.
+func (s *RedisStorage) GetCacheMetrics() *CacheMetrics {
+	ctx := context.Background()
+	entries, _ := s.client.SCard(ctx, sessionsKey).Result()
+	metrics := &CacheMetrics{
+		HitCount:     atomic.LoadUint64(&s.hitCount),
+		MissCount:    atomic.LoadUint64(&s.missCount),
+		DroppedCount: atomic.LoadUint64(&s.droppedCount),
+		AddedCount:   atomic.LoadUint64(&s.addedCount),
+		PolledCount:  atomic.LoadUint64(&s.polledCount),
+		Entries:      entries,
+		Sessions:     entries,
+	}
+	if info, err := s.client.Info(ctx, "memory").Result(); err == nil {
+		for _, line := range strings.Split(info, "\n") {
+			if value := strings.TrimPrefix(line, "used_memory:"); value != line {
+				metrics.UsedBytes, _ = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+				break
+			}
+		}
+	}
+	return metrics
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/rs/xid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisStorage(t *testing.T) {
+	server, err := miniredis.Run()
+	require.Nil(t, err, "could not start redis server")
+	defer server.Close()
+
+	storage, err := NewRedis("redis://"+server.Addr(), time.Hour)
+	require.Nil(t, err, "could not create redis storage")
+	defer storage.Close()
+	// a second instance behind the load balancer
+	other, err := NewRedis("redis://"+server.Addr(), time.Hour)
+	require.Nil(t, err, "could not create redis storage")
+	defer other.Close()
+
+	priv, encoded := newTestKey(t)
+
+	secret := uuid.New().String()
+	correlationID := xid.New().String()
+	require.Nil(t, storage.SetIDPublicKey(correlationID, secret, encoded), "could not set correlation-id")
+	require.NotNil(t, other.SetIDPublicKey(correlationID, secret, encoded), "could set correlation-id twice")
+	require.True(t, other.HasID(correlationID), "could not share correlation-id")
+
+	require.Nil(t, other.AddInteraction(correlationID, []byte("hello world")), "could not add interaction")
+	data, storedAt, key, err := storage.GetInteractionsWithStoredAt(correlationID, secret)
+	require.Nil(t, err, "could not get interactions")
+	require.Len(t, data, 1, "could not get interaction")
+	require.WithinDuration(t, time.Now(), storedAt[0], time.Minute, "invalid interaction storage time")
+
+	decodedKey, err := base64.StdEncoding.DecodeString(key)
+	require.Nil(t, err, "could not decode key")
+	keyPlaintext, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, decodedKey, nil)
+	require.Nil(t, err, "could not decrypt key")
+	cipherText, err := base64.StdEncoding.DecodeString(data[0])
+	require.Nil(t, err, "could not decode ciphertext")
+	block, err := aes.NewCipher(keyPlaintext)
+	require.Nil(t, err, "could not create aes cipher")
+	decoded := make([]byte, len(cipherText)-aes.BlockSize)
+	cipher.NewCFBDecrypter(block, cipherText[:aes.BlockSize]).XORKeyStream(decoded, cipherText[aes.BlockSize:])
+	require.Equal(t, "hello world", string(decoded), "could not decrypt interaction")
+
+	data, _, err = storage.GetInteractions(correlationID, secret)
+	require.Nil(t, err, "could not get interactions")
+	require.Empty(t, data, "could get interactions twice")
+	_, _, err = storage.GetInteractions(correlationID, "invalid")
+	require.NotNil(t, err, "could get interactions with invalid secret")
+
+	require.Nil(t, storage.SetID("token"), "could not set token")
+	require.Nil(t, other.AddInteractionWithId("token", []byte("plaintext")), "could not add interaction with id")
+	data, err = storage.GetInteractionsWithId("token")
+	require.Nil(t, err, "could not get interactions with id")
+	require.Equal(t, []string{"plaintext"}, data, "invalid interactions with id")
+
+	sessions := other.ListSessions()
+	require.Len(t, sessions, 1, "could not list sessions")
+	require.Equal(t, correlationID, sessions[0].CorrelationID, "invalid session")
+	require.Equal(t, int64(1), storage.GetCacheMetrics().Entries, "invalid entries")
+
+	server.FastForward(2 * time.Hour)
+	require.False(t, storage.HasID(correlationID), "could not evict correlation-id")
+	require.Empty(t, storage.ListSessions(), "listed evicted session")
+	_, err = storage.GetInteractionsWithId("token")
+	require.NotNil(t, err, "could not evict token")
+}
+
+func TestRedisStorageRemoveID(t *testing.T) {
+	server, err := miniredis.Run()
+	require.Nil(t, err, "could not start redis server")
+	defer server.Close()
+	storage, err := NewRedis("redis://"+server.Addr(), time.Hour)
+	require.Nil(t, err, "could not create redis storage")
+	defer storage.Close()
+
+	_, encoded := newTestKey(t)
+
+	require.Nil(t, storage.SetIDPublicKey("c1", "secret", encoded), "could not set correlation-id")
+	require.NotNil(t, storage.RemoveID("c1", "invalid"), "could remove id with invalid secret")
+	require.Nil(t, storage.RemoveID("c1", "secret"), "could not remove id")
+	require.False(t, storage.HasID("c1"), "could not remove id")
+
+	require.Nil(t, storage.SetIDPublicKey("c2", "secret", encoded), "could not set correlation-id")
+	require.Nil(t, storage.RemoveSession("c2"), "could not remove session")
+	require.Equal(t, ErrCorrelationIDNotFound, storage.RemoveSession("c2"), "could remove session twice")
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+)
+
+// snapshotVersion is the version of the snapshot format
+const snapshotVersion = 1
+
+// snapshot is the state of a memory storage written to disk
+type snapshot struct {
+	Version   int
+	CreatedAt time.Time
+	Entries   []snapshotEntry
+}
+
+// snapshotEntry is an id of a memory storage with its pending interactions,
+// the interactions of the correlation-ids being kept encrypted.
+type snapshotEntry struct {
+	ID        string
+	SecretKey string
+	// AESKey is the AES key encrypted for the client
+	AESKey string
+	// RawAESKey is the AES key the interactions are encrypted with
+	RawAESKey []byte
+	Data      []string
+	StoredAt  []time.Time
+	ExpiresAt time.Time
+}
+
+// Snapshot writes the ids of the storage along with their pending interactions
+// and AES keys to a file, replacing it atomically.
+func (s *MemoryStorage) Snapshot(path string) error {
+	state := snapshot{Version: snapshotVersion, CreatedAt: time.Now()}
+	s.ids.Range(func(key, _ interface{}) bool {
+		value, err := s.GetCacheItem(key.(string))
+		if err != nil {
+			return true
+		}
+		value.dataMutex.Lock()
+		entry := snapshotEntry{
+			ID:        key.(string),
+			SecretKey: value.secretKey,
+			AESKey:    value.AESKey,
+			RawAESKey: value.aesKey,
+			Data:      append([]string(nil), value.Data...),
+			StoredAt:  append([]time.Time(nil), value.storedAt...),
+			ExpiresAt: value.expiresAt,
+		}
+		value.dataMutex.Unlock()
+		state.Entries = append(state.Entries, entry)
+		return true
+	})
+
+	file, err := ioutil.TempFile(filepath.Dir(path), ".snapshot-*")
+	if err != nil {
+		return errors.Wrap(err, "could not create snapshot")
+	}
+	if err := gob.NewEncoder(file).Encode(&state); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return errors.Wrap(err, "could not write snapshot")
+	}
+	if err := file.Close(); err != nil {
+		_ = os.Remove(file.Name())
+		return errors.Wrap(err, "could not write snapshot")
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		_ = os.Remove(file.Name())
+		return errors.Wrap(err, "could not write snapshot")
+	}
+	return nil
+}
+
+// Restore restores the ids of a snapshot file which haven't expired yet,
+// returning the number of restored ids. A missing file restores nothing.
+func (s *MemoryStorage) Restore(path string) (int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "could not open snapshot")
+	}
+	defer file.Close()
+
+	var state snapshot
+	if err := gob.NewDecoder(file).Decode(&state); err != nil {
+		return 0, errors.Wrap(err, "could not read snapshot")
+	}
+	if state.Version != snapshotVersion {
+		return 0, errors.Errorf("unsupported snapshot version %d", state.Version)
+	}
+
+	now := time.Now()
+	restored := 0
+	for _, entry := range state.Entries {
+		if !entry.ExpiresAt.IsZero() && !entry.ExpiresAt.After(now) {
+			continue
+		}
+		if len(entry.StoredAt) != len(entry.Data) {
+			entry.StoredAt = make([]time.Time, len(entry.Data))
+		}
+		data := &CorrelationData{
+			Data:      entry.Data,
+			storedAt:  entry.StoredAt,
+			dataMutex: &sync.Mutex{},
+			secretKey: entry.SecretKey,
+			AESKey:    entry.AESKey,
+			aesKey:    entry.RawAESKey,
+			expiresAt: entry.ExpiresAt,
+		}
+		if data.Data == nil {
+			data.Data = make([]string, 0)
+		}
+		// the correlation-ids are evicted at their original expiry once looked up
+		s.put(entry.ID, data)
+		restored++
+	}
+	return restored, nil
+}
+
+// Snapshotter periodically snapshots a memory storage to a file.
+type Snapshotter struct {
+	storage  *MemoryStorage
+	path     string
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewSnapshotter returns a snapshotter writing the storage to a file every interval.
+func NewSnapshotter(storage *MemoryStorage, path string, interval time.Duration) *Snapshotter {
+	snapshotter := &Snapshotter{storage: storage, path: path, stop: make(chan struct{}), done: make(chan struct{})}
+	go snapshotter.loop(interval)
+	return snapshotter
+}
+
+// loop snapshots the storage until the snapshotter is closed
+func (s *Snapshotter) loop(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.storage.Snapshot(s.path); err != nil {
+				gologger.Error().Msgf("Could not snapshot storage: %s\n", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops the periodic snapshots and writes a last snapshot.
+func (s *Snapshotter) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+	return s.storage.Snapshot(s.path)
+}
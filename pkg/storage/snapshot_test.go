@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStorageSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot")
+	storage := New(time.Hour)
+
+	_, encoded := newTestKey(t)
+
+	require.Nil(t, storage.SetIDPublicKey("c1", "secret", encoded), "could not set correlation-id")
+	require.Nil(t, storage.AddInteraction("c1", []byte("hello world")), "could not add interaction")
+	require.Nil(t, storage.SetIDPublicKey("expired", "secret", encoded), "could not set correlation-id")
+	expired, err := storage.GetCacheItem("expired")
+	require.Nil(t, err, "could not get correlation-id")
+	expired.expiresAt = time.Now().Add(-time.Minute)
+	require.Nil(t, storage.SetID("token"), "could not set token")
+	require.Nil(t, storage.AddInteractionWithId("token", []byte("plaintext")), "could not add interaction with id")
+	_, aesKey, err := storage.GetInteractions("c1", "secret")
+	require.Nil(t, err, "could not get aes key")
+	require.Nil(t, storage.AddInteraction("c1", []byte("pending")), "could not add interaction")
+
+	require.Nil(t, storage.Snapshot(path), "could not snapshot storage")
+
+	restart := New(time.Hour)
+	restored, err := restart.Restore(path)
+	require.Nil(t, err, "could not restore snapshot")
+	require.Equal(t, 2, restored, "could not skip expired correlation-id")
+	require.False(t, restart.HasID("expired"), "could restore expired correlation-id")
+
+	// the token interactions are kept when it's set again at startup
+	require.Nil(t, restart.SetID("token"), "could not set token")
+	data, err := restart.GetInteractionsWithId("token")
+	require.Nil(t, err, "could not get interactions with id")
+	require.Equal(t, []string{"plaintext"}, data, "could not restore token interactions")
+
+	data, storedAt, key, err := restart.GetInteractionsWithStoredAt("c1", "secret")
+	require.Nil(t, err, "could not get restored interactions")
+	require.Equal(t, aesKey, key, "could not restore aes key")
+	require.Len(t, data, 1, "could not restore pending interactions")
+	require.Len(t, storedAt, 1, "could not restore interaction storage time")
+	expiry, ok := restart.GetExpiry("c1")
+	require.True(t, ok, "could not get restored expiry")
+	original, _ := storage.GetExpiry("c1")
+	require.True(t, expiry.Equal(original), "could not restore expiry")
+	_, err = restart.Encrypt("c1", []byte("data"))
+	require.Nil(t, err, "could not encrypt with restored aes key")
+
+	// a missing snapshot restores nothing
+	restored, err = New(time.Hour).Restore(filepath.Join(t.TempDir(), "missing"))
+	require.Nil(t, err, "could not ignore missing snapshot")
+	require.Zero(t, restored, "could restore missing snapshot")
+}
+
+func TestSnapshotter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot")
+	storage := New(time.Hour)
+	require.Nil(t, storage.SetID("token"), "could not set token")
+
+	snapshotter := NewSnapshotter(storage, path, time.Hour)
+	require.Nil(t, storage.AddInteractionWithId("token", []byte("plaintext")), "could not add interaction with id")
+	require.Nil(t, snapshotter.Close(), "could not write last snapshot")
+
+	restart := New(time.Hour)
+	restored, err := restart.Restore(path)
+	require.Nil(t, err, "could not restore snapshot")
+	require.Equal(t, 1, restored, "could not restore token")
+}
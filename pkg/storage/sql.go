@@ -0,0 +1,583 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite"
+)
+
+// sqlEvictionInterval is the interval the expired ids and interactions are deleted at
+const sqlEvictionInterval = time.Minute
+
+// sqlMigrations are the schema migrations of the sql storage, applied in
+// order and recorded in the schema_migrations table. {serial} is replaced
+// by the auto-incremented primary key of the driver.
+var sqlMigrations = []string{
+	`CREATE TABLE ids (
+		id TEXT PRIMARY KEY,
+		secret TEXT NOT NULL DEFAULT '',
+		raw_key TEXT NOT NULL DEFAULT '',
+		aes_key TEXT NOT NULL DEFAULT '',
+		expires_at BIGINT NOT NULL
+	);
+	CREATE TABLE interactions (
+		seq {serial},
+		id TEXT NOT NULL,
+		data TEXT NOT NULL,
+		stored_at BIGINT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		polled INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX interactions_id ON interactions (id, polled);
+	CREATE INDEX interactions_expires_at ON interactions (expires_at);`,
+	`ALTER TABLE ids ADD COLUMN seen BIGINT NOT NULL DEFAULT 0;`,
+}
+
+var _ Storage = &SQLStorage{}
+
+// SQLStorage is a storage persisting the ids and their interactions in a
+// PostgreSQL or SQLite database, so that they survive the restarts and can
+// be queried for forensics. The interactions of the sessions are stored
+// encrypted for their client and kept once polled until they expire, the
+// expired ids and interactions being deleted by an eviction job.
+type SQLStorage struct {
+	db          *sql.DB
+	postgres    bool
+	evictionTTL time.Duration
+	limit       InteractionLimit
+
+	hitCount      uint64
+	missCount     uint64
+	evictionCount uint64
+	droppedCount  uint64
+	addedCount    uint64
+	polledCount   uint64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewSQL creates a new storage instance on the database of the url, either
+// postgres://[user:password@]host[:port]/database[?options] or sqlite://path,
+// applying the pending schema migrations.
+func NewSQL(url string, evictionTTL time.Duration) (*SQLStorage, error) {
+	var driver, dsn string
+	switch {
+	case strings.HasPrefix(url, "postgres://"), strings.HasPrefix(url, "postgresql://"):
+		driver, dsn = "postgres", url
+	case strings.HasPrefix(url, "sqlite://"):
+		driver, dsn = "sqlite", strings.TrimPrefix(url, "sqlite://")
+	default:
+		return nil, errors.Errorf("unsupported database url %s (postgres:// or sqlite://)", url)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open database")
+	}
+	if driver == "sqlite" {
+		// sqlite serializes the writes
+		db.SetMaxOpenConns(1)
+	}
+	storage := &SQLStorage{db: db, postgres: driver == "postgres", evictionTTL: evictionTTL, stop: make(chan struct{})}
+	if err := storage.migrate(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	go storage.evictLoop()
+	return storage, nil
+}
+
+// Close stops the eviction job and closes the database.
+func (s *SQLStorage) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	return s.db.Close()
+}
+
+// SetInteractionLimit sets the maximum number of pending interactions per id.
+func (s *SQLStorage) SetInteractionLimit(limit InteractionLimit) {
+	s.limit = limit
+}
+
+// migrate applies the schema migrations which haven't been applied yet
+func (s *SQLStorage) migrate() error {
+	if _, err := s.db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)"); err != nil {
+		return errors.Wrap(err, "could not create migrations table")
+	}
+	var version int
+	if err := s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return errors.Wrap(err, "could not get schema version")
+	}
+	serial := "INTEGER PRIMARY KEY AUTOINCREMENT"
+	if s.postgres {
+		serial = "BIGSERIAL PRIMARY KEY"
+	}
+	for i := version; i < len(sqlMigrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return errors.Wrap(err, "could not begin migration")
+		}
+		for _, statement := range strings.Split(strings.ReplaceAll(sqlMigrations[i], "{serial}", serial), ";") {
+			if strings.TrimSpace(statement) == "" {
+				continue
+			}
+			if _, err := tx.Exec(statement); err != nil {
+				_ = tx.Rollback()
+				return errors.Wrapf(err, "could not apply migration %d", i+1)
+			}
+		}
+		if _, err := tx.Exec(s.rebind("INSERT INTO schema_migrations (version) VALUES (?)"), i+1); err != nil {
+			_ = tx.Rollback()
+			return errors.Wrapf(err, "could not record migration %d", i+1)
+		}
+		if err := tx.Commit(); err != nil {
+			return errors.Wrapf(err, "could not apply migration %d", i+1)
+		}
+	}
+	return nil
+}
+
+// rebind replaces the ? placeholders of a query with the $n ones of postgres
+func (s *SQLStorage) rebind(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var builder strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			builder.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// evictLoop deletes the expired ids and interactions until the storage is closed
+func (s *SQLStorage) evictLoop() {
+	ticker := time.NewTicker(sqlEvictionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = s.evict(time.Now())
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// evict deletes the ids and interactions expired at a time
+func (s *SQLStorage) evict(now time.Time) error {
+	if _, err := s.db.Exec(s.rebind("DELETE FROM interactions WHERE expires_at <= ?"), now.UnixNano()); err != nil {
+		return errors.Wrap(err, "could not evict interactions")
+	}
+	result, err := s.db.Exec(s.rebind("DELETE FROM ids WHERE expires_at <= ?"), now.UnixNano())
+	if err != nil {
+		return errors.Wrap(err, "could not evict ids")
+	}
+	if evicted, err := result.RowsAffected(); err == nil {
+		atomic.AddUint64(&s.evictionCount, uint64(evicted))
+	}
+	return nil
+}
+
+// sqlID is a row of the ids table
+type sqlID struct {
+	secret    string
+	rawKey    string
+	aesKey    string
+	expiresAt int64
+}
+
+// get returns an id which hasn't expired, ErrCorrelationIDNotFound if it isn't registered
+func (s *SQLStorage) get(ID string) (*sqlID, error) {
+	row := &sqlID{}
+	err := s.db.QueryRow(s.rebind("SELECT secret, raw_key, aes_key, expires_at FROM ids WHERE id = ? AND expires_at > ?"), ID, time.Now().UnixNano()).Scan(&row.secret, &row.rawKey, &row.aesKey, &row.expiresAt)
+	if err == sql.ErrNoRows {
+		atomic.AddUint64(&s.missCount, 1)
+		return nil, ErrCorrelationIDNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get id from database")
+	}
+	atomic.AddUint64(&s.hitCount, 1)
+	return row, nil
+}
+
+// SetIDPublicKey sets the correlation ID and publicKey into the storage for further operations.
+func (s *SQLStorage) SetIDPublicKey(correlationID, secretKey string, publicKey string) error {
+	return s.SetIDPublicKeyWithTTL(correlationID, secretKey, publicKey, 0)
+}
+
+// SetIDPublicKeyWithTTL sets the correlation ID and publicKey like SetIDPublicKey,
+// expiring the correlation ID after a ttl (the eviction ttl if 0).
+func (s *SQLStorage) SetIDPublicKeyWithTTL(correlationID, secretKey string, publicKey string, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = s.evictionTTL
+	}
+	publicKeyData, err := parseB64RSAPublicKeyFromPEM(publicKey)
+	if err != nil {
+		return errors.Wrap(err, "could not read public Key")
+	}
+	aesKey := uuid.New().String()[:32]
+
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKeyData, []byte(aesKey), []byte(""))
+	if err != nil {
+		return errors.New("could not encrypt event data")
+	}
+
+	return s.setSession(correlationID, secretKey, aesKey, base64.StdEncoding.EncodeToString(ciphertext), time.Now().Add(ttl))
+}
+
+// setSession registers a correlation ID with its secret, its AES key and the
+// AES key encrypted for its client, failing if it already exists
+func (s *SQLStorage) setSession(correlationID, secretKey, aesKey, encryptedKey string, expiresAt time.Time) error {
+	// the expired ids not evicted yet can be registered again
+	if err := s.remove(correlationID, true); err != nil {
+		return err
+	}
+	result, err := s.db.Exec(s.rebind("INSERT INTO ids (id, secret, raw_key, aes_key, expires_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING"),
+		correlationID, secretKey, aesKey, encryptedKey, expiresAt.UnixNano())
+	if err != nil {
+		return errors.Wrap(err, "could not set correlation-id in database")
+	}
+	if inserted, err := result.RowsAffected(); err == nil && inserted == 0 {
+		return errors.New("correlation-id provided already exists")
+	}
+	return nil
+}
+
+// SetID sets an id without public key, eg. the token, storing its interactions
+// unencrypted. The interactions stored before a restart for the id are kept.
+func (s *SQLStorage) SetID(ID string) error {
+	_, err := s.db.Exec(s.rebind("INSERT INTO ids (id, expires_at) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET expires_at = excluded.expires_at"), ID, time.Now().Add(s.evictionTTL).UnixNano())
+	return errors.Wrap(err, "could not set id in database")
+}
+
+// HasID returns true if the correlation id or id is registered in the storage.
+func (s *SQLStorage) HasID(ID string) bool {
+	_, err := s.get(ID)
+	return err == nil
+}
+
+// insert stores a data item for an id applying the limit of pending
+// interactions, expiring it with the id
+func (s *SQLStorage) insert(ID string, row *sqlID, item string) error {
+	now := time.Now().UnixNano()
+	atomic.AddUint64(&s.addedCount, 1)
+	if s.limit.Max <= 0 {
+		_, err := s.db.Exec(s.rebind("INSERT INTO interactions (id, data, stored_at, expires_at) VALUES (?, ?, ?, ?)"), ID, item, now, row.expiresAt)
+		return errors.Wrap(err, "could not add interaction to database")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "could not add interaction to database")
+	}
+	defer tx.Rollback() //nolint
+
+	if _, err := tx.Exec(s.rebind("UPDATE ids SET seen = seen + 1 WHERE id = ?"), ID); err != nil {
+		return errors.Wrap(err, "could not add interaction to database")
+	}
+	var seen, pending int
+	if err := tx.QueryRow(s.rebind("SELECT seen FROM ids WHERE id = ?"), ID).Scan(&seen); err != nil {
+		return errors.Wrap(err, "could not add interaction to database")
+	}
+	if err := tx.QueryRow(s.rebind("SELECT COUNT(*) FROM interactions WHERE id = ? AND polled = 0"), ID).Scan(&pending); err != nil {
+		return errors.Wrap(err, "could not add interaction to database")
+	}
+	action, index := s.limit.action(pending, seen)
+	switch action {
+	case dropItem:
+		atomic.AddUint64(&s.droppedCount, 1)
+	case replaceItem:
+		_, err = tx.Exec(s.rebind("UPDATE interactions SET data = ?, stored_at = ? WHERE seq = (SELECT seq FROM interactions WHERE id = ? AND polled = 0 ORDER BY seq LIMIT 1 OFFSET ?)"), item, now, ID, index)
+		atomic.AddUint64(&s.droppedCount, 1)
+	case shiftItem:
+		_, err = tx.Exec(s.rebind("DELETE FROM interactions WHERE seq IN (SELECT seq FROM interactions WHERE id = ? AND polled = 0 ORDER BY seq LIMIT ?)"), ID, index)
+		atomic.AddUint64(&s.droppedCount, uint64(index))
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not add interaction to database")
+	}
+	if action == appendItem || action == shiftItem {
+		if _, err := tx.Exec(s.rebind("INSERT INTO interactions (id, data, stored_at, expires_at) VALUES (?, ?, ?, ?)"), ID, item, now, row.expiresAt); err != nil {
+			return errors.Wrap(err, "could not add interaction to database")
+		}
+	}
+	return errors.Wrap(tx.Commit(), "could not add interaction to database")
+}
+
+// AddInteraction adds an interaction data to the correlation ID after encrypting
+// it with Public Key for the provided correlation ID.
+func (s *SQLStorage) AddInteraction(correlationID string, data []byte) error {
+	row, err := s.get(correlationID)
+	if err != nil {
+		return errors.Wrap(err, "could not get correlation-id")
+	}
+	ct, err := aesEncryptRaw([]byte(row.rawKey), data)
+	if err != nil {
+		return errors.Wrap(err, "could not encrypt event data")
+	}
+	return s.insert(correlationID, row, ct)
+}
+
+// AddInteractionWithId adds an interaction data to the id bucket
+func (s *SQLStorage) AddInteractionWithId(id string, data []byte) error {
+	row, err := s.get(id)
+	if err != nil {
+		return errors.Wrap(err, "could not get id")
+	}
+	return s.insert(id, row, string(data))
+}
+
+// Encrypt encrypts data with the AES key of the correlation ID, returning the
+// base64 encoded ciphertext which can be decrypted like the interactions.
+func (s *SQLStorage) Encrypt(correlationID string, data []byte) (string, error) {
+	row, err := s.get(correlationID)
+	if err != nil {
+		return "", errors.New("could not get correlation-id from cache")
+	}
+	if row.rawKey == "" {
+		return "", errors.New("no encryption key for correlation-id")
+	}
+	return aesEncryptRaw([]byte(row.rawKey), data)
+}
+
+// GetAESKey returns the AES Encrypted Key for a correlationID.
+func (s *SQLStorage) GetAESKey(correlationID, secret string) (string, error) {
+	row, err := s.get(correlationID)
+	if err != nil {
+		return "", errors.New("could not get correlation-id from cache")
+	}
+	if !strings.EqualFold(row.secret, secret) {
+		return "", errors.New("invalid secret key passed for user")
+	}
+	return row.aesKey, nil
+}
+
+// poll returns the interactions of an id which haven't been polled yet, marking them as polled
+func (s *SQLStorage) poll(ID string) ([]string, []time.Time, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not get interactions from database")
+	}
+	defer tx.Rollback() //nolint
+
+	rows, err := tx.Query(s.rebind("SELECT seq, data, stored_at FROM interactions WHERE id = ? AND polled = 0 ORDER BY seq"), ID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not get interactions from database")
+	}
+	data := []string{}
+	var storedAt []time.Time
+	var last int64
+	for rows.Next() {
+		var item string
+		var nanoseconds int64
+		if err := rows.Scan(&last, &item, &nanoseconds); err != nil {
+			rows.Close()
+			return nil, nil, errors.Wrap(err, "could not read interaction")
+		}
+		data = append(data, item)
+		storedAt = append(storedAt, time.Unix(0, nanoseconds))
+	}
+	rows.Close()
+	if len(data) == 0 {
+		return data, nil, nil
+	}
+	if _, err := tx.Exec(s.rebind("UPDATE interactions SET polled = 1 WHERE id = ? AND polled = 0 AND seq <= ?"), ID, last); err != nil {
+		return nil, nil, errors.Wrap(err, "could not mark interactions as polled")
+	}
+	if _, err := tx.Exec(s.rebind("UPDATE ids SET seen = 0 WHERE id = ?"), ID); err != nil {
+		return nil, nil, errors.Wrap(err, "could not mark interactions as polled")
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, errors.Wrap(err, "could not mark interactions as polled")
+	}
+	atomic.AddUint64(&s.polledCount, uint64(len(data)))
+	return data, storedAt, nil
+}
+
+// GetInteractions returns the interactions for a correlationID which haven't
+// been polled yet, marking them as polled. It also returns AES Encrypted Key for the IDs.
+func (s *SQLStorage) GetInteractions(correlationID, secret string) ([]string, string, error) {
+	data, _, aesKey, err := s.GetInteractionsWithStoredAt(correlationID, secret)
+	return data, aesKey, err
+}
+
+// GetInteractionsWithStoredAt returns the interactions for a correlationID
+// like GetInteractions, along with the time each one was stored at.
+func (s *SQLStorage) GetInteractionsWithStoredAt(correlationID, secret string) ([]string, []time.Time, string, error) {
+	row, err := s.get(correlationID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if !strings.EqualFold(row.secret, secret) {
+		return nil, nil, "", errors.New("invalid secret key passed for user")
+	}
+	data, storedAt, err := s.poll(correlationID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return data, storedAt, row.aesKey, nil
+}
+
+// GetExpiry returns the time a registered correlation-id is evicted at.
+func (s *SQLStorage) GetExpiry(correlationID string) (time.Time, bool) {
+	row, err := s.get(correlationID)
+	if err != nil || row.rawKey == "" {
+		return time.Time{}, false
+	}
+	return time.Unix(0, row.expiresAt), true
+}
+
+// GetInteractionsWithId returns the interactions for a id which haven't been polled yet
+func (s *SQLStorage) GetInteractionsWithId(id string) ([]string, error) {
+	if _, err := s.get(id); err != nil {
+		return nil, errors.New("could not get id from cache")
+	}
+	data, _, err := s.poll(id)
+	return data, err
+}
+
+// remove deletes an id and its interactions, only if it has expired if expiredOnly is set
+func (s *SQLStorage) remove(ID string, expiredOnly bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "could not remove id from database")
+	}
+	defer tx.Rollback() //nolint
+
+	var result sql.Result
+	if expiredOnly {
+		result, err = tx.Exec(s.rebind("DELETE FROM ids WHERE id = ? AND expires_at <= ?"), ID, time.Now().UnixNano())
+	} else {
+		result, err = tx.Exec(s.rebind("DELETE FROM ids WHERE id = ?"), ID)
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not remove id from database")
+	}
+	if removed, err := result.RowsAffected(); err == nil && removed > 0 {
+		if _, err := tx.Exec(s.rebind("DELETE FROM interactions WHERE id = ?"), ID); err != nil {
+			return errors.Wrap(err, "could not remove interactions from database")
+		}
+	}
+	return errors.Wrap(tx.Commit(), "could not remove id from database")
+}
+
+// RemoveID removes data for a correlation ID and data related to it.
+func (s *SQLStorage) RemoveID(correlationID, secret string) error {
+	row, err := s.get(correlationID)
+	if err != nil {
+		return errors.New("could not get correlation-id from cache")
+	}
+	if !strings.EqualFold(row.secret, secret) {
+		return errors.New("invalid secret key passed for deregister")
+	}
+	return s.remove(correlationID, false)
+}
+
+// RemoveSession removes a session registered with a public key without
+// requiring its secret, eg. to deregister it from the admin api.
+func (s *SQLStorage) RemoveSession(correlationID string) error {
+	row, err := s.get(correlationID)
+	if err != nil || row.rawKey == "" {
+		return ErrCorrelationIDNotFound
+	}
+	return s.remove(correlationID, false)
+}
+
+// GetSession returns the state of a session registered with a public key.
+func (s *SQLStorage) GetSession(correlationID string) (SessionInfo, bool) {
+	expiresAt, ok := s.GetExpiry(correlationID)
+	if !ok {
+		return SessionInfo{}, false
+	}
+	var pending int
+	_ = s.db.QueryRow(s.rebind("SELECT COUNT(*) FROM interactions WHERE id = ? AND polled = 0"), correlationID).Scan(&pending)
+	return SessionInfo{CorrelationID: correlationID, ExpiresAt: expiresAt, Pending: pending}, true
+}
+
+// ListSessions returns the state of all the sessions registered with
+// a public key sorted by correlation-id.
+func (s *SQLStorage) ListSessions() []SessionInfo {
+	rows, err := s.db.Query(s.rebind(`SELECT ids.id, ids.expires_at, COUNT(interactions.seq) FROM ids
+		LEFT JOIN interactions ON interactions.id = ids.id AND interactions.polled = 0
+		WHERE ids.raw_key <> '' AND ids.expires_at > ? GROUP BY ids.id, ids.expires_at ORDER BY ids.id`), time.Now().UnixNano())
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var sessions []SessionInfo
+	for rows.Next() {
+		var session SessionInfo
+		var expiresAt int64
+		if err := rows.Scan(&session.CorrelationID, &expiresAt, &session.Pending); err != nil {
+			return sessions
+		}
+		session.ExpiresAt = time.Unix(0, expiresAt)
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// ExportSession returns the registration of a session with its secret and AES keys.
+func (s *SQLStorage) ExportSession(correlationID string) (SessionExport, bool) {
+	row, err := s.get(correlationID)
+	if err != nil || row.rawKey == "" {
+		return SessionExport{}, false
+	}
+	return SessionExport{
+		CorrelationID: correlationID,
+		SecretKey:     row.secret,
+		AESKey:        row.aesKey,
+		RawAESKey:     []byte(row.rawKey),
+		ExpiresAt:     time.Unix(0, row.expiresAt),
+	}, true
+}
+
+// ImportSession registers a session exported by another server.
+func (s *SQLStorage) ImportSession(session SessionExport) error {
+	if err := session.validate(); err != nil {
+		return err
+	}
+	return s.setSession(session.CorrelationID, session.SecretKey, string(session.RawAESKey), session.AESKey, session.ExpiresAt)
+}
+
+// GetCacheMetrics returns the hits, misses and evictions of the instance
+// along with the number of ids and pending interactions in the database
+// and its size.
+func (s *SQLStorage) GetCacheMetrics() *CacheMetrics {
+	now := time.Now().UnixNano()
+	metrics := &CacheMetrics{
+		HitCount:      atomic.LoadUint64(&s.hitCount),
+		MissCount:     atomic.LoadUint64(&s.missCount),
+		EvictionCount: atomic.LoadUint64(&s.evictionCount),
+		DroppedCount:  atomic.LoadUint64(&s.droppedCount),
+		AddedCount:    atomic.LoadUint64(&s.addedCount),
+		PolledCount:   atomic.LoadUint64(&s.polledCount),
+	}
+	_ = s.db.QueryRow(s.rebind("SELECT COUNT(*), COALESCE(SUM(CASE WHEN aes_key != '' THEN 1 ELSE 0 END), 0) FROM ids WHERE expires_at > ?"), now).Scan(&metrics.Entries, &metrics.Sessions)
+	_ = s.db.QueryRow(s.rebind("SELECT COUNT(*), COALESCE(SUM(LENGTH(data)), 0) FROM interactions WHERE polled = 0 AND expires_at > ?"), now).Scan(&metrics.Pending, &metrics.PendingBytes)
+	size := "SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()"
+	if s.postgres {
+		size = "SELECT pg_database_size(current_database())"
+	}
+	_ = s.db.QueryRow(size).Scan(&metrics.UsedBytes)
+	return metrics
+}
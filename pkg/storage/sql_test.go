@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLStorage(t *testing.T) {
+	url := "sqlite://" + filepath.Join(t.TempDir(), "interactsh.db")
+	storage, err := NewSQL(url, time.Hour)
+	require.Nil(t, err, "could not create sql storage")
+
+	_, encoded := newTestKey(t)
+
+	require.Nil(t, storage.SetIDPublicKey("c1", "secret", encoded), "could not set correlation-id")
+	require.NotNil(t, storage.SetIDPublicKey("c1", "secret", encoded), "could set correlation-id twice")
+	require.Nil(t, storage.AddInteraction("c1", []byte("hello world")), "could not add interaction")
+	require.Nil(t, storage.SetID("token"), "could not set token")
+	require.Nil(t, storage.AddInteractionWithId("token", []byte("plaintext")), "could not add interaction with id")
+	require.Nil(t, storage.Close(), "could not close sql storage")
+
+	// the ids and interactions survive the restarts
+	storage, err = NewSQL(url, time.Hour)
+	require.Nil(t, err, "could not reopen sql storage")
+	defer storage.Close()
+	require.True(t, storage.HasID("c1"), "could not persist correlation-id")
+	sessions := storage.ListSessions()
+	require.Len(t, sessions, 1, "could not list sessions")
+	require.Equal(t, 1, sessions[0].Pending, "invalid pending interactions")
+	metrics := storage.GetCacheMetrics()
+	require.Equal(t, int64(2), metrics.Entries, "invalid entries")
+	require.Equal(t, int64(1), metrics.Sessions, "invalid sessions")
+	require.Equal(t, int64(2), metrics.Pending, "invalid pending interactions")
+	require.Greater(t, metrics.UsedBytes, int64(0), "invalid database size")
+
+	data, storedAt, key, err := storage.GetInteractionsWithStoredAt("c1", "secret")
+	require.Nil(t, err, "could not get interactions")
+	require.Len(t, data, 1, "could not get interaction")
+	require.Len(t, storedAt, 1, "could not get interaction storage time")
+	require.NotEmpty(t, key, "could not get aes key")
+	data, _, err = storage.GetInteractions("c1", "secret")
+	require.Nil(t, err, "could not get interactions")
+	require.Empty(t, data, "could get interactions twice")
+	_, _, err = storage.GetInteractions("c1", "invalid")
+	require.NotNil(t, err, "could get interactions with invalid secret")
+
+	data, err = storage.GetInteractionsWithId("token")
+	require.Nil(t, err, "could not get interactions with id")
+	require.Equal(t, []string{"plaintext"}, data, "invalid interactions with id")
+	require.Equal(t, uint64(2), storage.GetCacheMetrics().PolledCount, "invalid polled interactions")
+
+	require.Nil(t, storage.evict(time.Now().Add(2*time.Hour)), "could not evict")
+	require.False(t, storage.HasID("c1"), "could not evict correlation-id")
+	require.Equal(t, uint64(2), storage.GetCacheMetrics().EvictionCount, "invalid eviction count")
+	require.Nil(t, storage.SetIDPublicKey("c1", "secret", encoded), "could not register evicted correlation-id")
+	require.Nil(t, storage.RemoveSession("c1"), "could not remove session")
+	require.Equal(t, ErrCorrelationIDNotFound, storage.RemoveSession("c1"), "could remove session twice")
+}
+
+func TestSQLStorageRebind(t *testing.T) {
+	require.Equal(t, "SELECT ? FROM ids WHERE id = ?", (&SQLStorage{}).rebind("SELECT ? FROM ids WHERE id = ?"), "rebound sqlite query")
+	require.Equal(t, "SELECT $1 FROM ids WHERE id = $2", (&SQLStorage{postgres: true}).rebind("SELECT ? FROM ids WHERE id = ?"), "could not rebind postgres query")
+}
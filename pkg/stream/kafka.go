@@ -0,0 +1,50 @@
+package stream
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaPublisher publishes the messages to a kafka topic, acknowledged by
+// all the in-sync replicas of their partition.
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+// NewKafka returns a publisher to the topic of the kafka brokers (host:port),
+// the messages of a payload being hashed to the same partition.
+func NewKafka(brokers []string, topic string) (Publisher, error) {
+	if len(brokers) == 0 {
+		return nil, errors.New("no kafka brokers specified")
+	}
+	if topic == "" {
+		return nil, errors.New("no kafka topic specified")
+	}
+	return &kafkaPublisher{writer: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchSize:    maxBatchSize,
+		BatchTimeout: 10 * time.Millisecond,
+		// the failed batches are retried by the exporter
+		MaxAttempts: 1,
+	}}, nil
+}
+
+// Publish writes the messages to the topic.
+func (p *kafkaPublisher) Publish(ctx context.Context, messages []Message) error {
+	records := make([]kafka.Message, len(messages))
+	for i, message := range messages {
+		records[i] = kafka.Message{Key: message.Key, Value: message.Value}
+	}
+	return p.writer.WriteMessages(ctx, records...)
+}
+
+// Close closes the connections to the brokers.
+func (p *kafkaPublisher) Close() error {
+	return p.writer.Close()
+}
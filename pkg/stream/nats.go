@@ -0,0 +1,77 @@
+package stream
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// natsPublisher publishes the messages to a nats subject, acknowledged by
+// the server or by the jetstream stream of the subject.
+type natsPublisher struct {
+	conn      *nats.Conn
+	jetstream nats.JetStreamContext
+	subject   string
+}
+
+// NewNATS returns a publisher to the subject of a nats server (nats://[user:password@|token@]host:port),
+// waiting for the acknowledgements of the jetstream stream capturing the subject if enabled.
+func NewNATS(url, subject string, jetstream bool) (Publisher, error) {
+	if subject == "" {
+		return nil, errors.New("no nats subject specified")
+	}
+	// the messages are buffered by the exporter rather than by the
+	// connection while reconnecting, so that their delivery is confirmed
+	conn, err := nats.Connect(url, nats.Name("interactsh-client"), nats.MaxReconnects(-1), nats.RetryOnFailedConnect(true), nats.ReconnectBufSize(-1))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not connect to nats")
+	}
+	publisher := &natsPublisher{conn: conn, subject: subject}
+	if jetstream {
+		if publisher.jetstream, err = conn.JetStream(); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "could not get jetstream context")
+		}
+	}
+	return publisher, nil
+}
+
+// Publish publishes the messages, the jetstream messages being deduplicated
+// by the hash of their value when published again.
+func (p *natsPublisher) Publish(ctx context.Context, messages []Message) error {
+	if p.jetstream == nil {
+		for _, message := range messages {
+			if err := p.conn.Publish(p.subject, message.Value); err != nil {
+				return err
+			}
+		}
+		return p.conn.FlushWithContext(ctx)
+	}
+	futures := make([]nats.PubAckFuture, 0, len(messages))
+	for _, message := range messages {
+		hash := sha256.Sum256(message.Value)
+		future, err := p.jetstream.PublishMsgAsync(&nats.Msg{Subject: p.subject, Data: message.Value}, nats.MsgId(hex.EncodeToString(hash[:])))
+		if err != nil {
+			return err
+		}
+		futures = append(futures, future)
+	}
+	for _, future := range futures {
+		select {
+		case <-future.Ok():
+		case err := <-future.Err():
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes the connection.
+func (p *natsPublisher) Close() error {
+	return p.conn.Drain()
+}
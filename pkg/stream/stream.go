@@ -0,0 +1,183 @@
+// Package stream publishes the interactions received by the interactsh
+// client to a message bus (kafka or nats) for the detection pipelines,
+// with at-least-once delivery.
+package stream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+	"github.com/projectdiscovery/interactsh/pkg/server"
+)
+
+const (
+	// maxBatchSize is the number of messages published at once
+	maxBatchSize = 100
+	// maxPending is the number of messages buffered while the broker is
+	// unavailable, the following ones being dropped
+	maxPending = 100000
+	// publishTimeout is the time the broker has to acknowledge a batch
+	publishTimeout = 10 * time.Second
+	// closeTimeout is the time the buffered messages are published for on close
+	closeTimeout = 10 * time.Second
+	// minRetryWait and maxRetryWait bound the exponential backoff of the retries
+	minRetryWait = time.Second
+	maxRetryWait = 30 * time.Second
+)
+
+// Message is a message published to the message bus.
+type Message struct {
+	// Key is the key of the message (the unique id of the interaction)
+	Key []byte
+	// Value is the json encoded interaction
+	Value []byte
+}
+
+// Publisher publishes the batches of messages to a message bus, returning
+// once the broker acknowledged all of them.
+type Publisher interface {
+	Publish(ctx context.Context, messages []Message) error
+	Close() error
+}
+
+// Exporter buffers the interactions in memory and publishes them in order
+// in the background, retrying the failed batches with an exponential
+// backoff until the broker acknowledges them. A batch may be published
+// more than once if its acknowledgement is lost.
+type Exporter struct {
+	name      string
+	publisher Publisher
+
+	sync.Mutex
+	pending []Message
+	dropped int
+
+	wake      chan struct{}
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewExporter returns an exporter publishing with a publisher, the name of the
+// message bus being used in the logs.
+func NewExporter(name string, publisher Publisher) *Exporter {
+	exporter := &Exporter{
+		name:      name,
+		publisher: publisher,
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go exporter.loop()
+	return exporter
+}
+
+// Export queues an interaction for publishing.
+func (e *Exporter) Export(interaction *server.Interaction) error {
+	value, err := jsoniter.Marshal(interaction)
+	if err != nil {
+		return errors.Wrap(err, "could not encode interaction")
+	}
+
+	e.Lock()
+	if len(e.pending) >= maxPending {
+		e.dropped++
+		e.Unlock()
+		return errors.Errorf("%s buffer is full", e.name)
+	}
+	e.pending = append(e.pending, Message{Key: []byte(interaction.UniqueID), Value: value})
+	e.Unlock()
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close publishes the buffered interactions for up to 10 seconds and closes
+// the publisher.
+func (e *Exporter) Close() {
+	e.closeOnce.Do(func() { close(e.done) })
+	<-e.stopped
+}
+
+// loop publishes the buffered messages until the exporter is closed
+func (e *Exporter) loop() {
+	defer close(e.stopped)
+	defer func() {
+		if err := e.publisher.Close(); err != nil {
+			gologger.Warning().Msgf("Could not close %s publisher: %s\n", e.name, err)
+		}
+	}()
+
+	var deadline time.Time
+	wait := minRetryWait
+	unavailable := false
+	for {
+		e.Lock()
+		if e.dropped > 0 {
+			gologger.Error().Msgf("Dropped %d interactions while %s was unavailable\n", e.dropped, e.name)
+			e.dropped = 0
+		}
+		batch := e.pending
+		if len(batch) > maxBatchSize {
+			batch = batch[:maxBatchSize]
+		}
+		e.Unlock()
+
+		if len(batch) == 0 {
+			if !deadline.IsZero() {
+				return
+			}
+			select {
+			case <-e.wake:
+			case <-e.done:
+				deadline = time.Now().Add(closeTimeout)
+			}
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		err := e.publisher.Publish(ctx, batch)
+		cancel()
+		if err == nil {
+			e.Lock()
+			e.pending = e.pending[len(batch):]
+			e.Unlock()
+			if unavailable {
+				gologger.Info().Msgf("Resumed publishing the interactions to %s\n", e.name)
+				unavailable = false
+			}
+			wait = minRetryWait
+			continue
+		}
+
+		if !unavailable {
+			gologger.Warning().Msgf("Could not publish interactions to %s, buffering them: %s\n", e.name, err)
+			unavailable = true
+		}
+		if !deadline.IsZero() && time.Now().Add(wait).After(deadline) {
+			e.Lock()
+			gologger.Error().Msgf("Could not publish %d buffered interactions to %s before closing\n", len(e.pending), e.name)
+			e.Unlock()
+			return
+		}
+		if deadline.IsZero() {
+			select {
+			case <-time.After(wait):
+			case <-e.done:
+				deadline = time.Now().Add(closeTimeout)
+			}
+		} else {
+			time.Sleep(wait)
+		}
+		if wait *= 2; wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/interactsh/pkg/server"
+	"github.com/stretchr/testify/require"
+)
+
+// testPublisher fails the first publishes and records the published messages
+type testPublisher struct {
+	sync.Mutex
+	failures  int
+	attempts  int
+	published []Message
+	closed    bool
+}
+
+func (p *testPublisher) Publish(ctx context.Context, messages []Message) error {
+	p.Lock()
+	defer p.Unlock()
+	p.attempts++
+	if p.attempts <= p.failures {
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, messages...)
+	return nil
+}
+
+func (p *testPublisher) Close() error {
+	p.Lock()
+	defer p.Unlock()
+	p.closed = true
+	return nil
+}
+
+func TestExporter(t *testing.T) {
+	publisher := &testPublisher{failures: 1}
+	exporter := NewExporter("test", publisher)
+	for i := 0; i < 250; i++ {
+		interaction := &server.Interaction{Protocol: "dns", UniqueID: "c23b2la0kl1krjcrdj10" + strconv.Itoa(i), Timestamp: time.Now()}
+		require.Nil(t, exporter.Export(interaction), "could not export interaction")
+	}
+	exporter.Close()
+
+	publisher.Lock()
+	defer publisher.Unlock()
+	require.True(t, publisher.closed, "could not close publisher")
+	require.Greater(t, publisher.attempts, 1, "could not retry failed batch")
+	require.Len(t, publisher.published, 250, "could not publish buffered interactions")
+	for i, message := range publisher.published {
+		require.Equal(t, "c23b2la0kl1krjcrdj10"+strconv.Itoa(i), string(message.Key), "could not publish interactions in order")
+		require.Contains(t, string(message.Value), `"protocol":"dns"`, "could not encode interaction")
+	}
+}
+
+func TestExporterUnavailable(t *testing.T) {
+	publisher := &testPublisher{failures: 1000}
+	exporter := NewExporter("test", publisher)
+	require.Nil(t, exporter.Export(&server.Interaction{Protocol: "http", UniqueID: "c23b2la0kl1krjcrdj10"}), "could not export interaction")
+
+	start := time.Now()
+	exporter.Close()
+	require.Less(t, int64(time.Since(start)), int64(closeTimeout+time.Second), "could not give up publishing on close")
+	require.Empty(t, publisher.published, "published interaction to unavailable broker")
+}
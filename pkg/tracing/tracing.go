@@ -0,0 +1,47 @@
+// Package tracing configures opentelemetry tracing with an
+// otlp exporter for the interactsh server.
+package tracing
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// serviceName is the name of the service reported with the spans
+const serviceName = "interactsh-server"
+
+// New configures the global tracer provider to export spans to the otlp/http
+// collector endpoint (eg. http://127.0.0.1:4318), returning a function
+// flushing the pending spans on shutdown.
+func New(endpoint string) (func(context.Context) error, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Host == "" {
+		return nil, errors.Errorf("invalid otlp endpoint %s", endpoint)
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpointURL.Host)}
+	if endpointURL.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if endpointURL.Path != "" && endpointURL.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(endpointURL.Path))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create otlp exporter")
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
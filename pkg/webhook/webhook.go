@@ -0,0 +1,426 @@
+// Package webhook implements templated webhook delivery
+// of interactsh interactions to external services.
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/goburrow/cache"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	"github.com/projectdiscovery/gologger"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultQueueSize is the number of pending deliveries kept per webhook
+const defaultQueueSize = 1000
+
+// maxFirstOnlyKeys is the number of first-only keys remembered per webhook,
+// the least recently seen ones being forgotten first
+const maxFirstOnlyKeys = 100000
+
+const (
+	// defaultRetryWait is the time waited before the first retry of a failed
+	// delivery, doubled for each following retry
+	defaultRetryWait = time.Second
+	// maxRetryWait is the maximum time waited before retrying a delivery
+	maxRetryWait = 30 * time.Second
+)
+
+// firstOnlyKeys are the predefined keys supported by the first-only option
+var firstOnlyKeys = map[string]string{
+	// payload alerts once for each full interaction id (including any payload tag prefix)
+	"payload": "{{.FullId}}",
+	// protocol alerts once for each protocol of a session, keyed on the correlation id
+	// resolved by the server or the client (the unique id for the interactions of no session)
+	"protocol": "{{or .CorrelationID .UniqueID}}:{{.Protocol}}",
+}
+
+// Options contains configuration options for a webhook.
+type Options struct {
+	// Name is an optional name used in logs for the webhook.
+	Name string `yaml:"name"`
+	// URL is the endpoint the interactions are delivered to.
+	URL string `yaml:"url"`
+	// Method is the http method used for delivery (POST by default).
+	Method string `yaml:"method"`
+	// Headers are additional headers sent with each delivery.
+	Headers map[string]string `yaml:"headers"`
+	// Template is a go template for the delivery body. The interaction
+	// is encoded as JSON if no template is specified.
+	Template string `yaml:"template"`
+	// TemplateFile is a file containing the go template for the delivery body.
+	TemplateFile string `yaml:"template-file"`
+	// Filter is a go template evaluated for each interaction, the interaction
+	// is only delivered if it renders to "true" (eg. {{eq .Protocol "http"}}).
+	Filter string `yaml:"filter"`
+	// FirstOnly only delivers the first interaction for each key, the key is
+	// either "payload", "protocol" or a go template rendering the key. The
+	// "protocol" key requires the interactions to have a CorrelationID field.
+	FirstOnly string `yaml:"first-only"`
+	// Retries is the number of times a delivery failing with a network error,
+	// a 429 or a 5xx status code is retried with an exponential backoff.
+	Retries int `yaml:"retries"`
+}
+
+// Webhook is a webhook delivering interactions to an endpoint.
+type Webhook struct {
+	options   *Options
+	body      *template.Template
+	filter    *template.Template
+	firstOnly *template.Template
+	seenMutex sync.Mutex
+	seen      cache.Cache
+	// handedOver is true once the seen keys are used by the webhook replacing this one
+	handedOver bool
+	httpClient *http.Client
+	queue      chan delivery
+	done       chan struct{}
+	stopped    chan struct{}
+	closeOnce  sync.Once
+	retryWait  time.Duration
+	// delivered is called with the time the delivered bodies were queued for
+	delivered func(lag time.Duration)
+}
+
+// delivery is a body queued for delivery
+type delivery struct {
+	body     []byte
+	queuedAt time.Time
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := jsoniter.Marshal(v)
+		return string(data), err
+	},
+	"contains":  strings.Contains,
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"truncate": func(length int, value string) string {
+		if len(value) > length {
+			return value[:length]
+		}
+		return value
+	},
+}
+
+// New creates a new webhook from the options.
+func New(options *Options) (*Webhook, error) {
+	if options.URL == "" {
+		return nil, errors.New("no url specified for webhook")
+	}
+	if options.Method == "" {
+		options.Method = http.MethodPost
+	}
+	if options.Name == "" {
+		options.Name = options.URL
+	}
+	webhook := &Webhook{
+		options:    options,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		queue:      make(chan delivery, defaultQueueSize),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		retryWait:  defaultRetryWait,
+	}
+
+	body := options.Template
+	if options.TemplateFile != "" {
+		data, err := ioutil.ReadFile(options.TemplateFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read template file")
+		}
+		body = string(data)
+	}
+	if body != "" {
+		tpl, err := template.New("body").Funcs(templateFuncs).Parse(body)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not parse body template")
+		}
+		webhook.body = tpl
+	}
+	if options.Filter != "" {
+		tpl, err := template.New("filter").Funcs(templateFuncs).Parse(options.Filter)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not parse filter template")
+		}
+		webhook.filter = tpl
+	}
+	if options.FirstOnly != "" {
+		key := options.FirstOnly
+		if predefined, ok := firstOnlyKeys[key]; ok {
+			key = predefined
+		}
+		tpl, err := template.New("first-only").Funcs(templateFuncs).Parse(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not parse first-only template")
+		}
+		webhook.firstOnly = tpl
+		webhook.seen = cache.New(cache.WithMaximumSize(maxFirstOnlyKeys))
+	}
+
+	go webhook.deliver()
+	return webhook, nil
+}
+
+// ParseConfig parses a YAML file containing a list of webhooks.
+func ParseConfig(file string) ([]*Webhook, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open webhook config")
+	}
+	defer f.Close()
+
+	var options []*Options
+	if err := yaml.NewDecoder(f).Decode(&options); err != nil {
+		return nil, errors.Wrap(err, "could not decode webhook config")
+	}
+	webhooks := make([]*Webhook, 0, len(options))
+	for _, option := range options {
+		webhook, err := New(option)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+// Match returns true if the interaction passes the webhook filter.
+func (w *Webhook) Match(interaction interface{}) (bool, error) {
+	if w.filter == nil {
+		return true, nil
+	}
+	buffer := &bytes.Buffer{}
+	if err := w.filter.Execute(buffer, interaction); err != nil {
+		return false, errors.Wrap(err, "could not execute filter template")
+	}
+	return strings.TrimSpace(buffer.String()) == "true", nil
+}
+
+// First returns true if the interaction is the first one for its first-only key,
+// marking the key as seen. It always returns true if first-only isn't enabled.
+func (w *Webhook) First(interaction interface{}) (bool, error) {
+	if w.firstOnly == nil {
+		return true, nil
+	}
+	buffer := &bytes.Buffer{}
+	if err := w.firstOnly.Execute(buffer, interaction); err != nil {
+		return false, errors.Wrap(err, "could not execute first-only template")
+	}
+	key := buffer.String()
+
+	w.seenMutex.Lock()
+	defer w.seenMutex.Unlock()
+	if _, ok := w.seen.GetIfPresent(key); ok {
+		return false, nil
+	}
+	w.seen.Put(key, struct{}{})
+	return true, nil
+}
+
+// Render returns the delivery body for the interaction.
+func (w *Webhook) Render(interaction interface{}) ([]byte, error) {
+	if w.body == nil {
+		return jsoniter.Marshal(interaction)
+	}
+	buffer := &bytes.Buffer{}
+	if err := w.body.Execute(buffer, interaction); err != nil {
+		return nil, errors.Wrap(err, "could not execute body template")
+	}
+	return buffer.Bytes(), nil
+}
+
+// Send queues the interaction for delivery if it matches the filter
+// and is the first one for its key when first-only is enabled.
+//
+// Deliveries happen in the background, if the queue is full the
+// interaction is dropped so that slow endpoints don't block callers.
+func (w *Webhook) Send(interaction interface{}) error {
+	matched, err := w.Match(interaction)
+	if err != nil || !matched {
+		return err
+	}
+	first, err := w.First(interaction)
+	if err != nil || !first {
+		return err
+	}
+	body, err := w.Render(interaction)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-w.done:
+		return nil
+	default:
+	}
+	select {
+	case w.queue <- delivery{body: body, queuedAt: time.Now()}:
+		return nil
+	default:
+		return fmt.Errorf("delivery queue is full for %s", w.options.Name)
+	}
+}
+
+// SetDeliveryObserver sets the function called with the time the interactions
+// were queued for once they have been delivered. It must be set before any
+// interaction is sent.
+func (w *Webhook) SetDeliveryObserver(delivered func(lag time.Duration)) {
+	w.delivered = delivered
+}
+
+// Inherit makes the webhook continue the first-only keys seen by a previous
+// webhook of the same configuration, eg. across the reloads of the config,
+// returning false if the configurations differ.
+func (w *Webhook) Inherit(previous *Webhook) bool {
+	if w.seen == nil || previous.seen == nil || !reflect.DeepEqual(*w.options, *previous.options) {
+		return false
+	}
+	previous.seenMutex.Lock()
+	defer previous.seenMutex.Unlock()
+	if previous.handedOver {
+		return false
+	}
+	previous.handedOver = true
+
+	w.seenMutex.Lock()
+	defer w.seenMutex.Unlock()
+	_ = w.seen.Close()
+	w.seen = previous.seen
+	return true
+}
+
+// Close stops the webhook once the queued interactions have been delivered,
+// without retrying them, waiting for their delivery. Interactions sent after
+// the webhook has been closed are discarded.
+func (w *Webhook) Close() {
+	w.closeOnce.Do(func() {
+		close(w.done)
+		<-w.stopped
+		if w.seen != nil {
+			w.seenMutex.Lock()
+			if !w.handedOver {
+				_ = w.seen.Close()
+			}
+			w.seenMutex.Unlock()
+		}
+	})
+}
+
+// deliver delivers the queued bodies to the webhook endpoint, draining the
+// queue once the webhook is closed
+func (w *Webhook) deliver() {
+	defer close(w.stopped)
+	for {
+		select {
+		case <-w.done:
+			for {
+				select {
+				case delivery := <-w.queue:
+					w.deliverBody(delivery)
+				default:
+					return
+				}
+			}
+		case delivery := <-w.queue:
+			w.deliverBody(delivery)
+		}
+	}
+}
+
+// deliverBody delivers a queued body, reporting its delivery lag
+func (w *Webhook) deliverBody(delivery delivery) {
+	if err := w.send(delivery.body); err != nil {
+		gologger.Warning().Msgf("Could not deliver webhook to %s: %s\n", w.options.Name, err)
+	} else if w.delivered != nil {
+		w.delivered(time.Since(delivery.queuedAt))
+	}
+}
+
+// send posts a body to the webhook endpoint, retrying the retryable failures
+// with an exponential backoff (or the Retry-After of the endpoint) until the
+// webhook is closed.
+func (w *Webhook) send(body []byte) error {
+	wait := w.retryWait
+	for attempt := 0; ; attempt++ {
+		err := w.post(body)
+		if err == nil || attempt >= w.options.Retries || !retryable(err) {
+			return err
+		}
+		delay := wait
+		if status, ok := err.(*statusError); ok && status.retryAfter > 0 {
+			delay = status.retryAfter
+		}
+		if delay > maxRetryWait {
+			delay = maxRetryWait
+		}
+		gologger.Debug().Msgf("Could not deliver webhook to %s: %s, retrying in %s\n", w.options.Name, err, delay)
+		select {
+		case <-w.done:
+			return err
+		case <-time.After(delay):
+		}
+		wait *= 2
+	}
+}
+
+// statusError is the error of a delivery rejected by the webhook endpoint
+type statusError struct {
+	code       int
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", e.code)
+}
+
+// retryable returns true for the network errors and the status codes of
+// the deliveries which may succeed later
+func retryable(err error) bool {
+	if status, ok := err.(*statusError); ok {
+		return status.code == http.StatusTooManyRequests || status.code >= 500
+	}
+	return true
+}
+
+func (w *Webhook) post(body []byte) error {
+	req, err := http.NewRequest(w.options.Method, w.options.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "could not create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.options.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not make request")
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		status := &statusError{code: resp.StatusCode}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			status.retryAfter = time.Duration(seconds) * time.Second
+		}
+		return status
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goburrow/cache"
+	"github.com/stretchr/testify/require"
+)
+
+type testInteraction struct {
+	Protocol      string
+	UniqueID      string
+	FullId        string
+	RemoteAddress string
+	CorrelationID string
+}
+
+func TestWebhookSend(t *testing.T) {
+	received := make(chan string, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		received <- r.Header.Get("X-Test") + " " + string(data)
+	}))
+	defer ts.Close()
+
+	webhook, err := New(&Options{
+		URL:      ts.URL,
+		Headers:  map[string]string{"X-Test": "header"},
+		Template: `{"text": "{{upper .Protocol}} from {{.RemoteAddress}}"}`,
+		Filter:   `{{eq .Protocol "http"}}`,
+	})
+	require.Nil(t, err, "could not create webhook")
+	delivered := make(chan time.Duration, 2)
+	webhook.SetDeliveryObserver(func(lag time.Duration) { delivered <- lag })
+
+	err = webhook.Send(&testInteraction{Protocol: "dns", RemoteAddress: "127.0.0.1"})
+	require.Nil(t, err, "could not send filtered interaction")
+	err = webhook.Send(&testInteraction{Protocol: "http", RemoteAddress: "127.0.0.2"})
+	require.Nil(t, err, "could not send interaction")
+
+	select {
+	case body := <-received:
+		require.Equal(t, `header {"text": "HTTP from 127.0.0.2"}`, body, "could not get correct webhook body")
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "webhook was not delivered")
+	}
+	select {
+	case lag := <-delivered:
+		require.True(t, lag > 0 && lag < 5*time.Second, "could not get delivery lag")
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "webhook delivery was not observed")
+	}
+	require.Len(t, received, 0, "filtered interaction was delivered")
+}
+
+func TestWebhookRenderJSON(t *testing.T) {
+	webhook, err := New(&Options{URL: "http://127.0.0.1"})
+	require.Nil(t, err, "could not create webhook")
+
+	body, err := webhook.Render(&testInteraction{Protocol: "smtp"})
+	require.Nil(t, err, "could not render interaction")
+	require.JSONEq(t, `{"Protocol": "smtp", "UniqueID": "", "FullId": "", "RemoteAddress": "", "CorrelationID": ""}`, string(body), "could not get correct json body")
+}
+
+func TestWebhookFirstOnly(t *testing.T) {
+	webhook, err := New(&Options{URL: "http://127.0.0.1", FirstOnly: "protocol"})
+	require.Nil(t, err, "could not create webhook")
+
+	id := "c23b2la0kl1krjcrdj10cndmnioyyyyyn"
+	for _, test := range []struct {
+		interaction *testInteraction
+		first       bool
+	}{
+		{&testInteraction{Protocol: "dns", UniqueID: id, CorrelationID: id[:20]}, true},
+		{&testInteraction{Protocol: "dns", UniqueID: id, CorrelationID: id[:20]}, false},
+		{&testInteraction{Protocol: "http", UniqueID: id, CorrelationID: id[:20]}, true},
+		{&testInteraction{Protocol: "dns", UniqueID: "c23b2la0kl1krjcrdj11cndmnioyyyyyn", CorrelationID: "c23b2la0kl1krjcrdj11"}, true},
+		// the interactions of no session are keyed on their unique id
+		{&testInteraction{Protocol: "dns", UniqueID: "cndmnioyyyyyn"}, true},
+		{&testInteraction{Protocol: "dns", UniqueID: "cndmnioyyyyyn"}, false},
+	} {
+		first, err := webhook.First(test.interaction)
+		require.Nil(t, err, "could not check first interaction")
+		require.Equal(t, test.first, first, "could not get correct first-only result")
+	}
+}
+
+func TestWebhookFirstOnlyIDLength(t *testing.T) {
+	webhook, err := New(&Options{URL: "http://127.0.0.1", FirstOnly: "protocol"})
+	require.Nil(t, err, "could not create webhook")
+
+	// 8 characters correlation ids of the random scheme sharing their first characters
+	for _, test := range []struct {
+		interaction *testInteraction
+		first       bool
+	}{
+		{&testInteraction{Protocol: "dns", UniqueID: "abcd1234nonce", CorrelationID: "abcd1234"}, true},
+		{&testInteraction{Protocol: "dns", UniqueID: "abcd1234other", CorrelationID: "abcd1234"}, false},
+		{&testInteraction{Protocol: "dns", UniqueID: "abcd5678nonce", CorrelationID: "abcd5678"}, true},
+	} {
+		first, err := webhook.First(test.interaction)
+		require.Nil(t, err, "could not check first interaction")
+		require.Equal(t, test.first, first, "could not get correct first-only result")
+	}
+}
+
+func TestWebhookFirstOnlyBounded(t *testing.T) {
+	webhook, err := New(&Options{URL: "http://127.0.0.1", FirstOnly: "payload"})
+	require.Nil(t, err, "could not create webhook")
+	defer webhook.Close()
+	_ = webhook.seen.Close()
+	webhook.seen = cache.New(cache.WithMaximumSize(2))
+
+	for _, id := range []string{"a", "b", "c"} {
+		first, err := webhook.First(&testInteraction{FullId: id})
+		require.Nil(t, err, "could not check first interaction")
+		require.True(t, first, "could not get first interaction")
+	}
+	require.Eventually(t, func() bool {
+		stats := &cache.Stats{}
+		webhook.seen.Stats(stats)
+		return stats.EvictionCount == 1
+	}, 5*time.Second, 10*time.Millisecond, "could not forget least recently seen key")
+	first, err := webhook.First(&testInteraction{FullId: "a"})
+	require.Nil(t, err, "could not check first interaction")
+	require.True(t, first, "could not alert again on forgotten key")
+}
+
+func TestWebhookInherit(t *testing.T) {
+	previous, err := New(&Options{URL: "http://127.0.0.1", FirstOnly: "payload"})
+	require.Nil(t, err, "could not create webhook")
+	first, err := previous.First(&testInteraction{FullId: "a"})
+	require.Nil(t, err, "could not check first interaction")
+	require.True(t, first, "could not get first interaction")
+
+	changed, err := New(&Options{URL: "http://127.0.0.1", FirstOnly: "protocol"})
+	require.Nil(t, err, "could not create webhook")
+	defer changed.Close()
+	require.False(t, changed.Inherit(previous), "inherited keys of other configuration")
+
+	webhook, err := New(&Options{URL: "http://127.0.0.1", FirstOnly: "payload"})
+	require.Nil(t, err, "could not create webhook")
+	defer webhook.Close()
+	require.True(t, webhook.Inherit(previous), "could not inherit keys of same configuration")
+	previous.Close()
+	first, err = webhook.First(&testInteraction{FullId: "a"})
+	require.Nil(t, err, "could not check first interaction")
+	require.False(t, first, "alerted again on inherited key")
+}
+
+func TestWebhookCloseDrains(t *testing.T) {
+	received := make(chan string, 3)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer ts.Close()
+
+	webhook, err := New(&Options{URL: ts.URL, Template: "{{.Protocol}}"})
+	require.Nil(t, err, "could not create webhook")
+	for _, protocol := range []string{"dns", "http", "smtp"} {
+		require.Nil(t, webhook.Send(&testInteraction{Protocol: protocol}), "could not send interaction")
+	}
+	webhook.Close()
+	require.Len(t, received, 3, "could not wait for the delivery of queued interactions")
+	require.Nil(t, webhook.Send(&testInteraction{Protocol: "ldap"}), "could not discard interaction of closed webhook")
+	for _, protocol := range []string{"dns", "http", "smtp"} {
+		require.Equal(t, protocol, <-received, "could not deliver queued interaction")
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	attempts := make(chan int, 10)
+	count := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		attempts <- count
+		switch count {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 3:
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	webhook, err := New(&Options{URL: ts.URL, Retries: 3})
+	require.Nil(t, err, "could not create webhook")
+	defer webhook.Close()
+	webhook.retryWait = 10 * time.Millisecond
+	delivered := make(chan time.Duration, 2)
+	webhook.SetDeliveryObserver(func(lag time.Duration) { delivered <- lag })
+
+	require.Nil(t, webhook.Send(&testInteraction{Protocol: "http"}), "could not send interaction")
+	select {
+	case lag := <-delivered:
+		require.True(t, lag >= time.Second, "could not wait for retry-after")
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "webhook was not retried")
+	}
+	require.Len(t, attempts, 3, "could not retry failed deliveries")
+
+	require.NotNil(t, webhook.send([]byte("{}")), "delivered rejected body")
+	require.Len(t, attempts, 4, "retried client error")
+}